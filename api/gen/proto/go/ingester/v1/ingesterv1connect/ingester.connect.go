@@ -48,6 +48,15 @@ const (
 	IngesterServiceProfileTypesProcedure = "/ingester.v1.IngesterService/ProfileTypes"
 	// IngesterServiceSeriesProcedure is the fully-qualified name of the IngesterService's Series RPC.
 	IngesterServiceSeriesProcedure = "/ingester.v1.IngesterService/Series"
+	// IngesterServiceLabelCardinalityProcedure is the fully-qualified name of the IngesterService's
+	// LabelCardinality RPC.
+	IngesterServiceLabelCardinalityProcedure = "/ingester.v1.IngesterService/LabelCardinality"
+	// IngesterServiceSelectProfilesMetadataProcedure is the fully-qualified name of the
+	// IngesterService's SelectProfilesMetadata RPC.
+	IngesterServiceSelectProfilesMetadataProcedure = "/ingester.v1.IngesterService/SelectProfilesMetadata"
+	// IngesterServiceSelectExemplarsProcedure is the fully-qualified name of the IngesterService's
+	// SelectExemplars RPC.
+	IngesterServiceSelectExemplarsProcedure = "/ingester.v1.IngesterService/SelectExemplars"
 	// IngesterServiceFlushProcedure is the fully-qualified name of the IngesterService's Flush RPC.
 	IngesterServiceFlushProcedure = "/ingester.v1.IngesterService/Flush"
 	// IngesterServiceMergeProfilesStacktracesProcedure is the fully-qualified name of the
@@ -68,6 +77,9 @@ type IngesterServiceClient interface {
 	LabelNames(context.Context, *connect_go.Request[v11.LabelNamesRequest]) (*connect_go.Response[v11.LabelNamesResponse], error)
 	ProfileTypes(context.Context, *connect_go.Request[v12.ProfileTypesRequest]) (*connect_go.Response[v12.ProfileTypesResponse], error)
 	Series(context.Context, *connect_go.Request[v12.SeriesRequest]) (*connect_go.Response[v12.SeriesResponse], error)
+	LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error)
+	SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error)
+	SelectExemplars(context.Context, *connect_go.Request[v12.SelectExemplarsRequest]) (*connect_go.Response[v12.SelectExemplarsResponse], error)
 	Flush(context.Context, *connect_go.Request[v12.FlushRequest]) (*connect_go.Response[v12.FlushResponse], error)
 	MergeProfilesStacktraces(context.Context) *connect_go.BidiStreamForClient[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]
 	MergeProfilesLabels(context.Context) *connect_go.BidiStreamForClient[v12.MergeProfilesLabelsRequest, v12.MergeProfilesLabelsResponse]
@@ -109,6 +121,21 @@ func NewIngesterServiceClient(httpClient connect_go.HTTPClient, baseURL string,
 			baseURL+IngesterServiceSeriesProcedure,
 			opts...,
 		),
+		labelCardinality: connect_go.NewClient[v11.LabelCardinalityRequest, v11.LabelCardinalityResponse](
+			httpClient,
+			baseURL+IngesterServiceLabelCardinalityProcedure,
+			opts...,
+		),
+		selectProfilesMetadata: connect_go.NewClient[v11.SelectProfilesMetadataRequest, v11.SelectProfilesMetadataResponse](
+			httpClient,
+			baseURL+IngesterServiceSelectProfilesMetadataProcedure,
+			opts...,
+		),
+		selectExemplars: connect_go.NewClient[v12.SelectExemplarsRequest, v12.SelectExemplarsResponse](
+			httpClient,
+			baseURL+IngesterServiceSelectExemplarsProcedure,
+			opts...,
+		),
 		flush: connect_go.NewClient[v12.FlushRequest, v12.FlushResponse](
 			httpClient,
 			baseURL+IngesterServiceFlushProcedure,
@@ -139,6 +166,9 @@ type ingesterServiceClient struct {
 	labelNames               *connect_go.Client[v11.LabelNamesRequest, v11.LabelNamesResponse]
 	profileTypes             *connect_go.Client[v12.ProfileTypesRequest, v12.ProfileTypesResponse]
 	series                   *connect_go.Client[v12.SeriesRequest, v12.SeriesResponse]
+	labelCardinality         *connect_go.Client[v11.LabelCardinalityRequest, v11.LabelCardinalityResponse]
+	selectProfilesMetadata   *connect_go.Client[v11.SelectProfilesMetadataRequest, v11.SelectProfilesMetadataResponse]
+	selectExemplars          *connect_go.Client[v12.SelectExemplarsRequest, v12.SelectExemplarsResponse]
 	flush                    *connect_go.Client[v12.FlushRequest, v12.FlushResponse]
 	mergeProfilesStacktraces *connect_go.Client[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]
 	mergeProfilesLabels      *connect_go.Client[v12.MergeProfilesLabelsRequest, v12.MergeProfilesLabelsResponse]
@@ -170,6 +200,21 @@ func (c *ingesterServiceClient) Series(ctx context.Context, req *connect_go.Requ
 	return c.series.CallUnary(ctx, req)
 }
 
+// LabelCardinality calls ingester.v1.IngesterService.LabelCardinality.
+func (c *ingesterServiceClient) LabelCardinality(ctx context.Context, req *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error) {
+	return c.labelCardinality.CallUnary(ctx, req)
+}
+
+// SelectProfilesMetadata calls ingester.v1.IngesterService.SelectProfilesMetadata.
+func (c *ingesterServiceClient) SelectProfilesMetadata(ctx context.Context, req *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error) {
+	return c.selectProfilesMetadata.CallUnary(ctx, req)
+}
+
+// SelectExemplars calls ingester.v1.IngesterService.SelectExemplars.
+func (c *ingesterServiceClient) SelectExemplars(ctx context.Context, req *connect_go.Request[v12.SelectExemplarsRequest]) (*connect_go.Response[v12.SelectExemplarsResponse], error) {
+	return c.selectExemplars.CallUnary(ctx, req)
+}
+
 // Flush calls ingester.v1.IngesterService.Flush.
 func (c *ingesterServiceClient) Flush(ctx context.Context, req *connect_go.Request[v12.FlushRequest]) (*connect_go.Response[v12.FlushResponse], error) {
 	return c.flush.CallUnary(ctx, req)
@@ -197,6 +242,9 @@ type IngesterServiceHandler interface {
 	LabelNames(context.Context, *connect_go.Request[v11.LabelNamesRequest]) (*connect_go.Response[v11.LabelNamesResponse], error)
 	ProfileTypes(context.Context, *connect_go.Request[v12.ProfileTypesRequest]) (*connect_go.Response[v12.ProfileTypesResponse], error)
 	Series(context.Context, *connect_go.Request[v12.SeriesRequest]) (*connect_go.Response[v12.SeriesResponse], error)
+	LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error)
+	SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error)
+	SelectExemplars(context.Context, *connect_go.Request[v12.SelectExemplarsRequest]) (*connect_go.Response[v12.SelectExemplarsResponse], error)
 	Flush(context.Context, *connect_go.Request[v12.FlushRequest]) (*connect_go.Response[v12.FlushResponse], error)
 	MergeProfilesStacktraces(context.Context, *connect_go.BidiStream[v12.MergeProfilesStacktracesRequest, v12.MergeProfilesStacktracesResponse]) error
 	MergeProfilesLabels(context.Context, *connect_go.BidiStream[v12.MergeProfilesLabelsRequest, v12.MergeProfilesLabelsResponse]) error
@@ -234,6 +282,21 @@ func NewIngesterServiceHandler(svc IngesterServiceHandler, opts ...connect_go.Ha
 		svc.Series,
 		opts...,
 	)
+	ingesterServiceLabelCardinalityHandler := connect_go.NewUnaryHandler(
+		IngesterServiceLabelCardinalityProcedure,
+		svc.LabelCardinality,
+		opts...,
+	)
+	ingesterServiceSelectProfilesMetadataHandler := connect_go.NewUnaryHandler(
+		IngesterServiceSelectProfilesMetadataProcedure,
+		svc.SelectProfilesMetadata,
+		opts...,
+	)
+	ingesterServiceSelectExemplarsHandler := connect_go.NewUnaryHandler(
+		IngesterServiceSelectExemplarsProcedure,
+		svc.SelectExemplars,
+		opts...,
+	)
 	ingesterServiceFlushHandler := connect_go.NewUnaryHandler(
 		IngesterServiceFlushProcedure,
 		svc.Flush,
@@ -266,6 +329,12 @@ func NewIngesterServiceHandler(svc IngesterServiceHandler, opts ...connect_go.Ha
 			ingesterServiceProfileTypesHandler.ServeHTTP(w, r)
 		case IngesterServiceSeriesProcedure:
 			ingesterServiceSeriesHandler.ServeHTTP(w, r)
+		case IngesterServiceLabelCardinalityProcedure:
+			ingesterServiceLabelCardinalityHandler.ServeHTTP(w, r)
+		case IngesterServiceSelectProfilesMetadataProcedure:
+			ingesterServiceSelectProfilesMetadataHandler.ServeHTTP(w, r)
+		case IngesterServiceSelectExemplarsProcedure:
+			ingesterServiceSelectExemplarsHandler.ServeHTTP(w, r)
 		case IngesterServiceFlushProcedure:
 			ingesterServiceFlushHandler.ServeHTTP(w, r)
 		case IngesterServiceMergeProfilesStacktracesProcedure:
@@ -303,6 +372,18 @@ func (UnimplementedIngesterServiceHandler) Series(context.Context, *connect_go.R
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.Series is not implemented"))
 }
 
+func (UnimplementedIngesterServiceHandler) LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.LabelCardinality is not implemented"))
+}
+
+func (UnimplementedIngesterServiceHandler) SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.SelectProfilesMetadata is not implemented"))
+}
+
+func (UnimplementedIngesterServiceHandler) SelectExemplars(context.Context, *connect_go.Request[v12.SelectExemplarsRequest]) (*connect_go.Response[v12.SelectExemplarsResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.SelectExemplars is not implemented"))
+}
+
 func (UnimplementedIngesterServiceHandler) Flush(context.Context, *connect_go.Request[v12.FlushRequest]) (*connect_go.Response[v12.FlushResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("ingester.v1.IngesterService.Flush is not implemented"))
 }
@@ -44,6 +44,21 @@ func RegisterIngesterServiceHandler(mux *mux.Router, svc IngesterServiceHandler,
 		svc.Series,
 		opts...,
 	))
+	mux.Handle("/ingester.v1.IngesterService/LabelCardinality", connect_go.NewUnaryHandler(
+		"/ingester.v1.IngesterService/LabelCardinality",
+		svc.LabelCardinality,
+		opts...,
+	))
+	mux.Handle("/ingester.v1.IngesterService/SelectProfilesMetadata", connect_go.NewUnaryHandler(
+		"/ingester.v1.IngesterService/SelectProfilesMetadata",
+		svc.SelectProfilesMetadata,
+		opts...,
+	))
+	mux.Handle("/ingester.v1.IngesterService/SelectExemplars", connect_go.NewUnaryHandler(
+		"/ingester.v1.IngesterService/SelectExemplars",
+		svc.SelectExemplars,
+		opts...,
+	))
 	mux.Handle("/ingester.v1.IngesterService/Flush", connect_go.NewUnaryHandler(
 		"/ingester.v1.IngesterService/Flush",
 		svc.Flush,
@@ -46,12 +46,24 @@ const (
 	QuerierServiceLabelNamesProcedure = "/querier.v1.QuerierService/LabelNames"
 	// QuerierServiceSeriesProcedure is the fully-qualified name of the QuerierService's Series RPC.
 	QuerierServiceSeriesProcedure = "/querier.v1.QuerierService/Series"
+	// QuerierServiceLabelCardinalityProcedure is the fully-qualified name of the QuerierService's
+	// LabelCardinality RPC.
+	QuerierServiceLabelCardinalityProcedure = "/querier.v1.QuerierService/LabelCardinality"
+	// QuerierServiceSelectProfilesMetadataProcedure is the fully-qualified name of the QuerierService's
+	// SelectProfilesMetadata RPC.
+	QuerierServiceSelectProfilesMetadataProcedure = "/querier.v1.QuerierService/SelectProfilesMetadata"
+	// QuerierServiceSelectExemplarsProcedure is the fully-qualified name of the QuerierService's
+	// SelectExemplars RPC.
+	QuerierServiceSelectExemplarsProcedure = "/querier.v1.QuerierService/SelectExemplars"
 	// QuerierServiceSelectMergeStacktracesProcedure is the fully-qualified name of the QuerierService's
 	// SelectMergeStacktraces RPC.
 	QuerierServiceSelectMergeStacktracesProcedure = "/querier.v1.QuerierService/SelectMergeStacktraces"
 	// QuerierServiceSelectMergeProfileProcedure is the fully-qualified name of the QuerierService's
 	// SelectMergeProfile RPC.
 	QuerierServiceSelectMergeProfileProcedure = "/querier.v1.QuerierService/SelectMergeProfile"
+	// QuerierServiceSelectMergeProfileStreamProcedure is the fully-qualified name of the
+	// QuerierService's SelectMergeProfileStream RPC.
+	QuerierServiceSelectMergeProfileStreamProcedure = "/querier.v1.QuerierService/SelectMergeProfileStream"
 	// QuerierServiceSelectSeriesProcedure is the fully-qualified name of the QuerierService's
 	// SelectSeries RPC.
 	QuerierServiceSelectSeriesProcedure = "/querier.v1.QuerierService/SelectSeries"
@@ -65,8 +77,20 @@ type QuerierServiceClient interface {
 	LabelValues(context.Context, *connect_go.Request[v11.LabelValuesRequest]) (*connect_go.Response[v11.LabelValuesResponse], error)
 	LabelNames(context.Context, *connect_go.Request[v11.LabelNamesRequest]) (*connect_go.Response[v11.LabelNamesResponse], error)
 	Series(context.Context, *connect_go.Request[v1.SeriesRequest]) (*connect_go.Response[v1.SeriesResponse], error)
+	LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error)
+	// SelectProfilesMetadata lists the profiles matching the selector and
+	// the metadata predicates, without their samples.
+	SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error)
+	// SelectExemplars lists the exemplars, i.e. the samples of the profiles
+	// that belong to a span, matching the selector, along with the heatmap
+	// of their values over time.
+	SelectExemplars(context.Context, *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error)
 	SelectMergeStacktraces(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.Response[v1.SelectMergeStacktracesResponse], error)
 	SelectMergeProfile(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.Response[v12.Profile], error)
+	// SelectMergeProfileStream is like SelectMergeProfile, but the profile is
+	// sent in bounded chunks, as the partial results of the merge are received:
+	// the merged profile is never built in memory.
+	SelectMergeProfileStream(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.ServerStreamForClient[v1.SelectMergeProfileStreamResponse], error)
 	SelectSeries(context.Context, *connect_go.Request[v1.SelectSeriesRequest]) (*connect_go.Response[v1.SelectSeriesResponse], error)
 	Diff(context.Context, *connect_go.Request[v1.DiffRequest]) (*connect_go.Response[v1.DiffResponse], error)
 }
@@ -101,6 +125,21 @@ func NewQuerierServiceClient(httpClient connect_go.HTTPClient, baseURL string, o
 			baseURL+QuerierServiceSeriesProcedure,
 			opts...,
 		),
+		labelCardinality: connect_go.NewClient[v11.LabelCardinalityRequest, v11.LabelCardinalityResponse](
+			httpClient,
+			baseURL+QuerierServiceLabelCardinalityProcedure,
+			opts...,
+		),
+		selectProfilesMetadata: connect_go.NewClient[v11.SelectProfilesMetadataRequest, v11.SelectProfilesMetadataResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectProfilesMetadataProcedure,
+			opts...,
+		),
+		selectExemplars: connect_go.NewClient[v1.SelectExemplarsRequest, v1.SelectExemplarsResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectExemplarsProcedure,
+			opts...,
+		),
 		selectMergeStacktraces: connect_go.NewClient[v1.SelectMergeStacktracesRequest, v1.SelectMergeStacktracesResponse](
 			httpClient,
 			baseURL+QuerierServiceSelectMergeStacktracesProcedure,
@@ -111,6 +150,11 @@ func NewQuerierServiceClient(httpClient connect_go.HTTPClient, baseURL string, o
 			baseURL+QuerierServiceSelectMergeProfileProcedure,
 			opts...,
 		),
+		selectMergeProfileStream: connect_go.NewClient[v1.SelectMergeProfileRequest, v1.SelectMergeProfileStreamResponse](
+			httpClient,
+			baseURL+QuerierServiceSelectMergeProfileStreamProcedure,
+			opts...,
+		),
 		selectSeries: connect_go.NewClient[v1.SelectSeriesRequest, v1.SelectSeriesResponse](
 			httpClient,
 			baseURL+QuerierServiceSelectSeriesProcedure,
@@ -126,14 +170,18 @@ func NewQuerierServiceClient(httpClient connect_go.HTTPClient, baseURL string, o
 
 // querierServiceClient implements QuerierServiceClient.
 type querierServiceClient struct {
-	profileTypes           *connect_go.Client[v1.ProfileTypesRequest, v1.ProfileTypesResponse]
-	labelValues            *connect_go.Client[v11.LabelValuesRequest, v11.LabelValuesResponse]
-	labelNames             *connect_go.Client[v11.LabelNamesRequest, v11.LabelNamesResponse]
-	series                 *connect_go.Client[v1.SeriesRequest, v1.SeriesResponse]
-	selectMergeStacktraces *connect_go.Client[v1.SelectMergeStacktracesRequest, v1.SelectMergeStacktracesResponse]
-	selectMergeProfile     *connect_go.Client[v1.SelectMergeProfileRequest, v12.Profile]
-	selectSeries           *connect_go.Client[v1.SelectSeriesRequest, v1.SelectSeriesResponse]
-	diff                   *connect_go.Client[v1.DiffRequest, v1.DiffResponse]
+	profileTypes             *connect_go.Client[v1.ProfileTypesRequest, v1.ProfileTypesResponse]
+	labelValues              *connect_go.Client[v11.LabelValuesRequest, v11.LabelValuesResponse]
+	labelNames               *connect_go.Client[v11.LabelNamesRequest, v11.LabelNamesResponse]
+	series                   *connect_go.Client[v1.SeriesRequest, v1.SeriesResponse]
+	labelCardinality         *connect_go.Client[v11.LabelCardinalityRequest, v11.LabelCardinalityResponse]
+	selectProfilesMetadata   *connect_go.Client[v11.SelectProfilesMetadataRequest, v11.SelectProfilesMetadataResponse]
+	selectExemplars          *connect_go.Client[v1.SelectExemplarsRequest, v1.SelectExemplarsResponse]
+	selectMergeStacktraces   *connect_go.Client[v1.SelectMergeStacktracesRequest, v1.SelectMergeStacktracesResponse]
+	selectMergeProfile       *connect_go.Client[v1.SelectMergeProfileRequest, v12.Profile]
+	selectMergeProfileStream *connect_go.Client[v1.SelectMergeProfileRequest, v1.SelectMergeProfileStreamResponse]
+	selectSeries             *connect_go.Client[v1.SelectSeriesRequest, v1.SelectSeriesResponse]
+	diff                     *connect_go.Client[v1.DiffRequest, v1.DiffResponse]
 }
 
 // ProfileTypes calls querier.v1.QuerierService.ProfileTypes.
@@ -156,6 +204,21 @@ func (c *querierServiceClient) Series(ctx context.Context, req *connect_go.Reque
 	return c.series.CallUnary(ctx, req)
 }
 
+// LabelCardinality calls querier.v1.QuerierService.LabelCardinality.
+func (c *querierServiceClient) LabelCardinality(ctx context.Context, req *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error) {
+	return c.labelCardinality.CallUnary(ctx, req)
+}
+
+// SelectProfilesMetadata calls querier.v1.QuerierService.SelectProfilesMetadata.
+func (c *querierServiceClient) SelectProfilesMetadata(ctx context.Context, req *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error) {
+	return c.selectProfilesMetadata.CallUnary(ctx, req)
+}
+
+// SelectExemplars calls querier.v1.QuerierService.SelectExemplars.
+func (c *querierServiceClient) SelectExemplars(ctx context.Context, req *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error) {
+	return c.selectExemplars.CallUnary(ctx, req)
+}
+
 // SelectMergeStacktraces calls querier.v1.QuerierService.SelectMergeStacktraces.
 func (c *querierServiceClient) SelectMergeStacktraces(ctx context.Context, req *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.Response[v1.SelectMergeStacktracesResponse], error) {
 	return c.selectMergeStacktraces.CallUnary(ctx, req)
@@ -166,6 +229,11 @@ func (c *querierServiceClient) SelectMergeProfile(ctx context.Context, req *conn
 	return c.selectMergeProfile.CallUnary(ctx, req)
 }
 
+// SelectMergeProfileStream calls querier.v1.QuerierService.SelectMergeProfileStream.
+func (c *querierServiceClient) SelectMergeProfileStream(ctx context.Context, req *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.ServerStreamForClient[v1.SelectMergeProfileStreamResponse], error) {
+	return c.selectMergeProfileStream.CallServerStream(ctx, req)
+}
+
 // SelectSeries calls querier.v1.QuerierService.SelectSeries.
 func (c *querierServiceClient) SelectSeries(ctx context.Context, req *connect_go.Request[v1.SelectSeriesRequest]) (*connect_go.Response[v1.SelectSeriesResponse], error) {
 	return c.selectSeries.CallUnary(ctx, req)
@@ -182,8 +250,20 @@ type QuerierServiceHandler interface {
 	LabelValues(context.Context, *connect_go.Request[v11.LabelValuesRequest]) (*connect_go.Response[v11.LabelValuesResponse], error)
 	LabelNames(context.Context, *connect_go.Request[v11.LabelNamesRequest]) (*connect_go.Response[v11.LabelNamesResponse], error)
 	Series(context.Context, *connect_go.Request[v1.SeriesRequest]) (*connect_go.Response[v1.SeriesResponse], error)
+	LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error)
+	// SelectProfilesMetadata lists the profiles matching the selector and
+	// the metadata predicates, without their samples.
+	SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error)
+	// SelectExemplars lists the exemplars, i.e. the samples of the profiles
+	// that belong to a span, matching the selector, along with the heatmap
+	// of their values over time.
+	SelectExemplars(context.Context, *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error)
 	SelectMergeStacktraces(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.Response[v1.SelectMergeStacktracesResponse], error)
 	SelectMergeProfile(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest]) (*connect_go.Response[v12.Profile], error)
+	// SelectMergeProfileStream is like SelectMergeProfile, but the profile is
+	// sent in bounded chunks, as the partial results of the merge are received:
+	// the merged profile is never built in memory.
+	SelectMergeProfileStream(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest], *connect_go.ServerStream[v1.SelectMergeProfileStreamResponse]) error
 	SelectSeries(context.Context, *connect_go.Request[v1.SelectSeriesRequest]) (*connect_go.Response[v1.SelectSeriesResponse], error)
 	Diff(context.Context, *connect_go.Request[v1.DiffRequest]) (*connect_go.Response[v1.DiffResponse], error)
 }
@@ -214,6 +294,21 @@ func NewQuerierServiceHandler(svc QuerierServiceHandler, opts ...connect_go.Hand
 		svc.Series,
 		opts...,
 	)
+	querierServiceLabelCardinalityHandler := connect_go.NewUnaryHandler(
+		QuerierServiceLabelCardinalityProcedure,
+		svc.LabelCardinality,
+		opts...,
+	)
+	querierServiceSelectProfilesMetadataHandler := connect_go.NewUnaryHandler(
+		QuerierServiceSelectProfilesMetadataProcedure,
+		svc.SelectProfilesMetadata,
+		opts...,
+	)
+	querierServiceSelectExemplarsHandler := connect_go.NewUnaryHandler(
+		QuerierServiceSelectExemplarsProcedure,
+		svc.SelectExemplars,
+		opts...,
+	)
 	querierServiceSelectMergeStacktracesHandler := connect_go.NewUnaryHandler(
 		QuerierServiceSelectMergeStacktracesProcedure,
 		svc.SelectMergeStacktraces,
@@ -224,6 +319,11 @@ func NewQuerierServiceHandler(svc QuerierServiceHandler, opts ...connect_go.Hand
 		svc.SelectMergeProfile,
 		opts...,
 	)
+	querierServiceSelectMergeProfileStreamHandler := connect_go.NewServerStreamHandler(
+		QuerierServiceSelectMergeProfileStreamProcedure,
+		svc.SelectMergeProfileStream,
+		opts...,
+	)
 	querierServiceSelectSeriesHandler := connect_go.NewUnaryHandler(
 		QuerierServiceSelectSeriesProcedure,
 		svc.SelectSeries,
@@ -244,10 +344,18 @@ func NewQuerierServiceHandler(svc QuerierServiceHandler, opts ...connect_go.Hand
 			querierServiceLabelNamesHandler.ServeHTTP(w, r)
 		case QuerierServiceSeriesProcedure:
 			querierServiceSeriesHandler.ServeHTTP(w, r)
+		case QuerierServiceLabelCardinalityProcedure:
+			querierServiceLabelCardinalityHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectProfilesMetadataProcedure:
+			querierServiceSelectProfilesMetadataHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectExemplarsProcedure:
+			querierServiceSelectExemplarsHandler.ServeHTTP(w, r)
 		case QuerierServiceSelectMergeStacktracesProcedure:
 			querierServiceSelectMergeStacktracesHandler.ServeHTTP(w, r)
 		case QuerierServiceSelectMergeProfileProcedure:
 			querierServiceSelectMergeProfileHandler.ServeHTTP(w, r)
+		case QuerierServiceSelectMergeProfileStreamProcedure:
+			querierServiceSelectMergeProfileStreamHandler.ServeHTTP(w, r)
 		case QuerierServiceSelectSeriesProcedure:
 			querierServiceSelectSeriesHandler.ServeHTTP(w, r)
 		case QuerierServiceDiffProcedure:
@@ -277,6 +385,18 @@ func (UnimplementedQuerierServiceHandler) Series(context.Context, *connect_go.Re
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.Series is not implemented"))
 }
 
+func (UnimplementedQuerierServiceHandler) LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.LabelCardinality is not implemented"))
+}
+
+func (UnimplementedQuerierServiceHandler) SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectProfilesMetadata is not implemented"))
+}
+
+func (UnimplementedQuerierServiceHandler) SelectExemplars(context.Context, *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectExemplars is not implemented"))
+}
+
 func (UnimplementedQuerierServiceHandler) SelectMergeStacktraces(context.Context, *connect_go.Request[v1.SelectMergeStacktracesRequest]) (*connect_go.Response[v1.SelectMergeStacktracesResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectMergeStacktraces is not implemented"))
 }
@@ -285,6 +405,10 @@ func (UnimplementedQuerierServiceHandler) SelectMergeProfile(context.Context, *c
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectMergeProfile is not implemented"))
 }
 
+func (UnimplementedQuerierServiceHandler) SelectMergeProfileStream(context.Context, *connect_go.Request[v1.SelectMergeProfileRequest], *connect_go.ServerStream[v1.SelectMergeProfileStreamResponse]) error {
+	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectMergeProfileStream is not implemented"))
+}
+
 func (UnimplementedQuerierServiceHandler) SelectSeries(context.Context, *connect_go.Request[v1.SelectSeriesRequest]) (*connect_go.Response[v1.SelectSeriesResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("querier.v1.QuerierService.SelectSeries is not implemented"))
 }
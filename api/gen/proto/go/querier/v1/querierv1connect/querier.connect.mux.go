@@ -39,6 +39,21 @@ func RegisterQuerierServiceHandler(mux *mux.Router, svc QuerierServiceHandler, o
 		svc.Series,
 		opts...,
 	))
+	mux.Handle("/querier.v1.QuerierService/LabelCardinality", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/LabelCardinality",
+		svc.LabelCardinality,
+		opts...,
+	))
+	mux.Handle("/querier.v1.QuerierService/SelectProfilesMetadata", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/SelectProfilesMetadata",
+		svc.SelectProfilesMetadata,
+		opts...,
+	))
+	mux.Handle("/querier.v1.QuerierService/SelectExemplars", connect_go.NewUnaryHandler(
+		"/querier.v1.QuerierService/SelectExemplars",
+		svc.SelectExemplars,
+		opts...,
+	))
 	mux.Handle("/querier.v1.QuerierService/SelectMergeStacktraces", connect_go.NewUnaryHandler(
 		"/querier.v1.QuerierService/SelectMergeStacktraces",
 		svc.SelectMergeStacktraces,
@@ -49,6 +64,11 @@ func RegisterQuerierServiceHandler(mux *mux.Router, svc QuerierServiceHandler, o
 		svc.SelectMergeProfile,
 		opts...,
 	))
+	mux.Handle("/querier.v1.QuerierService/SelectMergeProfileStream", connect_go.NewServerStreamHandler(
+		"/querier.v1.QuerierService/SelectMergeProfileStream",
+		svc.SelectMergeProfileStream,
+		opts...,
+	))
 	mux.Handle("/querier.v1.QuerierService/SelectSeries", connect_go.NewUnaryHandler(
 		"/querier.v1.QuerierService/SelectSeries",
 		svc.SelectSeries,
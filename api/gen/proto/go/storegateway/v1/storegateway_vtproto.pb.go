@@ -6,7 +6,8 @@ package storegatewayv1
 
 import (
 	context "context"
-	v1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	v11 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	v1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -32,6 +33,9 @@ type StoreGatewayServiceClient interface {
 	MergeProfilesStacktraces(ctx context.Context, opts ...grpc.CallOption) (StoreGatewayService_MergeProfilesStacktracesClient, error)
 	MergeProfilesLabels(ctx context.Context, opts ...grpc.CallOption) (StoreGatewayService_MergeProfilesLabelsClient, error)
 	MergeProfilesPprof(ctx context.Context, opts ...grpc.CallOption) (StoreGatewayService_MergeProfilesPprofClient, error)
+	LabelCardinality(ctx context.Context, in *v1.LabelCardinalityRequest, opts ...grpc.CallOption) (*v1.LabelCardinalityResponse, error)
+	SelectProfilesMetadata(ctx context.Context, in *v1.SelectProfilesMetadataRequest, opts ...grpc.CallOption) (*v1.SelectProfilesMetadataResponse, error)
+	SelectExemplars(ctx context.Context, in *v11.SelectExemplarsRequest, opts ...grpc.CallOption) (*v11.SelectExemplarsResponse, error)
 }
 
 type storeGatewayServiceClient struct {
@@ -52,8 +56,8 @@ func (c *storeGatewayServiceClient) MergeProfilesStacktraces(ctx context.Context
 }
 
 type StoreGatewayService_MergeProfilesStacktracesClient interface {
-	Send(*v1.MergeProfilesStacktracesRequest) error
-	Recv() (*v1.MergeProfilesStacktracesResponse, error)
+	Send(*v11.MergeProfilesStacktracesRequest) error
+	Recv() (*v11.MergeProfilesStacktracesResponse, error)
 	grpc.ClientStream
 }
 
@@ -61,12 +65,12 @@ type storeGatewayServiceMergeProfilesStacktracesClient struct {
 	grpc.ClientStream
 }
 
-func (x *storeGatewayServiceMergeProfilesStacktracesClient) Send(m *v1.MergeProfilesStacktracesRequest) error {
+func (x *storeGatewayServiceMergeProfilesStacktracesClient) Send(m *v11.MergeProfilesStacktracesRequest) error {
 	return x.ClientStream.SendMsg(m)
 }
 
-func (x *storeGatewayServiceMergeProfilesStacktracesClient) Recv() (*v1.MergeProfilesStacktracesResponse, error) {
-	m := new(v1.MergeProfilesStacktracesResponse)
+func (x *storeGatewayServiceMergeProfilesStacktracesClient) Recv() (*v11.MergeProfilesStacktracesResponse, error) {
+	m := new(v11.MergeProfilesStacktracesResponse)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -83,8 +87,8 @@ func (c *storeGatewayServiceClient) MergeProfilesLabels(ctx context.Context, opt
 }
 
 type StoreGatewayService_MergeProfilesLabelsClient interface {
-	Send(*v1.MergeProfilesLabelsRequest) error
-	Recv() (*v1.MergeProfilesLabelsResponse, error)
+	Send(*v11.MergeProfilesLabelsRequest) error
+	Recv() (*v11.MergeProfilesLabelsResponse, error)
 	grpc.ClientStream
 }
 
@@ -92,12 +96,12 @@ type storeGatewayServiceMergeProfilesLabelsClient struct {
 	grpc.ClientStream
 }
 
-func (x *storeGatewayServiceMergeProfilesLabelsClient) Send(m *v1.MergeProfilesLabelsRequest) error {
+func (x *storeGatewayServiceMergeProfilesLabelsClient) Send(m *v11.MergeProfilesLabelsRequest) error {
 	return x.ClientStream.SendMsg(m)
 }
 
-func (x *storeGatewayServiceMergeProfilesLabelsClient) Recv() (*v1.MergeProfilesLabelsResponse, error) {
-	m := new(v1.MergeProfilesLabelsResponse)
+func (x *storeGatewayServiceMergeProfilesLabelsClient) Recv() (*v11.MergeProfilesLabelsResponse, error) {
+	m := new(v11.MergeProfilesLabelsResponse)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -114,8 +118,8 @@ func (c *storeGatewayServiceClient) MergeProfilesPprof(ctx context.Context, opts
 }
 
 type StoreGatewayService_MergeProfilesPprofClient interface {
-	Send(*v1.MergeProfilesPprofRequest) error
-	Recv() (*v1.MergeProfilesPprofResponse, error)
+	Send(*v11.MergeProfilesPprofRequest) error
+	Recv() (*v11.MergeProfilesPprofResponse, error)
 	grpc.ClientStream
 }
 
@@ -123,18 +127,45 @@ type storeGatewayServiceMergeProfilesPprofClient struct {
 	grpc.ClientStream
 }
 
-func (x *storeGatewayServiceMergeProfilesPprofClient) Send(m *v1.MergeProfilesPprofRequest) error {
+func (x *storeGatewayServiceMergeProfilesPprofClient) Send(m *v11.MergeProfilesPprofRequest) error {
 	return x.ClientStream.SendMsg(m)
 }
 
-func (x *storeGatewayServiceMergeProfilesPprofClient) Recv() (*v1.MergeProfilesPprofResponse, error) {
-	m := new(v1.MergeProfilesPprofResponse)
+func (x *storeGatewayServiceMergeProfilesPprofClient) Recv() (*v11.MergeProfilesPprofResponse, error) {
+	m := new(v11.MergeProfilesPprofResponse)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
+func (c *storeGatewayServiceClient) LabelCardinality(ctx context.Context, in *v1.LabelCardinalityRequest, opts ...grpc.CallOption) (*v1.LabelCardinalityResponse, error) {
+	out := new(v1.LabelCardinalityResponse)
+	err := c.cc.Invoke(ctx, "/storegateway.v1.StoreGatewayService/LabelCardinality", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storeGatewayServiceClient) SelectProfilesMetadata(ctx context.Context, in *v1.SelectProfilesMetadataRequest, opts ...grpc.CallOption) (*v1.SelectProfilesMetadataResponse, error) {
+	out := new(v1.SelectProfilesMetadataResponse)
+	err := c.cc.Invoke(ctx, "/storegateway.v1.StoreGatewayService/SelectProfilesMetadata", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storeGatewayServiceClient) SelectExemplars(ctx context.Context, in *v11.SelectExemplarsRequest, opts ...grpc.CallOption) (*v11.SelectExemplarsResponse, error) {
+	out := new(v11.SelectExemplarsResponse)
+	err := c.cc.Invoke(ctx, "/storegateway.v1.StoreGatewayService/SelectExemplars", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StoreGatewayServiceServer is the server API for StoreGatewayService service.
 // All implementations must embed UnimplementedStoreGatewayServiceServer
 // for forward compatibility
@@ -142,6 +173,9 @@ type StoreGatewayServiceServer interface {
 	MergeProfilesStacktraces(StoreGatewayService_MergeProfilesStacktracesServer) error
 	MergeProfilesLabels(StoreGatewayService_MergeProfilesLabelsServer) error
 	MergeProfilesPprof(StoreGatewayService_MergeProfilesPprofServer) error
+	LabelCardinality(context.Context, *v1.LabelCardinalityRequest) (*v1.LabelCardinalityResponse, error)
+	SelectProfilesMetadata(context.Context, *v1.SelectProfilesMetadataRequest) (*v1.SelectProfilesMetadataResponse, error)
+	SelectExemplars(context.Context, *v11.SelectExemplarsRequest) (*v11.SelectExemplarsResponse, error)
 	mustEmbedUnimplementedStoreGatewayServiceServer()
 }
 
@@ -158,6 +192,15 @@ func (UnimplementedStoreGatewayServiceServer) MergeProfilesLabels(StoreGatewaySe
 func (UnimplementedStoreGatewayServiceServer) MergeProfilesPprof(StoreGatewayService_MergeProfilesPprofServer) error {
 	return status.Errorf(codes.Unimplemented, "method MergeProfilesPprof not implemented")
 }
+func (UnimplementedStoreGatewayServiceServer) LabelCardinality(context.Context, *v1.LabelCardinalityRequest) (*v1.LabelCardinalityResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LabelCardinality not implemented")
+}
+func (UnimplementedStoreGatewayServiceServer) SelectProfilesMetadata(context.Context, *v1.SelectProfilesMetadataRequest) (*v1.SelectProfilesMetadataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SelectProfilesMetadata not implemented")
+}
+func (UnimplementedStoreGatewayServiceServer) SelectExemplars(context.Context, *v11.SelectExemplarsRequest) (*v11.SelectExemplarsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SelectExemplars not implemented")
+}
 func (UnimplementedStoreGatewayServiceServer) mustEmbedUnimplementedStoreGatewayServiceServer() {}
 
 // UnsafeStoreGatewayServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -176,8 +219,8 @@ func _StoreGatewayService_MergeProfilesStacktraces_Handler(srv interface{}, stre
 }
 
 type StoreGatewayService_MergeProfilesStacktracesServer interface {
-	Send(*v1.MergeProfilesStacktracesResponse) error
-	Recv() (*v1.MergeProfilesStacktracesRequest, error)
+	Send(*v11.MergeProfilesStacktracesResponse) error
+	Recv() (*v11.MergeProfilesStacktracesRequest, error)
 	grpc.ServerStream
 }
 
@@ -185,12 +228,12 @@ type storeGatewayServiceMergeProfilesStacktracesServer struct {
 	grpc.ServerStream
 }
 
-func (x *storeGatewayServiceMergeProfilesStacktracesServer) Send(m *v1.MergeProfilesStacktracesResponse) error {
+func (x *storeGatewayServiceMergeProfilesStacktracesServer) Send(m *v11.MergeProfilesStacktracesResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
-func (x *storeGatewayServiceMergeProfilesStacktracesServer) Recv() (*v1.MergeProfilesStacktracesRequest, error) {
-	m := new(v1.MergeProfilesStacktracesRequest)
+func (x *storeGatewayServiceMergeProfilesStacktracesServer) Recv() (*v11.MergeProfilesStacktracesRequest, error) {
+	m := new(v11.MergeProfilesStacktracesRequest)
 	if err := x.ServerStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -202,8 +245,8 @@ func _StoreGatewayService_MergeProfilesLabels_Handler(srv interface{}, stream gr
 }
 
 type StoreGatewayService_MergeProfilesLabelsServer interface {
-	Send(*v1.MergeProfilesLabelsResponse) error
-	Recv() (*v1.MergeProfilesLabelsRequest, error)
+	Send(*v11.MergeProfilesLabelsResponse) error
+	Recv() (*v11.MergeProfilesLabelsRequest, error)
 	grpc.ServerStream
 }
 
@@ -211,12 +254,12 @@ type storeGatewayServiceMergeProfilesLabelsServer struct {
 	grpc.ServerStream
 }
 
-func (x *storeGatewayServiceMergeProfilesLabelsServer) Send(m *v1.MergeProfilesLabelsResponse) error {
+func (x *storeGatewayServiceMergeProfilesLabelsServer) Send(m *v11.MergeProfilesLabelsResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
-func (x *storeGatewayServiceMergeProfilesLabelsServer) Recv() (*v1.MergeProfilesLabelsRequest, error) {
-	m := new(v1.MergeProfilesLabelsRequest)
+func (x *storeGatewayServiceMergeProfilesLabelsServer) Recv() (*v11.MergeProfilesLabelsRequest, error) {
+	m := new(v11.MergeProfilesLabelsRequest)
 	if err := x.ServerStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -228,8 +271,8 @@ func _StoreGatewayService_MergeProfilesPprof_Handler(srv interface{}, stream grp
 }
 
 type StoreGatewayService_MergeProfilesPprofServer interface {
-	Send(*v1.MergeProfilesPprofResponse) error
-	Recv() (*v1.MergeProfilesPprofRequest, error)
+	Send(*v11.MergeProfilesPprofResponse) error
+	Recv() (*v11.MergeProfilesPprofRequest, error)
 	grpc.ServerStream
 }
 
@@ -237,25 +280,92 @@ type storeGatewayServiceMergeProfilesPprofServer struct {
 	grpc.ServerStream
 }
 
-func (x *storeGatewayServiceMergeProfilesPprofServer) Send(m *v1.MergeProfilesPprofResponse) error {
+func (x *storeGatewayServiceMergeProfilesPprofServer) Send(m *v11.MergeProfilesPprofResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
-func (x *storeGatewayServiceMergeProfilesPprofServer) Recv() (*v1.MergeProfilesPprofRequest, error) {
-	m := new(v1.MergeProfilesPprofRequest)
+func (x *storeGatewayServiceMergeProfilesPprofServer) Recv() (*v11.MergeProfilesPprofRequest, error) {
+	m := new(v11.MergeProfilesPprofRequest)
 	if err := x.ServerStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
+func _StoreGatewayService_LabelCardinality_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(v1.LabelCardinalityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreGatewayServiceServer).LabelCardinality(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storegateway.v1.StoreGatewayService/LabelCardinality",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreGatewayServiceServer).LabelCardinality(ctx, req.(*v1.LabelCardinalityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StoreGatewayService_SelectProfilesMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(v1.SelectProfilesMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreGatewayServiceServer).SelectProfilesMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storegateway.v1.StoreGatewayService/SelectProfilesMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreGatewayServiceServer).SelectProfilesMetadata(ctx, req.(*v1.SelectProfilesMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _StoreGatewayService_SelectExemplars_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(v11.SelectExemplarsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreGatewayServiceServer).SelectExemplars(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/storegateway.v1.StoreGatewayService/SelectExemplars",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreGatewayServiceServer).SelectExemplars(ctx, req.(*v11.SelectExemplarsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // StoreGatewayService_ServiceDesc is the grpc.ServiceDesc for StoreGatewayService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var StoreGatewayService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "storegateway.v1.StoreGatewayService",
 	HandlerType: (*StoreGatewayServiceServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "LabelCardinality",
+			Handler:    _StoreGatewayService_LabelCardinality_Handler,
+		},
+		{
+			MethodName: "SelectProfilesMetadata",
+			Handler:    _StoreGatewayService_SelectProfilesMetadata_Handler,
+		},
+		{
+			MethodName: "SelectExemplars",
+			Handler:    _StoreGatewayService_SelectExemplars_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "MergeProfilesStacktraces",
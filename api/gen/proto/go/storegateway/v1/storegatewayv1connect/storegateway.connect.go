@@ -10,6 +10,7 @@ import (
 	connect_go "github.com/bufbuild/connect-go"
 	v1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	_ "github.com/grafana/pyroscope/api/gen/proto/go/storegateway/v1"
+	v11 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	http "net/http"
 	strings "strings"
 )
@@ -43,6 +44,15 @@ const (
 	// StoreGatewayServiceMergeProfilesPprofProcedure is the fully-qualified name of the
 	// StoreGatewayService's MergeProfilesPprof RPC.
 	StoreGatewayServiceMergeProfilesPprofProcedure = "/storegateway.v1.StoreGatewayService/MergeProfilesPprof"
+	// StoreGatewayServiceLabelCardinalityProcedure is the fully-qualified name of the
+	// StoreGatewayService's LabelCardinality RPC.
+	StoreGatewayServiceLabelCardinalityProcedure = "/storegateway.v1.StoreGatewayService/LabelCardinality"
+	// StoreGatewayServiceSelectProfilesMetadataProcedure is the fully-qualified name of the
+	// StoreGatewayService's SelectProfilesMetadata RPC.
+	StoreGatewayServiceSelectProfilesMetadataProcedure = "/storegateway.v1.StoreGatewayService/SelectProfilesMetadata"
+	// StoreGatewayServiceSelectExemplarsProcedure is the fully-qualified name of the
+	// StoreGatewayService's SelectExemplars RPC.
+	StoreGatewayServiceSelectExemplarsProcedure = "/storegateway.v1.StoreGatewayService/SelectExemplars"
 )
 
 // StoreGatewayServiceClient is a client for the storegateway.v1.StoreGatewayService service.
@@ -50,6 +60,9 @@ type StoreGatewayServiceClient interface {
 	MergeProfilesStacktraces(context.Context) *connect_go.BidiStreamForClient[v1.MergeProfilesStacktracesRequest, v1.MergeProfilesStacktracesResponse]
 	MergeProfilesLabels(context.Context) *connect_go.BidiStreamForClient[v1.MergeProfilesLabelsRequest, v1.MergeProfilesLabelsResponse]
 	MergeProfilesPprof(context.Context) *connect_go.BidiStreamForClient[v1.MergeProfilesPprofRequest, v1.MergeProfilesPprofResponse]
+	LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error)
+	SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error)
+	SelectExemplars(context.Context, *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error)
 }
 
 // NewStoreGatewayServiceClient constructs a client for the storegateway.v1.StoreGatewayService
@@ -77,6 +90,21 @@ func NewStoreGatewayServiceClient(httpClient connect_go.HTTPClient, baseURL stri
 			baseURL+StoreGatewayServiceMergeProfilesPprofProcedure,
 			opts...,
 		),
+		labelCardinality: connect_go.NewClient[v11.LabelCardinalityRequest, v11.LabelCardinalityResponse](
+			httpClient,
+			baseURL+StoreGatewayServiceLabelCardinalityProcedure,
+			opts...,
+		),
+		selectProfilesMetadata: connect_go.NewClient[v11.SelectProfilesMetadataRequest, v11.SelectProfilesMetadataResponse](
+			httpClient,
+			baseURL+StoreGatewayServiceSelectProfilesMetadataProcedure,
+			opts...,
+		),
+		selectExemplars: connect_go.NewClient[v1.SelectExemplarsRequest, v1.SelectExemplarsResponse](
+			httpClient,
+			baseURL+StoreGatewayServiceSelectExemplarsProcedure,
+			opts...,
+		),
 	}
 }
 
@@ -85,6 +113,9 @@ type storeGatewayServiceClient struct {
 	mergeProfilesStacktraces *connect_go.Client[v1.MergeProfilesStacktracesRequest, v1.MergeProfilesStacktracesResponse]
 	mergeProfilesLabels      *connect_go.Client[v1.MergeProfilesLabelsRequest, v1.MergeProfilesLabelsResponse]
 	mergeProfilesPprof       *connect_go.Client[v1.MergeProfilesPprofRequest, v1.MergeProfilesPprofResponse]
+	labelCardinality         *connect_go.Client[v11.LabelCardinalityRequest, v11.LabelCardinalityResponse]
+	selectProfilesMetadata   *connect_go.Client[v11.SelectProfilesMetadataRequest, v11.SelectProfilesMetadataResponse]
+	selectExemplars          *connect_go.Client[v1.SelectExemplarsRequest, v1.SelectExemplarsResponse]
 }
 
 // MergeProfilesStacktraces calls storegateway.v1.StoreGatewayService.MergeProfilesStacktraces.
@@ -102,12 +133,30 @@ func (c *storeGatewayServiceClient) MergeProfilesPprof(ctx context.Context) *con
 	return c.mergeProfilesPprof.CallBidiStream(ctx)
 }
 
+// LabelCardinality calls storegateway.v1.StoreGatewayService.LabelCardinality.
+func (c *storeGatewayServiceClient) LabelCardinality(ctx context.Context, req *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error) {
+	return c.labelCardinality.CallUnary(ctx, req)
+}
+
+// SelectProfilesMetadata calls storegateway.v1.StoreGatewayService.SelectProfilesMetadata.
+func (c *storeGatewayServiceClient) SelectProfilesMetadata(ctx context.Context, req *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error) {
+	return c.selectProfilesMetadata.CallUnary(ctx, req)
+}
+
+// SelectExemplars calls storegateway.v1.StoreGatewayService.SelectExemplars.
+func (c *storeGatewayServiceClient) SelectExemplars(ctx context.Context, req *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error) {
+	return c.selectExemplars.CallUnary(ctx, req)
+}
+
 // StoreGatewayServiceHandler is an implementation of the storegateway.v1.StoreGatewayService
 // service.
 type StoreGatewayServiceHandler interface {
 	MergeProfilesStacktraces(context.Context, *connect_go.BidiStream[v1.MergeProfilesStacktracesRequest, v1.MergeProfilesStacktracesResponse]) error
 	MergeProfilesLabels(context.Context, *connect_go.BidiStream[v1.MergeProfilesLabelsRequest, v1.MergeProfilesLabelsResponse]) error
 	MergeProfilesPprof(context.Context, *connect_go.BidiStream[v1.MergeProfilesPprofRequest, v1.MergeProfilesPprofResponse]) error
+	LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error)
+	SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error)
+	SelectExemplars(context.Context, *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error)
 }
 
 // NewStoreGatewayServiceHandler builds an HTTP handler from the service implementation. It returns
@@ -131,6 +180,21 @@ func NewStoreGatewayServiceHandler(svc StoreGatewayServiceHandler, opts ...conne
 		svc.MergeProfilesPprof,
 		opts...,
 	)
+	storeGatewayServiceLabelCardinalityHandler := connect_go.NewUnaryHandler(
+		StoreGatewayServiceLabelCardinalityProcedure,
+		svc.LabelCardinality,
+		opts...,
+	)
+	storeGatewayServiceSelectProfilesMetadataHandler := connect_go.NewUnaryHandler(
+		StoreGatewayServiceSelectProfilesMetadataProcedure,
+		svc.SelectProfilesMetadata,
+		opts...,
+	)
+	storeGatewayServiceSelectExemplarsHandler := connect_go.NewUnaryHandler(
+		StoreGatewayServiceSelectExemplarsProcedure,
+		svc.SelectExemplars,
+		opts...,
+	)
 	return "/storegateway.v1.StoreGatewayService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case StoreGatewayServiceMergeProfilesStacktracesProcedure:
@@ -139,6 +203,12 @@ func NewStoreGatewayServiceHandler(svc StoreGatewayServiceHandler, opts ...conne
 			storeGatewayServiceMergeProfilesLabelsHandler.ServeHTTP(w, r)
 		case StoreGatewayServiceMergeProfilesPprofProcedure:
 			storeGatewayServiceMergeProfilesPprofHandler.ServeHTTP(w, r)
+		case StoreGatewayServiceLabelCardinalityProcedure:
+			storeGatewayServiceLabelCardinalityHandler.ServeHTTP(w, r)
+		case StoreGatewayServiceSelectProfilesMetadataProcedure:
+			storeGatewayServiceSelectProfilesMetadataHandler.ServeHTTP(w, r)
+		case StoreGatewayServiceSelectExemplarsProcedure:
+			storeGatewayServiceSelectExemplarsHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -159,3 +229,15 @@ func (UnimplementedStoreGatewayServiceHandler) MergeProfilesLabels(context.Conte
 func (UnimplementedStoreGatewayServiceHandler) MergeProfilesPprof(context.Context, *connect_go.BidiStream[v1.MergeProfilesPprofRequest, v1.MergeProfilesPprofResponse]) error {
 	return connect_go.NewError(connect_go.CodeUnimplemented, errors.New("storegateway.v1.StoreGatewayService.MergeProfilesPprof is not implemented"))
 }
+
+func (UnimplementedStoreGatewayServiceHandler) LabelCardinality(context.Context, *connect_go.Request[v11.LabelCardinalityRequest]) (*connect_go.Response[v11.LabelCardinalityResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("storegateway.v1.StoreGatewayService.LabelCardinality is not implemented"))
+}
+
+func (UnimplementedStoreGatewayServiceHandler) SelectProfilesMetadata(context.Context, *connect_go.Request[v11.SelectProfilesMetadataRequest]) (*connect_go.Response[v11.SelectProfilesMetadataResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("storegateway.v1.StoreGatewayService.SelectProfilesMetadata is not implemented"))
+}
+
+func (UnimplementedStoreGatewayServiceHandler) SelectExemplars(context.Context, *connect_go.Request[v1.SelectExemplarsRequest]) (*connect_go.Response[v1.SelectExemplarsResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("storegateway.v1.StoreGatewayService.SelectExemplars is not implemented"))
+}
@@ -34,4 +34,19 @@ func RegisterStoreGatewayServiceHandler(mux *mux.Router, svc StoreGatewayService
 		svc.MergeProfilesPprof,
 		opts...,
 	))
+	mux.Handle("/storegateway.v1.StoreGatewayService/LabelCardinality", connect_go.NewUnaryHandler(
+		"/storegateway.v1.StoreGatewayService/LabelCardinality",
+		svc.LabelCardinality,
+		opts...,
+	))
+	mux.Handle("/storegateway.v1.StoreGatewayService/SelectProfilesMetadata", connect_go.NewUnaryHandler(
+		"/storegateway.v1.StoreGatewayService/SelectProfilesMetadata",
+		svc.SelectProfilesMetadata,
+		opts...,
+	))
+	mux.Handle("/storegateway.v1.StoreGatewayService/SelectExemplars", connect_go.NewUnaryHandler(
+		"/storegateway.v1.StoreGatewayService/SelectExemplars",
+		svc.SelectExemplars,
+		opts...,
+	))
 }
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/go-kit/log/level"
+	gprofile "github.com/google/pprof/profile"
+	"github.com/grafana/dskit/runutil"
+	"github.com/oklog/ulid"
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v3"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	"github.com/grafana/pyroscope/pkg/iter"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/objstore/client"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
+	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
+)
+
+// blockQuerier is implemented by the single block querier of phlaredb.
+type blockQuerier interface {
+	phlaredb.Querier
+	phlaredb.BlockReader
+	io.Closer
+}
+
+type blockParams struct {
+	ID          string
+	Query       string
+	ProfileType string
+	From        string
+	To          string
+}
+
+func addBlockParams(cmd commander) *blockParams {
+	params := &blockParams{}
+	cmd.Arg("block", "ULID of the block.").Required().StringVar(&params.ID)
+	cmd.Flag("query", "Label selector to query.").Default("{}").StringVar(&params.Query)
+	return params
+}
+
+func addBlockProfileParams(cmd commander) *blockParams {
+	params := addBlockParams(cmd)
+	cmd.Flag("profile-type", "Profile type to query.").Default("process_cpu:cpu:nanoseconds:cpu:nanoseconds").StringVar(&params.ProfileType)
+	cmd.Flag("from", "Beginning of the query, defaults to the block start.").StringVar(&params.From)
+	cmd.Flag("to", "End of the query, defaults to the block end.").StringVar(&params.To)
+	return params
+}
+
+// blocksBucket returns the bucket blocks are read from: the local --path
+// directory, or the object storage described in --bucket-config.
+func blocksBucket(ctx context.Context) (phlareobj.Bucket, error) {
+	if cfg.blocks.bucketConfig == "" {
+		return filesystem.NewBucket(cfg.blocks.path)
+	}
+	data, err := os.ReadFile(cfg.blocks.bucketConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read bucket config")
+	}
+	var bucketCfg client.Config
+	// Register the flags to populate the defaults.
+	bucketCfg.RegisterFlags(flag.NewFlagSet("", flag.PanicOnError), logger)
+	if err = yaml.Unmarshal(data, &bucketCfg); err != nil {
+		return nil, errors.Wrap(err, "failed to parse bucket config")
+	}
+	if err = bucketCfg.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid bucket config")
+	}
+	bucket, err := client.NewBucket(ctx, bucketCfg, "profilecli")
+	if err != nil {
+		return nil, err
+	}
+	if cfg.blocks.tenantID != "" {
+		bucket = phlareobj.NewPrefixedBucket(bucket, cfg.blocks.tenantID+"/phlaredb")
+	}
+	return bucket, nil
+}
+
+func downloadBlockMeta(ctx context.Context, bucket phlareobj.Bucket, id string) (*block.Meta, error) {
+	blockID, err := ulid.Parse(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse block ULID")
+	}
+	meta, err := block.DownloadMeta(ctx, logger, bucket, blockID)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func openBlock(ctx context.Context, id string) (blockQuerier, error) {
+	bucket, err := blocksBucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := downloadBlockMeta(ctx, bucket, id)
+	if err != nil {
+		return nil, err
+	}
+	q := phlaredb.NewSingleBlockQuerierFromMeta(ctx, bucket, meta)
+	if err = q.Open(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to open block")
+	}
+	return q, nil
+}
+
+func (p *blockParams) matchers() ([]*labels.Matcher, error) {
+	matchers, err := parser.ParseMetricSelector(p.Query)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse query")
+	}
+	return matchers, nil
+}
+
+// selectProfiles returns the profiles of the block matching the params.
+func (p *blockParams) selectProfiles(ctx context.Context, q blockQuerier) (iter.Iterator[phlaredb.Profile], error) {
+	profileType, err := phlaremodel.ParseProfileTypeSelector(p.ProfileType)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse profile type")
+	}
+	start, end := q.Bounds()
+	if p.From != "" {
+		from, err := parseTime(p.From)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse from")
+		}
+		start = model.TimeFromUnixNano(from.UnixNano())
+	}
+	if p.To != "" {
+		to, err := parseTime(p.To)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse to")
+		}
+		end = model.TimeFromUnixNano(to.UnixNano())
+	}
+	return q.SelectMatchingProfiles(ctx, &ingestv1.SelectProfilesRequest{
+		LabelSelector: p.Query,
+		Type:          profileType,
+		Start:         int64(start),
+		End:           int64(end),
+	})
+}
+
+func blocksQuerySeries(ctx context.Context, params *blockParams, outputFlag string) error {
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	matchers, err := params.matchers()
+	if err != nil {
+		return err
+	}
+
+	var postings index.Postings
+	if len(matchers) == 0 {
+		k, v := index.AllPostingsKey()
+		postings, err = q.Index().Postings(k, nil, v)
+	} else {
+		postings, err = phlaredb.PostingsForMatchers(q.Index(), nil, matchers...)
+	}
+	if err != nil {
+		return err
+	}
+	var (
+		lbls phlaremodel.Labels
+		chks = make([]index.ChunkMeta, 1)
+		rows [][]interface{}
+	)
+	for postings.Next() {
+		fp, err := q.Index().Series(postings.At(), &lbls, &chks)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, []interface{}{
+			fmt.Sprintf("%016x", fp),
+			phlaremodel.LabelPairsString(lbls),
+			model.TimeFromUnixNano(chks[0].MinTime).Time().Format(time.RFC3339),
+			model.TimeFromUnixNano(chks[0].MaxTime).Time().Format(time.RFC3339),
+		})
+	}
+	if err = postings.Err(); err != nil {
+		return err
+	}
+	return writeTable(ctx, outputFlag, []string{"Fingerprint", "Labels", "MinTime", "MaxTime"}, rows)
+}
+
+func blocksQueryLabelNames(ctx context.Context, params *blockParams, outputFlag string) error {
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	matchers, err := params.matchers()
+	if err != nil {
+		return err
+	}
+	names, err := q.Index().LabelNames(matchers...)
+	if err != nil {
+		return err
+	}
+	return writeStrings(ctx, outputFlag, "Name", names)
+}
+
+func blocksQueryLabelValues(ctx context.Context, params *blockParams, name string, outputFlag string) error {
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	matchers, err := params.matchers()
+	if err != nil {
+		return err
+	}
+	values, err := q.Index().SortedLabelValues(name, matchers...)
+	if err != nil {
+		return err
+	}
+	return writeStrings(ctx, outputFlag, "Value", values)
+}
+
+func blocksQueryMerge(ctx context.Context, params *blockParams, outputFlag string) (err error) {
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	profiles, err := params.selectProfiles(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	if format, filePath, ok := parseTreeOutput(outputFlag); ok {
+		if filePath == "" {
+			return errors.Errorf("no file path specified after %s=", format)
+		}
+		t, err := q.MergeByStacktraces(ctx, profiles)
+		if err != nil {
+			return errors.Wrap(err, "failed to merge profiles")
+		}
+		profileType, err := phlaremodel.ParseProfileTypeSelector(params.ProfileType)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s file", format)
+		}
+		defer runutil.CloseWithErrCapture(&err, f, "failed to close file")
+		return phlaremodel.WriteTree(f, t, format, profileType)
+	}
+
+	p, err := q.MergePprof(ctx, profiles)
+	if err != nil {
+		return errors.Wrap(err, "failed to merge profiles")
+	}
+	switch {
+	case outputFlag == outputConsole:
+		fmt.Fprintln(output(ctx), p.String())
+		return nil
+	case strings.HasPrefix(outputFlag, outputPprof):
+		filePath := strings.TrimPrefix(outputFlag, outputPprof)
+		if filePath == "" {
+			return errors.New("no file path specified after pprof=")
+		}
+		return writeProfileFile(filePath, p)
+	}
+	return errors.Errorf("unknown output %s", outputFlag)
+}
+
+func writeProfileFile(filePath string, p *gprofile.Profile) (err error) {
+	// open new file, fail when the file already exists
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create pprof file")
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "failed to close pprof file")
+
+	// Write compresses the profile with gzip.
+	if err = p.Write(f); err != nil {
+		return errors.Wrap(err, "failed to write pprof")
+	}
+	return nil
+}
+
+type blocksDumpParams struct {
+	*blockParams
+	Dir         string
+	MaxProfiles int
+}
+
+func addBlocksDumpParams(cmd commander) *blocksDumpParams {
+	params := &blocksDumpParams{blockParams: addBlockProfileParams(cmd)}
+	cmd.Flag("dir", "Directory to write the profiles to.").Default(".").StringVar(&params.Dir)
+	cmd.Flag("max-profiles", "Maximum number of profiles to dump, 0 dumps all of them.").Default("100").IntVar(&params.MaxProfiles)
+	return params
+}
+
+// blocksDump writes every profile matching the query as a separate pprof
+// file named after the series fingerprint and the profile timestamp.
+func blocksDump(ctx context.Context, params *blocksDumpParams) error {
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	it, err := params.selectProfiles(ctx, q)
+	if err != nil {
+		return err
+	}
+	profiles, err := iter.Slice(it)
+	if err != nil {
+		return err
+	}
+	if params.MaxProfiles > 0 && len(profiles) > params.MaxProfiles {
+		level.Warn(logger).Log("msg", "too many profiles, only the first ones are dumped", "profiles", len(profiles), "max_profiles", params.MaxProfiles)
+		profiles = profiles[:params.MaxProfiles]
+	}
+	if err = os.MkdirAll(params.Dir, 0o755); err != nil {
+		return err
+	}
+	for _, profile := range profiles {
+		p, err := q.MergePprof(ctx, iter.NewSliceIterator([]phlaredb.Profile{profile}))
+		if err != nil {
+			return errors.Wrap(err, "failed to read profile")
+		}
+		filePath := filepath.Join(params.Dir, fmt.Sprintf("%016x_%d.pb.gz", uint64(profile.Fingerprint()), profile.Timestamp().UnixNano()))
+		if err = writeProfileFile(filePath, p); err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "profile dumped", "path", filePath, "labels", phlaremodel.LabelPairsString(profile.Labels()))
+	}
+	return nil
+}
+
+// blocksPartitions prints the symdb statistics of every stack trace
+// partition. Blocks written with the first symdb format keep the symbols in
+// the block parquet files, therefore only stack traces are accounted for.
+func blocksPartitions(ctx context.Context, params *blockParams, outputFlag string) error {
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+	profiles, err := partitionProfiles(q.Profiles())
+	if err != nil {
+		return err
+	}
+	ids := make([]uint64, 0, len(profiles))
+	for id := range profiles {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	rows := make([][]interface{}, 0, len(ids))
+	for _, id := range ids {
+		p, err := q.Symbols().Partition(ctx, id)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load partition %d", id)
+		}
+		var stats symdb.PartitionStats
+		p.WriteStats(&stats)
+		p.Release()
+		rows = append(rows, []interface{}{
+			id,
+			profiles[id],
+			stats.StacktracesTotal,
+			stats.MaxStacktraceID,
+			stats.LocationsTotal,
+			stats.MappingsTotal,
+			stats.FunctionsTotal,
+			stats.StringsTotal,
+		})
+	}
+	return writeTable(ctx, outputFlag, []string{"Partition", "Profiles", "Stacktraces", "MaxStacktraceID", "Locations", "Mappings", "Functions", "Strings"}, rows)
+}
+
+// partitionProfiles returns the number of profiles per stack trace
+// partition. Blocks without the partition column have a single one.
+func partitionProfiles(rowGroups []parquet.RowGroup) (map[uint64]int, error) {
+	profiles := make(map[uint64]int)
+	buf := make([]parquet.Value, 1024)
+	for _, rg := range rowGroups {
+		column, ok := rg.Schema().Lookup("StacktracePartition")
+		if !ok {
+			profiles[0] += int(rg.NumRows())
+			continue
+		}
+		pages := rg.ColumnChunks()[column.ColumnIndex].Pages()
+		err := func() error {
+			defer pages.Close()
+			for {
+				page, err := pages.ReadPage()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				values := page.Values()
+				for {
+					n, err := values.ReadValues(buf)
+					for _, v := range buf[:n] {
+						profiles[v.Uint64()]++
+					}
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+type blocksCardinalityParams struct {
+	*blockParams
+	TopValues int
+}
+
+func addBlocksCardinalityParams(cmd commander) *blocksCardinalityParams {
+	params := &blocksCardinalityParams{blockParams: addBlockParams(cmd)}
+	cmd.Flag("top-values", "Number of label values with the most series to show per label name.").Default("5").IntVar(&params.TopValues)
+	return params
+}
+
+// blocksCardinality reports the number of values and series of every label
+// name, based on the TSDB index postings.
+func blocksCardinality(ctx context.Context, params *blocksCardinalityParams, outputFlag string) error {
+	if params.TopValues < 0 {
+		return fmt.Errorf("invalid --top-values %d: must not be negative", params.TopValues)
+	}
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	idx := q.Index()
+	names, err := idx.LabelNames()
+	if err != nil {
+		return err
+	}
+	type valueSeries struct {
+		value  string
+		series int
+	}
+	rows := make([][]interface{}, 0, len(names))
+	for _, name := range names {
+		values, err := idx.LabelValues(name)
+		if err != nil {
+			return err
+		}
+		counts := make([]valueSeries, 0, len(values))
+		var total int
+		for _, value := range values {
+			postings, err := idx.Postings(name, nil, value)
+			if err != nil {
+				return err
+			}
+			var n int
+			for postings.Next() {
+				n++
+			}
+			if err = postings.Err(); err != nil {
+				return err
+			}
+			counts = append(counts, valueSeries{value: value, series: n})
+			total += n
+		}
+		sort.Slice(counts, func(i, j int) bool {
+			if counts[i].series != counts[j].series {
+				return counts[i].series > counts[j].series
+			}
+			return counts[i].value < counts[j].value
+		})
+		if len(counts) > params.TopValues {
+			counts = counts[:params.TopValues]
+		}
+		top := make([]string, len(counts))
+		for i, c := range counts {
+			top[i] = fmt.Sprintf("%s=%d", c.value, c.series)
+		}
+		rows = append(rows, []interface{}{name, len(values), total, strings.Join(top, ", ")})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][1].(int) > rows[j][1].(int) })
+	return writeTable(ctx, outputFlag, []string{"Label", "Values", "Series", "TopValues"}, rows)
+}
+
+// blocksVerify checks that the block files are present with the sizes
+// recorded in meta.json, and reads them entirely: this verifies the CRC32
+// checksums of the parquet pages, of the TSDB index series entries, and of
+// the symdb index and stack trace chunks.
+func blocksVerify(ctx context.Context, params *blockParams, outputFlag string) error {
+	bucket, err := blocksBucket(ctx)
+	if err != nil {
+		return err
+	}
+	meta, err := downloadBlockMeta(ctx, bucket, params.ID)
+	if err != nil {
+		return err
+	}
+	blockBucket := phlareobj.NewPrefixedBucket(bucket, meta.ULID.String())
+
+	var (
+		rows   [][]interface{}
+		failed int
+	)
+	report := func(f block.File, err error) {
+		status := "ok"
+		if err != nil {
+			status = err.Error()
+			failed++
+		}
+		rows = append(rows, []interface{}{f.RelPath, humanize.Bytes(f.SizeBytes), status})
+	}
+	for _, f := range meta.Files {
+		attrs, err := blockBucket.Attributes(ctx, f.RelPath)
+		if err != nil {
+			report(f, err)
+			continue
+		}
+		if f.SizeBytes != 0 && uint64(attrs.Size) != f.SizeBytes {
+			report(f, fmt.Errorf("size mismatch: expected %d, got %d", f.SizeBytes, attrs.Size))
+			continue
+		}
+		switch {
+		case f.Parquet != nil:
+			report(f, verifyParquetFile(ctx, blockBucket, f, attrs.Size))
+		case f.TSDB != nil:
+			report(f, verifyTSDBIndex(ctx, bucket, meta, f))
+		case filepath.Base(f.RelPath) == symdb.IndexFileName:
+			report(f, verifySymdbIndex(ctx, blockBucket, f))
+		case filepath.Base(f.RelPath) == symdb.StacktracesFileName:
+			report(f, verifySymdbStacktraces(ctx, blockBucket, meta))
+		default:
+			report(f, nil)
+		}
+	}
+	if err = writeTable(ctx, outputFlag, []string{"File", "Size", "Status"}, rows); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed verification", failed, len(meta.Files))
+	}
+	return nil
+}
+
+func verifyParquetFile(ctx context.Context, bucket phlareobj.Bucket, f block.File, size int64) error {
+	ra, err := bucket.ReaderAt(ctx, f.RelPath)
+	if err != nil {
+		return err
+	}
+	defer ra.Close()
+	file, err := parquet.OpenFile(ra, size, parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return err
+	}
+	if n := uint64(file.NumRows()); n != f.Parquet.NumRows {
+		return fmt.Errorf("rows mismatch: expected %d, got %d", f.Parquet.NumRows, n)
+	}
+	if n := uint64(len(file.RowGroups())); n != f.Parquet.NumRowGroups {
+		return fmt.Errorf("row groups mismatch: expected %d, got %d", f.Parquet.NumRowGroups, n)
+	}
+	for _, rg := range file.RowGroups() {
+		for _, chunk := range rg.ColumnChunks() {
+			if err = readPages(chunk.Pages()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readPages(pages parquet.Pages) error {
+	defer pages.Close()
+	for {
+		_, err := pages.ReadPage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func verifyTSDBIndex(ctx context.Context, bucket phlareobj.Bucket, meta *block.Meta, f block.File) error {
+	q := phlaredb.NewSingleBlockQuerierFromMeta(ctx, bucket, meta)
+	if err := q.Open(ctx); err != nil {
+		return err
+	}
+	defer q.Close()
+	k, v := index.AllPostingsKey()
+	postings, err := q.Index().Postings(k, nil, v)
+	if err != nil {
+		return err
+	}
+	var (
+		lbls phlaremodel.Labels
+		chks = make([]index.ChunkMeta, 1)
+		n    uint64
+	)
+	for postings.Next() {
+		if _, err = q.Index().Series(postings.At(), &lbls, &chks); err != nil {
+			return err
+		}
+		n++
+	}
+	if err = postings.Err(); err != nil {
+		return err
+	}
+	if n != f.TSDB.NumSeries {
+		return fmt.Errorf("series mismatch: expected %d, got %d", f.TSDB.NumSeries, n)
+	}
+	return nil
+}
+
+func verifySymdbIndex(ctx context.Context, bucket phlareobj.Bucket, f block.File) error {
+	r, err := bucket.Get(ctx, f.RelPath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = symdb.ReadIndexFile(b)
+	return err
+}
+
+// verifySymdbStacktraces loads the stack traces of all the partitions,
+// the checksum of every chunk is verified when it is read.
+func verifySymdbStacktraces(ctx context.Context, bucket phlareobj.Bucket, meta *block.Meta) error {
+	r, err := symdb.Open(ctx, bucket, meta)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return r.Load(ctx)
+}
+
+type blocksDropSeriesParams struct {
+	*blockParams
+	Dir string
+}
+
+func addBlocksDropSeriesParams(cmd commander) *blocksDropSeriesParams {
+	params := &blocksDropSeriesParams{blockParams: addBlockParams(cmd)}
+	cmd.Flag("dir", "Directory to write the rewritten block to.").Required().StringVar(&params.Dir)
+	return params
+}
+
+// blocksDropSeries rewrites the block without the series matching the query.
+func blocksDropSeries(ctx context.Context, params *blocksDropSeriesParams) error {
+	matchers, err := params.matchers()
+	if err != nil {
+		return err
+	}
+	if len(matchers) == 0 {
+		return errors.New("a non-empty --query is required to drop series")
+	}
+	q, err := openBlock(ctx, params.ID)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	meta, err := phlaredb.Rewrite(ctx, q, params.Dir, matchers...)
+	if err != nil {
+		return errors.Wrap(err, "failed to rewrite block")
+	}
+	src := q.Meta()
+	level.Info(logger).Log(
+		"msg", "block rewritten",
+		"path", filepath.Join(params.Dir, meta.ULID.String()),
+		"series", meta.Stats.NumSeries,
+		"dropped_series", src.Stats.NumSeries-meta.Stats.NumSeries,
+		"profiles", meta.Stats.NumProfiles,
+		"dropped_profiles", src.Stats.NumProfiles-meta.Stats.NumProfiles,
+	)
+	return nil
+}
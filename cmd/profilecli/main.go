@@ -20,6 +20,8 @@ var cfg struct {
 	verbose bool
 	blocks  struct {
 		path               string
+		bucketConfig       string
+		tenantID           string
 		restoreMissingMeta bool
 	}
 }
@@ -41,21 +43,62 @@ func main() {
 	blocksCmd := app.Command("blocks", "Operate on Grafana Pyroscope's blocks.")
 	blocksCmd.Flag("path", "Path to blocks directory").Default("./data/local").StringVar(&cfg.blocks.path)
 
+	blocksCmd.Flag("bucket-config", "Path to an object storage bucket config YAML file, used instead of --path. Only supported by the commands operating on a single block.").StringVar(&cfg.blocks.bucketConfig)
+	blocksCmd.Flag("tenant-id", "Tenant ID of the blocks in the bucket, blocks are looked up under <tenant-id>/phlaredb/.").StringVar(&cfg.blocks.tenantID)
+	blocksOutput := blocksCmd.Flag("output", "How to output the result, examples: console, json, csv.").Default("console").String()
+
 	blocksListCmd := blocksCmd.Command("list", "List blocks.")
 	blocksListCmd.Flag("restore-missing-meta", "").Default("false").BoolVar(&cfg.blocks.restoreMissingMeta)
 
+	blocksQueryCmd := blocksCmd.Command("query", "Query a single block without running a server.")
+	blocksQuerySeriesCmd := blocksQueryCmd.Command("series", "List series of the block matching the query.")
+	blocksQuerySeriesParams := addBlockParams(blocksQuerySeriesCmd)
+	blocksQueryLabelNamesCmd := blocksQueryCmd.Command("label-names", "List label names of series matching the query.")
+	blocksQueryLabelNamesParams := addBlockParams(blocksQueryLabelNamesCmd)
+	blocksQueryLabelValuesCmd := blocksQueryCmd.Command("label-values", "List label values of series matching the query.")
+	blocksQueryLabelValuesParams := addBlockParams(blocksQueryLabelValuesCmd)
+	blocksQueryLabelValuesName := blocksQueryLabelValuesCmd.Flag("name", "Label name to list the values of.").Required().String()
+	blocksQueryMergeCmd := blocksQueryCmd.Command("merge", "Merge profiles of the block matching the query.")
+	blocksQueryMergeParams := addBlockProfileParams(blocksQueryMergeCmd)
+	blocksQueryMergeOutput := blocksQueryMergeCmd.Flag("merge-output", "How to output the merged profile, examples: console, pprof=./my.pprof, collapsed=./my.txt, speedscope=./my.json, chrome-trace=./my.json, html=./my.html").Default("console").String()
+
+	blocksDumpCmd := blocksCmd.Command("dump", "Write the profiles of a block matching the query as individual pprof files.")
+	blocksDumpParams := addBlocksDumpParams(blocksDumpCmd)
+	blocksPartitionsCmd := blocksCmd.Command("partitions", "Show the stack trace partitions of a block.")
+	blocksPartitionsParams := addBlockParams(blocksPartitionsCmd)
+	blocksCardinalityCmd := blocksCmd.Command("cardinality", "Show the label cardinality of a block.")
+	blocksCardinalityParams := addBlocksCardinalityParams(blocksCardinalityCmd)
+	blocksVerifyCmd := blocksCmd.Command("verify", "Verify the files and checksums of a block.")
+	blocksVerifyParams := addBlockParams(blocksVerifyCmd)
+	blocksDropSeriesCmd := blocksCmd.Command("drop-series", "Write a copy of a block without the series matching the query.")
+	blocksDropSeriesParams := addBlocksDropSeriesParams(blocksDropSeriesCmd)
+
 	parquetCmd := app.Command("parquet", "Operate on a Parquet file.")
 	parquetInspectCmd := parquetCmd.Command("inspect", "Inspect a parquet file's structure.")
 	parquetInspectFiles := parquetInspectCmd.Arg("file", "parquet file path").Required().ExistingFiles()
 
 	queryCmd := app.Command("query", "Query profile store.")
 	queryParams := addQueryParams(queryCmd)
-	queryOutput := queryCmd.Flag("output", "How to output the result, examples: console, raw, pprof=./my.pprof").Default("console").String()
+	queryOutput := queryCmd.Flag("output", "How to output the result, examples: console, raw, json, csv, pprof=./my.pprof, collapsed=./my.txt, speedscope=./my.json, chrome-trace=./my.json, html=./my.html").Default("console").String()
 	queryMergeCmd := queryCmd.Command("merge", "Request merged profile.")
+	queryMergeStream := queryMergeCmd.Flag("stream", "Receive the merged profile in chunks and write them directly to disk, without holding the profile in memory. Requires the pprof=<file> output.").Bool()
+	querySeriesCmd := queryCmd.Command("series", "Request series matching the query.")
+	querySeriesParams := addQuerySeriesParams(querySeriesCmd, queryParams)
+	queryLabelNamesCmd := queryCmd.Command("label-names", "Request label names of series matching the query.")
+	queryLabelValuesCmd := queryCmd.Command("label-values", "Request label values of series matching the query.")
+	queryLabelValuesParams := addQueryLabelValuesParams(queryLabelValuesCmd, queryParams)
+	queryProfileTypesCmd := queryCmd.Command("profile-types", "Request available profile types.")
+	queryTopCmd := queryCmd.Command("top", "Request functions with the highest self value.")
+	queryTopParams := addQueryTopParams(queryTopCmd, queryParams)
+	queryDiffCmd := queryCmd.Command("diff", "Request the difference between two queries, as a table or a pprof=./diff.pprof profile.")
+	queryDiffParams := addQueryDiffParams(queryDiffCmd, queryParams)
 
 	uploadCmd := app.Command("upload", "Upload profile(s).")
 	uploadParams := addUploadParams(uploadCmd)
 
+	migrateCmd := app.Command("migrate", "Migrate the data of a legacy Pyroscope server (v0.37 and earlier) storage directory.")
+	migrateParams := addMigrateParams(migrateCmd)
+
 	canaryExporterCmd := app.Command("canary-exporter", "Run the canary exporter.")
 	canaryExporterParams := addCanaryExporterParams(canaryExporterCmd)
 
@@ -70,6 +113,24 @@ func main() {
 	switch parsedCmd {
 	case blocksListCmd.FullCommand():
 		os.Exit(checkError(blocksList(ctx)))
+	case blocksQuerySeriesCmd.FullCommand():
+		os.Exit(checkError(blocksQuerySeries(ctx, blocksQuerySeriesParams, *blocksOutput)))
+	case blocksQueryLabelNamesCmd.FullCommand():
+		os.Exit(checkError(blocksQueryLabelNames(ctx, blocksQueryLabelNamesParams, *blocksOutput)))
+	case blocksQueryLabelValuesCmd.FullCommand():
+		os.Exit(checkError(blocksQueryLabelValues(ctx, blocksQueryLabelValuesParams, *blocksQueryLabelValuesName, *blocksOutput)))
+	case blocksQueryMergeCmd.FullCommand():
+		os.Exit(checkError(blocksQueryMerge(ctx, blocksQueryMergeParams, *blocksQueryMergeOutput)))
+	case blocksDumpCmd.FullCommand():
+		os.Exit(checkError(blocksDump(ctx, blocksDumpParams)))
+	case blocksPartitionsCmd.FullCommand():
+		os.Exit(checkError(blocksPartitions(ctx, blocksPartitionsParams, *blocksOutput)))
+	case blocksCardinalityCmd.FullCommand():
+		os.Exit(checkError(blocksCardinality(ctx, blocksCardinalityParams, *blocksOutput)))
+	case blocksVerifyCmd.FullCommand():
+		os.Exit(checkError(blocksVerify(ctx, blocksVerifyParams, *blocksOutput)))
+	case blocksDropSeriesCmd.FullCommand():
+		os.Exit(checkError(blocksDropSeries(ctx, blocksDropSeriesParams)))
 	case parquetInspectCmd.FullCommand():
 		for _, file := range *parquetInspectFiles {
 			if err := parquetInspect(ctx, file); err != nil {
@@ -77,13 +138,45 @@ func main() {
 			}
 		}
 	case queryMergeCmd.FullCommand():
-		if err := queryMerge(ctx, queryParams, *queryOutput); err != nil {
+		merge := queryMerge
+		if *queryMergeStream {
+			merge = queryMergeStreamed
+		}
+		if err := merge(ctx, queryParams, *queryOutput); err != nil {
+			os.Exit(checkError(err))
+		}
+	case querySeriesCmd.FullCommand():
+		if err := querySeries(ctx, querySeriesParams, *queryOutput); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryLabelNamesCmd.FullCommand():
+		if err := queryLabelNames(ctx, queryParams, *queryOutput); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryLabelValuesCmd.FullCommand():
+		if err := queryLabelValues(ctx, queryLabelValuesParams, *queryOutput); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryProfileTypesCmd.FullCommand():
+		if err := queryProfileTypes(ctx, queryParams, *queryOutput); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryTopCmd.FullCommand():
+		if err := queryTop(ctx, queryTopParams, *queryOutput); err != nil {
+			os.Exit(checkError(err))
+		}
+	case queryDiffCmd.FullCommand():
+		if err := queryDiff(ctx, queryDiffParams, *queryOutput); err != nil {
 			os.Exit(checkError(err))
 		}
 	case uploadCmd.FullCommand():
 		if err := upload(ctx, uploadParams); err != nil {
 			os.Exit(checkError(err))
 		}
+	case migrateCmd.FullCommand():
+		if err := migrate(ctx, migrateParams); err != nil {
+			os.Exit(checkError(err))
+		}
 	case canaryExporterCmd.FullCommand():
 		if err := newCanaryExporter(canaryExporterParams).run(ctx); err != nil {
 			os.Exit(checkError(err))
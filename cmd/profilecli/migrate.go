@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/go-kit/log/level"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
+	"github.com/grafana/pyroscope/pkg/ingester/pyroscope"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	"github.com/grafana/pyroscope/pkg/og/storage"
+	"github.com/grafana/pyroscope/pkg/og/storage/dict"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
+	"github.com/grafana/pyroscope/pkg/og/storage/segment"
+	"github.com/grafana/pyroscope/pkg/og/storage/tree"
+	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
+	"github.com/grafana/pyroscope/pkg/phlaredb"
+	"github.com/grafana/pyroscope/pkg/pprof"
+)
+
+// Key prefixes of the legacy storage, each kind of data is stored in its
+// own BadgerDB database, in a sub-directory of the storage path.
+const (
+	legacySegmentPrefix    = "s:"
+	legacyTreePrefix       = "t:"
+	legacyDictionaryPrefix = "d:"
+
+	// legacyResolution is the time span of the most granular trees.
+	legacyResolution = 10 * time.Second
+)
+
+type migrateParams struct {
+	*phlareClient
+	StoragePath   string
+	OutputDir     string
+	StateFile     string
+	From          string
+	To            string
+	BlockDuration time.Duration
+	BatchSize     int
+}
+
+func addMigrateParams(cmd commander) *migrateParams {
+	params := &migrateParams{}
+	params.phlareClient = addPhlareClient(cmd)
+	cmd.Arg("storage-path", "Path to the storage directory of the legacy Pyroscope server (v0.37 and earlier).").Required().ExistingDirVar(&params.StoragePath)
+	cmd.Flag("output-dir", "Write the profiles as blocks to this directory instead of pushing them to --url.").StringVar(&params.OutputDir)
+	cmd.Flag("state-file", "Path to the file the progress is saved to, an interrupted migration resumes from it.").Default("migrate-state.json").StringVar(&params.StateFile)
+	cmd.Flag("from", "Beginning of the data to migrate, defaults to the oldest data.").StringVar(&params.From)
+	cmd.Flag("to", "End of the data to migrate.").Default("now").StringVar(&params.To)
+	cmd.Flag("block-duration", "Time span migrated at once, when writing blocks every span results in a block.").Default("3h").DurationVar(&params.BlockDuration)
+	cmd.Flag("batch-size", "Number of profiles sent in a single push request.").Default("100").IntVar(&params.BatchSize)
+	return params
+}
+
+// legacyStorage reads the BadgerDB databases of the legacy storage.
+type legacyStorage struct {
+	segments *badger.DB
+	trees    *badger.DB
+	dicts    *badger.DB
+
+	dictsMu sync.Mutex
+	dictMap map[string]*dict.Dict
+}
+
+type legacySegment struct {
+	key       *segment.Key
+	treeKey   string
+	startTime time.Time
+	metadata  metadata.Metadata
+}
+
+func openLegacyStorage(path string) (*legacyStorage, error) {
+	s := &legacyStorage{dictMap: make(map[string]*dict.Dict)}
+	for name, db := range map[string]**badger.DB{
+		"segments": &s.segments,
+		"trees":    &s.trees,
+		"dicts":    &s.dicts,
+	} {
+		var err error
+		*db, err = badger.Open(badger.DefaultOptions(filepath.Join(path, name)).
+			WithReadOnly(true).
+			WithLogger(nil))
+		if err != nil {
+			_ = s.Close()
+			return nil, errors.Wrapf(err, "failed to open %s database", name)
+		}
+	}
+	return s, nil
+}
+
+func (s *legacyStorage) Close() error {
+	var err error
+	for _, db := range []*badger.DB{s.segments, s.trees, s.dicts} {
+		if db == nil {
+			continue
+		}
+		if closeErr := db.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// listSegments returns all the series of the storage, ordered by key.
+func (s *legacyStorage) listSegments() ([]*legacySegment, error) {
+	var segments []*legacySegment
+	err := s.segments.View(func(txn *badger.Txn) error {
+		prefix := []byte(legacySegmentPrefix)
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			name := string(bytes.TrimPrefix(item.Key(), prefix))
+			key, err := segment.ParseKey(name)
+			if err != nil {
+				level.Warn(logger).Log("msg", "skipping segment with invalid key", "key", name, "err", err)
+				continue
+			}
+			var seg *segment.Segment
+			if err = item.Value(func(v []byte) (err error) {
+				seg, err = segment.Deserialize(bytes.NewReader(v))
+				return err
+			}); err != nil {
+				return errors.Wrapf(err, "failed to read segment %s", name)
+			}
+			startTime := seg.StartTime()
+			if startTime.IsZero() {
+				continue
+			}
+			segments = append(segments, &legacySegment{
+				key:       key,
+				treeKey:   name,
+				startTime: startTime,
+				metadata:  seg.GetMetadata(),
+			})
+		}
+		return nil
+	})
+	return segments, err
+}
+
+func (s *legacyStorage) dict(name string) (*dict.Dict, error) {
+	s.dictsMu.Lock()
+	defer s.dictsMu.Unlock()
+	if d, ok := s.dictMap[name]; ok {
+		return d, nil
+	}
+	d := dict.New()
+	err := s.dicts.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(legacyDictionaryPrefix + name))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(v []byte) (err error) {
+			d, err = dict.Deserialize(bytes.NewReader(v))
+			return err
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read dictionary %s", name)
+	}
+	s.dictMap[name] = d
+	return d, nil
+}
+
+// iterateTrees calls fn for each of the most granular trees of the segment
+// within [from, to). Tree keys end with the unix time of the tree, which
+// has the same number of digits for all the dates the legacy server
+// supports, therefore the keys are sorted by time.
+func (s *legacyStorage) iterateTrees(seg *legacySegment, from, to time.Time, fn func(time.Time, *tree.Tree) error) error {
+	d, err := s.dict(segment.FromTreeToDictKey(seg.treeKey))
+	if err != nil {
+		return err
+	}
+	prefix := []byte(legacyTreePrefix + seg.treeKey + ":0:")
+	return s.trees.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: prefix, PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		for it.Seek(append(prefix, strconv.FormatInt(from.Unix(), 10)...)); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			unix, err := strconv.ParseInt(string(bytes.TrimPrefix(item.Key(), prefix)), 10, 64)
+			if err != nil {
+				level.Warn(logger).Log("msg", "skipping tree with invalid key", "key", string(item.Key()), "err", err)
+				continue
+			}
+			t := time.Unix(unix, 0)
+			if !t.Before(to) {
+				return nil
+			}
+			var tr *tree.Tree
+			if err = item.Value(func(v []byte) (err error) {
+				tr, err = tree.Deserialize(d, bytes.NewReader(v))
+				return err
+			}); err != nil {
+				return errors.Wrapf(err, "failed to read tree %s", item.Key())
+			}
+			if err = fn(t, tr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type migrateState struct {
+	// Next is the beginning of the first time span not migrated yet.
+	Next time.Time `json:"next"`
+	// Segments are the tree keys of the series of the span starting at
+	// Next that have been migrated already.
+	Segments []string `json:"segments,omitempty"`
+	Profiles int64    `json:"profiles"`
+	Skipped  int64    `json:"skipped"`
+}
+
+func loadMigrateState(path string) (*migrateState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &migrateState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state migrateState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse state file %s", path)
+	}
+	return &state, nil
+}
+
+func (s *migrateState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write and rename to never leave a truncated state file behind.
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// migrateSink receives the converted profiles. Profiles of a time span
+// are guaranteed to be persisted once flush returns.
+type migrateSink interface {
+	pyroscope.PushService
+	flush(ctx context.Context) error
+	// flushSegments reports whether flush is called after every series,
+	// otherwise it is only called at the end of the time spans.
+	flushSegments() bool
+	// skipped returns the number of profiles rejected as invalid
+	// since the last call, after they have been accepted by Push.
+	skipped() int64
+	close() error
+}
+
+// batchPusher buffers the pushed series and sends them in batches.
+type batchPusher struct {
+	client    pushv1connect.PusherServiceClient
+	batchSize int
+	series    []*pushv1.RawProfileSeries
+	rejected  int64
+}
+
+func (p *batchPusher) Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	p.series = append(p.series, req.Msg.Series...)
+	if len(p.series) >= p.batchSize {
+		if err := p.flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return connect.NewResponse(&pushv1.PushResponse{}), nil
+}
+
+func (p *batchPusher) flush(ctx context.Context) error {
+	if len(p.series) == 0 {
+		return nil
+	}
+	_, err := p.client.Push(ctx, connect.NewRequest(&pushv1.PushRequest{Series: p.series}))
+	if connect.CodeOf(err) == connect.CodeInvalidArgument {
+		// A single invalid profile fails the whole batch: the
+		// series are pushed separately to skip only the invalid ones.
+		err = nil
+		for _, series := range p.series {
+			_, pushErr := p.client.Push(ctx, connect.NewRequest(&pushv1.PushRequest{Series: []*pushv1.RawProfileSeries{series}}))
+			if connect.CodeOf(pushErr) == connect.CodeInvalidArgument {
+				level.Debug(logger).Log("msg", "skipping profile", "labels", phlaremodel.LabelPairsString(series.Labels), "err", pushErr)
+				p.rejected++
+				continue
+			}
+			if pushErr != nil {
+				err = pushErr
+				break
+			}
+		}
+	}
+	p.series = p.series[:0]
+	return err
+}
+
+func (p *batchPusher) skipped() int64 {
+	n := p.rejected
+	p.rejected = 0
+	return n
+}
+
+func (p *batchPusher) flushSegments() bool { return true }
+
+func (p *batchPusher) close() error { return nil }
+
+// blockWriter ingests the pushed profiles into a local database, the
+// head is flushed to a block at the end of every time span.
+type blockWriter struct {
+	db *phlaredb.PhlareDB
+}
+
+func newBlockWriter(ctx context.Context, dir string) (*blockWriter, error) {
+	bucket, err := filesystem.NewBucket(dir)
+	if err != nil {
+		return nil, err
+	}
+	// The logger of ctx is not filtered by the verbosity level.
+	db, err := phlaredb.New(phlarecontext.WithLogger(ctx, logger), phlaredb.Config{
+		DataPath: dir,
+		// Blocks are cut explicitly by flush.
+		MaxBlockDuration: 24 * time.Hour,
+	}, noLimit{}, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &blockWriter{db: db}, nil
+}
+
+func (w *blockWriter) Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	for _, series := range req.Msg.Series {
+		for _, sample := range series.Samples {
+			id, err := uuid.Parse(sample.ID)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
+			var ingestErr error
+			err = pprof.FromBytes(sample.RawProfile, func(p *profilev1.Profile, _ int) error {
+				ingestErr = w.db.Ingest(ctx, p, id, series.Labels...)
+				return ingestErr
+			})
+			if err != nil && ingestErr == nil {
+				// The profile could not be decoded.
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return connect.NewResponse(&pushv1.PushResponse{}), nil
+}
+
+func (w *blockWriter) flush(ctx context.Context) error { return w.db.Flush(ctx) }
+
+// flushSegments returns false: flush cuts a block, which
+// covers the whole time span.
+func (w *blockWriter) flushSegments() bool { return false }
+
+func (w *blockWriter) skipped() int64 { return 0 }
+
+func (w *blockWriter) close() error { return w.db.Close() }
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+type noLimit struct{}
+
+func (noLimit) AllowProfile(model.Fingerprint, phlaremodel.Labels, int64) error { return nil }
+
+func (noLimit) Stop() {}
+
+// migrate converts the trees of the legacy storage to pprof profiles, time
+// span by time span, and either pushes them or writes them as blocks. The
+// progress is saved after each span, and after each series of a span when
+// the profiles are pushed, so that the migration can be resumed without
+// pushing the series migrated already again.
+func migrate(ctx context.Context, params *migrateParams) (err error) {
+	if params.BlockDuration < legacyResolution {
+		return errors.Errorf("block duration must be at least %s", legacyResolution)
+	}
+	to, err := parseTime(params.To)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse to")
+	}
+
+	s, err := openLegacyStorage(params.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := s.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	segments, err := s.listSegments()
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		level.Info(logger).Log("msg", "no data to migrate", "path", params.StoragePath)
+		return nil
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].startTime.Before(segments[j].startTime) })
+
+	from := segments[0].startTime
+	if params.From != "" {
+		if from, err = parseTime(params.From); err != nil {
+			return errors.Wrap(err, "failed to parse from")
+		}
+	}
+	from = from.Truncate(params.BlockDuration)
+
+	state, err := loadMigrateState(params.StateFile)
+	if err != nil {
+		return err
+	}
+	if state.Next.After(from) {
+		level.Info(logger).Log("msg", "resuming migration", "state_file", params.StateFile, "from", state.Next, "series_migrated", len(state.Segments), "profiles", state.Profiles)
+		from = state.Next
+	}
+
+	var sink migrateSink
+	if params.OutputDir != "" {
+		if sink, err = newBlockWriter(ctx, params.OutputDir); err != nil {
+			return err
+		}
+	} else {
+		sink = &batchPusher{client: params.phlareClient.pusherClient(), batchSize: params.BatchSize}
+	}
+	defer func() {
+		if closeErr := sink.close(); err == nil {
+			err = closeErr
+		}
+	}()
+	putter := pyroscope.NewPutter(sink)
+
+	level.Info(logger).Log("msg", "migrating legacy storage", "path", params.StoragePath, "series", len(segments), "from", from, "to", to)
+	started := time.Now()
+	for spanStart := from; spanStart.Before(to); spanStart = spanStart.Add(params.BlockDuration) {
+		spanEnd := spanStart.Add(params.BlockDuration)
+		if !spanStart.Equal(state.Next) {
+			state.Segments = nil
+		}
+		migrated := make(map[string]struct{}, len(state.Segments))
+		for _, k := range state.Segments {
+			migrated[k] = struct{}{}
+		}
+		// The profiles and skipped profiles of the span, those
+		// pending are not accounted in the state yet.
+		var profiles, skipped, pendingProfiles, pendingSkipped int64
+		checkpoint := func() error {
+			if err := sink.flush(ctx); err != nil {
+				return errors.Wrap(err, "failed to flush profiles")
+			}
+			rejected := sink.skipped()
+			pendingProfiles -= rejected
+			pendingSkipped += rejected
+			state.Profiles += pendingProfiles
+			state.Skipped += pendingSkipped
+			profiles += pendingProfiles
+			skipped += pendingSkipped
+			pendingProfiles, pendingSkipped = 0, 0
+			return errors.Wrap(state.save(params.StateFile), "failed to save state")
+		}
+		for _, seg := range segments {
+			if !seg.startTime.Before(spanEnd) {
+				continue
+			}
+			if _, ok := migrated[seg.treeKey]; ok {
+				continue
+			}
+			err = s.iterateTrees(seg, spanStart, spanEnd, func(t time.Time, tr *tree.Tree) error {
+				err := putter.Put(ctx, &storage.PutInput{
+					StartTime:       t,
+					EndTime:         t.Add(legacyResolution),
+					Key:             seg.key,
+					Val:             tr,
+					SpyName:         seg.metadata.SpyName,
+					SampleRate:      seg.metadata.SampleRate,
+					Units:           seg.metadata.Units,
+					AggregationType: seg.metadata.AggregationType,
+				})
+				if connect.CodeOf(err) == connect.CodeInvalidArgument {
+					level.Debug(logger).Log("msg", "skipping profile", "key", seg.treeKey, "time", t, "err", err)
+					pendingSkipped++
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				pendingProfiles++
+				return nil
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to migrate %s", seg.treeKey)
+			}
+			if sink.flushSegments() {
+				state.Segments = append(state.Segments, seg.treeKey)
+				if err = checkpoint(); err != nil {
+					return err
+				}
+			}
+		}
+		state.Next = spanEnd
+		state.Segments = nil
+		if err = checkpoint(); err != nil {
+			return err
+		}
+		if profiles > 0 || skipped > 0 {
+			level.Info(logger).Log(
+				"msg", "time span migrated",
+				"from", spanStart,
+				"to", spanEnd,
+				"profiles", profiles,
+				"skipped", skipped,
+				"progress", fmt.Sprintf("%.1f%%", percentage(int64(minTime(spanEnd, to).Sub(from)), int64(to.Sub(from)))),
+				"elapsed", time.Since(started).Round(time.Second),
+			)
+		}
+	}
+	level.Info(logger).Log("msg", "migration completed", "profiles", state.Profiles, "skipped", state.Skipped)
+	return nil
+}
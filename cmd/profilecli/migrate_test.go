@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/require"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/ingester/pyroscope"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/storage"
+	"github.com/grafana/pyroscope/pkg/og/storage/dict"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
+	"github.com/grafana/pyroscope/pkg/og/storage/segment"
+	"github.com/grafana/pyroscope/pkg/og/storage/tree"
+)
+
+type mockPusher struct {
+	sync.Mutex
+	requests []*pushv1.PushRequest
+	// failAfter makes the pusher unavailable once it has
+	// received that many requests, if positive.
+	failAfter int
+}
+
+func (m *mockPusher) Push(_ context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.failAfter > 0 && len(m.requests) >= m.failAfter {
+		return nil, connect.NewError(connect.CodeUnavailable, errors.New("unavailable"))
+	}
+	m.requests = append(m.requests, req.Msg)
+	for _, s := range req.Msg.Series {
+		if phlaremodel.Labels(s.Labels).Get("name") == "invalid" {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("invalid profile"))
+		}
+	}
+	return connect.NewResponse(&pushv1.PushResponse{}), nil
+}
+
+func Test_BatchPusher_SkipsInvalidProfiles(t *testing.T) {
+	client := new(mockPusher)
+	p := &batchPusher{client: client, batchSize: 3}
+	ctx := context.Background()
+	for _, name := range []string{"a", "invalid", "c"} {
+		_, err := p.Push(ctx, connect.NewRequest(&pushv1.PushRequest{Series: []*pushv1.RawProfileSeries{{
+			Labels: []*typesv1.LabelPair{{Name: "name", Value: name}},
+		}}}))
+		require.NoError(t, err)
+	}
+	require.NoError(t, p.flush(ctx))
+	// The batch, then each of the profiles.
+	require.Len(t, client.requests, 4)
+	require.Equal(t, int64(1), p.skipped())
+	require.Equal(t, int64(0), p.skipped())
+}
+
+func Test_Migrate_SkipsUnknownProfileTypes(t *testing.T) {
+	client := new(mockPusher)
+	putter := pyroscope.NewPutter(&batchPusher{client: client, batchSize: 1})
+	k, err := segment.ParseKey("app.unknown_type{}")
+	require.NoError(t, err)
+	tr := tree.New()
+	tr.Insert([]byte("a;b"), 1)
+	err = putter.Put(context.Background(), &storage.PutInput{
+		StartTime: time.Unix(0, 0),
+		EndTime:   time.Unix(10, 0),
+		Key:       k,
+		Val:       tr,
+	})
+	require.Error(t, err)
+	// The migration skips the profiles rejected as invalid.
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	require.Empty(t, client.requests)
+}
+
+// writeLegacyStorage writes a legacy storage with a tree every 20s
+// within [from, to) for each of the apps.
+func writeLegacyStorage(t *testing.T, dir string, from, to time.Time, apps ...string) {
+	t.Helper()
+	open := func(name string) *badger.DB {
+		db, err := badger.Open(badger.DefaultOptions(filepath.Join(dir, name)).WithLogger(nil))
+		require.NoError(t, err)
+		return db
+	}
+	segments, trees, dicts := open("segments"), open("trees"), open("dicts")
+	// The storage is read once the databases are closed.
+	defer func() {
+		for _, db := range []*badger.DB{segments, trees, dicts} {
+			require.NoError(t, db.Close())
+		}
+	}()
+	for _, app := range apps {
+		k, err := segment.ParseKey(app + "{}")
+		require.NoError(t, err)
+		seg := segment.New()
+		seg.SetMetadata(metadata.Metadata{
+			SpyName:         "gospy",
+			SampleRate:      100,
+			Units:           metadata.SamplesUnits,
+			AggregationType: metadata.SumAggregationType,
+		})
+		d := dict.New()
+		for ts := from; ts.Before(to); ts = ts.Add(20 * time.Second) {
+			require.NoError(t, seg.Put(ts, ts.Add(legacyResolution), 1, func(int, time.Time, *big.Rat, []segment.Addon) {}))
+			tr := tree.New()
+			tr.Insert([]byte("main;work"), 1)
+			var buf bytes.Buffer
+			require.NoError(t, tr.SerializeTruncate(d, 1024, &buf))
+			require.NoError(t, trees.Update(func(txn *badger.Txn) error {
+				return txn.Set([]byte(legacyTreePrefix+k.TreeKey(0, ts)), buf.Bytes())
+			}))
+		}
+		var buf bytes.Buffer
+		require.NoError(t, seg.Serialize(&buf))
+		require.NoError(t, segments.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(legacySegmentPrefix+k.SegmentKey()), buf.Bytes())
+		}))
+		buf.Reset()
+		require.NoError(t, d.Serialize(&buf))
+		require.NoError(t, dicts.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(legacyDictionaryPrefix+k.DictKey()), buf.Bytes())
+		}))
+	}
+}
+
+func Test_LegacyStorage_IterateTrees(t *testing.T) {
+	dir := t.TempDir()
+	from := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	writeLegacyStorage(t, dir, from, from.Add(time.Minute), "app.cpu")
+
+	s, err := openLegacyStorage(dir)
+	require.NoError(t, err)
+	defer s.Close()
+	segments, err := s.listSegments()
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	require.Equal(t, "app.cpu{}", segments[0].treeKey)
+	require.Equal(t, from, segments[0].startTime.UTC())
+
+	var times []time.Time
+	err = s.iterateTrees(segments[0], from.Add(10*time.Second), from.Add(50*time.Second), func(ts time.Time, tr *tree.Tree) error {
+		times = append(times, ts.UTC())
+		require.Equal(t, "main;work 1\n", tr.String())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []time.Time{from.Add(20 * time.Second), from.Add(40 * time.Second)}, times)
+}
+
+func Test_Migrate_Resume(t *testing.T) {
+	dir := t.TempDir()
+	from := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Minute)
+	writeLegacyStorage(t, dir, from, to, "app-a.cpu", "app-b.cpu")
+
+	// Each series of a span is pushed in a single request. The pusher
+	// fails on the second series of the second span.
+	pusher := &mockPusher{failAfter: 3}
+	mux := http.NewServeMux()
+	mux.Handle(pushv1connect.NewPusherServiceHandler(pusher))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	params := &migrateParams{
+		phlareClient:  &phlareClient{URL: server.URL},
+		StoragePath:   dir,
+		StateFile:     filepath.Join(t.TempDir(), "state.json"),
+		From:          from.Format(time.RFC3339),
+		To:            to.Format(time.RFC3339),
+		BlockDuration: time.Minute,
+		BatchSize:     100,
+	}
+	err := migrate(context.Background(), params)
+	require.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+	state, err := loadMigrateState(params.StateFile)
+	require.NoError(t, err)
+	require.Equal(t, from.Add(time.Minute), state.Next.UTC())
+	require.Equal(t, []string{"app-a.cpu{}"}, state.Segments)
+	require.Equal(t, int64(9), state.Profiles)
+
+	// The migration resumes from the series not migrated yet.
+	pusher.Lock()
+	pusher.failAfter = 0
+	pusher.Unlock()
+	require.NoError(t, migrate(context.Background(), params))
+	state, err = loadMigrateState(params.StateFile)
+	require.NoError(t, err)
+	require.Equal(t, to, state.Next.UTC())
+	require.Empty(t, state.Segments)
+	require.Equal(t, int64(12), state.Profiles)
+
+	pusher.Lock()
+	defer pusher.Unlock()
+	require.Len(t, pusher.requests, 4)
+	profiles := make(map[string]int)
+	for _, req := range pusher.requests {
+		for _, series := range req.Series {
+			profiles[phlaremodel.Labels(series.Labels).Get("service_name")] += len(series.Samples)
+		}
+	}
+	require.Equal(t, map[string]int{"app-a": 6, "app-b": 6}, profiles)
+}
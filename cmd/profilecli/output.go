@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+)
+
+const (
+	outputJSON = "json"
+	outputCSV  = "csv"
+)
+
+// writeTable writes the rows in the requested output format: a table for
+// console, an array of objects keyed by the header for json, or csv.
+func writeTable(ctx context.Context, outputFlag string, header []string, rows [][]interface{}) error {
+	switch outputFlag {
+	case outputConsole:
+		table := tablewriter.NewWriter(output(ctx))
+		table.SetHeader(header)
+		table.SetAutoFormatHeaders(false)
+		for _, row := range rows {
+			table.Append(formatRow(row))
+		}
+		table.Render()
+		return nil
+
+	case outputJSON:
+		objects := make([]map[string]interface{}, len(rows))
+		for i, row := range rows {
+			o := make(map[string]interface{}, len(header))
+			for j, name := range header {
+				o[name] = row[j]
+			}
+			objects[i] = o
+		}
+		enc := json.NewEncoder(output(ctx))
+		enc.SetIndent("", "  ")
+		return enc.Encode(objects)
+
+	case outputCSV:
+		w := csv.NewWriter(output(ctx))
+		if err := w.Write(header); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := w.Write(formatRow(row)); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	}
+
+	return errors.Errorf("unknown output %s, supported outputs: %s, %s, %s", outputFlag, outputConsole, outputJSON, outputCSV)
+}
+
+func formatRow(row []interface{}) []string {
+	res := make([]string, len(row))
+	for i, v := range row {
+		switch v := v.(type) {
+		case float64:
+			res[i] = fmt.Sprintf("%.2f", v)
+		default:
+			res[i] = fmt.Sprint(v)
+		}
+	}
+	return res
+}
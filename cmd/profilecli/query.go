@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,6 +23,8 @@ import (
 
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 )
 
 const (
@@ -98,6 +101,7 @@ type queryParams struct {
 	To          string
 	ProfileType string
 	Query       string
+	MaxNodes    int64
 }
 
 func (p *queryParams) parseFromTo() (from time.Time, to time.Time, err error) {
@@ -127,6 +131,7 @@ func addQueryParams(queryCmd commander) *queryParams {
 	queryCmd.Flag("to", "End of the query.").Default("now").StringVar(&params.To)
 	queryCmd.Flag("profile-type", "Profile type to query.").Default("process_cpu:cpu:nanoseconds:cpu:nanoseconds").StringVar(&params.ProfileType)
 	queryCmd.Flag("query", "Label selector to query.").Default("{}").StringVar(&params.Query)
+	queryCmd.Flag("max-nodes", "Maximum number of nodes of the merged tree, -1 disables truncation. Not used by pprof outputs.").Default("0").Int64Var(&params.MaxNodes)
 	return params
 }
 
@@ -140,6 +145,10 @@ func queryMerge(ctx context.Context, params *queryParams, outputFlag string) (er
 
 	qc := params.phlareClient.queryClient()
 
+	if format, filePath, ok := parseTreeOutput(outputFlag); ok {
+		return queryMergeTree(ctx, qc, params, from, to, format, filePath)
+	}
+
 	resp, err := qc.SelectMergeProfile(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
 		ProfileTypeID: params.ProfileType,
 		Start:         from.UnixMilli(),
@@ -205,3 +214,215 @@ func queryMerge(ctx context.Context, params *queryParams, outputFlag string) (er
 
 	return errors.Errorf("unknown output %s", outputFlag)
 }
+
+// queryMergeStreamed receives the merged profile in chunks and writes them
+// to the pprof file as they arrive.
+func queryMergeStreamed(ctx context.Context, params *queryParams, outputFlag string) (err error) {
+	if !strings.HasPrefix(outputFlag, outputPprof) {
+		return errors.Errorf("streaming requires the %s<file> output", outputPprof)
+	}
+	filePath := strings.TrimPrefix(outputFlag, outputPprof)
+	if filePath == "" {
+		return errors.New("no file path specified after pprof=")
+	}
+	from, to, err := params.parseFromTo()
+	if err != nil {
+		return err
+	}
+
+	level.Info(logger).Log("msg", "stream aggregated profile from profile store", "url", params.URL, "from", from, "to", to, "query", params.Query, "type", params.ProfileType)
+
+	stream, err := params.phlareClient.queryClient().SelectMergeProfileStream(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		ProfileTypeID: params.ProfileType,
+		Start:         from.UnixMilli(),
+		End:           to.UnixMilli(),
+		LabelSelector: params.Query,
+	}))
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+	defer runutil.CloseWithErrCapture(&err, stream, "failed to close stream")
+
+	// open new file, fail when the file already exists
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create pprof file")
+	}
+	defer func() {
+		// Do not leave a truncated profile behind.
+		if err != nil {
+			_ = os.Remove(filePath)
+		}
+	}()
+	defer runutil.CloseWithErrCapture(&err, f, "failed to close pprof file")
+
+	gzipWriter := gzip.NewWriter(f)
+	defer runutil.CloseWithErrCapture(&err, gzipWriter, "failed to close pprof gzip writer")
+
+	var size int
+	for stream.Receive() {
+		n, err := gzipWriter.Write(stream.Msg().Chunk)
+		if err != nil {
+			return errors.Wrap(err, "failed to write pprof")
+		}
+		size += n
+	}
+	if err = stream.Err(); err != nil {
+		return errors.Wrap(err, "failed to receive profile")
+	}
+
+	level.Info(logger).Log("msg", "profile written", "path", filePath, "uncompressed_bytes", size)
+	return nil
+}
+
+// parseTreeOutput recognizes tree export outputs, e.g. speedscope=./my.json.
+func parseTreeOutput(outputFlag string) (phlaremodel.TreeFormat, string, bool) {
+	name, filePath, ok := strings.Cut(outputFlag, "=")
+	if !ok {
+		return "", "", false
+	}
+	format, err := phlaremodel.ParseTreeFormat(name)
+	if err != nil {
+		return "", "", false
+	}
+	return format, filePath, true
+}
+
+func queryMergeTree(ctx context.Context, qc querierv1connect.QuerierServiceClient, params *queryParams, from, to time.Time, format phlaremodel.TreeFormat, filePath string) (err error) {
+	if filePath == "" {
+		return errors.Errorf("no file path specified after %s=", format)
+	}
+	profileType, err := phlaremodel.ParseProfileTypeSelector(params.ProfileType)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse profile type")
+	}
+
+	t, err := selectMergeTree(ctx, qc, params.ProfileType, params.Query, from, to, params.MaxNodes)
+	if err != nil {
+		return err
+	}
+
+	// open new file, fail when the file already exists
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s file", format)
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "failed to close %s file", format)
+
+	if err = phlaremodel.WriteTree(f, t, format, profileType); err != nil {
+		return errors.Wrapf(err, "failed to write %s", format)
+	}
+	return nil
+}
+
+type querySeriesParams struct {
+	*queryParams
+	LabelNames []string
+}
+
+func addQuerySeriesParams(queryCmd commander, params *queryParams) *querySeriesParams {
+	p := &querySeriesParams{queryParams: params}
+	queryCmd.Flag("label-names", "Only return the given label names of the series, can be repeated.").StringsVar(&p.LabelNames)
+	return p
+}
+
+func querySeries(ctx context.Context, params *querySeriesParams, outputFlag string) error {
+	level.Info(logger).Log("msg", "query series from profile store", "url", params.URL, "query", params.Query)
+
+	resp, err := params.phlareClient.queryClient().Series(ctx, connect.NewRequest(&querierv1.SeriesRequest{
+		Matchers:   []string{params.Query},
+		LabelNames: params.LabelNames,
+	}))
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+
+	names := params.LabelNames
+	if len(names) == 0 {
+		seen := make(map[string]struct{})
+		for _, ls := range resp.Msg.LabelsSet {
+			for _, l := range ls.Labels {
+				if _, ok := seen[l.Name]; !ok {
+					seen[l.Name] = struct{}{}
+					names = append(names, l.Name)
+				}
+			}
+		}
+		sort.Strings(names)
+	}
+
+	rows := make([][]interface{}, len(resp.Msg.LabelsSet))
+	for i, ls := range resp.Msg.LabelsSet {
+		row := make([]interface{}, len(names))
+		for j, name := range names {
+			row[j] = ""
+			for _, l := range ls.Labels {
+				if l.Name == name {
+					row[j] = l.Value
+					break
+				}
+			}
+		}
+		rows[i] = row
+	}
+	return writeTable(ctx, outputFlag, names, rows)
+}
+
+func queryLabelNames(ctx context.Context, params *queryParams, outputFlag string) error {
+	level.Info(logger).Log("msg", "query label names from profile store", "url", params.URL, "query", params.Query)
+
+	resp, err := params.phlareClient.queryClient().LabelNames(ctx, connect.NewRequest(&typesv1.LabelNamesRequest{
+		Matchers: []string{params.Query},
+	}))
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+	return writeStrings(ctx, outputFlag, "Name", resp.Msg.Names)
+}
+
+type queryLabelValuesParams struct {
+	*queryParams
+	Name string
+}
+
+func addQueryLabelValuesParams(queryCmd commander, params *queryParams) *queryLabelValuesParams {
+	p := &queryLabelValuesParams{queryParams: params}
+	queryCmd.Flag("name", "Label name to query values for.").Required().StringVar(&p.Name)
+	return p
+}
+
+func queryLabelValues(ctx context.Context, params *queryLabelValuesParams, outputFlag string) error {
+	level.Info(logger).Log("msg", "query label values from profile store", "url", params.URL, "name", params.Name, "query", params.Query)
+
+	resp, err := params.phlareClient.queryClient().LabelValues(ctx, connect.NewRequest(&typesv1.LabelValuesRequest{
+		Name:     params.Name,
+		Matchers: []string{params.Query},
+	}))
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+	return writeStrings(ctx, outputFlag, "Value", resp.Msg.Names)
+}
+
+func queryProfileTypes(ctx context.Context, params *queryParams, outputFlag string) error {
+	level.Info(logger).Log("msg", "query profile types from profile store", "url", params.URL)
+
+	resp, err := params.phlareClient.queryClient().ProfileTypes(ctx, connect.NewRequest(&querierv1.ProfileTypesRequest{}))
+	if err != nil {
+		return errors.Wrap(err, "failed to query")
+	}
+
+	rows := make([][]interface{}, len(resp.Msg.ProfileTypes))
+	for i, t := range resp.Msg.ProfileTypes {
+		rows[i] = []interface{}{t.ID, t.Name, t.SampleType, t.SampleUnit, t.PeriodType, t.PeriodUnit}
+	}
+	return writeTable(ctx, outputFlag, []string{"ID", "Name", "SampleType", "SampleUnit", "PeriodType", "PeriodUnit"}, rows)
+}
+
+func writeStrings(ctx context.Context, outputFlag string, name string, values []string) error {
+	rows := make([][]interface{}, len(values))
+	for i, v := range values {
+		rows[i] = []interface{}{v}
+	}
+	return writeTable(ctx, outputFlag, []string{name}, rows)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	gprofile "github.com/google/pprof/profile"
+	"github.com/grafana/dskit/runutil"
+	"github.com/pkg/errors"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+type queryTopParams struct {
+	*queryParams
+	Limit int
+}
+
+func addQueryTopParams(queryCmd commander, params *queryParams) *queryTopParams {
+	p := &queryTopParams{queryParams: params}
+	queryCmd.Flag("limit", "Number of functions to show, 0 shows all of them.").Default("20").IntVar(&p.Limit)
+	return p
+}
+
+type topFunction struct {
+	name        string
+	self, total int64
+}
+
+// topFunctions aggregates the tree values by function name. The total
+// value of a function is only accounted once per stack trace, so that
+// recursive calls are not counted twice.
+func topFunctions(t *phlaremodel.Tree) map[string]*topFunction {
+	functions := make(map[string]*topFunction)
+	seen := make(map[string]struct{})
+	get := func(name string) *topFunction {
+		f, ok := functions[name]
+		if !ok {
+			f = &topFunction{name: name}
+			functions[name] = f
+		}
+		return f
+	}
+	t.IterateStacks(func(name string, self int64, stack []string) {
+		get(name).self += self
+		for k := range seen {
+			delete(seen, k)
+		}
+		for _, n := range stack {
+			if _, ok := seen[n]; ok {
+				continue
+			}
+			seen[n] = struct{}{}
+			get(n).total += self
+		}
+	})
+	return functions
+}
+
+func percentage(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(v) / float64(total)
+}
+
+func selectMergeTree(ctx context.Context, qc querierv1connect.QuerierServiceClient, profileType, query string, from, to time.Time, maxNodes int64) (*phlaremodel.Tree, error) {
+	resp, err := qc.SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: profileType,
+		Start:         from.UnixMilli(),
+		End:           to.UnixMilli(),
+		LabelSelector: query,
+		MaxNodes:      &maxNodes,
+	}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query")
+	}
+	m := phlaremodel.NewFlameGraphMerger()
+	if resp.Msg.Flamegraph != nil {
+		m.MergeFlameGraph(resp.Msg.Flamegraph)
+	}
+	return m.Tree(), nil
+}
+
+func queryTop(ctx context.Context, params *queryTopParams, outputFlag string) error {
+	from, to, err := params.parseFromTo()
+	if err != nil {
+		return err
+	}
+
+	level.Info(logger).Log("msg", "query top functions from profile store", "url", params.URL, "from", from, "to", to, "query", params.Query, "type", params.ProfileType)
+
+	t, err := selectMergeTree(ctx, params.phlareClient.queryClient(), params.ProfileType, params.Query, from, to, params.MaxNodes)
+	if err != nil {
+		return err
+	}
+
+	functions := make([]*topFunction, 0, 128)
+	for _, f := range topFunctions(t) {
+		functions = append(functions, f)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		if functions[i].self != functions[j].self {
+			return functions[i].self > functions[j].self
+		}
+		return functions[i].name < functions[j].name
+	})
+	if params.Limit > 0 && len(functions) > params.Limit {
+		functions = functions[:params.Limit]
+	}
+
+	total := t.Total()
+	rows := make([][]interface{}, len(functions))
+	for i, f := range functions {
+		rows[i] = []interface{}{f.name, f.self, percentage(f.self, total), f.total, percentage(f.total, total)}
+	}
+	return writeTable(ctx, outputFlag, []string{"Function", "Self", "Self%", "Total", "Total%"}, rows)
+}
+
+type queryDiffParams struct {
+	*queryParams
+	Limit      int
+	LeftQuery  string
+	LeftFrom   string
+	LeftTo     string
+	RightQuery string
+	RightFrom  string
+	RightTo    string
+}
+
+func addQueryDiffParams(queryCmd commander, params *queryParams) *queryDiffParams {
+	p := &queryDiffParams{queryParams: params}
+	queryCmd.Flag("limit", "Number of functions to show, 0 shows all of them.").Default("20").IntVar(&p.Limit)
+	queryCmd.Flag("left-query", "Label selector of the left (baseline) side, defaults to --query.").StringVar(&p.LeftQuery)
+	queryCmd.Flag("left-from", "Beginning of the left side, defaults to --from.").StringVar(&p.LeftFrom)
+	queryCmd.Flag("left-to", "End of the left side, defaults to --to.").StringVar(&p.LeftTo)
+	queryCmd.Flag("right-query", "Label selector of the right side, defaults to --query.").StringVar(&p.RightQuery)
+	queryCmd.Flag("right-from", "Beginning of the right side, defaults to --from.").StringVar(&p.RightFrom)
+	queryCmd.Flag("right-to", "End of the right side, defaults to --to.").StringVar(&p.RightTo)
+	return p
+}
+
+// sides returns the query parameters of the left and right sides of the diff.
+func (p *queryDiffParams) sides() (left, right *queryParams) {
+	side := func(query, from, to string) *queryParams {
+		s := *p.queryParams
+		if query != "" {
+			s.Query = query
+		}
+		if from != "" {
+			s.From = from
+		}
+		if to != "" {
+			s.To = to
+		}
+		return &s
+	}
+	return side(p.LeftQuery, p.LeftFrom, p.LeftTo), side(p.RightQuery, p.RightFrom, p.RightTo)
+}
+
+func queryDiff(ctx context.Context, params *queryDiffParams, outputFlag string) error {
+	left, right := params.sides()
+	leftFrom, leftTo, err := left.parseFromTo()
+	if err != nil {
+		return errors.Wrap(err, "left")
+	}
+	rightFrom, rightTo, err := right.parseFromTo()
+	if err != nil {
+		return errors.Wrap(err, "right")
+	}
+
+	level.Info(logger).Log("msg", "query diff from profile store", "url", params.URL, "type", params.ProfileType,
+		"left_query", left.Query, "left_from", leftFrom, "left_to", leftTo,
+		"right_query", right.Query, "right_from", rightFrom, "right_to", rightTo)
+
+	qc := params.phlareClient.queryClient()
+
+	if strings.HasPrefix(outputFlag, outputPprof) {
+		filePath := strings.TrimPrefix(outputFlag, outputPprof)
+		if filePath == "" {
+			return errors.New("no file path specified after pprof=")
+		}
+		return queryDiffPprof(ctx, qc, params, leftFrom, leftTo, rightFrom, rightTo, filePath)
+	}
+
+	leftTree, err := selectMergeTree(ctx, qc, params.ProfileType, left.Query, leftFrom, leftTo, params.MaxNodes)
+	if err != nil {
+		return errors.Wrap(err, "left")
+	}
+	rightTree, err := selectMergeTree(ctx, qc, params.ProfileType, right.Query, rightFrom, rightTo, params.MaxNodes)
+	if err != nil {
+		return errors.Wrap(err, "right")
+	}
+
+	type diffFunction struct {
+		name                  string
+		left, right           int64
+		leftPct, rightPct, pp float64
+	}
+	leftTotal, rightTotal := leftTree.Total(), rightTree.Total()
+	functions := make(map[string]*diffFunction)
+	for name, f := range topFunctions(leftTree) {
+		functions[name] = &diffFunction{name: name, left: f.self}
+	}
+	for name, f := range topFunctions(rightTree) {
+		d, ok := functions[name]
+		if !ok {
+			d = &diffFunction{name: name}
+			functions[name] = d
+		}
+		d.right = f.self
+	}
+	diff := make([]*diffFunction, 0, len(functions))
+	for _, d := range functions {
+		d.leftPct = percentage(d.left, leftTotal)
+		d.rightPct = percentage(d.right, rightTotal)
+		d.pp = d.rightPct - d.leftPct
+		diff = append(diff, d)
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		if a, b := math.Abs(diff[i].pp), math.Abs(diff[j].pp); a != b {
+			return a > b
+		}
+		return diff[i].name < diff[j].name
+	})
+	if params.Limit > 0 && len(diff) > params.Limit {
+		diff = diff[:params.Limit]
+	}
+
+	rows := make([][]interface{}, len(diff))
+	for i, d := range diff {
+		rows[i] = []interface{}{d.name, d.left, d.leftPct, d.right, d.rightPct, d.pp}
+	}
+	return writeTable(ctx, outputFlag, []string{"Function", "Left", "Left%", "Right", "Right%", "Diff%"}, rows)
+}
+
+// queryDiffPprof writes a pprof profile with the left side values negated,
+// which is the same as `go tool pprof -diff_base=left right`.
+func queryDiffPprof(ctx context.Context, qc querierv1connect.QuerierServiceClient, params *queryDiffParams, leftFrom, leftTo, rightFrom, rightTo time.Time, filePath string) (err error) {
+	left, right := params.sides()
+	selectProfile := func(query string, from, to time.Time) (*gprofile.Profile, error) {
+		resp, err := qc.SelectMergeProfile(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+			ProfileTypeID: params.ProfileType,
+			Start:         from.UnixMilli(),
+			End:           to.UnixMilli(),
+			LabelSelector: query,
+		}))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to query")
+		}
+		buf, err := resp.Msg.MarshalVT()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal protobuf")
+		}
+		p, err := gprofile.Parse(bytes.NewReader(buf))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse profile")
+		}
+		return p, nil
+	}
+
+	leftProfile, err := selectProfile(left.Query, leftFrom, leftTo)
+	if err != nil {
+		return errors.Wrap(err, "left")
+	}
+	rightProfile, err := selectProfile(right.Query, rightFrom, rightTo)
+	if err != nil {
+		return errors.Wrap(err, "right")
+	}
+	leftProfile.Scale(-1)
+	diff, err := gprofile.Merge([]*gprofile.Profile{rightProfile, leftProfile})
+	if err != nil {
+		return errors.Wrap(err, "failed to diff profiles")
+	}
+
+	// open new file, fail when the file already exists
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to create pprof file")
+	}
+	defer runutil.CloseWithErrCapture(&err, f, "failed to close pprof file")
+
+	// Write compresses the profile with gzip.
+	if err = diff.Write(f); err != nil {
+		return errors.Wrap(err, "failed to write pprof")
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package sd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+)
+
+const (
+	labelProcessPID  = "__meta_process_pid"
+	labelComm        = "comm"
+	labelExe         = "exe"
+	labelCGroupPath  = "cgroup_path"
+	labelSystemdUnit = "systemd_unit"
+)
+
+// ProcessDiscoveryOptions configures labelling of processes which do not
+// belong to any of the container targets. Such processes are discovered
+// directly from /proc and labeled with comm, exe, cgroup_path, systemd_unit
+// and __container_id__ on top of the default target labels.
+type ProcessDiscoveryOptions struct {
+	Enabled bool
+	// RelabelConfigs are applied to the discovered process labels in order.
+	// They are typically used to set service_name, but may also drop labels
+	// or whole processes. If service_name is still empty afterwards, it is
+	// inferred from the systemd unit or comm.
+	RelabelConfigs []*relabel.Config
+}
+
+// readLinkFS is implemented by file systems able to resolve symbolic links.
+// os.DirFS is not one of them, in which case the executable path is taken
+// from the process command line.
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// processTarget is the cached target of a process. The target is nil if
+// the process does not exist anymore, or has been dropped by relabeling.
+type processTarget struct {
+	target  *Target
+	dropped bool
+}
+
+func (tf *targetFinder) setProcessDiscovery(opts TargetsOptions) {
+	discovery := opts.ProcessDiscovery
+	if opts.TargetsOnly {
+		// Only the targets are profiled: the processes
+		// outside of them are not discovered.
+		discovery.Enabled = false
+	}
+	defaults := make(DiscoveryTarget, len(opts.DefaultTarget))
+	for k, v := range opts.DefaultTarget {
+		// service_name of the default target would shadow the inferred one.
+		if k == labelServiceName {
+			continue
+		}
+		defaults[k] = v
+	}
+	// The cached targets are kept unless the labels they were
+	// built with change, so that only new processes are discovered.
+	if !discovery.Enabled || !tf.processDiscovery.Enabled ||
+		!reflect.DeepEqual(discovery.RelabelConfigs, tf.processDiscovery.RelabelConfigs) ||
+		!reflect.DeepEqual(defaults, tf.processDefaults) {
+		tf.processCache.Purge()
+	}
+	tf.processDiscovery = discovery
+	tf.processDefaults = defaults
+	if discovery.Enabled {
+		tf.discoverProcesses()
+	}
+}
+
+// discoverProcesses enumerates /proc: the targets of the new processes are
+// added to the cache, and the processes that exited are removed from it.
+func (tf *targetFinder) discoverProcesses() {
+	entries, err := fs.ReadDir(tf.fs, "proc")
+	if err != nil {
+		_ = level.Error(tf.l).Log("msg", "failed to list processes", "err", err)
+		return
+	}
+	running := make(map[uint32]struct{}, len(entries))
+	discovered := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		pid, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		running[uint32(pid)] = struct{}{}
+		if tf.processCache.Contains(uint32(pid)) {
+			continue
+		}
+		if tf.findProcessTarget(uint32(pid)).target != nil {
+			discovered++
+		}
+	}
+	exited := 0
+	for _, pid := range tf.processCache.Keys() {
+		if _, ok := running[pid]; !ok {
+			tf.processCache.Remove(pid)
+			exited++
+		}
+	}
+	_ = level.Debug(tf.l).Log("msg", "discovered processes", "new", discovered, "exited", exited, "count", tf.processCache.Len())
+}
+
+func (tf *targetFinder) findProcessTarget(pid uint32) processTarget {
+	if t, ok := tf.processCache.Get(pid); ok {
+		return t
+	}
+	t := tf.newProcessTarget(pid)
+	tf.processCache.Add(pid, t)
+	return t
+}
+
+func (tf *targetFinder) newProcessTarget(pid uint32) processTarget {
+	discovered, ok := tf.discoverProcess(pid)
+	if !ok {
+		return processTarget{}
+	}
+	lset := make(map[string]string, len(tf.processDefaults)+len(discovered))
+	for k, v := range tf.processDefaults {
+		lset[k] = v
+	}
+	for k, v := range discovered {
+		lset[k] = v
+	}
+	relabeled, keep := relabel.Process(labels.FromMap(lset), tf.processDiscovery.RelabelConfigs...)
+	if !keep {
+		return processTarget{dropped: true}
+	}
+	target := DiscoveryTarget(relabeled.Map())
+	t, err := NewTarget(containerIDFromTarget(target), target)
+	if err != nil {
+		_ = level.Error(tf.l).Log(
+			"msg", "process target skipped",
+			"target", target.DebugString(),
+			"err", err,
+		)
+		return processTarget{}
+	}
+	return processTarget{target: t}
+}
+
+// discoverProcess reads the process labels from /proc/{pid}. It returns false
+// if the process does not exist anymore.
+func (tf *targetFinder) discoverProcess(pid uint32) (DiscoveryTarget, bool) {
+	comm, err := fs.ReadFile(tf.fs, fmt.Sprintf("proc/%d/comm", pid))
+	if err != nil {
+		return nil, false
+	}
+	target := DiscoveryTarget{
+		labelProcessPID: strconv.FormatUint(uint64(pid), 10),
+		labelComm:       string(bytes.TrimSpace(comm)),
+	}
+	if exe := tf.getProcessExe(pid); exe != "" {
+		target[labelExe] = exe
+	}
+	cgroupPath, cid := tf.getProcessCGroup(pid)
+	if cgroupPath != "" {
+		target[labelCGroupPath] = cgroupPath
+	}
+	if unit := getSystemdUnitFromCGroupPath(cgroupPath); unit != "" {
+		target[labelSystemdUnit] = unit
+	}
+	if cid != "" {
+		target[labelContainerID] = string(cid)
+	}
+	return target, true
+}
+
+func (tf *targetFinder) getProcessExe(pid uint32) string {
+	if rl, ok := tf.fs.(readLinkFS); ok {
+		exe, err := rl.ReadLink(fmt.Sprintf("proc/%d/exe", pid))
+		if err == nil {
+			return exe
+		}
+	}
+	cmdline, err := fs.ReadFile(tf.fs, fmt.Sprintf("proc/%d/cmdline", pid))
+	if err != nil {
+		return ""
+	}
+	if i := bytes.IndexByte(cmdline, 0); i >= 0 {
+		cmdline = cmdline[:i]
+	}
+	return string(cmdline)
+}
+
+// getProcessCGroup returns the cgroup path of the process and the container
+// ID if any. The unified (v2) hierarchy path is preferred, then the systemd
+// one, then the first listed hierarchy.
+func (tf *targetFinder) getProcessCGroup(pid uint32) (string, containerID) {
+	f, err := tf.fs.Open(fmt.Sprintf("proc/%d/cgroup", pid))
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	var (
+		cid         containerID
+		first       string
+		systemdPath string
+		unifiedPath string
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if cid == "" {
+			cid = containerID(getContainerIDFromCGroup(line))
+		}
+		parts := strings.SplitN(string(line), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			unifiedPath = parts[2]
+		case parts[1] == "name=systemd":
+			systemdPath = parts[2]
+		case first == "":
+			first = parts[2]
+		}
+	}
+	switch {
+	case unifiedPath != "":
+		return unifiedPath, cid
+	case systemdPath != "":
+		return systemdPath, cid
+	default:
+		return first, cid
+	}
+}
+
+// getSystemdUnitFromCGroupPath returns the innermost systemd service
+// the cgroup belongs to, e.g. "nginx.service" for
+// /system.slice/nginx.service.
+func getSystemdUnitFromCGroupPath(cgroupPath string) string {
+	for p := cgroupPath; p != "/" && p != "." && p != ""; p = path.Dir(p) {
+		if unit := path.Base(p); strings.HasSuffix(unit, ".service") {
+			return unit
+		}
+	}
+	return ""
+}
@@ -90,6 +90,14 @@ func inferServiceName(target DiscoveryTarget) string {
 	if dockerContainer != "" {
 		return dockerContainer
 	}
+	systemdUnit := target[labelSystemdUnit]
+	if systemdUnit != "" {
+		return strings.TrimSuffix(systemdUnit, ".service")
+	}
+	comm := target[labelComm]
+	if comm != "" {
+		return comm
+	}
 	return "unspecified"
 }
 
@@ -113,6 +121,7 @@ type TargetsOptions struct {
 	TargetsOnly        bool
 	DefaultTarget      DiscoveryTarget
 	ContainerCacheSize int
+	ProcessDiscovery   ProcessDiscoveryOptions
 }
 
 type targetFinder struct {
@@ -123,11 +132,16 @@ type targetFinder struct {
 	containerIDCache *lru.Cache[uint32, containerID]
 	defaultTarget    *Target
 	fs               fs.FS
+
+	processDiscovery ProcessDiscoveryOptions
+	processDefaults  DiscoveryTarget
+	processCache     *lru.Cache[uint32, processTarget]
 }
 
 func (tf *targetFinder) Update(args TargetsOptions) {
 	tf.setTargets(args)
 	tf.resizeContainerIDCache(args.ContainerCacheSize)
+	tf.setProcessDiscovery(args)
 }
 
 func NewTargetFinder(fs fs.FS, l log.Logger, options TargetsOptions) (TargetFinder, error) {
@@ -135,12 +149,18 @@ func NewTargetFinder(fs fs.FS, l log.Logger, options TargetsOptions) (TargetFind
 	if err != nil {
 		return nil, fmt.Errorf("containerIDCache create: %w", err)
 	}
+	processCache, err := lru.New[uint32, processTarget](options.ContainerCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("processCache create: %w", err)
+	}
 	res := &targetFinder{
 		l:                l,
 		containerIDCache: containerIDCache,
+		processCache:     processCache,
 		fs:               fs,
 	}
 	res.setTargets(options)
+	res.setProcessDiscovery(options)
 	return res, nil
 }
 
@@ -184,11 +204,23 @@ func (tf *targetFinder) setTargets(opts TargetsOptions) {
 	_ = level.Debug(tf.l).Log("msg", "created targets", "count", len(tf.cid2target))
 }
 
+// FindTarget returns the target of the process, or nil if the process
+// must not be profiled: it does not belong to any of the targets and
+// either TargetsOnly is set or it has been dropped by relabeling.
 func (tf *targetFinder) FindTarget(pid uint32) *Target {
 	res := tf.findTarget(pid)
 	if res != nil {
 		return res
 	}
+	if tf.processDiscovery.Enabled {
+		pt := tf.findProcessTarget(pid)
+		if pt.target != nil {
+			return pt.target
+		}
+		if pt.dropped {
+			return nil
+		}
+	}
 	return tf.defaultTarget
 }
 
@@ -205,14 +237,22 @@ func (tf *targetFinder) findTarget(pid uint32) *Target {
 
 func (tf *targetFinder) resizeContainerIDCache(size int) {
 	tf.containerIDCache.Resize(size)
+	tf.processCache.Resize(size)
 }
 
 func (tf *targetFinder) DebugInfo() []string {
-	debugTargets := make([]string, 0, len(tf.cid2target))
+	debugTargets := make([]string, 0, len(tf.cid2target)+tf.processCache.Len())
 	for _, target := range tf.cid2target {
 		_, ls := target.Labels()
 		debugTargets = append(debugTargets, ls.String())
 	}
+	for _, pt := range tf.processCache.Values() {
+		if pt.target == nil {
+			continue
+		}
+		_, ls := pt.target.Labels()
+		debugTargets = append(debugTargets, ls.String())
+	}
 	return debugTargets
 }
 
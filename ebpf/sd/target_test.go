@@ -8,6 +8,9 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/stretchr/testify/require"
 )
 
@@ -136,3 +139,99 @@ func TestTargetFinder(t *testing.T) {
 	target = tf.FindTarget(239)
 	require.Nil(t, target)
 }
+
+func TestProcessDiscovery(t *testing.T) {
+	fs, err := newMockFS()
+	require.NoError(t, err)
+	defer fs.rm()
+	require.NoError(t, fs.add("/proc/100/comm", []byte("nginx\n")))
+	require.NoError(t, fs.add("/proc/100/cmdline", []byte("/usr/sbin/nginx\x00-g\x00daemon off;\x00")))
+	require.NoError(t, fs.add("/proc/100/cgroup", []byte("0::/system.slice/nginx.service\n")))
+	require.NoError(t, fs.add("/proc/200/comm", []byte("java\n")))
+	require.NoError(t, fs.add("/proc/200/cmdline", []byte("/opt/jdk/bin/java\x00-jar\x00app.jar\x00")))
+	require.NoError(t, fs.add("/proc/200/cgroup",
+		[]byte("12:blkio:/docker/656959d9ee87a0b131c601ce9d9f8f76b1dda60e8608c503b5979d849cbdc714\n"+
+			"1:name=systemd:/docker/656959d9ee87a0b131c601ce9d9f8f76b1dda60e8608c503b5979d849cbdc714\n")))
+	require.NoError(t, fs.add("/proc/300/comm", []byte("sshd\n")))
+	require.NoError(t, fs.add("/proc/300/cgroup", []byte("0::/system.slice/ssh.service\n")))
+
+	options := TargetsOptions{
+		DefaultTarget:      map[string]string{"service_name": "default", "env": "prod"},
+		ContainerCacheSize: 1024,
+		ProcessDiscovery: ProcessDiscoveryOptions{
+			Enabled: true,
+			RelabelConfigs: []*relabel.Config{
+				{
+					SourceLabels: model.LabelNames{"exe"},
+					Regex:        relabel.MustNewRegexp(".*/(java)"),
+					TargetLabel:  "service_name",
+					Replacement:  "jvm/$1",
+					Action:       relabel.Replace,
+				},
+				{
+					SourceLabels: model.LabelNames{"comm"},
+					Regex:        relabel.MustNewRegexp("sshd"),
+					Action:       relabel.Drop,
+				},
+			},
+		},
+	}
+
+	tf, err := NewTargetFinder(fs.root, util.TestLogger(t), options)
+	require.NoError(t, err)
+	require.Len(t, tf.DebugInfo(), 2)
+
+	target := tf.FindTarget(100)
+	require.NotNil(t, target)
+	require.Equal(t, labels.FromMap(map[string]string{
+		"__name__":     "process_cpu",
+		"service_name": "nginx",
+		"env":          "prod",
+		"comm":         "nginx",
+		"exe":          "/usr/sbin/nginx",
+		"cgroup_path":  "/system.slice/nginx.service",
+		"systemd_unit": "nginx.service",
+	}), target.labels)
+
+	target = tf.FindTarget(200)
+	require.NotNil(t, target)
+	require.Equal(t, "jvm/java", target.ServiceName())
+	require.Equal(t, "656959d9ee87a0b131c601ce9d9f8f76b1dda60e8608c503b5979d849cbdc714", target.labels.Get(labelContainerID))
+	require.Equal(t, "/docker/656959d9ee87a0b131c601ce9d9f8f76b1dda60e8608c503b5979d849cbdc714", target.labels.Get(labelCGroupPath))
+
+	// The process dropped by relabeling is not profiled.
+	target = tf.FindTarget(300)
+	require.Nil(t, target)
+
+	target = tf.FindTarget(239)
+	require.NotNil(t, target)
+	require.Equal(t, "default", target.ServiceName())
+
+	// Only the new processes are discovered, the exited ones are evicted.
+	cached := tf.FindTarget(200)
+	require.NoError(t, fs.add("/proc/400/comm", []byte("redis\n")))
+	require.NoError(t, os.RemoveAll(filepath.Join(fs.rootPath, "proc/100")))
+	tf.Update(options)
+	require.Same(t, cached, tf.FindTarget(200))
+	require.Equal(t, "redis", tf.FindTarget(400).ServiceName())
+	require.Len(t, tf.DebugInfo(), 2)
+
+	// The processes outside of the targets are not profiled.
+	options.TargetsOnly = true
+	tf.Update(options)
+	require.Nil(t, tf.FindTarget(400))
+	require.Empty(t, tf.DebugInfo())
+}
+
+func TestSystemdUnitFromCGroupPath(t *testing.T) {
+	testcases := map[string]string{
+		"/system.slice/nginx.service":                                       "nginx.service",
+		"/user.slice/user-1000.slice/user@1000.service/app.slice/a.service": "a.service",
+		"/user.slice/user-1000.slice/session-2.scope":                       "",
+		"/": "",
+		"":  "",
+	}
+	for cgroupPath, expected := range testcases {
+		require.Equal(t, expected, getSystemdUnitFromCGroupPath(cgroupPath), cgroupPath)
+	}
+}
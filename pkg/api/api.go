@@ -49,6 +49,12 @@ type Config struct {
 	HTTPAuthMiddleware middleware.Interface `yaml:"-"`
 	GrpcAuthMiddleware connect.Option       `yaml:"-"`
 	BaseURL            string               `yaml:"base-url"`
+
+	IngestBatch pyroscope.BatchConfig `yaml:"ingest_batch"`
+}
+
+func (cfg *Config) Validate() error {
+	return cfg.IngestBatch.Validate()
 }
 
 type API struct {
@@ -197,7 +203,7 @@ func (a *API) RegisterOverridesExporter(oe *exporter.OverridesExporter) {
 
 // RegisterDistributor registers the endpoints associated with the distributor.
 func (a *API) RegisterDistributor(d *distributor.Distributor) {
-	pyroscopeHandler := pyroscope.NewPyroscopeIngestHandler(d, a.logger)
+	pyroscopeHandler := pyroscope.NewPyroscopeIngestHandler(d, a.cfg.IngestBatch, a.logger)
 	a.RegisterRoute("/ingest", pyroscopeHandler, true, true, "POST")
 	a.RegisterRoute("/pyroscope/ingest", pyroscopeHandler, true, true, "POST")
 	pushv1connect.RegisterPusherServiceHandler(a.server.HTTP, d, a.grpcAuthMiddleware)
@@ -224,15 +230,21 @@ func (a *API) RegisterRing(r http.Handler) {
 }
 
 // RegisterQuerier registers the endpoints associated with the querier.
-func (a *API) RegisterQuerier(svc querierv1connect.QuerierServiceHandler) {
-	querierv1connect.RegisterQuerierServiceHandler(a.server.HTTP, svc, a.grpcAuthMiddleware, a.grpcLogMiddleware)
+func (a *API) RegisterQuerier(svc querierv1connect.QuerierServiceHandler, interceptors ...connect.Interceptor) {
+	querierv1connect.RegisterQuerierServiceHandler(a.server.HTTP, svc, a.grpcAuthMiddleware, a.grpcLogMiddleware, connect.WithInterceptors(interceptors...))
 }
 
-func (a *API) RegisterPyroscopeHandlers(client querierv1connect.QuerierServiceClient) {
+func (a *API) RegisterPyroscopeHandlers(client querierv1connect.QuerierServiceHandler, middlewares ...middleware.Interface) {
 	handlers := querier.NewHTTPHandlers(client)
-	a.RegisterRoute("/pyroscope/render", http.HandlerFunc(handlers.Render), true, true, "GET")
-	a.RegisterRoute("/pyroscope/render-diff", http.HandlerFunc(handlers.RenderDiff), true, true, "GET")
-	a.RegisterRoute("/pyroscope/label-values", http.HandlerFunc(handlers.LabelValues), true, true, "GET")
+	wrap := func(h http.HandlerFunc) http.Handler { return middleware.Merge(middlewares...).Wrap(h) }
+	a.RegisterRoute("/pyroscope/render", wrap(handlers.Render), true, true, "GET")
+	a.RegisterRoute("/pyroscope/render-diff", wrap(handlers.RenderDiff), true, true, "GET")
+	a.RegisterRoute("/pyroscope/label-values", wrap(handlers.LabelValues), true, true, "GET")
+	a.RegisterRoute("/pyroscope/label-names", wrap(handlers.LabelNames), true, true, "GET")
+	a.RegisterRoute("/pyroscope/api/apps", wrap(handlers.Apps), true, true, "GET")
+	a.RegisterRoute("/pyroscope/merge", wrap(handlers.Merge), true, true, "POST")
+	a.RegisterRoute("/pyroscope/exemplars:merge", wrap(handlers.Merge), true, true, "POST")
+	a.RegisterRoute("/pyroscope/exemplars:query", wrap(handlers.ExemplarsQuery), true, true, "GET")
 }
 
 // RegisterIngester registers the endpoints associated with the ingester.
@@ -250,6 +262,7 @@ func (a *API) RegisterStoreGateway(svc *storegateway.StoreGateway) {
 	a.RegisterRoute("/store-gateway/ring", http.HandlerFunc(svc.RingHandler), false, true, "GET", "POST")
 	a.RegisterRoute("/store-gateway/tenants", http.HandlerFunc(svc.TenantsHandler), false, true, "GET")
 	a.RegisterRoute("/store-gateway/tenant/{tenant}/blocks", http.HandlerFunc(svc.BlocksHandler), false, true, "GET")
+	a.RegisterRoute("/store-gateway/tenant/{tenant}/cardinality", http.HandlerFunc(svc.CardinalityHandler), false, true, "GET")
 }
 
 // RegisterQueryFrontend registers the endpoints associated with the query frontend.
@@ -266,4 +279,5 @@ func (a *API) RegisterQueryScheduler(s *scheduler.Scheduler) {
 // RegisterFlags registers api-related flags.
 func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
 	fs.StringVar(&cfg.BaseURL, "api.base-url", "", "base URL for when the server is behind a reverse proxy with a different path")
+	cfg.IngestBatch.RegisterFlagsWithPrefix("api.ingest-batch", fs)
 }
@@ -0,0 +1,300 @@
+// Package costattribution accounts the usage of a tenant by the values of a
+// configurable set of labels, e.g. service_name or team, so that the cost of
+// profiling can be charged back within a shared tenant.
+package costattribution
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/usagestats"
+)
+
+const (
+	// MissingValue is the value attributed to a label the series does not have.
+	MissingValue = "__missing__"
+	// OverflowValue is the value attributed to all the labels once the
+	// maximum number of label value combinations is reached.
+	OverflowValue = "__overflow__"
+
+	keySeparator = "\xff"
+)
+
+type Limits interface {
+	CostAttributionLabels(tenantID string) []string
+	MaxCostAttributionCardinality(tenantID string) int
+}
+
+var (
+	receivedBytesHelp    = "The number of uncompressed bytes of the profiles received by the distributor, by cost attribution labels."
+	receivedProfilesHelp = "The number of profiles received by the distributor, by cost attribution labels."
+	storedBytesHelp      = "The number of uncompressed bytes of the profiles ingested by the ingester, by cost attribution labels."
+	activeSeriesHelp     = "The number of active series in the ingester, by cost attribution labels."
+)
+
+// The usage statistics report only aggregates of all the tenants: the names
+// of the tenants and the values of their labels, e.g. service names, must
+// not be included in the anonymous usage report.
+var (
+	trackedValuesStats    = usagestats.NewInt("cost_attribution_tracked_values")
+	receivedBytesStats    = usagestats.NewCounter("cost_attribution_received_bytes")
+	receivedProfilesStats = usagestats.NewCounter("cost_attribution_received_profiles")
+	storedBytesStats      = usagestats.NewCounter("cost_attribution_stored_bytes")
+	activeSeriesStats     = usagestats.NewInt("cost_attribution_active_series")
+)
+
+// Manager holds the cost attribution trackers of the tenants and exports
+// their statistics as Prometheus metrics.
+type Manager struct {
+	limits Limits
+
+	mu       sync.RWMutex
+	trackers map[string]*Tracker
+}
+
+func NewManager(limits Limits, reg prometheus.Registerer) *Manager {
+	m := &Manager{
+		limits:   limits,
+		trackers: make(map[string]*Tracker),
+	}
+	if reg != nil {
+		reg.MustRegister(m)
+	}
+	return m
+}
+
+// Tracker returns the cost attribution tracker of the tenant, or nil if no
+// cost attribution labels are configured for it or m is nil. The tracker is recreated,
+// and its statistics are reset, if the tenant's configuration changes.
+func (m *Manager) Tracker(tenantID string) *Tracker {
+	if m == nil {
+		return nil
+	}
+	names := m.limits.CostAttributionLabels(tenantID)
+	maxCardinality := m.limits.MaxCostAttributionCardinality(tenantID)
+	m.mu.RLock()
+	t, ok := m.trackers[tenantID]
+	m.mu.RUnlock()
+	if ok && t.matches(names, maxCardinality) {
+		return t
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok = m.trackers[tenantID]; ok && t.matches(names, maxCardinality) {
+		return t
+	}
+	if ok {
+		t.release()
+	}
+	if len(names) == 0 {
+		delete(m.trackers, tenantID)
+		return nil
+	}
+	t = newTracker(tenantID, names, maxCardinality)
+	m.trackers[tenantID] = t
+	return t
+}
+
+// Describe implements prometheus.Collector. The label names of the metrics
+// differ per tenant: the collector is unchecked, therefore no descriptor is
+// sent.
+func (m *Manager) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (m *Manager) Collect(ch chan<- prometheus.Metric) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.trackers {
+		t.collect(ch)
+	}
+}
+
+// Tracker accounts the usage of a tenant by the values of the cost
+// attribution labels. A nil Tracker accounts nothing.
+type Tracker struct {
+	tenantID       string
+	names          []string
+	maxCardinality int
+
+	receivedBytes    *prometheus.Desc
+	receivedProfiles *prometheus.Desc
+	storedBytes      *prometheus.Desc
+	activeSeries     *prometheus.Desc
+
+	mu       sync.Mutex
+	stats    map[string]*attributionStats
+	released bool
+}
+
+type attributionStats struct {
+	values []string
+
+	receivedBytes    float64
+	receivedProfiles float64
+	storedBytes      float64
+	activeSeries     float64
+}
+
+func newTracker(tenantID string, names []string, maxCardinality int) *Tracker {
+	variableLabels := append([]string{"tenant"}, names...)
+	return &Tracker{
+		tenantID:       tenantID,
+		names:          append([]string(nil), names...),
+		maxCardinality: maxCardinality,
+		stats:          make(map[string]*attributionStats),
+
+		receivedBytes:    prometheus.NewDesc("pyroscope_cost_attribution_received_bytes_total", receivedBytesHelp, variableLabels, nil),
+		receivedProfiles: prometheus.NewDesc("pyroscope_cost_attribution_received_profiles_total", receivedProfilesHelp, variableLabels, nil),
+		storedBytes:      prometheus.NewDesc("pyroscope_cost_attribution_stored_bytes_total", storedBytesHelp, variableLabels, nil),
+		activeSeries:     prometheus.NewDesc("pyroscope_cost_attribution_active_series", activeSeriesHelp, variableLabels, nil),
+	}
+}
+
+func (t *Tracker) matches(names []string, maxCardinality int) bool {
+	if t.maxCardinality != maxCardinality || len(t.names) != len(names) {
+		return false
+	}
+	for i := range names {
+		if t.names[i] != names[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// IncrementReceived accounts profiles of the given total uncompressed size,
+// received for the series.
+func (t *Tracker) IncrementReceived(lbs phlaremodel.Labels, profiles, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.statsFor(t.key(lbs))
+	s.receivedBytes += float64(bytes)
+	s.receivedProfiles += float64(profiles)
+	receivedBytesStats.Inc(bytes)
+	receivedProfilesStats.Inc(profiles)
+}
+
+// IncrementStored accounts a profile of the given uncompressed size,
+// ingested for the series.
+func (t *Tracker) IncrementStored(lbs phlaremodel.Labels, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.statsFor(t.key(lbs))
+	s.storedBytes += float64(bytes)
+	storedBytesStats.Inc(bytes)
+}
+
+// AddActiveSeries accounts a new active series. The returned key must be
+// passed to RemoveActiveSeries once the series is no longer active.
+func (t *Tracker) AddActiveSeries(lbs phlaremodel.Labels) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.statsFor(t.key(lbs))
+	s.activeSeries++
+	if !t.released {
+		activeSeriesStats.Add(1)
+	}
+	return s.key()
+}
+
+// RemoveActiveSeries accounts a series that is no longer active. Keys
+// unknown to the tracker, e.g. issued before the tenant's configuration
+// changed, are ignored.
+func (t *Tracker) RemoveActiveSeries(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.stats[key]; ok && s.activeSeries > 0 {
+		s.activeSeries--
+		if !t.released {
+			activeSeriesStats.Add(-1)
+		}
+	}
+}
+
+// release removes the label values and the active series of the tracker
+// from the usage statistics, once it is replaced. The tracker may still be
+// used by requests in flight: their updates are not accounted.
+func (t *Tracker) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.released = true
+	trackedValuesStats.Add(-int64(len(t.stats)))
+	for _, s := range t.stats {
+		activeSeriesStats.Add(-int64(s.activeSeries))
+	}
+}
+
+// key returns the values of the cost attribution labels of the series.
+func (t *Tracker) key(lbs phlaremodel.Labels) []string {
+	values := make([]string, len(t.names))
+	for i, name := range t.names {
+		if values[i] = lbs.Get(name); values[i] == "" {
+			values[i] = MissingValue
+		}
+	}
+	return values
+}
+
+// statsFor returns the statistics of the label values. Once the maximum
+// cardinality is reached, new label values are accounted as overflow.
+func (t *Tracker) statsFor(values []string) *attributionStats {
+	k := strings.Join(values, keySeparator)
+	if s, ok := t.stats[k]; ok {
+		return s
+	}
+	if t.maxCardinality > 0 && len(t.stats) >= t.maxCardinality {
+		for i := range values {
+			values[i] = OverflowValue
+		}
+		k = strings.Join(values, keySeparator)
+		if s, ok := t.stats[k]; ok {
+			return s
+		}
+	}
+	s := &attributionStats{values: values}
+	t.stats[k] = s
+	if !t.released {
+		trackedValuesStats.Add(1)
+	}
+	return s
+}
+
+func (s *attributionStats) key() string {
+	return strings.Join(s.values, keySeparator)
+}
+
+func (t *Tracker) collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Only the statistics the component accounts are exported: the
+	// distributor and the ingester share the metrics registry when run
+	// in the same process.
+	for _, s := range t.stats {
+		values := append([]string{t.tenantID}, s.values...)
+		if s.receivedProfiles > 0 {
+			ch <- prometheus.MustNewConstMetric(t.receivedBytes, prometheus.CounterValue, s.receivedBytes, values...)
+			ch <- prometheus.MustNewConstMetric(t.receivedProfiles, prometheus.CounterValue, s.receivedProfiles, values...)
+		}
+		if s.storedBytes > 0 {
+			ch <- prometheus.MustNewConstMetric(t.storedBytes, prometheus.CounterValue, s.storedBytes, values...)
+		}
+		if s.activeSeries > 0 {
+			ch <- prometheus.MustNewConstMetric(t.activeSeries, prometheus.GaugeValue, s.activeSeries, values...)
+		}
+	}
+}
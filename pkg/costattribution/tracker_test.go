@@ -0,0 +1,81 @@
+package costattribution
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+type fakeLimits struct {
+	labels         map[string][]string
+	maxCardinality int
+}
+
+func (f *fakeLimits) CostAttributionLabels(tenantID string) []string {
+	return f.labels[tenantID]
+}
+
+func (f *fakeLimits) MaxCostAttributionCardinality(string) int {
+	return f.maxCardinality
+}
+
+func Test_Tracker(t *testing.T) {
+	limits := &fakeLimits{
+		labels:         map[string][]string{"tenant-a": {"service_name", "team"}},
+		maxCardinality: 2,
+	}
+	trackedValues, activeSeries := trackedValuesStats.Value(), activeSeriesStats.Value()
+	receivedBytes := receivedBytesStats.Value()["total"].(int64)
+	reg := prometheus.NewPedanticRegistry()
+	m := NewManager(limits, reg)
+	require.Nil(t, m.Tracker("tenant-b"))
+
+	tracker := m.Tracker("tenant-a")
+	require.Same(t, tracker, m.Tracker("tenant-a"))
+	tracker.IncrementReceived(phlaremodel.LabelsFromStrings("service_name", "api", "team", "x"), 2, 100)
+	tracker.IncrementReceived(phlaremodel.LabelsFromStrings("service_name", "api", "team", "x"), 1, 50)
+	tracker.IncrementReceived(phlaremodel.LabelsFromStrings("service_name", "db"), 1, 10)
+	// The cardinality limit is reached.
+	tracker.IncrementReceived(phlaremodel.LabelsFromStrings("service_name", "web", "team", "y"), 1, 20)
+	tracker.IncrementStored(phlaremodel.LabelsFromStrings("service_name", "api", "team", "x"), 300)
+	key := tracker.AddActiveSeries(phlaremodel.LabelsFromStrings("service_name", "db"))
+	tracker.AddActiveSeries(phlaremodel.LabelsFromStrings("service_name", "api", "team", "x"))
+	tracker.RemoveActiveSeries(key)
+	tracker.RemoveActiveSeries(key)
+
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP pyroscope_cost_attribution_active_series The number of active series in the ingester, by cost attribution labels.
+# TYPE pyroscope_cost_attribution_active_series gauge
+pyroscope_cost_attribution_active_series{service_name="api",team="x",tenant="tenant-a"} 1
+# HELP pyroscope_cost_attribution_received_bytes_total The number of uncompressed bytes of the profiles received by the distributor, by cost attribution labels.
+# TYPE pyroscope_cost_attribution_received_bytes_total counter
+pyroscope_cost_attribution_received_bytes_total{service_name="__overflow__",team="__overflow__",tenant="tenant-a"} 20
+pyroscope_cost_attribution_received_bytes_total{service_name="api",team="x",tenant="tenant-a"} 150
+pyroscope_cost_attribution_received_bytes_total{service_name="db",team="__missing__",tenant="tenant-a"} 10
+# HELP pyroscope_cost_attribution_received_profiles_total The number of profiles received by the distributor, by cost attribution labels.
+# TYPE pyroscope_cost_attribution_received_profiles_total counter
+pyroscope_cost_attribution_received_profiles_total{service_name="__overflow__",team="__overflow__",tenant="tenant-a"} 1
+pyroscope_cost_attribution_received_profiles_total{service_name="api",team="x",tenant="tenant-a"} 3
+pyroscope_cost_attribution_received_profiles_total{service_name="db",team="__missing__",tenant="tenant-a"} 1
+# HELP pyroscope_cost_attribution_stored_bytes_total The number of uncompressed bytes of the profiles ingested by the ingester, by cost attribution labels.
+# TYPE pyroscope_cost_attribution_stored_bytes_total counter
+pyroscope_cost_attribution_stored_bytes_total{service_name="api",team="x",tenant="tenant-a"} 300
+`)))
+
+	// Only the aggregates of all the tenants are reported in the usage stats.
+	require.Equal(t, trackedValues+3, trackedValuesStats.Value())
+	require.Equal(t, activeSeries+1, activeSeriesStats.Value())
+	require.Equal(t, receivedBytes+180, receivedBytesStats.Value()["total"].(int64))
+
+	// Changing the labels resets the statistics.
+	limits.labels["tenant-a"] = []string{"service_name"}
+	require.NotSame(t, tracker, m.Tracker("tenant-a"))
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader("")))
+	require.Equal(t, trackedValues, trackedValuesStats.Value())
+	require.Equal(t, activeSeries, activeSeriesStats.Value())
+}
@@ -31,6 +31,7 @@ import (
 	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/clientpool"
+	"github.com/grafana/pyroscope/pkg/costattribution"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/tenant"
@@ -96,9 +97,12 @@ type Distributor struct {
 	bytesReceivedStats      *usagestats.Statistics
 	bytesReceivedTotalStats *usagestats.Counter
 	profileReceivedStats    *usagestats.Counter
+
+	costAttribution *costattribution.Manager
 }
 
 type Limits interface {
+	costattribution.Limits
 	IngestionRateBytes(tenantID string) float64
 	IngestionBurstSizeBytes(tenantID string) int
 	IngestionTenantShardSize(tenantID string) int
@@ -130,6 +134,7 @@ func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactor
 		bytesReceivedStats:      usagestats.NewStatistics("distributor_bytes_received"),
 		bytesReceivedTotalStats: usagestats.NewCounter("distributor_bytes_received_total"),
 		profileReceivedStats:    usagestats.NewCounter("distributor_profiles_received"),
+		costAttribution:         costattribution.NewManager(limits, reg),
 	}
 	var err error
 
@@ -204,6 +209,7 @@ func (d *Distributor) Push(ctx context.Context, req *connect.Request[pushv1.Push
 		}
 		keys = append(keys, TokenFor(tenantID, labelsString(series.Labels)))
 		profName := phlaremodel.Labels(series.Labels).Get(ProfileName)
+		var seriesUncompressedBytes int64
 		for _, raw := range series.Samples {
 			usagestats.NewCounter(fmt.Sprintf("distributor_profile_type_%s_received", profName)).Inc(1)
 			d.profileReceivedStats.Inc(1)
@@ -218,6 +224,7 @@ func (d *Distributor) Push(ctx context.Context, req *connect.Request[pushv1.Push
 			d.metrics.receivedDecompressedBytes.WithLabelValues(profName, tenantID).Observe(float64(p.SizeBytes()))
 			d.metrics.receivedSamples.WithLabelValues(profName, tenantID).Observe(float64(len(p.Sample)))
 			totalPushUncompressedBytes += int64(p.SizeBytes())
+			seriesUncompressedBytes += int64(p.SizeBytes())
 
 			if err := validation.ValidateProfile(d.limits, tenantID, p.Profile, p.SizeBytes(), phlaremodel.Labels(series.Labels)); err != nil {
 				validation.DiscardedProfiles.WithLabelValues(string(validation.ReasonOf(err)), tenantID).Add(float64(totalProfiles))
@@ -225,6 +232,10 @@ func (d *Distributor) Push(ctx context.Context, req *connect.Request[pushv1.Push
 				p.Close()
 				return nil, connect.NewError(connect.CodeInvalidArgument, err)
 			}
+			if err := addSpanIDLabel(p.Profile, raw); err != nil {
+				p.Close()
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
 
 			p.Normalize()
 			symbolsSize, samplesSize := profileSizeBytes(p.Profile)
@@ -242,7 +253,7 @@ func (d *Distributor) Push(ctx context.Context, req *connect.Request[pushv1.Push
 			// generate a unique profile ID before pushing.
 			raw.ID = uuid.NewString()
 		}
-		profiles = append(profiles, &profileTracker{profile: series})
+		profiles = append(profiles, &profileTracker{profile: series, uncompressedBytes: seriesUncompressedBytes})
 	}
 
 	if totalProfiles == 0 {
@@ -267,6 +278,12 @@ func (d *Distributor) Push(ctx context.Context, req *connect.Request[pushv1.Push
 		)
 	}
 
+	// account the accepted profiles to the cost attribution labels
+	costAttribution := d.costAttribution.Tracker(tenantID)
+	for _, p := range profiles {
+		costAttribution.IncrementReceived(p.profile.Labels, int64(len(p.profile.Samples)), p.uncompressedBytes)
+	}
+
 	const maxExpectedReplicationSet = 5 // typical replication factor 3 plus one for inactive plus one for luck
 	var descs [maxExpectedReplicationSet]ring.InstanceDesc
 
@@ -314,6 +331,38 @@ func (d *Distributor) Push(ctx context.Context, req *connect.Request[pushv1.Push
 	}
 }
 
+// errTraceIDNotSupported is returned for raw samples with a trace ID: the
+// samples are not indexed by trace, they are linked to traces by span only.
+var errTraceIDNotSupported = errors.New("trace ids are not supported, only span ids are")
+
+// addSpanIDLabel writes the span ID of the raw sample as the span_id label
+// of every sample of the profile that does not have it already. The ID is
+// cleared from the raw sample once written.
+func addSpanIDLabel(p *googlev1.Profile, raw *pushv1.RawSample) error {
+	if raw.TraceId != "" {
+		return errTraceIDNotSupported
+	}
+	if raw.SpanId == "" {
+		return nil
+	}
+	if _, err := phlaremodel.ParseSpanID(raw.SpanId); err != nil {
+		return err
+	}
+	key := int64(len(p.StringTable))
+	p.StringTable = append(p.StringTable, phlaremodel.SpanIDLabelName, raw.SpanId)
+samples:
+	for _, s := range p.Sample {
+		for _, l := range s.Label {
+			if l.Key >= 0 && l.Key < key && p.StringTable[l.Key] == phlaremodel.SpanIDLabelName {
+				continue samples
+			}
+		}
+		s.Label = append(s.Label, &googlev1.Label{Key: key, Str: key + 1})
+	}
+	raw.SpanId = ""
+	return nil
+}
+
 // profileSizeBytes returns the size of symbols and samples in bytes.
 func profileSizeBytes(p *googlev1.Profile) (symbols, samples int64) {
 	fullSize := p.SizeVT()
@@ -417,11 +466,12 @@ func (d *Distributor) HealthyInstancesCount() int {
 }
 
 type profileTracker struct {
-	profile     *pushv1.RawProfileSeries
-	minSuccess  int
-	maxFailures int
-	succeeded   atomic.Int32
-	failed      atomic.Int32
+	profile           *pushv1.RawProfileSeries
+	uncompressedBytes int64
+	minSuccess        int
+	maxFailures       int
+	succeeded         atomic.Int32
+	failed            atomic.Int32
 }
 
 type pushTracker struct {
@@ -30,6 +30,7 @@ import (
 	"github.com/grafana/pyroscope/api/gen/proto/go/push/v1/pushv1connect"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/clientpool"
+	phlarepprof "github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/testhelper"
 	"github.com/grafana/pyroscope/pkg/validation"
@@ -82,6 +83,62 @@ func Test_ConnectPush(t *testing.T) {
 	require.Equal(t, 3, len(ing.requests[0].Series))
 }
 
+func Test_PushTraceContext(t *testing.T) {
+	ing := newFakeIngester(t, false)
+	d, err := New(Config{
+		DistributorRing: ringConfig,
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "foo"},
+	}, 3), func(addr string) (client.PoolClient, error) {
+		return ing, nil
+	}, newOverrides(t), nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	newRequest := func(traceID, spanID string) *connect.Request[pushv1.PushRequest] {
+		return connect.NewRequest(&pushv1.PushRequest{
+			Series: []*pushv1.RawProfileSeries{{
+				Labels: []*typesv1.LabelPair{
+					{Name: phlaremodel.LabelNameServiceName, Value: "svc"},
+					{Name: "__name__", Value: "cpu"},
+				},
+				Samples: []*pushv1.RawSample{{
+					RawProfile: testProfile(t),
+					TraceId:    traceID,
+					SpanId:     spanID,
+				}},
+			}},
+		})
+	}
+	ctx := tenant.InjectTenantID(context.Background(), "foo")
+	_, err = d.Push(ctx, newRequest("", spanID))
+	require.NoError(t, err)
+
+	raw := ing.requests[0].Series[0].Samples[0]
+	require.Empty(t, raw.TraceId)
+	require.Empty(t, raw.SpanId)
+	p, err := phlarepprof.RawFromBytes(raw.RawProfile)
+	require.NoError(t, err)
+	defer p.Close()
+	require.NotEmpty(t, p.Sample)
+	for _, s := range p.Sample {
+		labels := make(map[string]string)
+		for _, l := range s.Label {
+			labels[p.StringTable[l.Key]] = p.StringTable[l.Str]
+		}
+		require.Equal(t, spanID, labels[phlaremodel.SpanIDLabelName])
+	}
+
+	_, err = d.Push(ctx, newRequest("", "not-a-span"))
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	// Trace IDs are not supported.
+	_, err = d.Push(ctx, newRequest(traceID, spanID))
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
 func Test_Replication(t *testing.T) {
 	ingesters := map[string]*fakeIngester{
 		"1": newFakeIngester(t, false),
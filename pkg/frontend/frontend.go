@@ -50,6 +50,9 @@ type Config struct {
 	Addr string `yaml:"address" category:"advanced"`
 	Port int    `yaml:"-"`
 
+	LogQueriesLongerThan        time.Duration `yaml:"log_queries_longer_than"`
+	LogQueriesReadBytesMoreThan uint64        `yaml:"log_queries_read_bytes_more_than" category:"advanced"`
+
 	// This configuration is injected internally.
 	QuerySchedulerDiscovery schedulerdiscovery.Config `yaml:"-"`
 	MaxLoopDuration         time.Duration             `yaml:"-"`
@@ -62,6 +65,9 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	f.Var((*flagext.StringSlice)(&cfg.InfNames), "query-frontend.instance-interface-names", "List of network interface names to look up when finding the instance IP address. This address is sent to query-scheduler and querier, which uses it to send the query response back to query-frontend.")
 	f.StringVar(&cfg.Addr, "query-frontend.instance-addr", "", "IP address to advertise to the querier (via scheduler) (default is auto-detected from network interfaces).")
 
+	f.DurationVar(&cfg.LogQueriesLongerThan, "query-frontend.log-queries-longer-than", 0, "Log queries that are slower than the specified duration. Set to 0 to disable. Set to < 0 to enable on all queries.")
+	f.Uint64Var(&cfg.LogQueriesReadBytesMoreThan, "query-frontend.log-queries-read-bytes-more-than", 0, "Log queries that read more than the specified number of bytes. Set to 0 to disable.")
+
 	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("query-frontend.grpc-client-config", f)
 }
 
@@ -95,6 +101,10 @@ type Frontend struct {
 
 type Limits interface {
 	QuerySplitDuration(string) time.Duration
+	QuerySplitMaxNodes(string) int
+	QuerySplitShards(string) int
+	BatchQueriesLongerThan(string) time.Duration
+	BatchQueriesSeriesHours(string) int
 	MaxQueryParallelism(string) int
 	MaxQueryLength(tenantID string) time.Duration
 	MaxQueryLookback(tenantID string) time.Duration
@@ -209,6 +219,8 @@ func (f *Frontend) RoundTripGRPC(ctx context.Context, req *httpgrpc.HTTPRequest)
 		}
 	}
 
+	setRequestPriority(ctx, req)
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -23,31 +23,21 @@ func (f *Frontend) Diff(ctx context.Context,
 
 	var left, right *phlaremodel.Tree
 	g.Go(func() error {
-		resp, err := f.SelectMergeStacktraces(ctx, connect.NewRequest(c.Msg.Left))
-		if err != nil {
-			return err
-		}
-		m := phlaremodel.NewFlameGraphMerger()
-		m.MergeFlameGraph(resp.Msg.Flamegraph)
-		left = m.Tree()
+		var err error
+		left, err = f.selectMergeTree(ctx, connect.NewRequest(c.Msg.Left))
 		return err
 	})
 	g.Go(func() error {
-		resp, err := f.SelectMergeStacktraces(ctx, connect.NewRequest(c.Msg.Right))
-		if err != nil {
-			return err
-		}
-		m := phlaremodel.NewFlameGraphMerger()
-		m.MergeFlameGraph(resp.Msg.Flamegraph)
-		right = m.Tree()
+		var err error
+		right, err = f.selectMergeTree(ctx, connect.NewRequest(c.Msg.Right))
 		return err
 	})
 	if err := g.Wait(); err != nil {
 		return nil, err
 	}
 
-	maxNodes := int(math.Max(c.Msg.Left.GetMaxNodes(), c.Msg.Right.GetMaxNodes()))
-	diff, err := phlaremodel.NewFlamegraphDiff(left, right, maxNodes)
+	maxNodes := maxNodesOrDefault(math.Max(c.Msg.Left.GetMaxNodes(), c.Msg.Right.GetMaxNodes()))
+	diff, err := phlaremodel.NewFlamegraphDiff(left, right, int(maxNodes))
 	if err != nil {
 		return nil, connect.NewError(http.StatusBadRequest, err)
 	}
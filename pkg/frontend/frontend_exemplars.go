@@ -0,0 +1,50 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/common/model"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func (f *Frontend) SelectExemplars(ctx context.Context, c *connect.Request[querierv1.SelectExemplarsRequest]) (*connect.Response[querierv1.SelectExemplarsResponse], error) {
+	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectExemplarsProcedure)
+	// The time range is set before the query is sent, so that the
+	// heatmaps of the tenants of a federated query are aligned.
+	if c.Msg.End == 0 {
+		c.Msg.End = int64(model.Now())
+	}
+	if c.Msg.Start == 0 {
+		c.Msg.Start = int64(model.Time(c.Msg.End).Add(-time.Hour))
+	}
+	if tenantIDs, ok := federatedTenantIDs(ctx); ok {
+		return f.federatedSelectExemplars(ctx, tenantIDs, c)
+	}
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, connect.NewError(http.StatusBadRequest, err)
+	}
+	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
+	if err != nil {
+		return nil, connect.NewError(http.StatusBadRequest, err)
+	}
+	if validated.IsEmpty {
+		return connect.NewResponse(&querierv1.SelectExemplarsResponse{}), nil
+	}
+	c.Msg.Start = int64(validated.Start)
+	c.Msg.End = int64(validated.End)
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      validated.Interval,
+	})
+	return connectgrpc.RoundTripUnary[querierv1.SelectExemplarsRequest, querierv1.SelectExemplarsResponse](ctx, f, c)
+}
@@ -0,0 +1,415 @@
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+// Federated queries span multiple tenants, specified in the X-Scope-OrgID
+// header separated by '|'. The query is executed for each of the tenants
+// independently, and the results are merged. The tenant of a series is
+// exposed as the __tenant_id__ label, which can be used to group series.
+
+// federatedTenantIDs returns the tenant IDs of the query, if there are more
+// than one.
+func federatedTenantIDs(ctx context.Context) ([]string, bool) {
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil || len(tenantIDs) < 2 {
+		return nil, false
+	}
+	return tenantIDs, true
+}
+
+// forEachTenant calls fn concurrently for each of the tenants, up to the
+// smallest max query parallelism of the tenants. The context passed to fn
+// carries the tenant ID.
+func (f *Frontend) forEachTenant(ctx context.Context, tenantIDs []string, fn func(ctx context.Context, i int, tenantID string) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
+		g.SetLimit(maxConcurrent)
+	}
+	for i, tenantID := range tenantIDs {
+		i, tenantID := i, tenantID
+		g.Go(func() error {
+			return fn(user.InjectOrgID(ctx, tenantID), i, tenantID)
+		})
+	}
+	return g.Wait()
+}
+
+func withTenantLabel(ls []*typesv1.LabelPair, tenantID string) []*typesv1.LabelPair {
+	return phlaremodel.NewLabelsBuilder(ls).Set(phlaremodel.LabelNameTenantID, tenantID).Labels()
+}
+
+func (f *Frontend) federatedProfileTypes(ctx context.Context, tenantIDs []string, c *connect.Request[querierv1.ProfileTypesRequest]) (*connect.Response[querierv1.ProfileTypesResponse], error) {
+	responses := make([]*querierv1.ProfileTypesResponse, len(tenantIDs))
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, i int, _ string) error {
+		resp, err := f.ProfileTypes(ctx, c)
+		if err != nil {
+			return err
+		}
+		responses[i] = resp.Msg
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	profileTypes := make(map[string]*typesv1.ProfileType)
+	for _, r := range responses {
+		for _, t := range r.ProfileTypes {
+			profileTypes[t.ID] = t
+		}
+	}
+	result := &querierv1.ProfileTypesResponse{
+		ProfileTypes: make([]*typesv1.ProfileType, 0, len(profileTypes)),
+	}
+	for _, t := range profileTypes {
+		result.ProfileTypes = append(result.ProfileTypes, t)
+	}
+	sort.Slice(result.ProfileTypes, func(i, j int) bool {
+		return result.ProfileTypes[i].ID < result.ProfileTypes[j].ID
+	})
+	return connect.NewResponse(result), nil
+}
+
+func (f *Frontend) federatedLabelNames(ctx context.Context, tenantIDs []string, c *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
+	names := make([][]string, len(tenantIDs)+1)
+	names[len(tenantIDs)] = []string{phlaremodel.LabelNameTenantID}
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, i int, _ string) error {
+		resp, err := f.LabelNames(ctx, c)
+		if err != nil {
+			return err
+		}
+		names[i] = resp.Msg.Names
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&typesv1.LabelNamesResponse{Names: uniqueSortedStrings(names...)}), nil
+}
+
+func (f *Frontend) federatedLabelValues(ctx context.Context, tenantIDs []string, c *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
+	if c.Msg.Name == phlaremodel.LabelNameTenantID {
+		return connect.NewResponse(&typesv1.LabelValuesResponse{Names: tenantIDs}), nil
+	}
+	values := make([][]string, len(tenantIDs))
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, i int, _ string) error {
+		resp, err := f.LabelValues(ctx, c)
+		if err != nil {
+			return err
+		}
+		values[i] = resp.Msg.Names
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&typesv1.LabelValuesResponse{Names: uniqueSortedStrings(values...)}), nil
+}
+
+func (f *Frontend) federatedSeries(ctx context.Context, tenantIDs []string, c *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
+	withTenant := len(c.Msg.LabelNames) == 0
+	labelNames := make([]string, 0, len(c.Msg.LabelNames))
+	for _, n := range c.Msg.LabelNames {
+		if n == phlaremodel.LabelNameTenantID {
+			withTenant = true
+			continue
+		}
+		labelNames = append(labelNames, n)
+	}
+	if len(c.Msg.LabelNames) > 0 && len(labelNames) == 0 && withTenant {
+		// Only the tenant label is requested.
+		labelsSet := make([]*typesv1.Labels, len(tenantIDs))
+		for i, tenantID := range tenantIDs {
+			labelsSet[i] = &typesv1.Labels{Labels: withTenantLabel(nil, tenantID)}
+		}
+		return connect.NewResponse(&querierv1.SeriesResponse{LabelsSet: labelsSet}), nil
+	}
+
+	var m sync.Mutex
+	seen := make(map[uint64]struct{})
+	result := new(querierv1.SeriesResponse)
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, _ int, tenantID string) error {
+		resp, err := f.Series(ctx, connectgrpc.CloneRequest(c, &querierv1.SeriesRequest{
+			Matchers:   c.Msg.Matchers,
+			LabelNames: labelNames,
+		}))
+		if err != nil {
+			return err
+		}
+		m.Lock()
+		defer m.Unlock()
+		for _, ls := range resp.Msg.LabelsSet {
+			if withTenant {
+				ls.Labels = withTenantLabel(ls.Labels, tenantID)
+			}
+			h := phlaremodel.Labels(ls.Labels).Hash()
+			if _, ok := seen[h]; ok {
+				continue
+			}
+			seen[h] = struct{}{}
+			result.LabelsSet = append(result.LabelsSet, ls)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(result), nil
+}
+
+// federatedLabelCardinality requests all the label values of each tenant,
+// and applies the limit to the merged result. The series of different
+// tenants are distinct, even if their labels are the same.
+func (f *Frontend) federatedLabelCardinality(ctx context.Context, tenantIDs []string, c *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	req := c.Msg.CloneVT()
+	if req.End == 0 {
+		req.End = int64(model.Now())
+	}
+	if req.Start == 0 {
+		req.Start = int64(model.Time(req.End).Add(-time.Hour))
+	}
+	m := phlaremodel.NewLabelCardinalityMerger()
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, _ int, _ string) error {
+		tenantReq := req.CloneVT()
+		tenantReq.Limit = 0
+		resp, err := f.LabelCardinality(ctx, connectgrpc.CloneRequest(c, tenantReq))
+		if err != nil {
+			return err
+		}
+		m.MergeLabelCardinality(resp.Msg, 1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(m.LabelCardinality(req)), nil
+}
+
+func (f *Frontend) federatedSelectMergeTrees(ctx context.Context, tenantIDs []string, c *connect.Request[querierv1.SelectMergeStacktracesRequest]) ([]*phlaremodel.Tree, error) {
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)},
+	})
+	var m sync.Mutex
+	trees := make([]*phlaremodel.Tree, 1+len(c.Msg.AdditionalProfileTypeIDs))
+	for i := range trees {
+		trees[i] = new(phlaremodel.Tree)
+	}
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, _ int, _ string) error {
+		r, err := f.selectMergeTrees(ctx, connectgrpc.CloneRequest(c, c.Msg.CloneVT()))
+		if err != nil {
+			return err
+		}
+		m.Lock()
+		for i, t := range trees {
+			t.Merge(r[i])
+		}
+		m.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return trees, nil
+}
+
+func (f *Frontend) federatedSelectMergeProfiles(ctx context.Context, tenantIDs []string, c *connect.Request[querierv1.SelectMergeProfileRequest], selectMerge selectMergeProfileFunc, fn func(*profilev1.Profile) error) error {
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)},
+	})
+	return f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, _ int, _ string) error {
+		return f.selectMergeProfiles(ctx, connectgrpc.CloneRequest(c, c.Msg.CloneVT()), selectMerge, fn)
+	})
+}
+
+func (f *Frontend) federatedSelectSeries(ctx context.Context, tenantIDs []string, c *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
+	var byTenant bool
+	groupBy := make([]string, 0, len(c.Msg.GroupBy))
+	for _, n := range c.Msg.GroupBy {
+		if n == phlaremodel.LabelNameTenantID {
+			byTenant = true
+			continue
+		}
+		groupBy = append(groupBy, n)
+	}
+	if !byTenant && !summableAggregation(c.Msg.Aggregation) {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("%s across tenants requires grouping by %s", c.Msg.Aggregation, phlaremodel.LabelNameTenantID))
+	}
+
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)},
+	})
+
+	// Unless grouped by tenant, series of different
+	// tenants with the same labels are summed up.
+	m := phlaremodel.NewSeriesMerger(true)
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, _ int, tenantID string) error {
+		req := c.Msg.CloneVT()
+		req.GroupBy = groupBy
+		resp, err := f.SelectSeries(ctx, connectgrpc.CloneRequest(c, req))
+		if err != nil {
+			return err
+		}
+		if byTenant {
+			for _, s := range resp.Msg.Series {
+				s.Labels = withTenantLabel(s.Labels, tenantID)
+			}
+		}
+		m.MergeSeries(resp.Msg.Series)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: m.Series()}), nil
+}
+
+// federatedSelectProfilesMetadata lists the profiles of each tenant, labeled
+// with the tenant ID, and applies the limit to the merged result.
+func (f *Frontend) federatedSelectProfilesMetadata(ctx context.Context, tenantIDs []string, c *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	m := phlaremodel.NewProfilesMetadataMerger()
+	err := f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, _ int, tenantID string) error {
+		resp, err := f.SelectProfilesMetadata(ctx, connectgrpc.CloneRequest(c, c.Msg.CloneVT()))
+		if err != nil {
+			return err
+		}
+		for _, p := range resp.Msg.Profiles {
+			p.Labels = withTenantLabel(p.Labels, tenantID)
+		}
+		m.MergeProfilesMetadata(resp.Msg.Profiles)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&typesv1.SelectProfilesMetadataResponse{Profiles: m.ProfilesMetadata(c.Msg.Limit)}), nil
+}
+
+// federatedSelectExemplars lists the exemplars of each tenant, labeled with
+// the tenant ID, applies the limit to the merged result and sums up the
+// heatmaps. The heatmaps of the tenants must have the same bounds: the time
+// range is validated for all the tenants at once and, unless the max value
+// is set in the request, the tenants whose heatmap does not reach the
+// highest value of all are queried again with it.
+func (f *Frontend) federatedSelectExemplars(ctx context.Context, tenantIDs []string, c *connect.Request[querierv1.SelectExemplarsRequest]) (*connect.Response[querierv1.SelectExemplarsResponse], error) {
+	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
+	if err != nil {
+		return nil, connect.NewError(http.StatusBadRequest, err)
+	}
+	if validated.IsEmpty {
+		return connect.NewResponse(&querierv1.SelectExemplarsResponse{}), nil
+	}
+	c.Msg.Start = int64(validated.Start)
+	c.Msg.End = int64(validated.End)
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      validated.Interval,
+	})
+
+	responses := make([]*querierv1.SelectExemplarsResponse, len(tenantIDs))
+	query := func(ctx context.Context, i int, tenantID string, req *querierv1.SelectExemplarsRequest) error {
+		resp, err := f.SelectExemplars(ctx, connectgrpc.CloneRequest(c, req))
+		if err != nil {
+			return err
+		}
+		for _, e := range resp.Msg.Exemplars {
+			e.Labels = withTenantLabel(e.Labels, tenantID)
+		}
+		responses[i] = resp.Msg
+		return nil
+	}
+	err = f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, i int, tenantID string) error {
+		return query(ctx, i, tenantID, c.Msg.CloneVT())
+	})
+	if err != nil {
+		return nil, err
+	}
+	var maxValue int64
+	for _, r := range responses {
+		if r.Heatmap != nil && r.Heatmap.MaxValue > maxValue {
+			maxValue = r.Heatmap.MaxValue
+		}
+	}
+	if c.Msg.MaxValue == 0 && maxValue > 0 {
+		err = f.forEachTenant(ctx, tenantIDs, func(ctx context.Context, i int, tenantID string) error {
+			if h := responses[i].Heatmap; h == nil || h.MaxValue == maxValue {
+				return nil
+			}
+			req := c.Msg.CloneVT()
+			req.MaxValue = maxValue
+			return query(ctx, i, tenantID, req)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m := phlaremodel.NewExemplarsMerger()
+	var heatmap *querierv1.Heatmap
+	for _, r := range responses {
+		m.MergeExemplars(r.Exemplars)
+		switch {
+		case r.Heatmap == nil:
+		case heatmap == nil:
+			heatmap = r.Heatmap
+		default:
+			if err = phlaremodel.MergeHeatmap(heatmap, r.Heatmap); err != nil {
+				return nil, err
+			}
+		}
+	}
+	limit := c.Msg.Limit
+	if limit <= 0 {
+		limit = phlaremodel.DefaultExemplarsLimit
+	}
+	return connect.NewResponse(&querierv1.SelectExemplarsResponse{
+		Exemplars: m.Exemplars(limit),
+		Heatmap:   heatmap,
+	}), nil
+}
+
+func uniqueSortedStrings(s ...[]string) []string {
+	var size int
+	for _, x := range s {
+		size += len(x)
+	}
+	set := make(map[string]struct{}, size)
+	for _, x := range s {
+		for _, v := range x {
+			set[v] = struct{}{}
+		}
+	}
+	result := make([]string, 0, len(set))
+	for v := range set {
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
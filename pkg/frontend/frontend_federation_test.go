@@ -0,0 +1,79 @@
+package frontend
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func withMultiResolver(t *testing.T) {
+	tenant.WithDefaultResolver(tenant.NewMultiResolver())
+	t.Cleanup(func() { tenant.WithDefaultResolver(tenant.NewSingleResolver()) })
+}
+
+func Test_federatedTenantIDs(t *testing.T) {
+	withMultiResolver(t)
+
+	tenantIDs, ok := federatedTenantIDs(user.InjectOrgID(context.Background(), "b|a"))
+	require.True(t, ok)
+	require.Equal(t, []string{"a", "b"}, tenantIDs)
+
+	_, ok = federatedTenantIDs(user.InjectOrgID(context.Background(), "a"))
+	require.False(t, ok)
+}
+
+func TestFrontend_FederatedLabelValues_TenantID(t *testing.T) {
+	withMultiResolver(t)
+
+	ctx := user.InjectOrgID(context.Background(), "c|a|b")
+	resp, err := new(Frontend).LabelValues(ctx, connect.NewRequest(&typesv1.LabelValuesRequest{
+		Name: phlaremodel.LabelNameTenantID,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, resp.Msg.Names)
+}
+
+func TestFrontend_forEachTenant_MaxQueryParallelism(t *testing.T) {
+	f := &Frontend{limits: validation.MockLimits{MaxQueryParallelismValue: 2}}
+	var running, maxRunning atomic.Int32
+	err := f.forEachTenant(context.Background(), []string{"a", "b", "c", "d", "e"}, func(ctx context.Context, _ int, tenantID string) error {
+		orgID, err := user.ExtractOrgID(ctx)
+		require.NoError(t, err)
+		require.Equal(t, tenantID, orgID)
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), maxRunning.Load())
+}
+
+func Test_withTenantLabel(t *testing.T) {
+	ls := phlaremodel.LabelsFromStrings("service_name", "foo", "__name__", "cpu")
+	require.Equal(t,
+		phlaremodel.LabelsFromStrings("__name__", "cpu", "__tenant_id__", "a", "service_name", "foo"),
+		phlaremodel.Labels(withTenantLabel(ls, "a")),
+	)
+}
+
+func Test_uniqueSortedStrings(t *testing.T) {
+	require.Equal(t, []string{"a", "b", "c"}, uniqueSortedStrings([]string{"c", "a"}, nil, []string{"b", "a"}))
+	require.Empty(t, uniqueSortedStrings())
+}
@@ -0,0 +1,19 @@
+package frontend
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+)
+
+func (f *Frontend) LabelCardinality(ctx context.Context, c *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceLabelCardinalityProcedure)
+	if tenantIDs, ok := federatedTenantIDs(ctx); ok {
+		return f.federatedLabelCardinality(ctx, tenantIDs, c)
+	}
+	return connectgrpc.RoundTripUnary[typesv1.LabelCardinalityRequest, typesv1.LabelCardinalityResponse](ctx, f, c)
+}
@@ -12,5 +12,8 @@ import (
 
 func (f *Frontend) LabelNames(ctx context.Context, c *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceLabelNamesProcedure)
+	if tenantIDs, ok := federatedTenantIDs(ctx); ok {
+		return f.federatedLabelNames(ctx, tenantIDs, c)
+	}
 	return connectgrpc.RoundTripUnary[typesv1.LabelNamesRequest, typesv1.LabelNamesResponse](ctx, f, c)
 }
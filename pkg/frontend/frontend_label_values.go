@@ -12,5 +12,8 @@ import (
 
 func (f *Frontend) LabelValues(ctx context.Context, c *connect.Request[typesv1.LabelValuesRequest]) (*connect.Response[typesv1.LabelValuesResponse], error) {
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceLabelValuesProcedure)
+	if tenantIDs, ok := federatedTenantIDs(ctx); ok {
+		return f.federatedLabelValues(ctx, tenantIDs, c)
+	}
 	return connectgrpc.RoundTripUnary[typesv1.LabelValuesRequest, typesv1.LabelValuesResponse](ctx, f, c)
 }
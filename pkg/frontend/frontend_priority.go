@@ -0,0 +1,125 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/scheduler/queue"
+	"github.com/grafana/pyroscope/pkg/util/httpgrpc"
+	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
+)
+
+type priorityCtxKey struct{}
+
+// query is the selection of a query the priority is determined from.
+type query struct {
+	profileTypeID string
+	labelSelector string
+	interval      model.Interval
+}
+
+// withQueryPriority classifies the query by its estimated cost: queries
+// spanning a time range longer than the tenant's threshold, or whose
+// number of series multiplied by the hours of the time range exceeds the
+// tenant's threshold, are scheduled with the batch priority, so that they
+// do not delay interactive queries of the same tenant. The series of all
+// the tenants of a federated query are counted, and the priority applies
+// to the queries of each of them.
+//
+// A priority requested explicitly by the client, or already set in the
+// context, takes precedence, and is propagated to the sub-queries with
+// the request headers.
+func (f *Frontend) withQueryPriority(ctx context.Context, h http.Header, tenantIDs []string, q query) context.Context {
+	if h.Get(queue.HeaderQueryPriority) != "" {
+		return ctx
+	}
+	if _, ok := ctx.Value(priorityCtxKey{}).(queue.Priority); ok {
+		return ctx
+	}
+	batch, err := f.isBatchQuery(tenantIDs, q, func() (int64, error) {
+		return f.estimateSeries(ctx, q)
+	})
+	if err != nil {
+		level.Warn(f.log).Log("msg", "failed to estimate the number of series of the query", "err", err)
+	}
+	if batch {
+		return context.WithValue(ctx, priorityCtxKey{}, queue.PriorityBatch)
+	}
+	return ctx
+}
+
+// isBatchQuery reports whether the cost of the query exceeds the
+// thresholds of the tenants. The number of series is only estimated if
+// the time range does not decide.
+func (f *Frontend) isBatchQuery(tenantIDs []string, q query, series func() (int64, error)) (bool, error) {
+	d := q.interval.End.Sub(q.interval.Start)
+	threshold := validationutil.SmallestPositiveNonZeroDurationPerTenant(tenantIDs, f.limits.BatchQueriesLongerThan)
+	if threshold > 0 && d > threshold {
+		return true, nil
+	}
+	seriesHours := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.BatchQueriesSeriesHours)
+	if seriesHours <= 0 {
+		return false, nil
+	}
+	n, err := series()
+	if err != nil {
+		return false, err
+	}
+	return float64(n)*d.Hours() > float64(seriesHours), nil
+}
+
+// estimateSeries returns the number of series matching the query, as
+// counted by the label cardinality of the profile type label, which is
+// set in every series.
+func (f *Frontend) estimateSeries(ctx context.Context, q query) (int64, error) {
+	profileType, err := phlaremodel.ParseProfileTypeSelector(q.profileTypeID)
+	if err != nil {
+		return 0, err
+	}
+	matchers, err := parser.ParseMetricSelector(q.labelSelector)
+	if err != nil {
+		return 0, err
+	}
+	matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, phlaremodel.LabelNameProfileType, profileType.ID))
+	resp, err := f.LabelCardinality(ctx, connect.NewRequest(&typesv1.LabelCardinalityRequest{
+		Matchers: []string{matchersToString(matchers)},
+		Start:    int64(q.interval.Start),
+		End:      int64(q.interval.End),
+		Limit:    1,
+	}))
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range resp.Msg.Labels {
+		if l.Name == phlaremodel.LabelNameProfileType {
+			return l.SeriesCount, nil
+		}
+	}
+	return 0, nil
+}
+
+// setRequestPriority sets the priority of the query as the request header,
+// unless it is already present.
+func setRequestPriority(ctx context.Context, req *httpgrpc.HTTPRequest) {
+	p, ok := ctx.Value(priorityCtxKey{}).(queue.Priority)
+	if !ok {
+		return
+	}
+	for _, h := range req.Headers {
+		if http.CanonicalHeaderKey(h.Key) == queue.HeaderQueryPriority {
+			return
+		}
+	}
+	req.Headers = append(req.Headers, &httpgrpc.Header{
+		Key:    queue.HeaderQueryPriority,
+		Values: []string{p.String()},
+	})
+}
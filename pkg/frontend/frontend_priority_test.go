@@ -0,0 +1,95 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/grafana/pyroscope/pkg/scheduler/queue"
+	"github.com/grafana/pyroscope/pkg/util/httpgrpc"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func Test_QueryPriority(t *testing.T) {
+	f := &Frontend{limits: validation.MockLimits{BatchQueriesLongerThanValue: 24 * time.Hour}}
+	day := model.Interval{Start: 0, End: model.TimeFromUnix(int64((24 * time.Hour).Seconds()))}
+	week := model.Interval{Start: 0, End: model.TimeFromUnix(int64((7 * 24 * time.Hour).Seconds()))}
+
+	for _, tc := range []struct {
+		name     string
+		header   http.Header
+		interval model.Interval
+		expected []string
+	}{
+		{
+			name:     "interactive",
+			header:   http.Header{},
+			interval: day,
+		},
+		{
+			name:     "batch",
+			header:   http.Header{},
+			interval: week,
+			expected: []string{"batch"},
+		},
+		{
+			name:     "explicit",
+			header:   http.Header{queue.HeaderQueryPriority: []string{"interactive"}},
+			interval: week,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := f.withQueryPriority(context.Background(), tc.header, []string{"tenant"}, query{interval: tc.interval})
+			req := new(httpgrpc.HTTPRequest)
+			setRequestPriority(ctx, req)
+			var actual []string
+			for _, h := range req.Headers {
+				if h.Key == queue.HeaderQueryPriority {
+					actual = h.Values
+				}
+			}
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func Test_QueryPriority_Series(t *testing.T) {
+	f := &Frontend{limits: validation.MockLimits{
+		BatchQueriesLongerThanValue:  24 * time.Hour,
+		BatchQueriesSeriesHoursValue: 1000,
+	}}
+	hours := func(h int64) query {
+		return query{interval: model.Interval{Start: 0, End: model.TimeFromUnix(h * 3600)}}
+	}
+	series := func(n int64) func() (int64, error) {
+		return func() (int64, error) { return n, nil }
+	}
+
+	batch, err := f.isBatchQuery([]string{"tenant"}, hours(2), series(100))
+	assert.NoError(t, err)
+	assert.False(t, batch)
+
+	batch, err = f.isBatchQuery([]string{"tenant"}, hours(2), series(501))
+	assert.NoError(t, err)
+	assert.True(t, batch)
+
+	// The time range decides: the series are not estimated.
+	batch, err = f.isBatchQuery([]string{"tenant"}, hours(48), func() (int64, error) {
+		t.Fatal("unexpected series estimation")
+		return 0, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, batch)
+
+	// The priority of a federated query applies to all its tenants.
+	ctx := context.WithValue(context.Background(), priorityCtxKey{}, queue.PriorityBatch)
+	ctx = f.withQueryPriority(ctx, http.Header{}, []string{"tenant"}, hours(1))
+	req := new(httpgrpc.HTTPRequest)
+	setRequestPriority(ctx, req)
+	assert.Equal(t, []string{"batch"}, req.Headers[0].Values)
+}
@@ -12,5 +12,8 @@ import (
 
 func (f *Frontend) ProfileTypes(ctx context.Context, c *connect.Request[querierv1.ProfileTypesRequest]) (*connect.Response[querierv1.ProfileTypesResponse], error) {
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceProfileTypesProcedure)
+	if tenantIDs, ok := federatedTenantIDs(ctx); ok {
+		return f.federatedProfileTypes(ctx, tenantIDs, c)
+	}
 	return connectgrpc.RoundTripUnary[querierv1.ProfileTypesRequest, querierv1.ProfileTypesResponse](ctx, f, c)
 }
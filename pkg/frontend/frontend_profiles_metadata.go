@@ -0,0 +1,43 @@
+package frontend
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/tenant"
+	"github.com/prometheus/common/model"
+
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func (f *Frontend) SelectProfilesMetadata(ctx context.Context, c *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectProfilesMetadataProcedure)
+	if c.Msg.End == 0 {
+		c.Msg.End = int64(model.Now())
+	}
+	if c.Msg.Start == 0 {
+		c.Msg.Start = int64(model.Time(c.Msg.End).Add(-time.Hour))
+	}
+	if tenantIDs, ok := federatedTenantIDs(ctx); ok {
+		return f.federatedSelectProfilesMetadata(ctx, tenantIDs, c)
+	}
+	tenantIDs, err := tenant.TenantIDs(ctx)
+	if err != nil {
+		return nil, connect.NewError(http.StatusBadRequest, err)
+	}
+	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
+	if err != nil {
+		return nil, connect.NewError(http.StatusBadRequest, err)
+	}
+	if validated.IsEmpty {
+		return connect.NewResponse(&typesv1.SelectProfilesMetadataResponse{}), nil
+	}
+	c.Msg.Start = int64(validated.Start)
+	c.Msg.End = int64(validated.End)
+	return connectgrpc.RoundTripUnary[typesv1.SelectProfilesMetadataRequest, typesv1.SelectProfilesMetadataResponse](ctx, f, c)
+}
@@ -3,33 +3,218 @@ package frontend
 import (
 	"context"
 	"net/http"
+	"sync"
 
 	"github.com/bufbuild/connect-go"
+	"github.com/google/pprof/profile"
 	"github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/grafana/dskit/tenant"
 
 	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/scheduler/queue"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
 	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 func (f *Frontend) SelectMergeProfile(ctx context.Context, c *connect.Request[querierv1.SelectMergeProfileRequest]) (*connect.Response[profilev1.Profile], error) {
+	var (
+		lock     sync.Mutex
+		profiles []*profilev1.Profile
+	)
+	err := f.selectMergeProfiles(ctx, c, f.selectMergeProfile, func(p *profilev1.Profile) error {
+		lock.Lock()
+		defer lock.Unlock()
+		profiles = append(profiles, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch len(profiles) {
+	case 0:
+		return connect.NewResponse(&profilev1.Profile{}), nil
+	case 1:
+		return connect.NewResponse(profiles[0]), nil
+	}
+	parsed := make([]*profile.Profile, len(profiles))
+	for i, p := range profiles {
+		if parsed[i], err = parseProfile(p); err != nil {
+			return nil, err
+		}
+	}
+	return mergeProfiles(c.Msg, parsed)
+}
+
+// SelectMergeProfileStream sends the merged profile in chunks, as the
+// profiles merged by the queriers are received. Exports are scheduled
+// with the batch priority, unless the client requests otherwise.
+func (f *Frontend) SelectMergeProfileStream(ctx context.Context, c *connect.Request[querierv1.SelectMergeProfileRequest], stream *connect.ServerStream[querierv1.SelectMergeProfileStreamResponse]) error {
+	if c.Header().Get(queue.HeaderQueryPriority) == "" {
+		ctx = context.WithValue(ctx, priorityCtxKey{}, queue.PriorityBatch)
+	}
+	return f.WriteMergeProfile(ctx, c, func(chunk []byte) error {
+		return stream.Send(&querierv1.SelectMergeProfileStreamResponse{Chunk: chunk})
+	})
+}
+
+// WriteMergeProfile writes the merged profile in the chunks passed to fn,
+// merging the profiles of the queriers as they are received: only their
+// symbols are retained.
+//
+// The queriers write their merged profile incrementally with the streaming
+// merge, and never build it in memory. However, the query is sent to the
+// queriers through the scheduler, which only transports whole responses:
+// the encoding of the profile merged by a querier is buffered by the
+// querier and the frontend. Sharding the query bounds the size of each
+// of these responses.
+func (f *Frontend) WriteMergeProfile(ctx context.Context, c *connect.Request[querierv1.SelectMergeProfileRequest], fn func([]byte) error) error {
+	var lock sync.Mutex
+	w := pprof.NewMergeWriter(pprof.DefaultChunkSize, fn)
+	err := f.selectMergeProfiles(ctx, c, f.selectMergeProfileStream, func(p *profilev1.Profile) error {
+		lock.Lock()
+		defer lock.Unlock()
+		return w.Write(p)
+	})
+	if err != nil {
+		return err
+	}
+	return w.Close(model.Time(c.Msg.End).UnixNano(), model.Time(c.Msg.End).UnixNano()-model.Time(c.Msg.Start).UnixNano())
+}
+
+// selectMergeProfileFunc sends a merge query to a querier.
+type selectMergeProfileFunc func(context.Context, *connect.Request[querierv1.SelectMergeProfileRequest]) (*profilev1.Profile, error)
+
+// selectMergeProfile sends the query to the unary merge of a querier.
+func (f *Frontend) selectMergeProfile(ctx context.Context, c *connect.Request[querierv1.SelectMergeProfileRequest]) (*profilev1.Profile, error) {
+	resp, err := connectgrpc.RoundTripUnary[querierv1.SelectMergeProfileRequest, profilev1.Profile](ctx, f, c)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Msg, nil
+}
+
+// selectMergeProfileStream sends the query to the streaming merge of a
+// querier, and decodes the profile from the chunks received.
+func (f *Frontend) selectMergeProfileStream(ctx context.Context, c *connect.Request[querierv1.SelectMergeProfileRequest]) (*profilev1.Profile, error) {
+	client := querierv1connect.NewQuerierServiceClient(connectgrpc.NewClient(f), "http://httpgrpc")
+	stream, err := client.SelectMergeProfileStream(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	var b []byte
+	for stream.Receive() {
+		b = append(b, stream.Msg().Chunk...)
+	}
+	if err = stream.Err(); err != nil {
+		return nil, err
+	}
+	var p profilev1.Profile
+	if err = p.UnmarshalVT(b); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return &p, nil
+}
+
+// selectMergeProfiles calls fn with the profiles merged by the queriers, one
+// per shard and tenant, as they are received. fn may be called concurrently.
+func (f *Frontend) selectMergeProfiles(ctx context.Context, c *connect.Request[querierv1.SelectMergeProfileRequest], selectMerge selectMergeProfileFunc, fn func(*profilev1.Profile) error) error {
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectMergeProfileProcedure)
 	tenantIDs, err := tenant.TenantIDs(ctx)
 	if err != nil {
-		return nil, connect.NewError(http.StatusBadRequest, err)
+		return connect.NewError(http.StatusBadRequest, err)
+	}
+	if len(tenantIDs) > 1 {
+		return f.federatedSelectMergeProfiles(ctx, tenantIDs, c, selectMerge, fn)
 	}
 	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
 	if err != nil {
-		return nil, connect.NewError(http.StatusBadRequest, err)
+		return connect.NewError(http.StatusBadRequest, err)
 	}
 	if validated.IsEmpty {
-		return connect.NewResponse(&profilev1.Profile{}), nil
+		return nil
 	}
 	c.Msg.Start = int64(validated.Start)
 	c.Msg.End = int64(validated.End)
-	return connectgrpc.RoundTripUnary[querierv1.SelectMergeProfileRequest, profilev1.Profile](ctx, f, c)
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      validated.Interval,
+	})
+
+	shards := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.QuerySplitShards)
+	if shards < 2 {
+		p, err := selectMerge(ctx, c)
+		if err != nil {
+			return err
+		}
+		return fn(p)
+	}
+	selectors, err := SplitBySeriesShard(c.Msg.LabelSelector, shards)
+	if err != nil {
+		return connect.NewError(http.StatusBadRequest, err)
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
+		g.SetLimit(maxConcurrent)
+	}
+
+	stats.FromContext(ctx).AddShardedQueries(uint32(len(selectors)))
+	for _, selector := range selectors {
+		selector := selector
+		g.Go(func() error {
+			req := connectgrpc.CloneRequest(c, &querierv1.SelectMergeProfileRequest{
+				ProfileTypeID: c.Msg.ProfileTypeID,
+				LabelSelector: selector,
+				Start:         c.Msg.Start,
+				End:           c.Msg.End,
+			})
+			p, err := selectMerge(ctx, req)
+			if err != nil {
+				return err
+			}
+			return fn(p)
+		})
+	}
+	return g.Wait()
+}
+
+func parseProfile(p *profilev1.Profile) (*profile.Profile, error) {
+	b, err := p.MarshalVT()
+	if err != nil {
+		return nil, err
+	}
+	return profile.ParseUncompressed(b)
+}
+
+func mergeProfiles(req *querierv1.SelectMergeProfileRequest, profiles []*profile.Profile) (*connect.Response[profilev1.Profile], error) {
+	// Empty profiles have no sample types and can't be merged.
+	nonEmpty := profiles[:0]
+	for _, p := range profiles {
+		if len(p.SampleType) > 0 {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return connect.NewResponse(&profilev1.Profile{}), nil
+	}
+	merged, err := profile.Merge(nonEmpty)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	p, err := pprof.FromProfile(merged)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	p.DurationNanos = model.Time(req.End).UnixNano() - model.Time(req.Start).UnixNano()
+	p.TimeNanos = model.Time(req.End).UnixNano()
+	return connect.NewResponse(p), nil
 }
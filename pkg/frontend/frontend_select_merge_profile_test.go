@@ -0,0 +1,71 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+	"github.com/grafana/pyroscope/pkg/scheduler/schedulerpb"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+)
+
+type fakeStreamingQuerier struct {
+	querierv1connect.UnimplementedQuerierServiceHandler
+	profile *profilev1.Profile
+}
+
+func (q *fakeStreamingQuerier) SelectMergeProfileStream(_ context.Context, _ *connect.Request[querierv1.SelectMergeProfileRequest], stream *connect.ServerStream[querierv1.SelectMergeProfileStreamResponse]) error {
+	return pprof.WriteChunks(q.profile.CloneVT(), 64, func(chunk []byte) error {
+		return stream.Send(&querierv1.SelectMergeProfileStreamResponse{Chunk: chunk})
+	})
+}
+
+func TestFrontendWriteMergeProfile_QuerierStream(t *testing.T) {
+	const userID = "test"
+	b := testhelper.NewProfileBuilder(0).CPUProfile()
+	b.ForStacktraceString("a", "b").AddSamples(1)
+	b.ForStacktraceString("a", "c").AddSamples(2)
+	q := &fakeStreamingQuerier{profile: b.Profile}
+
+	mux := http.NewServeMux()
+	mux.Handle(querierv1connect.NewQuerierServiceHandler(q))
+	handler := connectgrpc.NewHandler(mux)
+	f, _ := setupFrontend(t, nil, func(f *Frontend, msg *schedulerpb.FrontendToScheduler) *schedulerpb.SchedulerToFrontend {
+		resp, err := handler.Handle(context.Background(), msg.HttpRequest)
+		require.NoError(t, err)
+		go sendResponseWithDelay(f, 0, userID, msg.QueryID, resp)
+		return &schedulerpb.SchedulerToFrontend{Status: schedulerpb.SchedulerToFrontendStatus_OK}
+	})
+
+	var buf bytes.Buffer
+	ctx := user.InjectOrgID(context.Background(), userID)
+	err := f.WriteMergeProfile(ctx, connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: "{}",
+		Start:         0,
+		End:           1000,
+	}), func(chunk []byte) error {
+		_, err := buf.Write(chunk)
+		return err
+	})
+	require.NoError(t, err)
+
+	var merged profilev1.Profile
+	require.NoError(t, merged.UnmarshalVT(buf.Bytes()))
+	require.Len(t, merged.Sample, 2)
+	var total int64
+	for _, s := range merged.Sample {
+		total += s.Value[0]
+	}
+	require.Equal(t, int64(3), total)
+}
@@ -2,6 +2,7 @@ package frontend
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
 	"github.com/grafana/pyroscope/pkg/validation"
@@ -22,57 +24,234 @@ func (f *Frontend) SelectMergeStacktraces(ctx context.Context,
 	c *connect.Request[querierv1.SelectMergeStacktracesRequest]) (
 	*connect.Response[querierv1.SelectMergeStacktracesResponse], error,
 ) {
+	exportFormat, err := phlaremodel.ParseExportFormat(c.Msg)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if len(c.Msg.AdditionalProfileTypeIDs) > 0 {
+		return f.selectMergeMultiValue(ctx, c)
+	}
+	t, err := f.selectMergeTree(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	maxNodes := maxNodesOrDefault(c.Msg.GetMaxNodes())
+	if exportFormat != "" {
+		// The profile type has been validated by the selection.
+		profileType, _ := phlaremodel.ParseProfileTypeSelector(c.Msg.ProfileTypeID)
+		export, err := phlaremodel.ExportTree(t, maxNodes, exportFormat, profileType)
+		if err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+			Export: export,
+		}), nil
+	}
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+		Flamegraph: phlaremodel.NewFlameGraph(t, maxNodes),
+	}), nil
+}
+
+// maxNodesDefault is the max nodes of the merged tree, if the request does
+// not set it. It matches the default of the queriers.
+const maxNodesDefault = int64(2048)
+
+// maxNodesOrDefault returns the max nodes of the request, if set, or the
+// default: the merged tree is always truncated by the frontend, as the
+// queriers do not truncate the trees of the sub-queries to the request's
+// limit.
+func maxNodesOrDefault(maxNodes int64) int64 {
+	if maxNodes > 0 {
+		return maxNodes
+	}
+	return maxNodesDefault
+}
+
+// selectMergeMultiValue merges the trees of the profile types of the
+// request into one multi-value flame graph, or into a tree per profile
+// type in the tree format. The profile types are merged by the queriers
+// at once; if some of them do not support it, each profile type is split
+// and merged as a query of its own.
+func (f *Frontend) selectMergeMultiValue(ctx context.Context,
+	c *connect.Request[querierv1.SelectMergeStacktracesRequest]) (
+	*connect.Response[querierv1.SelectMergeStacktracesResponse], error,
+) {
+	profileTypeIDs := append([]string{c.Msg.ProfileTypeID}, c.Msg.AdditionalProfileTypeIDs...)
+	if _, err := phlaremodel.ParseMultiValueProfileTypes(profileTypeIDs); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	maxNodes := maxNodesOrDefault(c.Msg.GetMaxNodes())
+	c.Msg.MaxNodes = &maxNodes
+
+	trees, err := f.selectMergeTrees(ctx, connectgrpc.CloneRequest(c, c.Msg.CloneVT()))
+	if errors.Is(err, errAdditionalTypesNotSupported) {
+		trees, err = f.selectMergeTreesByProfileType(ctx, c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := phlaremodel.NewMultiValueMergeResponse(c.Msg, trees)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
+
+// selectMergeTreesByProfileType merges the trees of the profile types of
+// the request, splitting and merging each as a query of its own.
+func (f *Frontend) selectMergeTreesByProfileType(ctx context.Context,
+	c *connect.Request[querierv1.SelectMergeStacktracesRequest],
+) ([]*phlaremodel.Tree, error) {
+	profileTypeIDs := append([]string{c.Msg.ProfileTypeID}, c.Msg.AdditionalProfileTypeIDs...)
+	trees := make([]*phlaremodel.Tree, len(profileTypeIDs))
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, profileTypeID := range profileTypeIDs {
+		i, msg := i, c.Msg.CloneVT()
+		msg.ProfileTypeID = profileTypeID
+		msg.AdditionalProfileTypeIDs = nil
+		if i > 0 {
+			// The unit only applies to the first profile type.
+			msg.Unit = ""
+		}
+		g.Go(func() error {
+			t, err := f.selectMergeTree(gCtx, connectgrpc.CloneRequest(c, msg))
+			if err != nil {
+				return err
+			}
+			trees[i] = t
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return trees, nil
+}
+
+// errAdditionalTypesNotSupported is returned when a querier does not
+// merge the additional profile types of a request.
+var errAdditionalTypesNotSupported = errors.New("additional profile types are not supported")
+
+// selectMergeTree splits the query by interval and merges the trees
+// returned by queriers. The sub-queries are truncated to the tenant's
+// split query max nodes limit, which is expected to be much larger than
+// the limit of the result: the caller truncates the merged tree once.
+func (f *Frontend) selectMergeTree(ctx context.Context,
+	c *connect.Request[querierv1.SelectMergeStacktracesRequest],
+) (*phlaremodel.Tree, error) {
+	trees, err := f.selectMergeTrees(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return trees[0], nil
+}
+
+// selectMergeTrees is like selectMergeTree, but returns the trees of the
+// profile type and the additional profile types of the request, in the
+// order of the request.
+func (f *Frontend) selectMergeTrees(ctx context.Context,
+	c *connect.Request[querierv1.SelectMergeStacktracesRequest],
+) ([]*phlaremodel.Tree, error) {
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSelectMergeStacktracesProcedure)
 	tenantIDs, err := tenant.TenantIDs(ctx)
 	if err != nil {
 		return nil, connect.NewError(http.StatusBadRequest, err)
 	}
+	if len(tenantIDs) > 1 {
+		return f.federatedSelectMergeTrees(ctx, tenantIDs, c)
+	}
 
 	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
 	if err != nil {
 		return nil, connect.NewError(http.StatusBadRequest, err)
 	}
+	trees := make([]*phlaremodel.Tree, 1+len(c.Msg.AdditionalProfileTypeIDs))
 	if validated.IsEmpty {
-		return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{}), nil
+		for i := range trees {
+			trees[i] = new(phlaremodel.Tree)
+		}
+		return trees, nil
 	}
 	c.Msg.Start = int64(validated.Start)
 	c.Msg.End = int64(validated.End)
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      validated.Interval,
+	})
 
 	g, ctx := errgroup.WithContext(ctx)
 	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
 		g.SetLimit(maxConcurrent)
 	}
 
-	m := phlaremodel.NewFlameGraphMerger()
+	// Negative max nodes instructs queriers not to truncate the tree.
+	maxNodes := int64(-1)
+	if n := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.QuerySplitMaxNodes); n > 0 {
+		maxNodes = int64(n)
+	}
+
+	shards := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.QuerySplitShards)
+	selectors, err := SplitBySeriesShard(c.Msg.LabelSelector, shards)
+	if err != nil {
+		return nil, connect.NewError(http.StatusBadRequest, err)
+	}
+
+	mergers := make([]*phlaremodel.TreeMerger, len(trees))
+	for i := range mergers {
+		mergers[i] = phlaremodel.NewTreeMerger()
+	}
 	interval := validationutil.MaxDurationOrZeroPerTenant(tenantIDs, f.limits.QuerySplitDuration)
 	intervals := NewTimeIntervalIterator(time.UnixMilli(c.Msg.Start), time.UnixMilli(c.Msg.End), interval)
 
+	st := stats.FromContext(ctx)
 	for intervals.Next() {
 		r := intervals.At()
-		g.Go(func() error {
-			req := connectgrpc.CloneRequest(c, &querierv1.SelectMergeStacktracesRequest{
-				ProfileTypeID: c.Msg.ProfileTypeID,
-				LabelSelector: c.Msg.LabelSelector,
-				Start:         r.Start.UnixMilli(),
-				End:           r.End.UnixMilli(),
-				MaxNodes:      c.Msg.MaxNodes,
+		st.AddSplitQueries(1)
+		if len(selectors) > 1 {
+			st.AddShardedQueries(uint32(len(selectors)))
+		}
+		for _, selector := range selectors {
+			selector := selector
+			g.Go(func() error {
+				req := connectgrpc.CloneRequest(c, &querierv1.SelectMergeStacktracesRequest{
+					ProfileTypeID: c.Msg.ProfileTypeID,
+					LabelSelector: selector,
+					Start:         r.Start.UnixMilli(),
+					End:           r.End.UnixMilli(),
+					MaxNodes:      &maxNodes,
+					Format:        querierv1.ProfileFormat_PROFILE_FORMAT_TREE,
+					SpanSelector:  c.Msg.SpanSelector,
+					Unit:          c.Msg.Unit,
+
+					AdditionalProfileTypeIDs: c.Msg.AdditionalProfileTypeIDs,
+				})
+				resp, err := connectgrpc.RoundTripUnary[
+					querierv1.SelectMergeStacktracesRequest,
+					querierv1.SelectMergeStacktracesResponse](ctx, f, req)
+				if err != nil {
+					return err
+				}
+				if len(resp.Msg.AdditionalTrees) != len(mergers)-1 {
+					return errAdditionalTypesNotSupported
+				}
+				for i, b := range resp.Msg.AdditionalTrees {
+					if err = mergers[i+1].MergeTreeBytes(b); err != nil {
+						return err
+					}
+				}
+				return mergers[0].MergeTreeBytes(resp.Msg.Tree)
 			})
-			resp, err := connectgrpc.RoundTripUnary[
-				querierv1.SelectMergeStacktracesRequest,
-				querierv1.SelectMergeStacktracesResponse](ctx, f, req)
-			if err != nil {
-				return err
-			}
-			m.MergeFlameGraph(resp.Msg.Flamegraph)
-			return nil
-		})
+		}
 	}
 
 	if err = g.Wait(); err != nil {
 		return nil, err
 	}
 
-	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
-		Flamegraph: m.FlameGraph(c.Msg.GetMaxNodes()),
-	}), nil
+	for i, m := range mergers {
+		trees[i] = m.Tree()
+	}
+	return trees, nil
 }
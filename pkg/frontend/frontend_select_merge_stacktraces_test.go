@@ -0,0 +1,52 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/scheduler/schedulerpb"
+	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
+)
+
+func TestFrontendSelectMergeStacktraces_MaxNodesDefault(t *testing.T) {
+	const userID = "test"
+	tree := new(phlaremodel.Tree)
+	for i := 0; i < 3*int(maxNodesDefault); i++ {
+		tree.InsertStack(int64(i+1), strconv.Itoa(i), "main")
+	}
+	f, _ := setupFrontend(t, nil, func(f *Frontend, msg *schedulerpb.FrontendToScheduler) *schedulerpb.SchedulerToFrontend {
+		resp, err := connectgrpc.HandleUnary[querierv1.SelectMergeStacktracesRequest, querierv1.SelectMergeStacktracesResponse](
+			context.Background(), msg.HttpRequest,
+			func(_ context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+				var buf bytes.Buffer
+				if err := tree.MarshalTruncate(&buf, req.Msg.GetMaxNodes()); err != nil {
+					return nil, err
+				}
+				return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{Tree: buf.Bytes()}), nil
+			})
+		require.NoError(t, err)
+		go sendResponseWithDelay(f, 0, userID, msg.QueryID, resp)
+		return &schedulerpb.SchedulerToFrontend{Status: schedulerpb.SchedulerToFrontendStatus_OK}
+	})
+
+	ctx := user.InjectOrgID(context.Background(), userID)
+	resp, err := f.SelectMergeStacktraces(ctx, connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: "{}",
+		Start:         0,
+		End:           1000,
+	}))
+	require.NoError(t, err)
+	// The truncated nodes are replaced with the "other" node.
+	require.Equal(t, tree.Total(), resp.Msg.Flamegraph.Total)
+	require.Less(t, len(resp.Msg.Flamegraph.Names), 2*int(maxNodesDefault))
+	require.Greater(t, len(resp.Msg.Flamegraph.Names), int(maxNodesDefault)/2)
+}
@@ -12,7 +12,9 @@ import (
 
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 	validationutil "github.com/grafana/pyroscope/pkg/util/validation"
 	"github.com/grafana/pyroscope/pkg/validation"
@@ -27,6 +29,9 @@ func (f *Frontend) SelectSeries(ctx context.Context,
 	if err != nil {
 		return nil, connect.NewError(http.StatusBadRequest, err)
 	}
+	if len(tenantIDs) > 1 {
+		return f.federatedSelectSeries(ctx, tenantIDs, c)
+	}
 
 	validated, err := validation.ValidateRangeRequest(f.limits, tenantIDs, model.Interval{Start: model.Time(c.Msg.Start), End: model.Time(c.Msg.End)}, model.Now())
 	if err != nil {
@@ -37,37 +42,63 @@ func (f *Frontend) SelectSeries(ctx context.Context,
 	}
 	c.Msg.Start = int64(validated.Start)
 	c.Msg.End = int64(validated.End)
+	ctx = f.withQueryPriority(ctx, c.Header(), tenantIDs, query{
+		profileTypeID: c.Msg.ProfileTypeID,
+		labelSelector: c.Msg.LabelSelector,
+		interval:      validated.Interval,
+	})
 
 	g, ctx := errgroup.WithContext(ctx)
 	if maxConcurrent := validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.MaxQueryParallelism); maxConcurrent > 0 {
 		g.SetLimit(maxConcurrent)
 	}
 
-	m := phlaremodel.NewSeriesMerger(false)
+	var shards int
+	if summableAggregation(c.Msg.Aggregation) {
+		shards = validationutil.SmallestPositiveNonZeroIntPerTenant(tenantIDs, f.limits.QuerySplitShards)
+	}
+	selectors, err := SplitBySeriesShard(c.Msg.LabelSelector, shards)
+	if err != nil {
+		return nil, connect.NewError(http.StatusBadRequest, err)
+	}
+
+	// Points of a group may come from multiple series shards,
+	// therefore they must be summed up.
+	m := phlaremodel.NewSeriesMerger(len(selectors) > 1)
 	interval := validationutil.MaxDurationOrZeroPerTenant(tenantIDs, f.limits.QuerySplitDuration)
 	intervals := NewTimeIntervalIterator(time.UnixMilli(c.Msg.Start), time.UnixMilli(c.Msg.End), interval,
 		WithAlignment(time.Second*time.Duration(c.Msg.Step)))
 
+	st := stats.FromContext(ctx)
 	for intervals.Next() {
 		r := intervals.At()
-		g.Go(func() error {
-			req := connectgrpc.CloneRequest(c, &querierv1.SelectSeriesRequest{
-				ProfileTypeID: c.Msg.ProfileTypeID,
-				LabelSelector: c.Msg.LabelSelector,
-				Start:         r.Start.UnixMilli(),
-				End:           r.End.UnixMilli(),
-				GroupBy:       c.Msg.GroupBy,
-				Step:          c.Msg.Step,
+		st.AddSplitQueries(1)
+		if len(selectors) > 1 {
+			st.AddShardedQueries(uint32(len(selectors)))
+		}
+		for _, selector := range selectors {
+			selector := selector
+			g.Go(func() error {
+				req := connectgrpc.CloneRequest(c, &querierv1.SelectSeriesRequest{
+					ProfileTypeID: c.Msg.ProfileTypeID,
+					LabelSelector: selector,
+					Start:         r.Start.UnixMilli(),
+					End:           r.End.UnixMilli(),
+					GroupBy:       c.Msg.GroupBy,
+					Step:          c.Msg.Step,
+					Aggregation:   c.Msg.Aggregation,
+					Unit:          c.Msg.Unit,
+				})
+				resp, err := connectgrpc.RoundTripUnary[
+					querierv1.SelectSeriesRequest,
+					querierv1.SelectSeriesResponse](ctx, f, req)
+				if err != nil {
+					return err
+				}
+				m.MergeSeries(resp.Msg.Series)
+				return nil
 			})
-			resp, err := connectgrpc.RoundTripUnary[
-				querierv1.SelectSeriesRequest,
-				querierv1.SelectSeriesResponse](ctx, f, req)
-			if err != nil {
-				return err
-			}
-			m.MergeSeries(resp.Msg.Series)
-			return nil
-		})
+		}
 	}
 
 	if err = g.Wait(); err != nil {
@@ -76,3 +107,17 @@ func (f *Frontend) SelectSeries(ctx context.Context,
 
 	return connect.NewResponse(&querierv1.SelectSeriesResponse{Series: m.Series()}), nil
 }
+
+// summableAggregation reports whether the series of disjoint sets of
+// profiles can be summed up into the series of all the profiles. The
+// queries of the other aggregations are not split by series shard.
+func summableAggregation(a typesv1.TimeSeriesAggregationType) bool {
+	switch a {
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM,
+		typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_RATE,
+		typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT:
+		return true
+	default:
+		return false
+	}
+}
@@ -12,5 +12,8 @@ import (
 
 func (f *Frontend) Series(ctx context.Context, c *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
 	ctx = connectgrpc.WithProcedure(ctx, querierv1connect.QuerierServiceSeriesProcedure)
+	if tenantIDs, ok := federatedTenantIDs(ctx); ok {
+		return f.federatedSeries(ctx, tenantIDs, c)
+	}
 	return connectgrpc.RoundTripUnary[querierv1.SeriesRequest, querierv1.SeriesResponse](ctx, f, c)
 }
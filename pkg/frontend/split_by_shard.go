@@ -0,0 +1,49 @@
+package frontend
+
+import (
+	"strings"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/shard"
+)
+
+// SplitBySeriesShard splits the label selector into the given number of
+// selectors, each of which only matches series of a single shard. Series
+// are sharded by fingerprint: every series belongs to exactly one shard,
+// therefore the results of the sub-queries can be merged as is.
+//
+// If the number of shards is less than two, the selector is returned
+// unchanged.
+func SplitBySeriesShard(selector string, shards int) ([]string, error) {
+	if shards < 2 {
+		return []string{selector}, nil
+	}
+	matchers, err := parser.ParseMetricSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	selectors := make([]string, shards)
+	for i := range selectors {
+		l := shard.Annotation{Shard: i, Of: shards}.Label()
+		sharded := make([]*labels.Matcher, 0, len(matchers)+1)
+		sharded = append(sharded, matchers...)
+		sharded = append(sharded, labels.MustNewMatcher(labels.MatchEqual, l.Name, l.Value))
+		selectors[i] = matchersToString(sharded)
+	}
+	return selectors, nil
+}
+
+func matchersToString(matchers []*labels.Matcher) string {
+	var b strings.Builder
+	b.WriteRune('{')
+	for i, m := range matchers {
+		if i > 0 {
+			b.WriteRune(',')
+		}
+		b.WriteString(m.String())
+	}
+	b.WriteRune('}')
+	return b.String()
+}
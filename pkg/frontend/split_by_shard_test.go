@@ -0,0 +1,36 @@
+package frontend
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/shard"
+)
+
+func Test_SplitBySeriesShard(t *testing.T) {
+	selectors, err := SplitBySeriesShard(`{service_name="foo"}`, 0)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{service_name="foo"}`}, selectors)
+
+	selectors, err = SplitBySeriesShard(`{service_name="foo",env=~"prod|dev"}`, 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`{service_name="foo",env=~"prod|dev",__cortex_shard__="0_of_4"}`,
+		`{service_name="foo",env=~"prod|dev",__cortex_shard__="1_of_4"}`,
+		`{service_name="foo",env=~"prod|dev",__cortex_shard__="2_of_4"}`,
+		`{service_name="foo",env=~"prod|dev",__cortex_shard__="3_of_4"}`,
+	}, selectors)
+
+	for i, s := range selectors {
+		matchers, err := parser.ParseMetricSelector(s)
+		require.NoError(t, err)
+		a, _, err := shard.FromMatchers(matchers)
+		require.NoError(t, err)
+		require.Equal(t, &shard.Annotation{Shard: i, Of: 4}, a)
+	}
+
+	_, err = SplitBySeriesShard(`{service_name=}`, 4)
+	require.Error(t, err)
+}
@@ -20,6 +20,7 @@ import (
 	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	ingesterv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/pkg/costattribution"
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	phlareobjclient "github.com/grafana/pyroscope/pkg/objstore/client"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
@@ -64,8 +65,9 @@ type Ingester struct {
 	instances    map[string]*instance
 	instancesMtx sync.RWMutex
 
-	limits Limits
-	reg    prometheus.Registerer
+	limits          Limits
+	costAttribution *costattribution.Manager
+	reg             prometheus.Registerer
 }
 
 type ingesterFlusherCompat struct {
@@ -91,6 +93,7 @@ func New(phlarectx context.Context, cfg Config, dbConfig phlaredb.Config, storag
 		storageBucket: storageBucket,
 		limits:        limits,
 	}
+	i.costAttribution = costattribution.NewManager(limits, i.reg)
 
 	// initialise the local bucket client
 	var (
@@ -163,7 +166,7 @@ func (i *Ingester) GetOrCreateInstance(tenantID string) (*instance, error) { //n
 	if !ok {
 		var err error
 
-		inst, err = newInstance(i.phlarectx, i.dbConfig, tenantID, i.localBucket, i.storageBucket, NewLimiter(tenantID, i.limits, i.lifecycler, i.cfg.LifecyclerConfig.RingConfig.ReplicationFactor))
+		inst, err = newInstance(i.phlarectx, i.dbConfig, tenantID, i.localBucket, i.storageBucket, NewLimiter(tenantID, i.limits, i.lifecycler, i.cfg.LifecyclerConfig.RingConfig.ReplicationFactor, i.costAttribution))
 		if err != nil {
 			return nil, err
 		}
@@ -235,6 +238,7 @@ func (i *Ingester) evictBlock(tenantID string, b ulid.ULID, fn func() error) (er
 func (i *Ingester) Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
 	return forInstanceUnary(ctx, i, func(instance *instance) (*connect.Response[pushv1.PushResponse], error) {
 		level.Debug(instance.logger).Log("msg", "message received by ingester push")
+		costAttribution := i.costAttribution.Tracker(instance.tenantID)
 		for _, series := range req.Msg.Series {
 			for _, sample := range series.Samples {
 				err := pprof.FromBytes(sample.RawProfile, func(p *profilev1.Profile, size int) error {
@@ -254,8 +258,10 @@ func (i *Ingester) Push(ctx context.Context, req *connect.Request[pushv1.PushReq
 								return connect.NewError(connect.CodeResourceExhausted, err)
 							}
 						}
+						return err
 					}
-					return err
+					costAttribution.IncrementStored(series.Labels, int64(size))
+					return nil
 				})
 				if err != nil {
 					return nil, err
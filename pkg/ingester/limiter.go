@@ -8,8 +8,10 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/samber/lo"
 
+	"github.com/grafana/pyroscope/pkg/costattribution"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/util"
+	querylimiter "github.com/grafana/pyroscope/pkg/util/limiter"
 	"github.com/grafana/pyroscope/pkg/validation"
 )
 
@@ -23,9 +25,11 @@ type RingCount interface {
 }
 
 type Limits interface {
+	costattribution.Limits
 	MaxLocalSeriesPerTenant(tenantID string) int
 	MaxGlobalSeriesPerTenant(tenantID string) int
 	IngestionTenantShardSize(tenantID string) int
+	querylimiter.QueryLimits
 }
 
 type Limiter interface {
@@ -44,6 +48,9 @@ type limiter struct {
 	activeSeries  map[model.Fingerprint]int64
 	lastTimestamp map[model.Fingerprint]int64
 
+	costAttribution     *costattribution.Manager
+	costAttributionKeys map[model.Fingerprint]string
+
 	mtx sync.Mutex // todo: may be shard the lock to avoid latency spikes.
 
 	ctx    context.Context
@@ -51,18 +58,20 @@ type limiter struct {
 	wg     sync.WaitGroup
 }
 
-func NewLimiter(tenantID string, limits Limits, ring RingCount, replicationFactor int) Limiter {
+func NewLimiter(tenantID string, limits Limits, ring RingCount, replicationFactor int, costAttribution *costattribution.Manager) Limiter {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	l := &limiter{
-		tenantID:          tenantID,
-		limits:            limits,
-		ring:              ring,
-		replicationFactor: replicationFactor,
-		activeSeries:      map[model.Fingerprint]int64{},
-		lastTimestamp:     map[model.Fingerprint]int64{},
-		cancel:            cancel,
-		ctx:               ctx,
+		tenantID:            tenantID,
+		limits:              limits,
+		ring:                ring,
+		replicationFactor:   replicationFactor,
+		activeSeries:        map[model.Fingerprint]int64{},
+		lastTimestamp:       map[model.Fingerprint]int64{},
+		costAttribution:     costAttribution,
+		costAttributionKeys: map[model.Fingerprint]string{},
+		cancel:              cancel,
+		ctx:                 ctx,
 	}
 
 	l.wg.Add(1)
@@ -74,6 +83,14 @@ func NewLimiter(tenantID string, limits Limits, ring RingCount, replicationFacto
 func (l *limiter) Stop() {
 	l.cancel()
 	l.wg.Wait()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	costAttribution := l.costAttribution.Tracker(l.tenantID)
+	for fp, key := range l.costAttributionKeys {
+		costAttribution.RemoveActiveSeries(key)
+		delete(l.costAttributionKeys, fp)
+	}
 }
 
 func (l *limiter) loop() {
@@ -98,9 +115,14 @@ func (l *limiter) cleanup() {
 	l.mtx.Lock()
 	defer l.mtx.Unlock()
 
+	costAttribution := l.costAttribution.Tracker(l.tenantID)
 	for fp, lastUsed := range l.activeSeries {
 		if now-lastUsed > int64(activeSeriesTimeout) {
 			delete(l.activeSeries, fp)
+			if key, ok := l.costAttributionKeys[fp]; ok {
+				costAttribution.RemoveActiveSeries(key)
+				delete(l.costAttributionKeys, fp)
+			}
 		}
 	}
 }
@@ -111,7 +133,7 @@ func (l *limiter) AllowProfile(fp model.Fingerprint, lbs phlaremodel.Labels, tsN
 	if err := l.allowNewProfile(fp, lbs, tsNano); err != nil {
 		return err
 	}
-	return l.allowNewSeries(fp)
+	return l.allowNewSeries(fp, lbs)
 }
 
 func (l *limiter) allowNewProfile(fp model.Fingerprint, lbs phlaremodel.Labels, tsNano int64) error {
@@ -128,7 +150,7 @@ func (l *limiter) allowNewProfile(fp model.Fingerprint, lbs phlaremodel.Labels,
 	return nil
 }
 
-func (l *limiter) allowNewSeries(fp model.Fingerprint) error {
+func (l *limiter) allowNewSeries(fp model.Fingerprint, lbs phlaremodel.Labels) error {
 	_, ok := l.activeSeries[fp]
 	series := len(l.activeSeries)
 	if !ok {
@@ -136,6 +158,9 @@ func (l *limiter) allowNewSeries(fp model.Fingerprint) error {
 		if err := l.assertMaxSeriesPerUser(l.tenantID, series); err != nil {
 			return err
 		}
+		if costAttribution := l.costAttribution.Tracker(l.tenantID); costAttribution != nil {
+			l.costAttributionKeys[fp] = costAttribution.AddActiveSeries(lbs)
+		}
 	}
 
 	// update time or add it
@@ -3,13 +3,17 @@ package ingester
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/grafana/pyroscope/pkg/costattribution"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 )
 
@@ -17,6 +21,10 @@ type fakeLimits struct {
 	maxLocalSeriesPerTenant  int
 	maxGlobalSeriesPerTenant int
 	ingestionTenantShardSize int
+	maxQuerySeries           int
+	maxQueryBytesRead        int
+	maxQueryProfiles         int
+	costAttributionLabels    []string
 }
 
 func (f *fakeLimits) MaxLocalSeriesPerTenant(userID string) int {
@@ -31,6 +39,26 @@ func (f *fakeLimits) IngestionTenantShardSize(userID string) int {
 	return f.ingestionTenantShardSize
 }
 
+func (f *fakeLimits) MaxQuerySeries(userID string) int {
+	return f.maxQuerySeries
+}
+
+func (f *fakeLimits) MaxQueryBytesRead(userID string) int {
+	return f.maxQueryBytesRead
+}
+
+func (f *fakeLimits) MaxQueryProfiles(userID string) int {
+	return f.maxQueryProfiles
+}
+
+func (f *fakeLimits) CostAttributionLabels(userID string) []string {
+	return f.costAttributionLabels
+}
+
+func (f *fakeLimits) MaxCostAttributionCardinality(userID string) int {
+	return 0
+}
+
 type fakeRingCount struct {
 	healthyInstancesCount int
 }
@@ -40,7 +68,7 @@ func (f *fakeRingCount) HealthyInstancesCount() int {
 }
 
 func TestOutOfOrder(t *testing.T) {
-	limiter := NewLimiter("foo", &fakeLimits{}, &fakeRingCount{1}, 1)
+	limiter := NewLimiter("foo", &fakeLimits{}, &fakeRingCount{1}, 1, nil)
 	defer limiter.Stop()
 
 	// First push should be allowed.
@@ -61,7 +89,7 @@ func TestGlobalMaxSeries(t *testing.T) {
 	activeSeriesTimeout = 200 * time.Millisecond
 	activeSeriesCleanup = 100 * time.Millisecond
 
-	limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5}, &fakeRingCount{2}, 3)
+	limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5}, &fakeRingCount{2}, 3, nil)
 	defer limiter.Stop()
 
 	for i := 0; i < 7; i++ {
@@ -82,6 +110,27 @@ func TestGlobalMaxSeries(t *testing.T) {
 	}
 }
 
+func TestCostAttributionActiveSeries(t *testing.T) {
+	limits := &fakeLimits{costAttributionLabels: []string{"service_name"}}
+	reg := prometheus.NewPedanticRegistry()
+	limiter := NewLimiter("foo", limits, &fakeRingCount{1}, 1, costattribution.NewManager(limits, reg))
+
+	require.NoError(t, limiter.AllowProfile(1, phlaremodel.LabelsFromStrings("service_name", "a"), 1))
+	require.NoError(t, limiter.AllowProfile(1, phlaremodel.LabelsFromStrings("service_name", "a"), 2))
+	require.NoError(t, limiter.AllowProfile(2, phlaremodel.LabelsFromStrings("service_name", "a", "pod", "b"), 1))
+	require.NoError(t, limiter.AllowProfile(3, phlaremodel.LabelsFromStrings("service_name", "c"), 1))
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP pyroscope_cost_attribution_active_series The number of active series in the ingester, by cost attribution labels.
+# TYPE pyroscope_cost_attribution_active_series gauge
+pyroscope_cost_attribution_active_series{service_name="a",tenant="foo"} 2
+pyroscope_cost_attribution_active_series{service_name="c",tenant="foo"} 1
+`), "pyroscope_cost_attribution_active_series"))
+
+	// The series are no longer active once the limiter is stopped.
+	limiter.Stop()
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(""), "pyroscope_cost_attribution_active_series"))
+}
+
 func assertMaxSeries(t testing.TB, limiter Limiter, count int) {
 	var (
 		i   int
@@ -100,7 +149,7 @@ func assertMaxSeries(t testing.TB, limiter Limiter, count int) {
 
 func TestLocalLimit(t *testing.T) {
 	t.Run("local limit", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5, maxLocalSeriesPerTenant: 1}, &fakeRingCount{5}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 5, maxLocalSeriesPerTenant: 1}, &fakeRingCount{5}, 3, nil)
 		defer limiter.Stop()
 
 		// local limit of 1 series should take precedence over global limit of 5 series.
@@ -108,7 +157,7 @@ func TestLocalLimit(t *testing.T) {
 	})
 
 	t.Run("local limit enforced by diving global limit", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{9}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{9}, 3, nil)
 		defer limiter.Stop()
 
 		// local limit of 1 should be per ingester (globalLimit * replicationFactor) / ingesterNum
@@ -116,7 +165,7 @@ func TestLocalLimit(t *testing.T) {
 	})
 
 	t.Run("ensure we do not panic with zero ingesters", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{0}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3}, &fakeRingCount{0}, 3, nil)
 		defer limiter.Stop()
 
 		// we can ingest as many series as we want
@@ -124,7 +173,7 @@ func TestLocalLimit(t *testing.T) {
 	})
 
 	t.Run("ensure we handle sharding correctly", func(t *testing.T) {
-		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3, ingestionTenantShardSize: 3}, &fakeRingCount{9}, 3)
+		limiter := NewLimiter("foo", &fakeLimits{maxGlobalSeriesPerTenant: 3, ingestionTenantShardSize: 3}, &fakeRingCount{9}, 3, nil)
 		defer limiter.Stop()
 
 		// local limit of 3 should be per ingester (globalLimit * replicationFactor) / shardSize
@@ -0,0 +1,318 @@
+package pyroscope
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/user"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+// BatchConfig configures the batching of the profiles ingested
+// through the legacy Pyroscope /ingest API.
+type BatchConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	MaxBatchSize    int           `yaml:"max_batch_size" category:"advanced"`
+	MaxBatchBytes   int           `yaml:"max_batch_bytes" category:"advanced"`
+	FlushInterval   time.Duration `yaml:"flush_interval" category:"advanced"`
+	MaxConcurrency  int           `yaml:"max_concurrency" category:"advanced"`
+	MaxQueuedPushes int           `yaml:"max_queued_pushes" category:"advanced"`
+	MaxTenantPushes int           `yaml:"max_tenant_pushes" category:"advanced"`
+	PushTimeout     time.Duration `yaml:"push_timeout" category:"advanced"`
+	MaxRetries      int           `yaml:"max_retries" category:"advanced"`
+	MinBackoff      time.Duration `yaml:"min_backoff" category:"advanced"`
+	MaxBackoff      time.Duration `yaml:"max_backoff" category:"advanced"`
+}
+
+// RegisterFlagsWithPrefix registers the batching flags with the given prefix.
+func (cfg *BatchConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, prefix+".enabled", true, "Merge the profiles ingested concurrently by a tenant into a single push request.")
+	f.IntVar(&cfg.MaxBatchSize, prefix+".max-batch-size", 64, "Maximum number of profiles in a batch.")
+	f.IntVar(&cfg.MaxBatchBytes, prefix+".max-batch-bytes", 4<<20, "Maximum size of the profiles in a batch, in bytes.")
+	f.DurationVar(&cfg.FlushInterval, prefix+".flush-interval", 50*time.Millisecond, "Maximum time a profile waits for the batch to be complete.")
+	f.IntVar(&cfg.MaxConcurrency, prefix+".max-concurrency", 16, "Maximum number of batches pushed concurrently.")
+	f.IntVar(&cfg.MaxQueuedPushes, prefix+".max-queued-pushes", 256, "Maximum number of batches waiting to be pushed. Profiles are rejected with 429 Too Many Requests beyond it.")
+	f.IntVar(&cfg.MaxTenantPushes, prefix+".max-tenant-pushes", 32, "Maximum number of batches of a tenant being pushed or waiting to be pushed. Profiles are rejected with 429 Too Many Requests beyond it.")
+	f.DurationVar(&cfg.PushTimeout, prefix+".push-timeout", 10*time.Second, "Timeout of a batch push, including the retries.")
+	f.IntVar(&cfg.MaxRetries, prefix+".max-retries", 3, "Maximum number of retries of a batch push failed with a transient error.")
+	f.DurationVar(&cfg.MinBackoff, prefix+".min-backoff", 100*time.Millisecond, "Minimum delay before retrying a batch push.")
+	f.DurationVar(&cfg.MaxBackoff, prefix+".max-backoff", time.Second, "Maximum delay before retrying a batch push.")
+}
+
+func (cfg *BatchConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxBatchSize <= 0 {
+		return errors.New("the ingest batch max size must be positive")
+	}
+	if cfg.MaxBatchBytes <= 0 {
+		return errors.New("the ingest batch max bytes must be positive")
+	}
+	if cfg.FlushInterval <= 0 {
+		return errors.New("the ingest batch flush interval must be positive")
+	}
+	if cfg.MaxConcurrency <= 0 {
+		return errors.New("the ingest batch max concurrency must be positive")
+	}
+	if cfg.MaxQueuedPushes <= 0 {
+		return errors.New("the ingest batch max queued pushes must be positive")
+	}
+	if cfg.MaxTenantPushes <= 0 {
+		return errors.New("the ingest batch max tenant pushes must be positive")
+	}
+	if cfg.PushTimeout <= 0 {
+		return errors.New("the ingest batch push timeout must be positive")
+	}
+	if cfg.MaxRetries < 0 {
+		return errors.New("the ingest batch max retries must not be negative")
+	}
+	if cfg.MinBackoff > cfg.MaxBackoff {
+		return errors.New("the ingest batch min backoff must not exceed the max backoff")
+	}
+	return nil
+}
+
+var (
+	errTooManyQueuedPushes = errors.New("too many ingestion requests in flight")
+	errTooManyTenantPushes = errors.New("too many ingestion requests in flight for the tenant")
+)
+
+// batcher merges the series pushed concurrently by a tenant into a
+// single push request. A batch is pushed once it reaches the size
+// thresholds or the flush interval expires, whichever comes first.
+// The callers wait for the batch to be pushed and get its error.
+type batcher struct {
+	cfg    BatchConfig
+	svc    PushService
+	logger log.Logger
+
+	mu      sync.Mutex
+	batches map[string]*batch
+	// tenantPushes is the number of batches of each tenant
+	// being pushed or waiting to be pushed.
+	tenantPushes map[string]int
+	// pushes limits the number of batches pushed concurrently,
+	// queued limits the number of batches waiting for a slot.
+	pushes chan struct{}
+	queued chan struct{}
+}
+
+type batch struct {
+	tenantID string
+	entries  []*batchEntry
+	size     int
+	bytes    int
+	timer    *time.Timer
+}
+
+// batchEntry is the series of a single caller.
+type batchEntry struct {
+	series []*pushv1.RawProfileSeries
+	size   int
+	bytes  int
+	done   chan struct{}
+	err    error
+}
+
+func newBatcher(cfg BatchConfig, svc PushService, logger log.Logger) *batcher {
+	return &batcher{
+		cfg:          cfg,
+		svc:          svc,
+		logger:       logger,
+		batches:      make(map[string]*batch),
+		tenantPushes: make(map[string]int),
+		pushes:       make(chan struct{}, cfg.MaxConcurrency),
+		queued:       make(chan struct{}, cfg.MaxQueuedPushes),
+	}
+}
+
+func (b *batcher) push(ctx context.Context, series []*pushv1.RawProfileSeries) error {
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return connect.NewError(connect.CodeUnauthenticated, err)
+	}
+	e := newBatchEntry(series)
+	b.add(tenantID, e)
+	select {
+	case <-e.done:
+		return e.err
+	case <-ctx.Done():
+		// The profiles are dropped if the batch has not been sent yet.
+		// Otherwise the push is not interrupted and the profiles may
+		// still be ingested: a client retrying after its timeout may
+		// ingest them twice.
+		b.remove(tenantID, e)
+		return ctx.Err()
+	}
+}
+
+func newBatchEntry(series []*pushv1.RawProfileSeries) *batchEntry {
+	e := &batchEntry{series: series, done: make(chan struct{})}
+	for _, s := range series {
+		e.size += len(s.Samples)
+		for _, sample := range s.Samples {
+			e.bytes += len(sample.RawProfile)
+		}
+	}
+	return e
+}
+
+func (b *batcher) add(tenantID string, e *batchEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bt, ok := b.batches[tenantID]
+	if !ok {
+		bt = &batch{tenantID: tenantID}
+		bt.timer = time.AfterFunc(b.cfg.FlushInterval, func() { b.flush(bt) })
+		b.batches[tenantID] = bt
+	}
+	bt.entries = append(bt.entries, e)
+	bt.size += e.size
+	bt.bytes += e.bytes
+	if bt.size >= b.cfg.MaxBatchSize || bt.bytes >= b.cfg.MaxBatchBytes {
+		bt.timer.Stop()
+		delete(b.batches, tenantID)
+		go b.send(bt)
+	}
+}
+
+// remove removes the entry from the pending batch of the tenant.
+// It is a no-op if the batch has been sent already.
+func (b *batcher) remove(tenantID string, e *batchEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bt, ok := b.batches[tenantID]
+	if !ok {
+		return
+	}
+	for i, x := range bt.entries {
+		if x != e {
+			continue
+		}
+		bt.entries = append(bt.entries[:i], bt.entries[i+1:]...)
+		bt.size -= e.size
+		bt.bytes -= e.bytes
+		if len(bt.entries) == 0 {
+			bt.timer.Stop()
+			delete(b.batches, tenantID)
+		}
+		return
+	}
+}
+
+func (b *batcher) flush(bt *batch) {
+	b.mu.Lock()
+	if b.batches[bt.tenantID] != bt {
+		// The batch has been sent already.
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, bt.tenantID)
+	b.mu.Unlock()
+	b.send(bt)
+}
+
+func (b *batcher) send(bt *batch) {
+	if !b.acquireTenant(bt.tenantID) {
+		bt.done(connect.NewError(connect.CodeResourceExhausted, errTooManyTenantPushes))
+		return
+	}
+	defer b.releaseTenant(bt.tenantID)
+	select {
+	case b.queued <- struct{}{}:
+	default:
+		bt.done(connect.NewError(connect.CodeResourceExhausted, errTooManyQueuedPushes))
+		return
+	}
+	b.pushes <- struct{}{}
+	<-b.queued
+	defer func() { <-b.pushes }()
+
+	ctx, cancel := context.WithTimeout(user.InjectOrgID(context.Background(), bt.tenantID), b.cfg.PushTimeout)
+	defer cancel()
+	series := make([]*pushv1.RawProfileSeries, 0, bt.size)
+	for _, e := range bt.entries {
+		series = append(series, e.series...)
+	}
+	err := b.pushWithRetries(ctx, series)
+	if err != nil && len(bt.entries) > 1 && connect.CodeOf(err) == connect.CodeInvalidArgument {
+		// A single invalid profile fails the whole request: the
+		// entries are pushed separately to not reject the others.
+		level.Debug(b.logger).Log("msg", "batch rejected, pushing profiles separately", "tenant", bt.tenantID, "err", err)
+		for _, e := range bt.entries {
+			e.err = b.pushWithRetries(ctx, e.series)
+			close(e.done)
+		}
+		return
+	}
+	bt.done(err)
+}
+
+func (b *batcher) acquireTenant(tenantID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tenantPushes[tenantID] >= b.cfg.MaxTenantPushes {
+		return false
+	}
+	b.tenantPushes[tenantID]++
+	return true
+}
+
+func (b *batcher) releaseTenant(tenantID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tenantPushes[tenantID]--; b.tenantPushes[tenantID] == 0 {
+		delete(b.tenantPushes, tenantID)
+	}
+}
+
+func (bt *batch) done(err error) {
+	for _, e := range bt.entries {
+		e.err = err
+		close(e.done)
+	}
+}
+
+func (b *batcher) pushWithRetries(ctx context.Context, series []*pushv1.RawProfileSeries) (err error) {
+	boff := backoff.New(ctx, backoff.Config{
+		MinBackoff: b.cfg.MinBackoff,
+		MaxBackoff: b.cfg.MaxBackoff,
+		MaxRetries: b.cfg.MaxRetries + 1,
+	})
+	for boff.Ongoing() {
+		if _, err = b.svc.Push(ctx, connect.NewRequest(&pushv1.PushRequest{Series: series})); err == nil || !isRetryable(err) {
+			return err
+		}
+		level.Debug(b.logger).Log("msg", "failed to push batch, retrying", "err", err, "retries", boff.NumRetries())
+		boff.Wait()
+	}
+	if err == nil {
+		err = boff.Err()
+	}
+	return err
+}
+
+// isRetryable reports whether the push may succeed if retried. Requests
+// rejected because of the limits are not retried: the client is expected
+// to back off.
+func isRetryable(err error) bool {
+	switch connect.CodeOf(err) {
+	case connect.CodeUnknown,
+		connect.CodeInternal,
+		connect.CodeUnavailable,
+		connect.CodeDeadlineExceeded,
+		connect.CodeAborted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package pyroscope
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/google/pprof/profile"
+	"github.com/grafana/dskit/user"
+	"github.com/stretchr/testify/require"
+
+	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+type funcPushService struct {
+	sync.Mutex
+	requests []*pushv1.PushRequest
+	push     func(*pushv1.PushRequest) error
+}
+
+func (m *funcPushService) Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error) {
+	m.Lock()
+	m.requests = append(m.requests, req.Msg)
+	m.Unlock()
+	if _, err := user.ExtractOrgID(ctx); err != nil {
+		return nil, err
+	}
+	if m.push != nil {
+		if err := m.push(req.Msg); err != nil {
+			return nil, err
+		}
+	}
+	return connect.NewResponse(&pushv1.PushResponse{}), nil
+}
+
+func testBatchConfig() BatchConfig {
+	return BatchConfig{
+		Enabled:         true,
+		MaxBatchSize:    3,
+		MaxBatchBytes:   1 << 20,
+		FlushInterval:   time.Hour,
+		MaxConcurrency:  1,
+		MaxQueuedPushes: 8,
+		MaxTenantPushes: 8,
+		PushTimeout:     time.Minute,
+		MaxRetries:      2,
+		MinBackoff:      time.Millisecond,
+		MaxBackoff:      time.Millisecond,
+	}
+}
+
+func testSeries(name string) []*pushv1.RawProfileSeries {
+	return []*pushv1.RawProfileSeries{{
+		Labels:  []*typesv1.LabelPair{{Name: "name", Value: name}},
+		Samples: []*pushv1.RawSample{{RawProfile: []byte(name)}},
+	}}
+}
+
+func pushConcurrently(ctx context.Context, b *batcher, names ...string) []error {
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		i, name := i, name
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = b.push(ctx, testSeries(name))
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func Test_Batcher_MergesSeries(t *testing.T) {
+	svc := new(funcPushService)
+	b := newBatcher(testBatchConfig(), svc, log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+
+	require.Equal(t, []error{nil, nil, nil}, pushConcurrently(ctx, b, "a", "b", "c"))
+	require.Len(t, svc.requests, 1)
+	require.Len(t, svc.requests[0].Series, 3)
+}
+
+func Test_Batcher_FlushInterval(t *testing.T) {
+	svc := new(funcPushService)
+	cfg := testBatchConfig()
+	cfg.FlushInterval = 10 * time.Millisecond
+	b := newBatcher(cfg, svc, log.NewNopLogger())
+
+	// Batches are per tenant.
+	errs := pushConcurrently(user.InjectOrgID(context.Background(), "tenant-a"), b, "a")
+	errs = append(errs, pushConcurrently(user.InjectOrgID(context.Background(), "tenant-b"), b, "b")...)
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Len(t, svc.requests, 2)
+
+	require.Error(t, b.push(context.Background(), testSeries("a")))
+}
+
+func Test_Batcher_Retries(t *testing.T) {
+	var calls int
+	svc := &funcPushService{push: func(*pushv1.PushRequest) error {
+		if calls++; calls < 3 {
+			return connect.NewError(connect.CodeUnavailable, errors.New("unavailable"))
+		}
+		return nil
+	}}
+	b := newBatcher(testBatchConfig(), svc, log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+	require.Equal(t, []error{nil, nil, nil}, pushConcurrently(ctx, b, "a", "b", "c"))
+	require.Len(t, svc.requests, 3)
+
+	calls = -10
+	errs := pushConcurrently(ctx, b, "a", "b", "c")
+	require.Len(t, svc.requests, 6)
+	for _, err := range errs {
+		require.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+	}
+}
+
+func Test_Batcher_InvalidProfile(t *testing.T) {
+	svc := &funcPushService{push: func(req *pushv1.PushRequest) error {
+		for _, s := range req.Series {
+			if phlaremodel.Labels(s.Labels).Get("name") == "invalid" {
+				return connect.NewError(connect.CodeInvalidArgument, errors.New("invalid profile"))
+			}
+		}
+		return nil
+	}}
+	b := newBatcher(testBatchConfig(), svc, log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+
+	errs := pushConcurrently(ctx, b, "a", "invalid", "c")
+	require.NoError(t, errs[0])
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(errs[1]))
+	require.NoError(t, errs[2])
+	// The batch, then each of the profiles.
+	require.Len(t, svc.requests, 4)
+}
+
+func Test_Batcher_Cancellation(t *testing.T) {
+	release := make(chan struct{})
+	svc := &funcPushService{push: func(*pushv1.PushRequest) error {
+		<-release
+		return nil
+	}}
+	b := newBatcher(testBatchConfig(), svc, log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+
+	// The profiles are dropped if the batch is still pending.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, b.push(cancelled, testSeries("a")), context.Canceled)
+	require.Empty(t, b.batches)
+
+	// Otherwise, they are pushed regardless of the cancellation.
+	cancelled, cancel = context.WithCancel(ctx)
+	errs := make(chan error, 3)
+	go func() { errs <- b.push(cancelled, testSeries("b")) }()
+	go func() { errs <- b.push(ctx, testSeries("c")) }()
+	go func() { errs <- b.push(ctx, testSeries("d")) }()
+	require.Eventually(t, func() bool { return len(b.pushes) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			require.ErrorIs(t, err, context.Canceled)
+		}
+	}
+	require.Len(t, svc.requests, 1)
+	names := make([]string, 0, 3)
+	for _, s := range svc.requests[0].Series {
+		names = append(names, phlaremodel.Labels(s.Labels).Get("name"))
+	}
+	require.ElementsMatch(t, []string{"b", "c", "d"}, names)
+}
+
+func Test_Batcher_ResourceExhausted(t *testing.T) {
+	var calls int
+	svc := &funcPushService{push: func(*pushv1.PushRequest) error {
+		calls++
+		return connect.NewError(connect.CodeResourceExhausted, errors.New("rate limited"))
+	}}
+	cfg := testBatchConfig()
+	cfg.MaxBatchSize = 1
+	h := NewPyroscopeIngestHandler(svc, cfg, log.NewNopLogger())
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample:     []*profile.Sample{{Value: []int64{1}}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, p.Write(&buf))
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/ingest?name=app&format=pprof", &buf)
+	h.ServeHTTP(res, req.WithContext(user.InjectOrgID(req.Context(), "tenant-a")))
+	require.Equal(t, 429, res.Code)
+	// The request is not retried.
+	require.Equal(t, 1, calls)
+}
+
+func Test_Batcher_MaxQueuedPushes(t *testing.T) {
+	release := make(chan struct{})
+	svc := &funcPushService{push: func(*pushv1.PushRequest) error {
+		<-release
+		return nil
+	}}
+	cfg := testBatchConfig()
+	cfg.MaxBatchSize = 1
+	cfg.MaxQueuedPushes = 1
+	b := newBatcher(cfg, svc, log.NewNopLogger())
+	ctx := user.InjectOrgID(context.Background(), "tenant-a")
+
+	// The first batch is being pushed, the second one is queued.
+	errs := make(chan error, 2)
+	go func() { errs <- b.push(ctx, testSeries("a")) }()
+	require.Eventually(t, func() bool { return len(b.pushes) == 1 }, time.Second, time.Millisecond)
+	go func() { errs <- b.push(ctx, testSeries("b")) }()
+	require.Eventually(t, func() bool { return len(b.queued) == 1 }, time.Second, time.Millisecond)
+
+	err := b.push(ctx, testSeries("c"))
+	require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	close(release)
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+}
+
+func Test_Batcher_MaxTenantPushes(t *testing.T) {
+	release := make(chan struct{})
+	svc := &funcPushService{push: func(*pushv1.PushRequest) error {
+		<-release
+		return nil
+	}}
+	cfg := testBatchConfig()
+	cfg.MaxBatchSize = 1
+	cfg.MaxConcurrency = 2
+	cfg.MaxTenantPushes = 1
+	b := newBatcher(cfg, svc, log.NewNopLogger())
+	ctxA := user.InjectOrgID(context.Background(), "tenant-a")
+
+	errs := make(chan error, 2)
+	go func() { errs <- b.push(ctxA, testSeries("a")) }()
+	require.Eventually(t, func() bool { return len(b.pushes) == 1 }, time.Second, time.Millisecond)
+
+	// The tenant is at its limit, other tenants are not affected.
+	err := b.push(ctxA, testSeries("b"))
+	require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+	go func() { errs <- b.push(user.InjectOrgID(context.Background(), "tenant-b"), testSeries("c")) }()
+	require.Eventually(t, func() bool { return len(b.pushes) == 2 }, time.Second, time.Millisecond)
+
+	close(release)
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	// The tenant slot is released after the callers are notified.
+	require.Eventually(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.tenantPushes) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func Test_Batcher_SingleBatchPerUpload(t *testing.T) {
+	svc := new(funcPushService)
+	cfg := testBatchConfig()
+	cfg.FlushInterval = 10 * time.Millisecond
+	h := NewPyroscopeIngestHandler(svc, cfg, log.NewNopLogger())
+
+	fn := &profile.Function{ID: 1, Name: "foo"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+		Sample:   []*profile.Sample{{Value: []int64{1, 2}, Location: []*profile.Location{loc}}},
+		Location: []*profile.Location{loc},
+		Function: []*profile.Function{fn},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, p.Write(&buf))
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/ingest?name=app&format=pprof", &buf)
+	h.ServeHTTP(res, req.WithContext(user.InjectOrgID(req.Context(), "tenant-a")))
+	require.Equal(t, 200, res.Code)
+	// The trees of all the sample types are pushed at once.
+	require.Len(t, svc.requests, 1)
+	require.Len(t, svc.requests[0].Series, 2)
+}
+
+func Test_BatchConfig_Validate(t *testing.T) {
+	cfg := testBatchConfig()
+	require.NoError(t, cfg.Validate())
+	cfg.MaxQueuedPushes = 0
+	require.Error(t, cfg.Validate())
+	cfg.Enabled = false
+	require.NoError(t, cfg.Validate())
+}
@@ -10,35 +10,128 @@ import (
 	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
 	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/model/labels"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/grafana/pyroscope/pkg/og/ingestion"
 	"github.com/grafana/pyroscope/pkg/og/storage"
+	"github.com/grafana/pyroscope/pkg/og/storage/segment"
 	"github.com/grafana/pyroscope/pkg/og/storage/tree"
 
 	pushv1 "github.com/grafana/pyroscope/api/gen/proto/go/push/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+// spanProfilesTotal is the number of span profiles, i.e. the trees
+// with the profile_id label, ingested through the legacy ingest API.
+var spanProfilesTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "pyroscope",
+		Name:      "ingest_span_profiles_total",
+		Help:      "The total number of span profiles received through the legacy ingest API, by outcome.",
+	},
+	[]string{"outcome", "tenant"},
 )
 
 type PushService interface {
 	Push(ctx context.Context, req *connect.Request[pushv1.PushRequest]) (*connect.Response[pushv1.PushResponse], error)
 }
 
-func NewPyroscopeIngestHandler(svc PushService, logger log.Logger) http.Handler {
-	return NewIngestHandler(
-		logger,
-		&pyroscopeIngesterAdapter{svc: svc},
-	)
+func NewPyroscopeIngestHandler(svc PushService, cfg BatchConfig, logger log.Logger) http.Handler {
+	p := &pyroscopeIngesterAdapter{svc: svc}
+	if cfg.Enabled {
+		p.batcher = newBatcher(cfg, svc, logger)
+	}
+	return NewIngestHandler(logger, p)
+}
+
+// NewPutter returns a storage.Putter converting legacy Pyroscope trees
+// to pprof profiles pushed to svc, the same way the ingest handler does.
+func NewPutter(svc PushService) storage.Putter {
+	return &pyroscopeIngesterAdapter{svc: svc}
 }
 
 type pyroscopeIngesterAdapter struct {
 	svc PushService
+	// batcher is nil if the profiles are not batched.
+	batcher *batcher
 }
 
 func (p *pyroscopeIngesterAdapter) Ingest(ctx context.Context, in *ingestion.IngestInput) error {
-	return in.Profile.Parse(ctx, p, p, in.Metadata)
+	var s spanProfiles
+	err := in.Profile.Parse(ctx, &s, p, in.Metadata)
+	if pushErr := s.push(ctx, p); pushErr != nil {
+		err = multierror.Append(err, pushErr)
+	}
+	return err
+}
+
+// spanProfiles collects the trees of a parsed profile before they are
+// pushed. The parsers also account the samples of a span profile, i.e. a
+// tree with the profile_id label, in the tree without the label: they are
+// subtracted from the latter, so that the samples are not stored twice.
+type spanProfiles struct {
+	inputs []*storage.PutInput
+}
+
+func (s *spanProfiles) Put(_ context.Context, pi *storage.PutInput) error {
+	s.inputs = append(s.inputs, pi)
+	return nil
+}
+
+func (s *spanProfiles) push(ctx context.Context, p *pyroscopeIngesterAdapter) error {
+	baseline := make(map[string]*storage.PutInput, len(s.inputs))
+	for _, pi := range s.inputs {
+		if !pi.Key.HasProfileID() {
+			baseline[pi.Key.Normalized()] = pi
+		}
+	}
+	for _, pi := range s.inputs {
+		if !pi.Key.HasProfileID() {
+			continue
+		}
+		k := pi.Key.Clone()
+		k.Add(segment.ProfileIDLabelName, "")
+		if b, ok := baseline[k.Normalized()]; ok {
+			b.Val.Subtract(pi.Val)
+		}
+	}
+	// All the profiles are pushed at once, those that can't
+	// be converted are reported along with the push error.
+	var err error
+	var spans, dropped int
+	series := make([]*pushv1.RawProfileSeries, 0, len(s.inputs))
+	for _, pi := range s.inputs {
+		isSpan := pi.Key.HasProfileID()
+		rs, convErr := convertPutInput(pi)
+		if convErr != nil {
+			err = multierror.Append(err, convErr)
+			if isSpan {
+				dropped++
+			}
+			continue
+		}
+		if isSpan {
+			spans++
+		}
+		series = append(series, rs)
+	}
+	if pushErr := p.push(ctx, series); pushErr != nil {
+		err = multierror.Append(err, pushErr)
+		dropped += spans
+		spans = 0
+	}
+	if spans > 0 || dropped > 0 {
+		tenantID, _ := tenant.ExtractTenantIDFromContext(ctx)
+		spanProfilesTotal.WithLabelValues("ingested", tenantID).Add(float64(spans))
+		spanProfilesTotal.WithLabelValues("dropped", tenantID).Add(float64(dropped))
+	}
+	return err
 }
 
 const (
@@ -57,13 +150,42 @@ const (
 	stUnitNanos       = "nanoseconds"
 )
 
+// Put pushes the tree right away. The ingest handler does not use it:
+// the trees of an upload are collected and pushed as a single batch,
+// so that the converters putting one tree per sample type wait for the
+// batch only once.
 func (p *pyroscopeIngesterAdapter) Put(ctx context.Context, pi *storage.PutInput) error {
-	if pi.Key.HasProfileID() {
+	series, err := convertPutInput(pi)
+	if err != nil {
+		return err
+	}
+	_, err = p.svc.Push(ctx, connect.NewRequest(&pushv1.PushRequest{Series: []*pushv1.RawProfileSeries{series}}))
+	if err != nil {
+		return fmt.Errorf("pyroscopeIngesterAdapter failed to push: %w", err)
+	}
+	return nil
+}
+
+func (p *pyroscopeIngesterAdapter) push(ctx context.Context, series []*pushv1.RawProfileSeries) error {
+	if len(series) == 0 {
 		return nil
 	}
+	var err error
+	if p.batcher != nil {
+		err = p.batcher.push(ctx, series)
+	} else {
+		_, err = p.svc.Push(ctx, connect.NewRequest(&pushv1.PushRequest{Series: series}))
+	}
+	if err != nil {
+		return fmt.Errorf("pyroscopeIngesterAdapter failed to push: %w", err)
+	}
+	return nil
+}
+
+func convertPutInput(pi *storage.PutInput) (*pushv1.RawProfileSeries, error) {
 	metric, stType, stUnit, app, err := convertMetadata(pi)
 	if err != nil {
-		return connect.NewError(
+		return nil, connect.NewError(
 			connect.CodeInvalidArgument,
 			fmt.Errorf("pyroscopeIngesterAdapter failed to convert metadata: %w", err),
 		)
@@ -87,14 +209,18 @@ func (p *pyroscopeIngesterAdapter) Put(ctx context.Context, pi *storage.PutInput
 		pi.Val.Scale(uint64(period))
 	}
 	pprof := pi.Val.Pprof(mdata)
+	// The samples of a span profile are labeled with the span ID rather than
+	// the series: the profile_id label would make every span a new series.
+	if profileID, ok := pi.Key.ProfileID(); ok && profileID != "" {
+		addSpanIDLabel(pprof, profileID)
+	}
 	b, err := proto.Marshal(pprof)
 	if err != nil {
-		return connect.NewError(
+		return nil, connect.NewError(
 			connect.CodeInvalidArgument,
 			fmt.Errorf("pyroscopeIngesterAdapter failed to marshal pprof: %w", err),
 		)
 	}
-	req := &pushv1.PushRequest{}
 	series := &pushv1.RawProfileSeries{
 		Labels: make([]*typesv1.LabelPair, 0, 3+len(pi.Key.Labels())),
 	}
@@ -113,7 +239,7 @@ func (p *pyroscopeIngesterAdapter) Put(ctx context.Context, pi *storage.PutInput
 	}
 	hasServiceName := false
 	for k, v := range pi.Key.Labels() {
-		if strings.HasPrefix(k, "__") {
+		if strings.HasPrefix(k, "__") || k == segment.ProfileIDLabelName {
 			continue
 		}
 		if k == "service_name" {
@@ -140,12 +266,15 @@ func (p *pyroscopeIngesterAdapter) Put(ctx context.Context, pi *storage.PutInput
 		RawProfile: b,
 		ID:         uuid.New().String(),
 	}}
-	req.Series = append(req.Series, series)
-	_, err = p.svc.Push(ctx, connect.NewRequest(req))
-	if err != nil {
-		return fmt.Errorf("pyroscopeIngesterAdapter failed to push: %w", err)
+	return series, nil
+}
+
+func addSpanIDLabel(p *tree.Profile, spanID string) {
+	key := int64(len(p.StringTable))
+	p.StringTable = append(p.StringTable, phlaremodel.SpanIDLabelName, spanID)
+	for _, s := range p.Sample {
+		s.Label = append(s.Label, &tree.Label{Key: key, Str: key + 1})
 	}
-	return nil
 }
 
 func (p *pyroscopeIngesterAdapter) Evaluate(input *storage.PutInput) (storage.SampleObserver, bool) {
@@ -229,6 +358,14 @@ func convertMetadata(pi *storage.PutInput) (metricName, stType, stUnit, app stri
 		stType = stTypeDelay
 		stUnit = stUnitNanos
 		metricName = metricBlock
+	case "monitor_wait_count":
+		stType = stTypeContentions
+		stUnit = stUnitCount
+		metricName = "monitor_wait"
+	case "monitor_wait_duration":
+		stType = stTypeDelay
+		stUnit = stUnitNanos
+		metricName = "monitor_wait"
 	case "live":
 		metricName = metricMemory
 		stType = "live"
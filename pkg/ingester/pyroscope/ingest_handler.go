@@ -153,6 +153,7 @@ func (h ingestHandler) ingestInputFromRequest(r *http.Request) (*ingestion.Inges
 		input.Profile = &jfr.RawProfile{
 			FormDataContentType: contentType,
 			RawData:             b,
+			ThreadLabels:        q.Get("threadLabels") == "true",
 		}
 
 	case format == "pprof":
@@ -13,6 +13,7 @@ import (
 	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log"
 	"github.com/google/pprof/profile"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/slices"
 
@@ -135,7 +136,7 @@ func TestIngestJFR(b *testing.T) {
 			src := testdataDir + "/" + jfr
 			jfr, err := bench.ReadGzipFile(src)
 			svc := &MockPushService{Keep: true, T: t}
-			h := NewPyroscopeIngestHandler(svc, l)
+			h := NewPyroscopeIngestHandler(svc, BatchConfig{}, l)
 			require.NoError(t, err)
 
 			res := httptest.NewRecorder()
@@ -166,7 +167,7 @@ func BenchmarkIngestJFR(b *testing.B) {
 		"cortex-dev-01__kafka-0__cpu_lock_alloc__3.jfr.gz",
 	}
 	l := log.NewSyncLogger(log.NewLogfmtLogger(os.Stderr))
-	h := NewPyroscopeIngestHandler(&MockPushService{}, l)
+	h := NewPyroscopeIngestHandler(&MockPushService{}, BatchConfig{}, l)
 
 	for _, jfr := range jfrs {
 		b.Run(jfr, func(b *testing.B) {
@@ -182,3 +183,41 @@ func BenchmarkIngestJFR(b *testing.B) {
 	}
 
 }
+
+func TestIngestSpanProfiles(t *testing.T) {
+	fn := &profile.Function{ID: 1, Name: "foo"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Function:   []*profile.Function{fn},
+		Location:   []*profile.Location{loc},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc}, Value: []int64{10}},
+			{Location: []*profile.Location{loc}, Value: []int64{20}, Label: map[string][]string{"profile_id": {"00000000000000ab"}}},
+		},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, p.Write(&buf))
+
+	svc := &MockPushService{Keep: true, T: t}
+	h := NewPyroscopeIngestHandler(svc, BatchConfig{}, log.NewNopLogger())
+	ingested := testutil.ToFloat64(spanProfilesTotal.WithLabelValues("ingested", ""))
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/ingest?name=app&format=pprof", &buf)
+	h.ServeHTTP(res, req)
+	require.Equal(t, 200, res.Code)
+	require.Equal(t, ingested+1, testutil.ToFloat64(spanProfilesTotal.WithLabelValues("ingested", "")))
+
+	require.Len(t, svc.req, 2)
+	var spans []string
+	for _, series := range svc.req {
+		require.Empty(t, phlaremodel.Labels(series.Labels).Get("profile_id"))
+		pp, err := profile.ParseData(series.Samples[0].RawProfile)
+		require.NoError(t, err)
+		require.Len(t, pp.Sample, 1)
+		spans = append(spans, fmt.Sprintf("%v %d", pp.Sample[0].Label[phlaremodel.SpanIDLabelName], pp.Sample[0].Value[0]))
+	}
+	slices.Sort(spans)
+	// The samples of the span profile are not accounted twice.
+	require.Equal(t, []string{"[00000000000000ab] 20", "[] 10"}, spans)
+}
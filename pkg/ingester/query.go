@@ -7,6 +7,8 @@ import (
 
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	querylimiter "github.com/grafana/pyroscope/pkg/util/limiter"
 )
 
 // LabelValues returns the possible label values for a given label name.
@@ -37,20 +39,51 @@ func (i *Ingester) Series(ctx context.Context, req *connect.Request[ingestv1.Ser
 	})
 }
 
+// LabelCardinality returns the cardinality of the labels of the series matching the given set of matchers.
+func (i *Ingester) LabelCardinality(ctx context.Context, req *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	return forInstanceUnary(ctx, i, func(instance *instance) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+		return instance.LabelCardinality(ctx, req)
+	})
+}
+
+// SelectProfilesMetadata lists the metadata of the profiles matching the request.
+func (i *Ingester) SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	return forInstanceUnary(ctx, i, func(instance *instance) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+		return instance.SelectProfilesMetadata(i.withQueryLimiter(ctx), req)
+	})
+}
+
+// SelectExemplars lists the exemplars of the profiles matching the request.
+func (i *Ingester) SelectExemplars(ctx context.Context, req *connect.Request[ingestv1.SelectExemplarsRequest]) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+	return forInstanceUnary(ctx, i, func(instance *instance) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+		return instance.SelectExemplars(i.withQueryLimiter(ctx), req)
+	})
+}
+
 func (i *Ingester) MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
 	return i.forInstance(ctx, func(instance *instance) error {
-		return instance.MergeProfilesStacktraces(ctx, stream)
+		return instance.MergeProfilesStacktraces(i.withQueryLimiter(ctx), stream)
 	})
 }
 
 func (i *Ingester) MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesLabelsRequest, ingestv1.MergeProfilesLabelsResponse]) error {
 	return i.forInstance(ctx, func(instance *instance) error {
-		return instance.MergeProfilesLabels(ctx, stream)
+		return instance.MergeProfilesLabels(i.withQueryLimiter(ctx), stream)
 	})
 }
 
 func (i *Ingester) MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesPprofRequest, ingestv1.MergeProfilesPprofResponse]) error {
 	return i.forInstance(ctx, func(instance *instance) error {
-		return instance.MergeProfilesPprof(ctx, stream)
+		return instance.MergeProfilesPprof(i.withQueryLimiter(ctx), stream)
 	})
 }
+
+// withQueryLimiter returns a context carrying the limiter
+// of the query cost, enforcing the tenant's query limits.
+func (i *Ingester) withQueryLimiter(ctx context.Context) context.Context {
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return ctx
+	}
+	return querylimiter.AddQueryLimiterToContext(ctx, querylimiter.NewQueryLimiter(tenantID, i.limits))
+}
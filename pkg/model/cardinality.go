@@ -0,0 +1,147 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// LabelCardinalityMerger accumulates the number of series and bytes per
+// label value, and builds the label cardinality response out of them.
+type LabelCardinalityMerger struct {
+	mu     sync.Mutex
+	labels map[string]map[string]*labelValueStats
+}
+
+type labelValueStats struct {
+	series    float64
+	bytes     float64
+	firstSeen int64
+}
+
+func NewLabelCardinalityMerger() *LabelCardinalityMerger {
+	return &LabelCardinalityMerger{
+		labels: make(map[string]map[string]*labelValueStats),
+	}
+}
+
+// AddSeries accounts a series with the given labels. The series must be
+// added only once. firstSeen is the time the series was first seen, in
+// milliseconds since epoch.
+func (m *LabelCardinalityMerger) AddSeries(lbs Labels, bytes int64, firstSeen int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range lbs {
+		m.add(l.Name, l.Value, 1, float64(bytes), firstSeen)
+	}
+}
+
+// MergeLabelCardinality merges the label values of the response, which must
+// have been requested with no limit. Series are replicated, therefore series
+// and bytes counts are divided by replicas, the number of responses each
+// series is expected to be found in: the result is an approximation.
+func (m *LabelCardinalityMerger) MergeLabelCardinality(r *typesv1.LabelCardinalityResponse, replicas float64) {
+	f := math.Max(replicas, 1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range r.Labels {
+		for _, v := range l.Values {
+			m.add(l.Name, v.Value, float64(v.SeriesCount)/f, float64(v.Bytes)/f, v.FirstSeen)
+		}
+	}
+}
+
+func (m *LabelCardinalityMerger) add(name, value string, series, bytes float64, firstSeen int64) {
+	values, ok := m.labels[name]
+	if !ok {
+		values = make(map[string]*labelValueStats)
+		m.labels[name] = values
+	}
+	s, ok := values[value]
+	if !ok {
+		values[value] = &labelValueStats{series: series, bytes: bytes, firstSeen: firstSeen}
+		return
+	}
+	s.series += series
+	s.bytes += bytes
+	if firstSeen < s.firstSeen {
+		s.firstSeen = firstSeen
+	}
+}
+
+// LabelCardinality builds the response to the label cardinality request.
+// Labels are ordered by the number of distinct values, in descending order.
+// If the request limit is 0, all the label values are returned; otherwise,
+// only the top values by series and by bytes are.
+func (m *LabelCardinalityMerger) LabelCardinality(req *typesv1.LabelCardinalityRequest) *typesv1.LabelCardinalityResponse {
+	limit, growthSince := req.Limit, labelCardinalityGrowthSince(req)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &typesv1.LabelCardinalityResponse{
+		Labels: make([]*typesv1.LabelCardinality, 0, len(m.labels)),
+	}
+	for name, values := range m.labels {
+		l := &typesv1.LabelCardinality{
+			Name:        name,
+			ValuesCount: int64(len(values)),
+		}
+		all := make([]*typesv1.LabelValueCardinality, 0, len(values))
+		for value, s := range values {
+			v := &typesv1.LabelValueCardinality{
+				Value:       value,
+				SeriesCount: int64(math.Round(s.series)),
+				Bytes:       int64(math.Round(s.bytes)),
+				FirstSeen:   s.firstSeen,
+			}
+			l.SeriesCount += v.SeriesCount
+			l.Bytes += v.Bytes
+			if v.FirstSeen >= growthSince {
+				l.NewValuesCount++
+			}
+			all = append(all, v)
+		}
+		if limit <= 0 {
+			sort.Slice(all, func(i, j int) bool {
+				return all[i].Value < all[j].Value
+			})
+			l.Values = all
+		} else {
+			l.TopValuesBySeries = topLabelValues(all, limit, func(v *typesv1.LabelValueCardinality) int64 { return v.SeriesCount })
+			l.TopValuesByBytes = topLabelValues(all, limit, func(v *typesv1.LabelValueCardinality) int64 { return v.Bytes })
+		}
+		r.Labels = append(r.Labels, l)
+	}
+	sort.Slice(r.Labels, func(i, j int) bool {
+		if r.Labels[i].ValuesCount != r.Labels[j].ValuesCount {
+			return r.Labels[i].ValuesCount > r.Labels[j].ValuesCount
+		}
+		return r.Labels[i].Name < r.Labels[j].Name
+	})
+	return r
+}
+
+// labelCardinalityGrowthSince returns the time since which label values are
+// counted as new: if not set in the request, the middle of the time range.
+func labelCardinalityGrowthSince(req *typesv1.LabelCardinalityRequest) int64 {
+	if req.GrowthSince != 0 {
+		return req.GrowthSince
+	}
+	return req.Start + (req.End-req.Start)/2
+}
+
+func topLabelValues(values []*typesv1.LabelValueCardinality, limit int64, by func(*typesv1.LabelValueCardinality) int64) []*typesv1.LabelValueCardinality {
+	top := make([]*typesv1.LabelValueCardinality, len(values))
+	copy(top, values)
+	sort.Slice(top, func(i, j int) bool {
+		if a, b := by(top[i]), by(top[j]); a != b {
+			return a > b
+		}
+		return top[i].Value < top[j].Value
+	})
+	if int64(len(top)) > limit {
+		top = top[:limit]
+	}
+	return top
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+func Test_LabelCardinalityMerger(t *testing.T) {
+	source := NewLabelCardinalityMerger()
+	source.AddSeries(LabelsFromStrings("service", "a", "pod", "a-1"), 100, 10)
+	source.AddSeries(LabelsFromStrings("service", "a", "pod", "a-2"), 300, 20)
+	source.AddSeries(LabelsFromStrings("service", "b", "pod", "b-1"), 50, 30)
+	r := source.LabelCardinality(&typesv1.LabelCardinalityRequest{Start: 0, End: 40})
+
+	// Two replicas returned the same series.
+	m := NewLabelCardinalityMerger()
+	m.MergeLabelCardinality(r, 2)
+	m.MergeLabelCardinality(r, 2)
+
+	require.Equal(t, &typesv1.LabelCardinalityResponse{
+		Labels: []*typesv1.LabelCardinality{
+			{
+				Name:           "pod",
+				ValuesCount:    3,
+				SeriesCount:    3,
+				Bytes:          450,
+				NewValuesCount: 2,
+				TopValuesBySeries: []*typesv1.LabelValueCardinality{
+					{Value: "a-1", SeriesCount: 1, Bytes: 100, FirstSeen: 10},
+					{Value: "a-2", SeriesCount: 1, Bytes: 300, FirstSeen: 20},
+				},
+				TopValuesByBytes: []*typesv1.LabelValueCardinality{
+					{Value: "a-2", SeriesCount: 1, Bytes: 300, FirstSeen: 20},
+					{Value: "a-1", SeriesCount: 1, Bytes: 100, FirstSeen: 10},
+				},
+			},
+			{
+				Name:           "service",
+				ValuesCount:    2,
+				SeriesCount:    3,
+				Bytes:          450,
+				NewValuesCount: 1,
+				TopValuesBySeries: []*typesv1.LabelValueCardinality{
+					{Value: "a", SeriesCount: 2, Bytes: 400, FirstSeen: 10},
+					{Value: "b", SeriesCount: 1, Bytes: 50, FirstSeen: 30},
+				},
+				TopValuesByBytes: []*typesv1.LabelValueCardinality{
+					{Value: "a", SeriesCount: 2, Bytes: 400, FirstSeen: 10},
+					{Value: "b", SeriesCount: 1, Bytes: 50, FirstSeen: 30},
+				},
+			},
+		},
+	}, m.LabelCardinality(&typesv1.LabelCardinalityRequest{Start: 0, End: 40, Limit: 2}))
+}
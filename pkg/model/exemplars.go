@@ -0,0 +1,161 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
+)
+
+// DefaultExemplarsLimit is the number of exemplars returned if the request
+// does not set the limit.
+const DefaultExemplarsLimit = 100
+
+// ExemplarsMerger merges lists of exemplars, e.g. returned by the replicas
+// of the series. Exemplars are deduplicated by their span ID, timestamp
+// and labels.
+type ExemplarsMerger struct {
+	mu        sync.Mutex
+	exemplars map[exemplarKey]*typesv1.Exemplar
+}
+
+type exemplarKey struct {
+	id        string
+	timestamp int64
+	labels    uint64
+}
+
+func NewExemplarsMerger() *ExemplarsMerger {
+	return &ExemplarsMerger{exemplars: make(map[exemplarKey]*typesv1.Exemplar)}
+}
+
+func (m *ExemplarsMerger) MergeExemplars(exemplars []*typesv1.Exemplar) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range exemplars {
+		k := exemplarKey{id: e.ProfileId, timestamp: e.Timestamp, labels: Labels(e.Labels).Hash()}
+		if _, ok := m.exemplars[k]; !ok {
+			m.exemplars[k] = e
+		}
+	}
+}
+
+// Exemplars returns the exemplars with the highest value, up to the limit,
+// if it is greater than 0.
+func (m *ExemplarsMerger) Exemplars(limit int64) []*typesv1.Exemplar {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	exemplars := make([]*typesv1.Exemplar, 0, len(m.exemplars))
+	for _, e := range m.exemplars {
+		exemplars = append(exemplars, e)
+	}
+	SortExemplars(exemplars)
+	if limit > 0 && int64(len(exemplars)) > limit {
+		exemplars = exemplars[:limit]
+	}
+	return exemplars
+}
+
+// Heatmap counts the exemplars by time and value, over the time range
+// [start, end] and the values [minValue, maxValue]. If maxValue is 0, the
+// highest value of the exemplars is used.
+func (m *ExemplarsMerger) Heatmap(start, end, minValue, maxValue, timeBuckets, valueBuckets int64) *querierv1.Heatmap {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if maxValue == 0 {
+		for _, e := range m.exemplars {
+			if e.Value > maxValue {
+				maxValue = e.Value
+			}
+		}
+	}
+	h := &querierv1.Heatmap{
+		Start:        start,
+		End:          end,
+		MinValue:     minValue,
+		MaxValue:     maxValue,
+		TimeBuckets:  timeBuckets,
+		ValueBuckets: valueBuckets,
+		Counts:       make([]uint64, timeBuckets*valueBuckets),
+	}
+	for _, e := range m.exemplars {
+		if e.Timestamp < start || e.Timestamp > end || e.Value < minValue || e.Value > maxValue {
+			continue
+		}
+		t := heatmapBucket(e.Timestamp-start, end-start+1, timeBuckets)
+		v := heatmapBucket(e.Value-minValue, maxValue-minValue+1, valueBuckets)
+		h.Counts[t*valueBuckets+v]++
+	}
+	return h
+}
+
+// heatmapBucket returns the bucket of the offset x within a range of the
+// given size, split into n buckets.
+func heatmapBucket(x, size, n int64) int64 {
+	b := int64(float64(x) / float64(size) * float64(n))
+	if b >= n {
+		return n - 1
+	}
+	return b
+}
+
+// MergeHeatmap adds the counts of the heatmap src to dst. Both heatmaps
+// must have the same bounds and buckets.
+func MergeHeatmap(dst, src *querierv1.Heatmap) error {
+	if dst.Start != src.Start || dst.End != src.End ||
+		dst.MinValue != src.MinValue || dst.MaxValue != src.MaxValue ||
+		dst.TimeBuckets != src.TimeBuckets || dst.ValueBuckets != src.ValueBuckets ||
+		len(dst.Counts) != len(src.Counts) {
+		return fmt.Errorf("heatmap bounds mismatch")
+	}
+	for i, c := range src.Counts {
+		dst.Counts[i] += c
+	}
+	return nil
+}
+
+// ExportHeatmapToFlamebearer converts the heatmap to the format of the
+// legacy Pyroscope API, where the times are in nanoseconds.
+func ExportHeatmapToFlamebearer(h *querierv1.Heatmap) *flamebearer.Heatmap {
+	if h == nil || int64(len(h.Counts)) != h.TimeBuckets*h.ValueBuckets {
+		return nil
+	}
+	fb := &flamebearer.Heatmap{
+		Values:       make([][]uint64, h.TimeBuckets),
+		TimeBuckets:  h.TimeBuckets,
+		ValueBuckets: h.ValueBuckets,
+		StartTime:    h.Start * int64(time.Millisecond),
+		EndTime:      h.End * int64(time.Millisecond),
+		MinValue:     uint64(h.MinValue),
+		MaxValue:     uint64(h.MaxValue),
+	}
+	for t := range fb.Values {
+		fb.Values[t] = h.Counts[int64(t)*h.ValueBuckets : int64(t+1)*h.ValueBuckets]
+		for _, c := range fb.Values[t] {
+			if c > 0 && (fb.MinDepth == 0 || c < fb.MinDepth) {
+				fb.MinDepth = c
+			}
+			if c > fb.MaxDepth {
+				fb.MaxDepth = c
+			}
+		}
+	}
+	return fb
+}
+
+// SortExemplars sorts the exemplars, the highest value first.
+func SortExemplars(exemplars []*typesv1.Exemplar) {
+	sort.Slice(exemplars, func(i, j int) bool {
+		if exemplars[i].Value != exemplars[j].Value {
+			return exemplars[i].Value > exemplars[j].Value
+		}
+		if exemplars[i].Timestamp != exemplars[j].Timestamp {
+			return exemplars[i].Timestamp > exemplars[j].Timestamp
+		}
+		return exemplars[i].ProfileId < exemplars[j].ProfileId
+	})
+}
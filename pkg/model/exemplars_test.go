@@ -0,0 +1,72 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+func Test_ExemplarsMerger(t *testing.T) {
+	a := &typesv1.Exemplar{ProfileId: "a", Timestamp: 1, Value: 10, Labels: LabelsFromStrings("pod", "a")}
+	b := &typesv1.Exemplar{ProfileId: "b", Timestamp: 2, Value: 30, Labels: LabelsFromStrings("pod", "a")}
+	c := &typesv1.Exemplar{ProfileId: "b", Timestamp: 5, Value: 20, Labels: LabelsFromStrings("pod", "a")}
+
+	// Two replicas returned the same exemplars.
+	m := NewExemplarsMerger()
+	m.MergeExemplars([]*typesv1.Exemplar{a, b})
+	m.MergeExemplars([]*typesv1.Exemplar{b.CloneVT(), c})
+
+	require.Equal(t, []*typesv1.Exemplar{b, c, a}, m.Exemplars(0))
+	require.Equal(t, []*typesv1.Exemplar{b, c}, m.Exemplars(2))
+
+	// The values [0, 30] are split into 3 buckets of 31/3 values, and the
+	// times [0, 5] into 2 buckets of 3 milliseconds.
+	require.Equal(t, &querierv1.Heatmap{
+		Start:        0,
+		End:          5,
+		MinValue:     0,
+		MaxValue:     30,
+		TimeBuckets:  2,
+		ValueBuckets: 3,
+		Counts: []uint64{
+			1, 0, 1,
+			0, 1, 0,
+		},
+	}, m.Heatmap(0, 5, 0, 0, 2, 3))
+
+	// The exemplars out of bounds are not counted.
+	require.Equal(t, []uint64{1, 0}, m.Heatmap(0, 5, 15, 25, 1, 2).Counts)
+	require.Equal(t, []uint64{0, 0}, m.Heatmap(0, 4, 15, 25, 1, 2).Counts)
+}
+
+func Test_MergeHeatmap(t *testing.T) {
+	h := &querierv1.Heatmap{End: 10, MaxValue: 10, TimeBuckets: 1, ValueBuckets: 2, Counts: []uint64{1, 2}}
+	require.NoError(t, MergeHeatmap(h, h.CloneVT()))
+	require.Equal(t, []uint64{2, 4}, h.Counts)
+
+	other := h.CloneVT()
+	other.MaxValue = 20
+	require.Error(t, MergeHeatmap(h, other))
+}
+
+func Test_ExportHeatmapToFlamebearer(t *testing.T) {
+	fb := ExportHeatmapToFlamebearer(&querierv1.Heatmap{
+		Start:        1000,
+		End:          2000,
+		MaxValue:     10,
+		TimeBuckets:  2,
+		ValueBuckets: 2,
+		Counts:       []uint64{0, 3, 1, 0},
+	})
+	require.Equal(t, [][]uint64{{0, 3}, {1, 0}}, fb.Values)
+	require.Equal(t, int64(1e9), fb.StartTime)
+	require.Equal(t, int64(2e9), fb.EndTime)
+	require.Equal(t, uint64(10), fb.MaxValue)
+	require.Equal(t, uint64(1), fb.MinDepth)
+	require.Equal(t, uint64(3), fb.MaxDepth)
+
+	require.Nil(t, ExportHeatmapToFlamebearer(&querierv1.Heatmap{TimeBuckets: 2, ValueBuckets: 2}))
+}
@@ -0,0 +1,256 @@
+package model
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/grafana/pyroscope/pkg/og/structs/cappedarr"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+)
+
+// NewMultiValueMergeResponse returns the trees of the profile type and
+// the additional profile types of the request in the format of the
+// request: as trees in the tree format, and as a multi-value flame graph
+// otherwise.
+func NewMultiValueMergeResponse(req *querierv1.SelectMergeStacktracesRequest, trees []*Tree) (*querierv1.SelectMergeStacktracesResponse, error) {
+	if req.Format != querierv1.ProfileFormat_PROFILE_FORMAT_TREE {
+		profileTypeIDs := append([]string{req.ProfileTypeID}, req.AdditionalProfileTypeIDs...)
+		return &querierv1.SelectMergeStacktracesResponse{
+			MultiValueFlamegraph: NewFlameGraphMultiValue(profileTypeIDs, trees, req.GetMaxNodes()),
+		}, nil
+	}
+	// A negative max nodes value means the trees are not truncated.
+	res := new(querierv1.SelectMergeStacktracesResponse)
+	for i, t := range trees {
+		var buf bytes.Buffer
+		if err := t.MarshalTruncate(&buf, req.GetMaxNodes()); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			res.Tree = buf.Bytes()
+			continue
+		}
+		res.AdditionalTrees = append(res.AdditionalTrees, buf.Bytes())
+	}
+	return res, nil
+}
+
+// NewFlameGraphMultiValue generates a FlameGraphMultiValue from the trees
+// of the profile types, one value dimension per tree. It also prunes the
+// final tree based on the maxNodes parameter: a node is kept if its total
+// is large enough in any of the dimensions.
+//
+// For n dimensions, each node of a level is encoded as follows:
+//
+//	i+3d+0 = x offset, dimension d
+//	i+3d+1 = total   , dimension d
+//	i+3d+2 = self    , dimension d
+//	i+3n   = index in the names array
+func NewFlameGraphMultiValue(profileTypeIDs []string, trees []*Tree, maxNodes int64) *querierv1.FlameGraphMultiValue {
+	var (
+		dims     = len(trees)
+		step     = 3*dims + 1
+		combined = combineTrees(trees)
+		res      = &querierv1.FlameGraphMultiValue{
+			Names:          []string{},
+			Levels:         []*querierv1.Level{},
+			ProfileTypeIDs: profileTypeIDs,
+			Total:          make([]int64, dims),
+			MaxSelf:        make([]int64, dims),
+		}
+	)
+	if dims == 0 {
+		return res
+	}
+
+	// The nodes of the dimensions are processed side by side: the trees
+	// are combined, meaning that their structures are identical.
+	nodes := make([][]*node, dims)
+	xOffsets := make([][]int64, dims)
+	for d, t := range combined {
+		res.Total[d] = t.root[0].total
+		nodes[d] = t.root
+		xOffsets[d] = []int64{0}
+	}
+	levels := []int{0}
+	var minVal int64
+	if maxNodes > 0 {
+		minVal = int64(combineMinValuesMulti(combined, maxNodes))
+	}
+	nameLocationCache := map[string]int{}
+
+	current := make([]*node, dims)
+	xOffset := make([]int64, dims)
+	for len(nodes[0]) > 0 {
+		for d := range nodes {
+			current[d], nodes[d] = nodes[d][0], nodes[d][1:]
+			xOffset[d], xOffsets[d] = xOffsets[d][0], xOffsets[d][1:]
+		}
+		level := levels[0]
+		levels = levels[1:]
+
+		name := current[0].name
+		if !aboveMinValue(current, minVal) && name != "other" {
+			continue
+		}
+		i, ok := nameLocationCache[name]
+		if !ok {
+			i = len(res.Names)
+			nameLocationCache[name] = i
+			if i == 0 {
+				name = "total"
+			}
+			res.Names = append(res.Names, name)
+		}
+		if level == len(res.Levels) {
+			res.Levels = append(res.Levels, &querierv1.Level{})
+		}
+
+		values := make([]int64, 0, step)
+		for d, n := range current {
+			res.MaxSelf[d] = max(res.MaxSelf[d], n.self)
+			values = append(values, xOffset[d], n.total, n.self)
+			xOffset[d] += n.self
+		}
+		values = append(values, int64(i))
+		res.Levels[level].Values = append(values, res.Levels[level].Values...)
+
+		// All the dimensions have the same number of children nodes.
+		other := make([]int64, dims)
+		var hasOther bool
+		children := make([]*node, dims)
+		for ci := range current[0].children {
+			for d, n := range current {
+				children[d] = n.children[ci]
+			}
+			if !aboveMinValue(children, minVal) {
+				for d, c := range children {
+					other[d] += c.total
+					hasOther = hasOther || c.total != 0
+				}
+				continue
+			}
+			levels = prependInt(levels, level+1)
+			for d, c := range children {
+				xOffsets[d] = prependInt64(xOffsets[d], xOffset[d])
+				nodes[d] = prependTreeNode(nodes[d], c)
+				xOffset[d] += c.total
+			}
+		}
+		if hasOther {
+			levels = prependInt(levels, level+1)
+			for d := range current {
+				xOffsets[d] = prependInt64(xOffsets[d], xOffset[d])
+				nodes[d] = prependTreeNode(nodes[d], &node{
+					name:  "other",
+					total: other[d],
+					self:  other[d],
+				})
+			}
+		}
+	}
+
+	for d := 0; d < dims; d++ {
+		deltaEncoding(res.Levels, 3*d, step)
+	}
+	return res
+}
+
+func aboveMinValue(nodes []*node, minVal int64) bool {
+	for _, n := range nodes {
+		if n.total >= minVal {
+			return true
+		}
+	}
+	return false
+}
+
+// combineTrees aligns the trees by making them have the same structure,
+// with the same number of nodes in the same order. Each of the resulting
+// trees has a single root.
+func combineTrees(trees []*Tree) []*Tree {
+	combined := make([]*Tree, len(trees))
+	nodes := make([][]*node, len(trees))
+	for d, t := range trees {
+		// Differently from pyroscope, there could be multiple roots,
+		// so we add a fake root as expected.
+		combined[d] = &Tree{root: []*node{{
+			children: t.root,
+			total:    t.Total(),
+		}}}
+		nodes[d] = combined[d].root
+	}
+	current := make([]*node, len(trees))
+	for len(trees) > 0 && len(nodes[0]) > 0 {
+		for d := range nodes {
+			current[d], nodes[d] = nodes[d][0], nodes[d][1:]
+		}
+		combineChildren(current)
+		for d, n := range current {
+			nodes[d] = append(nodes[d], n.children...)
+		}
+	}
+	return combined
+}
+
+// combineChildren makes the children of the nodes equal by filling
+// them with non-existing nodes and sorting them lexicographically.
+func combineChildren(nodes []*node) {
+	byName := make([]map[string]*node, len(nodes))
+	names := make([]string, 0, len(nodes[0].children))
+	seen := make(map[string]struct{}, len(nodes[0].children))
+	for d, n := range nodes {
+		byName[d] = make(map[string]*node, len(n.children))
+		for _, c := range n.children {
+			byName[d][c.name] = c
+			if _, ok := seen[c.name]; !ok {
+				seen[c.name] = struct{}{}
+				names = append(names, c.name)
+			}
+		}
+	}
+	sort.Strings(names)
+	for d, n := range nodes {
+		children := make([]*node, len(names))
+		for i, name := range names {
+			if c, ok := byName[d][name]; ok {
+				children[i] = c
+			} else {
+				children[i] = &node{name: name, parent: n}
+			}
+		}
+		n.children = children
+	}
+}
+
+func combineMinValuesMulti(trees []*Tree, maxNodes int64) uint64 {
+	if maxNodes < 1 {
+		return 0
+	}
+	// Trees are combined, therefore the resulting tree can not have
+	// more nodes than any of them.
+	treeSize := trees[0].size(make([]*node, 0, defaultDFSSize))
+	if treeSize <= maxNodes {
+		return 0
+	}
+	c := cappedarr.New(int(maxNodes))
+	nodes := make([][]*node, len(trees))
+	for d, t := range trees {
+		nodes[d] = t.root
+	}
+	current := make([]*node, len(trees))
+	for len(nodes[0]) > 0 {
+		var total int64
+		for d := range nodes {
+			current[d], nodes[d] = nodes[d][0], nodes[d][1:]
+			total = max(total, current[d].total)
+		}
+		if c.Push(uint64(total)) {
+			for d, n := range current {
+				nodes[d] = append(n.children, nodes[d]...)
+			}
+		}
+	}
+	return c.MinValue()
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FlameGraphMultiValue(t *testing.T) {
+	tr := newTree([]stacktraces{
+		{locations: []string{"b", "a"}, value: 1},
+		{locations: []string{"c", "a"}, value: 2},
+	})
+	tr2 := newTree([]stacktraces{
+		{locations: []string{"b", "a"}, value: 4},
+		{locations: []string{"d", "a"}, value: 8},
+	})
+	tr3 := newTree([]stacktraces{
+		{locations: []string{"c", "a"}, value: 16},
+	})
+
+	res := NewFlameGraphMultiValue([]string{"x", "y", "z"}, []*Tree{tr, tr2, tr3}, 1024)
+	assert.Equal(t, []string{"x", "y", "z"}, res.ProfileTypeIDs)
+	assert.Equal(t, []string{"total", "a", "d", "c", "b"}, res.Names)
+	assert.Equal(t, []int64{3, 12, 16}, res.Total)
+	assert.Equal(t, []int64{2, 8, 16}, res.MaxSelf)
+
+	require.Equal(t, 3, len(res.Levels))
+	assert.Equal(t, []int64{0, 3, 0, 0, 12, 0, 0, 16, 0, 0}, res.Levels[0].Values)
+	assert.Equal(t, []int64{0, 3, 0, 0, 12, 0, 0, 16, 0, 1}, res.Levels[1].Values)
+	assert.Equal(t, []int64{
+		0, 1, 1, 0, 4, 4, 0, 0, 0, 4, //  b
+		0, 2, 2, 0, 0, 0, 0, 16, 16, 3, // c
+		0, 0, 0, 0, 8, 8, 0, 0, 0, 2, //  d
+	}, res.Levels[2].Values)
+}
+
+func Test_FlameGraphMultiValue_MatchesDiff(t *testing.T) {
+	newTrees := func() (*Tree, *Tree) {
+		return newTree([]stacktraces{
+				{locations: []string{"b", "a"}, value: 1},
+				{locations: []string{"c", "a"}, value: 2},
+				{locations: []string{"e", "a"}, value: 3},
+				{locations: []string{"f", "e", "a"}, value: 3},
+			}), newTree([]stacktraces{
+				{locations: []string{"b", "a"}, value: 4},
+				{locations: []string{"d", "a"}, value: 8},
+				{locations: []string{"e", "a"}, value: 12},
+				{locations: []string{"x"}, value: 1},
+			})
+	}
+	for _, maxNodes := range []int{-1, 2, 4, 1024} {
+		left, right := newTrees()
+		diff, err := NewFlamegraphDiff(left, right, maxNodes)
+		require.NoError(t, err)
+		left, right = newTrees()
+		res := NewFlameGraphMultiValue(nil, []*Tree{left, right}, int64(maxNodes))
+		assert.Equal(t, diff.Names, res.Names)
+		assert.Equal(t, diff.Levels, res.Levels)
+		assert.Equal(t, []int64{diff.LeftTicks, diff.RightTicks}, res.Total)
+	}
+}
@@ -23,10 +23,15 @@ const (
 	LabelNamePeriodType     = "__period_type__"
 	LabelNamePeriodUnit     = "__period_unit__"
 	LabelNameDelta          = "__delta__"
+	LabelNameTenantID       = "__tenant_id__"
 	LabelNameProfileName    = pmodel.MetricNameLabel
 	LabelNameServiceName    = "service_name"
 	LabelNameServiceNameK8s = "__meta_kubernetes_pod_annotation_pyroscope_io_service_name"
 
+	// LabelNameAdditionalProfileTypes holds the comma-separated IDs of
+	// the additional profile types of a multi-value profile series.
+	LabelNameAdditionalProfileTypes = "__additional_profile_types__"
+
 	labelSep = '\xfe'
 )
 
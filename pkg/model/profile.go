@@ -41,6 +41,29 @@ func ParseProfileTypeSelector(id string) (*typesv1.ProfileType, error) {
 	}, nil
 }
 
+// ParseMultiValueProfileTypes parses the profile types merged as the value
+// dimensions of one tree. The profile types must be distinct and have the
+// same profile name, i.e. be sample types of the same profiles.
+func ParseMultiValueProfileTypes(ids []string) ([]*typesv1.ProfileType, error) {
+	types := make([]*typesv1.ProfileType, 0, len(ids))
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		t, err := ParseProfileTypeSelector(id)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[id]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "profile type %q is requested more than once", id)
+		}
+		seen[id] = struct{}{}
+		if len(types) > 0 && types[0].Name != t.Name {
+			return nil, status.Errorf(codes.InvalidArgument, "profile types must have the same profile name, got %q and %q", types[0].Name, t.Name)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
 // SelectorFromProfileType builds a *label.Matcher from an profile type struct
 func SelectorFromProfileType(profileType *typesv1.ProfileType) *labels.Matcher {
 	return &labels.Matcher{
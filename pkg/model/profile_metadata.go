@@ -0,0 +1,172 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// ProfileMetadataField is a field of the profile metadata that can be
+// filtered on.
+type ProfileMetadataField string
+
+const (
+	ProfileMetadataDuration   ProfileMetadataField = "duration"
+	ProfileMetadataPeriod     ProfileMetadataField = "period"
+	ProfileMetadataTotalValue ProfileMetadataField = "total_value"
+)
+
+// ProfileMetadataPredicate compares a field of the profile metadata to a
+// value, e.g. "duration > 10s".
+type ProfileMetadataPredicate struct {
+	Field ProfileMetadataField
+	Op    string
+	Value int64
+}
+
+// Two-character operators come first, so that they are not mistaken
+// for the one-character ones.
+var profileMetadataOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// ParseProfileMetadataPredicates parses predicates in the form
+// <field> <op> <value>. Durations and periods may be given as Go
+// durations, which are converted to nanoseconds.
+func ParseProfileMetadataPredicates(predicates []string) ([]ProfileMetadataPredicate, error) {
+	result := make([]ProfileMetadataPredicate, 0, len(predicates))
+	for _, s := range predicates {
+		p, err := parseProfileMetadataPredicate(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+func parseProfileMetadataPredicate(s string) (p ProfileMetadataPredicate, err error) {
+	i, op := -1, ""
+	for _, o := range profileMetadataOps {
+		if i = strings.Index(s, o); i >= 0 {
+			op = o
+			break
+		}
+	}
+	if i < 0 {
+		return p, fmt.Errorf("invalid profile metadata predicate %q: no operator", s)
+	}
+	p.Op = op
+	p.Field = ProfileMetadataField(strings.TrimSpace(s[:i]))
+	value := strings.TrimSpace(s[i+len(op):])
+	switch p.Field {
+	case ProfileMetadataDuration, ProfileMetadataPeriod:
+		if d, err := time.ParseDuration(value); err == nil {
+			p.Value = int64(d)
+			return p, nil
+		}
+	case ProfileMetadataTotalValue:
+	default:
+		return p, fmt.Errorf("invalid profile metadata predicate %q: unknown field %q", s, p.Field)
+	}
+	if p.Value, err = strconv.ParseInt(value, 10, 64); err != nil {
+		return p, fmt.Errorf("invalid profile metadata predicate %q: invalid value %q", s, value)
+	}
+	return p, nil
+}
+
+// Match reports whether the value satisfies the predicate.
+func (p ProfileMetadataPredicate) Match(v int64) bool {
+	if p.Op == "!=" {
+		return v != p.Value
+	}
+	min, max := p.Bounds()
+	return min <= v && v <= max
+}
+
+// Bounds returns the inclusive range of the values that satisfy the
+// predicate. For the != operator, this is the whole range.
+func (p ProfileMetadataPredicate) Bounds() (min, max int64) {
+	switch p.Op {
+	case "=":
+		return p.Value, p.Value
+	case "<":
+		if p.Value == math.MinInt64 {
+			return 0, -1
+		}
+		return math.MinInt64, p.Value - 1
+	case "<=":
+		return math.MinInt64, p.Value
+	case ">":
+		if p.Value == math.MaxInt64 {
+			return 0, -1
+		}
+		return p.Value + 1, math.MaxInt64
+	case ">=":
+		return p.Value, math.MaxInt64
+	default:
+		return math.MinInt64, math.MaxInt64
+	}
+}
+
+func (p ProfileMetadataPredicate) String() string {
+	return fmt.Sprintf("%s %s %d", p.Field, p.Op, p.Value)
+}
+
+// ProfilesMetadataMerger merges lists of profiles metadata, e.g. returned
+// by the replicas of the series. Profiles are deduplicated by their ID and
+// labels.
+type ProfilesMetadataMerger struct {
+	mu       sync.Mutex
+	profiles map[profileMetadataKey]*typesv1.ProfileMetadata
+}
+
+type profileMetadataKey struct {
+	id     string
+	labels uint64
+}
+
+func NewProfilesMetadataMerger() *ProfilesMetadataMerger {
+	return &ProfilesMetadataMerger{profiles: make(map[profileMetadataKey]*typesv1.ProfileMetadata)}
+}
+
+func (m *ProfilesMetadataMerger) MergeProfilesMetadata(profiles []*typesv1.ProfileMetadata) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range profiles {
+		k := profileMetadataKey{id: p.ID, labels: Labels(p.Labels).Hash()}
+		if _, ok := m.profiles[k]; !ok {
+			m.profiles[k] = p
+		}
+	}
+}
+
+// ProfilesMetadata returns the most recent profiles, up to the limit, if
+// it is greater than 0.
+func (m *ProfilesMetadataMerger) ProfilesMetadata(limit int64) []*typesv1.ProfileMetadata {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	profiles := make([]*typesv1.ProfileMetadata, 0, len(m.profiles))
+	for _, p := range m.profiles {
+		profiles = append(profiles, p)
+	}
+	SortProfilesMetadata(profiles)
+	if limit > 0 && int64(len(profiles)) > limit {
+		profiles = profiles[:limit]
+	}
+	return profiles
+}
+
+// SortProfilesMetadata sorts the profiles, the most recent first.
+func SortProfilesMetadata(profiles []*typesv1.ProfileMetadata) {
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Timestamp != profiles[j].Timestamp {
+			return profiles[i].Timestamp > profiles[j].Timestamp
+		}
+		return profiles[i].ID < profiles[j].ID
+	})
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+func Test_ParseProfileMetadataPredicates(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		expected ProfileMetadataPredicate
+		err      bool
+	}{
+		{in: "duration > 10s", expected: ProfileMetadataPredicate{Field: ProfileMetadataDuration, Op: ">", Value: 10e9}},
+		{in: "duration>=15000", expected: ProfileMetadataPredicate{Field: ProfileMetadataDuration, Op: ">=", Value: 15000}},
+		{in: "period != 10ms", expected: ProfileMetadataPredicate{Field: ProfileMetadataPeriod, Op: "!=", Value: 10e6}},
+		{in: "total_value <= 100", expected: ProfileMetadataPredicate{Field: ProfileMetadataTotalValue, Op: "<=", Value: 100}},
+		{in: "total_value = -1", expected: ProfileMetadataPredicate{Field: ProfileMetadataTotalValue, Op: "=", Value: -1}},
+		{in: "total_value < 1s", err: true},
+		{in: "samples > 1", err: true},
+		{in: "duration", err: true},
+	} {
+		tc := tc
+		t.Run(tc.in, func(t *testing.T) {
+			actual, err := ParseProfileMetadataPredicates([]string{tc.in})
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, []ProfileMetadataPredicate{tc.expected}, actual)
+		})
+	}
+}
+
+func Test_ProfileMetadataPredicate_Match(t *testing.T) {
+	p := ProfileMetadataPredicate{Field: ProfileMetadataTotalValue, Op: "<", Value: 10}
+	require.True(t, p.Match(9))
+	require.False(t, p.Match(10))
+
+	p = ProfileMetadataPredicate{Field: ProfileMetadataTotalValue, Op: "!=", Value: 10}
+	require.True(t, p.Match(9))
+	require.False(t, p.Match(10))
+
+	p = ProfileMetadataPredicate{Field: ProfileMetadataTotalValue, Op: ">", Value: math.MaxInt64}
+	require.False(t, p.Match(math.MaxInt64))
+}
+
+func Test_ProfilesMetadataMerger(t *testing.T) {
+	a := &typesv1.ProfileMetadata{ID: "a", Timestamp: 1, Labels: LabelsFromStrings("pod", "a")}
+	b := &typesv1.ProfileMetadata{ID: "b", Timestamp: 2, Labels: LabelsFromStrings("pod", "a")}
+	c := &typesv1.ProfileMetadata{ID: "c", Timestamp: 2, Labels: LabelsFromStrings("pod", "b")}
+
+	// Two replicas returned the same profiles.
+	m := NewProfilesMetadataMerger()
+	m.MergeProfilesMetadata([]*typesv1.ProfileMetadata{a, b})
+	m.MergeProfilesMetadata([]*typesv1.ProfileMetadata{b.CloneVT(), c})
+
+	require.Equal(t, []*typesv1.ProfileMetadata{b, c, a}, m.ProfilesMetadata(0))
+	require.Equal(t, []*typesv1.ProfileMetadata{b, c}, m.ProfilesMetadata(2))
+}
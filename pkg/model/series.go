@@ -15,6 +15,34 @@ func SumSeries(series ...[]*typesv1.Series) []*typesv1.Series {
 	return m.Series()
 }
 
+// ConcatSeries merges the series with the same labels, keeping all their
+// points: points sharing a timestamp are neither summed up nor discarded.
+// The points of each series are sorted by timestamp.
+func ConcatSeries(series ...[]*typesv1.Series) []*typesv1.Series {
+	m := make(map[uint64]*typesv1.Series)
+	for _, ss := range series {
+		for _, s := range ss {
+			h := Labels(s.Labels).Hash()
+			if d, ok := m[h]; ok {
+				d.Points = append(d.Points, s.Points...)
+				continue
+			}
+			m[h] = s
+		}
+	}
+	r := make([]*typesv1.Series, 0, len(m))
+	for _, s := range m {
+		sort.SliceStable(s.Points, func(i, j int) bool {
+			return s.Points[i].Timestamp < s.Points[j].Timestamp
+		})
+		r = append(r, s)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return CompareLabelPairs(r[i].Labels, r[j].Labels) < 0
+	})
+	return r
+}
+
 type SeriesMerger struct {
 	mu     sync.Mutex
 	series map[uint64]*typesv1.Series
@@ -89,3 +89,19 @@ func Test_SeriesMerger_Overlap(t *testing.T) {
 		})
 	}
 }
+
+func Test_ConcatSeries(t *testing.T) {
+	in := [][]*typesv1.Series{
+		{
+			{Labels: LabelsFromStrings("foor", "bar"), Points: []*typesv1.Point{{Timestamp: 1, Value: 1}, {Timestamp: 3, Value: 3}}},
+		},
+		{
+			{Labels: LabelsFromStrings("foor", "bar"), Points: []*typesv1.Point{{Timestamp: 1, Value: 2}}},
+			{Labels: LabelsFromStrings("foor", "buzz"), Points: []*typesv1.Point{{Timestamp: 1, Value: 1}}},
+		},
+	}
+	testhelper.EqualProto(t, []*typesv1.Series{
+		{Labels: LabelsFromStrings("foor", "bar"), Points: []*typesv1.Point{{Timestamp: 1, Value: 1}, {Timestamp: 1, Value: 2}, {Timestamp: 3, Value: 3}}},
+		{Labels: LabelsFromStrings("foor", "buzz"), Points: []*typesv1.Point{{Timestamp: 1, Value: 1}}},
+	}, ConcatSeries(in...))
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// SpanIDLabelName is the name of the pprof sample label holding the ID of
+// the span the sample belongs to, as 16 hexadecimal characters.
+const SpanIDLabelName = "span_id"
+
+// SpanSelector is a set of span IDs.
+type SpanSelector map[uint64]struct{}
+
+// NewSpanSelector parses the given span IDs.
+func NewSpanSelector(spans []string) (SpanSelector, error) {
+	s := make(SpanSelector, len(spans))
+	for _, span := range spans {
+		id, err := ParseSpanID(span)
+		if err != nil {
+			return nil, err
+		}
+		s[id] = struct{}{}
+	}
+	return s, nil
+}
+
+// ParseSpanID parses a span ID formatted as hexadecimal characters. The
+// zero span ID is invalid.
+func ParseSpanID(s string) (uint64, error) {
+	if len(s) == 0 || len(s) > 16 {
+		return 0, fmt.Errorf("invalid span id %q", s)
+	}
+	id, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid span id %q", s)
+	}
+	return id, nil
+}
+
+// FormatSpanID formats the span ID as 16 hexadecimal characters.
+func FormatSpanID(id uint64) string {
+	return fmt.Sprintf("%016x", id)
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"math"
+	"sort"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// TimeSeriesAggregator aggregates the values of the profiles that belong
+// to a step of a time series.
+type TimeSeriesAggregator struct {
+	aggregation typesv1.TimeSeriesAggregationType
+	step        int64
+
+	sum    float64
+	min    float64
+	max    float64
+	count  int
+	values []float64
+}
+
+// NewTimeSeriesAggregator creates an aggregator of the values of steps of
+// the given width in milliseconds.
+func NewTimeSeriesAggregator(aggregation typesv1.TimeSeriesAggregationType, step int64) *TimeSeriesAggregator {
+	return &TimeSeriesAggregator{
+		aggregation: aggregation,
+		step:        step,
+	}
+}
+
+func (a *TimeSeriesAggregator) Add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+	if _, ok := percentile(a.aggregation); ok {
+		a.values = append(a.values, v)
+	}
+}
+
+// Value returns the aggregate of the values added since the last reset.
+func (a *TimeSeriesAggregator) Value() float64 {
+	if q, ok := percentile(a.aggregation); ok {
+		return nearestRank(a.values, q)
+	}
+	switch a.aggregation {
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_RATE:
+		return a.sum / (float64(a.step) / 1e3)
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_AVERAGE:
+		return a.sum / float64(a.count)
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_MIN:
+		return a.min
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_MAX:
+		return a.max
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT:
+		return float64(a.count)
+	default:
+		return a.sum
+	}
+}
+
+func (a *TimeSeriesAggregator) Reset() {
+	a.sum, a.min, a.max = 0, 0, 0
+	a.count = 0
+	a.values = a.values[:0]
+}
+
+func percentile(aggregation typesv1.TimeSeriesAggregationType) (float64, bool) {
+	switch aggregation {
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_P50:
+		return 0.5, true
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_P95:
+		return 0.95, true
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_P99:
+		return 0.99, true
+	default:
+		return 0, false
+	}
+}
+
+// nearestRank returns the smallest value such that at least q of the
+// values are less than or equal to it. The values are sorted in place.
+func nearestRank(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	i := int(math.Ceil(q*float64(len(values)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return values[i]
+}
+
+// SplitTimeSeriesAggregation returns the aggregation the stores apply to
+// the profiles of a step, and the one the querier combines their partial
+// results with. False is returned if the aggregation can't be computed
+// from partial results, e.g. the average or percentiles: the stores must
+// return the value of each profile then.
+func SplitTimeSeriesAggregation(aggregation typesv1.TimeSeriesAggregationType) (partial, combine typesv1.TimeSeriesAggregationType, ok bool) {
+	switch aggregation {
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM,
+		typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_MIN,
+		typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_MAX:
+		return aggregation, aggregation, true
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_RATE:
+		return typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM, aggregation, true
+	case typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT:
+		return aggregation, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM, true
+	default:
+		return aggregation, aggregation, false
+	}
+}
+
+// StepTimestamp returns the end of the step the timestamp belongs to.
+// Steps end at start plus a multiple of the step: the step ending at t
+// holds the timestamps in (t-step, t]. Timestamps before start belong to
+// the first step.
+func StepTimestamp(ts, start, step int64) int64 {
+	if ts <= start {
+		return start
+	}
+	return start + (ts-start+step-1)/step*step
+}
+
+// AggregateSteps aggregates the points of the series into steps. The
+// points of each series must be sorted by timestamp.
+func AggregateSteps(series []*typesv1.Series, start, step int64, aggregation typesv1.TimeSeriesAggregationType) {
+	a := NewTimeSeriesAggregator(aggregation, step)
+	for _, s := range series {
+		points := s.Points[:0]
+		for i := 0; i < len(s.Points); {
+			ts := StepTimestamp(s.Points[i].Timestamp, start, step)
+			a.Reset()
+			for ; i < len(s.Points) && StepTimestamp(s.Points[i].Timestamp, start, step) == ts; i++ {
+				a.Add(s.Points[i].Value)
+			}
+			points = append(points, &typesv1.Point{Timestamp: ts, Value: a.Value()})
+		}
+		s.Points = points
+	}
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/testhelper"
+)
+
+func Test_TimeSeriesAggregator(t *testing.T) {
+	values := []float64{4, 1, 3, 2, 10, 6, 7, 5, 9, 8}
+	for _, tc := range []struct {
+		aggregation typesv1.TimeSeriesAggregationType
+		expected    float64
+	}{
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM, 55},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_RATE, 27.5},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_AVERAGE, 5.5},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_MIN, 1},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_MAX, 10},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT, 10},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_P50, 5},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_P95, 10},
+		{typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_P99, 10},
+	} {
+		tc := tc
+		t.Run(tc.aggregation.String(), func(t *testing.T) {
+			a := NewTimeSeriesAggregator(tc.aggregation, 2000)
+			a.Add(100)
+			a.Reset()
+			for _, v := range values {
+				a.Add(v)
+			}
+			assert.Equal(t, tc.expected, a.Value())
+		})
+	}
+}
+
+func Test_StepTimestamp(t *testing.T) {
+	assert.Equal(t, int64(10), StepTimestamp(5, 10, 5))
+	assert.Equal(t, int64(10), StepTimestamp(10, 10, 5))
+	assert.Equal(t, int64(15), StepTimestamp(11, 10, 5))
+	assert.Equal(t, int64(15), StepTimestamp(15, 10, 5))
+	assert.Equal(t, int64(20), StepTimestamp(16, 10, 5))
+}
+
+func Test_AggregateSteps(t *testing.T) {
+	series := []*typesv1.Series{
+		{
+			Labels: LabelsFromStrings("foo", "bar"),
+			Points: []*typesv1.Point{
+				{Timestamp: 1, Value: 1},
+				{Timestamp: 2, Value: 3},
+				{Timestamp: 3, Value: 2},
+				{Timestamp: 7, Value: 4},
+			},
+		},
+	}
+	AggregateSteps(series, 0, 2, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_MAX)
+	testhelper.EqualProto(t, []*typesv1.Series{
+		{
+			Labels: LabelsFromStrings("foo", "bar"),
+			Points: []*typesv1.Point{
+				{Timestamp: 2, Value: 3},
+				{Timestamp: 4, Value: 2},
+				{Timestamp: 8, Value: 4},
+			},
+		},
+	}, series)
+}
+
+func Test_SplitTimeSeriesAggregation(t *testing.T) {
+	partial, combine, ok := SplitTimeSeriesAggregation(typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT)
+	assert.True(t, ok)
+	assert.Equal(t, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT, partial)
+	assert.Equal(t, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM, combine)
+
+	partial, combine, ok = SplitTimeSeriesAggregation(typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_RATE)
+	assert.True(t, ok)
+	assert.Equal(t, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM, partial)
+	assert.Equal(t, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_RATE, combine)
+
+	_, _, ok = SplitTimeSeriesAggregation(typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_P95)
+	assert.False(t, ok)
+}
@@ -269,24 +269,7 @@ func (t *Tree) MarshalTruncate(w io.Writer, maxNodes int64) (err error) {
 			return err
 		}
 
-		var other int64
-		var j int
-		for _, cn := range n.children {
-			if cn.total >= minVal || cn.name == truncatedNodeName {
-				n.children[j] = cn
-				j++
-			} else {
-				other += cn.total
-			}
-		}
-
-		n.children = n.children[:j]
-		if other > 0 {
-			o := n.insert(truncatedNodeName)
-			o.total += other
-			o.self += other
-		}
-
+		n.truncateChildren(minVal)
 		if len(n.children) > 0 {
 			nodes = append(nodes, n.children...)
 		}
@@ -298,6 +281,47 @@ func (t *Tree) MarshalTruncate(w io.Writer, maxNodes int64) (err error) {
 	return nil
 }
 
+// truncate removes the nodes of the tree that do not fit in maxNodes, like
+// MarshalTruncate: truncated nodes are replaced with an "other" node.
+func (t *Tree) truncate(maxNodes int64) {
+	minVal := t.minValue(maxNodes)
+	if minVal == 0 {
+		return
+	}
+	nodes := make([]*node, 1, defaultDFSSize)
+	nodes[0] = &node{children: t.root} // Virtual root node.
+	r := nodes[0]
+	var n *node
+	for len(nodes) > 0 {
+		last := len(nodes) - 1
+		n, nodes = nodes[last], nodes[:last]
+		n.truncateChildren(minVal)
+		nodes = append(nodes, n.children...)
+	}
+	t.root = r.children
+}
+
+// truncateChildren replaces the children of the node with a total value
+// lower than minVal with a single "other" node.
+func (n *node) truncateChildren(minVal int64) {
+	var other int64
+	var j int
+	for _, cn := range n.children {
+		if cn.total >= minVal || cn.name == truncatedNodeName {
+			n.children[j] = cn
+			j++
+		} else {
+			other += cn.total
+		}
+	}
+	n.children = n.children[:j]
+	if other > 0 {
+		o := n.insert(truncatedNodeName)
+		o.total += other
+		o.self += other
+	}
+}
+
 var errMalformedTreeBytes = fmt.Errorf("malformed tree bytes")
 
 const estimateBytesPerNode = 16 // Chosen empirically.
@@ -354,7 +378,9 @@ func UnmarshalTree(b []byte) (*Tree, error) {
 		}
 	}
 
-	// Remove the virtual root.
+	// Remove the virtual root. The root nodes keep the serialized virtual
+	// root as their parent, detached so that it is not part of the stacks.
+	root.children[0].parent = nil
 	t.root = root.children[0].children
 
 	return t, nil
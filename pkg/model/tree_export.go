@@ -0,0 +1,262 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+// TreeFormat is a format a tree can be exported to with WriteTree.
+type TreeFormat string
+
+const (
+	// TreeFormatCollapsed is the Brendan Gregg's collapsed stacks format:
+	// one "frame;frame;frame value" line per stack.
+	TreeFormatCollapsed TreeFormat = "collapsed"
+	// TreeFormatSpeedscope is the speedscope JSON file format,
+	// see https://www.speedscope.app/file-format-schema.json.
+	TreeFormatSpeedscope TreeFormat = "speedscope"
+	// TreeFormatChromeTrace is the Chrome trace event format, which can be
+	// opened in chrome://tracing or Perfetto. Nodes are laid out as complete
+	// events so that the trace renders as a flame chart.
+	TreeFormatChromeTrace TreeFormat = "chrome-trace"
+	// TreeFormatHTML is an interactive standalone HTML flamegraph.
+	TreeFormatHTML TreeFormat = "html"
+)
+
+var treeFormats = []TreeFormat{
+	TreeFormatCollapsed,
+	TreeFormatSpeedscope,
+	TreeFormatChromeTrace,
+	TreeFormatHTML,
+}
+
+func ParseTreeFormat(s string) (TreeFormat, error) {
+	for _, f := range treeFormats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	names := make([]string, len(treeFormats))
+	for i, f := range treeFormats {
+		names[i] = string(f)
+	}
+	return "", fmt.Errorf("unknown tree format %q, supported formats: %s", s, strings.Join(names, ", "))
+}
+
+func (f TreeFormat) ContentType() string {
+	switch f {
+	case TreeFormatSpeedscope, TreeFormatChromeTrace:
+		return "application/json"
+	case TreeFormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// WriteTree writes the tree to w in the given format. The profile type is
+// optional and only used to name the profile and pick its unit.
+func WriteTree(w io.Writer, t *Tree, f TreeFormat, profileType *typesv1.ProfileType) error {
+	switch f {
+	case TreeFormatCollapsed:
+		bw := bufio.NewWriter(w)
+		t.WriteCollapsed(bw)
+		return bw.Flush()
+	case TreeFormatSpeedscope:
+		return t.writeSpeedscope(w, profileType)
+	case TreeFormatChromeTrace:
+		return writeChromeTrace(w, NewFlameGraph(t, 0), profileType)
+	case TreeFormatHTML:
+		return writeHTML(w, NewFlameGraph(t, 0), profileType)
+	default:
+		return fmt.Errorf("unknown tree format %q", f)
+	}
+}
+
+// ParseExportFormat returns the export format of the request, or an
+// empty format if the merge result is not to be exported.
+func ParseExportFormat(req *querierv1.SelectMergeStacktracesRequest) (TreeFormat, error) {
+	if req.ExportFormat == "" {
+		return "", nil
+	}
+	if req.Format == querierv1.ProfileFormat_PROFILE_FORMAT_TREE {
+		return "", errors.New("the export format is not supported along with the tree format")
+	}
+	if len(req.AdditionalProfileTypeIDs) > 0 {
+		return "", errors.New("the export format does not support additional profile types")
+	}
+	return ParseTreeFormat(req.ExportFormat)
+}
+
+// ExportTree truncates the tree to maxNodes, like a flame graph, and
+// returns it in the given format. The function modifies the tree:
+// truncated nodes are removed from the tree.
+func ExportTree(t *Tree, maxNodes int64, f TreeFormat, profileType *typesv1.ProfileType) ([]byte, error) {
+	t.truncate(maxNodes)
+	var buf bytes.Buffer
+	if err := WriteTree(&buf, t, f, profileType); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func profileTypeName(profileType *typesv1.ProfileType) string {
+	if profileType == nil {
+		return "profile"
+	}
+	return profileType.ID
+}
+
+type speedscopeFile struct {
+	Schema             string              `json:"$schema"`
+	Shared             speedscopeShared    `json:"shared"`
+	Profiles           []speedscopeProfile `json:"profiles"`
+	Name               string              `json:"name"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type speedscopeShared struct {
+	Frames []speedscopeFrame `json:"frames"`
+}
+
+type speedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type speedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+func speedscopeUnit(profileType *typesv1.ProfileType) string {
+	if profileType == nil {
+		return "none"
+	}
+	switch profileType.SampleUnit {
+	case "nanoseconds", "microseconds", "milliseconds", "seconds", "bytes":
+		return profileType.SampleUnit
+	default:
+		return "none"
+	}
+}
+
+func (t *Tree) writeSpeedscope(w io.Writer, profileType *typesv1.ProfileType) error {
+	name := profileTypeName(profileType)
+	p := speedscopeProfile{
+		Type:     "sampled",
+		Name:     name,
+		Unit:     speedscopeUnit(profileType),
+		EndValue: t.Total(),
+		Samples:  [][]int{},
+		Weights:  []int64{},
+	}
+	frames := []speedscopeFrame{}
+	frameIndex := make(map[string]int)
+	t.IterateStacks(func(_ string, self int64, stack []string) {
+		// The stack starts from the leaf.
+		sample := make([]int, 0, len(stack))
+		for i := len(stack) - 1; i >= 0; i-- {
+			idx, ok := frameIndex[stack[i]]
+			if !ok {
+				idx = len(frames)
+				frameIndex[stack[i]] = idx
+				frames = append(frames, speedscopeFrame{Name: stack[i]})
+			}
+			sample = append(sample, idx)
+		}
+		p.Samples = append(p.Samples, sample)
+		p.Weights = append(p.Weights, self)
+	})
+	return json.NewEncoder(w).Encode(speedscopeFile{
+		Schema:   "https://www.speedscope.app/file-format-schema.json",
+		Shared:   speedscopeShared{Frames: frames},
+		Profiles: []speedscopeProfile{p},
+		Name:     name,
+		Exporter: "pyroscope",
+	})
+}
+
+type chromeTraceEvent struct {
+	Name  string `json:"name"`
+	Phase string `json:"ph"`
+	Ts    int64  `json:"ts"`
+	Dur   int64  `json:"dur"`
+	Pid   int    `json:"pid"`
+	Tid   int    `json:"tid"`
+}
+
+type chromeTrace struct {
+	TraceEvents []chromeTraceEvent `json:"traceEvents"`
+	Metadata    map[string]string  `json:"metadata,omitempty"`
+}
+
+// writeChromeTrace writes the nodes of the flame graph as complete events,
+// laid out as in the flame graph. The "total" node is omitted.
+func writeChromeTrace(w io.Writer, fg *querierv1.FlameGraph, profileType *typesv1.ProfileType) error {
+	events := make([]chromeTraceEvent, 0, 1024)
+	for i, level := range fg.Levels {
+		// The x offsets of a level are delta-encoded.
+		var offset int64
+		for j := 0; j+3 < len(level.Values); j += 4 {
+			ts := offset + level.Values[j]
+			offset = ts + level.Values[j+1]
+			if i == 0 {
+				continue
+			}
+			events = append(events, chromeTraceEvent{
+				Name:  fg.Names[level.Values[j+3]],
+				Phase: "X",
+				Ts:    ts,
+				Dur:   level.Values[j+1],
+			})
+		}
+	}
+	return json.NewEncoder(w).Encode(chromeTrace{
+		TraceEvents: events,
+		Metadata: map[string]string{
+			"profile_type": profileTypeName(profileType),
+			"unit":         speedscopeUnit(profileType),
+		},
+	})
+}
+
+//go:embed tree_export.gohtml
+var flamegraphHTML string
+
+var flamegraphHTMLTemplate = template.Must(template.New("flamegraph").Parse(flamegraphHTML))
+
+// writeHTML writes the flame graph as a standalone HTML page, which renders
+// the levels of the flame graph.
+func writeHTML(w io.Writer, fg *querierv1.FlameGraph, profileType *typesv1.ProfileType) error {
+	levels := make([][]int64, len(fg.Levels))
+	for i, level := range fg.Levels {
+		levels[i] = level.Values
+	}
+	return flamegraphHTMLTemplate.Execute(w, struct {
+		Title  string
+		Unit   string
+		Names  []string
+		Levels [][]int64
+	}{
+		Title:  profileTypeName(profileType),
+		Unit:   speedscopeUnit(profileType),
+		Names:  fg.Names,
+		Levels: levels,
+	})
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+func exportTestTree() *Tree {
+	t := new(Tree)
+	t.InsertStack(1, "main", "a", "b")
+	t.InsertStack(2, "main", "a")
+	t.InsertStack(3, "main", "c")
+	return t
+}
+
+func Test_WriteTree_Collapsed(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteTree(&buf, exportTestTree(), TreeFormatCollapsed, nil))
+	require.Equal(t, "main;a 2\nmain;c 3\nmain;a;b 1\n", buf.String())
+}
+
+func Test_WriteTree_Speedscope(t *testing.T) {
+	var buf bytes.Buffer
+	pt := &typesv1.ProfileType{ID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds", SampleUnit: "nanoseconds"}
+	require.NoError(t, WriteTree(&buf, exportTestTree(), TreeFormatSpeedscope, pt))
+
+	var f speedscopeFile
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &f))
+	require.Equal(t, []speedscopeFrame{{"main"}, {"a"}, {"c"}, {"b"}}, f.Shared.Frames)
+	require.Len(t, f.Profiles, 1)
+	p := f.Profiles[0]
+	require.Equal(t, "nanoseconds", p.Unit)
+	require.Equal(t, int64(6), p.EndValue)
+	require.Equal(t, [][]int{{0, 1}, {0, 2}, {0, 1, 3}}, p.Samples)
+	require.Equal(t, []int64{2, 3, 1}, p.Weights)
+}
+
+func Test_WriteTree_ChromeTrace(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteTree(&buf, exportTestTree(), TreeFormatChromeTrace, nil))
+
+	var trace chromeTrace
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &trace))
+	type span struct {
+		name    string
+		ts, dur int64
+	}
+	spans := make([]span, len(trace.TraceEvents))
+	for i, e := range trace.TraceEvents {
+		spans[i] = span{e.Name, e.Ts, e.Dur}
+	}
+	require.Equal(t, []span{
+		{"main", 0, 6},
+		{"a", 0, 3},
+		{"c", 3, 3},
+		{"b", 2, 1},
+	}, spans)
+}
+
+func Test_WriteTree_HTML(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteTree(&buf, exportTestTree(), TreeFormatHTML, nil))
+	out := buf.String()
+	require.True(t, strings.HasPrefix(out, "<!DOCTYPE html>"))
+	require.Contains(t, out, `const names = ["total","main","c","a","b"];`)
+	require.Contains(t, out, `const levels = [[0,6,0,0],[0,6,0,1],[0,3,2,3,0,3,3,2],[2,1,1,4]];`)
+}
+
+func Test_ExportTree_Truncate(t *testing.T) {
+	tree := new(Tree)
+	tree.InsertStack(1, "main", "a")
+	tree.InsertStack(10, "main", "b")
+	tree.InsertStack(10, "main", "c")
+	b, err := ExportTree(tree, 3, TreeFormatCollapsed, nil)
+	require.NoError(t, err)
+	require.Equal(t, "main;b 10\nmain;c 10\nmain;other 1\n", string(b))
+}
+
+func Test_ParseTreeFormat(t *testing.T) {
+	f, err := ParseTreeFormat("speedscope")
+	require.NoError(t, err)
+	require.Equal(t, TreeFormatSpeedscope, f)
+	_, err = ParseTreeFormat("svg")
+	require.Error(t, err)
+}
@@ -248,6 +248,11 @@ func Test_Tree_MarshalUnmarshal(t *testing.T) {
 		actual, err := UnmarshalTree(buf.Bytes())
 		require.NoError(t, err)
 		require.Equal(t, expected.String(), actual.String())
+
+		var expectedStacks, actualStacks bytes.Buffer
+		expected.WriteCollapsed(&expectedStacks)
+		actual.WriteCollapsed(&actualStacks)
+		require.Equal(t, expectedStacks.String(), actualStacks.String())
 	})
 
 	t.Run("truncation", func(t *testing.T) {
@@ -366,3 +371,36 @@ func stackToTree(stack stacktraces) *Tree {
 	t.root = []*node{current}
 	return t
 }
+
+func Test_TreeMerger_SplitQueries(t *testing.T) {
+	// Stack "c;a" is the smallest one in every split, but the
+	// largest one overall: it must not be lost when merging.
+	splits := [][]stacktraces{
+		{
+			{locations: []string{"b", "a"}, value: 3},
+			{locations: []string{"c", "a"}, value: 2},
+		},
+		{
+			{locations: []string{"d", "a"}, value: 3},
+			{locations: []string{"c", "a"}, value: 2},
+		},
+		{
+			{locations: []string{"e", "a"}, value: 3},
+			{locations: []string{"c", "a"}, value: 2},
+		},
+	}
+
+	var all []stacktraces
+	m := NewTreeMerger()
+	for _, s := range splits {
+		all = append(all, s...)
+		var buf bytes.Buffer
+		require.NoError(t, newTree(s).MarshalTruncate(&buf, -1))
+		require.NoError(t, m.MergeTreeBytes(buf.Bytes()))
+	}
+
+	expected := newTree(all)
+	require.Equal(t, expected.String(), m.Tree().String())
+	require.Equal(t, NewFlameGraph(expected, 2), NewFlameGraph(m.Tree(), 2))
+	require.Contains(t, NewFlameGraph(m.Tree(), 2).Names, "c")
+}
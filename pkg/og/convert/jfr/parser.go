@@ -25,9 +25,32 @@ const (
 	sampleTypeLockSamples
 	sampleTypeLockDuration
 	sampleTypeLiveObject
+	sampleTypeMonitorWaitSamples
+	sampleTypeMonitorWaitDuration
 )
 
+const (
+	threadNameLabelName  = "thread_name"
+	threadStateLabelName = "thread_state"
+)
+
+// ParseOptions configures the conversion of JFR events to trees.
+type ParseOptions struct {
+	// ThreadLabels adds the thread_name label to the samples, and the
+	// thread_state label to the execution samples. The numbers in the
+	// thread names are replaced, so that the threads of a pool share
+	// the same name.
+	ThreadLabels bool
+}
+
 func ParseJFR(ctx context.Context, s storage.Putter, body io.Reader, pi *storage.PutInput, jfrLabels *LabelsSnapshot) (err error) {
+	return ParseJFRWithOptions(ctx, s, body, pi, jfrLabels, ParseOptions{})
+}
+
+func ParseJFRWithOptions(ctx context.Context, s storage.Putter, body io.Reader, pi *storage.PutInput, jfrLabels *LabelsSnapshot, opts ParseOptions) (err error) {
+	if jfrLabels == nil {
+		jfrLabels = new(LabelsSnapshot)
+	}
 	chunks, err := parser.ParseWithOptions(body, &parser.ChunkParseOptions{
 		CPoolProcessor:     processSymbols,
 		UnsafeByteToString: true,
@@ -36,7 +59,7 @@ func ParseJFR(ctx context.Context, s storage.Putter, body io.Reader, pi *storage
 		return fmt.Errorf("unable to parse JFR format: %w", err)
 	}
 	for _, c := range chunks {
-		if pErr := parse(ctx, c, s, pi, jfrLabels); pErr != nil {
+		if pErr := parse(ctx, c, s, pi, jfrLabels, opts); pErr != nil {
 			err = multierror.Append(err, pErr)
 		}
 	}
@@ -44,28 +67,47 @@ func ParseJFR(ctx context.Context, s storage.Putter, body io.Reader, pi *storage
 }
 
 // revive:disable-next-line:cognitive-complexity necessary complexity
-func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *storage.PutInput, jfrLabels *LabelsSnapshot) (err error) {
+func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *storage.PutInput, jfrLabels *LabelsSnapshot, opts ParseOptions) (err error) {
 	var event string
 	cache := make(tree.LabelsCache)
 	type labelsWithHash struct {
 		Labels tree.Labels
 		Hash   uint64
 	}
-	contexts := make(map[int64]labelsWithHash)
+	type labelsKey struct {
+		contextID int64
+		thread    string
+		state     string
+	}
+	contexts := make(map[labelsKey]labelsWithHash)
+	labelStrings := newSnapshotStrings(jfrLabels)
 	for c.Next() {
 		e := c.Event
 
-		getLabels := func(contextID int64) labelsWithHash {
-			res, ok := contexts[contextID]
+		getLabels := func(contextID int64, thread *parser.Thread, state *parser.ThreadState) labelsWithHash {
+			k := labelsKey{contextID: contextID}
+			if opts.ThreadLabels {
+				k.thread = threadName(thread)
+				if state != nil {
+					k.state = state.Name
+				}
+			}
+			res, ok := contexts[k]
 			if ok {
 				return res
 			}
 			ls := getContextLabels(contextID, jfrLabels)
+			if k.thread != "" {
+				ls = append(ls, &tree.Label{Key: labelStrings.id(threadNameLabelName), Str: labelStrings.id(k.thread)})
+			}
+			if k.state != "" {
+				ls = append(ls, &tree.Label{Key: labelStrings.id(threadStateLabelName), Str: labelStrings.id(k.state)})
+			}
 			res = labelsWithHash{
 				Labels: ls,
 				Hash:   ls.Hash(),
 			}
-			contexts[contextID] = res
+			contexts[k] = res
 			return res
 		}
 
@@ -73,7 +115,7 @@ func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *st
 		case *parser.ExecutionSample:
 			es := e.(*parser.ExecutionSample)
 			if fs := frames(es.StackTrace); fs != nil {
-				lwh := getLabels(es.ContextId)
+				lwh := getLabels(es.ContextId, es.SampledThread, es.State)
 				if es.State.Name == "STATE_RUNNABLE" {
 					cache.GetOrCreateTreeByHash(sampleTypeCPU, lwh.Labels, lwh.Hash).InsertStack(fs, 1)
 				}
@@ -82,28 +124,28 @@ func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *st
 		case *parser.ObjectAllocationInNewTLAB:
 			oa := e.(*parser.ObjectAllocationInNewTLAB)
 			if fs := frames(oa.StackTrace); fs != nil {
-				lwh := getLabels(oa.ContextId)
+				lwh := getLabels(oa.ContextId, oa.EventThread, nil)
 				cache.GetOrCreateTreeByHash(sampleTypeInTLABObjects, lwh.Labels, lwh.Hash).InsertStack(fs, 1)
 				cache.GetOrCreateTreeByHash(sampleTypeInTLABBytes, lwh.Labels, lwh.Hash).InsertStack(fs, uint64(oa.TLABSize))
 			}
 		case *parser.ObjectAllocationOutsideTLAB:
 			oa := e.(*parser.ObjectAllocationOutsideTLAB)
 			if fs := frames(oa.StackTrace); fs != nil {
-				lwh := getLabels(oa.ContextId)
+				lwh := getLabels(oa.ContextId, oa.EventThread, nil)
 				cache.GetOrCreateTreeByHash(sampleTypeOutTLABObjects, lwh.Labels, lwh.Hash).InsertStack(fs, 1)
 				cache.GetOrCreateTreeByHash(sampleTypeOutTLABBytes, lwh.Labels, lwh.Hash).InsertStack(fs, uint64(oa.AllocationSize))
 			}
 		case *parser.JavaMonitorEnter:
 			jme := e.(*parser.JavaMonitorEnter)
 			if fs := frames(jme.StackTrace); fs != nil {
-				lwh := getLabels(jme.ContextId)
+				lwh := getLabels(jme.ContextId, jme.EventThread, nil)
 				cache.GetOrCreateTreeByHash(sampleTypeLockSamples, lwh.Labels, lwh.Hash).InsertStack(fs, 1)
 				cache.GetOrCreateTreeByHash(sampleTypeLockDuration, lwh.Labels, lwh.Hash).InsertStack(fs, uint64(jme.Duration))
 			}
 		case *parser.ThreadPark:
 			tp := e.(*parser.ThreadPark)
 			if fs := frames(tp.StackTrace); fs != nil {
-				lwh := getLabels(tp.ContextId)
+				lwh := getLabels(tp.ContextId, tp.EventThread, nil)
 
 				cache.GetOrCreateTreeByHash(sampleTypeLockSamples, lwh.Labels, lwh.Hash).InsertStack(fs, 1)
 				cache.GetOrCreateTreeByHash(sampleTypeLockDuration, lwh.Labels, lwh.Hash).InsertStack(fs, uint64(tp.Duration))
@@ -111,9 +153,17 @@ func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *st
 		case *parser.LiveObject:
 			lo := e.(*parser.LiveObject)
 			if fs := frames(lo.StackTrace); fs != nil {
-				lwh := getLabels(0)
+				lwh := getLabels(0, lo.EventThread, nil)
 				cache.GetOrCreateTreeByHash(sampleTypeLiveObject, lwh.Labels, lwh.Hash).InsertStack(fs, 1)
 			}
+		case *parser.JavaMonitorWait:
+			jmw := e.(*parser.JavaMonitorWait)
+			if fs := frames(jmw.StackTrace); fs != nil {
+				// The event has no context ID.
+				lwh := getLabels(0, jmw.EventThread, nil)
+				cache.GetOrCreateTreeByHash(sampleTypeMonitorWaitSamples, lwh.Labels, lwh.Hash).InsertStack(fs, 1)
+				cache.GetOrCreateTreeByHash(sampleTypeMonitorWaitDuration, lwh.Labels, lwh.Hash).InsertStack(fs, uint64(jmw.Duration))
+			}
 		case *parser.ActiveSetting:
 			if as, ok := e.(*parser.ActiveSetting); ok {
 				if as.Name == "event" {
@@ -190,6 +240,10 @@ func getName(sampleType int64, event string) string {
 		return "lock_duration"
 	case sampleTypeLiveObject:
 		return "live"
+	case sampleTypeMonitorWaitSamples:
+		return "monitor_wait_count"
+	case sampleTypeMonitorWaitDuration:
+		return "monitor_wait_duration"
 	}
 	return "unknown"
 }
@@ -223,6 +277,10 @@ func getUnits(sampleType int64) metadata.Units {
 		return metadata.LockNanosecondsUnits
 	case sampleTypeLiveObject:
 		return metadata.ObjectsUnits
+	case sampleTypeMonitorWaitSamples:
+		return metadata.LockSamplesUnits
+	case sampleTypeMonitorWaitDuration:
+		return metadata.LockNanosecondsUnits
 	}
 	return metadata.SamplesUnits
 }
@@ -260,6 +318,54 @@ func getContextLabels(contextID int64, labels *LabelsSnapshot) tree.Labels {
 	}
 	return res
 }
+
+// snapshotStrings adds strings to the labels snapshot, so that the labels
+// the parser adds are resolved the same way as the context labels.
+type snapshotStrings struct {
+	snapshot *LabelsSnapshot
+	ids      map[string]int64
+	next     int64
+}
+
+func newSnapshotStrings(s *LabelsSnapshot) *snapshotStrings {
+	if s.Strings == nil {
+		s.Strings = make(map[int64]string)
+	}
+	ss := &snapshotStrings{snapshot: s, ids: make(map[string]int64)}
+	for id := range s.Strings {
+		if id >= ss.next {
+			ss.next = id + 1
+		}
+	}
+	return ss
+}
+
+func (s *snapshotStrings) id(v string) int64 {
+	if id, ok := s.ids[v]; ok {
+		return id
+	}
+	id := s.next
+	s.next++
+	s.ids[v] = id
+	s.snapshot.Strings[id] = v
+	return id
+}
+
+var threadNumber = regexp.MustCompile(`\d+`)
+
+// threadName returns the name of the thread with the numbers replaced,
+// e.g. pool-1-thread-12 becomes pool-_-thread-_.
+func threadName(t *parser.Thread) string {
+	if t == nil {
+		return ""
+	}
+	name := t.JavaName
+	if name == "" {
+		name = t.OsName
+	}
+	return threadNumber.ReplaceAllString(name, "_")
+}
+
 func labelIndex(s *LabelsSnapshot, labels tree.Labels, key string) int {
 	for i, label := range labels {
 		if n, ok := s.Strings[label.Key]; ok {
@@ -87,3 +87,38 @@ func BenchmarkParser(b *testing.B) {
 		err = ParseJFR(context.TODO(), putter, bytes.NewBuffer(jfr), pi, nil)
 	}
 }
+
+func TestParseThreadLabels(t *testing.T) {
+	jfr, err := bench.ReadGzipFile("testdata/cortex-dev-01__kafka-0__cpu_lock_alloc__0.jfr.gz")
+	require.NoError(t, err)
+	k, err := segment.ParseKey("kafka.app")
+	require.NoError(t, err)
+	pi := &storage.PutInput{
+		StartTime:  time.UnixMilli(1000),
+		EndTime:    time.UnixMilli(2000),
+		Key:        k,
+		SpyName:    "java",
+		SampleRate: 100,
+	}
+	putter := &bench.MockPutter{Keep: true}
+	err = ParseJFRWithOptions(context.TODO(), putter, bytes.NewBuffer(jfr), pi, nil, ParseOptions{ThreadLabels: true})
+	require.NoError(t, err)
+
+	var cpu, other int
+	for _, p := range putter.Puts {
+		key, err := segment.ParseKey(p.Key)
+		require.NoError(t, err)
+		labels := key.Labels()
+		require.NotEmpty(t, labels[threadNameLabelName], p.Key)
+		require.NotRegexp(t, `\d`, labels[threadNameLabelName], p.Key)
+		if strings.HasSuffix(key.AppName(), ".cpu") {
+			require.NotEmpty(t, labels[threadStateLabelName], p.Key)
+			cpu++
+		} else {
+			require.Empty(t, labels[threadStateLabelName], p.Key)
+			other++
+		}
+	}
+	require.NotZero(t, cpu)
+	require.NotZero(t, other)
+}
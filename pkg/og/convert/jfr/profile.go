@@ -19,6 +19,8 @@ import (
 type RawProfile struct {
 	FormDataContentType string
 	RawData             []byte
+	// ThreadLabels adds the thread name and state labels to the profiles.
+	ThreadLabels bool
 }
 
 func (p *RawProfile) Bytes() ([]byte, error) { return p.RawData, nil }
@@ -43,7 +45,7 @@ func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage
 		}
 	}
 
-	return ParseJFR(ctx, putter, r, &input, labels)
+	return ParseJFRWithOptions(ctx, putter, r, &input, labels, ParseOptions{ThreadLabels: p.ThreadLabels})
 }
 
 func (p *RawProfile) ContentType() string {
@@ -133,6 +133,44 @@ func (t *Tree) Diff(x *Tree) *Tree {
 	return t
 }
 
+// Subtract removes the samples of x from t in a single pass over both
+// trees. The values do not go below zero, and the nodes left without
+// samples are removed.
+func (t *Tree) Subtract(x *Tree) {
+	t.root.subtract(x.root)
+}
+
+func (n *treeNode) subtract(x *treeNode) {
+	if n.Self > x.Self {
+		n.Self -= x.Self
+	} else {
+		n.Self = 0
+	}
+	n.Total = n.Self
+	// The children of both nodes are sorted by name.
+	var d, j int
+	for _, c := range n.ChildrenNodes {
+		for j < len(x.ChildrenNodes) && bytes.Compare(x.ChildrenNodes[j].Name, c.Name) < 0 {
+			j++
+		}
+		if j < len(x.ChildrenNodes) && bytes.Equal(x.ChildrenNodes[j].Name, c.Name) {
+			c.subtract(x.ChildrenNodes[j])
+			j++
+		}
+		if c.Total == 0 {
+			continue
+		}
+		n.ChildrenNodes[d] = c
+		n.Total += c.Total
+		d++
+	}
+	// Reclaim removed nodes space.
+	for i := d; i < len(n.ChildrenNodes); i++ {
+		n.ChildrenNodes[i] = nil
+	}
+	n.ChildrenNodes = n.ChildrenNodes[:d]
+}
+
 func prependTreeNode(s []*treeNode, x *treeNode) []*treeNode {
 	s = append(s, nil)
 	copy(s[1:], s)
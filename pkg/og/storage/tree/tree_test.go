@@ -62,6 +62,32 @@ var _ = Describe("tree package", func() {
 		})
 	})
 
+	Context("Subtract", func() {
+		a := New()
+		a.Insert([]byte("a;b;c"), uint64(100))
+		a.Insert([]byte("a;b;c;d"), uint64(100))
+		a.Insert([]byte("a;b;d"), uint64(100))
+		a.Insert([]byte("a;e"), uint64(100))
+		a.Insert([]byte("a;h"), uint64(150))
+
+		b := New()
+		b.Insert([]byte("a;b;c"), uint64(20))
+		b.Insert([]byte("a;b;c;d"), uint64(100))
+		b.Insert([]byte("a;b;d"), uint64(120))
+		b.Insert([]byte("a;f"), uint64(150))
+		b.Insert([]byte("a;h"), uint64(50))
+
+		a.Subtract(b)
+		It("properly sets up a tree", func() {
+			Expect(a).To(beTree([]stack{
+				{"a;e", 100},
+				{"a;h", 100},
+				{"a;b;c", 80},
+			}))
+			Expect(a.Samples()).To(Equal(uint64(280)))
+		})
+	})
+
 	Context("InsertStackString unsorted of length 1", func() {
 		tree := New()
 		tree.InsertStackString([]string{"a", "b"}, uint64(1))
@@ -17,6 +17,7 @@ import (
 	"github.com/grafana/dskit/runtimeconfig"
 	"github.com/grafana/dskit/server"
 	"github.com/grafana/dskit/services"
+	"github.com/grafana/dskit/tenant"
 	grpcgw "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
@@ -38,6 +39,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/querier"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/querier/worker"
 	"github.com/grafana/pyroscope/pkg/scheduler"
 	"github.com/grafana/pyroscope/pkg/storegateway"
@@ -90,18 +92,34 @@ func (f *Phlare) initQueryFrontend() (services.Service, error) {
 		f.Cfg.Frontend.Port = f.Cfg.Server.HTTPListenPort
 	}
 
+	if f.Cfg.TenantFederationEnabled {
+		tenant.WithDefaultResolver(tenant.NewMultiResolver())
+	}
+
 	frontendSvc, err := frontend.NewFrontend(f.Cfg.Frontend, f.Overrides, log.With(f.logger, "component", "frontend"), f.reg)
 	if err != nil {
 		return nil, err
 	}
 
-	f.API.RegisterPyroscopeHandlers(frontendSvc)
+	reporter := f.newQueryStatsReporter()
+	f.API.RegisterPyroscopeHandlers(frontendSvc, reporter)
 	f.API.RegisterQueryFrontend(frontendSvc)
-	f.API.RegisterQuerier(frontendSvc)
+	f.API.RegisterQuerier(frontendSvc, reporter)
 
 	return frontendSvc, nil
 }
 
+// newQueryStatsReporter returns the reporter of the statistics of the
+// queries served to the clients by either the query-frontend or, if the
+// query-frontend is not running, by the querier.
+func (f *Phlare) newQueryStatsReporter() *stats.Reporter {
+	return stats.NewReporter(
+		log.With(f.logger, "component", "query-stats"),
+		f.Cfg.Frontend.LogQueriesLongerThan,
+		f.Cfg.Frontend.LogQueriesReadBytesMoreThan,
+	)
+}
+
 func (f *Phlare) initRuntimeConfig() (services.Service, error) {
 	if len(f.Cfg.RuntimeConfig.LoadPath) == 0 {
 		// no need to initialize module if load path is empty
@@ -197,13 +215,14 @@ func (f *Phlare) initQuerier() (services.Service, error) {
 		}
 	}
 
-	querierSvc, err := querier.New(f.Cfg.Querier, f.ring, nil, storeGatewayQuerier, f.reg, log.With(f.logger, "component", "querier"), f.auth)
+	querierSvc, err := querier.New(f.Cfg.Querier, f.ring, nil, f.Overrides, storeGatewayQuerier, f.reg, log.With(f.logger, "component", "querier"), f.auth)
 	if err != nil {
 		return nil, err
 	}
 	if !f.isModuleActive(QueryFrontend) {
-		f.API.RegisterPyroscopeHandlers(querierSvc)
-		f.API.RegisterQuerier(querierSvc)
+		reporter := f.newQueryStatsReporter()
+		f.API.RegisterPyroscopeHandlers(querierSvc, reporter)
+		f.API.RegisterQuerier(querierSvc, reporter)
 	}
 	worker, err := worker.NewQuerierWorker(f.Cfg.Worker, querier.NewGRPCHandler(querierSvc), log.With(f.logger, "component", "querier-worker"), f.reg)
 	if err != nil {
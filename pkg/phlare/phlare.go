@@ -78,8 +78,9 @@ type Config struct {
 	Storage       StorageConfig       `yaml:"storage"`
 	SelfProfiling SelfProfilingConfig `yaml:"self_profiling,omitempty"`
 
-	MultitenancyEnabled bool              `yaml:"multitenancy_enabled,omitempty"`
-	Analytics           usagestats.Config `yaml:"analytics"`
+	MultitenancyEnabled     bool              `yaml:"multitenancy_enabled,omitempty"`
+	TenantFederationEnabled bool              `yaml:"tenant_federation_enabled,omitempty"`
+	Analytics               usagestats.Config `yaml:"analytics"`
 
 	ConfigFile      string `yaml:"-"`
 	ConfigExpandEnv bool   `yaml:"-"`
@@ -125,6 +126,7 @@ func (c *Config) RegisterFlagsWithContext(ctx context.Context, f *flag.FlagSet)
 	f.Var(&c.Target, "target", "Comma-separated list of Pyroscope modules to load. "+
 		"The alias 'all' can be used in the list to load a number of core modules and will enable single-binary mode. ")
 	f.BoolVar(&c.MultitenancyEnabled, "auth.multitenancy-enabled", false, "When set to true, incoming HTTP requests must specify tenant ID in HTTP X-Scope-OrgId header. When set to false, tenant ID anonymous is used instead.")
+	f.BoolVar(&c.TenantFederationEnabled, "auth.tenant-federation-enabled", false, "When set to true, the query-frontend accepts queries for multiple tenants specified in the X-Scope-OrgId header separated by '|'. The query is executed for each of the tenants and the results are merged; the __tenant_id__ label identifies the tenant of a series.")
 	f.BoolVar(&c.ConfigExpandEnv, "config.expand-env", false, "Expands ${var} in config according to the values of the environment variables.")
 
 	c.registerServerFlagsWithChangedDefaultValues(f)
@@ -179,6 +181,12 @@ func (c *Config) Validate() error {
 	if len(c.Target) == 0 {
 		return errors.New("no modules specified")
 	}
+	if err := c.LimitsConfig.Validate(); err != nil {
+		return err
+	}
+	if err := c.API.Validate(); err != nil {
+		return err
+	}
 	return c.Ingester.Validate()
 }
 
@@ -21,6 +21,7 @@ import (
 
 const (
 	IndexFilename        = "index.tsdb"
+	SpanIndexFilename    = "spans.bloom"
 	ParquetSuffix        = ".parquet"
 	DeletionMarkFilename = "deletion-mark.json"
 
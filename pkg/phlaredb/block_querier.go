@@ -42,7 +42,9 @@ import (
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
 	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/util/limiter"
 )
 
 const (
@@ -295,6 +297,8 @@ type singleBlockQuerier struct {
 	index    *index.Reader
 	profiles parquetReader[*schemav1.Profile, *schemav1.ProfilePersister]
 	symbols  symbolsResolver
+	// spans is nil if the block has no span index.
+	spans *spanIndex
 }
 
 func NewSingleBlockQuerierFromMeta(phlarectx context.Context, bucketReader phlareobj.Bucket, meta *block.Meta) *singleBlockQuerier {
@@ -360,6 +364,7 @@ func (b *singleBlockQuerier) Close() error {
 			errs.Add(err)
 		}
 	}
+	b.spans = nil
 	b.opened = false
 	return errs.Err()
 }
@@ -371,6 +376,9 @@ func (b *singleBlockQuerier) Bounds() (model.Time, model.Time) {
 type labelsInfo struct {
 	fp  model.Fingerprint
 	lbs phlaremodel.Labels
+	// valueIndex is the index of the selected profile type
+	// in the values of the profiles of the series.
+	valueIndex int
 }
 
 type Profile interface {
@@ -378,14 +386,33 @@ type Profile interface {
 	Timestamp() model.Time
 	Fingerprint() model.Fingerprint
 	Labels() phlaremodel.Labels
+	// Period returns the sampling period the sample values of the profile
+	// are multiplied by, if the profile was selected with scale_by_period,
+	// and zero otherwise.
+	Period() int64
+	// ValueIndex returns the index of the profile type of the profile in
+	// its values: 0 for the sample values, and i+1 for the i-th additional
+	// profile type of a multi-value profile.
+	ValueIndex() int
 }
 
 type Querier interface {
 	Bounds() (model.Time, model.Time)
 	SelectMatchingProfiles(ctx context.Context, params *ingestv1.SelectProfilesRequest) (iter.Iterator[Profile], error)
 	MergeByStacktraces(ctx context.Context, rows iter.Iterator[Profile]) (*phlaremodel.Tree, error)
+	// MergeByStacktracesMultiValue merges the profiles of each of the
+	// selections into a tree per selection. The samples of a profile
+	// selected more than once, as the profile types of a multi-value
+	// profile are, are read once.
+	MergeByStacktracesMultiValue(ctx context.Context, rows ...iter.Iterator[Profile]) ([]*phlaremodel.Tree, error)
+	// MergeBySpans merges the samples of the profiles that belong to the
+	// spans of the selector.
+	MergeBySpans(ctx context.Context, rows iter.Iterator[Profile], spans phlaremodel.SpanSelector) (*phlaremodel.Tree, error)
 	MergeByLabels(ctx context.Context, rows iter.Iterator[Profile], by ...string) ([]*typesv1.Series, error)
 	MergePprof(ctx context.Context, rows iter.Iterator[Profile]) (*profile.Profile, error)
+	// SelectExemplars lists the exemplars of the profiles, one for each of
+	// the spans of a profile, valued with the sum of its sample values.
+	SelectExemplars(ctx context.Context, rows iter.Iterator[Profile]) ([]*typesv1.Exemplar, error)
 	Open(ctx context.Context) error
 	// Sorts profiles for retrieval.
 	Sort([]Profile) []Profile
@@ -440,9 +467,12 @@ func (queriers Queriers) ForTimeRange(_ context.Context, start, end model.Time)
 type BlockGetter func(ctx context.Context, start, end model.Time) (Queriers, error)
 
 // SelectMatchingProfiles returns a list iterator of profiles matching the given request.
+// The profiles read from the iterators are accounted in the query limiter of the context,
+// the iterators fail with the limit error as soon as the limit is exceeded.
 func SelectMatchingProfiles(ctx context.Context, request *ingestv1.SelectProfilesRequest, queriers Queriers) ([]iter.Iterator[Profile], error) {
 	g, ctx := errgroup.WithContext(ctx)
 	iters := make([]iter.Iterator[Profile], len(queriers))
+	queryLimiter := limiter.QueryLimiterFromContext(ctx)
 
 	for i, querier := range queriers {
 		i := i
@@ -452,7 +482,7 @@ func SelectMatchingProfiles(ctx context.Context, request *ingestv1.SelectProfile
 			if err != nil {
 				return err
 			}
-			iters[i] = iter.NewBufferedIterator(profiles, 1024)
+			iters[i] = newLimitedProfileIterator(iter.NewBufferedIterator(profiles, 1024), queryLimiter)
 			return nil
 		}))
 	}
@@ -468,9 +498,42 @@ func SelectMatchingProfiles(ctx context.Context, request *ingestv1.SelectProfile
 	return iters, nil
 }
 
+// limitedProfileIterator accounts every profile read in the query limiter
+// and stops with the limit error once the limit is exceeded.
+type limitedProfileIterator struct {
+	iter.Iterator[Profile]
+	limiter *limiter.QueryLimiter
+	err     error
+}
+
+func newLimitedProfileIterator(it iter.Iterator[Profile], l *limiter.QueryLimiter) iter.Iterator[Profile] {
+	if l == nil {
+		return it
+	}
+	return &limitedProfileIterator{Iterator: it, limiter: l}
+}
+
+func (it *limitedProfileIterator) Next() bool {
+	if it.err != nil || !it.Iterator.Next() {
+		return false
+	}
+	if it.err = it.limiter.AddProfiles(1); it.err != nil {
+		return false
+	}
+	return true
+}
+
+func (it *limitedProfileIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.Iterator.Err()
+}
+
 func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse], blockGetter BlockGetter) error {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeProfilesStacktraces")
 	defer sp.Finish()
+	st, ctx := stats.ContextWithEmptyStats(ctx)
 
 	r, err := stream.Receive()
 	if err != nil {
@@ -490,15 +553,41 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 		otlog.String("selector", request.LabelSelector),
 		otlog.String("profile_id", request.Type.ID),
 	)
+	var spans phlaremodel.SpanSelector
+	if len(r.SpanSelector) > 0 {
+		if spans, err = phlaremodel.NewSpanSelector(r.SpanSelector); err != nil {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+	profileTypes := append([]*typesv1.ProfileType{request.Type}, r.AdditionalTypes...)
+	if len(profileTypes) > 1 && spans != nil {
+		return connect.NewError(connect.CodeInvalidArgument, errors.New("span selector is not supported with additional profile types"))
+	}
 
 	queriers, err := blockGetter(ctx, model.Time(request.Start), model.Time(request.End))
 	if err != nil {
 		return err
 	}
-
-	iters, err := SelectMatchingProfiles(ctx, request, queriers)
-	if err != nil {
-		return err
+	st.AddQueriedBlocks(uint64(len(queriers)))
+
+	// The profiles of the profile types are selected from each querier:
+	// the profiles of the i-th type from the j-th querier are at the
+	// index i*len(queriers)+j.
+	iters := make([]iter.Iterator[Profile], 0, len(profileTypes)*len(queriers))
+	for _, profileType := range profileTypes {
+		typeRequest := request
+		if profileType != request.Type {
+			typeRequest = request.CloneVT()
+			typeRequest.Type = profileType
+		}
+		typeIters, err := SelectMatchingProfiles(ctx, typeRequest, queriers)
+		if err != nil {
+			for _, it := range iters {
+				runutil.CloseWithLogOnErr(util.Logger, it, "closing buffered iterator")
+			}
+			return err
+		}
+		iters = append(iters, typeIters...)
 	}
 
 	// send batches of profiles to client and filter via bidi stream.
@@ -511,24 +600,60 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 	}
 
 	var m sync.Mutex
-	t := new(phlaremodel.Tree)
+	trees := make([]*phlaremodel.Tree, len(profileTypes))
+	for i := range trees {
+		trees[i] = new(phlaremodel.Tree)
+	}
 	g, ctx := errgroup.WithContext(ctx)
 
 	for i, querier := range queriers {
 		querier := querier
 		i := i
+		if len(profileTypes) > 1 {
+			selections := make([]iter.Iterator[Profile], len(profileTypes))
+			var selected int
+			for j := range profileTypes {
+				profiles := selectedProfiles[j*len(queriers)+i]
+				selections[j] = iter.NewSliceIterator(profiles)
+				selected += len(profiles)
+			}
+			if selected == 0 {
+				continue
+			}
+			st.AddMergedProfiles(uint64(selected))
+			g.Go(util.RecoverPanic(func() error {
+				merges, err := querier.MergeByStacktracesMultiValue(ctx, selections...)
+				if err != nil {
+					return err
+				}
+				m.Lock()
+				for j, merge := range merges {
+					trees[j].Merge(merge)
+				}
+				m.Unlock()
+				return nil
+			}))
+			continue
+		}
 		if len(selectedProfiles[i]) == 0 {
 			continue
 		}
+		st.AddMergedProfiles(uint64(len(selectedProfiles[i])))
 		// Sort profiles for better read locality.
 		// Merge async the result so we can continue streaming profiles.
 		g.Go(util.RecoverPanic(func() error {
-			merge, err := querier.MergeByStacktraces(ctx, iter.NewSliceIterator(querier.Sort(selectedProfiles[i])))
+			var merge *phlaremodel.Tree
+			var err error
+			if spans != nil {
+				merge, err = querier.MergeBySpans(ctx, iter.NewSliceIterator(querier.Sort(selectedProfiles[i])), spans)
+			} else {
+				merge, err = querier.MergeByStacktraces(ctx, iter.NewSliceIterator(querier.Sort(selectedProfiles[i])))
+			}
 			if err != nil {
 				return err
 			}
 			m.Lock()
-			t.Merge(merge)
+			trees[0].Merge(merge)
 			m.Unlock()
 			return nil
 		}))
@@ -545,18 +670,26 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 		return err
 	}
 
-	var buf bytes.Buffer
-	if err = t.MarshalTruncate(&buf, r.GetMaxNodes()); err != nil {
-		return err
+	result := &ingestv1.MergeProfilesStacktracesResult{
+		Format: ingestv1.StacktracesMergeFormat_MERGE_FORMAT_TREE,
+	}
+	for i, t := range trees {
+		var buf bytes.Buffer
+		if err = t.MarshalTruncate(&buf, r.GetMaxNodes()); err != nil {
+			return err
+		}
+		if i == 0 {
+			result.TreeBytes = buf.Bytes()
+			continue
+		}
+		result.AdditionalTreeBytes = append(result.AdditionalTreeBytes, buf.Bytes())
 	}
 
 	// sends the final result to the client.
 	sp.LogFields(otlog.String("msg", "sending the final result to the client"))
 	err = stream.Send(&ingestv1.MergeProfilesStacktracesResponse{
-		Result: &ingestv1.MergeProfilesStacktracesResult{
-			Format:    ingestv1.StacktracesMergeFormat_MERGE_FORMAT_TREE,
-			TreeBytes: buf.Bytes(),
-		},
+		Result: result,
+		Stats:  st.QueryStats(),
 	})
 	if err != nil {
 		if errors.Is(err, io.EOF) {
@@ -571,6 +704,7 @@ func MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[in
 func MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesLabelsRequest, ingestv1.MergeProfilesLabelsResponse], blockGetter BlockGetter) error {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeProfilesLabels")
 	defer sp.Finish()
+	st, ctx := stats.ContextWithEmptyStats(ctx)
 
 	r, err := stream.Receive()
 	if err != nil {
@@ -598,6 +732,7 @@ func MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv
 	if err != nil {
 		return err
 	}
+	st.AddQueriedBlocks(uint64(len(queriers)))
 
 	iters, err := SelectMatchingProfiles(ctx, request, queriers)
 	if err != nil {
@@ -627,6 +762,7 @@ func MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv
 		if len(selectedProfiles[i]) == 0 {
 			continue
 		}
+		st.AddMergedProfiles(uint64(len(selectedProfiles[i])))
 		// Sort profiles for better read locality.
 		// And merge async the result for each queriers.
 		g.Go(util.RecoverPanic(func() error {
@@ -648,9 +784,27 @@ func MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv
 		return err
 	}
 
+	var series []*typesv1.Series
+	a := r.StepAggregation
+	stepAggregated := a != nil && a.Step > 0
+	switch {
+	case stepAggregated:
+		// The point of each profile is aggregated: e.g. profiles of
+		// different series of a group sharing a timestamp are counted
+		// individually.
+		series = phlaremodel.ConcatSeries(result...)
+		phlaremodel.AggregateSteps(series, a.Start, a.Step, a.Aggregation)
+	case r.ProfilePoints:
+		series = phlaremodel.ConcatSeries(result...)
+	default:
+		series = phlaremodel.SumSeries(result...)
+	}
+
 	// sends the final result to the client.
 	err = stream.Send(&ingestv1.MergeProfilesLabelsResponse{
-		Series: phlaremodel.SumSeries(result...),
+		Series:         series,
+		Stats:          st.QueryStats(),
+		StepAggregated: stepAggregated,
 	})
 	if err != nil {
 		if errors.Is(err, io.EOF) {
@@ -665,6 +819,7 @@ func MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv
 func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesPprofRequest, ingestv1.MergeProfilesPprofResponse], blockGetter BlockGetter) error {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeProfilesPprof")
 	defer sp.Finish()
+	st, ctx := stats.ContextWithEmptyStats(ctx)
 
 	r, err := stream.Receive()
 	if err != nil {
@@ -689,6 +844,7 @@ func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1
 	if err != nil {
 		return err
 	}
+	st.AddQueriedBlocks(uint64(len(queriers)))
 
 	iters, err := SelectMatchingProfiles(ctx, request, queriers)
 	if err != nil {
@@ -706,6 +862,8 @@ func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1
 
 	result := make([]*profile.Profile, 0, len(queriers))
 	var lock sync.Mutex
+	// Partial results are only sent once the profile streaming has ended.
+	streamed := make(chan struct{})
 	g, ctx := errgroup.WithContext(ctx)
 	for i, querier := range queriers {
 		i := i
@@ -713,6 +871,7 @@ func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1
 		if len(selectedProfiles[i]) == 0 {
 			continue
 		}
+		st.AddMergedProfiles(uint64(len(selectedProfiles[i])))
 		// Sort profiles for better read locality.
 		// Merge async the result so we can continue streaming profiles.
 		g.Go(util.RecoverPanic(func() error {
@@ -720,10 +879,27 @@ func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1
 			if err != nil {
 				return err
 			}
+			if !r.PartialResults {
+				lock.Lock()
+				defer lock.Unlock()
+				result = append(result, merge)
+				return nil
+			}
+			b, err := marshalPprofResult(request, merge)
+			if err != nil {
+				return err
+			}
+			select {
+			case <-streamed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
 			lock.Lock()
 			defer lock.Unlock()
-			result = append(result, merge)
-			return nil
+			return stream.Send(&ingestv1.MergeProfilesPprofResponse{
+				Result:  b,
+				Partial: true,
+			})
 		}))
 	}
 
@@ -732,10 +908,21 @@ func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1
 	if err := stream.Send(&ingestv1.MergeProfilesPprofResponse{}); err != nil {
 		return err
 	}
+	close(streamed)
 
 	if err := g.Wait(); err != nil {
 		return err
 	}
+	if r.PartialResults {
+		// The last message carries the statistics of the whole merge.
+		err = stream.Send(&ingestv1.MergeProfilesPprofResponse{
+			Stats: st.QueryStats(),
+		})
+		if errors.Is(err, io.EOF) {
+			return connect.NewError(connect.CodeCanceled, errors.New("client closed stream"))
+		}
+		return err
+	}
 	if len(result) == 0 {
 		result = append(result, &profile.Profile{})
 	}
@@ -756,6 +943,7 @@ func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1
 	// sends the final result to the client.
 	err = stream.Send(&ingestv1.MergeProfilesPprofResponse{
 		Result: buf.Bytes(),
+		Stats:  st.QueryStats(),
 	})
 	if err != nil {
 		if errors.Is(err, io.EOF) {
@@ -767,6 +955,18 @@ func MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1
 	return nil
 }
 
+// marshalPprofResult returns the uncompressed pprof encoding of the merge
+// result of a block.
+func marshalPprofResult(request *ingestv1.SelectProfilesRequest, p *profile.Profile) ([]byte, error) {
+	phlaremodel.SetProfileMetadata(p, request.Type)
+	p.TimeNanos = model.Time(request.End).UnixNano()
+	var buf bytes.Buffer
+	if err := p.WriteUncompressed(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 var maxBlockProfile Profile = BlockProfile{
 	ts: model.Time(math.MaxInt64),
 }
@@ -776,6 +976,8 @@ type BlockProfile struct {
 	fp                  model.Fingerprint
 	ts                  model.Time
 	stacktracePartition uint64
+	period              int64
+	valueIndex          int
 	RowNum              int64
 }
 
@@ -799,6 +1001,14 @@ func (p BlockProfile) Fingerprint() model.Fingerprint {
 	return p.fp
 }
 
+func (p BlockProfile) ValueIndex() int {
+	return p.valueIndex
+}
+
+func (p BlockProfile) Period() int64 {
+	return p.period
+}
+
 func retrieveStacktracePartition(buf [][]parquet.Value, pos int) uint64 {
 	if len(buf) > pos && len(buf[pos]) == 1 {
 		return buf[pos][0].Uint64()
@@ -808,52 +1018,28 @@ func retrieveStacktracePartition(buf [][]parquet.Value, pos int) uint64 {
 	return uint64(0)
 }
 
+// retrievePeriod returns the sampling period of the profile, or zero if
+// the column was not read or the period is not set.
+func retrievePeriod(buf [][]parquet.Value, pos int) int64 {
+	if len(buf) > pos && len(buf[pos]) == 1 && !buf[pos][0].IsNull() {
+		return buf[pos][0].Int64()
+	}
+	return 0
+}
+
+// errUnknownPeriod is returned when the sample values are to be scaled by
+// the sampling period, but the period of a profile is not known, e.g. if
+// it was written before the period was stored.
+var errUnknownPeriod = errors.New("the sampling period of the profiles is unknown: their values cannot be converted")
+
 func (b *singleBlockQuerier) SelectMatchingProfiles(ctx context.Context, params *ingestv1.SelectProfilesRequest) (iter.Iterator[Profile], error) {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectMatchingProfiles - Block")
 	defer sp.Finish()
-	if err := b.Open(ctx); err != nil {
-		return nil, err
-	}
-	matchers, err := parser.ParseMetricSelector(params.LabelSelector)
-	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, "failed to parse label selectors: "+err.Error())
-	}
-	if params.Type == nil {
-		return nil, errors.New("no profileType given")
-	}
-	matchers = append(matchers, phlaremodel.SelectorFromProfileType(params.Type))
-
-	postings, err := PostingsForMatchers(b.index, nil, matchers...)
+	lblsPerRef, err := b.selectMatchingSeries(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 
-	var (
-		lbls       = make(phlaremodel.Labels, 0, 6)
-		chks       = make([]index.ChunkMeta, 1)
-		lblsPerRef = make(map[int64]labelsInfo)
-	)
-
-	// get all relevant labels/fingerprints
-	for postings.Next() {
-		fp, err := b.index.Series(postings.At(), &lbls, &chks)
-		if err != nil {
-			return nil, err
-		}
-		if lblsExisting, exists := lblsPerRef[int64(chks[0].SeriesIndex)]; exists {
-			// Compare to check if there is a clash
-			if phlaremodel.CompareLabelPairs(lbls, lblsExisting.lbs) != 0 {
-				panic("label hash conflict")
-			}
-		} else {
-			lblsPerRef[int64(chks[0].SeriesIndex)] = labelsInfo{
-				fp:  model.Fingerprint(fp),
-				lbs: lbls,
-			}
-			lbls = make(phlaremodel.Labels, 0, 6)
-		}
-	}
-
 	var buf [][]parquet.Value
 
 	pIt := query.NewBinaryJoinIterator(
@@ -868,10 +1054,15 @@ func (b *singleBlockQuerier) SelectMatchingProfiles(ctx context.Context, params
 			pIt,
 			b.profiles.columnIter(ctx, "StacktracePartition", nil, "StacktracePartition"),
 		)
-		buf = make([][]parquet.Value, 3)
-	} else {
-		buf = make([][]parquet.Value, 2)
 	}
+	if params.ScaleByPeriod {
+		pIt = query.NewBinaryJoinIterator(
+			0,
+			pIt,
+			b.profiles.columnIter(ctx, "Period", nil, "Period"),
+		)
+	}
+	buf = make([][]parquet.Value, 4)
 
 	iters := make([]iter.Iterator[Profile], 0, len(lblsPerRef))
 	defer pIt.Close()
@@ -880,7 +1071,7 @@ func (b *singleBlockQuerier) SelectMatchingProfiles(ctx context.Context, params
 	var currentSeriesSlice []Profile
 	for pIt.Next() {
 		res := pIt.At()
-		buf = res.Columns(buf, "SeriesIndex", "TimeNanos", "StacktracePartition")
+		buf = res.Columns(buf, "SeriesIndex", "TimeNanos", "StacktracePartition", "Period")
 		seriesIndex := buf[0][0].Int64()
 		if seriesIndex != currSeriesIndex {
 			currSeriesIndex = seriesIndex
@@ -890,11 +1081,17 @@ func (b *singleBlockQuerier) SelectMatchingProfiles(ctx context.Context, params
 			currentSeriesSlice = make([]Profile, 0, 100)
 		}
 
+		period := retrievePeriod(buf, 3)
+		if params.ScaleByPeriod && period == 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errUnknownPeriod)
+		}
 		currentSeriesSlice = append(currentSeriesSlice, BlockProfile{
 			labels:              lblsPerRef[seriesIndex].lbs,
 			fp:                  lblsPerRef[seriesIndex].fp,
 			ts:                  model.TimeFromUnixNano(buf[1][0].Int64()),
 			stacktracePartition: retrieveStacktracePartition(buf, 2),
+			period:              period,
+			valueIndex:          lblsPerRef[seriesIndex].valueIndex,
 			RowNum:              res.RowNumber[0],
 		})
 	}
@@ -905,6 +1102,128 @@ func (b *singleBlockQuerier) SelectMatchingProfiles(ctx context.Context, params
 	return iter.NewMergeIterator(maxBlockProfile, false, iters...), nil
 }
 
+// selectMatchingSeries returns the labels of the series matching the
+// selector by their series index.
+func (b *singleBlockQuerier) selectMatchingSeries(ctx context.Context, params *ingestv1.SelectProfilesRequest) (map[int64]labelsInfo, error) {
+	if err := b.Open(ctx); err != nil {
+		return nil, err
+	}
+	matchers, err := parser.ParseMetricSelector(params.LabelSelector)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse label selectors: "+err.Error())
+	}
+	if params.Type == nil {
+		return nil, errors.New("no profileType given")
+	}
+	profileTypeSelector := phlaremodel.SelectorFromProfileType(params.Type)
+	matchers = append(matchers, profileTypeSelector)
+	seriesShard, matchers, err := splitShardMatcher(matchers)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	postings, err := PostingsForMatchers(b.index, nil, matchers...)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lbls       = make(phlaremodel.Labels, 0, 6)
+		chks       = make([]index.ChunkMeta, 1)
+		lblsPerRef = make(map[int64]labelsInfo)
+	)
+
+	// get all relevant labels/fingerprints
+	for postings.Next() {
+		fp, err := b.index.Series(postings.At(), &lbls, &chks)
+		if err != nil {
+			return nil, err
+		}
+		if seriesShard != nil && !seriesShard.TSDB().Match(model.Fingerprint(fp)) {
+			continue
+		}
+		if lblsExisting, exists := lblsPerRef[int64(chks[0].SeriesIndex)]; exists {
+			// Compare to check if there is a clash
+			if phlaremodel.CompareLabelPairs(lbls, lblsExisting.lbs) != 0 {
+				panic("label hash conflict")
+			}
+		} else {
+			info := labelsInfo{
+				fp:  model.Fingerprint(fp),
+				lbs: lbls,
+			}
+			if additionalProfileTypes(lbls) != nil {
+				if info.lbs, err = profileTypeSeriesLabels(lbls, 0); err != nil {
+					return nil, err
+				}
+				info.fp = model.Fingerprint(info.lbs.Hash())
+			}
+			lblsPerRef[int64(chks[0].SeriesIndex)] = info
+			lbls = make(phlaremodel.Labels, 0, 6)
+		}
+	}
+	if err = postings.Err(); err != nil {
+		return nil, err
+	}
+
+	// The profile type may also be held by multi-value profile series.
+	multiValue := newMultiValueSelector(matchers, profileTypeSelector.Value)
+	if postings, err = PostingsForMatchers(b.index, nil, multiValue.matchers...); err != nil {
+		return nil, err
+	}
+	for postings.Next() {
+		if _, err = b.index.Series(postings.At(), &lbls, &chks); err != nil {
+			return nil, err
+		}
+		lbs, valueIndex, ok := multiValue.series(lbls)
+		if !ok {
+			continue
+		}
+		fp := model.Fingerprint(lbs.Hash())
+		if seriesShard != nil && !seriesShard.TSDB().Match(fp) {
+			continue
+		}
+		lblsPerRef[int64(chks[0].SeriesIndex)] = labelsInfo{
+			fp:         fp,
+			lbs:        lbs,
+			valueIndex: valueIndex,
+		}
+	}
+	if err = postings.Err(); err != nil {
+		return nil, err
+	}
+
+	stats.FromContext(ctx).AddFetchedSeries(uint64(len(lblsPerRef)))
+	if err = b.checkQueryLimits(ctx, lblsPerRef); err != nil {
+		return nil, err
+	}
+	return lblsPerRef, nil
+}
+
+// checkQueryLimits accounts the series matched in the block, and the
+// bytes of the profiles table estimated to be read for them: the share
+// of the matched series in the block is applied to the table size.
+// If the number of series in the block is unknown, the whole table is
+// assumed to be read.
+func (b *singleBlockQuerier) checkQueryLimits(ctx context.Context, lblsPerRef map[int64]labelsInfo) error {
+	ql := limiter.QueryLimiterFromContext(ctx)
+	if ql == nil {
+		return nil
+	}
+	fps := make([]model.Fingerprint, 0, len(lblsPerRef))
+	for _, info := range lblsPerRef {
+		fps = append(fps, info.fp)
+	}
+	if err := ql.AddSeries(fps...); err != nil {
+		return err
+	}
+	size := uint64(b.profiles.size)
+	if n := b.meta.Stats.NumSeries; n > 0 && uint64(len(lblsPerRef)) < n {
+		size = size * uint64(len(lblsPerRef)) / n
+	}
+	return ql.AddBytesRead(size)
+}
+
 func (b *singleBlockQuerier) Sort(in []Profile) []Profile {
 	// Sort by RowNumber to avoid seeking back and forth in the file.
 	sort.Slice(in, func(i, j int) bool {
@@ -1006,6 +1325,17 @@ func (q *singleBlockQuerier) openFiles(ctx context.Context) error {
 		return err
 	}))
 
+	for _, f := range q.meta.Files {
+		if f.RelPath == block.SpanIndexFilename {
+			size := f.SizeBytes
+			g.Go(util.RecoverPanic(func() (err error) {
+				q.spans, err = openSpanIndex(ctx, q.bucket, size)
+				return err
+			}))
+			break
+		}
+	}
+
 	return g.Wait()
 }
 
@@ -0,0 +1,206 @@
+package phlaredb
+
+import (
+	"context"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
+)
+
+// SeriesStats are the statistics of a series the label cardinality is
+// computed from.
+type SeriesStats struct {
+	Labels phlaremodel.Labels
+	// Bytes of the profiles ingested.
+	Bytes int64
+	// FirstSeen is the time of the first profile of the series.
+	FirstSeen model.Time
+}
+
+// SeriesStatsReader is implemented by the queriers that report the
+// statistics of their series.
+type SeriesStatsReader interface {
+	// SeriesStats calls fn once for each series matching any of the
+	// selectors, with profiles within the time range.
+	SeriesStats(ctx context.Context, sels [][]*labels.Matcher, start, end model.Time, fn func(model.Fingerprint, SeriesStats)) error
+}
+
+// LabelCardinality computes the label cardinality of the series of the blocks.
+func LabelCardinality(ctx context.Context, req *connect.Request[typesv1.LabelCardinalityRequest], blockGetter BlockGetter) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "LabelCardinality")
+	defer sp.Finish()
+
+	sels, err := parseSelectors(req.Msg.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	start, end := model.Time(req.Msg.Start), model.Time(req.Msg.End)
+	queriers, err := blockGetter(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	c := make(seriesStatsCollector)
+	for _, q := range queriers {
+		r, ok := q.(SeriesStatsReader)
+		if !ok {
+			continue
+		}
+		if err = r.SeriesStats(ctx, sels, start, end, c.add); err != nil {
+			return nil, err
+		}
+	}
+	return connect.NewResponse(c.labelCardinality(req.Msg)), nil
+}
+
+// seriesStatsCollector collects the statistics of series from multiple
+// readers: the statistics of the series found in more than one reader,
+// e.g. the head and a block, are merged.
+type seriesStatsCollector map[model.Fingerprint]*SeriesStats
+
+func (c seriesStatsCollector) add(fp model.Fingerprint, s SeriesStats) {
+	x, ok := c[fp]
+	if !ok {
+		c[fp] = &s
+		return
+	}
+	x.Bytes += s.Bytes
+	if s.FirstSeen < x.FirstSeen {
+		x.FirstSeen = s.FirstSeen
+	}
+}
+
+func (c seriesStatsCollector) labelCardinality(req *typesv1.LabelCardinalityRequest) *typesv1.LabelCardinalityResponse {
+	m := phlaremodel.NewLabelCardinalityMerger()
+	for _, s := range c {
+		m.AddSeries(s.Labels, s.Bytes, int64(s.FirstSeen))
+	}
+	return m.LabelCardinality(req)
+}
+
+func (h *Head) SeriesStats(_ context.Context, sels [][]*labels.Matcher, start, end model.Time, fn func(model.Fingerprint, SeriesStats)) error {
+	if selectors(sels).matchesAll() {
+		sels = [][]*labels.Matcher{nil}
+	}
+	seen := make(map[model.Fingerprint]struct{})
+	for _, sel := range sels {
+		err := h.profiles.index.forMatchingSeries(sel, func(s *profileSeries) error {
+			if _, ok := seen[s.fp]; ok {
+				return nil
+			}
+			seen[s.fp] = struct{}{}
+			minTime, maxTime := model.TimeFromUnixNano(s.minTime), model.TimeFromUnixNano(s.maxTime)
+			if minTime > end || maxTime < start {
+				return nil
+			}
+			fn(s.fp, SeriesStats{
+				Labels:    s.lbs,
+				Bytes:     int64(s.bytes),
+				FirstSeen: minTime,
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *singleBlockQuerier) SeriesStats(ctx context.Context, sels [][]*labels.Matcher, start, end model.Time, fn func(model.Fingerprint, SeriesStats)) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SeriesStats - Block")
+	defer sp.Finish()
+	if err := b.Open(ctx); err != nil {
+		return err
+	}
+	if selectors(sels).matchesAll() {
+		sels = [][]*labels.Matcher{nil}
+	}
+	var (
+		lbls = make(phlaremodel.Labels, 0, 6)
+		chks = make([]index.ChunkMeta, 1)
+		seen = make(map[uint64]struct{})
+	)
+	for _, sel := range sels {
+		postings, err := b.postingsForSelector(sel)
+		if err != nil {
+			return err
+		}
+		for postings.Next() {
+			fp, err := b.index.Series(postings.At(), &lbls, &chks)
+			if err != nil {
+				return err
+			}
+			if _, ok := seen[fp]; ok {
+				continue
+			}
+			seen[fp] = struct{}{}
+			s := SeriesStats{FirstSeen: model.Latest}
+			for _, c := range chks {
+				minTime, maxTime := model.TimeFromUnixNano(c.MinTime), model.TimeFromUnixNano(c.MaxTime)
+				if minTime > end || maxTime < start {
+					continue
+				}
+				s.Bytes += int64(c.KB) * 1024
+				if minTime < s.FirstSeen {
+					s.FirstSeen = minTime
+				}
+			}
+			if s.FirstSeen == model.Latest {
+				continue
+			}
+			s.Labels = lbls
+			fn(model.Fingerprint(fp), s)
+			lbls = make(phlaremodel.Labels, 0, 6)
+		}
+		if err = postings.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *singleBlockQuerier) postingsForSelector(sel []*labels.Matcher) (index.Postings, error) {
+	if len(sel) == 0 {
+		k, v := index.AllPostingsKey()
+		return b.index.Postings(k, nil, v)
+	}
+	return PostingsForMatchers(b.index, nil, sel...)
+}
+
+// LabelCardinality computes the label cardinality of the series of the
+// head and the local blocks.
+func (f *PhlareDB) LabelCardinality(ctx context.Context, req *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	sels, err := parseSelectors(req.Msg.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	start, end := model.Time(req.Msg.Start), model.Time(req.Msg.End)
+	c := make(seriesStatsCollector)
+	f.headLock.RLock()
+	for _, h := range []*Head{f.head, f.oldHead} {
+		if h == nil {
+			continue
+		}
+		if err = h.SeriesStats(ctx, sels, start, end, c.add); err != nil {
+			f.headLock.RUnlock()
+			return nil, err
+		}
+	}
+	f.headLock.RUnlock()
+	for _, q := range f.blockQuerier.Queriers() {
+		r, ok := q.(SeriesStatsReader)
+		if !ok || !InRange(q, start, end) {
+			continue
+		}
+		if err = r.SeriesStats(ctx, sels, start, end, c.add); err != nil {
+			return nil, err
+		}
+	}
+	return connect.NewResponse(c.labelCardinality(req.Msg)), nil
+}
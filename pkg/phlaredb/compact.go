@@ -13,6 +13,7 @@ import (
 	"github.com/parquet-go/parquet-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 
@@ -35,6 +36,35 @@ type BlockReader interface {
 }
 
 func Compact(ctx context.Context, src []BlockReader, dst string) (meta block.Meta, err error) {
+	if len(src) <= 1 {
+		return block.Meta{}, errors.New("not enough blocks to compact")
+	}
+	return compact(ctx, src, dst, nil)
+}
+
+// Rewrite writes a copy of the src block to the dst directory, leaving out
+// the series matching all the given matchers. The resulting block has a new
+// ULID and the src block as its parent.
+func Rewrite(ctx context.Context, src BlockReader, dst string, drop ...*labels.Matcher) (meta block.Meta, err error) {
+	if len(drop) == 0 {
+		return block.Meta{}, errors.New("no series matchers given")
+	}
+	if v := src.Meta().Version; v < block.MetaVersion3 {
+		return block.Meta{}, errors.Errorf("rewriting blocks of version %d is not supported", v)
+	}
+	return compact(ctx, []BlockReader{src}, dst, func(ls phlaremodel.Labels) bool {
+		for _, m := range drop {
+			if !m.Matches(ls.Get(m.Name)) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// compact merges the src blocks into a new block in the dst directory.
+// If keep is not nil, only the series it returns true for are written.
+func compact(ctx context.Context, src []BlockReader, dst string, keep func(phlaremodel.Labels) bool) (meta block.Meta, err error) {
 	srcMetas := make([]block.Meta, len(src))
 	ulids := make([]string, len(src))
 
@@ -59,9 +89,6 @@ func Compact(ctx context.Context, src []BlockReader, dst string) (meta block.Met
 		sp.Finish()
 	}()
 
-	if len(src) <= 1 {
-		return block.Meta{}, errors.New("not enough blocks to compact")
-	}
 	if err := os.MkdirAll(blockPath, 0o777); err != nil {
 		return block.Meta{}, err
 	}
@@ -91,6 +118,9 @@ func Compact(ctx context.Context, src []BlockReader, dst string) (meta block.Met
 	if err != nil {
 		return block.Meta{}, err
 	}
+	if keep != nil {
+		rowsIt = &filterProfileRowIterator{Iterator: rowsIt, keep: keep}
+	}
 	seriesRewriter := newSeriesRewriter(rowsIt, indexw)
 	symRewriter := newSymbolsRewriter(seriesRewriter, src, symw)
 	reader := phlareparquet.NewIteratorRowReader(newRowsIterator(symRewriter))
@@ -112,6 +142,9 @@ func Compact(ctx context.Context, src []BlockReader, dst string) (meta block.Met
 	if err = profileWriter.Close(); err != nil {
 		return block.Meta{}, err
 	}
+	if _, err = writeSpanIndex(profilePath, blockPath); err != nil {
+		return block.Meta{}, err
+	}
 	if err = symw.Flush(); err != nil {
 		return block.Meta{}, err
 	}
@@ -274,8 +307,16 @@ func newProfileRowIterator(s BlockReader) (*profileRowIterator, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Row groups of blocks written before multi-value profiles
+	// are converted to the current schema of the profiles table.
+	rowGroups := make([]parquet.RowGroup, len(s.Profiles()))
+	for i, rg := range s.Profiles() {
+		if rowGroups[i], err = schemav1.ConvertProfilesRowGroup(rg); err != nil {
+			return nil, err
+		}
+	}
 	// todo close once https://github.com/grafana/pyroscope/issues/2172 is done.
-	reader := parquet.MultiRowGroup(s.Profiles()...).Rows()
+	reader := parquet.MultiRowGroup(rowGroups...).Rows()
 	return &profileRowIterator{
 		profiles:         phlareparquet.NewBufferedRowReaderIterator(reader, 1024),
 		blockReader:      s,
@@ -379,6 +420,7 @@ type seriesRewriter struct {
 	labels           phlaremodel.Labels
 	previousFp       model.Fingerprint
 	currentChunkMeta index.ChunkMeta
+	currentBytes     uint64
 	err              error
 
 	numSeries uint64
@@ -404,6 +446,7 @@ func (s *seriesRewriter) Next() bool {
 		s.done = true
 		if s.previousFp != 0 {
 			s.currentChunkMeta.SeriesIndex = uint32(s.seriesRef) - 1
+			s.currentChunkMeta.KB = uint32((s.currentBytes + 512) / 1024)
 			if err := s.indexw.AddSeries(s.seriesRef-1, s.labels, s.previousFp, s.currentChunkMeta); err != nil {
 				s.err = err
 				return false
@@ -416,6 +459,7 @@ func (s *seriesRewriter) Next() bool {
 	if s.previousFp != currentProfile.fp {
 		if s.previousFp != 0 {
 			s.currentChunkMeta.SeriesIndex = uint32(s.seriesRef) - 1
+			s.currentChunkMeta.KB = uint32((s.currentBytes + 512) / 1024)
 			if err := s.indexw.AddSeries(s.seriesRef-1, s.labels, s.previousFp, s.currentChunkMeta); err != nil {
 				s.err = err
 				return false
@@ -426,7 +470,9 @@ func (s *seriesRewriter) Next() bool {
 		s.labels = currentProfile.labels.Clone()
 		s.previousFp = currentProfile.fp
 		s.currentChunkMeta.MinTime = currentProfile.timeNanos
+		s.currentBytes = 0
 	}
+	s.currentBytes += currentProfile.row.Size()
 	s.currentChunkMeta.MaxTime = currentProfile.timeNanos
 	currentProfile.row.SetSeriesIndex(uint32(s.seriesRef - 1))
 	return true
@@ -469,6 +515,20 @@ func (it *dedupeProfileRowIterator) Next() bool {
 	}
 }
 
+type filterProfileRowIterator struct {
+	iter.Iterator[profileRow]
+	keep func(phlaremodel.Labels) bool
+}
+
+func (it *filterProfileRowIterator) Next() bool {
+	for it.Iterator.Next() {
+		if it.keep(it.Iterator.At().labels) {
+			return true
+		}
+	}
+	return false
+}
+
 func prepareIndexWriter(ctx context.Context, path string, readers []BlockReader) (*index.Writer, error) {
 	var symbols index.StringIter
 	indexw, err := index.NewWriter(ctx, path)
@@ -12,6 +12,7 @@ import (
 	"github.com/oklog/ulid"
 	"github.com/parquet-go/parquet-go"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/stretchr/testify/assert"
@@ -85,6 +86,53 @@ func TestCompact(t *testing.T) {
 	require.Equal(t, expected.String(), res.String())
 }
 
+func TestRewrite(t *testing.T) {
+	ctx := context.Background()
+	b := newBlock(t, func() []*testhelper.ProfileBuilder {
+		return []*testhelper.ProfileBuilder{
+			testhelper.NewProfileBuilder(int64(time.Second*1)).
+				CPUProfile().
+				WithLabels(
+					"job", "a",
+				).ForStacktraceString("foo", "bar", "baz").AddSamples(1),
+			testhelper.NewProfileBuilder(int64(time.Second*2)).
+				CPUProfile().
+				WithLabels(
+					"job", "b",
+				).ForStacktraceString("foo", "bar", "qux").AddSamples(2),
+			testhelper.NewProfileBuilder(int64(time.Second*3)).
+				CPUProfile().
+				WithLabels(
+					"job", "c",
+				).ForStacktraceString("foo", "bar", "baz").AddSamples(3),
+		}
+	})
+	dst := t.TempDir()
+	rewritten, err := Rewrite(ctx, b, dst, labels.MustNewMatcher(labels.MatchRegexp, "job", "a|b"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), rewritten.Stats.NumProfiles)
+	require.Equal(t, uint64(1), rewritten.Stats.NumSeries)
+	require.NotEqual(t, b.Meta().ULID, rewritten.ULID)
+	querier := blockQuerierFromMeta(t, dst, rewritten)
+
+	it, err := querier.SelectMatchingProfiles(ctx, &ingesterv1.SelectProfilesRequest{
+		LabelSelector: "{}",
+		Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+		Start:         0,
+		End:           40000,
+	})
+	require.NoError(t, err)
+	res, err := querier.MergeByStacktraces(ctx, it)
+	require.NoError(t, err)
+
+	expected := new(phlaremodel.Tree)
+	expected.InsertStack(3, "baz", "bar", "foo")
+	require.Equal(t, expected.String(), res.String())
+
+	_, err = Rewrite(ctx, b, dst)
+	require.Error(t, err)
+}
+
 func TestProfileRowIterator(t *testing.T) {
 	b := newBlock(t, func() []*testhelper.ProfileBuilder {
 		return []*testhelper.ProfileBuilder{
@@ -273,18 +321,25 @@ func TestSeriesRewriter(t *testing.T) {
 	require.NoError(t, err)
 	it := newSeriesRewriter(rows, idxw)
 	// tests that all rows are written to the correct series index
+	var bytes [3]uint64
 	require.True(t, it.Next())
 	require.Equal(t, uint32(0), it.At().row.SeriesIndex())
+	bytes[0] += it.At().row.Size()
 	require.True(t, it.Next())
 	require.Equal(t, uint32(0), it.At().row.SeriesIndex())
+	bytes[0] += it.At().row.Size()
 	require.True(t, it.Next())
 	require.Equal(t, uint32(0), it.At().row.SeriesIndex())
+	bytes[0] += it.At().row.Size()
 	require.True(t, it.Next())
 	require.Equal(t, uint32(1), it.At().row.SeriesIndex())
+	bytes[1] += it.At().row.Size()
 	require.True(t, it.Next())
 	require.Equal(t, uint32(2), it.At().row.SeriesIndex())
+	bytes[2] += it.At().row.Size()
 	require.True(t, it.Next())
 	require.Equal(t, uint32(2), it.At().row.SeriesIndex())
+	bytes[2] += it.At().row.Size()
 	require.False(t, it.Next())
 
 	require.NoError(t, it.Err())
@@ -311,6 +366,7 @@ func TestSeriesRewriter(t *testing.T) {
 		SeriesIndex: 0,
 		MinTime:     int64(1),
 		MaxTime:     int64(3),
+		KB:          uint32((bytes[0] + 512) / 1024),
 	}}, chunks)
 
 	require.True(t, p.Next())
@@ -322,6 +378,7 @@ func TestSeriesRewriter(t *testing.T) {
 		SeriesIndex: 1,
 		MinTime:     int64(2),
 		MaxTime:     int64(2),
+		KB:          uint32((bytes[1] + 512) / 1024),
 	}}, chunks)
 
 	require.True(t, p.Next())
@@ -333,6 +390,7 @@ func TestSeriesRewriter(t *testing.T) {
 		SeriesIndex: 2,
 		MinTime:     int64(1),
 		MaxTime:     int64(2),
+		KB:          uint32((bytes[2] + 512) / 1024),
 	}}, chunks)
 }
 
@@ -369,15 +427,16 @@ func TestFlushMeta(t *testing.T) {
 	require.Equal(t, uint64(3), b.Meta().Stats.NumSeries)
 	require.Equal(t, uint64(3), b.Meta().Stats.NumSamples)
 	require.Equal(t, uint64(3), b.Meta().Stats.NumProfiles)
-	require.Len(t, b.Meta().Files, 8)
+	require.Len(t, b.Meta().Files, 9)
 	require.Equal(t, "index.tsdb", b.Meta().Files[0].RelPath)
 	require.Equal(t, "profiles.parquet", b.Meta().Files[1].RelPath)
-	require.Equal(t, "symbols/functions.parquet", b.Meta().Files[2].RelPath)
-	require.Equal(t, "symbols/index.symdb", b.Meta().Files[3].RelPath)
-	require.Equal(t, "symbols/locations.parquet", b.Meta().Files[4].RelPath)
-	require.Equal(t, "symbols/mappings.parquet", b.Meta().Files[5].RelPath)
-	require.Equal(t, "symbols/stacktraces.symdb", b.Meta().Files[6].RelPath)
-	require.Equal(t, "symbols/strings.parquet", b.Meta().Files[7].RelPath)
+	require.Equal(t, "spans.bloom", b.Meta().Files[2].RelPath)
+	require.Equal(t, "symbols/functions.parquet", b.Meta().Files[3].RelPath)
+	require.Equal(t, "symbols/index.symdb", b.Meta().Files[4].RelPath)
+	require.Equal(t, "symbols/locations.parquet", b.Meta().Files[5].RelPath)
+	require.Equal(t, "symbols/mappings.parquet", b.Meta().Files[6].RelPath)
+	require.Equal(t, "symbols/stacktraces.symdb", b.Meta().Files[7].RelPath)
+	require.Equal(t, "symbols/strings.parquet", b.Meta().Files[8].RelPath)
 }
 
 func newBlock(t *testing.T, generator func() []*testhelper.ProfileBuilder) BlockReader {
@@ -1,112 +1,261 @@
 package phlaredb
 
 import (
+	"encoding/binary"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/prometheus/common/model"
 
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
-	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 )
 
-const (
-	memoryProfileName   = "memory"
-	allocObjectTypeName = "alloc_objects"
-	allocSpaceTypeName  = "alloc_space"
-	blockProfileName    = "block"
-	contentionsTypeName = "contentions"
-	delayTypeName       = "delay"
-)
+// defaultCumulativeSampleTypes are the sample types, in the form
+// <profile name>:<sample type>, whose values are accumulated since the
+// start of the process.
+var defaultCumulativeSampleTypes = []string{
+	"memory:alloc_objects",
+	"memory:alloc_space",
+}
 
-// deltaProfiles is a helper to compute delta of profiles.
+// deltaProfiles converts the values of cumulative profiles to deltas: the
+// values of the previous profile of the series are subtracted from the
+// values of each stack trace.
+//
+// The state of a series is keyed by the symbols of the stack traces rather
+// than by the stack trace IDs of a head, therefore it outlives the head the
+// profiles are written to. It is kept in memory only: it is lost when the
+// ingester restarts, and it is not handed over when a series moves to
+// another ingester.
+//
+// The first profile of a series only establishes the baseline: its values
+// are dropped, as they may have been ingested before. Clients that declare
+// the profiles cumulative with __delta__="true" may report when their
+// counters started with the duration of the profile: if they started after
+// the state was created, no earlier profile of the counters can have been
+// ingested and the first profile is kept.
+//
+// A decrease of a value, or a change of the start of the counters, means
+// that the counters have been reset, e.g. by a restart of the process, and
+// the values of the profile are kept as they are: they have been
+// accumulated since the reset. Series that are not seen for the TTL are
+// evicted.
 type deltaProfiles struct {
-	mtx sync.Mutex
-	// todo cleanup sample profiles that are not used anymore using a cleanup goroutine.
-	highestSamples map[model.Fingerprint]map[uint32]uint64
+	cumulative map[string]struct{}
+	ttl        time.Duration
+	now        func() time.Time
+	// Time the state was created at.
+	created time.Time
+
+	mtx          sync.Mutex
+	series       map[model.Fingerprint]*deltaSeries
+	lastEviction time.Time
 }
 
-func newDeltaProfiles() *deltaProfiles {
-	return &deltaProfiles{
-		highestSamples: make(map[model.Fingerprint]map[uint32]uint64),
-	}
+// maxMissingProfiles is the number of consecutive profiles a stack trace
+// may be missing from before its value is forgotten.
+const maxMissingProfiles = 8
+
+type deltaSeries struct {
+	lastSeen time.Time
+	// Start of the counters in nanoseconds, 0 if unknown.
+	start int64
+	// Values of the last profile by sample key.
+	values map[uint64]deltaValue
 }
 
-func newSampleDict(samples schemav1.Samples) map[uint32]uint64 {
-	dict := make(map[uint32]uint64)
-	for i, s := range samples.StacktraceIDs {
-		dict[s] += samples.Values[i]
-	}
-	return dict
+type deltaValue struct {
+	value uint64
+	// Number of consecutive profiles the stack trace is missing from.
+	missing int
 }
 
-func (d *deltaProfiles) computeDelta(ps schemav1.InMemoryProfile, lbs phlaremodel.Labels) schemav1.Samples {
-	// there's no delta to compute for those profile.
-	if !isDelta(lbs) {
-		// Trim zero and negative values.
-		return ps.Samples.Compact(false)
+func newDeltaProfiles(cfg Config) *deltaProfiles {
+	sampleTypes := cfg.CumulativeSampleTypes
+	if sampleTypes == nil {
+		sampleTypes = defaultCumulativeSampleTypes
 	}
-
-	d.mtx.Lock()
-	defer d.mtx.Unlock()
-
-	// we store all series ref so fetching with one work.
-	lastSamples, ok := d.highestSamples[ps.SeriesFingerprint]
-	if !ok {
-		// if we don't have the last profile, we can't compute the delta.
-		// so we remove the delta from the list of labels and profiles.
-		d.highestSamples[ps.SeriesFingerprint] = newSampleDict(ps.Samples)
-
-		return schemav1.Samples{}
+	d := &deltaProfiles{
+		cumulative: make(map[string]struct{}, len(sampleTypes)),
+		ttl:        cfg.DeltaStateTTL,
+		now:        time.Now,
+		series:     make(map[model.Fingerprint]*deltaSeries),
+	}
+	for _, t := range sampleTypes {
+		d.cumulative[strings.TrimSpace(t)] = struct{}{}
 	}
+	d.created = d.now()
+	d.lastEviction = d.created
+	return d
+}
 
-	// we have the last profile, we can compute the delta.
-	// samples are sorted by stacktrace id.
-	// we need to compute the delta for each stacktrace.
-	if len(lastSamples) == 0 {
-		return ps.Samples.Compact(false)
+// computeDelta replaces the values of the cumulative sample types of the
+// profile with their deltas. Labels and fingerprints are the series of the
+// sample types.
+func (d *deltaProfiles) computeDelta(p *profilev1.Profile, labels []phlaremodel.Labels, fingerprints []model.Fingerprint) {
+	var keys []uint64
+	for _, lbs := range labels {
+		if d.isCumulative(lbs) {
+			keys = sampleKeys(p)
+			break
+		}
+	}
+	if keys == nil {
+		return
 	}
 
-	reset := deltaSamples(lastSamples, ps.Samples)
-	if reset {
-		// if we reset the delta, we can't compute the delta anymore.
-		// so we remove the delta from the list of labels and profiles.
-		d.highestSamples[ps.SeriesFingerprint] = newSampleDict(ps.Samples)
-		return schemav1.Samples{}
+	var start int64
+	if p.DurationNanos > 0 && p.TimeNanos > p.DurationNanos {
+		start = p.TimeNanos - p.DurationNanos
 	}
 
-	return ps.Samples.Compact(false).Clone()
+	now := d.now()
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.evictExpired(now)
+	for idxType, lbs := range labels {
+		if !d.isCumulative(lbs) {
+			continue
+		}
+		s, ok := d.series[fingerprints[idxType]]
+		if !ok {
+			s = new(deltaSeries)
+			d.series[fingerprints[idxType]] = s
+		}
+		s.lastSeen = now
+		keepFirst := start > 0 && start >= d.created.UnixNano() &&
+			lbs.Get(phlaremodel.LabelNameDelta) == "true"
+		s.delta(p.Sample, idxType, keys, start, keepFirst)
+	}
 }
 
-func isDelta(lbs phlaremodel.Labels) bool {
+// isCumulative reports whether the values of the series are cumulative.
+// Clients declare it with the __delta__ label; otherwise it is determined
+// by the sample type.
+func (d *deltaProfiles) isCumulative(lbs phlaremodel.Labels) bool {
 	switch lbs.Get(phlaremodel.LabelNameDelta) {
 	case "false":
 		return false
 	case "true":
 		return true
 	}
-	if lbs.Get(model.MetricNameLabel) == memoryProfileName {
-		ty := lbs.Get(phlaremodel.LabelNameType)
-		if ty == allocObjectTypeName || ty == allocSpaceTypeName {
-			return true
+	_, ok := d.cumulative[lbs.Get(model.MetricNameLabel)+":"+lbs.Get(phlaremodel.LabelNameType)]
+	return ok
+}
+
+func (d *deltaProfiles) evictExpired(now time.Time) {
+	if d.ttl <= 0 || now.Sub(d.lastEviction) < d.ttl {
+		return
+	}
+	for fp, s := range d.series {
+		if now.Sub(s.lastSeen) >= d.ttl {
+			delete(d.series, fp)
 		}
 	}
-	return false
+	d.lastEviction = now
 }
 
-func deltaSamples(highest map[uint32]uint64, new schemav1.Samples) bool {
-	for i, id := range new.StacktraceIDs {
-		newValue := new.Values[i]
-		if s, ok := highest[id]; ok {
-			if s <= newValue {
-				new.Values[i] -= s
-				highest[id] = newValue
-			} else {
-				// this is a reset, we can't compute the delta anymore.
-				return true
+func (s *deltaSeries) delta(samples []*profilev1.Sample, idxType int, keys []uint64, start int64, keepFirst bool) {
+	values := make(map[uint64]deltaValue, len(s.values))
+	for i, sample := range samples {
+		if v := sample.Value[idxType]; v > 0 {
+			dv := values[keys[i]]
+			dv.value += uint64(v)
+			values[keys[i]] = dv
+		}
+	}
+	last, lastStart := s.values, s.start
+	s.values, s.start = values, start
+	if last == nil {
+		if !keepFirst {
+			for _, sample := range samples {
+				sample.Value[idxType] = 0
 			}
-			continue
 		}
-		highest[id] = newValue
+		return
+	}
+	if start > 0 && lastStart > 0 && start != lastStart {
+		// The counters have been restarted.
+		return
+	}
+	for k, v := range values {
+		if v.value < last[k].value {
+			// The counters have been reset.
+			return
+		}
+	}
+	deltas := make(map[uint64]uint64, len(values))
+	for k, v := range values {
+		deltas[k] = v.value - last[k].value
+	}
+	// Samples with the same key share the delta: it is assigned
+	// to the first of them.
+	for i, sample := range samples {
+		sample.Value[idxType] = int64(deltas[keys[i]])
+		deltas[keys[i]] = 0
+	}
+	// Stack traces missing in the profile keep their values,
+	// should they show up again within a few profiles.
+	for k, v := range last {
+		if _, ok := values[k]; !ok && v.missing < maxMissingProfiles {
+			values[k] = deltaValue{value: v.value, missing: v.missing + 1}
+		}
+	}
+}
+
+// sampleKeys returns the keys that identify the samples across profiles:
+// the hashes of the symbols of the stack traces and of the sample labels.
+func sampleKeys(p *profilev1.Profile) []uint64 {
+	locations := make(map[uint64]*profilev1.Location, len(p.Location))
+	for _, loc := range p.Location {
+		locations[loc.Id] = loc
+	}
+	functions := make(map[uint64]*profilev1.Function, len(p.Function))
+	for _, fn := range p.Function {
+		functions[fn.Id] = fn
+	}
+	var (
+		h    = xxhash.New()
+		b    [8]byte
+		keys = make([]uint64, len(p.Sample))
+	)
+	writeUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(b[:], v)
+		_, _ = h.Write(b[:])
+	}
+	writeString := func(i int64) {
+		if i > 0 && i < int64(len(p.StringTable)) {
+			_, _ = h.WriteString(p.StringTable[i])
+		}
+		_, _ = h.Write([]byte{0})
+	}
+	for i, sample := range p.Sample {
+		h.Reset()
+		for _, id := range sample.LocationId {
+			loc, ok := locations[id]
+			if !ok {
+				continue
+			}
+			if len(loc.Line) == 0 {
+				writeUint64(loc.Address)
+			}
+			for _, line := range loc.Line {
+				if fn, ok := functions[line.FunctionId]; ok {
+					writeString(fn.Name)
+					writeString(fn.Filename)
+				}
+				writeUint64(uint64(line.Line))
+			}
+		}
+		for _, l := range sample.Label {
+			writeString(l.Key)
+			writeString(l.Str)
+			writeUint64(uint64(l.Num))
+		}
+		keys[i] = h.Sum64()
 	}
-	return false
+	return keys
 }
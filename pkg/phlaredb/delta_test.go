@@ -2,183 +2,196 @@ package phlaredb
 
 import (
 	"testing"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
 
-	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
-	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
-	"github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
 )
 
+// memoryProfile returns a memory profile with two stack traces, the values
+// are given per stack trace. Unless reversed, the stack traces are added
+// in a different order, which changes the IDs of locations and functions.
+func memoryProfile(reversed bool, abc, abcd []int64, lv ...string) *testhelper.ProfileBuilder {
+	b := testhelper.NewProfileBuilder(1).MemoryProfile().WithLabels(lv...)
+	if reversed {
+		b.ForStacktraceString("a", "b", "c", "d").AddSamples(abcd...)
+		b.ForStacktraceString("a", "b", "c").AddSamples(abc...)
+		b.Sample[0], b.Sample[1] = b.Sample[1], b.Sample[0]
+		return b
+	}
+	b.ForStacktraceString("a", "b", "c").AddSamples(abc...)
+	b.ForStacktraceString("a", "b", "c", "d").AddSamples(abcd...)
+	return b
+}
+
+func sampleValues(b *testhelper.ProfileBuilder) [][]int64 {
+	values := make([][]int64, len(b.Sample))
+	for i, s := range b.Sample {
+		values[i] = s.Value
+	}
+	return values
+}
+
+func computeDelta(d *deltaProfiles, b *testhelper.ProfileBuilder) [][]int64 {
+	labels, fingerprints := labelsForProfile(b.Profile, b.Labels...)
+	d.computeDelta(b.Profile, labels, fingerprints)
+	return sampleValues(b)
+}
+
 func TestComputeDelta(t *testing.T) {
-	delta := newDeltaProfiles()
-	builder := testhelper.NewProfileBuilder(1).MemoryProfile()
-	builder.ForStacktraceString("a", "b", "c").AddSamples(1, 2, 3, 4)
-	builder.ForStacktraceString("a", "b", "c", "d").AddSamples(1, 2, 3, 4)
-
-	profiles, labels := newProfileSchema(builder.Profile, "memory")
-
-	samples := delta.computeDelta(profiles[0], labels[0])
-	require.Empty(t, samples.StacktraceIDs)
-	samples = delta.computeDelta(profiles[1], labels[1])
-	require.Empty(t, samples.StacktraceIDs)
-	samples = delta.computeDelta(profiles[2], labels[2])
-	require.NotEmpty(t, samples.StacktraceIDs)
-	require.Equal(t, 2, len(samples.StacktraceIDs))
-	require.Equal(t, uint64(3), samples.Values[0])
-	require.Equal(t, uint64(3), samples.Values[1])
-	samples = delta.computeDelta(profiles[3], labels[3])
-	require.NotEmpty(t, samples.StacktraceIDs)
-	require.Equal(t, 2, len(samples.StacktraceIDs))
-	require.Equal(t, uint64(4), samples.Values[0])
-	require.Equal(t, uint64(4), samples.Values[1])
-
-	profiles, labels = newProfileSchema(builder.Profile, "memory")
-	samples = delta.computeDelta(profiles[0], labels[0])
-	require.Empty(t, samples.StacktraceIDs)
-	samples = delta.computeDelta(profiles[1], labels[1])
-	require.Empty(t, samples.StacktraceIDs)
-	samples = delta.computeDelta(profiles[2], labels[2])
-	require.NotEmpty(t, samples.StacktraceIDs)
-	require.Equal(t, 2, len(samples.StacktraceIDs))
-	require.Equal(t, uint64(3), samples.Values[0])
-	require.Equal(t, uint64(3), samples.Values[1])
-	samples = delta.computeDelta(profiles[3], labels[3])
-	require.NotEmpty(t, samples.StacktraceIDs)
-	require.Equal(t, 2, len(samples.StacktraceIDs))
-	require.Equal(t, uint64(4), samples.Values[0])
-	require.Equal(t, uint64(4), samples.Values[1])
+	d := newDeltaProfiles(Config{})
+
+	// The first profile establishes the baseline of alloc_*,
+	// inuse_* are not cumulative.
+	require.Equal(t, [][]int64{{0, 0, 3, 4}, {0, 0, 3, 4}},
+		computeDelta(d, memoryProfile(false, []int64{1, 2, 3, 4}, []int64{1, 2, 3, 4})))
+
+	require.Equal(t, [][]int64{{2, 3, 3, 4}, {1, 1, 3, 4}},
+		computeDelta(d, memoryProfile(true, []int64{3, 5, 3, 4}, []int64{2, 3, 3, 4})))
+
+	// Stack traces are identified by their symbols.
+	require.Equal(t, [][]int64{{1, 1, 3, 4}, {0, 0, 3, 4}},
+		computeDelta(d, memoryProfile(false, []int64{4, 6, 3, 4}, []int64{2, 3, 3, 4})))
+
+	// Counters reset: the values are accumulated since the reset.
+	require.Equal(t, [][]int64{{1, 2, 3, 4}, {2, 3, 3, 4}},
+		computeDelta(d, memoryProfile(false, []int64{1, 2, 3, 4}, []int64{2, 3, 3, 4})))
+
+	require.Equal(t, [][]int64{{1, 1, 3, 4}, {1, 1, 3, 4}},
+		computeDelta(d, memoryProfile(true, []int64{2, 3, 3, 4}, []int64{3, 4, 3, 4})))
+
+	// Series are distinct.
+	require.Equal(t, [][]int64{{0, 0, 3, 4}, {0, 0, 3, 4}},
+		computeDelta(d, memoryProfile(false, []int64{5, 5, 3, 4}, []int64{5, 5, 3, 4}, "pod", "bar")))
 }
 
-func newProfileSchema(p *profilev1.Profile, name string) ([]schemav1.InMemoryProfile, []phlaremodel.Labels) {
-	var (
-		labels, seriesRefs = labelsForProfile(p, &typesv1.LabelPair{Name: model.MetricNameLabel, Value: name})
-		ps                 = make([]schemav1.InMemoryProfile, len(labels))
-	)
-	for idxType := range labels {
-		ps[idxType] = schemav1.InMemoryProfile{
-			ID:                uuid.New(),
-			TimeNanos:         p.TimeNanos,
-			Comments:          p.Comment,
-			DurationNanos:     p.DurationNanos,
-			DropFrames:        p.DropFrames,
-			KeepFrames:        p.KeepFrames,
-			Period:            p.Period,
-			DefaultSampleType: p.DefaultSampleType,
+func TestComputeDelta_MissingStacktrace(t *testing.T) {
+	d := newDeltaProfiles(Config{})
+	newProfile := func(values ...int64) *testhelper.ProfileBuilder {
+		b := testhelper.NewProfileBuilder(1).MemoryProfile()
+		for i, v := range values {
+			if v > 0 {
+				b.ForStacktraceString("a", string(rune('b'+i))).AddSamples(v, v, 0, 0)
+			}
 		}
-		hashes := pprof.StacktracesHasher{}.Hashes(p.Sample)
-		ps[idxType].Samples = schemav1.Samples{
-			StacktraceIDs: make([]uint32, len(p.Sample)),
-			Values:        make([]uint64, len(p.Sample)),
-		}
-		for i, s := range p.Sample {
-			ps[idxType].Samples.Values[i] = uint64(s.Value[idxType])
-			ps[idxType].Samples.StacktraceIDs[i] = uint32(hashes[i])
+		return b
+	}
 
-		}
-		ps[idxType].SeriesFingerprint = seriesRefs[idxType]
+	require.Equal(t, [][]int64{{0, 0, 0, 0}, {0, 0, 0, 0}}, computeDelta(d, newProfile(1, 2)))
+	require.Equal(t, [][]int64{{2, 2, 0, 0}}, computeDelta(d, newProfile(3, 0)))
+	// The missing stack trace keeps its value.
+	require.Equal(t, [][]int64{{0, 0, 0, 0}, {1, 1, 0, 0}}, computeDelta(d, newProfile(3, 3)))
+}
+
+func TestComputeDelta_DuplicateSamples(t *testing.T) {
+	d := newDeltaProfiles(Config{})
+	newProfile := func(x, y int64) *testhelper.ProfileBuilder {
+		b := testhelper.NewProfileBuilder(1).MemoryProfile()
+		b.ForStacktraceString("a", "b").AddSamples(x, x, 0, 0)
+		b.ForStacktraceString("a", "b").AddSamples(y, y, 0, 0)
+		return b
 	}
-	return ps, labels
+
+	require.Equal(t, [][]int64{{0, 0, 0, 0}, {0, 0, 0, 0}}, computeDelta(d, newProfile(1, 2)))
+	require.Equal(t, [][]int64{{4, 4, 0, 0}, {0, 0, 0, 0}}, computeDelta(d, newProfile(3, 4)))
 }
 
-func TestDeltaSample(t *testing.T) {
-	new := schemav1.Samples{
-		StacktraceIDs: []uint32{2, 3},
-		Values:        []uint64{1, 1},
+func TestComputeDelta_Declared(t *testing.T) {
+	d := newDeltaProfiles(Config{CumulativeSampleTypes: []string{}})
+	newProfile := func(v int64, lv ...string) *testhelper.ProfileBuilder {
+		b := testhelper.NewProfileBuilder(1).CPUProfile().WithLabels(lv...)
+		b.ForStacktraceString("a", "b").AddSamples(v)
+		return b
 	}
-	highest := map[uint32]uint64{}
-	_ = deltaSamples(highest, new)
-	require.Equal(t, 2, len(highest))
-	require.Equal(t, map[uint32]uint64{
-		2: 1,
-		3: 1,
-	}, highest)
-
-	t.Run("same stacktraces, matching counter samples, matching gauge samples", func(t *testing.T) {
-		new = schemav1.Samples{
-			StacktraceIDs: []uint32{2, 3},
-			Values:        []uint64{1, 1},
-		}
-		_ = deltaSamples(highest, new)
-		require.Equal(t, 2, len(highest))
-		require.Equal(t, map[uint32]uint64{
-			2: 1,
-			3: 1,
-		}, highest)
-		require.Equal(t, schemav1.Samples{
-			StacktraceIDs: []uint32{2, 3},
-			Values:        []uint64{0, 0},
-		}, new)
-	})
-
-	t.Run("same stacktraces, matching counter samples, empty gauge samples", func(t *testing.T) {
-		new = schemav1.Samples{
-			StacktraceIDs: []uint32{2, 3},
-			Values:        []uint64{1, 1},
-		}
-		_ = deltaSamples(highest, new)
-		require.Equal(t, 2, len(highest))
-		require.Equal(t, map[uint32]uint64{
-			2: 1,
-			3: 1,
-		}, highest)
-		require.Equal(t, schemav1.Samples{
-			StacktraceIDs: []uint32{2, 3},
-			Values:        []uint64{0, 0},
-		}, new)
-	})
-
-	t.Run("new stacktrace, and increase counter in existing stacktrace", func(t *testing.T) {
-		new = schemav1.Samples{
-			StacktraceIDs: []uint32{3, 5},
-			Values:        []uint64{6, 1},
-		}
-		_ = deltaSamples(highest, new)
-		require.Equal(t, map[uint32]uint64{
-			2: 1,
-			3: 6,
-			5: 1,
-		}, highest)
-	})
-
-	t.Run("same stacktraces, counter samples resetting", func(t *testing.T) {
-		new = schemav1.Samples{
-			StacktraceIDs: []uint32{3, 5},
-			Values:        []uint64{0, 1},
-		}
-		reset := deltaSamples(highest, new)
-		require.True(t, reset)
-		require.Equal(t, map[uint32]uint64{
-			2: 1,
-			3: 6,
-			5: 1,
-		}, highest)
-	})
-
-	t.Run("two new stacktraces, raise counters of existing stacktrace", func(t *testing.T) {
-		new = schemav1.Samples{
-			StacktraceIDs: []uint32{0, 1, 7},
-			Values:        []uint64{10, 2, 1},
+
+	require.Equal(t, [][]int64{{1}}, computeDelta(d, newProfile(1)))
+	require.Equal(t, [][]int64{{0}}, computeDelta(d, newProfile(1, phlaremodel.LabelNameDelta, "true")))
+	require.Equal(t, [][]int64{{2}}, computeDelta(d, newProfile(3, phlaremodel.LabelNameDelta, "true")))
+
+	d = newDeltaProfiles(Config{})
+	b := memoryProfile(false, []int64{1, 2, 3, 4}, []int64{1, 2, 3, 4})
+	b.Labels = append(b.Labels, &typesv1.LabelPair{Name: phlaremodel.LabelNameDelta, Value: "false"})
+	require.Equal(t, [][]int64{{1, 2, 3, 4}, {1, 2, 3, 4}}, computeDelta(d, b))
+}
+
+func TestComputeDelta_TTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := newDeltaProfiles(Config{DeltaStateTTL: time.Minute})
+	d.now = func() time.Time { return now }
+	d.lastEviction = now
+
+	computeDelta(d, memoryProfile(false, []int64{1, 2, 3, 4}, []int64{1, 2, 3, 4}))
+	now = now.Add(30 * time.Second)
+	computeDelta(d, memoryProfile(false, []int64{1, 2, 3, 4}, []int64{1, 2, 3, 4}, "pod", "bar"))
+	require.Len(t, d.series, 4)
+
+	now = now.Add(45 * time.Second)
+	require.Equal(t, [][]int64{{1, 1, 3, 4}, {1, 1, 3, 4}},
+		computeDelta(d, memoryProfile(false, []int64{2, 3, 3, 4}, []int64{2, 3, 3, 4}, "pod", "bar")))
+	require.Len(t, d.series, 2)
+
+	// The evicted series starts over.
+	require.Equal(t, [][]int64{{0, 0, 3, 4}, {0, 0, 3, 4}},
+		computeDelta(d, memoryProfile(false, []int64{2, 3, 3, 4}, []int64{2, 3, 3, 4})))
+}
+
+func TestComputeDelta_StartTime(t *testing.T) {
+	now := time.Unix(100, 0)
+	d := newDeltaProfiles(Config{CumulativeSampleTypes: []string{}})
+	d.now = func() time.Time { return now }
+	d.created = now
+	newProfile := func(v int64, start time.Time, lv ...string) *testhelper.ProfileBuilder {
+		b := testhelper.NewProfileBuilder(now.UnixNano()).CPUProfile().
+			WithLabels(append([]string{phlaremodel.LabelNameDelta, "true"}, lv...)...)
+		b.DurationNanos = now.Sub(start).Nanoseconds()
+		b.ForStacktraceString("a", "b").AddSamples(v)
+		return b
+	}
+
+	// The counters started after the state was created: the first
+	// profile is kept.
+	now = now.Add(time.Minute)
+	require.Equal(t, [][]int64{{3}}, computeDelta(d, newProfile(3, now.Add(-10*time.Second))))
+	now = now.Add(10 * time.Second)
+	require.Equal(t, [][]int64{{2}}, computeDelta(d, newProfile(5, now.Add(-20*time.Second))))
+
+	// The counters have been restarted, although the value increased.
+	now = now.Add(10 * time.Second)
+	require.Equal(t, [][]int64{{6}}, computeDelta(d, newProfile(6, now.Add(-5*time.Second))))
+
+	// The counters started before the state was created: the values
+	// may have been ingested already.
+	require.Equal(t, [][]int64{{0}}, computeDelta(d, newProfile(7, time.Unix(50, 0), "pod", "bar")))
+}
+
+func TestComputeDelta_MissingStacktraceForgotten(t *testing.T) {
+	d := newDeltaProfiles(Config{})
+	newProfile := func(values ...int64) *testhelper.ProfileBuilder {
+		b := testhelper.NewProfileBuilder(1).MemoryProfile()
+		for i, v := range values {
+			if v > 0 {
+				b.ForStacktraceString("a", string(rune('b'+i))).AddSamples(v, v, 0, 0)
+			}
 		}
+		return b
+	}
 
-		_ = deltaSamples(highest, new)
-		require.Equal(t, map[uint32]uint64{
-			0: 10,
-			1: 2,
-			2: 1,
-			3: 6,
-			5: 1,
-			7: 1,
-		}, highest)
-
-		require.Equal(t, schemav1.Samples{
-			StacktraceIDs: []uint32{0, 1, 7},
-			Values:        []uint64{10, 2, 1},
-		}, new)
-	})
+	computeDelta(d, newProfile(1, 2))
+	for i := 0; i < maxMissingProfiles; i++ {
+		computeDelta(d, newProfile(1, 0))
+		require.Len(t, d.series[firstSeries(d)].values, 2)
+	}
+	computeDelta(d, newProfile(1, 0))
+	require.Len(t, d.series[firstSeries(d)].values, 1)
+}
+
+func firstSeries(d *deltaProfiles) model.Fingerprint {
+	for fp := range d.series {
+		return fp
+	}
+	return 0
 }
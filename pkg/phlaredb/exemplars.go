@@ -0,0 +1,168 @@
+package phlaredb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/opentracing/opentracing-go"
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"golang.org/x/sync/errgroup"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/iter"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+// SelectExemplars lists the exemplars of the profiles of the blocks that
+// match the request: an exemplar is the part of a profile that belongs to
+// a span. All the exemplars within the value bounds are returned, as the
+// heatmap is computed from all of them.
+func SelectExemplars(ctx context.Context, req *connect.Request[ingestv1.SelectExemplarsRequest], blockGetter BlockGetter) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectExemplars")
+	defer sp.Finish()
+
+	request := req.Msg.Request
+	if request == nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("missing select request"))
+	}
+	queriers, err := blockGetter(ctx, model.Time(request.Start), model.Time(request.End))
+	if err != nil {
+		return nil, err
+	}
+	iters, err := SelectMatchingProfiles(ctx, request, queriers)
+	if err != nil {
+		return nil, err
+	}
+	selectedProfiles := make([][]Profile, len(iters))
+	for i, it := range iters {
+		if selectedProfiles[i], err = iter.Slice(it); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		m         sync.Mutex
+		exemplars []*typesv1.Exemplar
+	)
+	g, ctx := errgroup.WithContext(ctx)
+	for i, querier := range queriers {
+		i := i
+		querier := querier
+		if len(selectedProfiles[i]) == 0 {
+			continue
+		}
+		g.Go(util.RecoverPanic(func() error {
+			selected, err := querier.SelectExemplars(ctx, iter.NewSliceIterator(querier.Sort(selectedProfiles[i])))
+			if err != nil {
+				return err
+			}
+			m.Lock()
+			defer m.Unlock()
+			for _, e := range selected {
+				if exemplarInRange(e, req.Msg.MinValue, req.Msg.MaxValue) {
+					exemplars = append(exemplars, e)
+				}
+			}
+			return nil
+		}))
+	}
+	if err = g.Wait(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&ingestv1.SelectExemplarsResponse{Exemplars: exemplars}), nil
+}
+
+// SelectExemplars lists the exemplars of the profiles of the head and the
+// local blocks that match the request.
+func (f *PhlareDB) SelectExemplars(ctx context.Context, req *connect.Request[ingestv1.SelectExemplarsRequest]) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+	f.headLock.RLock()
+	defer f.headLock.RUnlock()
+	return SelectExemplars(ctx, req, f.queriers().ForTimeRange)
+}
+
+// exemplarInRange reports whether the value of the exemplar is within
+// [min, max]; a zero bound is ignored.
+func exemplarInRange(e *typesv1.Exemplar, min, max int64) bool {
+	return (min == 0 || e.Value >= min) && (max == 0 || e.Value <= max)
+}
+
+func (b *singleBlockQuerier) SelectExemplars(ctx context.Context, rows iter.Iterator[Profile]) ([]*typesv1.Exemplar, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectExemplars - Block")
+	defer sp.Finish()
+	return selectExemplars(ctx, b.profiles.file, rows)
+}
+
+func (q *headOnDiskQuerier) SelectExemplars(ctx context.Context, rows iter.Iterator[Profile]) ([]*typesv1.Exemplar, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectExemplars - HeadOnDisk")
+	defer sp.Finish()
+	return selectExemplars(ctx, q.rowGroup(), rows)
+}
+
+func (q *headInMemoryQuerier) SelectExemplars(ctx context.Context, rows iter.Iterator[Profile]) ([]*typesv1.Exemplar, error) {
+	sp, _ := opentracing.StartSpanFromContext(ctx, "SelectExemplars - HeadInMemory")
+	defer sp.Finish()
+	var exemplars []*typesv1.Exemplar
+	values := make(map[uint64]int64)
+	for rows.Next() {
+		p, ok := rows.At().(ProfileWithLabels)
+		if !ok {
+			return nil, errors.New("expected ProfileWithLabels")
+		}
+		samples := p.Samples()
+		for i, span := range samples.Spans {
+			if span != 0 {
+				values[span] += int64(samples.Values[i])
+			}
+		}
+		exemplars = appendExemplars(exemplars, p, values)
+	}
+	return exemplars, rows.Err()
+}
+
+func selectExemplars(ctx context.Context, profileSource Source, rows iter.Iterator[Profile]) ([]*typesv1.Exemplar, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "selectExemplars")
+	defer sp.Finish()
+	var (
+		exemplars   []*typesv1.Exemplar
+		sampleSpans []uint64
+		values      = make(map[uint64]int64)
+	)
+	err := forEachProfileSamples(ctx, profileSource, rows, []string{schemav1.SpanIDColumnPath}, func(row Profile, v [][]parquet.Value) {
+		sampleSpans = schemav1.SampleSpanIDs(sampleSpans[:0], v[2])
+		if len(sampleSpans) != len(v[0]) {
+			return
+		}
+		for i, span := range sampleSpans {
+			if span != 0 {
+				values[span] += v[1][i].Int64()
+			}
+		}
+		exemplars = appendExemplars(exemplars, row, values)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return exemplars, nil
+}
+
+// appendExemplars appends an exemplar for each of the spans of the
+// profile, given the sum of their sample values, and resets the values.
+func appendExemplars(exemplars []*typesv1.Exemplar, p Profile, values map[uint64]int64) []*typesv1.Exemplar {
+	s := scale(p)
+	for span, v := range values {
+		exemplars = append(exemplars, &typesv1.Exemplar{
+			ProfileId: phlaremodel.FormatSpanID(span),
+			Timestamp: int64(p.Timestamp()),
+			Value:     v * s,
+			Labels:    p.Labels(),
+		})
+		delete(values, span)
+	}
+	return exemplars
+}
@@ -0,0 +1,94 @@
+package phlaredb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	pprofth "github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func TestSelectExemplars(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{
+		DataPath:         contextDataDir(ctx),
+		MaxBlockDuration: time.Duration(100000) * time.Minute, // we will manually flush
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		p := pprofth.NewProfileBuilder(int64(15*time.Second)).
+			CPUProfile().WithLabels("series", fmt.Sprintf("%d", i))
+		p.ForStacktraceString("my", "other").AddSamples(1)
+		p.ForStacktraceString("my", "other").AddSpanSamples("00000000000000aa", 2)
+		p.ForStacktraceString("my", "other", "stack").AddSpanSamples("00000000000000bb", int64(3+i))
+		p.ForStacktraceString("my", "other", "stack").AddSpanSamples("00000000000000aa", 4)
+		require.NoError(t, db.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+	}
+
+	selectExemplars := func(t *testing.T, blockGetter BlockGetter, minValue, maxValue int64) []string {
+		t.Helper()
+		resp, err := SelectExemplars(ctx, connect.NewRequest(&ingestv1.SelectExemplarsRequest{
+			Request: &ingestv1.SelectProfilesRequest{
+				LabelSelector: `{}`,
+				Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+				Start:         int64(model.TimeFromUnixNano(0)),
+				End:           int64(model.TimeFromUnixNano(int64(1 * time.Minute))),
+			},
+			MinValue: minValue,
+			MaxValue: maxValue,
+		}), blockGetter)
+		require.NoError(t, err)
+		exemplars := make([]string, 0, len(resp.Msg.Exemplars))
+		for _, e := range resp.Msg.Exemplars {
+			require.Equal(t, int64(15_000), e.Timestamp)
+			exemplars = append(exemplars, fmt.Sprintf("%s %d %s", e.ProfileId, e.Value, phlaremodel.Labels(e.Labels).Get("series")))
+		}
+		return exemplars
+	}
+
+	expected := []string{
+		"00000000000000aa 6 2",
+		"00000000000000aa 6 1",
+		"00000000000000aa 6 0",
+		"00000000000000bb 5 2",
+		"00000000000000bb 4 1",
+		"00000000000000bb 3 0",
+	}
+
+	t.Run("head", func(t *testing.T) {
+		require.ElementsMatch(t, expected, selectExemplars(t, db.head.Queriers().ForTimeRange, 0, 0))
+		require.ElementsMatch(t, expected[3:5], selectExemplars(t, db.head.Queriers().ForTimeRange, 4, 5))
+	})
+
+	require.NoError(t, db.Flush(context.Background()))
+	b, err := filesystem.NewBucket(filepath.Join(contextDataDir(ctx), pathLocal))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(context.Background()))
+
+	t.Run("block", func(t *testing.T) {
+		require.ElementsMatch(t, expected, selectExemplars(t, q.Queriers().ForTimeRange, 0, 0))
+		require.ElementsMatch(t, expected[:3], selectExemplars(t, q.Queriers().ForTimeRange, 6, 0))
+	})
+}
+
+func TestAppendExemplars(t *testing.T) {
+	lbs := phlaremodel.LabelsFromStrings("series", "a")
+	values := map[uint64]int64{0xaa: 3}
+	// The values are scaled by the period of the profiles selected with
+	// scale_by_period.
+	exemplars := appendExemplars(nil, BlockProfile{labels: lbs, ts: 1000, period: 10}, values)
+	require.Empty(t, values)
+	require.Equal(t, []*typesv1.Exemplar{{ProfileId: "00000000000000aa", Timestamp: 1000, Value: 30, Labels: lbs}}, exemplars)
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -69,6 +70,8 @@ type Head struct {
 	totalSamples  *atomic.Uint64
 	tables        []Table
 	delta         *deltaProfiles
+	// Store the sample types of a profile in a single row.
+	multiValueProfiles bool
 
 	limiter TenantLimiter
 }
@@ -91,8 +94,9 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 		meta:         block.NewMeta(),
 		totalSamples: atomic.NewUint64(0),
 
-		parquetConfig: &parquetConfig,
-		limiter:       limiter,
+		parquetConfig:      &parquetConfig,
+		multiValueProfiles: cfg.MultiValueProfiles,
+		limiter:            limiter,
 	}
 	h.headPath = filepath.Join(cfg.DataPath, pathHead, h.meta.ULID.String())
 	h.localPath = filepath.Join(cfg.DataPath, pathLocal, h.meta.ULID.String())
@@ -111,7 +115,7 @@ func NewHead(phlarectx context.Context, cfg Config, limiter TenantLimiter) (*Hea
 
 	// create profile store
 	h.profiles = newProfileStore(phlarectx)
-	h.delta = newDeltaProfiles()
+	h.delta = newDeltaProfiles(cfg)
 	h.tables = []Table{
 		h.profiles,
 	}
@@ -164,8 +168,17 @@ func (h *Head) loop() {
 func (h *Head) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, externalLabels ...*typesv1.LabelPair) error {
 	labels, seriesFingerprints := labelsForProfile(p, externalLabels...)
 
-	for i, fp := range seriesFingerprints {
-		if err := h.limiter.AllowProfile(fp, labels[i], p.TimeNanos); err != nil {
+	// A multi-value profile is stored in a single series,
+	// the series of its first sample type.
+	multiValue := h.multiValueProfiles && len(labels) > 1
+	seriesLabels, seriesRefs := labels, seriesFingerprints
+	if multiValue {
+		lbs := multiValueSeriesLabels(labels)
+		seriesLabels, seriesRefs = []phlaremodel.Labels{lbs}, []model.Fingerprint{model.Fingerprint(lbs.Hash())}
+	}
+
+	for i, fp := range seriesRefs {
+		if err := h.limiter.AllowProfile(fp, seriesLabels[i], p.TimeNanos); err != nil {
 			return err
 		}
 	}
@@ -175,19 +188,28 @@ func (h *Head) Ingest(ctx context.Context, p *profilev1.Profile, id uuid.UUID, e
 
 	metricName := phlaremodel.Labels(externalLabels).Get(model.MetricNameLabel)
 
+	h.delta.computeDelta(p, labels, seriesFingerprints)
+
+	var profiles []schemav1.InMemoryProfile
+	if multiValue {
+		profiles = []schemav1.InMemoryProfile{h.symdb.WriteMultiValueProfileSymbols(partition, p)}
+	} else {
+		profiles = h.symdb.WriteProfileSymbols(partition, p)
+	}
+
 	var profileIngested bool
-	for idxType, profile := range h.symdb.WriteProfileSymbols(partition, p) {
+	for idxType, profile := range profiles {
 		profile.ID = id
-		profile.SeriesFingerprint = seriesFingerprints[idxType]
-		profile.Samples = h.delta.computeDelta(profile, labels[idxType])
+		profile.SeriesFingerprint = seriesRefs[idxType]
 		profile.TotalValue = profile.Samples.Sum()
+		profile.AdditionalTotalValues = profile.Samples.AdditionalSums()
 
 		if profile.Samples.Len() == 0 {
 			level.Debug(h.logger).Log("msg", "profile is empty after delta computation", "metricName", metricName)
 			continue
 		}
 
-		if err := h.profiles.ingest(ctx, []schemav1.InMemoryProfile{profile}, labels[idxType], metricName); err != nil {
+		if err := h.profiles.ingest(ctx, []schemav1.InMemoryProfile{profile}, seriesLabels[idxType], metricName); err != nil {
 			return err
 		}
 
@@ -221,8 +243,9 @@ func (h *Head) LabelValues(ctx context.Context, req *connect.Request[typesv1.Lab
 		return nil, err
 	}
 
-	// shortcut to index when matcher match all
-	if selectors.matchesAll() {
+	// shortcut to index when matcher match all, unless the label
+	// differs in the profile types of multi-value profile series
+	if selectors.matchesAll() && !isProfileTypeLabel(req.Msg.Name) {
 		values, err := h.profiles.index.ix.LabelValues(req.Msg.Name, nil)
 		if err != nil {
 			return nil, err
@@ -262,6 +285,7 @@ func (h *Head) LabelNames(ctx context.Context, req *connect.Request[typesv1.Labe
 		if err != nil {
 			return nil, err
 		}
+		values = lo.Without(values, phlaremodel.LabelNameAdditionalProfileTypes)
 		sort.Strings(values)
 		return connect.NewResponse(&typesv1.LabelNamesResponse{
 			Names: values,
@@ -290,6 +314,16 @@ func (h *Head) ProfileTypes(ctx context.Context, req *connect.Request[ingestv1.P
 	if err != nil {
 		return nil, err
 	}
+	// The additional profile types of multi-value profiles
+	// are not stored in series of their own.
+	additional, err := h.profiles.index.ix.LabelValues(phlaremodel.LabelNameAdditionalProfileTypes, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range additional {
+		values = append(values, strings.Split(v, ",")...)
+	}
+	values = lo.Uniq(values)
 	sort.Strings(values)
 
 	profileTypes := make([]*typesv1.ProfileType, len(values))
@@ -521,6 +555,15 @@ func (h *Head) flush(ctx context.Context) error {
 		files = append(files, f)
 	}
 
+	// span index
+	f, err := writeSpanIndex(filepath.Join(h.headPath, h.profiles.Name()+block.ParquetSuffix), h.headPath)
+	if err != nil {
+		return errors.Wrap(err, "writing span index")
+	}
+	blockSize += f.SizeBytes
+	h.metrics.flushedFileSizeBytes.WithLabelValues("spans").Observe(float64(f.SizeBytes))
+	files = append(files, f)
+
 	// symdb
 	if err := h.symdb.Flush(); err != nil {
 		return errors.Wrap(err, "flushing symdb")
@@ -535,7 +578,7 @@ func (h *Head) flush(ctx context.Context) error {
 
 	// tsdb
 	h.meta.Stats.NumSeries = uint64(h.profiles.index.totalSeries.Load())
-	f := block.File{
+	f = block.File{
 		RelPath: block.IndexFilename,
 		TSDB: &block.TSDBFile{
 			NumSeries: h.meta.Stats.NumSeries,
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sort"
 
+	"github.com/bufbuild/connect-go"
 	"github.com/go-kit/log/level"
 	"github.com/google/pprof/profile"
 	"github.com/opentracing/opentracing-go"
@@ -40,7 +41,7 @@ func (q *headOnDiskQuerier) SelectMatchingProfiles(ctx context.Context, params *
 	defer sp.Finish()
 
 	// query the index for rows
-	rowIter, labelsPerFP, err := q.head.profiles.index.selectMatchingRowRanges(ctx, params, q.rowGroupIdx)
+	rowIter, seriesPerRef, err := q.head.profiles.index.selectMatchingRowRanges(ctx, params, q.rowGroupIdx)
 	if err != nil {
 		return nil, err
 	}
@@ -58,11 +59,14 @@ func (q *headOnDiskQuerier) SelectMatchingProfiles(ctx context.Context, params *
 		),
 		q.rowGroup().columnIter(ctx, "StacktracePartition", nil, "StacktracePartition"),
 	)
+	if params.ScaleByPeriod {
+		pIt = query.NewBinaryJoinIterator(0, pIt, q.rowGroup().columnIter(ctx, "Period", nil, "Period"))
+	}
 	defer pIt.Close()
 
 	var (
 		profiles []Profile
-		buf      = make([][]parquet.Value, 2)
+		buf      = make([][]parquet.Value, 3)
 	)
 	for pIt.Next() {
 		res := pIt.At()
@@ -72,21 +76,27 @@ func (q *headOnDiskQuerier) SelectMatchingProfiles(ctx context.Context, params *
 			panic("no fingerprint information found")
 		}
 
-		lbls, ok := labelsPerFP[v.fp]
+		series, ok := seriesPerRef[v.fp]
 		if !ok {
 			panic("no profile series labels with matching fingerprint found")
 		}
 
-		buf = res.Columns(buf, "TimeNanos", "StacktracePartition")
+		buf = res.Columns(buf, "TimeNanos", "StacktracePartition", "Period")
 		if len(buf) < 1 || len(buf[0]) != 1 {
 			level.Error(q.head.logger).Log("msg", "unable to read timeNanos from profiles", "row", res.RowNumber[0], "rowGroup", q.rowGroupIdx)
 			continue
 		}
+		period := retrievePeriod(buf, 2)
+		if params.ScaleByPeriod && period == 0 {
+			return nil, connect.NewError(connect.CodeInvalidArgument, errUnknownPeriod)
+		}
 		profiles = append(profiles, BlockProfile{
-			labels:              lbls,
-			fp:                  v.fp,
+			labels:              series.lbs,
+			fp:                  series.fp,
 			ts:                  model.TimeFromUnixNano(buf[0][0].Int64()),
 			stacktracePartition: retrieveStacktracePartition(buf, 1),
+			period:              period,
+			valueIndex:          series.valueIndex,
 			RowNum:              res.RowNumber[0],
 		})
 	}
@@ -118,6 +128,23 @@ func (q *headOnDiskQuerier) MergeByStacktraces(ctx context.Context, rows iter.It
 	return r.Tree()
 }
 
+func (q *headOnDiskQuerier) MergeByStacktracesMultiValue(ctx context.Context, rows ...iter.Iterator[Profile]) ([]*phlaremodel.Tree, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeByStacktracesMultiValue")
+	defer sp.Finish()
+	return mergeByStacktracesMultiValue(ctx, q.rowGroup(), q.head.symdb, rows)
+}
+
+func (q *headOnDiskQuerier) MergeBySpans(ctx context.Context, rows iter.Iterator[Profile], spans phlaremodel.SpanSelector) (*phlaremodel.Tree, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeBySpans")
+	defer sp.Finish()
+	r := symdb.NewResolver(ctx, q.head.symdb)
+	defer r.Release()
+	if err := mergeBySpans(ctx, q.rowGroup(), rows, r, spans); err != nil {
+		return nil, err
+	}
+	return r.Tree()
+}
+
 func (q *headOnDiskQuerier) MergePprof(ctx context.Context, rows iter.Iterator[Profile]) (*profile.Profile, error) {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergePprof")
 	defer sp.Finish()
@@ -170,7 +197,7 @@ func (q *headInMemoryQuerier) SelectMatchingProfiles(ctx context.Context, params
 
 	index := q.head.profiles.index
 
-	ids, err := index.selectMatchingFPs(ctx, params)
+	selected, err := index.selectMatchingSeries(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -181,26 +208,34 @@ func (q *headInMemoryQuerier) SelectMatchingProfiles(ctx context.Context, params
 		end   = model.Time(params.End)
 	)
 
-	iters := make([]iter.Iterator[Profile], 0, len(ids))
+	iters := make([]iter.Iterator[Profile], 0, len(selected))
 	index.mutex.RLock()
 	defer index.mutex.RUnlock()
 
-	for _, fp := range ids {
-		profileSeries, ok := index.profilesPerFP[fp]
+	for _, s := range selected {
+		profileSeries, ok := index.profilesPerFP[s.ref]
 		if !ok {
 			continue
 		}
 
 		profiles := make([]*schemav1.InMemoryProfile, len(profileSeries.profiles))
 		copy(profiles, profileSeries.profiles)
+		if params.ScaleByPeriod {
+			for _, p := range profiles {
+				if p.Period == 0 && p.Timestamp() >= start && p.Timestamp() <= end {
+					return nil, connect.NewError(connect.CodeInvalidArgument, errUnknownPeriod)
+				}
+			}
+		}
 
-		iters = append(iters,
-			NewSeriesIterator(
-				profileSeries.lbs,
-				profileSeries.fp,
-				iter.NewTimeRangedIterator(iter.NewSliceIterator(profiles), start, end),
-			),
+		it := NewSeriesIterator(
+			s.lbs,
+			s.fp,
+			iter.NewTimeRangedIterator(iter.NewSliceIterator(profiles), start, end),
 		)
+		it.valueIndex = s.valueIndex
+		it.scaleByPeriod = params.ScaleByPeriod
+		iters = append(iters, it)
 	}
 
 	return iter.NewMergeIterator(maxBlockProfile, false, iters...), nil
@@ -221,7 +256,48 @@ func (q *headInMemoryQuerier) MergeByStacktraces(ctx context.Context, rows iter.
 		if !ok {
 			return nil, errors.New("expected ProfileWithLabels")
 		}
-		r.AddSamples(p.StacktracePartition(), p.Samples())
+		addSamples(r, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return r.Tree()
+}
+
+// MergeByStacktracesMultiValue merges the selections one after another:
+// the samples of in-memory profiles are not read from the profile rows.
+func (q *headInMemoryQuerier) MergeByStacktracesMultiValue(ctx context.Context, rows ...iter.Iterator[Profile]) ([]*phlaremodel.Tree, error) {
+	trees := make([]*phlaremodel.Tree, len(rows))
+	for i, it := range rows {
+		t, err := q.MergeByStacktraces(ctx, it)
+		if err != nil {
+			return nil, err
+		}
+		trees[i] = t
+	}
+	return trees, nil
+}
+
+func (q *headInMemoryQuerier) MergeBySpans(ctx context.Context, rows iter.Iterator[Profile], spans phlaremodel.SpanSelector) (*phlaremodel.Tree, error) {
+	sp, _ := opentracing.StartSpanFromContext(ctx, "MergeBySpans - HeadInMemory")
+	defer sp.Finish()
+	r := symdb.NewResolver(ctx, q.head.symdb)
+	defer r.Release()
+	for rows.Next() {
+		p, ok := rows.At().(ProfileWithLabels)
+		if !ok {
+			return nil, errors.New("expected ProfileWithLabels")
+		}
+		samples := p.Samples()
+		if samples.Spans == nil {
+			continue
+		}
+		partition := r.Partition(p.StacktracePartition())
+		for i, span := range samples.Spans {
+			if _, ok = spans[span]; ok {
+				partition[samples.StacktraceIDs[i]] += int64(samples.Values[i]) * scale(p)
+			}
+		}
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -239,7 +315,7 @@ func (q *headInMemoryQuerier) MergePprof(ctx context.Context, rows iter.Iterator
 		if !ok {
 			return nil, errors.New("expected ProfileWithLabels")
 		}
-		r.AddSamples(p.StacktracePartition(), p.Samples())
+		addSamples(r, p)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -272,7 +348,7 @@ func (q *headInMemoryQuerier) MergeByLabels(ctx context.Context, rows iter.Itera
 					Points: []*typesv1.Point{
 						{
 							Timestamp: int64(p.Timestamp()),
-							Value:     float64(p.Total()),
+							Value:     float64(p.Total() * scale(p)),
 						},
 					},
 				}
@@ -282,7 +358,7 @@ func (q *headInMemoryQuerier) MergeByLabels(ctx context.Context, rows iter.Itera
 		series := seriesByLabels[labelsByString]
 		series.Points = append(series.Points, &typesv1.Point{
 			Timestamp: int64(p.Timestamp()),
-			Value:     float64(p.Total()),
+			Value:     float64(p.Total() * scale(p)),
 		})
 
 	}
@@ -296,3 +372,15 @@ func (q *headInMemoryQuerier) MergeByLabels(ctx context.Context, rows iter.Itera
 func (q *headInMemoryQuerier) Sort(in []Profile) []Profile {
 	return in
 }
+
+func addSamples(r *symdb.Resolver, p ProfileWithLabels) {
+	s := p.Samples()
+	if p.period == 0 {
+		r.AddSamples(p.StacktracePartition(), s)
+		return
+	}
+	partition := r.Partition(p.StacktracePartition())
+	for i, sid := range s.StacktraceIDs {
+		partition[sid] += int64(s.Values[i]) * p.period
+	}
+}
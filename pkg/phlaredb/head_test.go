@@ -24,6 +24,9 @@ import (
 	phlarecontext "github.com/grafana/pyroscope/pkg/phlare/context"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/pprof/testhelper"
+	"github.com/grafana/pyroscope/pkg/util/limiter"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 type noLimit struct{}
@@ -237,6 +240,158 @@ func TestHeadSeries(t *testing.T) {
 	require.Contains(t, res.Msg.LabelsSet, &typesv1.Labels{Labels: jobBar})
 }
 
+func TestHeadLabelCardinality(t *testing.T) {
+	head := newTestHead(t)
+	ctx := context.Background()
+	require.NoError(t, head.Ingest(ctx, newProfileFoo(), uuid.New(), &typesv1.LabelPair{Name: "job", Value: "foo"}, &typesv1.LabelPair{Name: "namespace", Value: "phlare"}))
+	require.NoError(t, head.Ingest(ctx, newProfileBar(), uuid.New(), &typesv1.LabelPair{Name: "job", Value: "bar"}, &typesv1.LabelPair{Name: "namespace", Value: "phlare"}))
+
+	sels, err := parseSelectors([]string{`{namespace="phlare"}`})
+	require.NoError(t, err)
+	headStats := make(seriesStatsCollector)
+	require.NoError(t, head.SeriesStats(ctx, sels, 0, model.Latest, headStats.add))
+	res := headStats.labelCardinality(&typesv1.LabelCardinalityRequest{Start: 0, End: 1, Limit: 1})
+
+	labels := make(map[string]*typesv1.LabelCardinality)
+	for _, l := range res.Labels {
+		labels[l.Name] = l
+	}
+	require.Equal(t, int64(2), labels["job"].ValuesCount)
+	require.Equal(t, int64(2), labels["job"].SeriesCount)
+	require.Len(t, labels["job"].TopValuesBySeries, 1)
+	require.Greater(t, labels["job"].Bytes, int64(0))
+	require.Equal(t, int64(1), labels["namespace"].ValuesCount)
+	require.Equal(t, int64(2), labels["namespace"].SeriesCount)
+	require.Equal(t, labels["job"].Bytes, labels["namespace"].Bytes)
+
+	// No series within the time range.
+	c := make(seriesStatsCollector)
+	require.NoError(t, head.SeriesStats(ctx, sels, model.Now(), model.Latest, c.add))
+	require.Len(t, c, 0)
+
+	// The series bytes are kept in the block index, rounded to KB.
+	require.NoError(t, head.Flush(ctx))
+	require.NoError(t, head.Move())
+	b, err := filesystem.NewBucket(filepath.Dir(head.localPath))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(ctx))
+	queriers := q.Queriers()
+	require.Len(t, queriers, 1)
+	c = make(seriesStatsCollector)
+	require.NoError(t, queriers[0].(SeriesStatsReader).SeriesStats(ctx, nil, 0, model.Latest, c.add))
+	require.Len(t, c, 2)
+	for fp, s := range c {
+		require.Equal(t, headStats[fp].FirstSeen, s.FirstSeen)
+		require.Equal(t, (headStats[fp].Bytes+512)/1024*1024, s.Bytes)
+	}
+}
+
+func TestHeadSelectProfilesMetadata(t *testing.T) {
+	head := newTestHead(t)
+	// force profiles to be written to row groups on disk
+	head.profiles.cfg = &ParquetConfig{MaxRowGroupBytes: 128000, MaxBufferRowCount: 2}
+	ctx := context.Background()
+	ids := make([]uuid.UUID, 5)
+	for i := range ids {
+		b := testhelper.NewProfileBuilder(int64(i+1) * 1e9).CPUProfile()
+		b.DurationNanos = int64(i) * 5e9
+		b.Period = 1e7
+		if i == 4 {
+			b.Period = 1e6
+		}
+		b.ForStacktraceString("a", "b").AddSamples(int64(i + 1))
+		ids[i] = b.UUID
+		require.NoError(t, head.Ingest(ctx, b.Profile, b.UUID, b.Labels...))
+		// wait for the row group to be flushed
+		for head.profiles.flushing.Load() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	selectProfiles := func(queriers Queriers, predicates ...string) []string {
+		res, err := SelectProfilesMetadata(ctx, connect.NewRequest(&typesv1.SelectProfilesMetadataRequest{
+			ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+			LabelSelector: `{job="foo"}`,
+			Predicates:    predicates,
+			Start:         0,
+			End:           10000,
+		}), queriers.ForTimeRange)
+		require.NoError(t, err)
+		result := make([]string, len(res.Msg.Profiles))
+		for i, p := range res.Msg.Profiles {
+			result[i] = p.ID
+		}
+		return result
+	}
+
+	assertProfiles := func(queriers Queriers) {
+		require.Equal(t, []string{ids[4].String(), ids[3].String(), ids[2].String()}, selectProfiles(queriers, "duration > 5s"))
+		require.Equal(t, []string{ids[3].String(), ids[2].String()}, selectProfiles(queriers, "duration > 5s", "period = 10ms"))
+		require.Equal(t, []string{ids[4].String()}, selectProfiles(queriers, "period != 10000000"))
+		require.Equal(t, []string{ids[1].String(), ids[0].String()}, selectProfiles(queriers, "total_value <= 2"))
+		require.Len(t, selectProfiles(queriers), 5)
+	}
+	require.Greater(t, len(head.Queriers()), 1)
+	assertProfiles(head.Queriers())
+
+	res, err := SelectProfilesMetadata(ctx, connect.NewRequest(&typesv1.SelectProfilesMetadataRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{}`,
+		Start:         0,
+		End:           10000,
+		Limit:         1,
+	}), head.Queriers().ForTimeRange)
+	require.NoError(t, err)
+	require.Equal(t, []*typesv1.ProfileMetadata{{
+		ID:            ids[4].String(),
+		Timestamp:     5000,
+		Labels:        res.Msg.Profiles[0].Labels,
+		TotalValue:    5,
+		DurationNanos: 20e9,
+		Period:        1e6,
+	}}, res.Msg.Profiles)
+	require.Equal(t, "foo", phlaremodel.Labels(res.Msg.Profiles[0].Labels).Get("job"))
+
+	// Each querier only returns its most recent profiles.
+	params := &ingestv1.SelectProfilesRequest{
+		LabelSelector: `{}`,
+		Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+		Start:         0,
+		End:           10000,
+	}
+	for _, q := range head.Queriers() {
+		all, err := q.(ProfilesMetadataSelector).SelectProfilesMetadata(ctx, params, nil, 0)
+		require.NoError(t, err)
+		top, err := q.(ProfilesMetadataSelector).SelectProfilesMetadata(ctx, params, nil, 1)
+		require.NoError(t, err)
+		if len(all) == 0 {
+			require.Empty(t, top)
+			continue
+		}
+		phlaremodel.SortProfilesMetadata(all)
+		require.Equal(t, all[:1], top)
+	}
+
+	limited := limiter.AddQueryLimiterToContext(ctx, limiter.NewQueryLimiter("tenant", validation.MockLimits{MaxQueryProfilesValue: 1}))
+	_, err = SelectProfilesMetadata(limited, connect.NewRequest(&typesv1.SelectProfilesMetadataRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{}`,
+		Start:         0,
+		End:           10000,
+	}), head.Queriers().ForTimeRange)
+	require.Error(t, err)
+	require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+
+	require.NoError(t, head.Flush(ctx))
+	require.NoError(t, head.Move())
+	b, err := filesystem.NewBucket(filepath.Dir(head.localPath))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(ctx))
+	assertProfiles(q.Queriers())
+}
+
 func TestHeadProfileTypes(t *testing.T) {
 	head := newTestHead(t)
 	require.NoError(t, head.Ingest(context.Background(), newProfileFoo(), uuid.New(), &typesv1.LabelPair{Name: "__name__", Value: "foo"}, &typesv1.LabelPair{Name: "job", Value: "foo"}, &typesv1.LabelPair{Name: "namespace", Value: "phlare"}))
@@ -302,12 +457,16 @@ func TestHeadFlush(t *testing.T) {
 				},
 				{
 					RelPath:   "profiles.parquet",
-					SizeBytes: 40728,
+					SizeBytes: 41309,
 					Parquet: &block.ParquetFile{
 						NumRowGroups: 1,
 						NumRows:      11,
 					},
 				},
+				{
+					RelPath:   "spans.bloom",
+					SizeBytes: 25,
+				},
 				{
 					RelPath:   "symbols/functions.parquet",
 					SizeBytes: 57957,
@@ -467,3 +626,69 @@ func BenchmarkHeadIngestProfiles(t *testing.B) {
 		}
 	}
 }
+
+func TestHeadSelectMatchingProfilesSharded(t *testing.T) {
+	head := newTestHead(t)
+	ctx := context.Background()
+	const numSeries = 32
+	for i := 0; i < numSeries; i++ {
+		p := newProfileFoo()
+		p.TimeNanos = int64(i + 1)
+		require.NoError(t, head.Ingest(ctx, p, uuid.New(),
+			&typesv1.LabelPair{Name: "job", Value: fmt.Sprint(i)},
+			&typesv1.LabelPair{Name: "namespace", Value: "phlare"},
+		))
+	}
+
+	selectJobs := func(t *testing.T, queriers Queriers, selector string) []string {
+		it, err := queriers.SelectMatchingProfiles(ctx, &ingestv1.SelectProfilesRequest{
+			LabelSelector: selector,
+			Type:          mustParseProfileSelector(t, ":type:unit:type:unit"),
+			Start:         0,
+			End:           1000,
+		})
+		require.NoError(t, err)
+		var jobs []string
+		for it.Next() {
+			jobs = append(jobs, it.At().Labels().Get("job"))
+		}
+		require.NoError(t, it.Err())
+		return jobs
+	}
+
+	assertSharded := func(t *testing.T, queriers Queriers) {
+		all := selectJobs(t, queriers, `{namespace="phlare"}`)
+		require.Len(t, all, numSeries)
+		for _, shards := range []int{2, 4, 64} {
+			var sharded []string
+			for i := 0; i < shards; i++ {
+				jobs := selectJobs(t, queriers, fmt.Sprintf(`{namespace="phlare",__cortex_shard__="%d_of_%d"}`, i, shards))
+				if shards < numSeries {
+					require.NotEmpty(t, jobs)
+				}
+				sharded = append(sharded, jobs...)
+			}
+			require.ElementsMatch(t, all, sharded)
+		}
+		_, err := queriers.SelectMatchingProfiles(ctx, &ingestv1.SelectProfilesRequest{
+			LabelSelector: `{namespace="phlare",__cortex_shard__="0_of_3"}`,
+			Type:          mustParseProfileSelector(t, ":type:unit:type:unit"),
+			End:           1000,
+		})
+		require.Error(t, err)
+	}
+
+	t.Run("head", func(t *testing.T) {
+		assertSharded(t, head.Queriers())
+	})
+
+	t.Run("block", func(t *testing.T) {
+		require.NoError(t, head.Flush(ctx))
+		require.NoError(t, head.Move())
+		b, err := filesystem.NewBucket(filepath.Dir(head.localPath))
+		require.NoError(t, err)
+		q := NewBlockQuerier(ctx, b)
+		require.NoError(t, q.Sync(ctx))
+		assertSharded(t, q.Queriers())
+	})
+}
@@ -2,9 +2,12 @@ package phlaredb
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/samber/lo"
 
 	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
@@ -63,3 +66,114 @@ func labelsForProfile(p *profilev1.Profile, externalLabels ...*typesv1.LabelPair
 	}
 	return profilesLabels, seriesRefs
 }
+
+// multiValueSeriesLabels returns the labels of the series of a
+// multi-value profile: the labels of its first profile type, with the
+// IDs of the other profile types in the additional profile types label.
+func multiValueSeriesLabels(profilesLabels []phlaremodel.Labels) phlaremodel.Labels {
+	ids := make([]string, len(profilesLabels)-1)
+	for i, lbs := range profilesLabels[1:] {
+		ids[i] = lbs.Get(phlaremodel.LabelNameProfileType)
+	}
+	return phlaremodel.NewLabelsBuilder(profilesLabels[0]).
+		Set(phlaremodel.LabelNameAdditionalProfileTypes, strings.Join(ids, ",")).
+		Labels()
+}
+
+// additionalProfileTypes returns the IDs of the additional profile
+// types of a multi-value profile series, and nil for other series.
+func additionalProfileTypes(lbs phlaremodel.Labels) []string {
+	v := lbs.Get(phlaremodel.LabelNameAdditionalProfileTypes)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// profileTypeSeriesLabels returns the labels of the profile type at the
+// value index of a multi-value profile series: the labels the series
+// would have, if the profile type was stored in a series of its own.
+func profileTypeSeriesLabels(lbs phlaremodel.Labels, valueIndex int) (phlaremodel.Labels, error) {
+	b := phlaremodel.NewLabelsBuilder(lbs).Del(phlaremodel.LabelNameAdditionalProfileTypes)
+	if valueIndex == 0 {
+		return b.Labels(), nil
+	}
+	ids := additionalProfileTypes(lbs)
+	if valueIndex > len(ids) {
+		return nil, fmt.Errorf("series %s has no profile type at value index %d", phlaremodel.LabelPairsString(lbs), valueIndex)
+	}
+	t, err := phlaremodel.ParseProfileTypeSelector(ids[valueIndex-1])
+	if err != nil {
+		return nil, err
+	}
+	return b.Set(phlaremodel.LabelNameProfileType, t.ID).
+		Set(phlaremodel.LabelNameType, t.SampleType).
+		Set(phlaremodel.LabelNameUnit, t.SampleUnit).
+		Labels(), nil
+}
+
+// multiValueSelector selects a profile type in the multi-value profile
+// series holding it as an additional profile type.
+type multiValueSelector struct {
+	profileTypeID string
+	// matchers select the multi-value profile series.
+	matchers []*labels.Matcher
+	// filters apply to the labels of the profile type.
+	filters []*labels.Matcher
+}
+
+// newMultiValueSelector returns the selector of the profile type in the
+// multi-value profile series matching the matchers. The profile type
+// matchers apply to the labels of the selected profile type.
+func newMultiValueSelector(matchers []*labels.Matcher, profileTypeID string) *multiValueSelector {
+	s := &multiValueSelector{profileTypeID: profileTypeID}
+	s.matchers, s.filters = splitProfileTypeMatchers(matchers)
+	s.matchers = append(s.matchers, labels.MustNewMatcher(labels.MatchRegexp,
+		phlaremodel.LabelNameAdditionalProfileTypes, "(.*,)?"+regexp.QuoteMeta(profileTypeID)+"(,.*)?"))
+	return s
+}
+
+// splitProfileTypeMatchers splits the matchers into the matchers of the
+// labels multi-value profile series share with their profile types, and
+// the matchers of the labels of the profile types.
+func splitProfileTypeMatchers(matchers []*labels.Matcher) (series, profileType []*labels.Matcher) {
+	for _, m := range matchers {
+		if isProfileTypeLabel(m.Name) {
+			profileType = append(profileType, m)
+		} else {
+			series = append(series, m)
+		}
+	}
+	return series, profileType
+}
+
+// isProfileTypeLabel reports whether the label differs in the profile
+// types of a multi-value profile series.
+func isProfileTypeLabel(name string) bool {
+	switch name {
+	case phlaremodel.LabelNameProfileType, phlaremodel.LabelNameType, phlaremodel.LabelNameUnit:
+		return true
+	}
+	return false
+}
+
+// series returns the labels of the selected profile type in the
+// multi-value profile series, and its value index. It returns false,
+// if the series doesn't hold the profile type or the filters don't
+// match its labels.
+func (s *multiValueSelector) series(lbs phlaremodel.Labels) (phlaremodel.Labels, int, bool) {
+	i := lo.IndexOf(additionalProfileTypes(lbs), s.profileTypeID)
+	if i < 0 {
+		return nil, 0, false
+	}
+	lbs, err := profileTypeSeriesLabels(lbs, i+1)
+	if err != nil {
+		return nil, 0, false
+	}
+	for _, f := range s.filters {
+		if !f.Matches(lbs.Get(f.Name)) {
+			return nil, 0, false
+		}
+	}
+	return lbs, i + 1, true
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/google/uuid"
+	"github.com/grafana/dskit/flagext"
 	"github.com/grafana/dskit/multierror"
 	"github.com/grafana/dskit/services"
 	"github.com/oklog/ulid"
@@ -41,6 +42,13 @@ type Config struct {
 	// TODO: docs
 	RowGroupTargetSize uint64 `yaml:"row_group_target_size"`
 
+	// Sample types whose values are converted to deltas on ingestion, in the form <profile name>:<sample type>.
+	CumulativeSampleTypes flagext.StringSliceCSV `yaml:"cumulative_sample_types,omitempty"`
+	// Delta state of series that are not seen for this long is evicted.
+	DeltaStateTTL time.Duration `yaml:"delta_state_ttl,omitempty"`
+	// Profiles with several sample types are stored in a single row holding the values of all of them.
+	MultiValueProfiles bool `yaml:"multi_value_profiles,omitempty" category:"experimental"`
+
 	Parquet *ParquetConfig `yaml:"-"` // Those configs should not be exposed to the user, rather they should be determined by pyroscope itself. Currently, they are solely used for test cases.
 }
 
@@ -54,6 +62,10 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.DataPath, "pyroscopedb.data-path", "./data", "Directory used for local storage.")
 	f.DurationVar(&cfg.MaxBlockDuration, "pyroscopedb.max-block-duration", 3*time.Hour, "Upper limit to the duration of a Pyroscope block.")
 	f.Uint64Var(&cfg.RowGroupTargetSize, "pyroscopedb.row-group-target-size", 10*128*1024*1024, "How big should a single row group be uncompressed") // This should roughly be 128MiB compressed
+	cfg.CumulativeSampleTypes = defaultCumulativeSampleTypes
+	f.Var(&cfg.CumulativeSampleTypes, "pyroscopedb.cumulative-sample-types", "Comma-separated list of sample types, in the form <profile name>:<sample type>, whose values are accumulated since the process start and are converted to deltas on ingestion. Clients may declare a profile cumulative with the __delta__=\"true\" label, and report the start of its counters with the profile duration to keep the first profile of the series.")
+	f.DurationVar(&cfg.DeltaStateTTL, "pyroscopedb.delta-state-ttl", time.Hour, "How long the last values of a cumulative series are kept after the series stops receiving profiles. 0 to keep them forever.")
+	f.BoolVar(&cfg.MultiValueProfiles, "pyroscopedb.multi-value-profiles", false, "Store the sample types of a profile in a single row, so that the profile types of a profile are merged reading the profile once. Blocks written with this option can only be read by versions supporting multi-value profiles.")
 }
 
 type TenantLimiter interface {
@@ -92,6 +104,9 @@ type PhlareDB struct {
 	blockQuerier *BlockQuerier
 	limiter      TenantLimiter
 	evictCh      chan *blockEviction
+	// The delta state is shared by the heads, so that
+	// cumulative series carry over to the next head.
+	delta *deltaProfiles
 }
 
 func New(phlarectx context.Context, cfg Config, limiter TenantLimiter, fs phlareobj.Bucket) (*PhlareDB, error) {
@@ -103,6 +118,7 @@ func New(phlarectx context.Context, cfg Config, limiter TenantLimiter, fs phlare
 		evictCh: make(chan *blockEviction),
 		metrics: newHeadMetrics(reg),
 		limiter: limiter,
+		delta:   newDeltaProfiles(cfg),
 	}
 
 	f.forceFlush = time.NewTicker(f.maxBlockDuration())
@@ -265,6 +281,7 @@ func (f *PhlareDB) initHead() (err error) {
 	if f.head, err = NewHead(f.phlarectx, f.cfg, f.limiter); err != nil {
 		return err
 	}
+	f.head.delta = f.delta
 	f.forceFlush.Reset(f.maxBlockDuration())
 	return nil
 }
@@ -26,7 +26,10 @@ import (
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	pprofth "github.com/grafana/pyroscope/pkg/pprof/testhelper"
 	"github.com/grafana/pyroscope/pkg/testhelper"
+	"github.com/grafana/pyroscope/pkg/util/limiter"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 func TestCreateLocalDir(t *testing.T) {
@@ -136,6 +139,18 @@ func (i *ingesterHandlerPhlareDB) Flush(context.Context, *connect.Request[ingest
 	return nil, errors.New("not implemented")
 }
 
+func (i *ingesterHandlerPhlareDB) LabelCardinality(context.Context, *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	return nil, errors.New("not implemented")
+}
+
+func (i *ingesterHandlerPhlareDB) SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	return SelectProfilesMetadata(ctx, req, i.ForTimeRange)
+}
+
+func (i *ingesterHandlerPhlareDB) SelectExemplars(ctx context.Context, req *connect.Request[ingestv1.SelectExemplarsRequest]) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+	return SelectExemplars(ctx, req, i.ForTimeRange)
+}
+
 func TestMergeProfilesStacktraces(t *testing.T) {
 	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
 
@@ -201,6 +216,11 @@ func TestMergeProfilesStacktraces(t *testing.T) {
 		at, err := phlaremodel.UnmarshalTree(resp.Result.TreeBytes)
 		require.NoError(t, err)
 		require.Equal(t, int64(500000000), at.Total())
+
+		require.NotNil(t, resp.Stats)
+		require.Equal(t, uint64(1), resp.Stats.MergedProfiles)
+		require.NotZero(t, resp.Stats.SeriesCount)
+		require.NotZero(t, resp.Stats.QueriedBlocks)
 	})
 
 	t.Run("request non existing series", func(t *testing.T) {
@@ -267,6 +287,68 @@ func TestMergeProfilesStacktraces(t *testing.T) {
 	})
 }
 
+func TestQueryLimits(t *testing.T) {
+	var (
+		ctx     = testContext(t)
+		testDir = contextDataDir(ctx)
+		end     = time.Unix(0, int64(time.Hour))
+		start   = end.Add(-time.Minute)
+		step    = 15 * time.Second
+	)
+
+	db, err := New(ctx, Config{
+		DataPath:         testDir,
+		MaxBlockDuration: time.Duration(100000) * time.Minute, // we will manually flush
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	for _, pod := range []string{"my-pod-1", "my-pod-2"} {
+		ingestProfiles(t, db, cpuProfileGenerator, start.UnixNano(), end.UnixNano(), step,
+			&typesv1.LabelPair{Name: "pod", Value: pod},
+		)
+	}
+
+	selectProfiles := func(limits validation.MockLimits, selector string) (int, error) {
+		ctx := limiter.AddQueryLimiterToContext(ctx, limiter.NewQueryLimiter("tenant", limits))
+		it, err := db.queriers().SelectMatchingProfiles(ctx, &ingestv1.SelectProfilesRequest{
+			LabelSelector: selector,
+			Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+			Start:         start.UnixMilli(),
+			End:           end.UnixMilli(),
+		})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Close()
+		var n int
+		for it.Next() {
+			n++
+		}
+		return n, it.Err()
+	}
+
+	total, err := selectProfiles(validation.MockLimits{MaxQuerySeriesValue: 1}, `{pod="my-pod-1"}`)
+	require.NoError(t, err)
+	_, err = selectProfiles(validation.MockLimits{MaxQuerySeriesValue: 1}, `{}`)
+	require.Error(t, err)
+	require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+	require.Equal(t, validation.QuerySeriesLimit, validation.ReasonOf(err))
+
+	// The profiles limit is enforced while iterating:
+	// the query stops before reading all the profiles.
+	n, err := selectProfiles(validation.MockLimits{MaxQueryProfilesValue: total}, `{pod="my-pod-1"}`)
+	require.NoError(t, err)
+	require.Equal(t, total, n)
+	n, err = selectProfiles(validation.MockLimits{MaxQueryProfilesValue: 2}, `{}`)
+	require.Error(t, err)
+	require.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+	require.Equal(t, validation.QueryProfilesLimit, validation.ReasonOf(err))
+	require.Equal(t, 2, n)
+}
+
 func TestMergeProfilesPprof(t *testing.T) {
 	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
 
@@ -333,6 +415,47 @@ func TestMergeProfilesPprof(t *testing.T) {
 		require.Len(t, p.Location, 287)
 	})
 
+	t.Run("request partial results", func(t *testing.T) {
+		bidi := client.MergeProfilesPprof(ctx)
+
+		require.NoError(t, bidi.Send(&ingestv1.MergeProfilesPprofRequest{
+			Request: &ingestv1.SelectProfilesRequest{
+				LabelSelector: `{pod="my-pod"}`,
+				Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+				Start:         start.UnixMilli(),
+				End:           end.UnixMilli(),
+			},
+			PartialResults: true,
+		}))
+
+		resp, err := bidi.Receive()
+		require.NoError(t, err)
+		require.Len(t, resp.SelectedProfiles.Profiles, 5)
+		require.NoError(t, bidi.Send(&ingestv1.MergeProfilesPprofRequest{
+			Profiles: []bool{true, true, true, true, true},
+		}))
+
+		// expect empty resp to signal it is finished
+		resp, err = bidi.Receive()
+		require.NoError(t, err)
+		require.Nil(t, resp.Result)
+
+		// the head is merged in a single partial result.
+		resp, err = bidi.Receive()
+		require.NoError(t, err)
+		require.True(t, resp.Partial)
+		require.Nil(t, resp.Stats)
+		p, err := profile.ParseUncompressed(resp.Result)
+		require.NoError(t, err)
+		require.Len(t, p.Location, 287)
+
+		resp, err = bidi.Receive()
+		require.NoError(t, err)
+		require.False(t, resp.Partial)
+		require.Nil(t, resp.Result)
+		require.Equal(t, uint64(5), resp.Stats.MergedProfiles)
+	})
+
 	t.Run("request non existing series", func(t *testing.T) {
 		bidi := client.MergeProfilesPprof(ctx)
 
@@ -415,6 +538,70 @@ func TestMergeProfilesPprof(t *testing.T) {
 	})
 }
 
+func TestMergeProfilesLabelsStepAggregation(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{
+		DataPath:         contextDataDir(ctx),
+		MaxBlockDuration: time.Duration(100000) * time.Minute, // we will manually flush
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	// The profiles of two series share the timestamps.
+	for i := int64(1); i <= 4; i++ {
+		for _, pod := range []string{"a", "b"} {
+			p := pprofth.NewProfileBuilder(i*int64(time.Second)).CPUProfile().WithLabels("pod", pod)
+			p.ForStacktraceString("my", "other").AddSamples(i)
+			require.NoError(t, db.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+		}
+	}
+
+	client, cleanup := db.queriers().ingesterClient()
+	defer cleanup()
+
+	bidi := client.MergeProfilesLabels(ctx)
+	require.NoError(t, bidi.Send(&ingestv1.MergeProfilesLabelsRequest{
+		Request: &ingestv1.SelectProfilesRequest{
+			LabelSelector: `{}`,
+			Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+			Start:         0,
+			End:           4000,
+		},
+		StepAggregation: &ingestv1.StepAggregation{
+			Start:       0,
+			Step:        2000,
+			Aggregation: typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT,
+		},
+	}))
+
+	resp, err := bidi.Receive()
+	require.NoError(t, err)
+	require.Len(t, resp.SelectedProfiles.Profiles, 8)
+	require.NoError(t, bidi.Send(&ingestv1.MergeProfilesLabelsRequest{
+		Profiles: []bool{true, true, true, true, true, true, true, true},
+	}))
+
+	// expect empty resp to signal it is finished
+	_, err = bidi.Receive()
+	require.NoError(t, err)
+
+	// Each profile is counted: the points of the series
+	// sharing a timestamp are not summed up first.
+	resp, err = bidi.Receive()
+	require.NoError(t, err)
+	require.True(t, resp.StepAggregated)
+	testhelper.EqualProto(t, []*typesv1.Series{
+		{
+			Points: []*typesv1.Point{
+				{Timestamp: 2000, Value: 4},
+				{Timestamp: 4000, Value: 4},
+			},
+		},
+	}, resp.Series)
+}
+
 func TestFilterProfiles(t *testing.T) {
 	ctx := context.Background()
 	profiles := lo.Times(11, func(i int) Profile {
@@ -64,12 +64,12 @@ func TestIndex(t *testing.T) {
 
 	// Testing Matching
 	ctx := testContext(t)
-	fps, err := a.selectMatchingFPs(ctx, &ingestv1.SelectProfilesRequest{
+	selected, err := a.selectMatchingSeries(ctx, &ingestv1.SelectProfilesRequest{
 		LabelSelector: `memory{bar=~"[0-9]", buzz!="bar"}`,
 		Type:          &typesv1.ProfileType{},
 	})
 	require.NoError(t, err)
-	require.Len(t, fps, 20)
+	require.Len(t, selected, 20)
 
 	names, err := a.ix.LabelNames(nil)
 	require.NoError(t, err)
@@ -24,6 +24,9 @@ import (
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb"
 	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/index"
+	"github.com/grafana/pyroscope/pkg/phlaredb/tsdb/shard"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
+	"github.com/grafana/pyroscope/pkg/util/limiter"
 )
 
 // delta encoding for ranges
@@ -126,6 +129,8 @@ type profileSeries struct {
 	fp  model.Fingerprint
 
 	minTime, maxTime int64
+	// bytes of the profiles ingested
+	bytes uint64
 
 	// profiles in memory
 	profiles []*schemav1.InMemoryProfile
@@ -182,6 +187,7 @@ func (pi *profilesIndex) Add(ps *schemav1.InMemoryProfile, lbs phlaremodel.Label
 	}
 
 	profiles.profiles = append(profiles.profiles, ps)
+	profiles.bytes += ps.Size()
 	if ps.TimeNanos < profiles.minTime {
 		profiles.minTime = ps.TimeNanos
 	}
@@ -193,8 +199,22 @@ func (pi *profilesIndex) Add(ps *schemav1.InMemoryProfile, lbs phlaremodel.Label
 	pi.metrics.profilesCreated.WithLabelValues(profileName).Inc()
 }
 
-func (pi *profilesIndex) selectMatchingFPs(ctx context.Context, params *ingestv1.SelectProfilesRequest) ([]model.Fingerprint, error) {
-	sp, _ := opentracing.StartSpanFromContext(ctx, "selectMatchingFPs - Index")
+// selectedSeries is a series of the profile type of a selection.
+type selectedSeries struct {
+	// ref is the fingerprint of the series in the index.
+	ref model.Fingerprint
+	// The labels and the fingerprint of the selected profile type. They
+	// differ from those of the series in the index for multi-value
+	// profiles, whose series hold several profile types.
+	lbs phlaremodel.Labels
+	fp  model.Fingerprint
+	// valueIndex is the index of the selected profile type in the
+	// values of the profiles, see schemav1.Samples.ValuesOf.
+	valueIndex int
+}
+
+func (pi *profilesIndex) selectMatchingSeries(ctx context.Context, params *ingestv1.SelectProfilesRequest) ([]selectedSeries, error) {
+	sp, _ := opentracing.StartSpanFromContext(ctx, "selectMatchingSeries - Index")
 	defer sp.Finish()
 	selectors, err := parser.ParseMetricSelector(params.LabelSelector)
 	if err != nil {
@@ -203,10 +223,22 @@ func (pi *profilesIndex) selectMatchingFPs(ctx context.Context, params *ingestv1
 	if params.Type == nil {
 		return nil, errors.New("no profileType given")
 	}
-	selectors = append(selectors, phlaremodel.SelectorFromProfileType(params.Type))
+	profileTypeSelector := phlaremodel.SelectorFromProfileType(params.Type)
+	selectors = append(selectors, profileTypeSelector)
+	seriesShard, selectors, err := splitShardMatcher(selectors)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	filters, matchers := SplitFiltersAndMatchers(selectors)
-	ids, err := pi.ix.Lookup(matchers, nil)
+	ids, err := pi.ix.Lookup(matchers, seriesShard)
+	if err != nil {
+		return nil, err
+	}
+	// The profile type may also be held by multi-value profile series.
+	multiValue := newMultiValueSelector(selectors, profileTypeSelector.Value)
+	multiValueFilters, multiValueMatchers := SplitFiltersAndMatchers(multiValue.matchers)
+	multiValueIDs, err := pi.ix.Lookup(multiValueMatchers, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -215,8 +247,7 @@ func (pi *profilesIndex) selectMatchingFPs(ctx context.Context, params *ingestv1
 	defer pi.mutex.RUnlock()
 
 	// filter fingerprints that no longer exist or don't match the filters
-	var idx int
-outer:
+	selected := make([]selectedSeries, 0, len(ids))
 	for _, fp := range ids {
 		profile, ok := pi.profilesPerFP[fp]
 		if !ok {
@@ -224,52 +255,87 @@ outer:
 			// and is supposed to be picked up from storage by querier
 			continue
 		}
-		for _, filter := range filters {
-			if !filter.Matches(profile.lbs.Get(filter.Name)) {
-				continue outer
+		if !matchesAll(filters, profile.lbs) {
+			continue
+		}
+		s := selectedSeries{ref: fp, lbs: profile.lbs, fp: fp}
+		if additionalProfileTypes(profile.lbs) != nil {
+			if s.lbs, err = profileTypeSeriesLabels(profile.lbs, 0); err != nil {
+				return nil, err
 			}
+			s.fp = model.Fingerprint(s.lbs.Hash())
+		}
+		selected = append(selected, s)
+	}
+	for _, fp := range multiValueIDs {
+		profile, ok := pi.profilesPerFP[fp]
+		if !ok || !matchesAll(multiValueFilters, profile.lbs) {
+			continue
+		}
+		lbs, valueIndex, ok := multiValue.series(profile.lbs)
+		if !ok {
+			continue
+		}
+		s := selectedSeries{ref: fp, lbs: lbs, fp: model.Fingerprint(lbs.Hash()), valueIndex: valueIndex}
+		if seriesShard != nil && !seriesShard.TSDB().Match(s.fp) {
+			continue
 		}
+		selected = append(selected, s)
+	}
 
-		// keep this one
-		ids[idx] = fp
-		idx++
+	sp.SetTag("matchedSeries", len(selected))
+	stats.FromContext(ctx).AddFetchedSeries(uint64(len(selected)))
+	fps := make([]model.Fingerprint, len(selected))
+	for i, s := range selected {
+		fps[i] = s.fp
+	}
+	if err = limiter.QueryLimiterFromContext(ctx).AddSeries(fps...); err != nil {
+		return nil, err
 	}
 
-	sp.SetTag("matchedSeries", idx)
+	return selected, nil
+}
 
-	return ids[:idx], nil
+// matchesAll reports whether the labels match all the matchers.
+func matchesAll(matchers []*labels.Matcher, lbs phlaremodel.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbs.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
 }
 
 func (pi *profilesIndex) selectMatchingRowRanges(ctx context.Context, params *ingestv1.SelectProfilesRequest, rowGroupIdx int) (
 	query.Iterator,
-	map[model.Fingerprint]phlaremodel.Labels,
+	map[model.Fingerprint]selectedSeries,
 	error,
 ) {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "selectMatchingRowRanges - Index")
 	defer sp.Finish()
 
-	ids, err := pi.selectMatchingFPs(ctx, params)
+	selected, err := pi.selectMatchingSeries(ctx, params)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// gather rowRanges and labels from matching series under read lock of the index
 	var (
-		rowRanges   = make(rowRanges, len(ids))
-		labelsPerFP = make(map[model.Fingerprint]phlaremodel.Labels, len(ids))
+		rowRanges    = make(rowRanges, len(selected))
+		seriesPerRef = make(map[model.Fingerprint]selectedSeries, len(selected))
 	)
 
 	pi.mutex.RLock()
 	defer pi.mutex.RUnlock()
 
-	for _, fp := range ids {
+	for _, s := range selected {
 		// skip if series no longer in index
-		profileSeries, ok := pi.profilesPerFP[fp]
+		profileSeries, ok := pi.profilesPerFP[s.ref]
 		if !ok {
 			continue
 		}
 
-		labelsPerFP[fp] = profileSeries.lbs
+		seriesPerRef[s.ref] = s
 
 		// skip if rowRange empty
 		rR := profileSeries.profilesOnDisk[rowGroupIdx]
@@ -277,19 +343,23 @@ func (pi *profilesIndex) selectMatchingRowRanges(ctx context.Context, params *in
 			continue
 		}
 
-		rowRanges[*rR] = fp
+		rowRanges[*rR] = s.ref
 	}
 
 	sp.SetTag("rowGroupSegment", rowGroupIdx)
 	sp.SetTag("matchedRowRangesCount", len(rowRanges))
 
-	return rowRanges.fingerprintsWithRowNum(), labelsPerFP, nil
+	return rowRanges.fingerprintsWithRowNum(), seriesPerRef, nil
 }
 
 type ProfileWithLabels struct {
 	profile *schemav1.InMemoryProfile
 	lbs     phlaremodel.Labels
 	fp      model.Fingerprint
+	period  int64
+	// valueIndex is the index of the profile type in the values
+	// of the profile, see schemav1.Samples.ValuesOf.
+	valueIndex int
 }
 
 func (p ProfileWithLabels) StacktracePartition() uint64 {
@@ -308,19 +378,43 @@ func (p ProfileWithLabels) Labels() phlaremodel.Labels {
 	return p.lbs
 }
 
+func (p ProfileWithLabels) Period() int64 {
+	return p.period
+}
+
+func (p ProfileWithLabels) ValueIndex() int {
+	return p.valueIndex
+}
+
+// Samples returns the samples of the profile, with the values of the
+// profile type of the profile.
 func (p ProfileWithLabels) Samples() schemav1.Samples {
-	return p.profile.Samples
+	s := p.profile.Samples
+	if s.AdditionalValues == nil {
+		return s
+	}
+	return schemav1.Samples{
+		StacktraceIDs: s.StacktraceIDs,
+		Values:        s.ValuesOf(p.valueIndex),
+		Spans:         s.Spans,
+	}
 }
 
 func (p ProfileWithLabels) Total() int64 {
+	if p.valueIndex > 0 {
+		return int64(p.profile.AdditionalTotalValues[p.valueIndex-1])
+	}
 	return int64(p.profile.TotalValue)
 }
 
 type SeriesIterator struct {
 	iter.Iterator[*schemav1.InMemoryProfile]
-	curr ProfileWithLabels
-	fp   model.Fingerprint
-	lbs  phlaremodel.Labels
+	curr       ProfileWithLabels
+	fp         model.Fingerprint
+	lbs        phlaremodel.Labels
+	valueIndex int
+
+	scaleByPeriod bool
 }
 
 func NewSeriesIterator(labels phlaremodel.Labels, fingerprint model.Fingerprint, it iter.Iterator[*schemav1.InMemoryProfile]) *SeriesIterator {
@@ -336,9 +430,13 @@ func (it *SeriesIterator) Next() bool {
 		return false
 	}
 	it.curr = ProfileWithLabels{
-		profile: it.Iterator.At(),
-		lbs:     it.lbs,
-		fp:      it.fp,
+		profile:    it.Iterator.At(),
+		lbs:        it.lbs,
+		fp:         it.fp,
+		valueIndex: it.valueIndex,
+	}
+	if it.scaleByPeriod {
+		it.curr.period = it.curr.profile.Period
 	}
 	return true
 }
@@ -348,9 +446,40 @@ func (it *SeriesIterator) At() Profile {
 }
 
 // forMatchingLabels iterates through all matching label sets and calls f for each labels set.
+// Multi-value profile series are expanded into the label sets of their profile types.
 func (pi *profilesIndex) forMatchingLabels(matchers []*labels.Matcher,
 	fn func(lbs phlaremodel.Labels, fp model.Fingerprint) error,
 ) error {
+	err := pi.forMatchingSeries(matchers, func(s *profileSeries) error {
+		if additionalProfileTypes(s.lbs) != nil {
+			return nil
+		}
+		return fn(s.lbs, s.fp)
+	})
+	if err != nil {
+		return err
+	}
+	multiValueMatchers, _ := splitProfileTypeMatchers(matchers)
+	multiValueMatchers = append(multiValueMatchers, labels.MustNewMatcher(labels.MatchRegexp, phlaremodel.LabelNameAdditionalProfileTypes, ".+"))
+	return pi.forMatchingSeries(multiValueMatchers, func(s *profileSeries) error {
+		for valueIndex := 0; valueIndex <= len(additionalProfileTypes(s.lbs)); valueIndex++ {
+			lbs, err := profileTypeSeriesLabels(s.lbs, valueIndex)
+			if err != nil {
+				return err
+			}
+			if matchesAll(matchers, lbs) {
+				if err = fn(lbs, model.Fingerprint(lbs.Hash())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// forMatchingSeries iterates through all matching series and calls f for each series.
+// The series must not be modified nor retained.
+func (pi *profilesIndex) forMatchingSeries(matchers []*labels.Matcher, fn func(*profileSeries) error) error {
 	filters, matchers := SplitFiltersAndMatchers(matchers)
 	ids, err := pi.ix.Lookup(matchers, nil)
 	if err != nil {
@@ -373,7 +502,7 @@ outer:
 				continue outer
 			}
 		}
-		if err := fn(profile.lbs, fp); err != nil {
+		if err := fn(profile); err != nil {
 			return err
 		}
 	}
@@ -430,6 +559,7 @@ func (pi *profilesIndex) writeTo(ctx context.Context, path string) ([][]rowRange
 		if err := writer.AddSeries(storage.SeriesRef(i), s.lbs, s.fp, index.ChunkMeta{
 			MinTime: s.minTime,
 			MaxTime: s.maxTime,
+			KB:      uint32((s.bytes + 512) / 1024),
 			// We store the series Index from the head with the series to use when retrieving data from parquet.
 			SeriesIndex: uint32(i),
 		}); err != nil {
@@ -509,3 +639,20 @@ func SplitFiltersAndMatchers(allMatchers []*labels.Matcher) (filters, matchers [
 	}
 	return
 }
+
+// splitShardMatcher removes the series shard matcher from the list,
+// if present, and returns the shard it selects. Series are sharded by
+// the fingerprint bit prefix, therefore the number of shards must be
+// a power of two.
+func splitShardMatcher(allMatchers []*labels.Matcher) (*shard.Annotation, []*labels.Matcher, error) {
+	s, idx, err := shard.FromMatchers(allMatchers)
+	if err != nil || s == nil {
+		return nil, allMatchers, err
+	}
+	if err = tsdb.ValidateBitPrefixShardFactor(uint32(s.Of)); err != nil {
+		return nil, nil, fmt.Errorf("invalid series shard %s: number of shards must be a power of two", s)
+	}
+	matchers := make([]*labels.Matcher, 0, len(allMatchers)-1)
+	matchers = append(matchers, allMatchers[:idx]...)
+	return s, append(matchers, allMatchers[idx+1:]...), nil
+}
@@ -0,0 +1,417 @@
+package phlaredb
+
+import (
+	"container/heap"
+	"context"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/parquet-go/parquet-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/phlaredb/query"
+	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/util/limiter"
+)
+
+// ProfilesMetadataSelector is implemented by the queriers that list the
+// metadata of their profiles. Only the metadata columns are read, the
+// samples of the profiles are not. Only the most recent profiles are
+// returned, up to the limit, if it is greater than 0.
+type ProfilesMetadataSelector interface {
+	SelectProfilesMetadata(ctx context.Context, params *ingestv1.SelectProfilesRequest, predicates []phlaremodel.ProfileMetadataPredicate, limit int64) ([]*typesv1.ProfileMetadata, error)
+}
+
+// SelectProfilesMetadata lists the metadata of the profiles of the blocks
+// that match the request. The profiles listed by each block are accounted
+// by the query limiter.
+func SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest], blockGetter BlockGetter) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectProfilesMetadata")
+	defer sp.Finish()
+
+	profileType, err := phlaremodel.ParseProfileTypeSelector(req.Msg.ProfileTypeID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	predicates, err := phlaremodel.ParseProfileMetadataPredicates(req.Msg.Predicates)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	params := &ingestv1.SelectProfilesRequest{
+		LabelSelector: req.Msg.LabelSelector,
+		Type:          profileType,
+		Start:         req.Msg.Start,
+		End:           req.Msg.End,
+	}
+	queriers, err := blockGetter(ctx, model.Time(req.Msg.Start), model.Time(req.Msg.End))
+	if err != nil {
+		return nil, err
+	}
+	m := phlaremodel.NewProfilesMetadataMerger()
+	for _, q := range queriers {
+		s, ok := q.(ProfilesMetadataSelector)
+		if !ok {
+			continue
+		}
+		profiles, err := s.SelectProfilesMetadata(ctx, params, predicates, req.Msg.Limit)
+		if err != nil {
+			return nil, err
+		}
+		if err = limiter.QueryLimiterFromContext(ctx).AddProfiles(len(profiles)); err != nil {
+			return nil, err
+		}
+		m.MergeProfilesMetadata(profiles)
+	}
+	return connect.NewResponse(&typesv1.SelectProfilesMetadataResponse{
+		Profiles: m.ProfilesMetadata(req.Msg.Limit),
+	}), nil
+}
+
+// SelectProfilesMetadata lists the metadata of the profiles of the head
+// and the local blocks that match the request.
+func (f *PhlareDB) SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	f.headLock.RLock()
+	defer f.headLock.RUnlock()
+	return SelectProfilesMetadata(ctx, req, f.queriers().ForTimeRange)
+}
+
+// profilesMetadataTop keeps the most recent profiles, up to the limit, if
+// it is greater than 0: the least recent of them is at the top of the
+// heap, to be replaced by a more recent one.
+type profilesMetadataTop struct {
+	limit    int
+	profiles []*typesv1.ProfileMetadata
+}
+
+func newProfilesMetadataTop(limit int64) *profilesMetadataTop {
+	return &profilesMetadataTop{limit: int(limit)}
+}
+
+func (t *profilesMetadataTop) add(p *typesv1.ProfileMetadata) {
+	switch {
+	case t.limit <= 0:
+		t.profiles = append(t.profiles, p)
+	case len(t.profiles) < t.limit:
+		heap.Push(t, p)
+	case moreRecentProfile(p, t.profiles[0]):
+		t.profiles[0] = p
+		heap.Fix(t, 0)
+	}
+}
+
+func moreRecentProfile(a, b *typesv1.ProfileMetadata) bool {
+	if a.Timestamp != b.Timestamp {
+		return a.Timestamp > b.Timestamp
+	}
+	return a.ID < b.ID
+}
+
+func (t *profilesMetadataTop) Len() int { return len(t.profiles) }
+func (t *profilesMetadataTop) Less(i, j int) bool {
+	return moreRecentProfile(t.profiles[j], t.profiles[i])
+}
+func (t *profilesMetadataTop) Swap(i, j int) {
+	t.profiles[i], t.profiles[j] = t.profiles[j], t.profiles[i]
+}
+func (t *profilesMetadataTop) Push(x any) {
+	t.profiles = append(t.profiles, x.(*typesv1.ProfileMetadata))
+}
+func (t *profilesMetadataTop) Pop() any {
+	p := t.profiles[len(t.profiles)-1]
+	t.profiles = t.profiles[:len(t.profiles)-1]
+	return p
+}
+
+// metadataColumnIter returns the iterator of the column of the profiles
+// table, filtered with the predicates on the field.
+func metadataColumnIter(
+	ctx context.Context,
+	columnIter func(ctx context.Context, columnName string, predicate query.Predicate, alias string) query.Iterator,
+	columnName string,
+	field phlaremodel.ProfileMetadataField,
+	predicates []phlaremodel.ProfileMetadataPredicate,
+) query.Iterator {
+	var p metadataPredicate
+	for _, x := range predicates {
+		if x.Field == field {
+			p = append(p, x)
+		}
+	}
+	if len(p) == 0 {
+		return columnIter(ctx, columnName, nil, columnName)
+	}
+	return columnIter(ctx, columnName, p, columnName)
+}
+
+// metadataColumns are the columns of the profiles table read by
+// selectProfilesMetadata, in addition to TimeNanos.
+var metadataColumns = []string{"ID", "TotalValue", "DurationNanos", "Period", schemav1.AdditionalTotalValuesColumnPath}
+
+// joinMetadataColumns joins the metadata columns to the iterator of the
+// matching profiles. If multiValue is set, the additional total values
+// of multi-value profiles are joined as well, and the total value
+// predicates are left to the caller, as the total value of the
+// additional profile types is not in the total value column.
+func joinMetadataColumns(
+	ctx context.Context,
+	it query.Iterator,
+	columnIter func(ctx context.Context, columnName string, predicate query.Predicate, alias string) query.Iterator,
+	withTotalValue, multiValue bool,
+	predicates []phlaremodel.ProfileMetadataPredicate,
+) query.Iterator {
+	it = query.NewBinaryJoinIterator(0, it, columnIter(ctx, "ID", nil, "ID"))
+	if multiValue {
+		it = query.NewBinaryJoinIterator(0, it, columnIter(ctx, "TotalValue", nil, "TotalValue"))
+		it = query.NewBinaryJoinIterator(0, it, columnIter(ctx, schemav1.AdditionalTotalValuesColumnPath, nil, schemav1.AdditionalTotalValuesColumnPath))
+	} else if withTotalValue {
+		it = query.NewBinaryJoinIterator(0, it, metadataColumnIter(ctx, columnIter, "TotalValue", phlaremodel.ProfileMetadataTotalValue, predicates))
+	}
+	it = query.NewBinaryJoinIterator(0, it, metadataColumnIter(ctx, columnIter, "DurationNanos", phlaremodel.ProfileMetadataDuration, predicates))
+	return query.NewBinaryJoinIterator(0, it, metadataColumnIter(ctx, columnIter, "Period", phlaremodel.ProfileMetadataPeriod, predicates))
+}
+
+// profileMetadataFromColumns builds the metadata of the profile from the
+// values of the TimeNanos column, followed by the metadataColumns.
+func profileMetadataFromColumns(buf [][]parquet.Value, lbs phlaremodel.Labels, valueIndex int) (*typesv1.ProfileMetadata, error) {
+	id, err := uuid.FromBytes(buf[1][0].ByteArray())
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid profile ID")
+	}
+	totalValue := retrieveInt64(buf, 2)
+	if valueIndex > 0 {
+		totalValue = 0
+		if i := valueIndex - 1; len(buf) > 5 && i < len(buf[5]) && !buf[5][i].IsNull() {
+			totalValue = buf[5][i].Int64()
+		}
+	}
+	return &typesv1.ProfileMetadata{
+		ID:            id.String(),
+		Timestamp:     int64(model.TimeFromUnixNano(buf[0][0].Int64())),
+		Labels:        lbs,
+		TotalValue:    totalValue,
+		DurationNanos: retrieveInt64(buf, 3),
+		Period:        retrieveInt64(buf, 4),
+	}, nil
+}
+
+// retrieveInt64 returns the value of the column, or 0 if it is null or
+// missing.
+func retrieveInt64(buf [][]parquet.Value, pos int) int64 {
+	if len(buf) > pos && len(buf[pos]) == 1 && !buf[pos][0].IsNull() {
+		return buf[pos][0].Int64()
+	}
+	return 0
+}
+
+func (b *singleBlockQuerier) SelectProfilesMetadata(ctx context.Context, params *ingestv1.SelectProfilesRequest, predicates []phlaremodel.ProfileMetadataPredicate, limit int64) ([]*typesv1.ProfileMetadata, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectProfilesMetadata - Block")
+	defer sp.Finish()
+	lblsPerRef, err := b.selectMatchingSeries(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	multiValue := false
+	for _, info := range lblsPerRef {
+		multiValue = multiValue || info.valueIndex > 0
+	}
+	// Blocks of version 1 do not have the total value of the profiles:
+	// it is reported as 0.
+	withTotalValue := b.meta.Version >= 2
+	it := joinMetadataColumns(ctx,
+		query.NewBinaryJoinIterator(0,
+			b.profiles.columnIter(ctx, "SeriesIndex", query.NewMapPredicate(lblsPerRef), "SeriesIndex"),
+			b.profiles.columnIter(ctx, "TimeNanos", query.NewIntBetweenPredicate(model.Time(params.Start).UnixNano(), model.Time(params.End).UnixNano()), "TimeNanos"),
+		),
+		b.profiles.columnIter, withTotalValue, multiValue, predicates)
+	defer it.Close()
+
+	var (
+		profiles = newProfilesMetadataTop(limit)
+		columns  = append([]string{"TimeNanos"}, metadataColumns...)
+		buf      [][]parquet.Value
+		series   [][]parquet.Value
+	)
+	for it.Next() {
+		res := it.At()
+		series = res.Columns(series, "SeriesIndex")
+		buf = res.Columns(buf, columns...)
+		info := lblsPerRef[series[0][0].Int64()]
+		p, err := profileMetadataFromColumns(buf, info.lbs, info.valueIndex)
+		if err != nil {
+			return nil, err
+		}
+		if (!withTotalValue || multiValue) && !matchProfileMetadata(p, predicates) {
+			continue
+		}
+		profiles.add(p)
+	}
+	return profiles.profiles, it.Err()
+}
+
+func (q *headOnDiskQuerier) SelectProfilesMetadata(ctx context.Context, params *ingestv1.SelectProfilesRequest, predicates []phlaremodel.ProfileMetadataPredicate, limit int64) ([]*typesv1.ProfileMetadata, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectProfilesMetadata - HeadOnDisk")
+	defer sp.Finish()
+	rowIter, seriesPerRef, err := q.head.profiles.index.selectMatchingRowRanges(ctx, params, q.rowGroupIdx)
+	if err != nil {
+		return nil, err
+	}
+	multiValue := false
+	for _, s := range seriesPerRef {
+		multiValue = multiValue || s.valueIndex > 0
+	}
+	rowGroup := q.rowGroup()
+	it := joinMetadataColumns(ctx,
+		query.NewBinaryJoinIterator(0,
+			rowIter,
+			rowGroup.columnIter(ctx, "TimeNanos", query.NewIntBetweenPredicate(model.Time(params.Start).UnixNano(), model.Time(params.End).UnixNano()), "TimeNanos"),
+		),
+		rowGroup.columnIter, true, multiValue, predicates)
+	defer it.Close()
+
+	var (
+		profiles = newProfilesMetadataTop(limit)
+		columns  = append([]string{"TimeNanos"}, metadataColumns...)
+		buf      [][]parquet.Value
+	)
+	for it.Next() {
+		res := it.At()
+		v, ok := res.Entries[0].RowValue.(fingerprintWithRowNum)
+		if !ok {
+			panic("no fingerprint information found")
+		}
+		buf = res.Columns(buf, columns...)
+		series := seriesPerRef[v.fp]
+		p, err := profileMetadataFromColumns(buf, series.lbs, series.valueIndex)
+		if err != nil {
+			return nil, err
+		}
+		if multiValue && !matchProfileMetadata(p, predicates) {
+			continue
+		}
+		profiles.add(p)
+	}
+	return profiles.profiles, it.Err()
+}
+
+func (q *headInMemoryQuerier) SelectProfilesMetadata(ctx context.Context, params *ingestv1.SelectProfilesRequest, predicates []phlaremodel.ProfileMetadataPredicate, limit int64) ([]*typesv1.ProfileMetadata, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectProfilesMetadata - HeadInMemory")
+	defer sp.Finish()
+	index := q.head.profiles.index
+	selected, err := index.selectMatchingSeries(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	start, end := model.Time(params.Start).UnixNano(), model.Time(params.End).UnixNano()
+
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	profiles := newProfilesMetadataTop(limit)
+	for _, s := range selected {
+		series, ok := index.profilesPerFP[s.ref]
+		if !ok {
+			continue
+		}
+		for _, p := range series.profiles {
+			if p.TimeNanos < start || p.TimeNanos > end {
+				continue
+			}
+			m := inMemoryProfileMetadata(ProfileWithLabels{profile: p, valueIndex: s.valueIndex}, s.lbs)
+			if matchProfileMetadata(m, predicates) {
+				profiles.add(m)
+			}
+		}
+	}
+	return profiles.profiles, nil
+}
+
+func inMemoryProfileMetadata(p ProfileWithLabels, lbs phlaremodel.Labels) *typesv1.ProfileMetadata {
+	return &typesv1.ProfileMetadata{
+		ID:            p.profile.ID.String(),
+		Timestamp:     int64(p.Timestamp()),
+		Labels:        lbs,
+		TotalValue:    p.Total(),
+		DurationNanos: p.profile.DurationNanos,
+		Period:        p.profile.Period,
+	}
+}
+
+func matchProfileMetadata(p *typesv1.ProfileMetadata, predicates []phlaremodel.ProfileMetadataPredicate) bool {
+	for _, x := range predicates {
+		var v int64
+		switch x.Field {
+		case phlaremodel.ProfileMetadataDuration:
+			v = p.DurationNanos
+		case phlaremodel.ProfileMetadataPeriod:
+			v = p.Period
+		case phlaremodel.ProfileMetadataTotalValue:
+			v = p.TotalValue
+		}
+		if !x.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataPredicate is a pushdown predicate on a column of the profile
+// metadata: all the predicates must match. Null values are compared as 0.
+type metadataPredicate []phlaremodel.ProfileMetadataPredicate
+
+var _ query.Predicate = metadataPredicate(nil)
+
+func (p metadataPredicate) keepRange(min, max int64) bool {
+	for _, x := range p {
+		if x.Op == "!=" {
+			if min == max && min == x.Value {
+				return false
+			}
+			continue
+		}
+		lo, hi := x.Bounds()
+		if hi < min || lo > max {
+			return false
+		}
+	}
+	return true
+}
+
+func (p metadataPredicate) KeepColumnChunk(c parquet.ColumnChunk) bool {
+	ci := c.ColumnIndex()
+	if ci == nil {
+		return true
+	}
+	for i := 0; i < ci.NumPages(); i++ {
+		if ci.NullPage(i) || p.keepRange(ci.MinValue(i).Int64(), ci.MaxValue(i).Int64()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p metadataPredicate) KeepPage(page parquet.Page) bool {
+	if page.NumNulls() > 0 {
+		return true
+	}
+	if min, max, ok := page.Bounds(); ok {
+		return p.keepRange(min.Int64(), max.Int64())
+	}
+	return true
+}
+
+func (p metadataPredicate) KeepValue(v parquet.Value) bool {
+	var x int64
+	if !v.IsNull() {
+		x = v.Int64()
+	}
+	for _, m := range p {
+		if !m.Match(x) {
+			return false
+		}
+	}
+	return true
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/parquet-go/parquet-go"
 
 	"github.com/grafana/pyroscope/pkg/iter"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 )
 
 const MaxDefinitionLevel = 5
@@ -832,6 +833,7 @@ type SyncIterator struct {
 	cancel          func()
 	span            opentracing.Span
 	metrics         *Metrics
+	stats           *stats.Stats
 	curr            RowNumber
 	currRowGroup    parquet.RowGroup
 	currRowGroupMin RowNumber
@@ -899,6 +901,7 @@ func NewSyncIterator(ctx context.Context, rgs []parquet.RowGroup, column int, co
 		ctx:        ctx,
 		cancel:     cancel,
 		metrics:    getMetricsFromContext(ctx),
+		stats:      stats.FromContext(ctx),
 		span:       span,
 		column:     column,
 		columnName: columnName,
@@ -1064,6 +1067,9 @@ func (c *SyncIterator) seekPages(seekTo RowNumber, definitionLevel int) (done bo
 				return true, err
 			}
 			c.metrics.pageReadsTotal.WithLabelValues(c.table, c.columnName).Add(1)
+			c.stats.AddReadPages(1)
+			c.stats.AddReadBytes(uint64(pg.Size()))
+			c.stats.AddReadRows(uint64(pg.NumRows()))
 			c.span.LogFields(
 				log.String("msg", "reading page (seekPages)"),
 				log.Int64("page_num_values", pg.NumValues()),
@@ -1133,6 +1139,9 @@ func (c *SyncIterator) next() (RowNumber, *parquet.Value, error) {
 				return EmptyRowNumber(), nil, err
 			}
 			c.metrics.pageReadsTotal.WithLabelValues(c.table, c.columnName).Add(1)
+			c.stats.AddReadPages(1)
+			c.stats.AddReadBytes(uint64(pg.Size()))
+			c.stats.AddReadRows(uint64(pg.NumRows()))
 			c.span.LogFields(
 				log.String("msg", "reading page (next)"),
 				log.Int64("page_num_values", pg.NumValues()),
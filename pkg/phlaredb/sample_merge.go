@@ -2,6 +2,7 @@ package phlaredb
 
 import (
 	"context"
+	"fmt"
 	"sort"
 
 	"github.com/google/pprof/profile"
@@ -14,6 +15,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/phlaredb/query"
+	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb/symdb"
 )
 
@@ -28,6 +30,26 @@ func (b *singleBlockQuerier) MergeByStacktraces(ctx context.Context, rows iter.I
 	return r.Tree()
 }
 
+func (b *singleBlockQuerier) MergeByStacktracesMultiValue(ctx context.Context, rows ...iter.Iterator[Profile]) ([]*phlaremodel.Tree, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeByStacktracesMultiValue - Block")
+	defer sp.Finish()
+	return mergeByStacktracesMultiValue(ctx, b.profiles.file, b.symbols, rows)
+}
+
+func (b *singleBlockQuerier) MergeBySpans(ctx context.Context, rows iter.Iterator[Profile], spans phlaremodel.SpanSelector) (*phlaremodel.Tree, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeBySpans - Block")
+	defer sp.Finish()
+	if b.spans != nil {
+		rows = b.spans.filterRows(b.profiles.file.RowGroups(), rows, spans)
+	}
+	r := symdb.NewResolver(ctx, b.symbols)
+	defer r.Release()
+	if err := mergeBySpans(ctx, b.profiles.file, rows, r, spans); err != nil {
+		return nil, err
+	}
+	return r.Tree()
+}
+
 func (b *singleBlockQuerier) MergePprof(ctx context.Context, rows iter.Iterator[Profile]) (*profile.Profile, error) {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "MergeByStacktraces - Block")
 	defer sp.Finish()
@@ -62,26 +84,204 @@ type Source interface {
 func mergeByStacktraces(ctx context.Context, profileSource Source, rows iter.Iterator[Profile], r *symdb.Resolver) error {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "mergeByStacktraces")
 	defer sp.Finish()
-	// clone the rows to be able to iterate over them twice
-	multiRows, err := iter.CloneN(rows, 2)
+	return forEachProfileSamples(ctx, profileSource, rows, nil, func(row Profile, values [][]parquet.Value) {
+		s := scale(row)
+		p := r.Partition(row.StacktracePartition())
+		for i := 0; i < len(values[0]); i++ {
+			p[uint32(values[0][i].Int64())] += values[1][i].Int64() * s
+		}
+	})
+}
+
+func mergeBySpans(ctx context.Context, profileSource Source, rows iter.Iterator[Profile], r *symdb.Resolver, spans phlaremodel.SpanSelector) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "mergeBySpans")
+	defer sp.Finish()
+	var sampleSpans []uint64
+	return forEachProfileSamples(ctx, profileSource, rows, []string{schemav1.SpanIDColumnPath}, func(row Profile, values [][]parquet.Value) {
+		sampleSpans = schemav1.SampleSpanIDs(sampleSpans[:0], values[2])
+		if len(sampleSpans) != len(values[0]) {
+			return
+		}
+		s := scale(row)
+		p := r.Partition(row.StacktracePartition())
+		for i := 0; i < len(values[0]); i++ {
+			if _, ok := spans[sampleSpans[i]]; ok {
+				p[uint32(values[0][i].Int64())] += values[1][i].Int64() * s
+			}
+		}
+	})
+}
+
+func mergeByStacktracesMultiValue(ctx context.Context, profileSource Source, symbols symdb.SymbolsReader, rows []iter.Iterator[Profile]) ([]*phlaremodel.Tree, error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "mergeByStacktracesMultiValue")
+	defer sp.Finish()
+	resolvers := make([]*symdb.Resolver, len(rows))
+	for i := range resolvers {
+		resolvers[i] = symdb.NewResolver(ctx, symbols)
+	}
+	defer func() {
+		for _, r := range resolvers {
+			r.Release()
+		}
+	}()
+
+	selected, additional, err := groupByRowNumber(rows)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	columns := []string{"Samples.list.element.StacktraceID", "Samples.list.element.Value"}
+	if additional {
+		columns = append(columns, schemav1.AdditionalValuesColumnPath)
+	}
+	multiRows, err := iter.CloneN(iter.NewSliceIterator(selected), len(columns))
+	if err != nil {
+		return nil, err
+	}
+	iters := make([]iter.Iterator[*query.RepeatedRow[*selectedRow]], len(columns))
+	for i, column := range columns {
+		iters[i] = repeatedColumnIter(ctx, profileSource, column, multiRows[i])
 	}
-	it := query.NewMultiRepeatedPageIterator(
-		repeatedColumnIter(ctx, profileSource, "Samples.list.element.StacktraceID", multiRows[0]),
-		repeatedColumnIter(ctx, profileSource, "Samples.list.element.Value", multiRows[1]),
-	)
+	it := query.NewMultiRepeatedPageIterator(iters...)
 	defer it.Close()
 	for it.Next() {
 		values := it.At().Values
-		p := r.Partition(it.At().Row.StacktracePartition())
-		for i := 0; i < len(values[0]); i++ {
-			p[uint32(values[0][i].Int64())] += values[1][i].Int64()
+		for _, p := range it.At().Row.profiles {
+			sampleValues := values[1]
+			if vi := p.ValueIndex(); vi > 0 {
+				if sampleValues = schemav1.AdditionalValues(values[2], vi, len(values[0])); sampleValues == nil {
+					continue
+				}
+			}
+			s := scale(p)
+			partition := resolvers[p.selection].Partition(p.StacktracePartition())
+			for i := 0; i < len(values[0]); i++ {
+				partition[uint32(values[0][i].Int64())] += sampleValues[i].Int64() * s
+			}
+		}
+	}
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+
+	trees := make([]*phlaremodel.Tree, len(resolvers))
+	for i, r := range resolvers {
+		if trees[i], err = r.Tree(); err != nil {
+			return nil, err
+		}
+	}
+	return trees, nil
+}
+
+// selectedRow is a profile row with the profiles it is selected as.
+type selectedRow struct {
+	rowNum   int64
+	profiles []selectedProfile
+}
+
+func (r *selectedRow) RowNumber() int64 { return r.rowNum }
+
+type selectedProfile struct {
+	Profile
+	// selection is the index of the selection of the profile.
+	selection int
+}
+
+// groupByRowNumber groups the profiles of the selections by their row,
+// ordered by row number. It reports whether any of the profiles is of
+// an additional profile type of a multi-value profile.
+func groupByRowNumber(rows []iter.Iterator[Profile]) (selected []*selectedRow, additional bool, err error) {
+	byRowNumber := make(map[int64]*selectedRow)
+	for i, it := range rows {
+		for it.Next() {
+			p := it.At()
+			g, ok := p.(query.RowGetter)
+			if !ok {
+				return nil, false, fmt.Errorf("unexpected profile type %T", p)
+			}
+			row, ok := byRowNumber[g.RowNumber()]
+			if !ok {
+				row = &selectedRow{rowNum: g.RowNumber()}
+				byRowNumber[row.rowNum] = row
+				selected = append(selected, row)
+			}
+			row.profiles = append(row.profiles, selectedProfile{Profile: p, selection: i})
+			additional = additional || p.ValueIndex() > 0
+		}
+		if err = it.Err(); err != nil {
+			return nil, false, err
 		}
 	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].rowNum < selected[j].rowNum
+	})
+	return selected, additional, nil
+}
+
+// forEachProfileSamples calls fn for each profile with the stack trace
+// IDs of its samples, their values for the profile type of the profile,
+// and the values of the given columns.
+func forEachProfileSamples(ctx context.Context, profileSource Source, rows iter.Iterator[Profile], columns []string, fn func(Profile, [][]parquet.Value)) error {
+	profiles, additional, err := splitByValueIndex(rows)
+	if err != nil {
+		return err
+	}
+	if err = readSampleColumns(ctx, profileSource, profiles, "Samples.list.element.Value", columns, fn); err != nil {
+		return err
+	}
+	if len(additional) == 0 {
+		return nil
+	}
+	// The values of the additional profile types of multi-value
+	// profiles are read from the additional values column.
+	return readSampleColumns(ctx, profileSource, additional, schemav1.AdditionalValuesColumnPath, columns, func(p Profile, values [][]parquet.Value) {
+		if values[1] = schemav1.AdditionalValues(values[1], p.ValueIndex(), len(values[0])); values[1] != nil {
+			fn(p, values)
+		}
+	})
+}
+
+func readSampleColumns(ctx context.Context, profileSource Source, profiles []Profile, valuesColumn string, columns []string, fn func(Profile, [][]parquet.Value)) error {
+	columns = append([]string{"Samples.list.element.StacktraceID", valuesColumn}, columns...)
+	// clone the rows to be able to iterate over them for each column
+	multiRows, err := iter.CloneN(iter.NewSliceIterator(profiles), len(columns))
+	if err != nil {
+		return err
+	}
+	iters := make([]iter.Iterator[*query.RepeatedRow[Profile]], len(columns))
+	for i, column := range columns {
+		iters[i] = repeatedColumnIter(ctx, profileSource, column, multiRows[i])
+	}
+	it := query.NewMultiRepeatedPageIterator(iters...)
+	defer it.Close()
+	for it.Next() {
+		fn(it.At().Row, it.At().Values)
+	}
 	return it.Err()
 }
 
+// splitByValueIndex splits the profiles into the profiles of the sample
+// values, and those of the additional profile types of multi-value
+// profiles. The order of the profiles is preserved.
+func splitByValueIndex(rows iter.Iterator[Profile]) (profiles, additional []Profile, err error) {
+	for rows.Next() {
+		if p := rows.At(); p.ValueIndex() > 0 {
+			additional = append(additional, p)
+		} else {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles, additional, rows.Err()
+}
+
+// scale returns the factor the sample values of the profile are
+// multiplied by when merged.
+func scale(p Profile) int64 {
+	if period := p.Period(); period > 0 {
+		return period
+	}
+	return 1
+}
+
 type seriesByLabels map[string]*typesv1.Series
 
 func (m seriesByLabels) normalize() []*typesv1.Series {
@@ -99,20 +299,14 @@ func (m seriesByLabels) normalize() []*typesv1.Series {
 }
 
 func mergeByLabels(ctx context.Context, profileSource Source, columnName string, rows iter.Iterator[Profile], m seriesByLabels, by ...string) error {
-	it := repeatedColumnIter(ctx, profileSource, columnName, rows)
-
-	defer it.Close()
+	profiles, additional, err := splitByValueIndex(rows)
+	if err != nil {
+		return err
+	}
 
 	labelsByFingerprint := map[model.Fingerprint]string{}
 	labelBuf := make([]byte, 0, 1024)
-
-	for it.Next() {
-		values := it.At()
-		p := values.Row
-		var total int64
-		for _, e := range values.Values {
-			total += e.Int64()
-		}
+	add := func(p Profile, total int64) {
 		labelsByString, ok := labelsByFingerprint[p.Fingerprint()]
 		if !ok {
 			labelBuf = p.Labels().BytesWithLabels(labelBuf, by...)
@@ -128,7 +322,7 @@ func mergeByLabels(ctx context.Context, profileSource Source, columnName string,
 						},
 					},
 				}
-				continue
+				return
 			}
 		}
 		series := m[labelsByString]
@@ -137,5 +331,33 @@ func mergeByLabels(ctx context.Context, profileSource Source, columnName string,
 			Value:     float64(total),
 		})
 	}
+
+	it := repeatedColumnIter(ctx, profileSource, columnName, iter.NewSliceIterator(profiles))
+	for it.Next() {
+		values := it.At()
+		var total int64
+		for _, e := range values.Values {
+			total += e.Int64()
+		}
+		add(values.Row, total*scale(values.Row))
+	}
+	if err = it.Err(); err != nil {
+		_ = it.Close()
+		return err
+	}
+	if err = it.Close(); err != nil || len(additional) == 0 {
+		return err
+	}
+
+	// The totals of the additional profile types of multi-value
+	// profiles are read from the additional total values column.
+	it = repeatedColumnIter(ctx, profileSource, schemav1.AdditionalTotalValuesColumnPath, iter.NewSliceIterator(additional))
+	defer it.Close()
+	for it.Next() {
+		values := it.At()
+		if i := values.Row.ValueIndex() - 1; i < len(values.Values) && !values.Values[i].IsNull() {
+			add(values.Row, values.Values[i].Int64()*scale(values.Row))
+		}
+	}
 	return it.Err()
 }
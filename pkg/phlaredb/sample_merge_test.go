@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bufbuild/connect-go"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/pprof/profile"
@@ -227,6 +228,120 @@ func TestHeadMergeSampleByStacktraces(t *testing.T) {
 	}
 }
 
+func TestMergeMultiValueProfiles(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{
+		DataPath:           contextDataDir(ctx),
+		MaxBlockDuration:   time.Duration(100000) * time.Minute, // we will manually flush
+		MultiValueProfiles: true,
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+
+	p := pprofth.NewProfileBuilder(int64(15*time.Second)).
+		MemoryProfile().WithLabels("service_name", "foo")
+	p.ForStacktraceString("my", "other").AddSamples(1, 2, 3, 4)
+	p.ForStacktraceString("my", "other", "stack").AddSamples(5, 6, 7, 8)
+	require.NoError(t, db.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+
+	types, err := db.head.ProfileTypes(ctx, connect.NewRequest(&ingestv1.ProfileTypesRequest{}))
+	require.NoError(t, err)
+	require.Len(t, types.Msg.ProfileTypes, 4)
+	values, err := db.head.LabelValues(ctx, connect.NewRequest(&typesv1.LabelValuesRequest{
+		Name:     phlaremodel.LabelNameProfileType,
+		Matchers: []string{`{service_name="foo"}`},
+	}))
+	require.NoError(t, err)
+	require.Len(t, values.Msg.Names, 4)
+
+	profileTypes := []*typesv1.ProfileType{
+		mustParseProfileSelector(t, "memory:inuse_objects:count:space:bytes"),
+		mustParseProfileSelector(t, "memory:inuse_space:bytes:space:bytes"),
+	}
+	expected := []*phlaremodel.Tree{new(phlaremodel.Tree), new(phlaremodel.Tree)}
+	expected[0].InsertStack(3, "other", "my")
+	expected[0].InsertStack(7, "stack", "other", "my")
+	expected[1].InsertStack(4, "other", "my")
+	expected[1].InsertStack(8, "stack", "other", "my")
+
+	assertMerge := func(t *testing.T, queriers Queriers) {
+		selections := make([]iter.Iterator[Profile], len(profileTypes))
+		for i, profileType := range profileTypes {
+			it, err := queriers.SelectMatchingProfiles(ctx, &ingestv1.SelectProfilesRequest{
+				LabelSelector: `{service_name="foo"}`,
+				Type:          profileType,
+				Start:         int64(model.TimeFromUnixNano(0)),
+				End:           int64(model.TimeFromUnixNano(int64(1 * time.Minute))),
+			})
+			require.NoError(t, err)
+			profiles, err := iter.Slice(it)
+			require.NoError(t, err)
+			require.Len(t, profiles, 1)
+			require.Equal(t, profileType.ID, profiles[0].Labels().Get(phlaremodel.LabelNameProfileType))
+			require.Empty(t, profiles[0].Labels().Get(phlaremodel.LabelNameAdditionalProfileTypes))
+
+			tree, err := queriers[0].MergeByStacktraces(ctx, iter.NewSliceIterator(profiles))
+			require.NoError(t, err)
+			require.Equal(t, expected[i].String(), tree.String())
+			selections[i] = iter.NewSliceIterator(profiles)
+		}
+
+		trees, err := queriers[0].MergeByStacktracesMultiValue(ctx, selections...)
+		require.NoError(t, err)
+		require.Len(t, trees, len(expected))
+		for i := range expected {
+			require.Equal(t, expected[i].String(), trees[i].String())
+		}
+	}
+
+	t.Run("head", func(t *testing.T) {
+		assertMerge(t, db.head.Queriers())
+	})
+
+	t.Run("merge profile types", func(t *testing.T) {
+		client, cleanup := db.head.Queriers().ingesterClient()
+		defer cleanup()
+		bidi := client.MergeProfilesStacktraces(ctx)
+		require.NoError(t, bidi.Send(&ingestv1.MergeProfilesStacktracesRequest{
+			Request: &ingestv1.SelectProfilesRequest{
+				LabelSelector: `{service_name="foo"}`,
+				Type:          profileTypes[0],
+				Start:         int64(model.TimeFromUnixNano(0)),
+				End:           int64(model.TimeFromUnixNano(int64(1 * time.Minute))),
+			},
+			AdditionalTypes: profileTypes[1:],
+		}))
+		for {
+			resp, err := bidi.Receive()
+			require.NoError(t, err)
+			if resp.SelectedProfiles == nil {
+				break
+			}
+			require.Len(t, resp.SelectedProfiles.Profiles, len(profileTypes))
+			require.NoError(t, bidi.Send(&ingestv1.MergeProfilesStacktracesRequest{
+				Profiles: []bool{true, true},
+			}))
+		}
+		resp, err := bidi.Receive()
+		require.NoError(t, err)
+		require.Len(t, resp.Result.AdditionalTreeBytes, 1)
+		for i, b := range [][]byte{resp.Result.TreeBytes, resp.Result.AdditionalTreeBytes[0]} {
+			tree, err := phlaremodel.UnmarshalTree(b)
+			require.NoError(t, err)
+			require.Equal(t, expected[i].String(), tree.String())
+		}
+	})
+
+	require.NoError(t, db.Flush(context.Background()))
+	b, err := filesystem.NewBucket(filepath.Join(contextDataDir(ctx), pathLocal))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(context.Background()))
+
+	t.Run("block", func(t *testing.T) {
+		assertMerge(t, Queriers{q.queriers[0]})
+	})
+}
+
 func TestMergeSampleByLabels(t *testing.T) {
 	for _, tc := range []struct {
 		name     string
@@ -612,3 +727,189 @@ func compareProfileSlice[T any](t *testing.T, expected, actual []T) {
 		t.Errorf("result mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestMergeSampleBySpans(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{
+		DataPath:         contextDataDir(ctx),
+		MaxBlockDuration: time.Duration(100000) * time.Minute, // we will manually flush
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		p := pprofth.NewProfileBuilder(int64(15*time.Second)).
+			CPUProfile().WithLabels("series", fmt.Sprintf("%d", i))
+		p.ForStacktraceString("my", "other").AddSamples(1)
+		p.ForStacktraceString("my", "other").AddSpanSamples("00000000000000aa", 2)
+		p.ForStacktraceString("my", "other", "stack").AddSpanSamples("00000000000000bb", 3)
+		p.ForStacktraceString("my", "other", "stack").AddSpanSamples("00000000000000aa", 4)
+		require.NoError(t, db.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+	}
+
+	req := &ingestv1.SelectProfilesRequest{
+		LabelSelector: `{}`,
+		Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+		Start:         int64(model.TimeFromUnixNano(0)),
+		End:           int64(model.TimeFromUnixNano(int64(1 * time.Minute))),
+	}
+	spans, err := phlaremodel.NewSpanSelector([]string{"aa"})
+	require.NoError(t, err)
+	expected := new(phlaremodel.Tree)
+	expected.InsertStack(6, "other", "my")
+	expected.InsertStack(12, "stack", "other", "my")
+	expectedTotal := new(phlaremodel.Tree)
+	expectedTotal.InsertStack(9, "other", "my")
+	expectedTotal.InsertStack(21, "stack", "other", "my")
+
+	profiles, err := db.head.Queriers().SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err := db.head.Queriers()[0].MergeBySpans(ctx, profiles, spans)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), r.String())
+
+	require.NoError(t, db.Flush(context.Background()))
+	b, err := filesystem.NewBucket(filepath.Join(contextDataDir(ctx), pathLocal))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(context.Background()))
+
+	profiles, err = q.queriers[0].SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err = q.queriers[0].MergeBySpans(ctx, profiles, spans)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), r.String())
+
+	// The samples of the spans are merged with the others.
+	profiles, err = q.queriers[0].SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err = q.queriers[0].MergeByStacktraces(ctx, profiles)
+	require.NoError(t, err)
+	require.Equal(t, expectedTotal.String(), r.String())
+}
+
+func TestMergeSampleScaledByPeriod(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{
+		DataPath:         contextDataDir(ctx),
+		MaxBlockDuration: time.Duration(100000) * time.Minute, // we will manually flush
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+
+	for i, period := range []int64{10, 20} {
+		p := pprofth.NewProfileBuilder(int64(15 * time.Second))
+		p.CustomProfile("process_cpu", "samples", "count", "cpu", "nanoseconds")
+		p.WithLabels("series", fmt.Sprintf("%d", i))
+		p.Period = period
+		p.ForStacktraceString("my", "other").AddSamples(1)
+		p.ForStacktraceString("my", "other", "stack").AddSamples(2)
+		require.NoError(t, db.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+	}
+
+	req := &ingestv1.SelectProfilesRequest{
+		LabelSelector: `{}`,
+		Type:          mustParseProfileSelector(t, "process_cpu:samples:count:cpu:nanoseconds"),
+		Start:         int64(model.TimeFromUnixNano(0)),
+		End:           int64(model.TimeFromUnixNano(int64(1 * time.Minute))),
+		ScaleByPeriod: true,
+	}
+	expected := new(phlaremodel.Tree)
+	expected.InsertStack(30, "other", "my")
+	expected.InsertStack(60, "stack", "other", "my")
+	expectedSeries := []*typesv1.Series{
+		{
+			Labels: []*typesv1.LabelPair{{Name: "series", Value: "0"}},
+			Points: []*typesv1.Point{{Timestamp: 15000, Value: 30}},
+		},
+		{
+			Labels: []*typesv1.LabelPair{{Name: "series", Value: "1"}},
+			Points: []*typesv1.Point{{Timestamp: 15000, Value: 60}},
+		},
+	}
+
+	profiles, err := db.head.Queriers().SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err := db.head.Queriers()[0].MergeByStacktraces(ctx, profiles)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), r.String())
+
+	profiles, err = db.head.Queriers().SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	series, err := db.head.Queriers()[0].MergeByLabels(ctx, profiles, "series")
+	require.NoError(t, err)
+	testhelper.EqualProto(t, expectedSeries, series)
+
+	require.NoError(t, db.Flush(context.Background()))
+	b, err := filesystem.NewBucket(filepath.Join(contextDataDir(ctx), pathLocal))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(context.Background()))
+
+	profiles, err = q.queriers[0].SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err = q.queriers[0].MergeByStacktraces(ctx, profiles)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), r.String())
+
+	profiles, err = q.queriers[0].SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	series, err = q.queriers[0].MergeByLabels(ctx, profiles, "series")
+	require.NoError(t, err)
+	testhelper.EqualProto(t, expectedSeries, series)
+
+	// Without scale_by_period, the samples are counted.
+	req.ScaleByPeriod = false
+	expected = new(phlaremodel.Tree)
+	expected.InsertStack(2, "other", "my")
+	expected.InsertStack(4, "stack", "other", "my")
+	profiles, err = q.queriers[0].SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err = q.queriers[0].MergeByStacktraces(ctx, profiles)
+	require.NoError(t, err)
+	require.Equal(t, expected.String(), r.String())
+}
+
+func TestMergeSampleUnknownPeriod(t *testing.T) {
+	ctx := testContext(t)
+	db, err := New(ctx, Config{
+		DataPath:         contextDataDir(ctx),
+		MaxBlockDuration: time.Duration(100000) * time.Minute, // we will manually flush
+	}, NoLimit, ctx.localBucketClient)
+	require.NoError(t, err)
+
+	// The period of the profile is not set, as in profiles written before
+	// it was stored.
+	p := pprofth.NewProfileBuilder(int64(15 * time.Second))
+	p.CustomProfile("process_cpu", "samples", "count", "cpu", "nanoseconds")
+	p.Period = 0
+	p.ForStacktraceString("my", "other").AddSamples(1)
+	require.NoError(t, db.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+
+	req := &ingestv1.SelectProfilesRequest{
+		LabelSelector: `{}`,
+		Type:          mustParseProfileSelector(t, "process_cpu:samples:count:cpu:nanoseconds"),
+		Start:         int64(model.TimeFromUnixNano(0)),
+		End:           int64(model.TimeFromUnixNano(int64(1 * time.Minute))),
+		ScaleByPeriod: true,
+	}
+	_, err = db.head.Queriers().SelectMatchingProfiles(ctx, req)
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	require.NoError(t, db.Flush(context.Background()))
+	b, err := filesystem.NewBucket(filepath.Join(contextDataDir(ctx), pathLocal))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(context.Background()))
+
+	_, err = q.queriers[0].SelectMatchingProfiles(ctx, req)
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+
+	// The samples of the block can still be counted.
+	req.ScaleByPeriod = false
+	profiles, err := q.queriers[0].SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err := q.queriers[0].MergeByStacktraces(ctx, profiles)
+	require.NoError(t, err)
+	expected := new(phlaremodel.Tree)
+	expected.InsertStack(1, "other", "my")
+	require.Equal(t, expected.String(), r.String())
+}
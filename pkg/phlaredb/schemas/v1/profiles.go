@@ -41,6 +41,8 @@ var (
 		phlareparquet.NewGroupField("Period", parquet.Optional(parquet.Int(64))),
 		phlareparquet.NewGroupField("Comments", parquet.List(stringRef)),
 		phlareparquet.NewGroupField("DefaultSampleType", parquet.Optional(parquet.Int(64))),
+		phlareparquet.NewGroupField("AdditionalValues", parquet.List(parquet.Encoded(parquet.Int(64), &parquet.DeltaBinaryPacked))),
+		phlareparquet.NewGroupField("AdditionalTotalValues", parquet.List(parquet.Encoded(parquet.Int(64), &parquet.DeltaBinaryPacked))),
 	})
 
 	maxProfileRow               parquet.Row
@@ -123,6 +125,13 @@ type Profile struct {
 	// Index into the string table of the type of the preferred sample
 	// value. If unset, clients should default to the last sample value.
 	DefaultSampleType int64 `parquet:",optional"`
+	// The values of the additional profile types of multi-value profiles,
+	// see Samples.AdditionalValues. The values of the i-th additional
+	// profile type are stored at [i*len(Samples), (i+1)*len(Samples)).
+	AdditionalValues []int64 `parquet:",list"`
+	// AdditionalTotalValues is the sum of the values of each additional
+	// profile type of a multi-value profile.
+	AdditionalTotalValues []int64 `parquet:",list"`
 }
 
 func (p Profile) Timestamp() model.Time {
@@ -239,6 +248,9 @@ type InMemoryProfile struct {
 	// Index into the string table of the type of the preferred sample
 	// value. If unset, clients should default to the last sample value.
 	DefaultSampleType int64
+	// AdditionalTotalValues is the sum of the values of each additional
+	// profile type of a multi-value profile.
+	AdditionalTotalValues []uint64
 
 	Samples Samples
 }
@@ -246,6 +258,14 @@ type InMemoryProfile struct {
 type Samples struct {
 	StacktraceIDs []uint32
 	Values        []uint64
+	// Spans holds the span ID of each sample, zero if the sample does not
+	// belong to a span. Nil if no sample of the profile belongs to a span.
+	Spans []uint64
+	// AdditionalValues holds the values of the additional profile types
+	// of a multi-value profile: AdditionalValues[i][j] is the value of the
+	// i-th additional profile type of the j-th sample. Nil for profiles
+	// of a single profile type.
+	AdditionalValues [][]uint64
 }
 
 func NewSamples(size int) Samples {
@@ -290,49 +310,119 @@ func trimDuplicateSamples(samples Samples) Samples {
 	sort.Sort(samples)
 	n := 0
 	for j := 1; j < len(samples.StacktraceIDs); j++ {
-		if samples.StacktraceIDs[n] == samples.StacktraceIDs[j] {
+		if samples.StacktraceIDs[n] == samples.StacktraceIDs[j] && (samples.Spans == nil || samples.Spans[n] == samples.Spans[j]) {
 			samples.Values[n] += samples.Values[j]
+			for _, values := range samples.AdditionalValues {
+				values[n] += values[j]
+			}
 		} else {
 			n++
 			samples.StacktraceIDs[n] = samples.StacktraceIDs[j]
 			samples.Values[n] = samples.Values[j]
+			if samples.Spans != nil {
+				samples.Spans[n] = samples.Spans[j]
+			}
+			for _, values := range samples.AdditionalValues {
+				values[n] = values[j]
+			}
 		}
 	}
-	return Samples{
-		StacktraceIDs: samples.StacktraceIDs[:n+1],
-		Values:        samples.Values[:n+1],
+	return samples.slice(n + 1)
+}
+
+// slice returns the first n samples.
+func (s Samples) slice(n int) Samples {
+	r := Samples{
+		StacktraceIDs: s.StacktraceIDs[:n],
+		Values:        s.Values[:n],
+	}
+	if s.Spans != nil {
+		r.Spans = s.Spans[:n]
+	}
+	if s.AdditionalValues != nil {
+		r.AdditionalValues = make([][]uint64, len(s.AdditionalValues))
+		for i, values := range s.AdditionalValues {
+			r.AdditionalValues[i] = values[:n]
+		}
 	}
+	return r
 }
 
 func trimZeroAndNegativeSamples(samples Samples) Samples {
 	n := 0
 	for j, v := range samples.Values {
-		if v > 0 {
+		if v > 0 || samples.hasAdditionalValue(j) {
 			samples.Values[n] = v
 			samples.StacktraceIDs[n] = samples.StacktraceIDs[j]
+			if samples.Spans != nil {
+				samples.Spans[n] = samples.Spans[j]
+			}
+			for _, values := range samples.AdditionalValues {
+				values[n] = values[j]
+			}
 			n++
 		}
 	}
-	return Samples{
-		StacktraceIDs: samples.StacktraceIDs[:n],
-		Values:        samples.Values[:n],
+	return samples.slice(n)
+}
+
+// hasAdditionalValue reports whether any additional value
+// of the j-th sample is positive.
+func (s Samples) hasAdditionalValue(j int) bool {
+	for _, values := range s.AdditionalValues {
+		if values[j] > 0 {
+			return true
+		}
 	}
+	return false
 }
 
 func cloneSamples(samples Samples) Samples {
-	return Samples{
+	s := Samples{
 		StacktraceIDs: copySlice(samples.StacktraceIDs),
 		Values:        copySlice(samples.Values),
 	}
+	if samples.Spans != nil {
+		s.Spans = copySlice(samples.Spans)
+	}
+	if samples.AdditionalValues != nil {
+		s.AdditionalValues = make([][]uint64, len(samples.AdditionalValues))
+		for i, values := range samples.AdditionalValues {
+			s.AdditionalValues[i] = copySlice(values)
+		}
+	}
+	return s
 }
 
 func (s Samples) Less(i, j int) bool {
-	return s.StacktraceIDs[i] < s.StacktraceIDs[j]
+	if s.StacktraceIDs[i] != s.StacktraceIDs[j] || s.Spans == nil {
+		return s.StacktraceIDs[i] < s.StacktraceIDs[j]
+	}
+	return s.Spans[i] < s.Spans[j]
 }
 
 func (s Samples) Swap(i, j int) {
 	s.StacktraceIDs[i], s.StacktraceIDs[j] = s.StacktraceIDs[j], s.StacktraceIDs[i]
 	s.Values[i], s.Values[j] = s.Values[j], s.Values[i]
+	if s.Spans != nil {
+		s.Spans[i], s.Spans[j] = s.Spans[j], s.Spans[i]
+	}
+	for _, values := range s.AdditionalValues {
+		values[i], values[j] = values[j], values[i]
+	}
+}
+
+// ValuesOf returns the values of the profile type at the given value
+// index: 0 for the sample values, i+1 for the i-th additional profile
+// type of a multi-value profile.
+func (s Samples) ValuesOf(valueIndex int) []uint64 {
+	if valueIndex == 0 {
+		return s.Values
+	}
+	if valueIndex > len(s.AdditionalValues) {
+		return nil
+	}
+	return s.AdditionalValues[valueIndex-1]
 }
 
 func (s Samples) Len() int {
@@ -347,6 +437,21 @@ func (s Samples) Sum() uint64 {
 	return sum
 }
 
+// AdditionalSums returns the sum of the values of each additional
+// profile type, and nil for profiles of a single profile type.
+func (s Samples) AdditionalSums() []uint64 {
+	if s.AdditionalValues == nil {
+		return nil
+	}
+	sums := make([]uint64, len(s.AdditionalValues))
+	for i, values := range s.AdditionalValues {
+		for _, v := range values {
+			sums[i] += v
+		}
+	}
+	return sums
+}
+
 // TODO(kolesnikovae): Consider map alternatives.
 
 // SampleMap is a map of partitioned samples structured
@@ -385,7 +490,10 @@ func (m SampleMap) WriteSamples(partition uint64, dst *Samples) {
 const profileSize = uint64(unsafe.Sizeof(InMemoryProfile{}))
 
 func (p InMemoryProfile) Size() uint64 {
-	size := profileSize + uint64(cap(p.Comments)*8)
+	size := profileSize + uint64(cap(p.Comments)*8) + uint64(cap(p.Samples.Spans)*8) + uint64(cap(p.AdditionalTotalValues)*8)
+	for _, values := range p.Samples.AdditionalValues {
+		size += uint64(cap(values) * 8)
+	}
 	// 4 bytes for stacktrace id and 8 bytes for each stacktrace value
 	return size + uint64(cap(p.Samples.StacktraceIDs)*(4+8))
 }
@@ -422,7 +530,7 @@ func deconstructMemoryProfile(imp InMemoryProfile, row parquet.Row) parquet.Row
 			col++
 			return col
 		}
-		totalCols = 8 + (6 * len(imp.Samples.StacktraceIDs)) + len(imp.Comments)
+		totalCols = 10 + ((6 + len(imp.Samples.AdditionalValues)) * len(imp.Samples.StacktraceIDs)) + len(imp.Comments) + len(imp.AdditionalTotalValues)
 	)
 	if cap(row) < totalCols {
 		row = make(parquet.Row, 0, totalCols)
@@ -454,17 +562,29 @@ func deconstructMemoryProfile(imp InMemoryProfile, row parquet.Row) parquet.Row
 		}
 		row = append(row, parquet.Int64Value(int64(imp.Samples.Values[i])).Level(repetition, 1, col))
 	}
+	// The span ID is stored as the only label of the sample, see SampleSpanIDs.
 	for i := 0; i < 4; i++ {
 		newCol()
 		repetition := -1
 		if len(imp.Samples.Values) == 0 {
 			row = append(row, parquet.Value{}.Level(0, 0, col))
 		}
-		for range imp.Samples.Values {
+		for j := range imp.Samples.Values {
 			if repetition < 1 {
 				repetition++
 			}
-			row = append(row, parquet.Value{}.Level(repetition, 1, col))
+			if imp.Samples.Spans == nil || imp.Samples.Spans[j] == 0 {
+				row = append(row, parquet.Value{}.Level(repetition, 1, col))
+				continue
+			}
+			switch i {
+			case 0: // Key
+				row = append(row, parquet.Int64Value(0).Level(repetition, 2, col))
+			case 2: // Num
+				row = append(row, parquet.Int64Value(int64(imp.Samples.Spans[j])).Level(repetition, 3, col))
+			default: // Str, NumUnit
+				row = append(row, parquet.Value{}.Level(repetition, 2, col))
+			}
 		}
 	}
 	if imp.DropFrames == 0 {
@@ -504,6 +624,30 @@ func deconstructMemoryProfile(imp InMemoryProfile, row parquet.Row) parquet.Row
 	} else {
 		row = append(row, parquet.Int64Value(imp.DefaultSampleType).Level(0, 1, newCol()))
 	}
+	newCol()
+	if len(imp.Samples.AdditionalValues) == 0 || len(imp.Samples.Values) == 0 {
+		row = append(row, parquet.Value{}.Level(0, 0, col))
+	}
+	repetition = -1
+	for _, values := range imp.Samples.AdditionalValues {
+		for i := range values {
+			if repetition < 1 {
+				repetition++
+			}
+			row = append(row, parquet.Int64Value(int64(values[i])).Level(repetition, 1, col))
+		}
+	}
+	newCol()
+	if len(imp.AdditionalTotalValues) == 0 {
+		row = append(row, parquet.Value{}.Level(0, 0, col))
+	}
+	repetition = -1
+	for i := range imp.AdditionalTotalValues {
+		if repetition < 1 {
+			repetition++
+		}
+		row = append(row, parquet.Int64Value(int64(imp.AdditionalTotalValues[i])).Level(repetition, 1, col))
+	}
 	return row
 }
 
@@ -540,6 +684,60 @@ func lessProfileRows(r1, r2 parquet.Row) bool {
 	return ts1 < ts2
 }
 
+// SpanIDColumnPath is the path of the column holding the span IDs of the
+// samples. Sample labels are not resolved against a string table: the span
+// ID is stored as the only label of the sample, in the Num field.
+const SpanIDColumnPath = "Samples.list.element.Labels.list.element.Num"
+
+// SampleSpanIDs decodes the span IDs of the samples of a profile from the
+// values of the SpanIDColumnPath column, and appends them to dst. The span
+// ID is zero for the samples that do not belong to a span.
+func SampleSpanIDs(dst []uint64, values []parquet.Value) []uint64 {
+	for _, v := range values {
+		if v.IsNull() {
+			dst = append(dst, 0)
+			continue
+		}
+		dst = append(dst, uint64(v.Int64()))
+	}
+	return dst
+}
+
+const (
+	// AdditionalValuesColumnPath is the path of the column holding the
+	// values of the additional profile types of multi-value profiles.
+	AdditionalValuesColumnPath = "AdditionalValues.list.element"
+	// AdditionalTotalValuesColumnPath is the path of the column holding
+	// the total values of the additional profile types.
+	AdditionalTotalValuesColumnPath = "AdditionalTotalValues.list.element"
+)
+
+// AdditionalValues returns the values of the profile type at the value
+// index of a multi-value profile, from the values of the
+// AdditionalValuesColumnPath column of a profile of n samples.
+// It returns nil if the profile does not hold the profile type.
+func AdditionalValues(values []parquet.Value, valueIndex, n int) []parquet.Value {
+	lo, hi := (valueIndex-1)*n, valueIndex*n
+	if valueIndex < 1 || n == 0 || hi > len(values) || values[lo].IsNull() {
+		return nil
+	}
+	return values[lo:hi]
+}
+
+// ConvertProfilesRowGroup converts a row group of a profiles table
+// written with an earlier version of the schema to ProfilesSchema.
+// The profiles of these row groups have no additional values.
+func ConvertProfilesRowGroup(rowGroup parquet.RowGroup) (parquet.RowGroup, error) {
+	if _, ok := rowGroup.Schema().Lookup("AdditionalTotalValues"); ok {
+		return rowGroup, nil
+	}
+	conv, err := parquet.Convert(ProfilesSchema, rowGroup.Schema())
+	if err != nil {
+		return nil, err
+	}
+	return parquet.ConvertRowGroup(rowGroup, conv), nil
+}
+
 type ProfileRow parquet.Row
 
 func (p ProfileRow) SeriesIndex() uint32 {
@@ -561,6 +759,16 @@ func (p ProfileRow) TimeNanos() int64 {
 	return ts
 }
 
+// Size returns the approximate size of the profile, estimated
+// the same way as InMemoryProfile.Size.
+func (p ProfileRow) Size() uint64 {
+	var samples int
+	p.ForStacktraceIDsValues(func(values []parquet.Value) {
+		samples = len(values)
+	})
+	return profileSize + uint64(samples*(4+8))
+}
+
 func (p ProfileRow) SetSeriesIndex(v uint32) {
 	p[seriesIndexColIndex] = parquet.Int32Value(int32(v)).Level(0, 0, seriesIndexColIndex)
 }
@@ -80,6 +80,29 @@ func TestRoundtripProfile(t *testing.T) {
 		require.Equal(t, expected, actual)
 	})
 
+	t.Run("AdditionalValues", func(t *testing.T) {
+		profiles := generateProfiles(2)
+		inMemoryProfiles := generateMemoryProfiles(2)
+		for i, p := range profiles {
+			additional := make([][]uint64, 2)
+			for j := range additional {
+				additional[j] = make([]uint64, samplesPerProfile)
+				for k := range additional[j] {
+					additional[j][k] = uint64((j + 1) * k)
+					p.AdditionalValues = append(p.AdditionalValues, int64((j+1)*k))
+				}
+				p.AdditionalTotalValues = append(p.AdditionalTotalValues, int64(j+1))
+				inMemoryProfiles[i].AdditionalTotalValues = append(inMemoryProfiles[i].AdditionalTotalValues, uint64(j+1))
+			}
+			inMemoryProfiles[i].Samples.AdditionalValues = additional
+		}
+		expected, err := phlareparquet.ReadAll(NewProfilesRowReader(profiles))
+		require.NoError(t, err)
+		actual, err := phlareparquet.ReadAll(NewInMemoryProfilesRowReader(inMemoryProfiles))
+		require.NoError(t, err)
+		require.Equal(t, expected, actual)
+	})
+
 	t.Run("EmptySamples", func(t *testing.T) {
 		profiles := generateProfiles(1)
 		for _, p := range profiles {
@@ -123,6 +146,18 @@ func TestCompactSamples(t *testing.T) {
 	})
 }
 
+func TestCompactSamples_AdditionalValues(t *testing.T) {
+	require.Equal(t, Samples{
+		StacktraceIDs:    []uint32{3, 1, 2, 1, 4},
+		Values:           []uint64{1, 1, 0, 2, 0},
+		AdditionalValues: [][]uint64{{10, 20, 30, 40, 0}},
+	}.Compact(true), Samples{
+		StacktraceIDs:    []uint32{1, 2, 3},
+		Values:           []uint64{3, 0, 1},
+		AdditionalValues: [][]uint64{{60, 30, 10}},
+	})
+}
+
 func BenchmarkRowReader(b *testing.B) {
 	profiles := generateProfiles(1000)
 	iprofiles := generateMemoryProfiles(1000)
@@ -99,7 +99,9 @@ func newProfiles() []*Profile {
 					},
 				},
 			},
-			Comments: []int64{},
+			Comments:              []int64{},
+			AdditionalValues:      []int64{},
+			AdditionalTotalValues: []int64{},
 		},
 		{
 			ID:          uuid.MustParse("00000000-0000-0000-0000-000000000001"),
@@ -119,7 +121,9 @@ func newProfiles() []*Profile {
 					},
 				},
 			},
-			Comments: []int64{},
+			Comments:              []int64{},
+			AdditionalValues:      []int64{},
+			AdditionalTotalValues: []int64{},
 		},
 		{
 			ID:          uuid.MustParse("00000000-0000-0000-0000-000000000002"),
@@ -132,7 +136,9 @@ func newProfiles() []*Profile {
 					Labels:       []*profilev1.Label{},
 				},
 			},
-			Comments: []int64{},
+			Comments:              []int64{},
+			AdditionalValues:      []int64{},
+			AdditionalTotalValues: []int64{},
 		},
 		{
 			ID:          uuid.MustParse("00000000-0000-0000-0000-000000000002"),
@@ -145,7 +151,9 @@ func newProfiles() []*Profile {
 					Labels:       []*profilev1.Label{},
 				},
 			},
-			Comments: []int64{},
+			Comments:              []int64{},
+			AdditionalValues:      []int64{},
+			AdditionalTotalValues: []int64{},
 		},
 	}
 }
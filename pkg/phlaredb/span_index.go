@@ -0,0 +1,273 @@
+package phlaredb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/bloom"
+
+	"github.com/grafana/pyroscope/pkg/iter"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
+	"github.com/grafana/pyroscope/pkg/phlaredb/block"
+	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+)
+
+// The span index holds a bloom filter of the span IDs of the samples
+// of each row group of the profiles table. It allows span selector
+// queries to skip the row groups that do not include any of the spans.
+//
+// The file is laid out as follows, integers are big endian:
+//
+//	| magic (4) | version (1) | row groups (4) |
+//	| rows (8) | filter size (4) | filter | ... for each row group
+//	| crc32 castagnoli of the preceding bytes (4) |
+//
+// A row group without spans has an empty filter.
+const (
+	spanIndexMagic        = "SPAN"
+	spanIndexFormatV1     = 1
+	spanIndexBitsPerValue = 10
+)
+
+var (
+	spanIndexCastagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errSpanIndexCorrupted = errors.New("span index corrupted")
+)
+
+type spanIndex struct {
+	rowGroups []spanIndexRowGroup
+}
+
+type spanIndexRowGroup struct {
+	numRows int64
+	filter  bloom.SplitBlockFilter
+}
+
+func newSpanIndexRowGroup(numRows int64, spans map[uint64]struct{}) spanIndexRowGroup {
+	rg := spanIndexRowGroup{numRows: numRows}
+	if len(spans) == 0 {
+		return rg
+	}
+	n := bloom.NumSplitBlocksOf(int64(len(spans)), spanIndexBitsPerValue)
+	rg.filter = bloom.MakeSplitBlockFilter(make([]byte, n*bloom.BlockSize))
+	for id := range spans {
+		rg.filter.Insert(spanIDHash(id))
+	}
+	return rg
+}
+
+func spanIDHash(id uint64) uint64 { return bloom.XXH64{}.Sum64Uint64(id) }
+
+// mayContain reports whether any of the spans may be present in the row group.
+func (rg *spanIndexRowGroup) mayContain(spans phlaremodel.SpanSelector) bool {
+	if len(rg.filter) == 0 {
+		return false
+	}
+	for id := range spans {
+		if rg.filter.Check(spanIDHash(id)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (idx *spanIndex) MarshalBinary() ([]byte, error) {
+	size := len(spanIndexMagic) + 1 + 4 + 4
+	for _, rg := range idx.rowGroups {
+		size += 8 + 4 + len(rg.filter)*bloom.BlockSize
+	}
+	b := make([]byte, 0, size)
+	b = append(b, spanIndexMagic...)
+	b = append(b, spanIndexFormatV1)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(idx.rowGroups)))
+	for _, rg := range idx.rowGroups {
+		b = binary.BigEndian.AppendUint64(b, uint64(rg.numRows))
+		b = binary.BigEndian.AppendUint32(b, uint32(len(rg.filter)*bloom.BlockSize))
+		b = append(b, rg.filter.Bytes()...)
+	}
+	return binary.BigEndian.AppendUint32(b, crc32.Checksum(b, spanIndexCastagnoli)), nil
+}
+
+func (idx *spanIndex) UnmarshalBinary(b []byte) error {
+	if len(b) < len(spanIndexMagic)+1+4+4 || string(b[:len(spanIndexMagic)]) != spanIndexMagic {
+		return errSpanIndexCorrupted
+	}
+	n := len(b) - 4
+	if crc32.Checksum(b[:n], spanIndexCastagnoli) != binary.BigEndian.Uint32(b[n:]) {
+		return fmt.Errorf("%w: checksum mismatch", errSpanIndexCorrupted)
+	}
+	if v := b[len(spanIndexMagic)]; v != spanIndexFormatV1 {
+		return fmt.Errorf("unsupported span index version %d", v)
+	}
+	b = b[len(spanIndexMagic)+1 : n]
+	numRowGroups := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	idx.rowGroups = make([]spanIndexRowGroup, 0, numRowGroups)
+	for i := uint32(0); i < numRowGroups; i++ {
+		if len(b) < 8+4 {
+			return errSpanIndexCorrupted
+		}
+		rg := spanIndexRowGroup{numRows: int64(binary.BigEndian.Uint64(b))}
+		size := int(binary.BigEndian.Uint32(b[8:]))
+		b = b[8+4:]
+		if len(b) < size || size%bloom.BlockSize != 0 {
+			return errSpanIndexCorrupted
+		}
+		if size > 0 {
+			// The filter is copied to keep it aligned.
+			rg.filter = bloom.MakeSplitBlockFilter(append(make([]byte, 0, size), b[:size]...))
+		}
+		b = b[size:]
+		idx.rowGroups = append(idx.rowGroups, rg)
+	}
+	if len(b) != 0 {
+		return errSpanIndexCorrupted
+	}
+	return nil
+}
+
+// writeSpanIndex builds the span index of the profiles table file and
+// writes it to the block directory. The index is written even if there
+// are no spans in the block, so that span queries can skip it entirely.
+func writeSpanIndex(profilesPath, blockPath string) (block.File, error) {
+	f, err := os.Open(profilesPath)
+	if err != nil {
+		return block.File{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return block.File{}, err
+	}
+	pf, err := parquet.OpenFile(f, stat.Size(), parquet.SkipPageIndex(true), parquet.SkipBloomFilters(true))
+	if err != nil {
+		return block.File{}, err
+	}
+	col, ok := pf.Schema().Lookup(strings.Split(schemav1.SpanIDColumnPath, ".")...)
+	if !ok {
+		return block.File{}, fmt.Errorf("column %s not found", schemav1.SpanIDColumnPath)
+	}
+
+	var idx spanIndex
+	spans := make(map[uint64]struct{})
+	for _, rg := range pf.RowGroups() {
+		for id := range spans {
+			delete(spans, id)
+		}
+		if err = readSpanIDs(rg.ColumnChunks()[col.ColumnIndex], spans); err != nil {
+			return block.File{}, fmt.Errorf("reading span ids: %w", err)
+		}
+		idx.rowGroups = append(idx.rowGroups, newSpanIndexRowGroup(rg.NumRows(), spans))
+	}
+
+	b, _ := idx.MarshalBinary()
+	if err = os.WriteFile(filepath.Join(blockPath, block.SpanIndexFilename), b, 0o644); err != nil {
+		return block.File{}, err
+	}
+	return block.File{
+		RelPath:   block.SpanIndexFilename,
+		SizeBytes: uint64(len(b)),
+	}, nil
+}
+
+func readSpanIDs(chunk parquet.ColumnChunk, spans map[uint64]struct{}) error {
+	pages := chunk.Pages()
+	defer pages.Close()
+	var values []parquet.Value
+	for {
+		page, err := pages.ReadPage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n := int(page.NumValues()); cap(values) < n {
+			values = make([]parquet.Value, n)
+		}
+		n, err := page.Values().ReadValues(values[:cap(values)])
+		parquet.Release(page)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for _, v := range values[:n] {
+			if !v.IsNull() && v.Int64() != 0 {
+				spans[uint64(v.Int64())] = struct{}{}
+			}
+		}
+	}
+}
+
+func openSpanIndex(ctx context.Context, bucket phlareobj.BucketReader, size uint64) (*spanIndex, error) {
+	f, err := bucket.Get(ctx, block.SpanIndexFilename)
+	if err != nil {
+		return nil, fmt.Errorf("opening span index: %w", err)
+	}
+	defer f.Close()
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err = io.Copy(buf, f); err != nil {
+		return nil, fmt.Errorf("reading span index: %w", err)
+	}
+	var idx spanIndex
+	if err = idx.UnmarshalBinary(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return &idx, nil
+}
+
+// filterRows returns an iterator that skips the rows of the row groups
+// that do not include any of the spans. The rows are not filtered if the
+// index does not match the row groups of the profiles table.
+func (idx *spanIndex) filterRows(rowGroups []parquet.RowGroup, rows iter.Iterator[Profile], spans phlaremodel.SpanSelector) iter.Iterator[Profile] {
+	if len(idx.rowGroups) != len(rowGroups) {
+		return rows
+	}
+	it := &spanIndexRowIterator{
+		Iterator: rows,
+		bounds:   make([]int64, len(rowGroups)),
+		matches:  make([]bool, len(rowGroups)),
+	}
+	var offset int64
+	for i, rg := range idx.rowGroups {
+		if rg.numRows != rowGroups[i].NumRows() {
+			return rows
+		}
+		offset += rg.numRows
+		it.bounds[i] = offset
+		it.matches[i] = rg.mayContain(spans)
+	}
+	return it
+}
+
+type spanIndexRowIterator struct {
+	iter.Iterator[Profile]
+	// bounds holds the end row number (exclusive) of each row group.
+	bounds  []int64
+	matches []bool
+}
+
+func (it *spanIndexRowIterator) Next() bool {
+	for it.Iterator.Next() {
+		if it.keep(it.Iterator.At().(BlockProfile).RowNum) {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *spanIndexRowIterator) keep(rowNum int64) bool {
+	i := sort.Search(len(it.bounds), func(i int) bool { return rowNum < it.bounds[i] })
+	return i < len(it.matches) && it.matches[i]
+}
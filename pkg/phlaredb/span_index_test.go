@@ -0,0 +1,97 @@
+package phlaredb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/objstore/providers/filesystem"
+	pprofth "github.com/grafana/pyroscope/pkg/pprof/testhelper"
+)
+
+func Test_SpanIndex_Marshal(t *testing.T) {
+	idx := spanIndex{rowGroups: []spanIndexRowGroup{
+		newSpanIndexRowGroup(10, map[uint64]struct{}{1: {}, 2: {}}),
+		newSpanIndexRowGroup(5, nil),
+		newSpanIndexRowGroup(1, map[uint64]struct{}{3: {}}),
+	}}
+	b, err := idx.MarshalBinary()
+	require.NoError(t, err)
+
+	var actual spanIndex
+	require.NoError(t, actual.UnmarshalBinary(b))
+	require.Equal(t, idx, actual)
+	require.True(t, actual.rowGroups[0].mayContain(phlaremodel.SpanSelector{2: {}}))
+	require.False(t, actual.rowGroups[1].mayContain(phlaremodel.SpanSelector{2: {}}))
+	require.True(t, actual.rowGroups[2].mayContain(phlaremodel.SpanSelector{2: {}, 3: {}}))
+
+	b[len(b)/2] ^= 0xff
+	require.ErrorIs(t, actual.UnmarshalBinary(b), errSpanIndexCorrupted)
+	require.ErrorIs(t, actual.UnmarshalBinary(b[:4]), errSpanIndexCorrupted)
+}
+
+func TestHeadFlushSpanIndex(t *testing.T) {
+	head := newTestHead(t)
+	// force profiles to be written to separate row groups
+	head.profiles.cfg = &ParquetConfig{MaxRowGroupBytes: 128000, MaxBufferRowCount: 1}
+	ctx := context.Background()
+	spans := []string{"", "00000000000000aa", "00000000000000bb"}
+	for i, span := range spans {
+		p := pprofth.NewProfileBuilder(int64(i+1) * 1e9).CPUProfile()
+		p.ForStacktraceString("my", "other").AddSamples(1)
+		if span != "" {
+			p.ForStacktraceString("my", "other", "stack").AddSpanSamples(span, 2)
+		}
+		require.NoError(t, head.Ingest(ctx, p.Profile, p.UUID, p.Labels...))
+		// wait for the row group to be flushed
+		for head.profiles.flushing.Load() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	require.NoError(t, head.Flush(ctx))
+	require.NoError(t, head.Move())
+	b, err := filesystem.NewBucket(filepath.Dir(head.localPath))
+	require.NoError(t, err)
+	q := NewBlockQuerier(ctx, b)
+	require.NoError(t, q.Sync(ctx))
+	require.Len(t, q.queriers, 1)
+	bq := q.queriers[0]
+	require.NoError(t, bq.Open(ctx))
+	require.NotNil(t, bq.spans)
+	require.Len(t, bq.spans.rowGroups, len(spans))
+
+	req := &ingestv1.SelectProfilesRequest{
+		LabelSelector: `{}`,
+		Type:          mustParseProfileSelector(t, "process_cpu:cpu:nanoseconds:cpu:nanoseconds"),
+		Start:         int64(model.TimeFromUnixNano(0)),
+		End:           int64(model.TimeFromUnixNano(int64(time.Minute))),
+	}
+	selector, err := phlaremodel.NewSpanSelector([]string{"aa"})
+	require.NoError(t, err)
+
+	// Only the row group of the span is read.
+	profiles, err := bq.SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	rows := bq.spans.filterRows(bq.profiles.file.RowGroups(), profiles, selector)
+	var n int
+	for rows.Next() {
+		n++
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, 1, n)
+
+	profiles, err = bq.SelectMatchingProfiles(ctx, req)
+	require.NoError(t, err)
+	r, err := bq.MergeBySpans(ctx, profiles, selector)
+	require.NoError(t, err)
+	expected := new(phlaremodel.Tree)
+	expected.InsertStack(2, "stack", "other", "my")
+	require.Equal(t, expected.String(), r.String())
+}
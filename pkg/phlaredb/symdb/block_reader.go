@@ -20,6 +20,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/objstore"
 	"github.com/grafana/pyroscope/pkg/phlaredb/block"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 )
 
 type Reader struct {
@@ -182,6 +183,7 @@ func (r *Reader) partition(ctx context.Context, partition uint64) (*partition, e
 	if err := p.init(ctx); err != nil {
 		return nil, err
 	}
+	stats.FromContext(ctx).AddLoadedPartitions(1)
 	return p, nil
 }
 
@@ -29,6 +29,9 @@ func (r *Reader) loadStacktraces(ctx context.Context) error {
 		return err
 	}
 
+	if len(r.partitions) == 0 {
+		return nil
+	}
 	partitions := make([]*partition, len(r.partitions))
 	var size int64
 	var i int
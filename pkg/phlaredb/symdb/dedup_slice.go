@@ -12,6 +12,7 @@ import (
 	"go.uber.org/atomic"
 
 	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	schemav1 "github.com/grafana/pyroscope/pkg/phlaredb/schemas/v1"
 )
 
@@ -23,6 +24,39 @@ var (
 )
 
 func (p *PartitionWriter) WriteProfileSymbols(profile *profilev1.Profile) []schemav1.InMemoryProfile {
+	samplesPerType := p.convertSamples(p.writeSymbols(profile), profile.Sample, sampleSpanIDs(profile))
+	profiles := make([]schemav1.InMemoryProfile, len(samplesPerType))
+	for idxType := range samplesPerType {
+		profiles[idxType] = p.newInMemoryProfile(profile, samplesPerType[idxType])
+	}
+	return profiles
+}
+
+// WriteMultiValueProfileSymbols writes the symbols of the profile and
+// returns a single profile holding the values of all its sample types:
+// the values of the first one, and the additional values of the others.
+func (p *PartitionWriter) WriteMultiValueProfileSymbols(profile *profilev1.Profile) schemav1.InMemoryProfile {
+	samples := p.convertMultiValueSamples(p.writeSymbols(profile), profile.Sample, sampleSpanIDs(profile))
+	return p.newInMemoryProfile(profile, samples)
+}
+
+func (p *PartitionWriter) newInMemoryProfile(profile *profilev1.Profile, samples schemav1.Samples) schemav1.InMemoryProfile {
+	return schemav1.InMemoryProfile{
+		StacktracePartition: p.header.Partition,
+		Samples:             samples,
+		DropFrames:          profile.DropFrames,
+		KeepFrames:          profile.KeepFrames,
+		TimeNanos:           profile.TimeNanos,
+		DurationNanos:       profile.DurationNanos,
+		Period:              profile.Period,
+		Comments:            copySlice(profile.Comment),
+		DefaultSampleType:   profile.DefaultSampleType,
+	}
+}
+
+// writeSymbols writes the strings, mappings, functions and locations
+// of the profile, and returns the rewriter of their references.
+func (p *PartitionWriter) writeSymbols(profile *profilev1.Profile) *rewriter {
 	// create a rewriter state
 	rewrites := &rewriter{}
 
@@ -75,51 +109,82 @@ func (p *PartitionWriter) WriteProfileSymbols(profile *profilev1.Profile) []sche
 	}
 
 	p.locations.ingest(locs, rewrites)
-	samplesPerType := p.convertSamples(rewrites, profile.Sample)
-
-	profiles := make([]schemav1.InMemoryProfile, len(samplesPerType))
-	for idxType := range samplesPerType {
-		profiles[idxType] = schemav1.InMemoryProfile{
-			StacktracePartition: p.header.Partition,
-			Samples:             samplesPerType[idxType],
-			DropFrames:          profile.DropFrames,
-			KeepFrames:          profile.KeepFrames,
-			TimeNanos:           profile.TimeNanos,
-			DurationNanos:       profile.DurationNanos,
-			Comments:            copySlice(profile.Comment),
-			DefaultSampleType:   profile.DefaultSampleType,
-		}
-	}
-
-	return profiles
+	return rewrites
 }
 
-func (p *PartitionWriter) convertSamples(r *rewriter, in []*profilev1.Sample) []schemav1.Samples {
+func (p *PartitionWriter) convertSamples(r *rewriter, in []*profilev1.Sample, spans []uint64) []schemav1.Samples {
 	if len(in) == 0 {
 		return nil
 	}
 
 	// populate output
-	var (
-		out            = make([]schemav1.Samples, len(in[0].Value))
-		stacktraces    = make([]*schemav1.Stacktrace, len(in))
-		stacktracesIds = uint32SlicePool.Get()
-	)
-
+	out := make([]schemav1.Samples, len(in[0].Value))
 	for idxType := range out {
 		out[idxType] = schemav1.Samples{
 			Values:        make([]uint64, len(in)),
 			StacktraceIDs: make([]uint32, len(in)),
 		}
+		if spans != nil {
+			out[idxType].Spans = copySlice(spans)
+		}
 	}
-
 	for idxSample := range in {
-		// populate samples
 		for idxType := range out {
 			out[idxType].Values[idxSample] = uint64(in[idxSample].Value[idxType])
 		}
+	}
+
+	stacktracesIds := p.appendStacktraces(r, in)
+	defer uint32SlicePool.Put(stacktracesIds)
 
-		// build full stack traces
+	// reference stacktraces
+	for idxType := range out {
+		copy(out[idxType].StacktraceIDs, stacktracesIds)
+		out[idxType] = compactSamples(out[idxType])
+	}
+
+	return out
+}
+
+func (p *PartitionWriter) convertMultiValueSamples(r *rewriter, in []*profilev1.Sample, spans []uint64) schemav1.Samples {
+	if len(in) == 0 {
+		return schemav1.Samples{}
+	}
+
+	out := schemav1.Samples{
+		Values:           make([]uint64, len(in)),
+		StacktraceIDs:    make([]uint32, len(in)),
+		AdditionalValues: make([][]uint64, len(in[0].Value)-1),
+		Spans:            spans,
+	}
+	for i := range out.AdditionalValues {
+		out.AdditionalValues[i] = make([]uint64, len(in))
+	}
+	for idxSample, sample := range in {
+		out.Values[idxSample] = uint64(sample.Value[0])
+		for i := range out.AdditionalValues {
+			out.AdditionalValues[i][idxSample] = uint64(sample.Value[i+1])
+		}
+	}
+
+	stacktracesIds := p.appendStacktraces(r, in)
+	defer uint32SlicePool.Put(stacktracesIds)
+	copy(out.StacktraceIDs, stacktracesIds)
+
+	return compactSamples(out)
+}
+
+// appendStacktraces appends the stack traces of the samples to the
+// partition and returns their IDs. The returned slice is to be put
+// back to uint32SlicePool.
+func (p *PartitionWriter) appendStacktraces(r *rewriter, in []*profilev1.Sample) []uint32 {
+	var (
+		stacktraces    = make([]*schemav1.Stacktrace, len(in))
+		stacktracesIds = uint32SlicePool.Get()
+	)
+
+	// build full stack traces
+	for idxSample := range in {
 		stacktraces[idxSample] = &schemav1.Stacktrace{
 			// no copySlice necessary at this point,stacktracesHelper.clone
 			// will copy it, if it is required to be retained.
@@ -134,23 +199,50 @@ func (p *PartitionWriter) convertSamples(r *rewriter, in []*profilev1.Sample) []
 		stacktracesIds = make([]uint32, len(stacktraces))
 	}
 	stacktracesIds = stacktracesIds[:len(stacktraces)]
-	defer uint32SlicePool.Put(stacktracesIds)
-
 	p.stacktraces.append(stacktracesIds, stacktraces)
+	return stacktracesIds
+}
 
-	// reference stacktraces
-	for idxType := range out {
-		for idxSample := range out[idxType].StacktraceIDs {
-			out[idxType].StacktraceIDs[idxSample] = stacktracesIds[int64(idxSample)]
+func compactSamples(s schemav1.Samples) schemav1.Samples {
+	compacted := s.Compact(true)
+	if compacted.Len() != s.Len() {
+		compacted = compacted.Clone()
+	}
+	return compacted
+}
+
+// sampleSpanIDs returns the span IDs of the samples, taken from the span ID
+// sample label, or nil if no sample belongs to a span. Invalid span IDs are
+// ignored.
+func sampleSpanIDs(profile *profilev1.Profile) []uint64 {
+	key := int64(-1)
+	for i, s := range profile.StringTable {
+		if s == phlaremodel.SpanIDLabelName {
+			key = int64(i)
+			break
 		}
-		compacted := out[idxType].Compact(true)
-		if compacted.Len() != out[idxType].Len() {
-			compacted = compacted.Clone()
+	}
+	if key < 0 {
+		return nil
+	}
+	var spans []uint64
+	for i, s := range profile.Sample {
+		for _, l := range s.Label {
+			if l.Key != key || l.Str <= 0 || l.Str >= int64(len(profile.StringTable)) {
+				continue
+			}
+			id, err := phlaremodel.ParseSpanID(profile.StringTable[l.Str])
+			if err != nil {
+				continue
+			}
+			if spans == nil {
+				spans = make([]uint64, len(profile.Sample))
+			}
+			spans[i] = id
+			break
 		}
-		out[idxType] = compacted
 	}
-
-	return out
+	return spans
 }
 
 func copySlice[T any](in []T) []T {
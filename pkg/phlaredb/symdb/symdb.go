@@ -178,6 +178,10 @@ func (s *SymDB) WriteProfileSymbols(partition uint64, profile *profilev1.Profile
 	return s.PartitionWriter(partition).WriteProfileSymbols(profile)
 }
 
+func (s *SymDB) WriteMultiValueProfileSymbols(partition uint64, profile *profilev1.Profile) schemav1.InMemoryProfile {
+	return s.PartitionWriter(partition).WriteMultiValueProfileSymbols(profile)
+}
+
 func (s *SymDB) Partition(_ context.Context, partition uint64) (PartitionReader, error) {
 	if p, ok := s.lookupPartition(partition); ok {
 		return p, nil
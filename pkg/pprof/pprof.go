@@ -558,7 +558,7 @@ func (p *Profile) visitAllNameReferences(fn func(*int64)) {
 	for _, s := range p.Sample {
 		for _, l := range s.Label {
 			fn(&l.Key)
-			fn(&l.Num)
+			fn(&l.Str)
 			fn(&l.NumUnit)
 		}
 	}
@@ -1,8 +1,11 @@
 package pprof
 
 import (
+	"bytes"
+	"fmt"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -98,6 +101,38 @@ func TestNormalizeProfile(t *testing.T) {
 	})
 }
 
+func TestNormalizeProfile_SampleLabels(t *testing.T) {
+	p := &profilev1.Profile{
+		SampleType: []*profilev1.ValueType{{Type: 1, Unit: 2}},
+		Sample: []*profilev1.Sample{
+			{LocationId: []uint64{1}, Value: []int64{1}, Label: []*profilev1.Label{{Key: 5, Str: 6}, {Key: 7, Num: 42}}},
+			// This sample is dropped along with its function.
+			{LocationId: []uint64{2}, Value: []int64{0}},
+		},
+		Mapping: []*profilev1.Mapping{{Id: 1, HasFunctions: true}},
+		Location: []*profilev1.Location{
+			{Id: 1, MappingId: 1, Line: []*profilev1.Line{{FunctionId: 1}}},
+			{Id: 2, MappingId: 1, Line: []*profilev1.Line{{FunctionId: 2}}},
+		},
+		Function: []*profilev1.Function{
+			{Id: 1, Name: 3},
+			{Id: 2, Name: 4},
+		},
+		StringTable: []string{"", "cpu", "nanoseconds", "main", "foo", "span_id", "0000000000000001", "n"},
+		PeriodType:  &profilev1.ValueType{Type: 1, Unit: 2},
+	}
+
+	pf := &Profile{Profile: p}
+	pf.Normalize()
+	require.Len(t, pf.Sample, 1)
+	labels := pf.Sample[0].Label
+	require.Len(t, labels, 2)
+	require.Equal(t, "span_id", pf.StringTable[labels[0].Key])
+	require.Equal(t, "0000000000000001", pf.StringTable[labels[0].Str])
+	require.Equal(t, "n", pf.StringTable[labels[1].Key])
+	require.Equal(t, int64(42), labels[1].Num)
+}
+
 func TestFromProfile(t *testing.T) {
 	out, err := FromProfile(testhelper.FooBarProfile)
 	require.NoError(t, err)
@@ -109,6 +144,103 @@ func TestFromProfile(t *testing.T) {
 	require.Equal(t, testhelper.FooBarProfile, outProfile)
 }
 
+func TestWriteChunks(t *testing.T) {
+	p, err := OpenFile("testdata/heap")
+	require.NoError(t, err)
+	p.TimeNanos = 1
+	p.DurationNanos = 2
+	p.Comment = []int64{3}
+
+	for _, chunkSize := range []int{1, 100, 1 << 20} {
+		var buf bytes.Buffer
+		var chunks int
+		require.NoError(t, WriteChunks(p.Profile, chunkSize, func(b []byte) error {
+			chunks++
+			_, err := buf.Write(b)
+			return err
+		}))
+		if chunkSize < buf.Len() {
+			require.Greater(t, chunks, 1)
+		}
+		actual := new(profilev1.Profile)
+		require.NoError(t, actual.UnmarshalVT(buf.Bytes()))
+		require.True(t, proto.Equal(p.Profile, actual))
+	}
+}
+
+func TestMergeWriter(t *testing.T) {
+	profiles := make([]*profile.Profile, 2)
+	for i := range profiles {
+		p, err := OpenFile("testdata/heap")
+		require.NoError(t, err)
+		b, err := p.MarshalVT()
+		require.NoError(t, err)
+		profiles[i], err = profile.ParseUncompressed(b)
+		require.NoError(t, err)
+	}
+	expected, err := profile.Merge(profiles)
+	require.NoError(t, err)
+
+	for _, chunkSize := range []int{1, 100, 1 << 20} {
+		var buf bytes.Buffer
+		w := NewMergeWriter(chunkSize, func(b []byte) error {
+			_, err := buf.Write(b)
+			return err
+		})
+		for range profiles {
+			p, err := OpenFile("testdata/heap")
+			require.NoError(t, err)
+			require.NoError(t, w.Write(p.Profile))
+		}
+		require.NoError(t, w.Write(&profilev1.Profile{}))
+		require.NoError(t, w.Close(expected.TimeNanos, expected.DurationNanos))
+
+		actual, err := profile.ParseUncompressed(buf.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, expected.SampleType, actual.SampleType)
+		require.Equal(t, expected.PeriodType, actual.PeriodType)
+		require.Equal(t, expected.Period, actual.Period)
+		require.Equal(t, expected.TimeNanos, actual.TimeNanos)
+		require.Len(t, actual.Function, len(expected.Function))
+		require.Len(t, actual.Location, len(expected.Location))
+		require.Len(t, actual.Mapping, len(expected.Mapping))
+		require.Len(t, actual.Sample, 2*len(profiles[0].Sample))
+		require.Equal(t, stackValues(expected), stackValues(actual))
+	}
+}
+
+func TestMergeWriter_SampleTypeMismatch(t *testing.T) {
+	p, err := OpenFile("testdata/heap")
+	require.NoError(t, err)
+	w := NewMergeWriter(DefaultChunkSize, func([]byte) error { return nil })
+	require.NoError(t, w.Write(p.Profile))
+	require.Error(t, w.Write(testhelper.NewProfileBuilder(0).CPUProfile().
+		ForStacktraceString("foo", "bar").AddSamples(1).Profile))
+}
+
+// stackValues returns the sum of the sample values by stack trace and labels.
+func stackValues(p *profile.Profile) map[string][]int64 {
+	values := make(map[string][]int64)
+	for _, s := range p.Sample {
+		var key strings.Builder
+		for _, l := range s.Location {
+			for _, line := range l.Line {
+				fmt.Fprintf(&key, "%s:%d;", line.Function.Name, line.Line)
+			}
+		}
+		fmt.Fprintf(&key, "%v%v", s.Label, s.NumLabel)
+		v, ok := values[key.String()]
+		if !ok {
+			v = make([]int64, len(s.Value))
+			values[key.String()] = v
+		}
+		for i := range s.Value {
+			v[i] += s.Value[i]
+		}
+	}
+	return values
+}
+
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 func RandStringBytes(n int) string {
@@ -0,0 +1,403 @@
+package pprof
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	profilev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+)
+
+// DefaultChunkSize is the default size of the chunks produced by WriteChunks.
+const DefaultChunkSize = 1 << 20
+
+// Field numbers of the google.v1.Profile message.
+const (
+	profileSampleTypeField  protowire.Number = 1
+	profileSampleField      protowire.Number = 2
+	profileMappingField     protowire.Number = 3
+	profileLocationField    protowire.Number = 4
+	profileFunctionField    protowire.Number = 5
+	profileStringTableField protowire.Number = 6
+)
+
+type vtMessage interface {
+	SizeVT() int
+	MarshalToSizedBufferVT([]byte) (int, error)
+}
+
+// WriteChunks writes the uncompressed protobuf encoding of the profile
+// incrementally, in chunks of approximately chunkSize bytes: the encoding
+// of the profile is never held in full in memory. The string table, mappings,
+// functions and locations are written first, samples follow, and the rest
+// of the profile fields are written last. The concatenation of the chunks
+// is a valid pprof profile.
+//
+// The chunk passed to fn is only valid until fn returns.
+func WriteChunks(p *profilev1.Profile, chunkSize int, fn func([]byte) error) error {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	w := chunkWriter{
+		buf:  make([]byte, 0, chunkSize),
+		size: chunkSize,
+		fn:   fn,
+	}
+	for _, s := range p.StringTable {
+		w.buf = protowire.AppendTag(w.buf, profileStringTableField, protowire.BytesType)
+		w.buf = protowire.AppendString(w.buf, s)
+		if err := w.flushFull(); err != nil {
+			return err
+		}
+	}
+	for _, m := range p.Mapping {
+		if err := w.writeMessage(profileMappingField, m); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.Function {
+		if err := w.writeMessage(profileFunctionField, f); err != nil {
+			return err
+		}
+	}
+	for _, l := range p.Location {
+		if err := w.writeMessage(profileLocationField, l); err != nil {
+			return err
+		}
+	}
+	for _, t := range p.SampleType {
+		if err := w.writeMessage(profileSampleTypeField, t); err != nil {
+			return err
+		}
+	}
+	for _, s := range p.Sample {
+		if err := w.writeMessage(profileSampleField, s); err != nil {
+			return err
+		}
+	}
+	// The remaining fields are small: they are marshaled at once.
+	rest := &profilev1.Profile{
+		DropFrames:        p.DropFrames,
+		KeepFrames:        p.KeepFrames,
+		TimeNanos:         p.TimeNanos,
+		DurationNanos:     p.DurationNanos,
+		PeriodType:        p.PeriodType,
+		Period:            p.Period,
+		Comment:           p.Comment,
+		DefaultSampleType: p.DefaultSampleType,
+	}
+	if err := w.appendMessage(rest); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// MergeWriter merges profiles into the uncompressed protobuf encoding of a
+// single profile, written in chunks of approximately chunkSize bytes as the
+// profiles are added. The symbols of a profile not written yet are written
+// first, followed by its samples: only the symbols are retained, and the
+// merged profile is never built in memory. Samples of the same stack trace
+// added with different profiles are written as distinct samples, pprof
+// readers sum them up.
+//
+// All the profiles must have the same sample types. MergeWriter is not safe
+// for concurrent use.
+type MergeWriter struct {
+	w chunkWriter
+
+	sampleType        []*profilev1.ValueType
+	periodType        *profilev1.ValueType
+	period            int64
+	defaultSampleType int64
+
+	strings   map[string]int64
+	mappings  map[mappingKey]uint64
+	functions map[functionKey]uint64
+	locations map[string]uint64
+
+	// The symbols of the profile being written, by their ID in the profile.
+	stringIDs   []int64
+	mappingIDs  map[uint64]uint64
+	functionIDs map[uint64]uint64
+	locationIDs map[uint64]uint64
+	key         []byte
+}
+
+type mappingKey struct {
+	memoryStart, memoryLimit, fileOffset uint64
+	filename, buildID                    int64
+}
+
+type functionKey struct {
+	name, systemName, filename, startLine int64
+}
+
+var errStringIndex = errors.New("string index out of range")
+
+// NewMergeWriter returns a MergeWriter calling fn with the chunks written.
+// The chunk passed to fn is only valid until fn returns.
+func NewMergeWriter(chunkSize int, fn func([]byte) error) *MergeWriter {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	w := &MergeWriter{
+		w: chunkWriter{
+			buf:  make([]byte, 0, chunkSize),
+			size: chunkSize,
+			fn:   fn,
+		},
+		strings:     make(map[string]int64),
+		mappings:    make(map[mappingKey]uint64),
+		functions:   make(map[functionKey]uint64),
+		locations:   make(map[string]uint64),
+		mappingIDs:  make(map[uint64]uint64),
+		functionIDs: make(map[uint64]uint64),
+		locationIDs: make(map[uint64]uint64),
+	}
+	// The first string of the table must be empty.
+	w.writeString("")
+	return w
+}
+
+// Write merges the profile into the output. Profiles without sample types
+// are empty and skipped. Write modifies the symbols of the profile, which
+// must not be used afterwards.
+func (m *MergeWriter) Write(p *profilev1.Profile) error {
+	if len(p.SampleType) == 0 {
+		return nil
+	}
+	m.stringIDs = m.stringIDs[:0]
+	for _, s := range p.StringTable {
+		id, ok := m.strings[s]
+		if !ok {
+			id = m.writeString(s)
+			if err := m.w.flushFull(); err != nil {
+				return err
+			}
+		}
+		m.stringIDs = append(m.stringIDs, id)
+	}
+	// An out of range string index fails the write once all the symbols
+	// of the profile have been remapped.
+	var strErr error
+	str := func(i int64) int64 {
+		if i < 0 || i >= int64(len(m.stringIDs)) {
+			strErr = errStringIndex
+			return 0
+		}
+		return m.stringIDs[i]
+	}
+	if err := m.writeSampleTypes(p, str); err != nil {
+		return err
+	}
+
+	for k := range m.mappingIDs {
+		delete(m.mappingIDs, k)
+	}
+	for _, x := range p.Mapping {
+		x.Filename = str(x.Filename)
+		x.BuildId = str(x.BuildId)
+		k := mappingKey{
+			memoryStart: x.MemoryStart,
+			memoryLimit: x.MemoryLimit,
+			fileOffset:  x.FileOffset,
+			filename:    x.Filename,
+			buildID:     x.BuildId,
+		}
+		id, ok := m.mappings[k]
+		if !ok {
+			id = uint64(len(m.mappings) + 1)
+			m.mappings[k] = id
+		}
+		m.mappingIDs[x.Id] = id
+		if ok {
+			continue
+		}
+		x.Id = id
+		if err := m.w.writeMessage(profileMappingField, x); err != nil {
+			return err
+		}
+	}
+
+	for k := range m.functionIDs {
+		delete(m.functionIDs, k)
+	}
+	for _, x := range p.Function {
+		x.Name = str(x.Name)
+		x.SystemName = str(x.SystemName)
+		x.Filename = str(x.Filename)
+		k := functionKey{
+			name:       x.Name,
+			systemName: x.SystemName,
+			filename:   x.Filename,
+			startLine:  x.StartLine,
+		}
+		id, ok := m.functions[k]
+		if !ok {
+			id = uint64(len(m.functions) + 1)
+			m.functions[k] = id
+		}
+		m.functionIDs[x.Id] = id
+		if ok {
+			continue
+		}
+		x.Id = id
+		if err := m.w.writeMessage(profileFunctionField, x); err != nil {
+			return err
+		}
+	}
+
+	for k := range m.locationIDs {
+		delete(m.locationIDs, k)
+	}
+	for _, x := range p.Location {
+		x.MappingId = m.mappingIDs[x.MappingId]
+		m.key = binary.AppendUvarint(m.key[:0], x.MappingId)
+		m.key = binary.AppendUvarint(m.key, x.Address)
+		if x.IsFolded {
+			m.key = append(m.key, 1)
+		}
+		for _, l := range x.Line {
+			l.FunctionId = m.functionIDs[l.FunctionId]
+			m.key = binary.AppendUvarint(m.key, l.FunctionId)
+			m.key = binary.AppendVarint(m.key, l.Line)
+		}
+		id, ok := m.locations[string(m.key)]
+		if !ok {
+			id = uint64(len(m.locations) + 1)
+			m.locations[string(m.key)] = id
+		}
+		m.locationIDs[x.Id] = id
+		if ok {
+			continue
+		}
+		x.Id = id
+		if err := m.w.writeMessage(profileLocationField, x); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range p.Sample {
+		for i, id := range s.LocationId {
+			s.LocationId[i] = m.locationIDs[id]
+		}
+		for _, l := range s.Label {
+			l.Key = str(l.Key)
+			l.Str = str(l.Str)
+			l.NumUnit = str(l.NumUnit)
+		}
+		if err := m.w.writeMessage(profileSampleField, s); err != nil {
+			return err
+		}
+	}
+	return strErr
+}
+
+func (m *MergeWriter) writeSampleTypes(p *profilev1.Profile, str func(int64) int64) error {
+	sampleType := make([]*profilev1.ValueType, len(p.SampleType))
+	for i, t := range p.SampleType {
+		sampleType[i] = &profilev1.ValueType{Type: str(t.Type), Unit: str(t.Unit)}
+	}
+	if p.Period > m.period {
+		m.period = p.Period
+	}
+	if m.sampleType != nil {
+		if len(sampleType) != len(m.sampleType) {
+			return fmt.Errorf("profiles have different sample types: %d and %d", len(m.sampleType), len(sampleType))
+		}
+		for i, t := range sampleType {
+			if t.Type != m.sampleType[i].Type || t.Unit != m.sampleType[i].Unit {
+				return fmt.Errorf("profiles have different sample types at index %d", i)
+			}
+		}
+		return nil
+	}
+	m.sampleType = sampleType
+	if p.PeriodType != nil {
+		m.periodType = &profilev1.ValueType{Type: str(p.PeriodType.Type), Unit: str(p.PeriodType.Unit)}
+	}
+	m.defaultSampleType = str(p.DefaultSampleType)
+	for _, t := range sampleType {
+		if err := m.w.writeMessage(profileSampleTypeField, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MergeWriter) writeString(s string) int64 {
+	id := int64(len(m.strings))
+	m.strings[s] = id
+	m.w.buf = protowire.AppendTag(m.w.buf, profileStringTableField, protowire.BytesType)
+	m.w.buf = protowire.AppendString(m.w.buf, s)
+	return id
+}
+
+// Close writes the remaining fields of the merged profile, and flushes
+// the last chunk.
+func (m *MergeWriter) Close(timeNanos, durationNanos int64) error {
+	if err := m.w.flushFull(); err != nil {
+		return err
+	}
+	rest := &profilev1.Profile{
+		TimeNanos:         timeNanos,
+		DurationNanos:     durationNanos,
+		PeriodType:        m.periodType,
+		Period:            m.period,
+		DefaultSampleType: m.defaultSampleType,
+	}
+	if err := m.w.appendMessage(rest); err != nil {
+		return err
+	}
+	return m.w.flush()
+}
+
+type chunkWriter struct {
+	buf  []byte
+	size int
+	fn   func([]byte) error
+}
+
+func (w *chunkWriter) writeMessage(num protowire.Number, m vtMessage) error {
+	w.buf = protowire.AppendTag(w.buf, num, protowire.BytesType)
+	w.buf = protowire.AppendVarint(w.buf, uint64(m.SizeVT()))
+	if err := w.appendMessage(m); err != nil {
+		return err
+	}
+	return w.flushFull()
+}
+
+func (w *chunkWriter) appendMessage(m vtMessage) error {
+	size := m.SizeVT()
+	n := len(w.buf)
+	w.buf = grow(w.buf, size)
+	_, err := m.MarshalToSizedBufferVT(w.buf[n : n+size])
+	return err
+}
+
+func (w *chunkWriter) flushFull() error {
+	if len(w.buf) < w.size {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.fn(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+func grow(b []byte, n int) []byte {
+	if cap(b)-len(b) >= n {
+		return b[:len(b)+n]
+	}
+	g := make([]byte, len(b)+n, 2*cap(b)+n)
+	copy(g, b)
+	return g
+}
@@ -213,3 +213,13 @@ func (s *StacktraceBuilder) AddSamples(samples ...int64) *ProfileBuilder {
 	})
 	return s.ProfileBuilder
 }
+
+// AddSpanSamples adds samples labeled with the ID of the span they belong to.
+func (s *StacktraceBuilder) AddSpanSamples(spanID string, samples ...int64) *ProfileBuilder {
+	s.AddSamples(samples...)
+	s.Profile.Sample[len(s.Profile.Sample)-1].Label = []*profilev1.Label{{
+		Key: s.addString(phlaremodel.SpanIDLabelName),
+		Str: s.addString(spanID),
+	}}
+	return s.ProfileBuilder
+}
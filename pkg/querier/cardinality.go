@@ -0,0 +1,155 @@
+package querier
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/ring"
+	"github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/sync/errgroup"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util/spanlogger"
+)
+
+// LabelCardinality returns the cardinality of the labels of the series
+// matching the given set of matchers within the time range. If the time
+// range is not set, the last hour is queried.
+func (q *Querier) LabelCardinality(ctx context.Context, req *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "LabelCardinality")
+	defer func() {
+		sp.LogFields(
+			otlog.String("matchers", strings.Join(req.Msg.Matchers, ",")),
+			otlog.String("start", model.Time(req.Msg.Start).Time().String()),
+			otlog.String("end", model.Time(req.Msg.End).Time().String()),
+		)
+		sp.Finish()
+	}()
+
+	msg := req.Msg.CloneVT()
+	if msg.End == 0 {
+		msg.End = int64(model.Now())
+	}
+	if msg.Start == 0 {
+		msg.Start = int64(model.Time(msg.End).Add(-time.Hour))
+	}
+	if msg.Start > msg.End {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start must be before end"))
+	}
+	for _, m := range msg.Matchers {
+		if _, err := parser.ParseMetricSelector(m); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+
+	m := phlaremodel.NewLabelCardinalityMerger()
+	// no store gateways configured so just query the ingesters
+	if q.storeGatewayQuerier == nil {
+		if err := q.labelCardinalityFromIngesters(ctx, labelCardinalitySourceRequest(msg, model.Time(msg.Start), model.Time(msg.End)), m); err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(m.LabelCardinality(msg)), nil
+	}
+
+	storeQueries := splitQueryToStores(model.Time(msg.Start), model.Time(msg.End), model.Now(), q.cfg.QueryStoreAfter)
+	if !storeQueries.ingester.shouldQuery && !storeQueries.storeGateway.shouldQuery {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start and end time are outside of the ingester and store gateway retention"))
+	}
+	storeQueries.Log(level.Debug(spanlogger.FromContext(ctx, q.logger)))
+
+	g, gCtx := errgroup.WithContext(ctx)
+	if storeQueries.ingester.shouldQuery {
+		g.Go(func() error {
+			return q.labelCardinalityFromIngesters(gCtx, labelCardinalitySourceRequest(msg, storeQueries.ingester.start, storeQueries.ingester.end), m)
+		})
+	}
+	if storeQueries.storeGateway.shouldQuery {
+		g.Go(func() error {
+			return q.labelCardinalityFromStoreGateway(gCtx, labelCardinalitySourceRequest(msg, storeQueries.storeGateway.start, storeQueries.storeGateway.end), m)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(m.LabelCardinality(msg)), nil
+}
+
+// labelCardinalitySourceRequest returns the request for ingesters and
+// store-gateways: all the label values are requested, to be merged.
+func labelCardinalitySourceRequest(req *typesv1.LabelCardinalityRequest, start, end model.Time) *typesv1.LabelCardinalityRequest {
+	return &typesv1.LabelCardinalityRequest{
+		Matchers: req.Matchers,
+		Start:    int64(start),
+		End:      int64(end),
+	}
+}
+
+func (q *Querier) labelCardinalityFromIngesters(ctx context.Context, req *typesv1.LabelCardinalityRequest, m *phlaremodel.LabelCardinalityMerger) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "LabelCardinality Ingesters")
+	defer sp.Finish()
+	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(childCtx context.Context, ic IngesterQueryClient) (*typesv1.LabelCardinalityResponse, error) {
+		res, err := ic.LabelCardinality(childCtx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	replicas := seriesReplicas(q.ingesterQuerier.tenantRing(ctx), len(responses))
+	for _, r := range responses {
+		m.MergeLabelCardinality(r.response, replicas)
+	}
+	return nil
+}
+
+func (q *Querier) labelCardinalityFromStoreGateway(ctx context.Context, req *typesv1.LabelCardinalityRequest, m *phlaremodel.LabelCardinalityMerger) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "LabelCardinality StoreGateway")
+	defer sp.Finish()
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	responses, err := forAllStoreGateways(ctx, tenantID, q.storeGatewayQuerier, func(childCtx context.Context, sc StoreGatewayQueryClient) (*typesv1.LabelCardinalityResponse, error) {
+		res, err := sc.LabelCardinality(childCtx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	replicas := seriesReplicas(GetShuffleShardingSubring(q.storeGatewayQuerier.ring, tenantID, q.storeGatewayQuerier.limits), len(responses))
+	for _, r := range responses {
+		m.MergeLabelCardinality(r.response, replicas)
+	}
+	return nil
+}
+
+// seriesReplicas returns the number of responses each series is expected
+// to be found in, if the given number of instances of the ring responded.
+func seriesReplicas(r ring.ReadRing, responses int) float64 {
+	instances := r.InstancesCount()
+	if instances == 0 {
+		return 1
+	}
+	rf := r.ReplicationFactor()
+	if rf > instances {
+		rf = instances
+	}
+	if responses > instances {
+		responses = instances
+	}
+	return float64(rf) * float64(responses) / float64(instances)
+}
@@ -0,0 +1,158 @@
+package querier
+
+import (
+	"context"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/sync/errgroup"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util/spanlogger"
+)
+
+// SelectExemplars lists the exemplars with the highest value matching the
+// selector within the time range, and counts all of them in the heatmap.
+// If the time range is not set, the last hour is queried.
+func (q *Querier) SelectExemplars(ctx context.Context, req *connect.Request[querierv1.SelectExemplarsRequest]) (*connect.Response[querierv1.SelectExemplarsResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectExemplars")
+	defer func() {
+		sp.LogFields(
+			otlog.String("profile_type", req.Msg.ProfileTypeID),
+			otlog.String("selector", req.Msg.LabelSelector),
+			otlog.String("start", model.Time(req.Msg.Start).Time().String()),
+			otlog.String("end", model.Time(req.Msg.End).Time().String()),
+		)
+		sp.Finish()
+	}()
+
+	msg := req.Msg.CloneVT()
+	if msg.End == 0 {
+		msg.End = int64(model.Now())
+	}
+	if msg.Start == 0 {
+		msg.Start = int64(model.Time(msg.End).Add(-time.Hour))
+	}
+	if msg.Start > msg.End {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start must be before end"))
+	}
+	if msg.MaxValue != 0 && msg.MinValue > msg.MaxValue {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("min value must not exceed max value"))
+	}
+	if msg.HeatmapTimeBuckets < 0 || msg.HeatmapValueBuckets < 0 {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("heatmap buckets must not be negative"))
+	}
+	if msg.Limit <= 0 {
+		msg.Limit = phlaremodel.DefaultExemplarsLimit
+	}
+	profileType, err := phlaremodel.ParseProfileTypeSelector(msg.ProfileTypeID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if _, err = parser.ParseMetricSelector(msg.LabelSelector); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	sourceRequest := func(start, end model.Time) *ingestv1.SelectExemplarsRequest {
+		return &ingestv1.SelectExemplarsRequest{
+			Request: &ingestv1.SelectProfilesRequest{
+				LabelSelector: msg.LabelSelector,
+				Type:          profileType,
+				Start:         int64(start),
+				End:           int64(end),
+			},
+			MinValue: msg.MinValue,
+			MaxValue: msg.MaxValue,
+		}
+	}
+
+	m := phlaremodel.NewExemplarsMerger()
+	// no store gateways configured so just query the ingesters
+	if q.storeGatewayQuerier == nil {
+		if err = q.exemplarsFromIngesters(ctx, sourceRequest(model.Time(msg.Start), model.Time(msg.End)), m); err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(exemplarsResponse(msg, m)), nil
+	}
+
+	storeQueries := splitQueryToStores(model.Time(msg.Start), model.Time(msg.End), model.Now(), q.cfg.QueryStoreAfter)
+	if !storeQueries.ingester.shouldQuery && !storeQueries.storeGateway.shouldQuery {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start and end time are outside of the ingester and store gateway retention"))
+	}
+	storeQueries.Log(level.Debug(spanlogger.FromContext(ctx, q.logger)))
+
+	g, gCtx := errgroup.WithContext(ctx)
+	if storeQueries.ingester.shouldQuery {
+		g.Go(func() error {
+			return q.exemplarsFromIngesters(gCtx, sourceRequest(storeQueries.ingester.start, storeQueries.ingester.end), m)
+		})
+	}
+	if storeQueries.storeGateway.shouldQuery {
+		g.Go(func() error {
+			return q.exemplarsFromStoreGateway(gCtx, sourceRequest(storeQueries.storeGateway.start, storeQueries.storeGateway.end), m)
+		})
+	}
+	if err = g.Wait(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(exemplarsResponse(msg, m)), nil
+}
+
+func exemplarsResponse(req *querierv1.SelectExemplarsRequest, m *phlaremodel.ExemplarsMerger) *querierv1.SelectExemplarsResponse {
+	resp := &querierv1.SelectExemplarsResponse{Exemplars: m.Exemplars(req.Limit)}
+	if req.HeatmapTimeBuckets > 0 && req.HeatmapValueBuckets > 0 {
+		resp.Heatmap = m.Heatmap(req.Start, req.End, req.MinValue, req.MaxValue, req.HeatmapTimeBuckets, req.HeatmapValueBuckets)
+	}
+	return resp
+}
+
+func (q *Querier) exemplarsFromIngesters(ctx context.Context, req *ingestv1.SelectExemplarsRequest, m *phlaremodel.ExemplarsMerger) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectExemplars Ingesters")
+	defer sp.Finish()
+	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(childCtx context.Context, ic IngesterQueryClient) (*ingestv1.SelectExemplarsResponse, error) {
+		res, err := ic.SelectExemplars(childCtx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range responses {
+		m.MergeExemplars(r.response.Exemplars)
+	}
+	return nil
+}
+
+func (q *Querier) exemplarsFromStoreGateway(ctx context.Context, req *ingestv1.SelectExemplarsRequest, m *phlaremodel.ExemplarsMerger) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectExemplars StoreGateway")
+	defer sp.Finish()
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	responses, err := forAllStoreGateways(ctx, tenantID, q.storeGatewayQuerier, func(childCtx context.Context, sc StoreGatewayQueryClient) (*ingestv1.SelectExemplarsResponse, error) {
+		res, err := sc.SelectExemplars(childCtx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range responses {
+		m.MergeExemplars(r.response.Exemplars)
+	}
+	return nil
+}
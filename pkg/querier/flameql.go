@@ -0,0 +1,141 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/prometheus/model/labels"
+
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/flameql"
+)
+
+// legacyProfileTypes maps the suffixes of the legacy Pyroscope application
+// names, e.g. cpu in my-app.cpu, to the profile types the profiles ingested
+// through /ingest are stored as. The order matters when a profile type is
+// mapped back to a suffix: the first match wins.
+var legacyProfileTypes = []struct {
+	suffix      string
+	name        string
+	sampleTypes []string
+}{
+	{suffix: "cpu", name: "process_cpu", sampleTypes: []string{"cpu", "samples"}},
+	{suffix: "itimer", name: "process_cpu", sampleTypes: []string{"cpu", "samples"}},
+	{suffix: "wall", name: "wall", sampleTypes: []string{"wall", "samples"}},
+	{suffix: "inuse_objects", name: "memory", sampleTypes: []string{"inuse_objects"}},
+	{suffix: "inuse_space", name: "memory", sampleTypes: []string{"inuse_space"}},
+	{suffix: "alloc_objects", name: "memory", sampleTypes: []string{"alloc_objects"}},
+	{suffix: "alloc_space", name: "memory", sampleTypes: []string{"alloc_space"}},
+	{suffix: "alloc_in_new_tlab_objects", name: "memory", sampleTypes: []string{"alloc_in_new_tlab_objects"}},
+	{suffix: "alloc_in_new_tlab_bytes", name: "memory", sampleTypes: []string{"alloc_in_new_tlab_bytes"}},
+	{suffix: "alloc_outside_tlab_objects", name: "memory", sampleTypes: []string{"alloc_outside_tlab_objects"}},
+	{suffix: "alloc_outside_tlab_bytes", name: "memory", sampleTypes: []string{"alloc_outside_tlab_bytes"}},
+	{suffix: "live", name: "memory", sampleTypes: []string{"live"}},
+	{suffix: "goroutines", name: "goroutine", sampleTypes: []string{"goroutines", "goroutine"}},
+	{suffix: "mutex_count", name: "mutex", sampleTypes: []string{"contentions"}},
+	{suffix: "mutex_duration", name: "mutex", sampleTypes: []string{"delay"}},
+	{suffix: "block_count", name: "block", sampleTypes: []string{"contentions"}},
+	{suffix: "block_duration", name: "block", sampleTypes: []string{"delay"}},
+	{suffix: "lock_count", name: "block", sampleTypes: []string{"contentions"}},
+	{suffix: "lock_duration", name: "block", sampleTypes: []string{"delay"}},
+	{suffix: "exceptions", name: "exceptions", sampleTypes: []string{"samples"}},
+}
+
+// isFlameQLQuery reports whether the query is a FlameQL query, e.g.
+// my-app.cpu{env="prod"}, rather than a profile type selector.
+func isFlameQLQuery(query string) bool {
+	name := query
+	if i := strings.IndexByte(query, '{'); i >= 0 {
+		name = query[:i]
+	}
+	name = strings.TrimSpace(name)
+	return name != "" && !strings.Contains(name, ":")
+}
+
+// parseFlameQLQuery translates a FlameQL query into a label selector and
+// the profile type the application name refers to.
+func parseFlameQLQuery(ctx context.Context, client querierProfileTypes, query string) (string, *typesv1.ProfileType, error) {
+	q, err := flameql.ParseQuery(query)
+	if err != nil {
+		return "", nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	app, profileType, err := resolveLegacyAppName(ctx, client, q.AppName)
+	if err != nil {
+		return "", nil, err
+	}
+	matchers := make([]*labels.Matcher, 0, len(q.Matchers)+1)
+	matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, phlaremodel.LabelNameServiceName, app))
+	for _, m := range q.Matchers {
+		matcher, err := labels.NewMatcher(flameQLMatchType(m.Op), m.Key, m.Value)
+		if err != nil {
+			return "", nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return convertMatchersToString(matchers), profileType, nil
+}
+
+type querierProfileTypes interface {
+	ProfileTypes(context.Context, *connect.Request[querierv1.ProfileTypesRequest]) (*connect.Response[querierv1.ProfileTypesResponse], error)
+}
+
+// resolveLegacyAppName splits a legacy application name into the service
+// name and the profile type, among the ones the queriers know about.
+func resolveLegacyAppName(ctx context.Context, client querierProfileTypes, appName string) (string, *typesv1.ProfileType, error) {
+	i := strings.LastIndexByte(appName, '.')
+	if i <= 0 {
+		return "", nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("application name %q has no profile type suffix", appName))
+	}
+	app, suffix := appName[:i], appName[i+1:]
+	res, err := client.ProfileTypes(ctx, connect.NewRequest(&querierv1.ProfileTypesRequest{}))
+	if err != nil {
+		return "", nil, err
+	}
+	for _, t := range legacyProfileTypes {
+		if t.suffix != suffix {
+			continue
+		}
+		for _, sampleType := range t.sampleTypes {
+			for _, p := range res.Msg.ProfileTypes {
+				if p.Name == t.name && p.SampleType == sampleType {
+					return app, p, nil
+				}
+			}
+		}
+		return "", nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("no profiles found for %q", appName))
+	}
+	return "", nil, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("unknown profile type %q", suffix))
+}
+
+// legacyAppNameSuffix returns the suffix of the legacy application names
+// of the profile type, e.g. cpu for process_cpu:cpu:nanoseconds:cpu:nanoseconds.
+func legacyAppNameSuffix(p *typesv1.ProfileType) string {
+	for _, t := range legacyProfileTypes {
+		if t.name != p.Name {
+			continue
+		}
+		for _, sampleType := range t.sampleTypes {
+			if sampleType == p.SampleType {
+				return t.suffix
+			}
+		}
+	}
+	return p.SampleType
+}
+
+func flameQLMatchType(op flameql.Op) labels.MatchType {
+	switch op {
+	case flameql.OpNotEqual:
+		return labels.MatchNotEqual
+	case flameql.OpEqualRegex:
+		return labels.MatchRegexp
+	case flameql.OpNotEqualRegex:
+		return labels.MatchNotRegexp
+	default:
+		return labels.MatchEqual
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/util/connectgrpc"
 )
 
-func NewGRPCRoundTripper(transport connectgrpc.GRPCRoundTripper) querierv1connect.QuerierServiceHandler {
+func NewGRPCRoundTripper(transport connectgrpc.GRPCRoundTripper) querierv1connect.QuerierServiceClient {
 	return querierv1connect.NewQuerierServiceClient(
 		connectgrpc.NewClient(transport),
 		"http://httpgrpc",
@@ -1,9 +1,12 @@
 package querier
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
 
@@ -19,24 +23,31 @@ import (
 	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/og/storage/metadata"
 	"github.com/grafana/pyroscope/pkg/og/structs/flamebearer"
 	"github.com/grafana/pyroscope/pkg/og/util/attime"
+	"github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/querier/timeline"
 )
 
-func NewHTTPHandlers(client querierv1connect.QuerierServiceClient) *QueryHandlers {
+func NewHTTPHandlers(client querierv1connect.QuerierServiceHandler) *QueryHandlers {
 	return &QueryHandlers{client}
 }
 
 type QueryHandlers struct {
-	client querierv1connect.QuerierServiceClient
+	client querierv1connect.QuerierServiceHandler
 }
 
 // LabelValues only returns the label values for the given label name.
 // This is mostly for fulfilling the pyroscope API and won't be used in the future.
 // For example, /label-values?label=__name__ will return all the profile types.
+// The values can be narrowed down with a query, e.g. query=my-app.cpu{env="prod"}.
 func (q *QueryHandlers) LabelValues(w http.ResponseWriter, req *http.Request) {
-	label := req.URL.Query().Get("label")
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	label := req.Form.Get("label")
 	if label == "" {
 		http.Error(w, "label parameter is required", http.StatusBadRequest)
 		return
@@ -53,7 +64,15 @@ func (q *QueryHandlers) LabelValues(w http.ResponseWriter, req *http.Request) {
 			res = append(res, t.ID)
 		}
 	} else {
-		response, err := q.client.LabelValues(req.Context(), connect.NewRequest(&typesv1.LabelValuesRequest{}))
+		matchers, err := q.parseMatchers(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response, err := q.client.LabelValues(req.Context(), connect.NewRequest(&typesv1.LabelValuesRequest{
+			Name:     label,
+			Matchers: matchers,
+		}))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -68,6 +87,334 @@ func (q *QueryHandlers) LabelValues(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// LabelNames returns the names of the labels of the series, except the
+// reserved ones, e.g. /label-names?query=my-app.cpu{env="prod"}.
+// This is mostly for fulfilling the pyroscope API.
+func (q *QueryHandlers) LabelNames(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	matchers, err := q.parseMatchers(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response, err := q.client.LabelNames(req.Context(), connect.NewRequest(&typesv1.LabelNamesRequest{
+		Matchers: matchers,
+	}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := make([]string, 0, len(response.Msg.Names))
+	for _, name := range response.Msg.Names {
+		if !strings.HasPrefix(name, "__") {
+			res = append(res, name)
+		}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type legacyApp struct {
+	Name    string `json:"name"`
+	SpyName string `json:"spyName,omitempty"`
+	Units   string `json:"units"`
+}
+
+// Apps lists the applications the way /api/apps of Pyroscope does: an
+// application is a service name and a profile type, e.g. my-app.cpu.
+func (q *QueryHandlers) Apps(w http.ResponseWriter, req *http.Request) {
+	response, err := q.client.Series(req.Context(), connect.NewRequest(&querierv1.SeriesRequest{
+		LabelNames: []string{phlaremodel.LabelNameServiceName, phlaremodel.LabelNameProfileType, "pyroscope_spy"},
+	}))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apps := make(map[string]legacyApp)
+	for _, s := range response.Msg.LabelsSet {
+		lbs := phlaremodel.Labels(s.Labels)
+		service := lbs.Get(phlaremodel.LabelNameServiceName)
+		profileType, err := phlaremodel.ParseProfileTypeSelector(lbs.Get(phlaremodel.LabelNameProfileType))
+		if service == "" || err != nil {
+			continue
+		}
+		app := legacyApp{
+			Name:    service + "." + legacyAppNameSuffix(profileType),
+			SpyName: lbs.Get("pyroscope_spy"),
+			Units:   string(metadata.Units(profileType.SampleUnit)),
+		}
+		if existing, ok := apps[app.Name]; !ok || existing.SpyName == "" {
+			apps[app.Name] = app
+		}
+	}
+	res := lo.Values(apps)
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// legacyTime is a point in time in the attime format, e.g. now-1h, or a
+// unix timestamp. The legacy clients send it both as a string and a number.
+type legacyTime string
+
+func (t *legacyTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = legacyTime(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*t = legacyTime(n.String())
+	return nil
+}
+
+type mergeRequest struct {
+	AppName    string     `json:"appName"`
+	Profiles   []string   `json:"profiles"`
+	ProfileIDs []string   `json:"profileIDs"`
+	StartTime  legacyTime `json:"startTime"`
+	EndTime    legacyTime `json:"endTime"`
+	MaxNodes   int64      `json:"maxNodes"`
+}
+
+type mergeResponse struct {
+	*flamebearer.FlamebearerProfile
+	MergeMetadata mergeMetadata `json:"mergeMetadata"`
+}
+
+type mergeMetadata struct {
+	AppName        string `json:"appName"`
+	StartTime      string `json:"startTime"`
+	EndTime        string `json:"endTime"`
+	ProfilesLength int    `json:"profilesLength"`
+}
+
+// Merge merges the span profiles, also known as exemplars, with the given
+// IDs, the way /merge and /exemplars:merge of Pyroscope do. The time range
+// defaults to the last hour.
+func (q *QueryHandlers) Merge(w http.ResponseWriter, req *http.Request) {
+	var mr mergeRequest
+	if err := json.NewDecoder(req.Body).Decode(&mr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profileIDs := append(mr.Profiles, mr.ProfileIDs...)
+	if len(profileIDs) == 0 {
+		http.Error(w, "profiles are required", http.StatusBadRequest)
+		return
+	}
+	if _, err := phlaremodel.NewSpanSelector(profileIDs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selector, profileType, err := parseFlameQLQuery(req.Context(), q.client, mr.AppName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if mr.StartTime == "" {
+		mr.StartTime = "now-1h"
+	}
+	start := model.TimeFromUnixNano(attime.Parse(string(mr.StartTime)).UnixNano())
+	end := model.TimeFromUnixNano(attime.Parse(string(mr.EndTime)).UnixNano())
+	r := &querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: profileType.ID,
+		LabelSelector: selector,
+		Start:         int64(start),
+		End:           int64(end),
+		SpanSelector:  profileIDs,
+	}
+	if mr.MaxNodes > 0 {
+		r.MaxNodes = &mr.MaxNodes
+	}
+	res, err := q.client.SelectMergeStacktraces(req.Context(), connect.NewRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(mergeResponse{
+		FlamebearerProfile: phlaremodel.ExportToFlamebearer(res.Msg.Flamegraph, profileType),
+		MergeMetadata: mergeMetadata{
+			AppName:        mr.AppName,
+			StartTime:      strconv.FormatInt(start.Unix(), 10),
+			EndTime:        strconv.FormatInt(end.Unix(), 10),
+			ProfilesLength: len(profileIDs),
+		},
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+const (
+	defaultHeatmapTimeBuckets  = 128
+	defaultHeatmapValueBuckets = 32
+)
+
+type exemplarsQueryResponse struct {
+	*flamebearer.FlamebearerProfile
+	Profiles []exemplarProfile `json:"profiles"`
+}
+
+type exemplarProfile struct {
+	ID string `json:"id"`
+	// Seconds since epoch.
+	Timestamp int64 `json:"timestamp"`
+	Value     int64 `json:"value"`
+}
+
+// ExemplarsQuery renders the heatmap of the values of the exemplars, i.e.
+// the span profiles, matching the query, the way /exemplars:query of
+// Pyroscope does. The flame graph merges the exemplars of the selection,
+// a region of the heatmap bounded by selectionStartTime, selectionEndTime,
+// selectionMinValue and selectionMaxValue, or the exemplars with the
+// highest value if there is no selection.
+func (q *QueryHandlers) ExemplarsQuery(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selectParams, profileType, err := q.parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	heatmapParams := &querierv1.SelectExemplarsRequest{
+		ProfileTypeID:       selectParams.ProfileTypeID,
+		LabelSelector:       selectParams.LabelSelector,
+		Start:               selectParams.Start,
+		End:                 selectParams.End,
+		HeatmapTimeBuckets:  defaultHeatmapTimeBuckets,
+		HeatmapValueBuckets: defaultHeatmapValueBuckets,
+	}
+	v := req.URL.Query()
+	for name, dst := range map[string]*int64{
+		"heatmapTimeBuckets":  &heatmapParams.HeatmapTimeBuckets,
+		"heatmapValueBuckets": &heatmapParams.HeatmapValueBuckets,
+		"minValue":            &heatmapParams.MinValue,
+		"maxValue":            &heatmapParams.MaxValue,
+		"limit":               &heatmapParams.Limit,
+	} {
+		if err = parseInt64Param(v, name, dst); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var selectionParams *querierv1.SelectExemplarsRequest
+	if v.Get("selectionStartTime") != "" || v.Get("selectionEndTime") != "" ||
+		v.Get("selectionMinValue") != "" || v.Get("selectionMaxValue") != "" {
+		selectionParams = heatmapParams.CloneVT()
+		selectionParams.HeatmapTimeBuckets = 0
+		selectionParams.HeatmapValueBuckets = 0
+		if s := v.Get("selectionStartTime"); s != "" {
+			selectionParams.Start = int64(model.TimeFromUnixNano(attime.Parse(s).UnixNano()))
+		}
+		if s := v.Get("selectionEndTime"); s != "" {
+			selectionParams.End = int64(model.TimeFromUnixNano(attime.Parse(s).UnixNano()))
+		}
+		if err = parseInt64Param(v, "selectionMinValue", &selectionParams.MinValue); err == nil {
+			err = parseInt64Param(v, "selectionMaxValue", &selectionParams.MaxValue)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var heatmap, selection *connect.Response[querierv1.SelectExemplarsResponse]
+	g, ctx := errgroup.WithContext(req.Context())
+	g.Go(func() (err error) {
+		heatmap, err = q.client.SelectExemplars(ctx, connect.NewRequest(heatmapParams))
+		return err
+	})
+	if selectionParams != nil {
+		g.Go(func() (err error) {
+			selection, err = q.client.SelectExemplars(ctx, connect.NewRequest(selectionParams))
+			return err
+		})
+	}
+	if err = g.Wait(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if selection == nil {
+		selection, selectionParams = heatmap, heatmapParams
+	}
+
+	// The exemplars are merged by their span ID. Without any exemplar, the
+	// span selector would be empty and select all the profiles.
+	var fg *querierv1.FlameGraph
+	profiles := make([]exemplarProfile, 0, len(selection.Msg.Exemplars))
+	spans := make([]string, 0, len(selection.Msg.Exemplars))
+	for _, e := range selection.Msg.Exemplars {
+		profiles = append(profiles, exemplarProfile{
+			ID:        e.ProfileId,
+			Timestamp: model.Time(e.Timestamp).Unix(),
+			Value:     e.Value,
+		})
+		spans = append(spans, e.ProfileId)
+	}
+	if len(spans) > 0 {
+		res, err := q.client.SelectMergeStacktraces(req.Context(), connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
+			ProfileTypeID: selectParams.ProfileTypeID,
+			LabelSelector: selectParams.LabelSelector,
+			Start:         selectionParams.Start,
+			End:           selectionParams.End,
+			MaxNodes:      selectParams.MaxNodes,
+			SpanSelector:  lo.Uniq(spans),
+		}))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fg = res.Msg.Flamegraph
+	}
+
+	fb := phlaremodel.ExportToFlamebearer(fg, profileType)
+	fb.Heatmap = phlaremodel.ExportHeatmapToFlamebearer(heatmap.Msg.Heatmap)
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(exemplarsQueryResponse{
+		FlamebearerProfile: fb,
+		Profiles:           profiles,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseInt64Param sets dst to the value of the parameter, if present.
+func parseInt64Param(v url.Values, name string, dst *int64) error {
+	s := v.Get(name)
+	if s == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid '%s': %w", name, err)
+	}
+	*dst = n
+	return nil
+}
+
 func (q *QueryHandlers) RenderDiff(w http.ResponseWriter, req *http.Request) {
 	if err := req.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -75,7 +422,7 @@ func (q *QueryHandlers) RenderDiff(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Left
-	leftSelectParams, leftProfileType, err := parseSelectProfilesRequest(renderRequestFieldNames{
+	leftSelectParams, leftProfileType, err := q.parseSelectProfilesRequest(renderRequestFieldNames{
 		query: "leftQuery",
 		from:  "leftFrom",
 		until: "leftUntil",
@@ -85,7 +432,7 @@ func (q *QueryHandlers) RenderDiff(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	rightSelectParams, rightProfileType, err := parseSelectProfilesRequest(renderRequestFieldNames{
+	rightSelectParams, rightProfileType, err := q.parseSelectProfilesRequest(renderRequestFieldNames{
 		query: "rightQuery",
 		from:  "rightFrom",
 		until: "rightUntil",
@@ -121,12 +468,22 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	selectParams, profileType, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	selectParams, profileType, err := q.parseSelectProfilesRequest(renderRequestFieldNames{}, req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	format := req.URL.Query().Get("format")
+	if format == "pprof" {
+		q.renderPprof(w, req, selectParams)
+		return
+	}
+	if format != "" && format != "json" {
+		q.renderTree(w, req, format, selectParams)
+		return
+	}
+
 	groupBy := req.URL.Query()["groupBy"]
 
 	var resFlame *connect.Response[querierv1.SelectMergeStacktracesResponse]
@@ -188,6 +545,74 @@ func (q *QueryHandlers) Render(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// renderTree renders the merged stack traces in one of the tree export
+// formats, e.g. /render?format=speedscope.
+func (q *QueryHandlers) renderTree(w http.ResponseWriter, req *http.Request, format string, selectParams *querierv1.SelectMergeStacktracesRequest) {
+	treeFormat, err := phlaremodel.ParseTreeFormat(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	selectParams.ExportFormat = string(treeFormat)
+	res, err := q.client.SelectMergeStacktraces(req.Context(), connect.NewRequest(selectParams))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", treeFormat.ContentType())
+	_, _ = w.Write(res.Msg.Export)
+}
+
+// mergeProfileWriter is implemented by the query services able to write the
+// merged profile as it is being merged.
+type mergeProfileWriter interface {
+	WriteMergeProfile(ctx context.Context, req *connect.Request[querierv1.SelectMergeProfileRequest], fn func([]byte) error) error
+}
+
+// renderPprof renders the merged profile in the pprof format, e.g.
+// /render?format=pprof. The response is written in chunks, as the
+// profile is being merged.
+func (q *QueryHandlers) renderPprof(w http.ResponseWriter, req *http.Request, selectParams *querierv1.SelectMergeStacktracesRequest) {
+	mergeReq := connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		ProfileTypeID: selectParams.ProfileTypeID,
+		LabelSelector: selectParams.LabelSelector,
+		Start:         selectParams.Start,
+		End:           selectParams.End,
+	})
+	writeMergeProfile := func(ctx context.Context, req *connect.Request[querierv1.SelectMergeProfileRequest], fn func([]byte) error) error {
+		res, err := q.client.SelectMergeProfile(ctx, req)
+		if err != nil {
+			return err
+		}
+		return pprof.WriteChunks(res.Msg, pprof.DefaultChunkSize, fn)
+	}
+	if mw, ok := q.client.(mergeProfileWriter); ok {
+		writeMergeProfile = mw.WriteMergeProfile
+	}
+
+	var written bool
+	flusher, _ := w.(http.Flusher)
+	err := writeMergeProfile(req.Context(), mergeReq, func(chunk []byte) error {
+		if !written {
+			w.Header().Add("Content-Type", "application/octet-stream")
+			w.Header().Add("Content-Disposition", "attachment; filename=profile.pb")
+			written = true
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	// Once a chunk has been written, the response status has already been
+	// sent: there is nothing left to do but to truncate the response.
+	if err != nil && !written {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 type renderRequestFieldNames struct {
 	query string
 	from  string
@@ -195,7 +620,7 @@ type renderRequestFieldNames struct {
 }
 
 // render/render?format=json&from=now-12h&until=now&query=pyroscope.server.cpu
-func parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Request) (*querierv1.SelectMergeStacktracesRequest, *typesv1.ProfileType, error) {
+func (q *QueryHandlers) parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Request) (*querierv1.SelectMergeStacktracesRequest, *typesv1.ProfileType, error) {
 	if fieldNames == (renderRequestFieldNames{}) {
 		fieldNames = renderRequestFieldNames{
 			query: "query",
@@ -203,7 +628,7 @@ func parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Re
 			until: "until",
 		}
 	}
-	selector, ptype, err := parseQuery(fieldNames.query, req)
+	selector, ptype, err := q.parseQuery(fieldNames.query, req)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -233,13 +658,19 @@ func parseSelectProfilesRequest(fieldNames renderRequestFieldNames, req *http.Re
 	return p, ptype, nil
 }
 
-func parseQuery(fieldName string, req *http.Request) (string, *typesv1.ProfileType, error) {
-	q := req.Form.Get(fieldName)
-	if q == "" {
+// parseQuery parses either a profile type selector, e.g.
+// process_cpu:cpu:nanoseconds:cpu:nanoseconds{service_name="my-app"}, or a
+// FlameQL query of the legacy Pyroscope API, e.g. my-app.cpu{env="prod"}.
+func (q *QueryHandlers) parseQuery(fieldName string, req *http.Request) (string, *typesv1.ProfileType, error) {
+	query := req.Form.Get(fieldName)
+	if query == "" {
 		return "", nil, fmt.Errorf("'%s' is required", fieldName)
 	}
+	if isFlameQLQuery(query) {
+		return parseFlameQLQuery(req.Context(), q.client, query)
+	}
 
-	parsedSelector, err := parser.ParseMetricSelector(q)
+	parsedSelector, err := parser.ParseMetricSelector(query)
 	if err != nil {
 		return "", nil, status.Error(codes.InvalidArgument, fmt.Sprintf("failed to parse '%s'", fieldName))
 	}
@@ -264,6 +695,23 @@ func parseQuery(fieldName string, req *http.Request) (string, *typesv1.ProfileTy
 	return convertMatchersToString(sel), profileSelector, nil
 }
 
+// parseMatchers returns the series selector of the optional query,
+// restricted to the profile type.
+func (q *QueryHandlers) parseMatchers(req *http.Request) ([]string, error) {
+	if req.Form.Get("query") == "" {
+		return nil, nil
+	}
+	selector, ptype, err := q.parseQuery("query", req)
+	if err != nil {
+		return nil, err
+	}
+	profileType := labels.MustNewMatcher(labels.MatchEqual, phlaremodel.LabelNameProfileType, ptype.ID).String()
+	if selector == "{}" {
+		return []string{"{" + profileType + "}"}, nil
+	}
+	return []string{"{" + profileType + "," + selector[1:]}, nil
+}
+
 func convertMatchersToString(matchers []*labels.Matcher) string {
 	out := strings.Builder{}
 	out.WriteRune('{')
@@ -1,16 +1,27 @@
 package querier
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/bufbuild/connect-go"
+	"github.com/google/pprof/profile"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
 
+	googlev1 "github.com/grafana/pyroscope/api/gen/proto/go/google/v1"
+	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
+	"github.com/grafana/pyroscope/api/gen/proto/go/querier/v1/querierv1connect"
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/pprof"
+	pprofth "github.com/grafana/pyroscope/pkg/pprof/testhelper"
 )
 
 func Test_ParseQuery(t *testing.T) {
@@ -24,7 +35,7 @@ func Test_ParseQuery(t *testing.T) {
 	require.NoError(t, err)
 	require.NoError(t, req.ParseForm())
 
-	queryRequest, ptype, err := parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	queryRequest, ptype, err := NewHTTPHandlers(nil).parseSelectProfilesRequest(renderRequestFieldNames{}, req)
 	require.NoError(t, err)
 	require.WithinDuration(t, time.Now(), model.Time(queryRequest.End).Time(), 1*time.Minute)
 	require.WithinDuration(t, time.Now().Add(-6*time.Hour), model.Time(queryRequest.Start).Time(), 1*time.Minute)
@@ -40,3 +51,230 @@ func Test_ParseQuery(t *testing.T) {
 
 	require.Equal(t, `{foo="bar",bar=~"buzz"}`, queryRequest.LabelSelector)
 }
+
+type fakeMergeProfileHandler struct {
+	querierv1connect.UnimplementedQuerierServiceHandler
+	profile *googlev1.Profile
+}
+
+func (h *fakeMergeProfileHandler) SelectMergeProfile(context.Context, *connect.Request[querierv1.SelectMergeProfileRequest]) (*connect.Response[googlev1.Profile], error) {
+	return connect.NewResponse(h.profile), nil
+}
+
+// fakeMergeProfileWriter writes the merged profile in small chunks.
+type fakeMergeProfileWriter struct {
+	fakeMergeProfileHandler
+}
+
+func (h *fakeMergeProfileWriter) WriteMergeProfile(_ context.Context, _ *connect.Request[querierv1.SelectMergeProfileRequest], fn func([]byte) error) error {
+	return pprof.WriteChunks(h.profile, 100, fn)
+}
+
+func Test_RenderPprof(t *testing.T) {
+	p, err := pprof.FromProfile(pprofth.FooBarProfile)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		handler querierv1connect.QuerierServiceHandler
+	}{
+		{name: "merged profile", handler: &fakeMergeProfileHandler{profile: p}},
+		{name: "merge profile writer", handler: &fakeMergeProfileWriter{fakeMergeProfileHandler{profile: p}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handlers := NewHTTPHandlers(tc.handler)
+			q := url.Values{
+				"query":  []string{`memory:alloc_space:bytes:space:bytes{foo="bar"}`},
+				"from":   []string{"now-6h"},
+				"until":  []string{"now"},
+				"format": []string{"pprof"},
+			}
+			req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost/pyroscope/render?%s", q.Encode()), nil)
+			rec := httptest.NewRecorder()
+			handlers.Render(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+			require.True(t, rec.Flushed)
+			actual, err := profile.ParseUncompressed(rec.Body.Bytes())
+			require.NoError(t, err)
+			require.Equal(t, pprofth.FooBarProfile, actual)
+		})
+	}
+}
+
+type fakeLegacyHandler struct {
+	querierv1connect.UnimplementedQuerierServiceHandler
+	mergeRequest *querierv1.SelectMergeStacktracesRequest
+
+	mu                sync.Mutex
+	exemplarsRequests []*querierv1.SelectExemplarsRequest
+}
+
+func (h *fakeLegacyHandler) ProfileTypes(context.Context, *connect.Request[querierv1.ProfileTypesRequest]) (*connect.Response[querierv1.ProfileTypesResponse], error) {
+	return connect.NewResponse(&querierv1.ProfileTypesResponse{
+		ProfileTypes: []*typesv1.ProfileType{
+			{ID: "memory:alloc_space:bytes:space:bytes", Name: "memory", SampleType: "alloc_space", SampleUnit: "bytes", PeriodType: "space", PeriodUnit: "bytes"},
+			{ID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds", Name: "process_cpu", SampleType: "cpu", SampleUnit: "nanoseconds", PeriodType: "cpu", PeriodUnit: "nanoseconds"},
+		},
+	}), nil
+}
+
+func (h *fakeLegacyHandler) Series(context.Context, *connect.Request[querierv1.SeriesRequest]) (*connect.Response[querierv1.SeriesResponse], error) {
+	return connect.NewResponse(&querierv1.SeriesResponse{
+		LabelsSet: []*typesv1.Labels{
+			{Labels: []*typesv1.LabelPair{{Name: "__profile_type__", Value: "process_cpu:cpu:nanoseconds:cpu:nanoseconds"}, {Name: "pyroscope_spy", Value: "gospy"}, {Name: "service_name", Value: "my-app"}}},
+			{Labels: []*typesv1.LabelPair{{Name: "__profile_type__", Value: "memory:alloc_space:bytes:space:bytes"}, {Name: "service_name", Value: "my-app"}}},
+			{Labels: []*typesv1.LabelPair{{Name: "__profile_type__", Value: "process_cpu:cpu:nanoseconds:cpu:nanoseconds"}}},
+		},
+	}), nil
+}
+
+func (h *fakeLegacyHandler) LabelNames(_ context.Context, req *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error) {
+	return connect.NewResponse(&typesv1.LabelNamesResponse{
+		Names: append([]string{"__name__", "env", "service_name"}, req.Msg.Matchers...),
+	}), nil
+}
+
+func (h *fakeLegacyHandler) SelectMergeStacktraces(_ context.Context, req *connect.Request[querierv1.SelectMergeStacktracesRequest]) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	h.mergeRequest = req.Msg
+	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{}), nil
+}
+
+// SelectExemplars returns the exemplar aa along with the heatmap, and the
+// exemplar bb for the selection.
+func (h *fakeLegacyHandler) SelectExemplars(_ context.Context, req *connect.Request[querierv1.SelectExemplarsRequest]) (*connect.Response[querierv1.SelectExemplarsResponse], error) {
+	h.mu.Lock()
+	h.exemplarsRequests = append(h.exemplarsRequests, req.Msg)
+	h.mu.Unlock()
+	if req.Msg.HeatmapTimeBuckets == 0 {
+		return connect.NewResponse(&querierv1.SelectExemplarsResponse{
+			Exemplars: []*typesv1.Exemplar{{ProfileId: "00000000000000bb", Timestamp: 1690000010000, Value: 5}},
+		}), nil
+	}
+	return connect.NewResponse(&querierv1.SelectExemplarsResponse{
+		Exemplars: []*typesv1.Exemplar{{ProfileId: "00000000000000aa", Timestamp: 1690000000000, Value: 10}},
+		Heatmap: &querierv1.Heatmap{
+			Start:        req.Msg.Start,
+			End:          req.Msg.End,
+			MaxValue:     10,
+			TimeBuckets:  req.Msg.HeatmapTimeBuckets,
+			ValueBuckets: req.Msg.HeatmapValueBuckets,
+			Counts:       make([]uint64, req.Msg.HeatmapTimeBuckets*req.Msg.HeatmapValueBuckets),
+		},
+	}), nil
+}
+
+func Test_ParseFlameQLQuery(t *testing.T) {
+	q := url.Values{
+		"query": []string{`my-app.alloc_space{env="prod",pod!~"x.*"}`},
+		"from":  []string{"now-6h"},
+		"until": []string{"now"},
+	}
+	req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost/pyroscope/render?%s", q.Encode()), nil)
+	require.NoError(t, req.ParseForm())
+
+	queryRequest, ptype, err := NewHTTPHandlers(&fakeLegacyHandler{}).parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+	require.NoError(t, err)
+	require.Equal(t, "memory:alloc_space:bytes:space:bytes", ptype.ID)
+	require.Equal(t, "memory:alloc_space:bytes:space:bytes", queryRequest.ProfileTypeID)
+	require.Equal(t, `{service_name="my-app",pod!~"x.*",env="prod"}`, queryRequest.LabelSelector)
+
+	for _, query := range []string{`my-app.unknown{}`, `my-app.goroutines`, `my-app`, `my-app.cpu{env=prod}`} {
+		req = httptest.NewRequest("GET", fmt.Sprintf("http://localhost/pyroscope/render?%s", url.Values{"query": []string{query}}.Encode()), nil)
+		require.NoError(t, req.ParseForm())
+		_, _, err = NewHTTPHandlers(&fakeLegacyHandler{}).parseSelectProfilesRequest(renderRequestFieldNames{}, req)
+		require.Error(t, err, query)
+	}
+}
+
+func Test_LegacyLabelNames(t *testing.T) {
+	handlers := NewHTTPHandlers(&fakeLegacyHandler{})
+	req := httptest.NewRequest("GET", fmt.Sprintf("http://localhost/pyroscope/label-names?%s", url.Values{"query": []string{`my-app.cpu{env="prod"}`}}.Encode()), nil)
+	rec := httptest.NewRecorder()
+	handlers.LabelNames(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	// The fake querier returns the matchers as label names.
+	require.JSONEq(t, `["env","service_name","{__profile_type__=\"process_cpu:cpu:nanoseconds:cpu:nanoseconds\",service_name=\"my-app\",env=\"prod\"}"]`, rec.Body.String())
+}
+
+func Test_LegacyApps(t *testing.T) {
+	handlers := NewHTTPHandlers(&fakeLegacyHandler{})
+	rec := httptest.NewRecorder()
+	handlers.Apps(rec, httptest.NewRequest("GET", "http://localhost/pyroscope/api/apps", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[
+		{"name":"my-app.alloc_space","units":"bytes"},
+		{"name":"my-app.cpu","spyName":"gospy","units":"nanoseconds"}
+	]`, rec.Body.String())
+}
+
+func Test_LegacyMerge(t *testing.T) {
+	client := &fakeLegacyHandler{}
+	handlers := NewHTTPHandlers(client)
+	body := `{"appName":"my-app.cpu{env=\"prod\"}","profiles":["00000000000000aa"],"startTime":1690000000,"endTime":"1690003600","maxNodes":1024}`
+	rec := httptest.NewRecorder()
+	handlers.Merge(rec, httptest.NewRequest("POST", "http://localhost/pyroscope/merge", strings.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	maxNodes := int64(1024)
+	require.Equal(t, &querierv1.SelectMergeStacktracesRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{service_name="my-app",env="prod"}`,
+		Start:         1690000000000,
+		End:           1690003600000,
+		MaxNodes:      &maxNodes,
+		SpanSelector:  []string{"00000000000000aa"},
+	}, client.mergeRequest)
+	require.Contains(t, rec.Body.String(), `"mergeMetadata":{"appName":"my-app.cpu{env=\"prod\"}","startTime":"1690000000","endTime":"1690003600","profilesLength":1}`)
+
+	rec = httptest.NewRecorder()
+	handlers.Merge(rec, httptest.NewRequest("POST", "http://localhost/pyroscope/exemplars:merge", strings.NewReader(`{"appName":"my-app.cpu","profileIDs":["not-a-span"]}`)))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_LegacyExemplarsQuery(t *testing.T) {
+	query := func(params url.Values) (*fakeLegacyHandler, *httptest.ResponseRecorder) {
+		client := &fakeLegacyHandler{}
+		params.Set("query", `my-app.cpu{env="prod"}`)
+		params.Set("from", "1690000000")
+		params.Set("until", "1690003600")
+		rec := httptest.NewRecorder()
+		NewHTTPHandlers(client).ExemplarsQuery(rec, httptest.NewRequest("GET", "http://localhost/pyroscope/exemplars:query?"+params.Encode(), nil))
+		return client, rec
+	}
+
+	t.Run("heatmap", func(t *testing.T) {
+		client, rec := query(url.Values{"heatmapTimeBuckets": []string{"2"}, "heatmapValueBuckets": []string{"3"}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, []*querierv1.SelectExemplarsRequest{{
+			ProfileTypeID:       "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+			LabelSelector:       `{service_name="my-app",env="prod"}`,
+			Start:               1690000000000,
+			End:                 1690003600000,
+			HeatmapTimeBuckets:  2,
+			HeatmapValueBuckets: 3,
+		}}, client.exemplarsRequests)
+		// Without selection, the exemplars of the heatmap are merged.
+		require.Equal(t, []string{"00000000000000aa"}, client.mergeRequest.SpanSelector)
+		require.Contains(t, rec.Body.String(), `"heatmap":{"values":[[0,0,0],[0,0,0]],"timeBuckets":2,"valueBuckets":3,"startTime":1690000000000000000,"endTime":1690003600000000000,"minValue":0,"maxValue":10`)
+		require.Contains(t, rec.Body.String(), `"profiles":[{"id":"00000000000000aa","timestamp":1690000000,"value":10}]`)
+	})
+
+	t.Run("selection", func(t *testing.T) {
+		client, rec := query(url.Values{"selectionStartTime": []string{"1690000010"}, "selectionMinValue": []string{"5"}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, client.exemplarsRequests, 2)
+		require.Equal(t, []string{"00000000000000bb"}, client.mergeRequest.SpanSelector)
+		require.Equal(t, int64(1690000010000), client.mergeRequest.Start)
+		require.Equal(t, int64(1690003600000), client.mergeRequest.End)
+		require.Contains(t, rec.Body.String(), `"profiles":[{"id":"00000000000000bb","timestamp":1690000010,"value":5}]`)
+	})
+
+	t.Run("invalid parameter", func(t *testing.T) {
+		_, rec := query(url.Values{"selectionMaxValue": []string{"x"}})
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
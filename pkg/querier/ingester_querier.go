@@ -2,6 +2,7 @@ package querier
 
 import (
 	"context"
+	"time"
 
 	"github.com/bufbuild/connect-go"
 	"github.com/grafana/dskit/ring"
@@ -16,6 +17,7 @@ import (
 	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/clientpool"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/util"
 )
 
@@ -24,21 +26,32 @@ type IngesterQueryClient interface {
 	LabelNames(context.Context, *connect.Request[typesv1.LabelNamesRequest]) (*connect.Response[typesv1.LabelNamesResponse], error)
 	ProfileTypes(context.Context, *connect.Request[ingestv1.ProfileTypesRequest]) (*connect.Response[ingestv1.ProfileTypesResponse], error)
 	Series(ctx context.Context, req *connect.Request[ingestv1.SeriesRequest]) (*connect.Response[ingestv1.SeriesResponse], error)
+	LabelCardinality(context.Context, *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error)
+	SelectProfilesMetadata(context.Context, *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error)
+	SelectExemplars(context.Context, *connect.Request[ingestv1.SelectExemplarsRequest]) (*connect.Response[ingestv1.SelectExemplarsResponse], error)
 	MergeProfilesStacktraces(context.Context) clientpool.BidiClientMergeProfilesStacktraces
 	MergeProfilesLabels(ctx context.Context) clientpool.BidiClientMergeProfilesLabels
 	MergeProfilesPprof(ctx context.Context) clientpool.BidiClientMergeProfilesPprof
 }
 
+type IngesterLimits interface {
+	IngestionTenantShardSize(tenantID string) int
+}
+
 // IngesterQuerier helps with querying the ingesters.
 type IngesterQuerier struct {
-	ring ring.ReadRing
-	pool *ring_client.Pool
+	cfg    Config
+	ring   ring.ReadRing
+	pool   *ring_client.Pool
+	limits IngesterLimits
 }
 
-func NewIngesterQuerier(pool *ring_client.Pool, ring ring.ReadRing) *IngesterQuerier {
+func NewIngesterQuerier(cfg Config, pool *ring_client.Pool, ring ring.ReadRing, limits IngesterLimits) *IngesterQuerier {
 	return &IngesterQuerier{
-		ring: ring,
-		pool: pool,
+		cfg:    cfg,
+		ring:   ring,
+		pool:   pool,
+		limits: limits,
 	}
 }
 
@@ -46,12 +59,15 @@ func NewIngesterQuerier(pool *ring_client.Pool, ring ring.ReadRing) *IngesterQue
 // This should mirror the operation used when choosing ingesters to write series to (ring.WriteNoExtend).
 var readNoExtend = ring.NewOp([]ring.InstanceState{ring.ACTIVE}, nil)
 
-// forAllIngesters runs f, in parallel, for all ingesters
+// forAllIngesters runs f, in parallel, for all ingesters that may hold the tenant's data.
 func forAllIngesters[T any](ctx context.Context, ingesterQuerier *IngesterQuerier, f QueryReplicaFn[T, IngesterQueryClient]) ([]ResponseFromReplica[T], error) {
-	replicationSet, err := ingesterQuerier.ring.GetReplicationSetForOperation(readNoExtend)
+	replicationSet, err := ingesterQuerier.tenantRing(ctx).GetReplicationSetForOperation(readNoExtend)
 	if err != nil {
 		return nil, err
 	}
+	if ingesterQuerier.cfg.IngesterSingleZoneReads {
+		replicationSet = singleZoneReplicationSet(replicationSet)
+	}
 	return forGivenReplicationSet(ctx, func(addr string) (IngesterQueryClient, error) {
 		client, err := ingesterQuerier.pool.GetClientFor(addr)
 		if err != nil {
@@ -61,17 +77,66 @@ func forAllIngesters[T any](ctx context.Context, ingesterQuerier *IngesterQuerie
 	}, replicationSet, f)
 }
 
-func (q *Querier) selectTreeFromIngesters(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+// tenantRing returns the subring of the ingesters the tenant's series are
+// written to. The distributor only writes to the tenant's shuffle shard, but
+// the shard may have changed recently: the ingesters that have been part of it
+// within the last query-store-after period may still hold the tenant's data.
+func (q *IngesterQuerier) tenantRing(ctx context.Context) ring.ReadRing {
+	if !q.cfg.ShuffleShardingIngestersEnabled {
+		return q.ring
+	}
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return q.ring
+	}
+	shardSize := q.limits.IngestionTenantShardSize(tenantID)
+	// A shard size of 0 means shuffle sharding is disabled for this specific tenant.
+	if shardSize <= 0 {
+		return q.ring
+	}
+	if q.cfg.QueryStoreAfter <= 0 {
+		return q.ring.ShuffleShard(tenantID, shardSize)
+	}
+	return q.ring.ShuffleShardWithLookback(tenantID, shardSize, q.cfg.QueryStoreAfter, time.Now())
+}
+
+// singleZoneReplicationSet allows the quorum to be reached with responses
+// from the instances of a single zone. Zones with at least one unhealthy
+// instance are never part of the replication set, therefore each zone holds
+// a complete copy of the data, and the other zones are only queried when a
+// request to the selected zone fails.
+func singleZoneReplicationSet(rs ring.ReplicationSet) ring.ReplicationSet {
+	if rs.MaxUnavailableZones <= 0 {
+		// Zone awareness is disabled, or there are no spare zones.
+		return rs
+	}
+	zones := make(map[string]struct{}, rs.MaxUnavailableZones+1)
+	for _, instance := range rs.Instances {
+		zones[instance.Zone] = struct{}{}
+	}
+	rs.MaxUnavailableZones = len(zones) - 1
+	return rs
+}
+
+func (q *Querier) selectTreesFromIngesters(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) ([]*phlaremodel.Tree, error) {
 	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectTree Ingesters")
 	defer sp.Finish()
 	profileType, err := phlaremodel.ParseProfileTypeSelector(req.ProfileTypeID)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
+	additionalTypes, err := phlaremodel.ParseMultiValueProfileTypes(req.AdditionalProfileTypeIDs)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
 	_, err = parser.ParseMetricSelector(req.LabelSelector)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInvalidArgument, err)
 	}
+	scaleByPeriod, err := treeScaleByPeriod(req)
+	if err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -92,8 +157,11 @@ func (q *Querier) selectTreeFromIngesters(ctx context.Context, req *querierv1.Se
 					Start:         req.Start,
 					End:           req.End,
 					Type:          profileType,
+					ScaleByPeriod: scaleByPeriod,
 				},
-				MaxNodes: req.MaxNodes,
+				MaxNodes:        req.MaxNodes,
+				SpanSelector:    req.SpanSelector,
+				AdditionalTypes: additionalTypes,
 				// TODO(kolesnikovae): Max stacks.
 			})
 		}))
@@ -103,7 +171,7 @@ func (q *Querier) selectTreeFromIngesters(ctx context.Context, req *querierv1.Se
 	}
 
 	// merge all profiles
-	return selectMergeTree(gCtx, responses)
+	return selectMergeTrees(gCtx, responses, len(additionalTypes))
 }
 
 func (q *Querier) selectSeriesFromIngesters(ctx context.Context, req *ingesterv1.MergeProfilesLabelsRequest) ([]ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels], error) {
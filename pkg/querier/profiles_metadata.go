@@ -0,0 +1,143 @@
+package querier
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log/level"
+	"github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"golang.org/x/sync/errgroup"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util/spanlogger"
+)
+
+const defaultProfilesMetadataLimit = 100
+
+// SelectProfilesMetadata lists the most recent profiles matching the
+// selector and the metadata predicates within the time range, without
+// their samples. If the time range is not set, the last hour is queried.
+func (q *Querier) SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectProfilesMetadata")
+	defer func() {
+		sp.LogFields(
+			otlog.String("profile_type", req.Msg.ProfileTypeID),
+			otlog.String("selector", req.Msg.LabelSelector),
+			otlog.String("predicates", strings.Join(req.Msg.Predicates, ",")),
+			otlog.String("start", model.Time(req.Msg.Start).Time().String()),
+			otlog.String("end", model.Time(req.Msg.End).Time().String()),
+		)
+		sp.Finish()
+	}()
+
+	msg := req.Msg.CloneVT()
+	if msg.End == 0 {
+		msg.End = int64(model.Now())
+	}
+	if msg.Start == 0 {
+		msg.Start = int64(model.Time(msg.End).Add(-time.Hour))
+	}
+	if msg.Start > msg.End {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start must be before end"))
+	}
+	if msg.Limit <= 0 {
+		msg.Limit = defaultProfilesMetadataLimit
+	}
+	if _, err := phlaremodel.ParseProfileTypeSelector(msg.ProfileTypeID); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if _, err := parser.ParseMetricSelector(msg.LabelSelector); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if _, err := phlaremodel.ParseProfileMetadataPredicates(msg.Predicates); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	m := phlaremodel.NewProfilesMetadataMerger()
+	// no store gateways configured so just query the ingesters
+	if q.storeGatewayQuerier == nil {
+		if err := q.profilesMetadataFromIngesters(ctx, profilesMetadataSourceRequest(msg, model.Time(msg.Start), model.Time(msg.End)), m); err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(&typesv1.SelectProfilesMetadataResponse{Profiles: m.ProfilesMetadata(msg.Limit)}), nil
+	}
+
+	storeQueries := splitQueryToStores(model.Time(msg.Start), model.Time(msg.End), model.Now(), q.cfg.QueryStoreAfter)
+	if !storeQueries.ingester.shouldQuery && !storeQueries.storeGateway.shouldQuery {
+		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("start and end time are outside of the ingester and store gateway retention"))
+	}
+	storeQueries.Log(level.Debug(spanlogger.FromContext(ctx, q.logger)))
+
+	g, gCtx := errgroup.WithContext(ctx)
+	if storeQueries.ingester.shouldQuery {
+		g.Go(func() error {
+			return q.profilesMetadataFromIngesters(gCtx, profilesMetadataSourceRequest(msg, storeQueries.ingester.start, storeQueries.ingester.end), m)
+		})
+	}
+	if storeQueries.storeGateway.shouldQuery {
+		g.Go(func() error {
+			return q.profilesMetadataFromStoreGateway(gCtx, profilesMetadataSourceRequest(msg, storeQueries.storeGateway.start, storeQueries.storeGateway.end), m)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(&typesv1.SelectProfilesMetadataResponse{Profiles: m.ProfilesMetadata(msg.Limit)}), nil
+}
+
+func profilesMetadataSourceRequest(req *typesv1.SelectProfilesMetadataRequest, start, end model.Time) *typesv1.SelectProfilesMetadataRequest {
+	r := req.CloneVT()
+	r.Start = int64(start)
+	r.End = int64(end)
+	return r
+}
+
+func (q *Querier) profilesMetadataFromIngesters(ctx context.Context, req *typesv1.SelectProfilesMetadataRequest, m *phlaremodel.ProfilesMetadataMerger) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectProfilesMetadata Ingesters")
+	defer sp.Finish()
+	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(childCtx context.Context, ic IngesterQueryClient) (*typesv1.SelectProfilesMetadataResponse, error) {
+		res, err := ic.SelectProfilesMetadata(childCtx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range responses {
+		m.MergeProfilesMetadata(r.response.Profiles)
+	}
+	return nil
+}
+
+func (q *Querier) profilesMetadataFromStoreGateway(ctx context.Context, req *typesv1.SelectProfilesMetadataRequest, m *phlaremodel.ProfilesMetadataMerger) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "SelectProfilesMetadata StoreGateway")
+	defer sp.Finish()
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	responses, err := forAllStoreGateways(ctx, tenantID, q.storeGatewayQuerier, func(childCtx context.Context, sc StoreGatewayQueryClient) (*typesv1.SelectProfilesMetadataResponse, error) {
+		res, err := sc.SelectProfilesMetadata(childCtx, connect.NewRequest(req))
+		if err != nil {
+			return nil, err
+		}
+		return res.Msg, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range responses {
+		m.MergeProfilesMetadata(r.response.Profiles)
+	}
+	return nil
+}
@@ -1,8 +1,10 @@
 package querier
 
 import (
+	"bytes"
 	"context"
 	"flag"
+	"fmt"
 	"sort"
 	"strings"
 	"time"
@@ -30,6 +32,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/clientpool"
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+	"github.com/grafana/pyroscope/pkg/pprof"
 	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/util/math"
 	"github.com/grafana/pyroscope/pkg/util/spanlogger"
@@ -38,12 +41,17 @@ import (
 type Config struct {
 	PoolConfig      clientpool.PoolConfig `yaml:"pool_config,omitempty"`
 	QueryStoreAfter time.Duration         `yaml:"query_store_after" category:"advanced"`
+
+	ShuffleShardingIngestersEnabled bool `yaml:"shuffle_sharding_ingesters_enabled" category:"advanced"`
+	IngesterSingleZoneReads         bool `yaml:"ingester_single_zone_reads" category:"experimental"`
 }
 
 // RegisterFlags registers distributor-related flags.
 func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
 	cfg.PoolConfig.RegisterFlagsWithPrefix("querier", fs)
 	fs.DurationVar(&cfg.QueryStoreAfter, "querier.query-store-after", 4*time.Hour, "The time after which a metric should be queried from storage and not just ingesters. 0 means all queries are sent to store. If this option is enabled, the time range of the query sent to the store-gateway will be manipulated to ensure the query end is not more recent than 'now - query-store-after'.")
+	fs.BoolVar(&cfg.ShuffleShardingIngestersEnabled, "querier.shuffle-sharding-ingesters-enabled", true, "Query only the ingesters of the tenant's shuffle shard, when the tenant has an ingestion shard size configured. Ingesters which have been part of the shard within the last query-store-after period are queried as well, as they may still hold the tenant's data.")
+	fs.BoolVar(&cfg.IngesterSingleZoneReads, "querier.ingester-single-zone-reads", false, "When zone awareness is enabled, query the ingesters of a single zone in which all the instances are healthy, and fall back to other zones on failure. This reduces the read fan-out at the cost of missing samples that were written to fewer than all zones.")
 }
 
 type Querier struct {
@@ -60,7 +68,7 @@ type Querier struct {
 
 const maxNodesDefault = int64(2048)
 
-func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactory, storeGatewayQuerier *StoreGatewayQuerier, reg prometheus.Registerer, logger log.Logger, clientsOptions ...connect.ClientOption) (*Querier, error) {
+func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactory, limits IngesterLimits, storeGatewayQuerier *StoreGatewayQuerier, reg prometheus.Registerer, logger log.Logger, clientsOptions ...connect.ClientOption) (*Querier, error) {
 	// disable gzip compression for querier-ingester communication as most of payload are not benefit from it.
 	clientsOptions = append(clientsOptions, connect.WithAcceptCompression("gzip", nil, nil))
 	clientsMetrics := promauto.With(reg).NewGauge(prometheus.GaugeOpts{
@@ -73,8 +81,10 @@ func New(cfg Config, ingestersRing ring.ReadRing, factory ring_client.PoolFactor
 		cfg:    cfg,
 		logger: logger,
 		ingesterQuerier: NewIngesterQuerier(
+			cfg,
 			clientpool.NewIngesterPool(cfg.PoolConfig, ingestersRing, factory, clientsMetrics, logger, clientsOptions...),
 			ingestersRing,
+			limits,
 		),
 		storeGatewayQuerier: storeGatewayQuerier,
 	}
@@ -320,21 +330,129 @@ func (q *Querier) SelectMergeStacktraces(ctx context.Context, req *connect.Reque
 		mn := maxNodesDefault
 		req.Msg.MaxNodes = &mn
 	}
+	if len(req.Msg.SpanSelector) > 0 {
+		if _, err := phlaremodel.NewSpanSelector(req.Msg.SpanSelector); err != nil {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
+	}
+	exportFormat, err := phlaremodel.ParseExportFormat(req.Msg)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if len(req.Msg.AdditionalProfileTypeIDs) > 0 {
+		return q.selectMergeMultiValue(ctx, req.Msg)
+	}
 
 	t, err := q.selectTree(ctx, req.Msg)
 	if err != nil {
 		return nil, err
 	}
 
+	if exportFormat != "" {
+		// The profile type has been validated by the selection.
+		profileType, _ := phlaremodel.ParseProfileTypeSelector(req.Msg.ProfileTypeID)
+		export, err := phlaremodel.ExportTree(t, req.Msg.GetMaxNodes(), exportFormat, profileType)
+		if err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+			Export: export,
+		}), nil
+	}
+
+	if req.Msg.Format == querierv1.ProfileFormat_PROFILE_FORMAT_TREE {
+		// A negative max nodes value means the tree is not truncated.
+		var buf bytes.Buffer
+		if err = t.MarshalTruncate(&buf, req.Msg.GetMaxNodes()); err != nil {
+			return nil, err
+		}
+		return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
+			Tree: buf.Bytes(),
+		}), nil
+	}
+
 	return connect.NewResponse(&querierv1.SelectMergeStacktracesResponse{
 		Flamegraph: phlaremodel.NewFlameGraph(t, req.Msg.GetMaxNodes()),
 	}), nil
 }
 
+// selectMergeMultiValue merges the profile types of the request into one
+// multi-value flame graph, or into a tree per profile type in the tree
+// format. The profile types are merged by the ingesters and
+// store-gateways at once, reading the profiles of multi-value profiles
+// once. If some of them do not support it, or if the request converts
+// the unit or selects spans, the trees of the profile types are selected
+// concurrently.
+func (q *Querier) selectMergeMultiValue(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*connect.Response[querierv1.SelectMergeStacktracesResponse], error) {
+	profileTypeIDs := append([]string{req.ProfileTypeID}, req.AdditionalProfileTypeIDs...)
+	if _, err := phlaremodel.ParseMultiValueProfileTypes(profileTypeIDs); err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	var trees []*phlaremodel.Tree
+	if req.Unit == "" && len(req.SpanSelector) == 0 {
+		var err error
+		if trees, err = q.selectTrees(ctx, req); err != nil && !errors.Is(err, errAdditionalTypesNotSupported) {
+			return nil, err
+		}
+	}
+	if trees == nil {
+		var err error
+		if trees, err = q.selectTreesByProfileType(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := phlaremodel.NewMultiValueMergeResponse(req, trees)
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(res), nil
+}
+
+// selectTreesByProfileType selects the trees of the profile type and the
+// additional profile types of the request concurrently, one query each.
+func (q *Querier) selectTreesByProfileType(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) ([]*phlaremodel.Tree, error) {
+	profileTypeIDs := append([]string{req.ProfileTypeID}, req.AdditionalProfileTypeIDs...)
+	trees := make([]*phlaremodel.Tree, len(profileTypeIDs))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, profileTypeID := range profileTypeIDs {
+		i, r := i, req.CloneVT()
+		r.ProfileTypeID = profileTypeID
+		r.AdditionalProfileTypeIDs = nil
+		if i > 0 {
+			// The unit only applies to the first profile type.
+			r.Unit = ""
+		}
+		g.Go(func() error {
+			t, err := q.selectTree(ctx, r)
+			if err != nil {
+				return err
+			}
+			trees[i] = t
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return trees, nil
+}
+
 func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) (*phlaremodel.Tree, error) {
+	trees, err := q.selectTrees(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return trees[0], nil
+}
+
+// selectTrees selects the trees of the profile type and the additional
+// profile types of the request, in the order of the request.
+func (q *Querier) selectTrees(ctx context.Context, req *querierv1.SelectMergeStacktracesRequest) ([]*phlaremodel.Tree, error) {
 	// no store gateways configured so just query the ingesters
 	if q.storeGatewayQuerier == nil {
-		return q.selectTreeFromIngesters(ctx, req)
+		return q.selectTreesFromIngesters(ctx, req)
 	}
 
 	storeQueries := splitQueryToStores(model.Time(req.Start), model.Time(req.End), model.Now(), q.cfg.QueryStoreAfter)
@@ -345,17 +463,17 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	storeQueries.Log(level.Debug(spanlogger.FromContext(ctx, q.logger)))
 
 	if !storeQueries.ingester.shouldQuery {
-		return q.selectTreeFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
+		return q.selectTreesFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
 	}
 	if !storeQueries.storeGateway.shouldQuery {
-		return q.selectTreeFromIngesters(ctx, storeQueries.ingester.MergeStacktracesRequest(req))
+		return q.selectTreesFromIngesters(ctx, storeQueries.ingester.MergeStacktracesRequest(req))
 	}
 
 	g, ctx := errgroup.WithContext(ctx)
-	var ingesterTree, storegatewayTree *phlaremodel.Tree
+	var ingesterTrees, storegatewayTrees []*phlaremodel.Tree
 	g.Go(func() error {
 		var err error
-		ingesterTree, err = q.selectTreeFromIngesters(ctx, storeQueries.ingester.MergeStacktracesRequest(req))
+		ingesterTrees, err = q.selectTreesFromIngesters(ctx, storeQueries.ingester.MergeStacktracesRequest(req))
 		if err != nil {
 			return err
 		}
@@ -363,7 +481,7 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	})
 	g.Go(func() error {
 		var err error
-		storegatewayTree, err = q.selectTreeFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
+		storegatewayTrees, err = q.selectTreesFromStoreGateway(ctx, storeQueries.storeGateway.MergeStacktracesRequest(req))
 		if err != nil {
 			return err
 		}
@@ -372,8 +490,10 @@ func (q *Querier) selectTree(ctx context.Context, req *querierv1.SelectMergeStac
 	if err := g.Wait(); err != nil {
 		return nil, err
 	}
-	storegatewayTree.Merge(ingesterTree)
-	return storegatewayTree, nil
+	for i, t := range storegatewayTrees {
+		t.Merge(ingesterTrees[i])
+	}
+	return storegatewayTrees, nil
 }
 
 type storeQuery struct {
@@ -388,19 +508,18 @@ func (sq storeQuery) MergeStacktracesRequest(req *querierv1.SelectMergeStacktrac
 		LabelSelector: req.LabelSelector,
 		ProfileTypeID: req.ProfileTypeID,
 		MaxNodes:      req.MaxNodes,
+		SpanSelector:  req.SpanSelector,
+		Unit:          req.Unit,
+
+		AdditionalProfileTypeIDs: req.AdditionalProfileTypeIDs,
 	}
 }
 
-func (sq storeQuery) MergeSeriesRequest(req *querierv1.SelectSeriesRequest, profileType *typesv1.ProfileType) *ingestv1.MergeProfilesLabelsRequest {
-	return &ingestv1.MergeProfilesLabelsRequest{
-		By: req.GroupBy,
-		Request: &ingestv1.SelectProfilesRequest{
-			Type:          profileType,
-			LabelSelector: req.LabelSelector,
-			Start:         int64(sq.start),
-			End:           int64(sq.end),
-		},
-	}
+func (sq storeQuery) MergeSeriesRequest(req *ingestv1.MergeProfilesLabelsRequest) *ingestv1.MergeProfilesLabelsRequest {
+	r := req.CloneVT()
+	r.Request.Start = int64(sq.start)
+	r.Request.End = int64(sq.end)
+	return r
 }
 
 type storeQueries struct {
@@ -449,50 +568,97 @@ func (q *Querier) SelectMergeProfile(ctx context.Context, req *connect.Request[q
 		sp.Finish()
 	}()
 
-	profileType, err := phlaremodel.ParseProfileTypeSelector(req.Msg.ProfileTypeID)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	profileType, responses, err := q.mergeProfilesPprof(ctx, req.Msg, false)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		return nil, err
 	}
-	_, err = parser.ParseMetricSelector(req.Msg.LabelSelector)
+
+	// merge all profiles
+	profile, err := selectMergePprofProfile(ctx, profileType, responses)
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		return nil, err
 	}
+	profile.DurationNanos = model.Time(req.Msg.End).UnixNano() - model.Time(req.Msg.Start).UnixNano()
+	profile.TimeNanos = model.Time(req.Msg.End).UnixNano()
+	return connect.NewResponse(profile), nil
+}
+
+// SelectMergeProfileStream sends the merged profile in chunks, as the
+// partial merge results of the ingesters are received.
+func (q *Querier) SelectMergeProfileStream(ctx context.Context, req *connect.Request[querierv1.SelectMergeProfileRequest], stream *connect.ServerStream[querierv1.SelectMergeProfileStreamResponse]) error {
+	return q.WriteMergeProfile(ctx, req, func(chunk []byte) error {
+		return stream.Send(&querierv1.SelectMergeProfileStreamResponse{Chunk: chunk})
+	})
+}
+
+// WriteMergeProfile writes the merged profile in the chunks passed to fn.
+// The ingesters send the merge result of each block separately, and the
+// results are merged as they are received: only their symbols are retained.
+func (q *Querier) WriteMergeProfile(ctx context.Context, req *connect.Request[querierv1.SelectMergeProfileRequest], fn func([]byte) error) error {
+	sp, ctx := opentracing.StartSpanFromContext(ctx, "WriteMergeProfile")
+	defer func() {
+		sp.LogFields(
+			otlog.String("start", model.Time(req.Msg.Start).Time().String()),
+			otlog.String("end", model.Time(req.Msg.End).Time().String()),
+			otlog.String("selector", req.Msg.LabelSelector),
+			otlog.String("profile_id", req.Msg.ProfileTypeID),
+		)
+		sp.Finish()
+	}()
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	profileType, responses, err := q.mergeProfilesPprof(ctx, req.Msg, true)
+	if err != nil {
+		return err
+	}
+	w := pprof.NewMergeWriter(pprof.DefaultChunkSize, fn)
+	if err = streamMergePprofProfile(ctx, profileType, responses, w); err != nil {
+		return err
+	}
+	return w.Close(model.Time(req.Msg.End).UnixNano(), model.Time(req.Msg.End).UnixNano()-model.Time(req.Msg.Start).UnixNano())
+}
+
+// mergeProfilesPprof opens the pprof merge streams of the ingesters, and
+// sends them the initial request.
+func (q *Querier) mergeProfilesPprof(ctx context.Context, req *querierv1.SelectMergeProfileRequest, partialResults bool) (*typesv1.ProfileType, []ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof], error) {
+	profileType, err := phlaremodel.ParseProfileTypeSelector(req.ProfileTypeID)
+	if err != nil {
+		return nil, nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	_, err = parser.ParseMetricSelector(req.LabelSelector)
+	if err != nil {
+		return nil, nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
 
 	responses, err := forAllIngesters(ctx, q.ingesterQuerier, func(ctx context.Context, ic IngesterQueryClient) (clientpool.BidiClientMergeProfilesPprof, error) {
 		return ic.MergeProfilesPprof(ctx), nil
 	})
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return nil, nil, connect.NewError(connect.CodeInternal, err)
 	}
 	// send the first initial request to all ingesters.
-	g, gCtx := errgroup.WithContext(ctx)
+	g := new(errgroup.Group)
 	for _, r := range responses {
 		r := r
 		g.Go(util.RecoverPanic(func() error {
 			return r.response.Send(&ingestv1.MergeProfilesPprofRequest{
 				Request: &ingestv1.SelectProfilesRequest{
-					LabelSelector: req.Msg.LabelSelector,
-					Start:         req.Msg.Start,
-					End:           req.Msg.End,
+					LabelSelector: req.LabelSelector,
+					Start:         req.Start,
+					End:           req.End,
 					Type:          profileType,
 				},
+				PartialResults: partialResults,
 			})
 		}))
 	}
 	if err := g.Wait(); err != nil {
-		return nil, connect.NewError(connect.CodeInternal, err)
+		return nil, nil, connect.NewError(connect.CodeInternal, err)
 	}
-
-	// merge all profiles
-	profile, err := selectMergePprofProfile(gCtx, profileType, responses)
-	if err != nil {
-		return nil, err
-	}
-	profile.DurationNanos = model.Time(req.Msg.End).UnixNano() - model.Time(req.Msg.Start).UnixNano()
-	profile.TimeNanos = model.Time(req.Msg.End).UnixNano()
-	return connect.NewResponse(profile), nil
+	return profileType, responses, nil
 }
 
 func (q *Querier) SelectSeries(ctx context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) (*connect.Response[querierv1.SelectSeriesResponse], error) {
@@ -522,56 +688,75 @@ func (q *Querier) SelectSeries(ctx context.Context, req *connect.Request[querier
 		return nil, connect.NewError(connect.CodeInvalidArgument, errors.New("step must be non-zero"))
 	}
 
+	profileType, err := phlaremodel.ParseProfileTypeSelector(req.Msg.ProfileTypeID)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	conversion, err := newUnitConversion(profileType, req.Msg.Unit)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
 	stepMs := time.Duration(req.Msg.Step * float64(time.Second)).Milliseconds()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	responses, err := q.selectSeries(ctx, req)
+	sort.Strings(req.Msg.GroupBy)
+	// we need to request profile from start - step to end since start is inclusive.
+	// The first step starts at start-step to start.
+	mergeReq := &ingestv1.MergeProfilesLabelsRequest{
+		Request: &ingestv1.SelectProfilesRequest{
+			LabelSelector: req.Msg.LabelSelector,
+			Start:         req.Msg.Start - stepMs,
+			End:           req.Msg.End,
+			Type:          profileType,
+			ScaleByPeriod: conversion.scaleByPeriod,
+		},
+		By: req.Msg.GroupBy,
+	}
+	// The aggregation is pushed down to the stores if the querier can
+	// combine their partial results. Otherwise, the stores return the
+	// value of each profile.
+	aggregation := req.Msg.Aggregation
+	partial, combine, pushDown := phlaremodel.SplitTimeSeriesAggregation(aggregation)
+	if pushDown {
+		mergeReq.StepAggregation = &ingestv1.StepAggregation{
+			Start:       req.Msg.Start,
+			Step:        stepMs,
+			Aggregation: partial,
+		}
+		aggregation = combine
+	} else {
+		mergeReq.ProfilePoints = true
+	}
+
+	responses, err := q.selectSeries(ctx, mergeReq)
 	if err != nil {
 		return nil, err
 	}
 
-	it, err := selectMergeSeries(ctx, responses)
+	it, err := selectMergeSeries(ctx, responses, mergeReq.StepAggregation)
 	if err != nil {
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
-	result := rangeSeries(it, req.Msg.Start, req.Msg.End, stepMs)
+	result := rangeSeries(it, req.Msg.Start, req.Msg.End, stepMs, aggregation)
 	if it.Err() != nil {
 		return nil, connect.NewError(connect.CodeInternal, it.Err())
 	}
+	conversion.convertSeries(result)
 
 	return connect.NewResponse(&querierv1.SelectSeriesResponse{
 		Series: result,
 	}), nil
 }
 
-func (q *Querier) selectSeries(ctx context.Context, req *connect.Request[querierv1.SelectSeriesRequest]) ([]ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels], error) {
-	stepMs := time.Duration(req.Msg.Step * float64(time.Second)).Milliseconds()
-	sort.Strings(req.Msg.GroupBy)
-
-	// we need to request profile from start - step to end since start is inclusive.
-	// The first step starts at start-step to start.
-	start := req.Msg.Start - stepMs
-
-	profileType, err := phlaremodel.ParseProfileTypeSelector(req.Msg.ProfileTypeID)
-	if err != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, err)
-	}
-
+func (q *Querier) selectSeries(ctx context.Context, req *ingestv1.MergeProfilesLabelsRequest) ([]ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels], error) {
 	if q.storeGatewayQuerier == nil {
-		return q.selectSeriesFromIngesters(ctx, &ingestv1.MergeProfilesLabelsRequest{
-			Request: &ingestv1.SelectProfilesRequest{
-				LabelSelector: req.Msg.LabelSelector,
-				Start:         start,
-				End:           req.Msg.End,
-				Type:          profileType,
-			},
-			By: req.Msg.GroupBy,
-		})
+		return q.selectSeriesFromIngesters(ctx, req)
 	}
 
-	storeQueries := splitQueryToStores(model.Time(start), model.Time(req.Msg.End), model.Now(), q.cfg.QueryStoreAfter)
+	storeQueries := splitQueryToStores(model.Time(req.Request.Start), model.Time(req.Request.End), model.Now(), q.cfg.QueryStoreAfter)
 
 	var responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels]
 
@@ -581,7 +766,7 @@ func (q *Querier) selectSeries(ctx context.Context, req *connect.Request[querier
 
 	// todo in parallel
 	if storeQueries.ingester.shouldQuery {
-		ir, err := q.selectSeriesFromIngesters(ctx, storeQueries.ingester.MergeSeriesRequest(req.Msg, profileType))
+		ir, err := q.selectSeriesFromIngesters(ctx, storeQueries.ingester.MergeSeriesRequest(req))
 		if err != nil {
 			return nil, err
 		}
@@ -589,7 +774,7 @@ func (q *Querier) selectSeries(ctx context.Context, req *connect.Request[querier
 	}
 
 	if storeQueries.storeGateway.shouldQuery {
-		ir, err := q.selectSeriesFromStoreGateway(ctx, storeQueries.storeGateway.MergeSeriesRequest(req.Msg, profileType))
+		ir, err := q.selectSeriesFromStoreGateway(ctx, storeQueries.storeGateway.MergeSeriesRequest(req))
 		if err != nil {
 			return nil, err
 		}
@@ -601,59 +786,75 @@ func (q *Querier) selectSeries(ctx context.Context, req *connect.Request[querier
 // rangeSeries aggregates profiles into series.
 // Series contains points spaced by step from start to end.
 // Profiles from the same step are aggregated into one point.
-func rangeSeries(it iter.Iterator[ProfileValue], start, end, step int64) []*typesv1.Series {
+func rangeSeries(it iter.Iterator[ProfileValue], start, end, step int64, aggregation typesv1.TimeSeriesAggregationType) []*typesv1.Series {
 	defer it.Close()
-	seriesMap := make(map[uint64]*typesv1.Series)
-
-	if !it.Next() {
-		return nil
+	type rangedSeries struct {
+		*typesv1.Series
+		aggregator *phlaremodel.TimeSeriesAggregator
+		step       int64
+	}
+	seriesMap := make(map[uint64]*rangedSeries)
+	flush := func(s *rangedSeries) {
+		s.Points = append(s.Points, &typesv1.Point{
+			Value:     s.aggregator.Value(),
+			Timestamp: s.step,
+		})
+		s.aggregator.Reset()
 	}
-	// advance from the start to the end, adding each step results to the map.
-Outer:
-	for currentStep := start; currentStep <= end; currentStep += step {
-		for {
-			if it.At().Ts > currentStep {
-				break // no more profiles for the currentStep
-			}
-			// find or create series
-			series, ok := seriesMap[it.At().LabelsHash]
-			if !ok {
-				seriesMap[it.At().LabelsHash] = &typesv1.Series{
-					Labels: it.At().Lbs,
-					Points: []*typesv1.Point{
-						{Value: it.At().Value, Timestamp: currentStep},
-					},
-				}
-				if !it.Next() {
-					break Outer
-				}
-				continue
-			}
-			// Aggregate point if it is in the current step.
-			if series.Points[len(series.Points)-1].Timestamp == currentStep {
-				series.Points[len(series.Points)-1].Value += it.At().Value
-				if !it.Next() {
-					break Outer
-				}
-				continue
-			}
-			// Next step is missing
-			series.Points = append(series.Points, &typesv1.Point{
-				Value:     it.At().Value,
-				Timestamp: currentStep,
-			})
-			if !it.Next() {
-				break Outer
+
+	// Profiles are sorted by timestamp: a series moves on to the next
+	// step once a profile of the series falls beyond the current one.
+	for it.Next() {
+		p := it.At()
+		if p.Ts > end {
+			break
+		}
+		currentStep := phlaremodel.StepTimestamp(p.Ts, start, step)
+		series, ok := seriesMap[p.LabelsHash]
+		if !ok {
+			series = &rangedSeries{
+				Series:     &typesv1.Series{Labels: p.Lbs},
+				aggregator: phlaremodel.NewTimeSeriesAggregator(aggregation, step),
+				step:       currentStep,
 			}
+			seriesMap[p.LabelsHash] = series
 		}
+		if series.step != currentStep {
+			flush(series)
+			series.step = currentStep
+		}
+		series.aggregator.Add(p.Value)
+	}
+	series := make([]*typesv1.Series, 0, len(seriesMap))
+	for _, s := range seriesMap {
+		flush(s)
+		series = append(series, s.Series)
 	}
-	series := lo.Values(seriesMap)
 	sort.Slice(series, func(i, j int) bool {
 		return phlaremodel.CompareLabelPairs(series[i].Labels, series[j].Labels) < 0
 	})
 	return series
 }
 
+// treeScaleByPeriod reports whether the sample values of the profiles
+// must be scaled by the sampling period to build the tree in the unit
+// requested. Tree values are integers, therefore no other conversion
+// is supported.
+func treeScaleByPeriod(req *querierv1.SelectMergeStacktracesRequest) (bool, error) {
+	profileType, err := phlaremodel.ParseProfileTypeSelector(req.ProfileTypeID)
+	if err != nil {
+		return false, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	c, err := newUnitConversion(profileType, req.Unit)
+	if err != nil {
+		return false, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	if c.factor != 1 {
+		return false, connect.NewError(connect.CodeInvalidArgument, fmt.Errorf("stack trace values cannot be converted to %s", req.Unit))
+	}
+	return c.scaleByPeriod, nil
+}
+
 func uniqueSortedStrings(responses []ResponseFromReplica[[]string]) []string {
 	total := 0
 	for _, r := range responses {
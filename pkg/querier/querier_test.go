@@ -3,6 +3,8 @@ package querier
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"os"
 	"sort"
 	"testing"
@@ -17,6 +19,7 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	querierv1 "github.com/grafana/pyroscope/api/gen/proto/go/querier/v1"
@@ -25,7 +28,9 @@ import (
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	pprofth "github.com/grafana/pyroscope/pkg/pprof/testhelper"
+	"github.com/grafana/pyroscope/pkg/tenant"
 	"github.com/grafana/pyroscope/pkg/testhelper"
+	"github.com/grafana/pyroscope/pkg/validation"
 )
 
 func Test_QuerySampleType(t *testing.T) {
@@ -64,7 +69,7 @@ func Test_QuerySampleType(t *testing.T) {
 				}), nil)
 		}
 		return q, nil
-	}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.ProfileTypes(context.Background(), connect.NewRequest(&querierv1.ProfileTypesRequest{}))
@@ -95,7 +100,7 @@ func Test_QueryLabelValues(t *testing.T) {
 			q.On("LabelValues", mock.Anything, mock.Anything).Return(connect.NewResponse(&typesv1.LabelValuesResponse{Names: []string{"buzz", "foo"}}), nil)
 		}
 		return q, nil
-	}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.LabelValues(context.Background(), req)
@@ -122,7 +127,7 @@ func Test_QueryLabelNames(t *testing.T) {
 			q.On("LabelNames", mock.Anything, mock.Anything).Return(connect.NewResponse(&typesv1.LabelNamesResponse{Names: []string{"buzz", "foo"}}), nil)
 		}
 		return q, nil
-	}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.LabelNames(context.Background(), req)
@@ -155,7 +160,7 @@ func Test_Series(t *testing.T) {
 			q.On("Series", mock.Anything, mock.Anything).Return(ingesterReponse, nil)
 		}
 		return q, nil
-	}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
 
 	require.NoError(t, err)
 	out, err := querier.Series(context.Background(), req)
@@ -166,6 +171,142 @@ func Test_Series(t *testing.T) {
 	}, out.Msg.LabelsSet)
 }
 
+func Test_LabelCardinality(t *testing.T) {
+	req := connect.NewRequest(&typesv1.LabelCardinalityRequest{Matchers: []string{`{foo="bar"}`}, Start: 1000, End: 2000, Limit: 1})
+	ingesterResponse := connect.NewResponse(&typesv1.LabelCardinalityResponse{Labels: []*typesv1.LabelCardinality{
+		{
+			Name: "pod",
+			Values: []*typesv1.LabelValueCardinality{
+				{Value: "a", SeriesCount: 1, Bytes: 10, FirstSeen: 1100},
+				{Value: "b", SeriesCount: 2, Bytes: 20, FirstSeen: 1600},
+			},
+		},
+	}})
+	querier, err := New(Config{
+		PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+		{Addr: "3"},
+	}, 3), func(addr string) (client.PoolClient, error) {
+		q := newFakeQuerier()
+		q.On("LabelCardinality", mock.Anything, mock.MatchedBy(func(r *connect.Request[typesv1.LabelCardinalityRequest]) bool {
+			// All the values are requested from ingesters.
+			return r.Msg.Limit == 0
+		})).Return(ingesterResponse, nil)
+		return q, nil
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	// Every ingester holds a replica of all the series.
+	out, err := querier.LabelCardinality(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []*typesv1.LabelCardinality{
+		{
+			Name:              "pod",
+			ValuesCount:       2,
+			SeriesCount:       3,
+			Bytes:             30,
+			NewValuesCount:    1,
+			TopValuesBySeries: []*typesv1.LabelValueCardinality{{Value: "b", SeriesCount: 2, Bytes: 20, FirstSeen: 1600}},
+			TopValuesByBytes:  []*typesv1.LabelValueCardinality{{Value: "b", SeriesCount: 2, Bytes: 20, FirstSeen: 1600}},
+		},
+	}, out.Msg.Labels)
+}
+
+func Test_SelectProfilesMetadata(t *testing.T) {
+	req := connect.NewRequest(&typesv1.SelectProfilesMetadataRequest{
+		ProfileTypeID: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector: `{foo="bar"}`,
+		Predicates:    []string{"duration < 10s"},
+		Start:         1000,
+		End:           5000,
+		Limit:         2,
+	})
+	querier, err := New(Config{
+		PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+	}, 2), func(addr string) (client.PoolClient, error) {
+		q := newFakeQuerier()
+		profiles := []*typesv1.ProfileMetadata{
+			{ID: "a", Timestamp: 1000, DurationNanos: 1e9},
+			{ID: "b", Timestamp: 3000, DurationNanos: 2e9},
+			{ID: "c", Timestamp: 2000, DurationNanos: 3e9},
+		}
+		q.On("SelectProfilesMetadata", mock.Anything, mock.Anything).
+			Return(connect.NewResponse(&typesv1.SelectProfilesMetadataResponse{Profiles: profiles}), nil)
+		return q, nil
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	// Replicas are deduplicated, the most recent profiles come first.
+	out, err := querier.SelectProfilesMetadata(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []*typesv1.ProfileMetadata{
+		{ID: "b", Timestamp: 3000, DurationNanos: 2e9},
+		{ID: "c", Timestamp: 2000, DurationNanos: 3e9},
+	}, out.Msg.Profiles)
+
+	req.Msg.Predicates = []string{"duration ~ 10s"}
+	_, err = querier.SelectProfilesMetadata(context.Background(), req)
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
+func Test_SelectExemplars(t *testing.T) {
+	req := connect.NewRequest(&querierv1.SelectExemplarsRequest{
+		ProfileTypeID:       "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+		LabelSelector:       `{foo="bar"}`,
+		Start:               1000,
+		End:                 4999,
+		MinValue:            10,
+		Limit:               2,
+		HeatmapTimeBuckets:  2,
+		HeatmapValueBuckets: 2,
+	})
+	querier, err := New(Config{
+		PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+	}, 2), func(addr string) (client.PoolClient, error) {
+		q := newFakeQuerier()
+		exemplars := []*typesv1.Exemplar{
+			{ProfileId: "a", Timestamp: 1000, Value: 10},
+			{ProfileId: "b", Timestamp: 3000, Value: 30},
+			{ProfileId: "c", Timestamp: 2000, Value: 20},
+		}
+		q.On("SelectExemplars", mock.Anything, mock.MatchedBy(func(r *connect.Request[ingestv1.SelectExemplarsRequest]) bool {
+			return r.Msg.MinValue == 10 && r.Msg.Request.Type.ID == req.Msg.ProfileTypeID
+		})).Return(connect.NewResponse(&ingestv1.SelectExemplarsResponse{Exemplars: exemplars}), nil)
+		return q, nil
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	// Replicas are deduplicated, the highest values come first, and all
+	// the exemplars are counted in the heatmap.
+	out, err := querier.SelectExemplars(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []*typesv1.Exemplar{
+		{ProfileId: "b", Timestamp: 3000, Value: 30},
+		{ProfileId: "c", Timestamp: 2000, Value: 20},
+	}, out.Msg.Exemplars)
+	require.Equal(t, &querierv1.Heatmap{
+		Start:        1000,
+		End:          4999,
+		MinValue:     10,
+		MaxValue:     30,
+		TimeBuckets:  2,
+		ValueBuckets: 2,
+		Counts:       []uint64{2, 0, 0, 1},
+	}, out.Msg.Heatmap)
+
+	req.Msg.LabelSelector = `{foo=bar}`
+	_, err = querier.SelectExemplars(context.Background(), req)
+	require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+}
+
 func Test_SelectMergeStacktraces(t *testing.T) {
 	req := connect.NewRequest(&querierv1.SelectMergeStacktracesRequest{
 		LabelSelector: `{app="foo"}`,
@@ -241,7 +382,7 @@ func Test_SelectMergeStacktraces(t *testing.T) {
 			q.On("MergeProfilesStacktraces", mock.Anything).Once().Return(bidi3)
 		}
 		return q, nil
-	}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
 	require.NoError(t, err)
 	flame, err := querier.SelectMergeStacktraces(context.Background(), req)
 	require.NoError(t, err)
@@ -271,6 +412,126 @@ func Test_SelectMergeStacktraces(t *testing.T) {
 		}, selected)
 }
 
+func Test_SelectMergeStacktraces_MultiValue(t *testing.T) {
+	newBidi := func(multiValue bool) *fakeBidiClientStacktraces {
+		b := newFakeBidiClientStacktraces([]*ingestv1.ProfileSets{{
+			LabelsSets: []*typesv1.Labels{{Labels: []*typesv1.LabelPair{{Name: "app", Value: "foo"}}}},
+			Profiles:   []*ingestv1.SeriesProfile{{Timestamp: 1, LabelIndex: 0}},
+		}})
+		b.multiValue = multiValue
+		return b
+	}
+	newQuerier := func(t *testing.T, multiValue bool) *Querier {
+		querier, err := New(Config{
+			PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+		}, testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "1"}, {Addr: "2"}, {Addr: "3"}}, 3), func(addr string) (client.PoolClient, error) {
+			q := newFakeQuerier()
+			if multiValue {
+				// A single query merges the profile types.
+				q.On("MergeProfilesStacktraces", mock.Anything).Once().Return(newBidi(true))
+				return q, nil
+			}
+			// The query merging the profile types is followed
+			// by a query per profile type.
+			for i := 0; i < 3; i++ {
+				q.On("MergeProfilesStacktraces", mock.Anything).Once().Return(newBidi(false))
+			}
+			return q, nil
+		}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+		require.NoError(t, err)
+		return querier
+	}
+
+	req := &querierv1.SelectMergeStacktracesRequest{
+		LabelSelector:            `{app="foo"}`,
+		ProfileTypeID:            "memory:alloc_space:bytes:space:bytes",
+		AdditionalProfileTypeIDs: []string{"memory:alloc_objects:count:space:bytes"},
+		Start:                    0,
+		End:                      2,
+	}
+	for _, multiValue := range []bool{true, false} {
+		multiValue := multiValue
+		t.Run(fmt.Sprintf("multiValue=%v", multiValue), func(t *testing.T) {
+			querier := newQuerier(t, multiValue)
+			flame, err := querier.SelectMergeStacktraces(context.Background(), connect.NewRequest(req.CloneVT()))
+			require.NoError(t, err)
+			require.Nil(t, flame.Msg.Flamegraph)
+			fg := flame.Msg.MultiValueFlamegraph
+			require.Equal(t, []string{"memory:alloc_space:bytes:space:bytes", "memory:alloc_objects:count:space:bytes"}, fg.ProfileTypeIDs)
+			require.Equal(t, []int64{2, 2}, fg.Total)
+			require.Equal(t, []int64{2, 2}, fg.MaxSelf)
+			require.Equal(t, []string{"total", "buzz", "bar", "foo"}, fg.Names)
+			require.Equal(t, []int64{0, 2, 0, 0, 2, 0, 0}, fg.Levels[0].Values)
+			require.Equal(t, []int64{0, 2, 2, 0, 2, 2, 3}, fg.Levels[3].Values)
+		})
+	}
+
+	t.Run("tree format", func(t *testing.T) {
+		querier := newQuerier(t, true)
+		treeReq := req.CloneVT()
+		treeReq.Format = querierv1.ProfileFormat_PROFILE_FORMAT_TREE
+		res, err := querier.SelectMergeStacktraces(context.Background(), connect.NewRequest(treeReq))
+		require.NoError(t, err)
+		require.Nil(t, res.Msg.MultiValueFlamegraph)
+		require.Len(t, res.Msg.AdditionalTrees, 1)
+		for _, b := range [][]byte{res.Msg.Tree, res.Msg.AdditionalTrees[0]} {
+			tree, err := phlaremodel.UnmarshalTree(b)
+			require.NoError(t, err)
+			require.Equal(t, int64(2), tree.Total())
+		}
+	})
+
+	querier := newQuerier(t, true)
+	for _, invalid := range []*querierv1.SelectMergeStacktracesRequest{
+		{
+			ProfileTypeID:            "memory:alloc_space:bytes:space:bytes",
+			AdditionalProfileTypeIDs: []string{"process_cpu:cpu:nanoseconds:cpu:nanoseconds"},
+		},
+		{
+			ProfileTypeID:            "memory:alloc_space:bytes:space:bytes",
+			AdditionalProfileTypeIDs: []string{"memory:alloc_space:bytes:space:bytes"},
+		},
+	} {
+		_, err := querier.SelectMergeStacktraces(context.Background(), connect.NewRequest(invalid))
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	}
+}
+
+func Test_SelectMergeStacktraces_Export(t *testing.T) {
+	querier, err := New(Config{
+		PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+	}, testhelper.NewMockRing([]ring.InstanceDesc{{Addr: "1"}, {Addr: "2"}, {Addr: "3"}}, 3), func(addr string) (client.PoolClient, error) {
+		q := newFakeQuerier()
+		q.On("MergeProfilesStacktraces", mock.Anything).Once().Return(newFakeBidiClientStacktraces([]*ingestv1.ProfileSets{{
+			LabelsSets: []*typesv1.Labels{{Labels: []*typesv1.LabelPair{{Name: "app", Value: "foo"}}}},
+			Profiles:   []*ingestv1.SeriesProfile{{Timestamp: 1, LabelIndex: 0}},
+		}}))
+		return q, nil
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	req := &querierv1.SelectMergeStacktracesRequest{
+		LabelSelector: `{app="foo"}`,
+		ProfileTypeID: "memory:alloc_space:bytes:space:bytes",
+		Start:         0,
+		End:           2,
+		ExportFormat:  "collapsed",
+	}
+	res, err := querier.SelectMergeStacktraces(context.Background(), connect.NewRequest(req))
+	require.NoError(t, err)
+	require.Nil(t, res.Msg.Flamegraph)
+	require.Equal(t, "buzz;bar;foo 2\n", string(res.Msg.Export))
+
+	for _, invalid := range []*querierv1.SelectMergeStacktracesRequest{
+		{ProfileTypeID: req.ProfileTypeID, ExportFormat: "svg"},
+		{ProfileTypeID: req.ProfileTypeID, ExportFormat: "collapsed", Format: querierv1.ProfileFormat_PROFILE_FORMAT_TREE},
+		{ProfileTypeID: req.ProfileTypeID, ExportFormat: "collapsed", AdditionalProfileTypeIDs: []string{"memory:alloc_objects:count:space:bytes"}},
+	} {
+		_, err = querier.SelectMergeStacktraces(context.Background(), connect.NewRequest(invalid))
+		require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+	}
+}
+
 func Test_SelectMergeProfile(t *testing.T) {
 	req := connect.NewRequest(&querierv1.SelectMergeProfileRequest{
 		LabelSelector: `{app="foo"}`,
@@ -346,7 +607,7 @@ func Test_SelectMergeProfile(t *testing.T) {
 			q.On("MergeProfilesPprof", mock.Anything).Once().Return(bidi3)
 		}
 		return q, nil
-	}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
 	require.NoError(t, err)
 	res, err := querier.SelectMergeProfile(context.Background(), req)
 	require.NoError(t, err)
@@ -384,6 +645,75 @@ func Test_SelectMergeProfile(t *testing.T) {
 		}, selected)
 }
 
+func Test_WriteMergeProfile(t *testing.T) {
+	req := connect.NewRequest(&querierv1.SelectMergeProfileRequest{
+		LabelSelector: `{app="foo"}`,
+		ProfileTypeID: "memory:inuse_space:bytes:space:byte",
+		Start:         0,
+		End:           2,
+	})
+	// The ingesters reply with the same profiles, and send two partial
+	// results of the merge: only the results of one of them are read.
+	bidis := make([]*fakeBidiClientProfiles, 2)
+	for i := range bidis {
+		bidis[i] = newFakeBidiClientProfiles([]*ingestv1.ProfileSets{
+			{
+				LabelsSets: []*typesv1.Labels{
+					{
+						Labels: []*typesv1.LabelPair{{Name: "app", Value: "foo"}},
+					},
+				},
+				Profiles: []*ingestv1.SeriesProfile{
+					{Timestamp: 1, LabelIndex: 0},
+					{Timestamp: 2, LabelIndex: 0},
+				},
+			},
+		})
+	}
+	querier, err := New(Config{
+		PoolConfig: clientpool.PoolConfig{ClientCleanupPeriod: 1 * time.Millisecond},
+	}, testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+	}, 2), func(addr string) (client.PoolClient, error) {
+		q := newFakeQuerier()
+		switch addr {
+		case "1":
+			q.On("MergeProfilesPprof", mock.Anything).Once().Return(bidis[0])
+		case "2":
+			q.On("MergeProfilesPprof", mock.Anything).Once().Return(bidis[1])
+		}
+		return q, nil
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, querier.WriteMergeProfile(context.Background(), req, func(chunk []byte) error {
+		_, err := buf.Write(chunk)
+		return err
+	}))
+	actual, err := profile.ParseUncompressed(buf.Bytes())
+	require.NoError(t, err)
+	// The partial results are not compacted.
+	require.Len(t, actual.Sample, 2*len(pprofth.FooBarProfile.Sample))
+	require.Len(t, actual.Location, len(pprofth.FooBarProfile.Location))
+
+	merged, err := profile.Merge([]*profile.Profile{actual})
+	require.NoError(t, err)
+	var mergedBuf bytes.Buffer
+	require.NoError(t, merged.WriteUncompressed(&mergedBuf))
+	merged, err = profile.ParseUncompressed(mergedBuf.Bytes())
+	require.NoError(t, err)
+	expected := pprofth.FooBarProfile.Copy()
+	expected.DurationNanos = model.Time(req.Msg.End).UnixNano() - model.Time(req.Msg.Start).UnixNano()
+	expected.TimeNanos = model.Time(req.Msg.End).UnixNano()
+	for _, s := range expected.Sample {
+		s.Value[0] = s.Value[0] * 2
+	}
+	require.Equal(t, expected, merged)
+	require.Len(t, append(bidis[0].kept, bidis[1].kept...), 2)
+}
+
 func TestSelectSeries(t *testing.T) {
 	req := connect.NewRequest(&querierv1.SelectSeriesRequest{
 		LabelSelector: `{app="foo"}`,
@@ -460,7 +790,7 @@ func TestSelectSeries(t *testing.T) {
 			q.On("MergeProfilesLabels", mock.Anything).Once().Return(bidi3)
 		}
 		return q, nil
-	}, nil, nil, log.NewLogfmtLogger(os.Stdout))
+	}, validation.MockLimits{}, nil, nil, log.NewLogfmtLogger(os.Stdout))
 	require.NoError(t, err)
 	res, err := querier.SelectSeries(context.Background(), req)
 	require.NoError(t, err)
@@ -559,6 +889,54 @@ func (f *fakeQuerierIngester) Series(ctx context.Context, req *connect.Request[i
 	return res, err
 }
 
+func (f *fakeQuerierIngester) LabelCardinality(ctx context.Context, req *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	var (
+		args = f.Called(ctx, req)
+		res  *connect.Response[typesv1.LabelCardinalityResponse]
+		err  error
+	)
+	if args[0] != nil {
+		res = args[0].(*connect.Response[typesv1.LabelCardinalityResponse])
+	}
+	if args[1] != nil {
+		err = args.Get(1).(error)
+	}
+
+	return res, err
+}
+
+func (f *fakeQuerierIngester) SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	var (
+		args = f.Called(ctx, req)
+		res  *connect.Response[typesv1.SelectProfilesMetadataResponse]
+		err  error
+	)
+	if args[0] != nil {
+		res = args[0].(*connect.Response[typesv1.SelectProfilesMetadataResponse])
+	}
+	if args[1] != nil {
+		err = args.Get(1).(error)
+	}
+
+	return res, err
+}
+
+func (f *fakeQuerierIngester) SelectExemplars(ctx context.Context, req *connect.Request[ingestv1.SelectExemplarsRequest]) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+	var (
+		args = f.Called(ctx, req)
+		res  *connect.Response[ingestv1.SelectExemplarsResponse]
+		err  error
+	)
+	if args[0] != nil {
+		res = args[0].(*connect.Response[ingestv1.SelectExemplarsResponse])
+	}
+	if args[1] != nil {
+		err = args.Get(1).(error)
+	}
+
+	return res, err
+}
+
 type testProfile struct {
 	Ts     int64
 	Labels *typesv1.Labels
@@ -569,6 +947,11 @@ type fakeBidiClientStacktraces struct {
 	batches  []*ingestv1.ProfileSets
 	kept     []testProfile
 	cur      *ingestv1.ProfileSets
+
+	// multiValue makes the client merge the additional
+	// profile types of the request.
+	multiValue      bool
+	additionalTypes int
 }
 
 func newFakeBidiClientStacktraces(batches []*ingestv1.ProfileSets) *fakeBidiClientStacktraces {
@@ -583,6 +966,9 @@ func newFakeBidiClientStacktraces(batches []*ingestv1.ProfileSets) *fakeBidiClie
 
 func (f *fakeBidiClientStacktraces) Send(in *ingestv1.MergeProfilesStacktracesRequest) error {
 	if in.Request != nil {
+		if f.multiValue {
+			f.additionalTypes = len(in.AdditionalTypes)
+		}
 		return nil
 	}
 	for i, b := range in.Profiles {
@@ -605,14 +991,20 @@ func (f *fakeBidiClientStacktraces) Send(in *ingestv1.MergeProfilesStacktracesRe
 func (f *fakeBidiClientStacktraces) Receive() (*ingestv1.MergeProfilesStacktracesResponse, error) {
 	profiles := <-f.profiles
 	if profiles == nil {
-		return &ingestv1.MergeProfilesStacktracesResponse{
-			Result: &ingestv1.MergeProfilesStacktracesResult{
-				Format: ingestv1.StacktracesMergeFormat_MERGE_FORMAT_STACKTRACES,
-				Stacktraces: []*ingestv1.StacktraceSample{
-					{FunctionIds: []int32{0, 1, 2}, Value: 1},
-				},
-				FunctionNames: []string{"foo", "bar", "buzz"},
+		result := &ingestv1.MergeProfilesStacktracesResult{
+			Format: ingestv1.StacktracesMergeFormat_MERGE_FORMAT_STACKTRACES,
+			Stacktraces: []*ingestv1.StacktraceSample{
+				{FunctionIds: []int32{0, 1, 2}, Value: 1},
 			},
+			FunctionNames: []string{"foo", "bar", "buzz"},
+		}
+		for i := 0; i < f.additionalTypes; i++ {
+			m := phlaremodel.NewStackTraceMerger()
+			m.MergeStackTraces(result.Stacktraces, result.FunctionNames)
+			result.AdditionalTreeBytes = append(result.AdditionalTreeBytes, m.TreeBytes(-1))
+		}
+		return &ingestv1.MergeProfilesStacktracesResponse{
+			Result: result,
 		}, nil
 	}
 	f.cur = profiles
@@ -634,6 +1026,9 @@ type fakeBidiClientProfiles struct {
 	batches  []*ingestv1.ProfileSets
 	kept     []testProfile
 	cur      *ingestv1.ProfileSets
+
+	partialResults bool
+	results        int
 }
 
 func newFakeBidiClientProfiles(batches []*ingestv1.ProfileSets) *fakeBidiClientProfiles {
@@ -648,6 +1043,7 @@ func newFakeBidiClientProfiles(batches []*ingestv1.ProfileSets) *fakeBidiClientP
 
 func (f *fakeBidiClientProfiles) Send(in *ingestv1.MergeProfilesPprofRequest) error {
 	if in.Request != nil {
+		f.partialResults = in.PartialResults
 		return nil
 	}
 	for i, b := range in.Profiles {
@@ -669,6 +1065,9 @@ func (f *fakeBidiClientProfiles) Send(in *ingestv1.MergeProfilesPprofRequest) er
 
 func (f *fakeBidiClientProfiles) Receive() (*ingestv1.MergeProfilesPprofResponse, error) {
 	profiles := <-f.profiles
+	if profiles == nil && f.partialResults {
+		return f.partialResult()
+	}
 	if profiles == nil {
 		var buf bytes.Buffer
 		if err := pprofth.FooBarProfile.WriteUncompressed(&buf); err != nil {
@@ -683,6 +1082,30 @@ func (f *fakeBidiClientProfiles) Receive() (*ingestv1.MergeProfilesPprofResponse
 		SelectedProfiles: profiles,
 	}, nil
 }
+
+// partialResult replies with the end of the profile streaming signal,
+// followed by two partial results and the final message.
+func (f *fakeBidiClientProfiles) partialResult() (*ingestv1.MergeProfilesPprofResponse, error) {
+	f.results++
+	switch f.results {
+	case 1:
+		return &ingestv1.MergeProfilesPprofResponse{}, nil
+	case 2, 3:
+		var buf bytes.Buffer
+		if err := pprofth.FooBarProfile.WriteUncompressed(&buf); err != nil {
+			return nil, err
+		}
+		return &ingestv1.MergeProfilesPprofResponse{
+			Result:  buf.Bytes(),
+			Partial: true,
+		}, nil
+	default:
+		return &ingestv1.MergeProfilesPprofResponse{
+			Stats: &ingestv1.QueryStats{},
+		}, nil
+	}
+}
+
 func (f *fakeBidiClientProfiles) CloseRequest() error  { return nil }
 func (f *fakeBidiClientProfiles) CloseResponse() error { return nil }
 
@@ -692,7 +1115,8 @@ type fakeBidiClientSeries struct {
 	kept     []testProfile
 	cur      *ingestv1.ProfileSets
 
-	result []*typesv1.Series
+	result         []*typesv1.Series
+	stepAggregated bool
 }
 
 func newFakeBidiClientSeries(batches []*ingestv1.ProfileSets, result ...*typesv1.Series) *fakeBidiClientSeries {
@@ -731,7 +1155,8 @@ func (f *fakeBidiClientSeries) Receive() (*ingestv1.MergeProfilesLabelsResponse,
 	profiles := <-f.profiles
 	if profiles == nil {
 		return &ingestv1.MergeProfilesLabelsResponse{
-			Series: f.result,
+			Series:         f.result,
+			StepAggregated: f.stepAggregated,
 		}, nil
 	}
 	f.cur = profiles
@@ -842,12 +1267,49 @@ func TestRangeSeries(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			in := iter.NewSliceIterator(tc.in)
-			out := rangeSeries(in, 1, 5, 1)
+			out := rangeSeries(in, 1, 5, 1, typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM)
 			testhelper.EqualProto(t, tc.out, out)
 		})
 	}
 }
 
+func TestRangeSeriesAggregation(t *testing.T) {
+	lbs := phlaremodel.LabelsFromStrings("foo", "bar")
+	for _, tc := range []struct {
+		aggregation typesv1.TimeSeriesAggregationType
+		expected    []*typesv1.Point
+	}{
+		{
+			aggregation: typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_SUM,
+			expected:    []*typesv1.Point{{Timestamp: 1000, Value: 30}, {Timestamp: 3000, Value: 40}},
+		},
+		{
+			aggregation: typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_RATE,
+			expected:    []*typesv1.Point{{Timestamp: 1000, Value: 15}, {Timestamp: 3000, Value: 20}},
+		},
+		{
+			aggregation: typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_AVERAGE,
+			expected:    []*typesv1.Point{{Timestamp: 1000, Value: 15}, {Timestamp: 3000, Value: 40}},
+		},
+		{
+			aggregation: typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT,
+			expected:    []*typesv1.Point{{Timestamp: 1000, Value: 2}, {Timestamp: 3000, Value: 1}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.aggregation.String(), func(t *testing.T) {
+			in := iter.NewSliceIterator([]ProfileValue{
+				{Ts: 500, Value: 10, Lbs: lbs, LabelsHash: lbs.Hash()},
+				{Ts: 1000, Value: 20, Lbs: lbs, LabelsHash: lbs.Hash()},
+				{Ts: 3000, Value: 40, Lbs: lbs, LabelsHash: lbs.Hash()},
+				{Ts: 3001, Value: 50, Lbs: lbs, LabelsHash: lbs.Hash()},
+			})
+			out := rangeSeries(in, 1000, 3000, 2000, tc.aggregation)
+			testhelper.EqualProto(t, []*typesv1.Series{{Labels: lbs, Points: tc.expected}}, out)
+		})
+	}
+}
+
 func Test_splitQueryToStores(t *testing.T) {
 	for _, tc := range []struct {
 		name            string
@@ -1108,3 +1570,114 @@ func Test_splitQueryToStores(t *testing.T) {
 // 	}
 // 	return clients, nil
 // }
+
+func Test_IngesterQuerier_tenantRing(t *testing.T) {
+	ingesters := testhelper.NewMockRing([]ring.InstanceDesc{
+		{Addr: "1"},
+		{Addr: "2"},
+		{Addr: "3"},
+		{Addr: "4"},
+		{Addr: "5"},
+	}, 1)
+	ctx := tenant.InjectTenantID(context.Background(), "tenant")
+
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		cfg      Config
+		limits   validation.MockLimits
+		expected int
+	}{
+		{
+			name:     "shard size is not set",
+			ctx:      ctx,
+			cfg:      Config{ShuffleShardingIngestersEnabled: true},
+			expected: 5,
+		},
+		{
+			name:     "shuffle sharding",
+			ctx:      ctx,
+			cfg:      Config{ShuffleShardingIngestersEnabled: true},
+			limits:   validation.MockLimits{IngestionTenantShardSizeValue: 2},
+			expected: 2,
+		},
+		{
+			name:     "shuffle sharding is disabled",
+			ctx:      ctx,
+			limits:   validation.MockLimits{IngestionTenantShardSizeValue: 2},
+			expected: 5,
+		},
+		{
+			name:     "tenant is unknown",
+			ctx:      context.Background(),
+			cfg:      Config{ShuffleShardingIngestersEnabled: true},
+			limits:   validation.MockLimits{IngestionTenantShardSizeValue: 2},
+			expected: 5,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewIngesterQuerier(tc.cfg, nil, ingesters, tc.limits)
+			require.Equal(t, tc.expected, q.tenantRing(tc.ctx).InstancesCount())
+		})
+	}
+}
+
+func Test_singleZoneReplicationSet(t *testing.T) {
+	rs := singleZoneReplicationSet(ring.ReplicationSet{
+		Instances: []ring.InstanceDesc{
+			{Addr: "a-1", Zone: "a"},
+			{Addr: "a-2", Zone: "a"},
+			{Addr: "b-1", Zone: "b"},
+			{Addr: "b-2", Zone: "b"},
+			{Addr: "c-1", Zone: "c"},
+			{Addr: "c-2", Zone: "c"},
+		},
+		MaxUnavailableZones: 1,
+	})
+	require.Equal(t, 2, rs.MaxUnavailableZones)
+
+	query := func(failing string) ([]string, int32) {
+		var calls atomic.Int32
+		responses, err := forGivenReplicationSet(context.Background(), func(addr string) (string, error) {
+			return addr, nil
+		}, rs, func(_ context.Context, addr string) (string, error) {
+			calls.Inc()
+			if addr == failing {
+				return "", errors.New("failed")
+			}
+			return addr, nil
+		})
+		require.NoError(t, err)
+		addrs := make([]string, 0, len(responses))
+		for _, r := range responses {
+			addrs = append(addrs, r.response)
+		}
+		sort.Strings(addrs)
+		return addrs, calls.Load()
+	}
+
+	// All the instances of a single zone are queried.
+	for i := 0; i < 10; i++ {
+		addrs, calls := query("")
+		require.Len(t, addrs, 2)
+		require.Equal(t, addrs[0][0], addrs[1][0])
+		require.Equal(t, int32(2), calls)
+	}
+
+	// Other zones are queried if a zone fails.
+	for i := 0; i < 10; i++ {
+		addrs, _ := query("a-1")
+		require.Len(t, addrs, 2)
+		require.Equal(t, addrs[0][0], addrs[1][0])
+		require.NotEqual(t, byte('a'), addrs[0][0])
+	}
+}
+
+func Test_singleZoneReplicationSet_ZoneAwarenessDisabled(t *testing.T) {
+	rs := ring.ReplicationSet{
+		Instances: []ring.InstanceDesc{{Addr: "1"}, {Addr: "2"}, {Addr: "3"}},
+		MaxErrors: 1,
+	}
+	require.Equal(t, rs, singleZoneReplicationSet(rs))
+}
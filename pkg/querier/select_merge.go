@@ -2,6 +2,7 @@ package querier
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"sync"
@@ -22,6 +23,7 @@ import (
 	"github.com/grafana/pyroscope/pkg/iter"
 	phlaremodel "github.com/grafana/pyroscope/pkg/model"
 	"github.com/grafana/pyroscope/pkg/pprof"
+	"github.com/grafana/pyroscope/pkg/querier/stats"
 	"github.com/grafana/pyroscope/pkg/util"
 	"github.com/grafana/pyroscope/pkg/util/loser"
 )
@@ -193,12 +195,16 @@ func (s *mergeIterator[R, Req, Res]) Result() (R, error) {
 		s.err = err
 		return *new(R), err
 	}
+	st := stats.FromContext(s.ctx)
 	switch result := any(res).(type) {
 	case *ingestv1.MergeProfilesStacktracesResponse:
+		st.MergeQueryStats(result.Stats)
 		return any(result.Result).(R), nil
 	case *ingestv1.MergeProfilesLabelsResponse:
-		return any(result.Series).(R), nil
+		st.MergeQueryStats(result.Stats)
+		return any(result).(R), nil
 	case *ingestv1.MergeProfilesPprofResponse:
+		st.MergeQueryStats(result.Stats)
 		return any(result.Result).(R), nil
 	default:
 		return *new(R), fmt.Errorf("unexpected response type %T", result)
@@ -271,6 +277,20 @@ func skipDuplicates(ctx context.Context, its []MergeIterator) error {
 // selectMergeTree selects the  profile from each ingester by deduping them and
 // returns merge of stacktrace samples represented as a tree.
 func selectMergeTree(ctx context.Context, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesStacktraces]) (*phlaremodel.Tree, error) {
+	trees, err := selectMergeTrees(ctx, responses, 0)
+	if err != nil {
+		return nil, err
+	}
+	return trees[0], nil
+}
+
+// errAdditionalTypesNotSupported is returned when an ingester or a
+// store-gateway does not merge the additional profile types of a request.
+var errAdditionalTypesNotSupported = errors.New("additional profile types are not supported")
+
+// selectMergeTrees is like selectMergeTree, but also returns the trees of
+// the given number of additional profile types of the request.
+func selectMergeTrees(ctx context.Context, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesStacktraces], additional int) ([]*phlaremodel.Tree, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "selectMergeTree")
 	defer span.Finish()
 
@@ -301,6 +321,10 @@ func selectMergeTree(ctx context.Context, responses []ResponseFromReplica[client
 	g, _ := errgroup.WithContext(ctx)
 	m := phlaremodel.NewTreeMerger()
 	sm := phlaremodel.NewStackTraceMerger()
+	additionalMergers := make([]*phlaremodel.TreeMerger, additional)
+	for i := range additionalMergers {
+		additionalMergers[i] = phlaremodel.NewTreeMerger()
+	}
 	for _, iter := range mergeResults {
 		iter := iter
 		g.Go(util.RecoverPanic(func() error {
@@ -308,6 +332,14 @@ func selectMergeTree(ctx context.Context, responses []ResponseFromReplica[client
 			if err != nil || result == nil {
 				return err
 			}
+			if len(result.AdditionalTreeBytes) != additional {
+				return errAdditionalTypesNotSupported
+			}
+			for i, b := range result.AdditionalTreeBytes {
+				if err = additionalMergers[i].MergeTreeBytes(b); err != nil {
+					return err
+				}
+			}
 			switch result.Format {
 			default:
 				return fmt.Errorf("unknown merge result format")
@@ -331,11 +363,15 @@ func selectMergeTree(ctx context.Context, responses []ResponseFromReplica[client
 	}
 
 	span.LogFields(otlog.String("msg", "building tree"))
-	return m.Tree(), nil
+	trees := []*phlaremodel.Tree{m.Tree()}
+	for _, am := range additionalMergers {
+		trees = append(trees, am.Tree())
+	}
+	return trees, nil
 }
 
 // selectMergePprofProfile selects the  profile from each ingester by deduping them and request merges of stacktraces in the pprof format.
-func selectMergePprofProfile(ctx context.Context, ty *typesv1.ProfileType, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof]) (*googlev1.Profile, error) {
+func newMergePprofIterators(ctx context.Context, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof]) ([]MergeIterator, []MergeResult[[]byte]) {
 	mergeResults := make([]MergeResult[[]byte], len(responses))
 	iters := make([]MergeIterator, len(responses))
 	var wg sync.WaitGroup
@@ -353,7 +389,11 @@ func selectMergePprofProfile(ctx context.Context, ty *typesv1.ProfileType, respo
 		}(i, resp)
 	}
 	wg.Wait()
+	return iters, mergeResults
+}
 
+func selectMergePprofProfile(ctx context.Context, ty *typesv1.ProfileType, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof]) (*googlev1.Profile, error) {
+	iters, mergeResults := newMergePprofIterators(ctx, responses)
 	if err := skipDuplicates(ctx, iters); err != nil {
 		return nil, err
 	}
@@ -395,6 +435,51 @@ func selectMergePprofProfile(ctx context.Context, ty *typesv1.ProfileType, respo
 	return pprof.FromProfile(p)
 }
 
+// streamMergePprofProfile writes the partial merge results of the replicas
+// into w, one after another, as they are received: the replicas must have
+// been requested to send partial results.
+func streamMergePprofProfile(ctx context.Context, ty *typesv1.ProfileType, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesPprof], w *pprof.MergeWriter) error {
+	iters, _ := newMergePprofIterators(ctx, responses)
+	if err := skipDuplicates(ctx, iters); err != nil {
+		return err
+	}
+
+	var written bool
+	st := stats.FromContext(ctx)
+	for _, resp := range responses {
+		for {
+			res, err := resp.response.Receive()
+			if err != nil {
+				return err
+			}
+			st.MergeQueryStats(res.Stats)
+			if len(res.Result) > 0 {
+				p := new(googlev1.Profile)
+				if err = p.UnmarshalVT(res.Result); err != nil {
+					return err
+				}
+				if err = w.Write(p); err != nil {
+					return err
+				}
+				written = true
+			}
+			if !res.Partial {
+				break
+			}
+		}
+	}
+	if written {
+		return nil
+	}
+	empty := &profile.Profile{}
+	phlaremodel.SetProfileMetadata(empty, ty)
+	p, err := pprof.FromProfile(empty)
+	if err != nil {
+		return err
+	}
+	return w.Write(p)
+}
+
 type ProfileValue struct {
 	Ts         int64
 	Lbs        []*typesv1.LabelPair
@@ -411,15 +496,19 @@ func (p ProfileValue) Timestamp() model.Time {
 }
 
 // selectMergeSeries selects the  profile from each ingester by deduping them and request merges of total values.
-func selectMergeSeries(ctx context.Context, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels]) (iter.Iterator[ProfileValue], error) {
-	mergeResults := make([]MergeResult[[]*typesv1.Series], len(responses))
+// Points with matching timestamps are not summed up: they hold the value of a profile, or the partial aggregate
+// of a step if the step aggregation a is pushed down, and are combined by the step aggregation of the querier.
+// Stores that do not support the step aggregation return the points as if it was not requested: they are
+// aggregated here.
+func selectMergeSeries(ctx context.Context, responses []ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels], a *ingestv1.StepAggregation) (iter.Iterator[ProfileValue], error) {
+	mergeResults := make([]MergeResult[*ingestv1.MergeProfilesLabelsResponse], len(responses))
 	iters := make([]MergeIterator, len(responses))
 	var wg sync.WaitGroup
 	for i, resp := range responses {
 		wg.Add(1)
 		go func(i int, resp ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels]) {
 			defer wg.Done()
-			it := NewMergeIterator[[]*typesv1.Series](
+			it := NewMergeIterator[*ingestv1.MergeProfilesLabelsResponse](
 				ctx, ResponseFromReplica[BidiClientMerge[*ingestv1.MergeProfilesLabelsRequest, *ingestv1.MergeProfilesLabelsResponse]]{
 					addr:     resp.addr,
 					response: resp.response,
@@ -445,8 +534,11 @@ func selectMergeSeries(ctx context.Context, responses []ResponseFromReplica[clie
 			if err != nil || result == nil {
 				return err
 			}
+			if a != nil && !result.StepAggregated {
+				phlaremodel.AggregateSteps(result.Series, a.Start, a.Step, a.Aggregation)
+			}
 			s.Do(func() {
-				results = append(results, result)
+				results = append(results, result.Series)
 			})
 			return nil
 		}))
@@ -454,7 +546,7 @@ func selectMergeSeries(ctx context.Context, responses []ResponseFromReplica[clie
 	if err := g.Wait(); err != nil {
 		return nil, err
 	}
-	series := phlaremodel.SumSeries(results...)
+	series := phlaremodel.ConcatSeries(results...)
 	seriesIters := make([]iter.Iterator[ProfileValue], 0, len(series))
 	for _, s := range series {
 		s := s
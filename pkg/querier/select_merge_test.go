@@ -181,7 +181,7 @@ func TestSelectMergeByLabels(t *testing.T) {
 		{
 			response: resp3,
 		},
-	})
+	}, nil)
 	require.NoError(t, err)
 	// ensure we have correctly selected the right profiles
 	all := []testProfile{}
@@ -209,6 +209,49 @@ func TestSelectMergeByLabels(t *testing.T) {
 	}, values)
 }
 
+func TestSelectMergeByLabels_StepAggregation(t *testing.T) {
+	profiles := []*ingestv1.ProfileSets{{
+		LabelsSets: []*typesv1.Labels{{Labels: foobarlabels}},
+		Profiles: []*ingestv1.SeriesProfile{
+			{LabelIndex: 0, Timestamp: 1},
+			{LabelIndex: 0, Timestamp: 2},
+		},
+	}}
+	// The store aggregated the points per step.
+	aggregated := newFakeBidiClientSeries(profiles, &typesv1.Series{
+		Labels: foobarlabels,
+		Points: []*typesv1.Point{{Timestamp: 2, Value: 2.0}},
+	})
+	aggregated.stepAggregated = true
+	// The store does not support the step aggregation.
+	notAggregated := newFakeBidiClientSeries([]*ingestv1.ProfileSets{{
+		LabelsSets: []*typesv1.Labels{{Labels: foobarlabels}},
+		Profiles: []*ingestv1.SeriesProfile{
+			{LabelIndex: 0, Timestamp: 3},
+			{LabelIndex: 0, Timestamp: 4},
+		},
+	}}, &typesv1.Series{
+		Labels: foobarlabels,
+		Points: []*typesv1.Point{{Timestamp: 3, Value: 3.0}, {Timestamp: 4, Value: 4.0}},
+	})
+
+	res, err := selectMergeSeries(context.Background(), []ResponseFromReplica[clientpool.BidiClientMergeProfilesLabels]{
+		{response: aggregated},
+		{response: notAggregated},
+	}, &ingestv1.StepAggregation{
+		Start:       0,
+		Step:        2,
+		Aggregation: typesv1.TimeSeriesAggregationType_TIME_SERIES_AGGREGATION_TYPE_COUNT,
+	})
+	require.NoError(t, err)
+	values, err := iter.Slice(res)
+	require.NoError(t, err)
+	require.Equal(t, []ProfileValue{
+		{Ts: 2, Value: 2.0, Lbs: foobarlabels, LabelsHash: foobarlabels.Hash()},
+		{Ts: 4, Value: 2.0, Lbs: foobarlabels, LabelsHash: foobarlabels.Hash()},
+	}, values)
+}
+
 func BenchmarkSelectMergeStacktraces(b *testing.B) {
 	rf := 3
 	clientsCount := 20
@@ -0,0 +1,178 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/dskit/tracing"
+
+	"github.com/grafana/pyroscope/pkg/tenant"
+)
+
+// Response headers carrying the statistics of the query.
+const (
+	HeaderWallTime         = "X-Pyroscope-Query-Wall-Time"
+	HeaderSeries           = "X-Pyroscope-Query-Series"
+	HeaderMergedProfiles   = "X-Pyroscope-Query-Merged-Profiles"
+	HeaderReadBytes        = "X-Pyroscope-Query-Read-Bytes"
+	HeaderReadPages        = "X-Pyroscope-Query-Read-Pages"
+	HeaderReadRows         = "X-Pyroscope-Query-Read-Rows"
+	HeaderLoadedPartitions = "X-Pyroscope-Query-Loaded-Partitions"
+	HeaderQueriedBlocks    = "X-Pyroscope-Query-Queried-Blocks"
+	HeaderSplitQueries     = "X-Pyroscope-Query-Split-Queries"
+	HeaderShardedQueries   = "X-Pyroscope-Query-Sharded-Queries"
+)
+
+// SetHeaders sets the statistics as response headers.
+func (s *Stats) SetHeaders(h http.Header) {
+	if s == nil {
+		return
+	}
+
+	h.Set(HeaderWallTime, s.LoadWallTime().String())
+	h.Set(HeaderSeries, strconv.FormatUint(s.LoadFetchedSeries(), 10))
+	h.Set(HeaderMergedProfiles, strconv.FormatUint(s.LoadMergedProfiles(), 10))
+	h.Set(HeaderReadBytes, strconv.FormatUint(s.LoadReadBytes(), 10))
+	h.Set(HeaderReadPages, strconv.FormatUint(s.LoadReadPages(), 10))
+	h.Set(HeaderReadRows, strconv.FormatUint(s.LoadReadRows(), 10))
+	h.Set(HeaderLoadedPartitions, strconv.FormatUint(s.LoadLoadedPartitions(), 10))
+	h.Set(HeaderQueriedBlocks, strconv.FormatUint(s.LoadQueriedBlocks(), 10))
+	h.Set(HeaderSplitQueries, strconv.FormatUint(uint64(s.LoadSplitQueries()), 10))
+	h.Set(HeaderShardedQueries, strconv.FormatUint(uint64(s.LoadShardedQueries()), 10))
+}
+
+// Reporter collects the statistics of the queries it wraps, returns them
+// to the caller as response headers, and logs the queries exceeding the
+// configured thresholds.
+//
+// Reporter is both a connect interceptor and an HTTP middleware.
+type Reporter struct {
+	logger        log.Logger
+	longerThan    time.Duration
+	readMoreThan  uint64
+	alwaysLogging bool
+}
+
+// NewReporter returns a new Reporter. Queries taking longer than longerThan
+// or reading more than readMoreThan bytes are logged; a zero value disables
+// the respective threshold. A negative longerThan logs all the queries.
+func NewReporter(logger log.Logger, longerThan time.Duration, readMoreThan uint64) *Reporter {
+	return &Reporter{
+		logger:        logger,
+		longerThan:    longerThan,
+		readMoreThan:  readMoreThan,
+		alwaysLogging: longerThan < 0,
+	}
+}
+
+func (r *Reporter) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		stats, ctx := ContextWithEmptyStats(ctx)
+		begin := time.Now()
+		resp, err := next(ctx, req)
+		duration := time.Since(begin)
+		if err == nil {
+			stats.SetHeaders(resp.Header())
+		}
+		r.report(ctx, stats, duration, err, "route", req.Spec().Procedure, "parameters", req.Any())
+		return resp, err
+	}
+}
+
+func (r *Reporter) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (r *Reporter) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
+
+// Wrap implements middleware.Interface.
+func (r *Reporter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		stats, ctx := ContextWithEmptyStats(req.Context())
+		begin := time.Now()
+		next.ServeHTTP(&headersWriter{ResponseWriter: w, stats: stats}, req.WithContext(ctx))
+		r.report(ctx, stats, time.Since(begin), nil, "route", req.URL.Path, "parameters", req.URL.RawQuery)
+	})
+}
+
+func (r *Reporter) report(ctx context.Context, stats *Stats, duration time.Duration, err error, keyvals ...interface{}) {
+	if !r.shouldLog(stats, duration) {
+		return
+	}
+	tenantID, tenantErr := tenant.ExtractTenantIDsFromContext(ctx)
+	if tenantErr != nil {
+		tenantID = tenant.DefaultTenantID
+	}
+	traceID, ok := tracing.ExtractTraceID(ctx)
+	if !ok {
+		traceID = "unknown"
+	}
+	keyvals = append([]interface{}{"msg", "query stats"}, keyvals...)
+	keyvals = append(keyvals,
+		"tenant", tenantID,
+		"traceID", traceID,
+		"duration", duration,
+		"wall_time", stats.LoadWallTime(),
+		"series", stats.LoadFetchedSeries(),
+		"merged_profiles", stats.LoadMergedProfiles(),
+		"read_bytes", stats.LoadReadBytes(),
+		"read_pages", stats.LoadReadPages(),
+		"read_rows", stats.LoadReadRows(),
+		"loaded_partitions", stats.LoadLoadedPartitions(),
+		"queried_blocks", stats.LoadQueriedBlocks(),
+		"split_queries", stats.LoadSplitQueries(),
+		"sharded_queries", stats.LoadShardedQueries(),
+	)
+	if err != nil {
+		keyvals = append(keyvals, "err", err)
+	}
+	level.Info(r.logger).Log(keyvals...)
+}
+
+func (r *Reporter) shouldLog(stats *Stats, duration time.Duration) bool {
+	switch {
+	case r.alwaysLogging:
+		return true
+	case r.longerThan > 0 && duration > r.longerThan:
+		return true
+	case r.readMoreThan > 0 && stats.LoadReadBytes() > r.readMoreThan:
+		return true
+	default:
+		return false
+	}
+}
+
+// headersWriter sets the statistics as response headers right before
+// the handler writes the response, when the query has been completed.
+type headersWriter struct {
+	http.ResponseWriter
+	stats       *Stats
+	wroteHeader bool
+}
+
+func (w *headersWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if statusCode < http.StatusBadRequest {
+			w.stats.SetHeaders(w.Header())
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headersWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
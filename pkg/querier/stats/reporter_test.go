@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporter_Wrap(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		longerThan   time.Duration
+		readMoreThan uint64
+		logged       bool
+	}{
+		{name: "disabled"},
+		{name: "all queries", longerThan: -1, logged: true},
+		{name: "faster than threshold", longerThan: time.Hour},
+		{name: "read bytes above threshold", readMoreThan: 100, logged: true},
+		{name: "read bytes below threshold", readMoreThan: 1000},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := NewReporter(log.NewLogfmtLogger(&buf), tc.longerThan, tc.readMoreThan)
+			h := r.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				st := FromContext(req.Context())
+				st.AddReadBytes(512)
+				st.AddFetchedSeries(3)
+				_, _ = w.Write([]byte("ok"))
+			}))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pyroscope/render?query=foo", nil))
+
+			assert.Equal(t, "ok", w.Body.String())
+			assert.Equal(t, "512", w.Header().Get(HeaderReadBytes))
+			assert.Equal(t, "3", w.Header().Get(HeaderSeries))
+			if tc.logged {
+				assert.Contains(t, buf.String(), "read_bytes=512")
+				assert.Contains(t, buf.String(), "route=/pyroscope/render")
+			} else {
+				assert.Empty(t, buf.String())
+			}
+		})
+	}
+}
+
+func TestReporter_WrapError(t *testing.T) {
+	r := NewReporter(log.NewNopLogger(), 0, 0)
+	h := r.Wrap(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		FromContext(req.Context()).AddReadBytes(512)
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pyroscope/render", nil))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get(HeaderReadBytes))
+}
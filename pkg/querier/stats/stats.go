@@ -10,6 +10,7 @@ import (
 	"sync/atomic" //lint:ignore faillint we can't use go.uber.org/atomic with a protobuf struct without wrapping it.
 	"time"
 
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 	"github.com/grafana/pyroscope/pkg/util/httpgrpc"
 )
 
@@ -155,6 +156,102 @@ func (s *Stats) LoadSplitQueries() uint32 {
 	return atomic.LoadUint32(&s.SplitQueries)
 }
 
+func (s *Stats) AddMergedProfiles(profiles uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.MergedProfiles, profiles)
+}
+
+func (s *Stats) LoadMergedProfiles() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.MergedProfiles)
+}
+
+func (s *Stats) AddReadBytes(bytes uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.ReadBytes, bytes)
+}
+
+func (s *Stats) LoadReadBytes() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.ReadBytes)
+}
+
+func (s *Stats) AddReadPages(pages uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.ReadPages, pages)
+}
+
+func (s *Stats) LoadReadPages() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.ReadPages)
+}
+
+func (s *Stats) AddReadRows(rows uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.ReadRows, rows)
+}
+
+func (s *Stats) LoadReadRows() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.ReadRows)
+}
+
+func (s *Stats) AddLoadedPartitions(partitions uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.LoadedPartitions, partitions)
+}
+
+func (s *Stats) LoadLoadedPartitions() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.LoadedPartitions)
+}
+
+func (s *Stats) AddQueriedBlocks(blocks uint64) {
+	if s == nil {
+		return
+	}
+
+	atomic.AddUint64(&s.QueriedBlocks, blocks)
+}
+
+func (s *Stats) LoadQueriedBlocks() uint64 {
+	if s == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&s.QueriedBlocks)
+}
+
 // Merge the provided Stats into this one.
 func (s *Stats) Merge(other *Stats) {
 	if s == nil || other == nil {
@@ -168,6 +265,46 @@ func (s *Stats) Merge(other *Stats) {
 	s.AddShardedQueries(other.LoadShardedQueries())
 	s.AddSplitQueries(other.LoadSplitQueries())
 	s.AddFetchedIndexBytes(other.LoadFetchedIndexBytes())
+	s.AddMergedProfiles(other.LoadMergedProfiles())
+	s.AddReadBytes(other.LoadReadBytes())
+	s.AddReadPages(other.LoadReadPages())
+	s.AddReadRows(other.LoadReadRows())
+	s.AddLoadedPartitions(other.LoadLoadedPartitions())
+	s.AddQueriedBlocks(other.LoadQueriedBlocks())
+}
+
+// MergeQueryStats merges the statistics reported by an ingester or a
+// store-gateway into this one.
+func (s *Stats) MergeQueryStats(other *ingestv1.QueryStats) {
+	if s == nil || other == nil {
+		return
+	}
+
+	s.AddFetchedSeries(other.SeriesCount)
+	s.AddMergedProfiles(other.MergedProfiles)
+	s.AddReadBytes(other.ReadBytes)
+	s.AddReadPages(other.ReadPages)
+	s.AddReadRows(other.ReadRows)
+	s.AddLoadedPartitions(other.LoadedPartitions)
+	s.AddQueriedBlocks(other.QueriedBlocks)
+}
+
+// QueryStats returns the statistics to be sent along with the result of a
+// MergeProfiles* call.
+func (s *Stats) QueryStats() *ingestv1.QueryStats {
+	if s == nil {
+		return nil
+	}
+
+	return &ingestv1.QueryStats{
+		SeriesCount:      s.LoadFetchedSeries(),
+		MergedProfiles:   s.LoadMergedProfiles(),
+		ReadBytes:        s.LoadReadBytes(),
+		ReadPages:        s.LoadReadPages(),
+		ReadRows:         s.LoadReadRows(),
+		LoadedPartitions: s.LoadLoadedPartitions(),
+		QueriedBlocks:    s.LoadQueriedBlocks(),
+	}
 }
 
 func ShouldTrackHTTPGRPCResponse(r *httpgrpc.HTTPResponse) bool {
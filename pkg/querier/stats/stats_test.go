@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
 )
 
 func TestStats_WallTime(t *testing.T) {
@@ -124,6 +126,8 @@ func TestStats_Merge(t *testing.T) {
 		stats1.AddFetchedChunks(10)
 		stats1.AddShardedQueries(20)
 		stats1.AddSplitQueries(10)
+		stats1.AddMergedProfiles(5)
+		stats1.AddReadBytes(1024)
 
 		stats2 := &Stats{}
 		stats2.AddWallTime(time.Second)
@@ -132,6 +136,8 @@ func TestStats_Merge(t *testing.T) {
 		stats2.AddFetchedChunks(11)
 		stats2.AddShardedQueries(21)
 		stats2.AddSplitQueries(11)
+		stats2.AddMergedProfiles(6)
+		stats2.AddReadBytes(2048)
 
 		stats1.Merge(stats2)
 
@@ -141,6 +147,8 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, uint64(21), stats1.LoadFetchedChunks())
 		assert.Equal(t, uint32(41), stats1.LoadShardedQueries())
 		assert.Equal(t, uint32(21), stats1.LoadSplitQueries())
+		assert.Equal(t, uint64(11), stats1.LoadMergedProfiles())
+		assert.Equal(t, uint64(3072), stats1.LoadReadBytes())
 	})
 
 	t.Run("merge two nil stats objects", func(t *testing.T) {
@@ -157,3 +165,37 @@ func TestStats_Merge(t *testing.T) {
 		assert.Equal(t, uint32(0), stats1.LoadSplitQueries())
 	})
 }
+
+func TestStats_MergeQueryStats(t *testing.T) {
+	t.Run("merge query stats", func(t *testing.T) {
+		stats := &Stats{}
+		qs := &ingestv1.QueryStats{
+			SeriesCount:      1,
+			MergedProfiles:   2,
+			ReadBytes:        3,
+			ReadPages:        4,
+			ReadRows:         5,
+			LoadedPartitions: 6,
+			QueriedBlocks:    7,
+		}
+		stats.MergeQueryStats(qs)
+		stats.MergeQueryStats(qs)
+
+		assert.Equal(t, &ingestv1.QueryStats{
+			SeriesCount:      2,
+			MergedProfiles:   4,
+			ReadBytes:        6,
+			ReadPages:        8,
+			ReadRows:         10,
+			LoadedPartitions: 12,
+			QueriedBlocks:    14,
+		}, stats.QueryStats())
+	})
+
+	t.Run("merge query stats nil receiver", func(t *testing.T) {
+		var stats *Stats
+		stats.MergeQueryStats(&ingestv1.QueryStats{SeriesCount: 1})
+
+		assert.Nil(t, stats.QueryStats())
+	})
+}
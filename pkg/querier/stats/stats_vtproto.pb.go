@@ -48,6 +48,36 @@ func (m *Stats) MarshalToSizedBufferVT(dAtA []byte) (int, error) {
 		i -= len(m.unknownFields)
 		copy(dAtA[i:], m.unknownFields)
 	}
+	if m.QueriedBlocks != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.QueriedBlocks))
+		i--
+		dAtA[i] = 0x68
+	}
+	if m.LoadedPartitions != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.LoadedPartitions))
+		i--
+		dAtA[i] = 0x60
+	}
+	if m.ReadRows != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.ReadRows))
+		i--
+		dAtA[i] = 0x58
+	}
+	if m.ReadPages != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.ReadPages))
+		i--
+		dAtA[i] = 0x50
+	}
+	if m.ReadBytes != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.ReadBytes))
+		i--
+		dAtA[i] = 0x48
+	}
+	if m.MergedProfiles != 0 {
+		i = encodeVarint(dAtA, i, uint64(m.MergedProfiles))
+		i--
+		dAtA[i] = 0x40
+	}
 	if m.FetchedIndexBytes != 0 {
 		i = encodeVarint(dAtA, i, uint64(m.FetchedIndexBytes))
 		i--
@@ -124,6 +154,24 @@ func (m *Stats) SizeVT() (n int) {
 	if m.FetchedIndexBytes != 0 {
 		n += 1 + sov(uint64(m.FetchedIndexBytes))
 	}
+	if m.MergedProfiles != 0 {
+		n += 1 + sov(uint64(m.MergedProfiles))
+	}
+	if m.ReadBytes != 0 {
+		n += 1 + sov(uint64(m.ReadBytes))
+	}
+	if m.ReadPages != 0 {
+		n += 1 + sov(uint64(m.ReadPages))
+	}
+	if m.ReadRows != 0 {
+		n += 1 + sov(uint64(m.ReadRows))
+	}
+	if m.LoadedPartitions != 0 {
+		n += 1 + sov(uint64(m.LoadedPartitions))
+	}
+	if m.QueriedBlocks != 0 {
+		n += 1 + sov(uint64(m.QueriedBlocks))
+	}
 	n += len(m.unknownFields)
 	return n
 }
@@ -296,6 +344,120 @@ func (m *Stats) UnmarshalVT(dAtA []byte) error {
 					break
 				}
 			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MergedProfiles", wireType)
+			}
+			m.MergedProfiles = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MergedProfiles |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReadBytes", wireType)
+			}
+			m.ReadBytes = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ReadBytes |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReadPages", wireType)
+			}
+			m.ReadPages = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ReadPages |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ReadRows", wireType)
+			}
+			m.ReadRows = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ReadRows |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LoadedPartitions", wireType)
+			}
+			m.LoadedPartitions = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.LoadedPartitions |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field QueriedBlocks", wireType)
+			}
+			m.QueriedBlocks = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflow
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.QueriedBlocks |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skip(dAtA[iNdEx:])
@@ -0,0 +1,84 @@
+package querier
+
+import (
+	"fmt"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+)
+
+const (
+	dimensionTime  = "time"
+	dimensionBytes = "bytes"
+)
+
+// units maps the units values can be converted between to their dimension
+// and their size in the smallest unit of the dimension.
+var units = map[string]struct {
+	dimension string
+	size      float64
+}{
+	"nanoseconds":  {dimension: dimensionTime, size: 1},
+	"microseconds": {dimension: dimensionTime, size: 1e3},
+	"milliseconds": {dimension: dimensionTime, size: 1e6},
+	"seconds":      {dimension: dimensionTime, size: 1e9},
+	"bytes":        {dimension: dimensionBytes, size: 1},
+	"kilobytes":    {dimension: dimensionBytes, size: 1 << 10},
+	"megabytes":    {dimension: dimensionBytes, size: 1 << 20},
+	"gigabytes":    {dimension: dimensionBytes, size: 1 << 30},
+}
+
+// unitConversion converts the values of a profile type to another unit.
+type unitConversion struct {
+	// scaleByPeriod indicates that the values, counted in samples, are
+	// multiplied by the sampling period of their profiles, which yields
+	// values in the unit of the period. This is done where the profiles
+	// are read, as the period may differ from one profile to another.
+	// Profiles whose period is not known cannot be converted: the query
+	// fails rather than returning values in the wrong unit.
+	scaleByPeriod bool
+	// factor the values are multiplied by, once scaled by the period.
+	factor float64
+}
+
+// newUnitConversion returns the conversion of the values of the profile
+// type to the given unit. No conversion is needed if the unit is empty.
+//
+// Samples can only be converted if they were taken at a time interval,
+// e.g. samples of process_cpu:samples:count:cpu:nanoseconds: the count
+// of allocated objects multiplied by the memory sampling rate is not a
+// size.
+func newUnitConversion(t *typesv1.ProfileType, unit string) (unitConversion, error) {
+	c := unitConversion{factor: 1}
+	if unit == "" || unit == t.SampleUnit {
+		return c, nil
+	}
+	from := t.SampleUnit
+	if from == "count" && units[t.PeriodUnit].dimension == dimensionTime {
+		c.scaleByPeriod = true
+		from = t.PeriodUnit
+	}
+	src, ok := units[from]
+	if !ok {
+		return c, fmt.Errorf("values in %s cannot be converted to %s", t.SampleUnit, unit)
+	}
+	dst, ok := units[unit]
+	if !ok {
+		return c, fmt.Errorf("unknown unit %q", unit)
+	}
+	if src.dimension != dst.dimension {
+		return c, fmt.Errorf("values in %s cannot be converted to %s", t.SampleUnit, unit)
+	}
+	c.factor = src.size / dst.size
+	return c, nil
+}
+
+func (c unitConversion) convertSeries(series []*typesv1.Series) {
+	if c.factor == 1 {
+		return
+	}
+	for _, s := range series {
+		for _, p := range s.Points {
+			p.Value *= c.factor
+		}
+	}
+}
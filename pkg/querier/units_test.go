@@ -0,0 +1,86 @@
+package querier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	phlaremodel "github.com/grafana/pyroscope/pkg/model"
+)
+
+func Test_newUnitConversion(t *testing.T) {
+	for _, tc := range []struct {
+		profileType string
+		unit        string
+
+		expected unitConversion
+		err      bool
+	}{
+		{
+			profileType: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+			expected:    unitConversion{factor: 1},
+		},
+		{
+			profileType: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+			unit:        "nanoseconds",
+			expected:    unitConversion{factor: 1},
+		},
+		{
+			profileType: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+			unit:        "seconds",
+			expected:    unitConversion{factor: 1e-9},
+		},
+		{
+			profileType: "process_cpu:samples:count:cpu:nanoseconds",
+			unit:        "nanoseconds",
+			expected:    unitConversion{scaleByPeriod: true, factor: 1},
+		},
+		{
+			profileType: "process_cpu:samples:count:cpu:nanoseconds",
+			unit:        "milliseconds",
+			expected:    unitConversion{scaleByPeriod: true, factor: 1e-6},
+		},
+		{
+			profileType: "memory:inuse_space:bytes:space:bytes",
+			unit:        "megabytes",
+			expected:    unitConversion{factor: 1.0 / (1 << 20)},
+		},
+		{
+			profileType: "memory:inuse_objects:count:space:bytes",
+			unit:        "bytes",
+			err:         true,
+		},
+		{
+			profileType: "memory:inuse_space:bytes:space:bytes",
+			unit:        "seconds",
+			err:         true,
+		},
+		{
+			profileType: "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+			unit:        "fortnights",
+			err:         true,
+		},
+	} {
+		tc := tc
+		t.Run(tc.profileType+"/"+tc.unit, func(t *testing.T) {
+			profileType, err := phlaremodel.ParseProfileTypeSelector(tc.profileType)
+			require.NoError(t, err)
+			c, err := newUnitConversion(profileType, tc.unit)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, c)
+		})
+	}
+}
+
+func Test_unitConversion_convertSeries(t *testing.T) {
+	series := []*typesv1.Series{{Points: []*typesv1.Point{{Value: 3 << 20}, {Value: 1 << 19}}}}
+	unitConversion{factor: 1.0 / (1 << 20)}.convertSeries(series)
+	assert.Equal(t, 3.0, series[0].Points[0].Value)
+	assert.Equal(t, 0.5, series[0].Points[1].Value)
+}
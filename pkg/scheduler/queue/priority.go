@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HeaderQueryPriority is the request header specifying the priority
+// of the query explicitly. Recognised values are "interactive" and "batch".
+const HeaderQueryPriority = "X-Pyroscope-Query-Priority"
+
+// Priority of a request determines the lane of the tenant queue the
+// request is put into. Within a tenant, requests of a higher priority
+// are always dequeued first; tenants are still served in a round-robin
+// fashion regardless of the priority of their requests.
+type Priority int
+
+const (
+	// PriorityInteractive is the priority of the requests a user is waiting
+	// for, such as dashboard and explore queries. This is the default.
+	PriorityInteractive Priority = iota
+	// PriorityBatch is the priority of expensive requests, such as
+	// queries spanning a long time range or exports.
+	PriorityBatch
+
+	numPriorities = iota
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBatch:
+		return "batch"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePriority parses the priority from its string representation.
+func ParsePriority(s string) (Priority, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "interactive":
+		return PriorityInteractive, true
+	case "batch":
+		return PriorityBatch, true
+	default:
+		return PriorityInteractive, false
+	}
+}
+
+// PriorityFromHeader returns the priority specified in the request
+// header. Requests without a valid priority are considered interactive.
+func PriorityFromHeader(h http.Header) Priority {
+	p, _ := ParsePriority(h.Get(HeaderQueryPriority))
+	return p
+}
@@ -61,13 +61,17 @@ type RequestQueue struct {
 	queues  *queues
 	stopped bool
 
-	queueLength       *prometheus.GaugeVec   // Per user and reason.
-	discardedRequests *prometheus.CounterVec // Per user.
+	queueLength       *prometheus.GaugeVec   // Per user and priority.
+	discardedRequests *prometheus.CounterVec // Per user and priority.
 }
 
-func NewRequestQueue(maxOutstandingPerTenant int, forgetDelay time.Duration, queueLength *prometheus.GaugeVec, discardedRequests *prometheus.CounterVec) *RequestQueue {
+// NewRequestQueue creates a new RequestQueue. The maxOutstandingPerTenant and
+// maxOutstandingBatchPerTenant limits apply to the interactive and batch lanes
+// of the tenant queue respectively. If maxOutstandingBatchPerTenant is 0, the
+// interactive lane limit is used.
+func NewRequestQueue(maxOutstandingPerTenant, maxOutstandingBatchPerTenant int, forgetDelay time.Duration, queueLength *prometheus.GaugeVec, discardedRequests *prometheus.CounterVec) *RequestQueue {
 	q := &RequestQueue{
-		queues:                  newUserQueues(maxOutstandingPerTenant, forgetDelay),
+		queues:                  newUserQueues(maxOutstandingPerTenant, maxOutstandingBatchPerTenant, forgetDelay),
 		connectedQuerierWorkers: atomic.NewInt32(0),
 		queueLength:             queueLength,
 		discardedRequests:       discardedRequests,
@@ -81,10 +85,10 @@ func NewRequestQueue(maxOutstandingPerTenant int, forgetDelay time.Duration, que
 
 // EnqueueRequest puts the request into the queue. MaxQueries is user-specific value that specifies how many queriers can
 // this user use (zero or negative = all queriers). It is passed to each EnqueueRequest, because it can change
-// between calls.
+// between calls. Priority determines the lane of the user queue the request is put into.
 //
 // If request is successfully enqueued, successFn is called with the lock held, before any querier can receive the request.
-func (q *RequestQueue) EnqueueRequest(userID string, req Request, maxQueriers int, successFn func()) error {
+func (q *RequestQueue) EnqueueRequest(userID string, req Request, priority Priority, maxQueriers int, successFn func()) error {
 	q.mtx.Lock()
 	defer q.mtx.Unlock()
 
@@ -98,19 +102,22 @@ func (q *RequestQueue) EnqueueRequest(userID string, req Request, maxQueriers in
 		return errors.New("no queue found")
 	}
 
-	select {
-	case queue <- req:
-		q.queueLength.WithLabelValues(userID).Inc()
-		q.cond.Broadcast()
-		// Call this function while holding a lock. This guarantees that no querier can fetch the request before function returns.
-		if successFn != nil {
-			successFn()
-		}
-		return nil
-	default:
-		q.discardedRequests.WithLabelValues(userID).Inc()
+	if priority < 0 || priority >= numPriorities {
+		priority = PriorityInteractive
+	}
+
+	if !queue.enqueue(req, priority) {
+		q.discardedRequests.WithLabelValues(userID, priority.String()).Inc()
 		return ErrTooManyRequests
 	}
+
+	q.queueLength.WithLabelValues(userID, priority.String()).Inc()
+	q.cond.Broadcast()
+	// Call this function while holding a lock. This guarantees that no querier can fetch the request before function returns.
+	if successFn != nil {
+		successFn()
+	}
+	return nil
 }
 
 // GetNextRequestForQuerier find next user queue and takes the next request off of it. Will block if there are no requests.
@@ -144,14 +151,14 @@ FindQueue:
 			break
 		}
 
-		// Pick next request from the queue.
+		// Pick next request from the queue, interactive requests first.
 		for {
-			request := <-queue
-			if len(queue) == 0 {
+			request, priority := queue.dequeue()
+			if queue.len() == 0 {
 				q.queues.deleteQueue(userID)
 			}
 
-			q.queueLength.WithLabelValues(userID).Dec()
+			q.queueLength.WithLabelValues(userID, priority.String()).Dec()
 
 			// Tell close() we've processed a request.
 			q.cond.Broadcast()
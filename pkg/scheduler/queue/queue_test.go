@@ -16,6 +16,7 @@ import (
 	"github.com/grafana/dskit/services"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -28,9 +29,9 @@ func BenchmarkGetNextRequest(b *testing.B) {
 	queues := make([]*RequestQueue, 0, b.N)
 
 	for n := 0; n < b.N; n++ {
-		queue := NewRequestQueue(maxOutstandingPerTenant, 0,
-			promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"tenant"}),
-			promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"tenant"}),
+		queue := NewRequestQueue(maxOutstandingPerTenant, 0, 0,
+			promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"tenant", "priority"}),
+			promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"tenant", "priority"}),
 		)
 		queues = append(queues, queue)
 
@@ -42,7 +43,7 @@ func BenchmarkGetNextRequest(b *testing.B) {
 			for j := 0; j < numTenants; j++ {
 				tenantID := strconv.Itoa(j)
 
-				err := queue.EnqueueRequest(tenantID, "request", 0, nil)
+				err := queue.EnqueueRequest(tenantID, "request", PriorityInteractive, 0, nil)
 				if err != nil {
 					b.Fatal(err)
 				}
@@ -85,9 +86,9 @@ func BenchmarkQueueRequest(b *testing.B) {
 	requests := make([]string, 0, numTenants)
 
 	for n := 0; n < b.N; n++ {
-		q := NewRequestQueue(maxOutstandingPerTenant, 0,
-			promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"tenant"}),
-			promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user"}),
+		q := NewRequestQueue(maxOutstandingPerTenant, 0, 0,
+			promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"tenant", "priority"}),
+			promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user", "priority"}),
 		)
 
 		for ix := 0; ix < queriers; ix++ {
@@ -106,7 +107,7 @@ func BenchmarkQueueRequest(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		for i := 0; i < maxOutstandingPerTenant; i++ {
 			for j := 0; j < numTenants; j++ {
-				err := queues[n].EnqueueRequest(users[j], requests[j], 0, nil)
+				err := queues[n].EnqueueRequest(users[j], requests[j], PriorityInteractive, 0, nil)
 				if err != nil {
 					b.Fatal(err)
 				}
@@ -118,9 +119,9 @@ func BenchmarkQueueRequest(b *testing.B) {
 func TestRequestQueue_GetNextRequestForQuerier_ShouldGetRequestAfterReshardingBecauseQuerierHasBeenForgotten(t *testing.T) {
 	const forgetDelay = 3 * time.Second
 
-	queue := NewRequestQueue(1, forgetDelay,
-		promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"user"}),
-		promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user"}))
+	queue := NewRequestQueue(1, 0, forgetDelay,
+		promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"user", "priority"}),
+		promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user", "priority"}))
 
 	// Start the queue service.
 	ctx := context.Background()
@@ -147,7 +148,7 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldGetRequestAfterReshardingBe
 
 	// Enqueue a request from an user which would be assigned to querier-1.
 	// NOTE: "user-1" hash falls in the querier-1 shard.
-	require.NoError(t, queue.EnqueueRequest("user-1", "request", 1, nil))
+	require.NoError(t, queue.EnqueueRequest("user-1", "request", PriorityInteractive, 1, nil))
 
 	startTime := time.Now()
 	querier2wg.Wait()
@@ -157,6 +158,44 @@ func TestRequestQueue_GetNextRequestForQuerier_ShouldGetRequestAfterReshardingBe
 	assert.GreaterOrEqual(t, waitTime.Milliseconds(), forgetDelay.Milliseconds())
 }
 
+func TestRequestQueue_GetNextRequestForQuerier_ShouldDequeueInteractiveRequestsFirst(t *testing.T) {
+	queueLength := promauto.With(nil).NewGaugeVec(prometheus.GaugeOpts{}, []string{"user", "priority"})
+	discarded := promauto.With(nil).NewCounterVec(prometheus.CounterOpts{}, []string{"user", "priority"})
+	queue := NewRequestQueue(2, 1, 0, queueLength, discarded)
+
+	ctx := context.Background()
+	require.NoError(t, services.StartAndAwaitRunning(ctx, queue))
+	t.Cleanup(func() {
+		require.NoError(t, services.StopAndAwaitTerminated(ctx, queue))
+	})
+	queue.RegisterQuerierConnection("querier-1")
+
+	require.NoError(t, queue.EnqueueRequest("user-1", "batch-1", PriorityBatch, 0, nil))
+	// The batch lane is full, while the interactive one is not.
+	require.ErrorIs(t, queue.EnqueueRequest("user-1", "batch-2", PriorityBatch, 0, nil), ErrTooManyRequests)
+	require.NoError(t, queue.EnqueueRequest("user-1", "interactive-1", PriorityInteractive, 0, nil))
+	require.NoError(t, queue.EnqueueRequest("user-1", "interactive-2", PriorityInteractive, 0, nil))
+	require.ErrorIs(t, queue.EnqueueRequest("user-1", "interactive-3", PriorityInteractive, 0, nil), ErrTooManyRequests)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(queueLength.WithLabelValues("user-1", "interactive")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(queueLength.WithLabelValues("user-1", "batch")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(discarded.WithLabelValues("user-1", "interactive")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(discarded.WithLabelValues("user-1", "batch")))
+
+	idx := FirstUser()
+	for _, expected := range []string{"interactive-1", "interactive-2", "batch-1"} {
+		var req Request
+		var err error
+		req, idx, err = queue.GetNextRequestForQuerier(ctx, idx, "querier-1")
+		require.NoError(t, err)
+		assert.Equal(t, expected, req)
+	}
+
+	assert.Equal(t, float64(0), testutil.ToFloat64(queueLength.WithLabelValues("user-1", "interactive")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(queueLength.WithLabelValues("user-1", "batch")))
+	assert.Equal(t, 0, queue.queues.len())
+}
+
 func TestContextCond(t *testing.T) {
 	t.Run("wait until broadcast", func(t *testing.T) {
 		t.Parallel()
@@ -37,7 +37,8 @@ type queues struct {
 	// this list when there are ""'s at the end of it.
 	users []string
 
-	maxUserQueueSize int
+	// Max number of outstanding requests per user, per priority lane.
+	maxUserQueueSize [numPriorities]int
 
 	// How long to wait before removing a querier which has got disconnected
 	// but hasn't notified about a graceful shutdown.
@@ -51,7 +52,8 @@ type queues struct {
 }
 
 type userQueue struct {
-	ch chan Request
+	// Requests of the user, one FIFO per priority lane.
+	lanes [numPriorities]chan Request
 
 	// If not nil, only these queriers can handle user requests. If nil, all queriers can.
 	// We set this to nil if number of available queriers <= maxQueriers.
@@ -66,14 +68,22 @@ type userQueue struct {
 	index int
 }
 
-func newUserQueues(maxUserQueueSize int, forgetDelay time.Duration) *queues {
+// newUserQueues creates user queues. If maxUserBatchQueueSize is 0,
+// maxUserQueueSize applies to the batch lane as well.
+func newUserQueues(maxUserQueueSize, maxUserBatchQueueSize int, forgetDelay time.Duration) *queues {
+	if maxUserBatchQueueSize <= 0 {
+		maxUserBatchQueueSize = maxUserQueueSize
+	}
 	return &queues{
-		userQueues:       map[string]*userQueue{},
-		users:            nil,
-		maxUserQueueSize: maxUserQueueSize,
-		forgetDelay:      forgetDelay,
-		queriers:         map[string]*querier{},
-		sortedQueriers:   nil,
+		userQueues: map[string]*userQueue{},
+		users:      nil,
+		maxUserQueueSize: [numPriorities]int{
+			PriorityInteractive: maxUserQueueSize,
+			PriorityBatch:       maxUserBatchQueueSize,
+		},
+		forgetDelay:    forgetDelay,
+		queriers:       map[string]*querier{},
+		sortedQueriers: nil,
 	}
 }
 
@@ -100,7 +110,7 @@ func (q *queues) deleteQueue(userID string) {
 // MaxQueriers is used to compute which queriers should handle requests for this user.
 // If maxQueriers is <= 0, all queriers can handle this user's requests.
 // If maxQueriers has changed since the last call, queriers for this are recomputed.
-func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan Request {
+func (q *queues) getOrAddQueue(userID string, maxQueriers int) *userQueue {
 	// Empty user is not allowed, as that would break our users list ("" is used for free spot).
 	if userID == "" {
 		return nil
@@ -114,10 +124,12 @@ func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan Request {
 
 	if uq == nil {
 		uq = &userQueue{
-			ch:    make(chan Request, q.maxUserQueueSize),
 			seed:  util.ShuffleShardSeed(userID, ""),
 			index: -1,
 		}
+		for p := range uq.lanes {
+			uq.lanes[p] = make(chan Request, q.maxUserQueueSize[p])
+		}
 		q.userQueues[userID] = uq
 
 		// Add user to the list of users... find first free spot, and put it there.
@@ -141,13 +153,13 @@ func (q *queues) getOrAddQueue(userID string, maxQueriers int) chan Request {
 		uq.queriers = shuffleQueriersForUser(uq.seed, maxQueriers, q.sortedQueriers, nil)
 	}
 
-	return uq.ch
+	return uq
 }
 
 // Finds next queue for the querier. To support fair scheduling between users, client is expected
 // to pass last user index returned by this function as argument. Is there was no previous
 // last user index, use -1.
-func (q *queues) getNextQueueForQuerier(lastUserIndex int, querierID string) (chan Request, string, int) {
+func (q *queues) getNextQueueForQuerier(lastUserIndex int, querierID string) (*userQueue, string, int) {
 	uid := lastUserIndex
 
 	// Ensure the querier is not shutting down. If the querier is shutting down, we shouldn't forward
@@ -179,11 +191,44 @@ func (q *queues) getNextQueueForQuerier(lastUserIndex int, querierID string) (ch
 			}
 		}
 
-		return q.ch, u, uid
+		return q, u, uid
 	}
 	return nil, "", uid
 }
 
+// enqueue puts the request into the lane of the given priority.
+// It returns false if the lane is full.
+func (uq *userQueue) enqueue(req Request, p Priority) bool {
+	select {
+	case uq.lanes[p] <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+// dequeue takes the next request off the queue: requests of a higher
+// priority are dequeued first. The queue must not be empty.
+func (uq *userQueue) dequeue() (Request, Priority) {
+	for p, lane := range uq.lanes {
+		select {
+		case req := <-lane:
+			return req, Priority(p)
+		default:
+		}
+	}
+	panic("dequeue from an empty user queue")
+}
+
+// len returns the number of requests in the queue.
+func (uq *userQueue) len() int {
+	var n int
+	for _, lane := range uq.lanes {
+		n += len(lane)
+	}
+	return n
+}
+
 func (q *queues) addQuerierConnection(querierID string) {
 	info := q.queriers[querierID]
 	if info != nil {
@@ -93,14 +93,16 @@ type connectedFrontend struct {
 }
 
 type Config struct {
-	MaxOutstandingPerTenant int                       `yaml:"max_outstanding_requests_per_tenant"`
-	QuerierForgetDelay      time.Duration             `yaml:"querier_forget_delay" category:"experimental"`
-	GRPCClientConfig        grpcclient.Config         `yaml:"grpc_client_config" doc:"description=This configures the gRPC client used to report errors back to the query-frontend."`
-	ServiceDiscovery        schedulerdiscovery.Config `yaml:",inline"`
+	MaxOutstandingPerTenant      int                       `yaml:"max_outstanding_requests_per_tenant"`
+	MaxOutstandingBatchPerTenant int                       `yaml:"max_outstanding_batch_requests_per_tenant" category:"advanced"`
+	QuerierForgetDelay           time.Duration             `yaml:"querier_forget_delay" category:"experimental"`
+	GRPCClientConfig             grpcclient.Config         `yaml:"grpc_client_config" doc:"description=This configures the gRPC client used to report errors back to the query-frontend."`
+	ServiceDiscovery             schedulerdiscovery.Config `yaml:",inline"`
 }
 
 func (cfg *Config) RegisterFlags(f *flag.FlagSet, logger log.Logger) {
 	f.IntVar(&cfg.MaxOutstandingPerTenant, "query-scheduler.max-outstanding-requests-per-tenant", 100, "Maximum number of outstanding requests per tenant per query-scheduler. In-flight requests above this limit will fail with HTTP response status code 429.")
+	f.IntVar(&cfg.MaxOutstandingBatchPerTenant, "query-scheduler.max-outstanding-batch-requests-per-tenant", 0, "Maximum number of outstanding batch requests per tenant per query-scheduler. Batch requests are queued separately from interactive ones, and are only dispatched to queriers when the tenant has no interactive requests pending. The value 0 means the same limit as for interactive requests.")
 	f.DurationVar(&cfg.QuerierForgetDelay, "query-scheduler.querier-forget-delay", 0, "If a querier disconnects without sending notification about graceful shutdown, the query-scheduler will keep the querier in the tenant's shard until the forget delay has passed. This feature is useful to reduce the blast radius when shuffle-sharding is enabled.")
 	cfg.GRPCClientConfig.RegisterFlagsWithPrefix("query-scheduler.grpc-client-config", f)
 	cfg.ServiceDiscovery.RegisterFlags(f, logger)
@@ -127,7 +129,7 @@ func NewScheduler(cfg Config, limits Limits, log log.Logger, registerer promethe
 	s.queueLength = promauto.With(registerer).NewGaugeVec(prometheus.GaugeOpts{
 		Name: "pyroscope_query_scheduler_queue_length",
 		Help: "Number of queries in the queue.",
-	}, []string{"tenant"})
+	}, []string{"tenant", "priority"})
 
 	s.cancelledRequests = promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
 		Name: "pyroscope_query_scheduler_cancelled_requests_total",
@@ -136,8 +138,8 @@ func NewScheduler(cfg Config, limits Limits, log log.Logger, registerer promethe
 	s.discardedRequests = promauto.With(registerer).NewCounterVec(prometheus.CounterOpts{
 		Name: "pyroscope_query_scheduler_discarded_requests_total",
 		Help: "Total number of query requests discarded.",
-	}, []string{"tenant"})
-	s.requestQueue = queue.NewRequestQueue(cfg.MaxOutstandingPerTenant, cfg.QuerierForgetDelay, s.queueLength, s.discardedRequests)
+	}, []string{"tenant", "priority"})
+	s.requestQueue = queue.NewRequestQueue(cfg.MaxOutstandingPerTenant, cfg.MaxOutstandingBatchPerTenant, cfg.QuerierForgetDelay, s.queueLength, s.discardedRequests)
 
 	s.queueDuration = promauto.With(registerer).NewHistogram(prometheus.HistogramOpts{
 		Name:    "pyroscope_query_scheduler_queue_duration_seconds",
@@ -351,7 +353,7 @@ func (s *Scheduler) enqueueRequest(frontendContext context.Context, frontendAddr
 	maxQueriers := validation.SmallestPositiveNonZeroIntPerTenant(tenantIDs, s.limits.MaxQueriersPerTenant)
 
 	s.activeUsers.UpdateUserTimestamp(userID, now)
-	return s.requestQueue.EnqueueRequest(userID, req, maxQueriers, func() {
+	return s.requestQueue.EnqueueRequest(userID, req, requestPriority(msg.HttpRequest), maxQueriers, func() {
 		shouldCancel = false
 
 		s.pendingRequestsMu.Lock()
@@ -360,6 +362,18 @@ func (s *Scheduler) enqueueRequest(frontendContext context.Context, frontendAddr
 	})
 }
 
+// requestPriority returns the priority of the request specified by the
+// query-frontend in the request headers.
+func requestPriority(req *httpgrpc.HTTPRequest) queue.Priority {
+	for _, h := range req.GetHeaders() {
+		if http.CanonicalHeaderKey(h.Key) == queue.HeaderQueryPriority && len(h.Values) > 0 {
+			p, _ := queue.ParsePriority(h.Values[0])
+			return p
+		}
+	}
+	return queue.PriorityInteractive
+}
+
 // This method doesn't do removal from the queue.
 func (s *Scheduler) cancelRequestAndRemoveFromPending(frontendAddr string, queryID uint64) {
 	s.pendingRequestsMu.Lock()
@@ -614,8 +628,8 @@ func (s *Scheduler) stopping(_ error) error {
 }
 
 func (s *Scheduler) cleanupMetricsForInactiveUser(user string) {
-	s.queueLength.DeleteLabelValues(user)
-	s.discardedRequests.DeleteLabelValues(user)
+	s.queueLength.DeletePartialMatch(prometheus.Labels{"tenant": user})
+	s.discardedRequests.DeletePartialMatch(prometheus.Labels{"tenant": user})
 	s.cancelledRequests.DeleteLabelValues(user)
 }
 
@@ -35,6 +35,7 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/grafana/pyroscope/pkg/frontend/frontendpb"
+	"github.com/grafana/pyroscope/pkg/scheduler/queue"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerpb"
 	"github.com/grafana/pyroscope/pkg/scheduler/schedulerpb/schedulerpbconnect"
 	"github.com/grafana/pyroscope/pkg/util"
@@ -437,17 +438,21 @@ func TestSchedulerMetrics(t *testing.T) {
 		HttpRequest: &httpgrpc.HTTPRequest{Method: "GET", Url: "/hello"},
 	})
 	frontendToScheduler(t, frontendLoop, &schedulerpb.FrontendToScheduler{
-		Type:        schedulerpb.FrontendToSchedulerType_ENQUEUE,
-		QueryID:     1,
-		UserID:      "another",
-		HttpRequest: &httpgrpc.HTTPRequest{Method: "GET", Url: "/hello"},
+		Type:    schedulerpb.FrontendToSchedulerType_ENQUEUE,
+		QueryID: 1,
+		UserID:  "another",
+		HttpRequest: &httpgrpc.HTTPRequest{
+			Method:  "GET",
+			Url:     "/hello",
+			Headers: []*httpgrpc.Header{{Key: queue.HeaderQueryPriority, Values: []string{"batch"}}},
+		},
 	})
 
 	require.NoError(t, promtest.GatherAndCompare(reg, strings.NewReader(`
 		# HELP pyroscope_query_scheduler_queue_length Number of queries in the queue.
 		# TYPE pyroscope_query_scheduler_queue_length gauge
-		pyroscope_query_scheduler_queue_length{tenant="another"} 1
-		pyroscope_query_scheduler_queue_length{tenant="test"} 1
+		pyroscope_query_scheduler_queue_length{priority="batch",tenant="another"} 1
+		pyroscope_query_scheduler_queue_length{priority="interactive",tenant="test"} 1
 	`), "pyroscope_query_scheduler_queue_length"))
 
 	scheduler.cleanupMetricsForInactiveUser("test")
@@ -455,7 +460,7 @@ func TestSchedulerMetrics(t *testing.T) {
 	require.NoError(t, promtest.GatherAndCompare(reg, strings.NewReader(`
 		# HELP pyroscope_query_scheduler_queue_length Number of queries in the queue.
 		# TYPE pyroscope_query_scheduler_queue_length gauge
-		pyroscope_query_scheduler_queue_length{tenant="another"} 1
+		pyroscope_query_scheduler_queue_length{priority="batch",tenant="another"} 1
 	`), "pyroscope_query_scheduler_queue_length"))
 }
 
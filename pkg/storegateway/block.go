@@ -14,6 +14,7 @@ import (
 
 type BlockCloser interface {
 	phlaredb.Querier
+	phlaredb.SeriesStatsReader
 	Close() error
 }
 
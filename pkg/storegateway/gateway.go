@@ -16,6 +16,7 @@ import (
 
 	phlareobj "github.com/grafana/pyroscope/pkg/objstore"
 	"github.com/grafana/pyroscope/pkg/util"
+	"github.com/grafana/pyroscope/pkg/util/limiter"
 	"github.com/grafana/pyroscope/pkg/validation"
 )
 
@@ -34,6 +35,7 @@ var errInvalidTenantShardSize = errors.New("invalid tenant shard size, the value
 
 type Limits interface {
 	ShardingLimits
+	limiter.QueryLimits
 }
 
 // ShardingLimits is the interface that should be implemented by the limits provider,
@@ -48,6 +50,7 @@ type StoreGateway struct {
 
 	gatewayCfg Config
 	stores     *BucketStores
+	limits     Limits
 
 	// Ring used for sharding blocks.
 	ringLifecycler *ring.BasicLifecycler
@@ -102,6 +105,7 @@ func newStoreGateway(gatewayCfg Config, storageBucket phlareobj.Bucket, ringStor
 	g := &StoreGateway{
 		gatewayCfg: gatewayCfg,
 		logger:     logger,
+		limits:     limits,
 		bucketSync: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 			Name: "pyroscope_storegateway_bucket_sync_total",
 			Help: "Total number of times the bucket sync operation triggered.",
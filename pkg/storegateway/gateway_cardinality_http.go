@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package storegateway
+
+import (
+	_ "embed" // Used to embed html template
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/dustin/go-humanize"
+	"github.com/gorilla/mux"
+	"github.com/grafana/dskit/user"
+
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
+	"github.com/grafana/pyroscope/pkg/util"
+)
+
+//go:embed cardinality.gohtml
+var cardinalityPageHTML string
+var cardinalityPageTemplate = template.Must(template.New("webpage").Funcs(template.FuncMap{
+	"bytes": func(b int64) string { return humanize.Bytes(uint64(b)) },
+}).Parse(cardinalityPageHTML))
+
+const (
+	defaultCardinalityRange = 24 * time.Hour
+	defaultCardinalityLimit = 10
+)
+
+type cardinalityPageContents struct {
+	Now         time.Time                   `json:"now"`
+	Tenant      string                      `json:"tenant,omitempty"`
+	Selector    string                      `json:"selector,omitempty"`
+	Start       time.Time                   `json:"start"`
+	End         time.Time                   `json:"end"`
+	GrowthSince time.Time                   `json:"growthSince"`
+	Limit       int                         `json:"limit"`
+	Labels      []*typesv1.LabelCardinality `json:"labels"`
+}
+
+// CardinalityHandler shows the cardinality of the labels of the tenant's
+// series, in the blocks of the store-gateway.
+func (s *StoreGateway) CardinalityHandler(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	tenantID := vars["tenant"]
+	if tenantID == "" {
+		util.WriteTextResponse(w, "Tenant ID can't be empty")
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		util.WriteTextResponse(w, fmt.Sprintf("Can't parse form: %s", err))
+		return
+	}
+
+	var err error
+	end := time.Now().UTC().Truncate(time.Second)
+	if v := req.Form.Get("end"); v != "" {
+		if end, err = time.Parse(time.RFC3339, v); err != nil {
+			util.WriteTextResponse(w, fmt.Sprintf("Can't parse end time: %s", err))
+			return
+		}
+	}
+	start := end.Add(-defaultCardinalityRange)
+	if v := req.Form.Get("start"); v != "" {
+		if start, err = time.Parse(time.RFC3339, v); err != nil {
+			util.WriteTextResponse(w, fmt.Sprintf("Can't parse start time: %s", err))
+			return
+		}
+	}
+	limit := defaultCardinalityLimit
+	if v := req.Form.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			util.WriteTextResponse(w, fmt.Sprintf("Invalid limit: %q", v))
+			return
+		}
+	}
+	selector := req.Form.Get("selector")
+	var matchers []string
+	if selector != "" {
+		matchers = []string{selector}
+	}
+
+	r := &typesv1.LabelCardinalityRequest{
+		Matchers: matchers,
+		Start:    start.UnixMilli(),
+		End:      end.UnixMilli(),
+		Limit:    int64(limit),
+	}
+	res, err := s.LabelCardinality(user.InjectOrgID(req.Context(), tenantID), connect.NewRequest(r))
+	if err != nil {
+		util.WriteTextResponse(w, fmt.Sprintf("Failed to compute label cardinality: %s", err))
+		return
+	}
+
+	util.RenderHTTPResponse(w, cardinalityPageContents{
+		Now:         time.Now(),
+		Tenant:      tenantID,
+		Selector:    selector,
+		Start:       start,
+		End:         end,
+		GrowthSince: start.Add(end.Sub(start) / 2),
+		Limit:       limit,
+		Labels:      res.Msg.Labels,
+	}, cardinalityPageTemplate, req)
+}
@@ -9,11 +9,14 @@ import (
 	"github.com/prometheus/common/model"
 
 	ingestv1 "github.com/grafana/pyroscope/api/gen/proto/go/ingester/v1"
+	typesv1 "github.com/grafana/pyroscope/api/gen/proto/go/types/v1"
 	"github.com/grafana/pyroscope/pkg/phlaredb"
 	"github.com/grafana/pyroscope/pkg/tenant"
+	"github.com/grafana/pyroscope/pkg/util/limiter"
 )
 
 func (s *StoreGateway) MergeProfilesStacktraces(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesStacktracesRequest, ingestv1.MergeProfilesStacktracesResponse]) error {
+	ctx = s.withQueryLimiter(ctx)
 	found, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
 		return bs.MergeProfilesStacktraces(ctx, stream)
 	})
@@ -24,6 +27,7 @@ func (s *StoreGateway) MergeProfilesStacktraces(ctx context.Context, stream *con
 }
 
 func (s *StoreGateway) MergeProfilesLabels(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesLabelsRequest, ingestv1.MergeProfilesLabelsResponse]) error {
+	ctx = s.withQueryLimiter(ctx)
 	found, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
 		return bs.MergeProfilesLabels(ctx, stream)
 	})
@@ -34,6 +38,7 @@ func (s *StoreGateway) MergeProfilesLabels(ctx context.Context, stream *connect.
 }
 
 func (s *StoreGateway) MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesPprofRequest, ingestv1.MergeProfilesPprofResponse]) error {
+	ctx = s.withQueryLimiter(ctx)
 	found, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
 		return bs.MergeProfilesPprof(ctx, stream)
 	})
@@ -43,6 +48,66 @@ func (s *StoreGateway) MergeProfilesPprof(ctx context.Context, stream *connect.B
 	return terminateStream(stream)
 }
 
+func (s *StoreGateway) LabelCardinality(ctx context.Context, req *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	var res *connect.Response[typesv1.LabelCardinalityResponse]
+	found, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		var err error
+		res, err = bs.LabelCardinality(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return connect.NewResponse(&typesv1.LabelCardinalityResponse{}), nil
+	}
+	return res, nil
+}
+
+func (s *StoreGateway) SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	ctx = s.withQueryLimiter(ctx)
+	var res *connect.Response[typesv1.SelectProfilesMetadataResponse]
+	found, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		var err error
+		res, err = bs.SelectProfilesMetadata(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return connect.NewResponse(&typesv1.SelectProfilesMetadataResponse{}), nil
+	}
+	return res, nil
+}
+
+func (s *StoreGateway) SelectExemplars(ctx context.Context, req *connect.Request[ingestv1.SelectExemplarsRequest]) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+	ctx = s.withQueryLimiter(ctx)
+	var res *connect.Response[ingestv1.SelectExemplarsResponse]
+	found, err := s.forBucketStore(ctx, func(bs *BucketStore) error {
+		var err error
+		res, err = bs.SelectExemplars(ctx, req)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return connect.NewResponse(&ingestv1.SelectExemplarsResponse{}), nil
+	}
+	return res, nil
+}
+
+// withQueryLimiter returns a context carrying the limiter
+// of the query cost, enforcing the tenant's query limits.
+func (s *StoreGateway) withQueryLimiter(ctx context.Context) context.Context {
+	tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+	if err != nil {
+		return ctx
+	}
+	return limiter.AddQueryLimiterToContext(ctx, limiter.NewQueryLimiter(tenantID, s.limits))
+}
+
 func terminateStream[Req, Resp any](stream *connect.BidiStream[Req, Resp]) (err error) {
 	if _, err = stream.Receive(); err != nil {
 		if errors.Is(err, io.EOF) {
@@ -92,3 +157,15 @@ func (store *BucketStore) MergeProfilesLabels(ctx context.Context, stream *conne
 func (store *BucketStore) MergeProfilesPprof(ctx context.Context, stream *connect.BidiStream[ingestv1.MergeProfilesPprofRequest, ingestv1.MergeProfilesPprofResponse]) error {
 	return phlaredb.MergeProfilesPprof(ctx, stream, store.openBlocksForReading)
 }
+
+func (store *BucketStore) LabelCardinality(ctx context.Context, req *connect.Request[typesv1.LabelCardinalityRequest]) (*connect.Response[typesv1.LabelCardinalityResponse], error) {
+	return phlaredb.LabelCardinality(ctx, req, store.openBlocksForReading)
+}
+
+func (store *BucketStore) SelectProfilesMetadata(ctx context.Context, req *connect.Request[typesv1.SelectProfilesMetadataRequest]) (*connect.Response[typesv1.SelectProfilesMetadataResponse], error) {
+	return phlaredb.SelectProfilesMetadata(ctx, req, store.openBlocksForReading)
+}
+
+func (store *BucketStore) SelectExemplars(ctx context.Context, req *connect.Request[ingestv1.SelectExemplarsRequest]) (*connect.Response[ingestv1.SelectExemplarsResponse], error) {
+	return phlaredb.SelectExemplars(ctx, req, store.openBlocksForReading)
+}
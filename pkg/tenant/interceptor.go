@@ -35,7 +35,7 @@ func (i *authInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
 	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
 		// client side we extract the tenantID from the context and inject it into the request header
 		if req.Spec().IsClient {
-			tenantID, _ := ExtractTenantIDFromContext(ctx)
+			tenantID, _ := ExtractTenantIDsFromContext(ctx)
 			if tenantID != "" {
 				req.Header().Set("X-Scope-OrgID", tenantID)
 			}
@@ -46,7 +46,10 @@ func (i *authInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
 		if !i.enabled {
 			return next(InjectTenantID(ctx, DefaultTenantID), req)
 		}
-		_, ctx, _ = ExtractTenantIDFromHeaders(ctx, req.Header())
+		_, ctx, err := ExtractTenantIDFromHeaders(ctx, req.Header())
+		if err != nil && !errors.Is(err, ErrNoTenantID) {
+			return nil, connect.NewError(connect.CodeInvalidArgument, err)
+		}
 
 		resp, err := next(ctx, req)
 		if err != nil && errors.Is(err, ErrNoTenantID) {
@@ -59,7 +62,7 @@ func (i *authInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
 func (i *authInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
 	return func(ctx context.Context, s connect.Spec) connect.StreamingClientConn {
 		conn := next(ctx, s)
-		tenantID, _ := ExtractTenantIDFromContext(ctx)
+		tenantID, _ := ExtractTenantIDsFromContext(ctx)
 		if tenantID != "" {
 			conn.RequestHeader().Set("X-Scope-OrgID", tenantID)
 		}
@@ -72,7 +75,10 @@ func (i *authInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc
 		if !i.enabled {
 			return next(InjectTenantID(ctx, DefaultTenantID), conn)
 		}
-		_, ctx, _ = ExtractTenantIDFromHeaders(ctx, conn.RequestHeader())
+		_, ctx, err := ExtractTenantIDFromHeaders(ctx, conn.RequestHeader())
+		if err != nil && !errors.Is(err, ErrNoTenantID) {
+			return connect.NewError(connect.CodeInvalidArgument, err)
+		}
 		if err := next(ctx, conn); err != nil {
 			if errors.Is(err, ErrNoTenantID) {
 				return connect.NewError(connect.CodeUnauthenticated, err)
@@ -83,25 +89,31 @@ func (i *authInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc
 	}
 }
 
-var defaultResolver tenant.Resolver = tenant.NewSingleResolver()
+// The IDs of the tenants of a federated query are separated by '|': the
+// resolver validates each of them, and does not resolve them as a single
+// tenant ID.
+var defaultResolver tenant.Resolver = tenant.NewMultiResolver()
 
-// ExtractTenantIDFromHeaders extracts a single TenantID from http headers.
+// ExtractTenantIDFromHeaders extracts the tenant IDs from http headers,
+// joined with '|' if there are more than one. The context is returned
+// unchanged on error.
 func ExtractTenantIDFromHeaders(ctx context.Context, headers http.Header) (string, context.Context, error) {
 	orgID := headers.Get(user.OrgIDHeaderName)
 	if orgID == "" {
 		return "", ctx, ErrNoTenantID
 	}
-	ctx = InjectTenantID(ctx, orgID)
+	tenantCtx := InjectTenantID(ctx, orgID)
 
-	tenantID, err := defaultResolver.TenantID(ctx)
+	tenantIDs, err := defaultResolver.TenantIDs(tenantCtx)
 	if err != nil {
-		return "", nil, err
+		return "", ctx, err
 	}
 
-	return tenantID, ctx, nil
+	return tenant.JoinTenantIDs(tenantIDs), tenantCtx, nil
 }
 
 // ExtractTenantIDFromContext extracts a single TenantID from the context.
+// It fails if the context carries the IDs of several tenants.
 func ExtractTenantIDFromContext(ctx context.Context) (string, error) {
 	tenantID, err := defaultResolver.TenantID(ctx)
 	if err != nil {
@@ -110,3 +122,14 @@ func ExtractTenantIDFromContext(ctx context.Context) (string, error) {
 
 	return tenantID, nil
 }
+
+// ExtractTenantIDsFromContext extracts the tenant IDs from the context,
+// joined with '|' if there are more than one.
+func ExtractTenantIDsFromContext(ctx context.Context) (string, error) {
+	tenantIDs, err := defaultResolver.TenantIDs(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return tenant.JoinTenantIDs(tenantIDs), nil
+}
@@ -88,6 +88,32 @@ func Test_AuthInterceptor(t *testing.T) {
 				return nil
 			})(context.Background(), shc)
 		},
+		"server: enable, multiple tenants": func(t *testing.T) {
+			i := NewAuthInterceptor(true)
+			req := newFakeReq(false)
+			req.Header().Set("X-Scope-OrgID", "foo|bar")
+			resp, err := i.WrapUnary(func(ctx context.Context, ar connect.AnyRequest) (connect.AnyResponse, error) {
+				// The tenants are not resolved as a single tenant ID.
+				_, err := ExtractTenantIDFromContext(ctx)
+				require.Error(t, err)
+				tenantIDs, err := ExtractTenantIDsFromContext(ctx)
+				require.NoError(t, err)
+				require.Equal(t, "bar|foo", tenantIDs)
+				return nil, nil
+			})(context.Background(), req)
+			require.NoError(t, err)
+			require.Nil(t, resp)
+		},
+		"server: enable, invalid tenant": func(t *testing.T) {
+			i := NewAuthInterceptor(true)
+			req := newFakeReq(false)
+			req.Header().Set("X-Scope-OrgID", "foo|..")
+			_, err := i.WrapUnary(func(ctx context.Context, ar connect.AnyRequest) (connect.AnyResponse, error) {
+				t.Fatal("unexpected call")
+				return nil, nil
+			})(context.Background(), req)
+			require.Equal(t, connect.CodeInvalidArgument, connect.CodeOf(err))
+		},
 	} {
 		t.Run(testName, testCase)
 	}
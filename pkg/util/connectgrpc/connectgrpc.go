@@ -290,6 +290,10 @@ func (r *responseWriter) WriteHeader(statusCode int) {
 	r.resp.Code = int32(statusCode)
 }
 
+// Flush is a no-op: the response is sent at once, when the handler returns.
+// It allows streaming handlers to be served, their messages are buffered.
+func (r *responseWriter) Flush() {}
+
 func (r *responseWriter) HTTPResponse() *httpgrpc.HTTPResponse {
 	r.resp.Headers = connectHeaderToHTTPGRPCHeader(r.header)
 	return &r.resp
@@ -54,7 +54,7 @@ func NewLogInterceptor(logger log.Logger) connect.UnaryInterceptorFunc {
 			req connect.AnyRequest,
 		) (connect.AnyResponse, error) {
 			begin := time.Now()
-			tenantID, err := tenant.ExtractTenantIDFromContext(ctx)
+			tenantID, err := tenant.ExtractTenantIDsFromContext(ctx)
 			if err != nil {
 				tenantID = "anonymous"
 			}
@@ -0,0 +1,115 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/common/model"
+	"go.uber.org/atomic"
+
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+type contextKey int
+
+var ctxKey = contextKey(0)
+
+// QueryLimits are the per-tenant limits of the cost of a single query.
+type QueryLimits interface {
+	MaxQuerySeries(tenantID string) int
+	MaxQueryBytesRead(tenantID string) int
+	MaxQueryProfiles(tenantID string) int
+}
+
+// QueryLimiter tracks the cost of a query and rejects the query as soon
+// as any of the limits is exceeded. A nil QueryLimiter imposes no limits.
+type QueryLimiter struct {
+	tenantID string
+
+	maxSeries    int
+	maxBytesRead int
+	maxProfiles  int
+
+	seriesMu sync.Mutex
+	series   map[model.Fingerprint]struct{}
+
+	bytesRead atomic.Uint64
+	profiles  atomic.Uint64
+	discarded atomic.Bool
+}
+
+// NewQueryLimiter creates a new QueryLimiter for the tenant.
+func NewQueryLimiter(tenantID string, limits QueryLimits) *QueryLimiter {
+	l := &QueryLimiter{
+		tenantID:     tenantID,
+		maxSeries:    limits.MaxQuerySeries(tenantID),
+		maxBytesRead: limits.MaxQueryBytesRead(tenantID),
+		maxProfiles:  limits.MaxQueryProfiles(tenantID),
+	}
+	if l.maxSeries > 0 {
+		l.series = make(map[model.Fingerprint]struct{})
+	}
+	return l
+}
+
+// AddQueryLimiterToContext returns a context carrying the limiter.
+func AddQueryLimiterToContext(ctx context.Context, limiter *QueryLimiter) context.Context {
+	return context.WithValue(ctx, ctxKey, limiter)
+}
+
+// QueryLimiterFromContext returns the limiter of the query, or nil
+// if the context carries none.
+func QueryLimiterFromContext(ctx context.Context) *QueryLimiter {
+	l, _ := ctx.Value(ctxKey).(*QueryLimiter)
+	return l
+}
+
+// AddSeries adds series matched by the query. A series matched in
+// multiple blocks is only accounted once.
+func (l *QueryLimiter) AddSeries(fingerprints ...model.Fingerprint) error {
+	if l == nil || l.maxSeries <= 0 {
+		return nil
+	}
+	l.seriesMu.Lock()
+	for _, fp := range fingerprints {
+		l.series[fp] = struct{}{}
+	}
+	n := len(l.series)
+	l.seriesMu.Unlock()
+	if n > l.maxSeries {
+		return l.reject(validation.QuerySeriesLimit, validation.QueryTooManySeriesErrorMsg, n, l.maxSeries)
+	}
+	return nil
+}
+
+// AddBytesRead adds the estimated number of bytes the query reads.
+func (l *QueryLimiter) AddBytesRead(n uint64) error {
+	if l == nil || l.maxBytesRead <= 0 {
+		return nil
+	}
+	if v := l.bytesRead.Add(n); v > uint64(l.maxBytesRead) {
+		return l.reject(validation.QueryBytesLimit, validation.QueryTooManyBytesErrorMsg, v, l.maxBytesRead)
+	}
+	return nil
+}
+
+// AddProfiles adds the number of profiles the query merges.
+func (l *QueryLimiter) AddProfiles(n int) error {
+	if l == nil || l.maxProfiles <= 0 {
+		return nil
+	}
+	if v := l.profiles.Add(uint64(n)); v > uint64(l.maxProfiles) {
+		return l.reject(validation.QueryProfilesLimit, validation.QueryTooManyProfilesErrorMsg, v, l.maxProfiles)
+	}
+	return nil
+}
+
+func (l *QueryLimiter) reject(reason validation.Reason, msg string, args ...interface{}) error {
+	// The query is only accounted once, regardless of
+	// how many limits are exceeded and how many times.
+	if l.discarded.CompareAndSwap(false, true) {
+		validation.DiscardedQueries.WithLabelValues(string(reason), l.tenantID).Inc()
+	}
+	return connect.NewError(connect.CodeResourceExhausted, validation.NewErrorf(reason, msg, args...))
+}
@@ -0,0 +1,51 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bufbuild/connect-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyroscope/pkg/validation"
+)
+
+func TestQueryLimiter(t *testing.T) {
+	t.Run("nil limiter imposes no limits", func(t *testing.T) {
+		l := QueryLimiterFromContext(context.Background())
+		require.Nil(t, l)
+		assert.NoError(t, l.AddSeries(1, 2, 3))
+		assert.NoError(t, l.AddBytesRead(1<<30))
+		assert.NoError(t, l.AddProfiles(1<<20))
+	})
+
+	t.Run("series are accounted once", func(t *testing.T) {
+		l := NewQueryLimiter("series", validation.MockLimits{MaxQuerySeriesValue: 2})
+		require.NoError(t, l.AddSeries(1, 2))
+		require.NoError(t, l.AddSeries(1, 2))
+		err := l.AddSeries(3)
+		require.Error(t, err)
+		assert.Equal(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+		assert.Equal(t, validation.QuerySeriesLimit, validation.ReasonOf(err))
+	})
+
+	t.Run("bytes read", func(t *testing.T) {
+		l := NewQueryLimiter("bytes", validation.MockLimits{MaxQueryBytesReadValue: 100})
+		require.NoError(t, l.AddBytesRead(60))
+		err := l.AddBytesRead(60)
+		require.Error(t, err)
+		assert.Equal(t, validation.QueryBytesLimit, validation.ReasonOf(err))
+	})
+
+	t.Run("profiles", func(t *testing.T) {
+		l := NewQueryLimiter("profiles", validation.MockLimits{MaxQueryProfilesValue: 10})
+		require.NoError(t, l.AddProfiles(10))
+		require.Error(t, l.AddProfiles(1))
+		require.Error(t, l.AddProfiles(1))
+		// The query is discarded only once.
+		assert.Equal(t, float64(1), testutil.ToFloat64(
+			validation.DiscardedQueries.WithLabelValues(string(validation.QueryProfilesLimit), "profiles")))
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"time"
 
+	"github.com/grafana/dskit/flagext"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/model"
 	"gopkg.in/yaml.v3"
@@ -38,6 +39,10 @@ type Limits struct {
 	// can be calculated correctly.
 	IngestionTenantShardSize int `yaml:"ingestion_tenant_shard_size" json:"ingestion_tenant_shard_size"`
 
+	// Cost attribution, accounted by distributors and ingesters.
+	CostAttributionLabels         flagext.StringSliceCSV `yaml:"cost_attribution_labels" json:"cost_attribution_labels"`
+	MaxCostAttributionCardinality int                    `yaml:"max_cost_attribution_cardinality" json:"max_cost_attribution_cardinality"`
+
 	// Ingester enforced limits.
 	MaxLocalSeriesPerTenant  int `yaml:"max_local_series_per_tenant" json:"max_local_series_per_tenant"`
 	MaxGlobalSeriesPerTenant int `yaml:"max_global_series_per_tenant" json:"max_global_series_per_tenant"`
@@ -46,12 +51,21 @@ type Limits struct {
 	MaxQueryLookback    model.Duration `yaml:"max_query_lookback" json:"max_query_lookback"`
 	MaxQueryLength      model.Duration `yaml:"max_query_length" json:"max_query_length"`
 	MaxQueryParallelism int            `yaml:"max_query_parallelism" json:"max_query_parallelism"`
+	MaxQuerySeries      int            `yaml:"max_query_series" json:"max_query_series"`
+	MaxQueryBytesRead   int            `yaml:"max_query_bytes_read" json:"max_query_bytes_read"`
+	MaxQueryProfiles    int            `yaml:"max_query_profiles" json:"max_query_profiles"`
 
 	// Store-gateway.
 	StoreGatewayTenantShardSize int `yaml:"store_gateway_tenant_shard_size" json:"store_gateway_tenant_shard_size"`
 
 	// Query frontend.
 	QuerySplitDuration model.Duration `yaml:"split_queries_by_interval" json:"split_queries_by_interval"`
+	QuerySplitMaxNodes int            `yaml:"split_queries_max_nodes" json:"split_queries_max_nodes"`
+	QuerySplitShards   int            `yaml:"split_queries_by_series_shards" json:"split_queries_by_series_shards"`
+
+	// Query scheduler.
+	BatchQueriesLongerThan  model.Duration `yaml:"batch_queries_longer_than" json:"batch_queries_longer_than"`
+	BatchQueriesSeriesHours int            `yaml:"batch_queries_series_hours" json:"batch_queries_series_hours"`
 }
 
 // LimitError are errors that do not comply with the limits specified.
@@ -72,6 +86,9 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&l.MaxLabelValueLength, "validation.max-length-label-value", 2048, "Maximum length accepted for label value. This setting also applies to the metric name.")
 	f.IntVar(&l.MaxLabelNamesPerSeries, "validation.max-label-names-per-series", 30, "Maximum number of label names per series.")
 
+	f.Var(&l.CostAttributionLabels, "validation.cost-attribution-labels", "Comma-separated list of labels the usage of the tenant is attributed to: received bytes and profiles, ingested bytes and active series are exported as metrics by the values of these labels. Only their totals, without the tenants and label values, are included in the anonymous usage statistics report. Series without a label are attributed to the value \"__missing__\". Empty to disable.")
+	f.IntVar(&l.MaxCostAttributionCardinality, "validation.max-cost-attribution-cardinality", 100, "Maximum number of distinct combinations of cost attribution label values per tenant. Once reached, new combinations are attributed to the value \"__overflow__\". 0 to disable.")
+
 	f.IntVar(&l.MaxLocalSeriesPerTenant, "ingester.max-local-series-per-tenant", 0, "Maximum number of active series of profiles per tenant, per ingester. 0 to disable.")
 	f.IntVar(&l.MaxGlobalSeriesPerTenant, "ingester.max-global-series-per-tenant", 5000, "Maximum number of active series of profiles per tenant, across the cluster. 0 to disable. When the global limit is enabled, each ingester is configured with a dynamic local limit based on the replication factor and the current number of healthy ingesters, and is kept updated whenever the number of ingesters change.")
 
@@ -85,8 +102,17 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 
 	_ = l.QuerySplitDuration.Set("0s")
 	f.Var(&l.QuerySplitDuration, "querier.split-queries-by-interval", "Split queries by a time interval and execute in parallel. The value 0 disables splitting by time")
+	f.IntVar(&l.QuerySplitMaxNodes, "querier.split-queries-max-nodes", 65536, "Maximum number of nodes in the tree returned by each split query. Smaller nodes are aggregated into \"other\"; the final result is truncated to the requested max nodes once all split queries are merged. The value 0 disables truncation of split query results.")
+	f.IntVar(&l.QuerySplitShards, "querier.split-queries-by-series-shards", 0, "Split queries further into the given number of series shards and execute in parallel. Must be a power of two. The value 0 disables splitting by series.")
+
+	_ = l.BatchQueriesLongerThan.Set("0s")
+	f.Var(&l.BatchQueriesLongerThan, "query-scheduler.batch-queries-longer-than", "Queries spanning a time range longer than this are scheduled with the batch priority: the query-scheduler only dispatches them to queriers when the tenant has no interactive queries pending. Clients may specify the priority explicitly with the X-Pyroscope-Query-Priority header. The value 0 disables the classification.")
+	f.IntVar(&l.BatchQueriesSeriesHours, "query-scheduler.batch-queries-series-hours", 0, "Queries whose number of series multiplied by the hours of their time range exceeds this are scheduled with the batch priority. The number of series is estimated with a label cardinality query before the query is scheduled. The value 0 disables the classification.")
 
 	f.IntVar(&l.MaxQueryParallelism, "querier.max-query-parallelism", 0, "Maximum number of queries that will be scheduled in parallel by the frontend.")
+	f.IntVar(&l.MaxQuerySeries, "querier.max-query-series", 0, "Maximum number of series a single query can match in an ingester or store-gateway. This limit is enforced in the ingesters and store-gateways. 0 to disable.")
+	f.IntVar(&l.MaxQueryBytesRead, "querier.max-query-bytes-read", 0, "Maximum number of bytes a single query can read from blocks of an ingester or store-gateway. The number of bytes is estimated from the block statistics before the data is read. This limit is enforced in the ingesters and store-gateways. 0 to disable.")
+	f.IntVar(&l.MaxQueryProfiles, "querier.max-query-profiles", 0, "Maximum number of profiles a single query can merge in an ingester or store-gateway. This limit is enforced in the ingesters and store-gateways. 0 to disable.")
 
 	f.IntVar(&l.MaxProfileSizeBytes, "validation.max-profile-size-bytes", 4*1024*1024, "Maximum size of a profile in bytes. This is based off the uncompressed size. 0 to disable.")
 	f.IntVar(&l.MaxProfileStacktraceSamples, "validation.max-profile-stacktrace-samples", 16000, "Maximum number of samples in a profile. 0 to disable.")
@@ -117,6 +143,14 @@ func (l *Limits) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // Validate validates that this limits config is valid.
 func (l *Limits) Validate() error {
+	if s := l.QuerySplitShards; s < 0 || s&(s-1) != 0 {
+		return errors.Errorf("split_queries_by_series_shards must be a power of two, got %d", s)
+	}
+	for _, name := range l.CostAttributionLabels {
+		if !model.LabelName(name).IsValid() || name == "tenant" {
+			return errors.Errorf("invalid cost attribution label %q", name)
+		}
+	}
 	return nil
 }
 
@@ -231,6 +265,18 @@ func (o *Overrides) MaxGlobalSeriesPerTenant(tenantID string) int {
 	return o.getOverridesForTenant(tenantID).MaxGlobalSeriesPerTenant
 }
 
+// CostAttributionLabels returns the labels the usage of the tenant is
+// attributed to.
+func (o *Overrides) CostAttributionLabels(tenantID string) []string {
+	return o.getOverridesForTenant(tenantID).CostAttributionLabels
+}
+
+// MaxCostAttributionCardinality returns the maximum number of distinct
+// combinations of cost attribution label values of the tenant.
+func (o *Overrides) MaxCostAttributionCardinality(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxCostAttributionCardinality
+}
+
 // MaxQueryLength returns the limit of the length (in time) of a query.
 func (o *Overrides) MaxQueryLength(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).MaxQueryLength)
@@ -257,6 +303,49 @@ func (o *Overrides) QuerySplitDuration(tenantID string) time.Duration {
 	return time.Duration(o.getOverridesForTenant(tenantID).QuerySplitDuration)
 }
 
+// QuerySplitMaxNodes returns the tenant specific max number of nodes
+// in the tree of a split query, applied in the query frontend.
+func (o *Overrides) QuerySplitMaxNodes(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).QuerySplitMaxNodes
+}
+
+// QuerySplitShards returns the tenant specific number of series shards
+// the query frontend splits queries into.
+func (o *Overrides) QuerySplitShards(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).QuerySplitShards
+}
+
+// MaxQuerySeries returns the limit to the number of series a single query
+// can match in an ingester or store-gateway.
+func (o *Overrides) MaxQuerySeries(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxQuerySeries
+}
+
+// MaxQueryBytesRead returns the limit to the estimated number of bytes a
+// single query can read from blocks of an ingester or store-gateway.
+func (o *Overrides) MaxQueryBytesRead(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxQueryBytesRead
+}
+
+// MaxQueryProfiles returns the limit to the number of profiles a single
+// query can merge in an ingester or store-gateway.
+func (o *Overrides) MaxQueryProfiles(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).MaxQueryProfiles
+}
+
+// BatchQueriesLongerThan returns the tenant specific query time range
+// above which queries are scheduled with the batch priority.
+func (o *Overrides) BatchQueriesLongerThan(tenantID string) time.Duration {
+	return time.Duration(o.getOverridesForTenant(tenantID).BatchQueriesLongerThan)
+}
+
+// BatchQueriesSeriesHours returns the tenant specific query cost, in
+// series multiplied by hours, above which queries are scheduled with
+// the batch priority.
+func (o *Overrides) BatchQueriesSeriesHours(tenantID string) int {
+	return o.getOverridesForTenant(tenantID).BatchQueriesSeriesHours
+}
+
 // MaxQueriersPerTenant returns the limit to the number of queriers that can be used
 // Shuffle sharding will be used to distribute queries across queriers.
 // 0 means no limit. Currently disabled.
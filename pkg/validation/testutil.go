@@ -3,13 +3,21 @@ package validation
 import "time"
 
 type MockLimits struct {
-	QuerySplitDurationValue     time.Duration
-	MaxQueryParallelismValue    int
-	MaxQueryLengthValue         time.Duration
-	MaxQueryLookbackValue       time.Duration
-	MaxLabelNameLengthValue     int
-	MaxLabelValueLengthValue    int
-	MaxLabelNamesPerSeriesValue int
+	QuerySplitDurationValue       time.Duration
+	QuerySplitMaxNodesValue       int
+	QuerySplitShardsValue         int
+	BatchQueriesLongerThanValue   time.Duration
+	BatchQueriesSeriesHoursValue  int
+	MaxQueryParallelismValue      int
+	MaxQuerySeriesValue           int
+	MaxQueryBytesReadValue        int
+	MaxQueryProfilesValue         int
+	MaxQueryLengthValue           time.Duration
+	MaxQueryLookbackValue         time.Duration
+	MaxLabelNameLengthValue       int
+	MaxLabelValueLengthValue      int
+	MaxLabelNamesPerSeriesValue   int
+	IngestionTenantShardSizeValue int
 
 	MaxProfileSizeBytesValue              int
 	MaxProfileStacktraceSamplesValue      int
@@ -19,6 +27,8 @@ type MockLimits struct {
 }
 
 func (m MockLimits) QuerySplitDuration(string) time.Duration        { return m.QuerySplitDurationValue }
+func (m MockLimits) QuerySplitMaxNodes(string) int                  { return m.QuerySplitMaxNodesValue }
+func (m MockLimits) QuerySplitShards(string) int                    { return m.QuerySplitShardsValue }
 func (m MockLimits) MaxQueryParallelism(string) int                 { return m.MaxQueryParallelismValue }
 func (m MockLimits) MaxQueryLength(tenantID string) time.Duration   { return m.MaxQueryLengthValue }
 func (m MockLimits) MaxQueryLookback(tenantID string) time.Duration { return m.MaxQueryLookbackValue }
@@ -41,3 +51,15 @@ func (m MockLimits) MaxProfileStacktraceSampleLabels(userID string) int {
 func (m MockLimits) MaxProfileSymbolValueLength(userID string) int {
 	return m.MaxProfileSymbolValueLengthValue
 }
+
+func (m MockLimits) BatchQueriesLongerThan(string) time.Duration {
+	return m.BatchQueriesLongerThanValue
+}
+
+func (m MockLimits) BatchQueriesSeriesHours(string) int { return m.BatchQueriesSeriesHoursValue }
+
+func (m MockLimits) MaxQuerySeries(string) int    { return m.MaxQuerySeriesValue }
+func (m MockLimits) MaxQueryBytesRead(string) int { return m.MaxQueryBytesReadValue }
+func (m MockLimits) MaxQueryProfiles(string) int  { return m.MaxQueryProfilesValue }
+
+func (m MockLimits) IngestionTenantShardSize(string) int { return m.IngestionTenantShardSizeValue }
@@ -48,6 +48,11 @@ const (
 	SamplesLimit      Reason = "samples_limit"
 	ProfileSizeLimit  Reason = "profile_size_limit"
 	SampleLabelsLimit Reason = "sample_labels_limit"
+	// QuerySeriesLimit, QueryBytesLimit, and QueryProfilesLimit are reasons for
+	// rejecting queries exceeding the respective cost limits.
+	QuerySeriesLimit   Reason = "query_series_limit"
+	QueryBytesLimit    Reason = "query_bytes_limit"
+	QueryProfilesLimit Reason = "query_profiles_limit"
 
 	SeriesLimitErrorMsg                = "Maximum active series limit exceeded (%d/%d), reduce the number of active streams (reduce labels or reduce label values), or contact your administrator to see if the limit can be increased"
 	MissingLabelsErrorMsg              = "error at least one label pair is required per profile"
@@ -60,6 +65,9 @@ const (
 	ProfileTooBigErrorMsg              = "the profile with labels '%s' exceeds the size limit (max_profile_size_byte, actual: %d, limit: %d)"
 	ProfileTooManySamplesErrorMsg      = "the profile with labels '%s' exceeds the samples count limit (max_profile_stacktrace_samples, actual: %d, limit: %d)"
 	ProfileTooManySampleLabelsErrorMsg = "the profile with labels '%s' exceeds the sample labels limit (max_profile_stacktrace_sample_labels, actual: %d, limit: %d)"
+	QueryTooManySeriesErrorMsg         = "the query exceeds the series limit (max_query_series, actual: %d, limit: %d), narrow down the label selector"
+	QueryTooManyBytesErrorMsg          = "the query exceeds the estimated bytes to read limit (max_query_bytes_read, actual: %d, limit: %d), narrow down the label selector or the time range"
+	QueryTooManyProfilesErrorMsg       = "the query exceeds the profiles limit (max_query_profiles, actual: %d, limit: %d), narrow down the label selector or the time range"
 )
 
 var (
@@ -82,6 +90,17 @@ var (
 		},
 		[]string{ReasonLabel, "tenant"},
 	)
+
+	// DiscardedQueries is a metric of the number of queries rejected because
+	// of the query cost limits, by reason.
+	DiscardedQueries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "pyroscope",
+			Name:      "discarded_queries_total",
+			Help:      "The total number of queries that were rejected because of the query limits.",
+		},
+		[]string{ReasonLabel, "tenant"},
+	)
 )
 
 type LabelValidationLimits interface {